@@ -0,0 +1,165 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package adcs enrolls the signing certificate of Istio CA with a Microsoft
+// Active Directory Certificate Services CA, through the certsrv web
+// enrollment pages that `certreq` and browsers use. It lets Istio CA run as
+// an intermediate under an enterprise root and renew its own certificate.
+package adcs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	requestTimeout = 30 * time.Second
+
+	// The maximum size of a response of the enrollment pages.
+	maxResponseSize = 1 << 20
+)
+
+var (
+	// The link to the issued certificate on the result page of a request.
+	certLinkPattern = regexp.MustCompile(`certnew\.cer\?ReqID=(\d+)`)
+	// The request ID on the result page of a pending request.
+	pendingPattern = regexp.MustCompile(`(?is)wait for an administrator.*?request id is (\d+)`)
+	// The reason on the result page of a denied request.
+	dispositionPattern = regexp.MustCompile(`(?i)disposition message is "([^"]*)"`)
+)
+
+// Client requests certificates of a certificate template from the web
+// enrollment pages of an ADCS CA, e.g. https://adcs.example.com/certsrv.
+// It authenticates with HTTP basic authentication, which the pages must
+// accept over HTTPS.
+type Client struct {
+	url      string
+	template string
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewClient returns a pointer to a Client of the certsrv pages at the HTTPS
+// URL, which requests certificates of the template, e.g. "SubCA". The server
+// certificate is verified against the PEM-encoded caCert, or the system roots
+// if it is empty.
+func NewClient(certsrvURL, template, username, password string, caCert []byte) (*Client, error) {
+	u, err := url.Parse(certsrvURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid ADCS URL %q: expect an https:// URL", certsrvURL)
+	}
+	if template == "" {
+		return nil, errors.New("no certificate template has been specified")
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(caCert) > 0 {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("the CA certificate of ADCS holds no PEM-encoded certificate")
+		}
+	}
+	return &Client{
+		url:      strings.TrimSuffix(certsrvURL, "/"),
+		template: template,
+		username: username,
+		password: password,
+		client: &http.Client{
+			Timeout:   requestTimeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// Enroll submits the PEM-encoded certificate signing request and returns the
+// PEM-encoded certificate issued for it.
+func (c *Client) Enroll(csrPEM []byte) ([]byte, error) {
+	form := url.Values{
+		"Mode":             {"newreq"},
+		"CertRequest":      {string(csrPEM)},
+		"CertAttrib":       {"CertificateTemplate:" + c.template},
+		"TargetStoreFlags": {"0"},
+		"SaveCert":         {"yes"},
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url+"/certfnsh.asp", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit the certificate request (error: %v)", err)
+	}
+
+	if m := certLinkPattern.FindSubmatch(body); m != nil {
+		return c.certificate(string(m[1]))
+	}
+	// The page of a pending request has a disposition message too.
+	if m := pendingPattern.FindSubmatch(body); m != nil {
+		return nil, fmt.Errorf("the certificate request %s is pending the approval of a certificate manager", m[1])
+	}
+	if m := dispositionPattern.FindSubmatch(body); m != nil {
+		return nil, fmt.Errorf("the certificate request has been denied: %s", m[1])
+	}
+	return nil, errors.New("unexpected response to the certificate request")
+}
+
+// CACertificate returns the PEM-encoded current certificate of the ADCS CA
+// that issues the certificates.
+func (c *Client) CACertificate() ([]byte, error) {
+	return c.certificate("CACert&Renewal=-1")
+}
+
+// certificate downloads the PEM-encoded certificate with the request ID.
+func (c *Client) certificate(reqID string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url+"/certnew.cer?ReqID="+reqID+"&Enc=b64", nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download the certificate (error: %v)", err)
+	}
+	block, _ := pem.Decode(body)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("the downloaded certificate is not PEM-encoded")
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+func (c *Client) do(req *http.Request) ([]byte, error) {
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+}
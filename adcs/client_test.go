@@ -0,0 +1,274 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adcs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newTestCA returns a CA certificate signed by the parent, or a self-signed
+// one if the parent is nil.
+func newTestCA(t *testing.T, name string, parent *testCA) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	issuer, signer := template, key
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// certsrv is a fake of the web enrollment pages of ADCS. It issues
+// certificates of the "SubCA" template with the issuing CA.
+type certsrv struct {
+	issuer *testCA
+
+	mutex sync.Mutex
+	// The disposition of the next request: "issued", "pending" or "denied".
+	disposition string
+	// Whether to issue CA certificates.
+	issueCA bool
+	certs   map[string][]byte
+}
+
+func newCertsrv(issuer *testCA) *certsrv {
+	return &certsrv{issuer: issuer, disposition: "issued", issueCA: true, certs: map[string][]byte{}}
+}
+
+func (s *certsrv) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, password, ok := r.BasicAuth(); !ok || user != "istio" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch r.URL.Path {
+	case "/certsrv/certfnsh.asp":
+		if r.FormValue("Mode") != "newreq" || r.FormValue("CertAttrib") != "CertificateTemplate:SubCA" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reqID := fmt.Sprintf("%d", len(s.certs)+1)
+		switch s.disposition {
+		case "pending":
+			fmt.Fprintf(w, "<p>Your certificate request has been received. However, you must wait for an "+ // nolint: errcheck
+				"administrator to issue the certificate you requested.</p><p>Your Request Id is %s.</p>"+
+				"<p>The disposition message is \"Taken Under Submission\".</p>", reqID)
+			return
+		case "denied":
+			fmt.Fprintf(w, "<p>Your certificate request was denied.</p><p>Your Request Id is %s. "+ // nolint: errcheck
+				"The disposition message is \"Denied by Policy Module\".</p>", reqID)
+			return
+		}
+		block, _ := pem.Decode([]byte(r.FormValue("CertRequest")))
+		if block == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(time.Now().UnixNano()),
+			Subject:               csr.Subject,
+			NotBefore:             time.Now().Add(-time.Minute),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageDigitalSignature,
+			BasicConstraintsValid: true,
+			IsCA:                  s.issueCA,
+		}
+		if s.issueCA {
+			template.KeyUsage |= x509.KeyUsageCertSign
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, s.issuer.cert, csr.PublicKey, s.issuer.key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.certs[reqID] = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		fmt.Fprintf(w, `<a href="certnew.cer?ReqID=%s&amp;Enc=b64">Download certificate</a>`, reqID) // nolint: errcheck
+	case "/certsrv/certnew.cer":
+		if r.FormValue("ReqID") == "CACert" {
+			w.Write(s.issuer.pem) // nolint: errcheck
+			return
+		}
+		cert, ok := s.certs[r.FormValue("ReqID")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(cert) // nolint: errcheck
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *certsrv) setDisposition(disposition string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.disposition = disposition
+}
+
+func newTestCSR(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader,
+		&x509.CertificateRequest{Subject: pkix.Name{CommonName: "Istio CA"}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func TestClient(t *testing.T) {
+	issuer := newTestCA(t, "Issuing CA", nil)
+	srv := newCertsrv(issuer)
+	server := httptest.NewTLSServer(srv)
+	defer server.Close()
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	testCases := map[string]struct {
+		url         string
+		template    string
+		password    string
+		caCert      []byte
+		disposition string
+		expectedErr string
+	}{
+		"Issued": {
+			url:         server.URL + "/certsrv/",
+			template:    "SubCA",
+			password:    "secret",
+			caCert:      caCert,
+			disposition: "issued",
+		},
+		"Pending": {
+			url:         server.URL + "/certsrv",
+			template:    "SubCA",
+			password:    "secret",
+			caCert:      caCert,
+			disposition: "pending",
+			expectedErr: "is pending the approval of a certificate manager",
+		},
+		"Denied": {
+			url:         server.URL + "/certsrv",
+			template:    "SubCA",
+			password:    "secret",
+			caCert:      caCert,
+			disposition: "denied",
+			expectedErr: "the certificate request has been denied: Denied by Policy Module",
+		},
+		"Unauthorized": {
+			url:         server.URL + "/certsrv",
+			template:    "SubCA",
+			password:    "wrong",
+			caCert:      caCert,
+			disposition: "issued",
+			expectedErr: "unexpected status 401 Unauthorized",
+		},
+		"Untrusted server": {
+			url:         server.URL + "/certsrv",
+			template:    "SubCA",
+			password:    "secret",
+			disposition: "issued",
+			expectedErr: "certificate",
+		},
+		"Plain HTTP": {
+			url:         strings.Replace(server.URL, "https://", "http://", 1),
+			template:    "SubCA",
+			expectedErr: "expect an https:// URL",
+		},
+		"No template": {
+			url:         server.URL + "/certsrv",
+			expectedErr: "no certificate template has been specified",
+		},
+	}
+	for id, tc := range testCases {
+		srv.setDisposition(tc.disposition)
+		c, err := NewClient(tc.url, tc.template, "istio", tc.password, tc.caCert)
+		var certPEM []byte
+		if err == nil {
+			certPEM, err = c.Enroll(newTestCSR(t))
+		}
+		if tc.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: expected error %q, got %v", id, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+		cert, err := parseCertificate(certPEM)
+		if err != nil {
+			t.Errorf("%s: failed to parse the issued certificate: %v", id, err)
+			continue
+		}
+		if err := cert.CheckSignatureFrom(issuer.cert); err != nil {
+			t.Errorf("%s: the certificate is not signed by the issuing CA: %v", id, err)
+		}
+
+		caCertPEM, err := c.CACertificate()
+		if err != nil {
+			t.Errorf("%s: failed to download the CA certificate: %v", id, err)
+		} else if string(caCertPEM) != string(issuer.pem) {
+			t.Errorf("%s: unexpected CA certificate %q", id, caCertPEM)
+		}
+	}
+}
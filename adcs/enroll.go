@@ -0,0 +1,208 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adcs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	keySize = 2048
+
+	// The fraction of the lifetime of the signing certificate after which it is renewed.
+	renewalFraction = 2.0 / 3
+
+	retryInterval = time.Minute
+)
+
+var oidExtensionBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+
+// Material is the signing material of Istio CA enrolled with ADCS.
+type Material struct {
+	// Empty if the signing certificate is issued by the root directly.
+	CertChain   []byte
+	SigningCert []byte
+	SigningKey  []byte
+	RootCert    []byte
+}
+
+// SigningMaterialUpdater is a CA whose signing material can be replaced.
+type SigningMaterialUpdater interface {
+	UpdateSigningMaterial(certChain, signingCert, signingKey, rootCert []byte) error
+}
+
+// Enroller obtains the signing material of Istio CA from ADCS, and renews it
+// once renewalFraction of its lifetime has passed.
+type Enroller struct {
+	client   *Client
+	subject  pkix.Name
+	rootCert []byte
+}
+
+// NewEnroller returns a pointer to an Enroller that requests CA certificates
+// for the subject from the client, which must chain to the PEM-encoded root
+// certificate of the ADCS hierarchy.
+func NewEnroller(client *Client, subject pkix.Name, rootCert []byte) *Enroller {
+	return &Enroller{client: client, subject: subject, rootCert: rootCert}
+}
+
+// Enroll generates a signing key and returns it with the certificate ADCS
+// issues for it, verified to chain to the root certificate through the ADCS
+// CA that issues it.
+func (e *Enroller) Enroll() (*Material, error) {
+	key, err := rsa.GenerateKey(rand.Reader, keySize)
+	if err != nil {
+		return nil, err
+	}
+	// Ask for a CA certificate; the template decides what is issued.
+	bc, err := asn1.Marshal(struct {
+		IsCA bool
+	}{true})
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.CertificateRequest{
+		Subject:         e.subject,
+		ExtraExtensions: []pkix.Extension{{Id: oidExtensionBasicConstraints, Critical: true, Value: bc}},
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM, err := e.client.Enroll(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	if err != nil {
+		return nil, err
+	}
+	issuerPEM, err := e.client.CACertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if pub, ok := cert.PublicKey.(*rsa.PublicKey); !ok || pub.N.Cmp(key.N) != 0 || pub.E != key.E {
+		return nil, errors.New("the issued certificate does not certify the generated key")
+	}
+	if !cert.IsCA || cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, fmt.Errorf("the certificate template %q does not issue CA certificates", e.client.template)
+	}
+
+	issuer, err := parseCertificate(issuerPEM)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(e.rootCert) {
+		return nil, errors.New("the root certificate holds no PEM-encoded certificate")
+	}
+	// The chain is empty if the root issues the certificates itself.
+	var chain []byte
+	if !containsCertificate(e.rootCert, issuer) {
+		chain = issuerPEM
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(issuer)
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Intermediates: intermediates,
+		Roots:         roots,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, fmt.Errorf("the issued certificate does not chain to the root certificate (error: %v)", err)
+	}
+
+	return &Material{
+		CertChain:   chain,
+		SigningCert: certPEM,
+		SigningKey:  pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		RootCert:    e.rootCert,
+	}, nil
+}
+
+// Run renews the current signing material of the CA, returned by Enroll,
+// until stopCh is closed. Failed renewals are retried every retryInterval.
+func (e *Enroller) Run(ca SigningMaterialUpdater, current *Material, stopCh <-chan struct{}) {
+	cert, err := parseCertificate(current.SigningCert)
+	if err != nil {
+		glog.Errorf("Failed to parse the signing certificate enrolled with ADCS (error: %v)", err)
+		return
+	}
+	wait := time.Until(renewAt(cert))
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-stopCh:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		wait = retryInterval
+		m, err := e.Enroll()
+		if err != nil {
+			glog.Errorf("Failed to renew the signing certificate with ADCS, which expires at %v (error: %v)",
+				cert.NotAfter, err)
+			continue
+		}
+		if err := ca.UpdateSigningMaterial(m.CertChain, m.SigningCert, m.SigningKey, m.RootCert); err != nil {
+			glog.Errorf("The CA refused the signing material renewed with ADCS (error: %v)", err)
+			continue
+		}
+		// Enroll has parsed the certificate.
+		cert, _ = parseCertificate(m.SigningCert)
+		glog.Infof("Renewed the signing certificate with ADCS, valid until %v", cert.NotAfter)
+		wait = time.Until(renewAt(cert))
+	}
+}
+
+// renewAt returns when the certificate is due for renewal.
+func renewAt(cert *x509.Certificate) time.Time {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotBefore.Add(time.Duration(float64(lifetime) * renewalFraction))
+}
+
+// containsCertificate returns true if the PEM-encoded certificates hold the certificate.
+func containsCertificate(certsPEM []byte, cert *x509.Certificate) bool {
+	for rest := certsPEM; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			return false
+		}
+		if bytes.Equal(block.Bytes, cert.Raw) {
+			return true
+		}
+	}
+}
+
+func parseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, errors.New("invalid PEM encoding for the certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
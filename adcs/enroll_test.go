@@ -0,0 +1,117 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adcs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnroll(t *testing.T) {
+	root := newTestCA(t, "Root CA", nil)
+	issuing := newTestCA(t, "Issuing CA", root)
+	other := newTestCA(t, "Other root CA", nil)
+
+	testCases := map[string]struct {
+		issuer        *testCA
+		rootCert      []byte
+		issueCA       bool
+		expectedChain []byte
+		expectedErr   string
+	}{
+		"Issued by an intermediate": {
+			issuer:        issuing,
+			rootCert:      root.pem,
+			issueCA:       true,
+			expectedChain: issuing.pem,
+		},
+		"Issued by the root": {
+			issuer:   root,
+			rootCert: append(append([]byte{}, other.pem...), root.pem...),
+			issueCA:  true,
+		},
+		"Not a CA certificate": {
+			issuer:      issuing,
+			rootCert:    root.pem,
+			expectedErr: `the certificate template "SubCA" does not issue CA certificates`,
+		},
+		"Untrusted issuer": {
+			issuer:      issuing,
+			rootCert:    other.pem,
+			issueCA:     true,
+			expectedErr: "the issued certificate does not chain to the root certificate",
+		},
+		"Invalid root": {
+			issuer:      issuing,
+			rootCert:    []byte("not a certificate"),
+			issueCA:     true,
+			expectedErr: "the root certificate holds no PEM-encoded certificate",
+		},
+	}
+	for id, tc := range testCases {
+		srv := newCertsrv(tc.issuer)
+		srv.issueCA = tc.issueCA
+		server := httptest.NewTLSServer(srv)
+		c, err := NewClient(server.URL+"/certsrv", "SubCA", "istio", "secret", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.client = server.Client()
+
+		m, err := NewEnroller(c, pkix.Name{CommonName: "Istio CA"}, tc.rootCert).Enroll()
+		server.Close()
+		if tc.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: expected error %q, got %v", id, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+		if !bytes.Equal(m.CertChain, tc.expectedChain) {
+			t.Errorf("%s: unexpected certificate chain %q", id, m.CertChain)
+		}
+		if !bytes.Equal(m.RootCert, tc.rootCert) {
+			t.Errorf("%s: unexpected root certificate %q", id, m.RootCert)
+		}
+		cert, err := parseCertificate(m.SigningCert)
+		if err != nil {
+			t.Errorf("%s: failed to parse the signing certificate: %v", id, err)
+			continue
+		}
+		if cert.Subject.CommonName != "Istio CA" {
+			t.Errorf("%s: unexpected subject %v", id, cert.Subject)
+		}
+		if _, err := tls.X509KeyPair(m.SigningCert, m.SigningKey); err != nil {
+			t.Errorf("%s: the signing key does not match the certificate: %v", id, err)
+		}
+	}
+}
+
+func TestRenewAt(t *testing.T) {
+	notBefore := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := &x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.Add(90 * 24 * time.Hour)}
+	if expected := notBefore.Add(60 * 24 * time.Hour); !renewAt(cert).Equal(expected) {
+		t.Errorf("expected renewal at %v, got %v", expected, renewAt(cert))
+	}
+}
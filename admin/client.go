@@ -0,0 +1,211 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const clientTimeout = 30 * time.Second
+
+// Client calls the admin API of an Istio CA.
+type Client struct {
+	// The base URL of the admin API, e.g. "https://localhost:8061".
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// NewClient returns a pointer to a Client calling the admin API served at the
+// given address with the given admin token. The address is either a
+// "host:port" pair or a base URL. The TLS certificate of the admin API is
+// verified against the roots, e.g. the root certificate of the CA, or against
+// the system roots if nil.
+func NewClient(addr, token string, roots *x509.CertPool) *Client {
+	if !strings.Contains(addr, "://") {
+		addr = "https://" + addr
+	}
+	return &Client{
+		baseURL: strings.TrimSuffix(addr, "/"),
+		token:   token,
+		client: &http.Client{
+			Timeout:   clientTimeout,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+		},
+	}
+}
+
+// ListCertificates returns the unexpired certificates issued by the CA.
+func (c *Client) ListCertificates() ([]Certificate, error) {
+	certs := []Certificate{}
+	err := c.call(http.MethodGet, CertificatesPath, nil, &certs)
+	return certs, err
+}
+
+// Revoke revokes the certificate with the given hex-encoded serial number.
+func (c *Client) Revoke(serial string) error {
+	return c.call(http.MethodPost, RevokePath, url.Values{SerialParam: {serial}}, nil)
+}
+
+// RotateRoot rotates the root certificate of the CA.
+func (c *Client) RotateRoot() error {
+	return c.call(http.MethodPost, RotateRootPath, nil, nil)
+}
+
+// Config returns the effective configuration of the CA.
+func (c *Client) Config() (map[string]string, error) {
+	config := map[string]string{}
+	err := c.call(http.MethodGet, ConfigPath, nil, &config)
+	return config, err
+}
+
+// Status returns the status of the CA.
+func (c *Client) Status() (*Status, error) {
+	st := &Status{}
+	err := c.call(http.MethodGet, StatusPath, nil, st)
+	return st, err
+}
+
+// Inventory returns a page of at most limit managed identities, following the
+// page that returned the given continue token. The first page is returned for
+// an empty token, and the default page size for a limit of zero.
+func (c *Client) Inventory(limit int, cont string) (*InventoryPage, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set(LimitParam, strconv.Itoa(limit))
+	}
+	if cont != "" {
+		query.Set(ContinueParam, cont)
+	}
+	page := &InventoryPage{}
+	err := c.call(http.MethodGet, InventoryPath, query, page)
+	return page, err
+}
+
+// Reissue rotates the secrets in the namespace, or in all namespaces if it is
+// empty, whose certificates expire within the given duration, and returns the
+// identities of the rotated secrets.
+func (c *Client) Reissue(within time.Duration, namespace string) ([]Identity, error) {
+	query := url.Values{ExpiringWithinParam: {within.String()}}
+	if namespace != "" {
+		query.Set(NamespaceParam, namespace)
+	}
+	identities := []Identity{}
+	err := c.call(http.MethodPost, ReissuePath, query, &identities)
+	return identities, err
+}
+
+// RootRolloutStatus returns the progress of the staged root rotation.
+func (c *Client) RootRolloutStatus() (*RootRolloutStatus, error) {
+	return c.rootRollout(http.MethodGet, RootRolloutPath)
+}
+
+// StartRootRollout starts a staged root rotation.
+func (c *Client) StartRootRollout() (*RootRolloutStatus, error) {
+	return c.rootRollout(http.MethodPost, RootRolloutStartPath)
+}
+
+// ResumeRootRollout resumes the paused staged root rotation.
+func (c *Client) ResumeRootRollout() (*RootRolloutStatus, error) {
+	return c.rootRollout(http.MethodPost, RootRolloutResumePath)
+}
+
+// AbortRootRollout aborts the staged root rotation.
+func (c *Client) AbortRootRollout() (*RootRolloutStatus, error) {
+	return c.rootRollout(http.MethodPost, RootRolloutAbortPath)
+}
+
+func (c *Client) rootRollout(method, path string) (*RootRolloutStatus, error) {
+	st := &RootRolloutStatus{}
+	err := c.call(method, path, nil, st)
+	return st, err
+}
+
+// Approvals returns the approval requests and the audit trail of the approval queue.
+func (c *Client) Approvals() (*Approvals, error) {
+	approvals := &Approvals{}
+	err := c.call(http.MethodGet, ApprovalsPath, nil, approvals)
+	return approvals, err
+}
+
+// Approve approves the pending approval request with the given ID on behalf
+// of the approver. The approver may be empty if the token of the client is
+// an approver token, see Server.ApproverTokens.
+func (c *Client) Approve(id, approver string) (*Approvals, error) {
+	return c.decide(ApprovePath, id, approver)
+}
+
+// Deny denies the pending approval request with the given ID on behalf of
+// the approver like Approve.
+func (c *Client) Deny(id, approver string) (*Approvals, error) {
+	return c.decide(DenyPath, id, approver)
+}
+
+func (c *Client) decide(path, id, approver string) (*Approvals, error) {
+	query := url.Values{RequestParam: {id}}
+	if approver != "" {
+		query.Set(ApproverParam, approver)
+	}
+	approvals := &Approvals{}
+	err := c.call(http.MethodPost, path, query, approvals)
+	return approvals, err
+}
+
+// FlushCaches flushes the named caches of the CA, or all of them if none is
+// named, and returns the names of the flushed caches.
+func (c *Client) FlushCaches(names ...string) ([]string, error) {
+	flushed := []string{}
+	err := c.call(http.MethodPost, FlushCachesPath, url.Values{CacheParam: names}, &flushed)
+	return flushed, err
+}
+
+// call sends a request to the admin API and decodes the JSON response into
+// out, unless out is nil.
+func (c *Client) call(method, path string, query url.Values, out interface{}) error {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s failed with status %d: %s", method, path, resp.StatusCode,
+			strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"crypto/x509"
+	"math/big"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager"
+)
+
+func TestClient(t *testing.T) {
+	notBefore := time.Date(2017, time.May, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(time.Hour)
+	ca := &fakeCA{records: []certmanager.IssuanceRecord{
+		{SerialNumber: big.NewInt(0xab), ID: "spiffe://cluster.local/ns/bar/sa/foo", NotBefore: notBefore, NotAfter: notAfter},
+	}}
+	revocations := certmanager.NewRevocationList()
+	s := NewServer(ca, revocations, map[string]string{"namespace": "bar"}, []byte("secret"))
+	s.Inventory = func() []Identity {
+		return []Identity{
+			{ID: "spiffe://cluster.local/ns/bar/sa/foo", Namespace: "bar", SecretName: "istio.foo", NotAfter: notAfter},
+			{ID: "spiffe://cluster.local/ns/bar/sa/baz", Namespace: "bar", SecretName: "istio.baz", NotAfter: notAfter},
+		}
+	}
+	s.Reissue = func(within time.Duration, namespace string) []Identity {
+		return []Identity{{ID: "spiffe://cluster.local/ns/bar/sa/foo", Namespace: namespace, SecretName: "istio.foo",
+			NotAfter: notBefore.Add(within)}}
+	}
+	ts := httptest.NewTLSServer(s.Handler())
+	defer ts.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+
+	if _, err := NewClient(ts.URL, "secret", nil).ListCertificates(); err == nil {
+		t.Error("Calling an admin API whose TLS certificate is not trusted should fail")
+	}
+
+	c := NewClient(ts.URL, "secret", roots)
+
+	certs, err := c.ListCertificates()
+	if err != nil {
+		t.Fatalf("Failed to list certificates: %v", err)
+	}
+	expectedCerts := []Certificate{
+		{SerialNumber: "ab", ID: "spiffe://cluster.local/ns/bar/sa/foo", NotBefore: notBefore, NotAfter: notAfter},
+	}
+	if !reflect.DeepEqual(certs, expectedCerts) {
+		t.Errorf("Unexpected certificates (expecting %v, actual %v)", expectedCerts, certs)
+	}
+
+	if err := c.Revoke("ab"); err != nil {
+		t.Errorf("Failed to revoke certificate: %v", err)
+	}
+	if !revocations.IsRevoked(big.NewInt(0xab)) {
+		t.Error("Certificate is not revoked")
+	}
+	if err := c.Revoke("cd"); err == nil {
+		t.Error("Revoking an unknown certificate should fail")
+	}
+
+	if err := c.RotateRoot(); err != nil || !ca.rotated {
+		t.Errorf("Failed to rotate the root certificate: %v", err)
+	}
+
+	config, err := c.Config()
+	if err != nil || config["namespace"] != "bar" {
+		t.Errorf("Unexpected config %v (error: %v)", config, err)
+	}
+
+	st, err := c.Status()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if st.IssuedCertificates != 1 || st.RevokedCertificates != 1 {
+		t.Errorf("Unexpected status %+v", st)
+	}
+
+	page, err := c.Inventory(1, "")
+	if err != nil || len(page.Identities) != 1 || page.Identities[0].SecretName != "istio.baz" {
+		t.Fatalf("Unexpected first inventory page %+v (error: %v)", page, err)
+	}
+	page, err = c.Inventory(1, page.Continue)
+	if err != nil || len(page.Identities) != 1 || page.Identities[0].SecretName != "istio.foo" || page.Continue != "" {
+		t.Errorf("Unexpected last inventory page %+v (error: %v)", page, err)
+	}
+
+	reissued, err := c.Reissue(time.Hour, "bar")
+	if err != nil || len(reissued) != 1 || reissued[0].Namespace != "bar" || !reissued[0].NotAfter.Equal(notAfter) {
+		t.Errorf("Unexpected reissued identities %+v (error: %v)", reissued, err)
+	}
+
+	registryFlushes := 0
+	s.AddCache("registry", func() error {
+		registryFlushes++
+		return nil
+	})
+	s.AddCache("jwks", func() error { return nil })
+	if flushed, err := c.FlushCaches("registry"); err != nil || len(flushed) != 1 || registryFlushes != 1 {
+		t.Errorf("Unexpected flushed caches %v (error: %v)", flushed, err)
+	}
+	if flushed, err := c.FlushCaches(); err != nil || !reflect.DeepEqual(flushed, []string{"jwks", "registry"}) {
+		t.Errorf("Unexpected flushed caches %v (error: %v)", flushed, err)
+	}
+	if _, err := c.FlushCaches("unknown"); err == nil {
+		t.Error("Flushing an unknown cache should fail")
+	}
+
+	if _, err := c.Approve("ab", "alice"); err == nil {
+		t.Error("Approving without an approval queue should fail")
+	}
+
+	if _, err := NewClient(ts.URL, "wrong", roots).ListCertificates(); err == nil {
+		t.Error("Calling with a wrong token should fail")
+	}
+}
@@ -0,0 +1,575 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package admin provides the administrative API of Istio CA, which is served as
+// JSON over HTTPS and protected by a shared admin token.
+
+package admin
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/auth/certmanager"
+)
+
+// The paths of the admin API.
+const (
+	CertificatesPath = "/v1/certificates"
+	RevokePath       = "/v1/certificates/revoke"
+	RotateRootPath   = "/v1/root/rotate"
+	ConfigPath       = "/v1/config"
+	StatusPath       = "/v1/status"
+	InventoryPath    = "/v1/inventory"
+	ReissuePath      = "/v1/inventory/reissue"
+
+	RootRolloutPath       = "/v1/root/rollout"
+	RootRolloutStartPath  = "/v1/root/rollout/start"
+	RootRolloutResumePath = "/v1/root/rollout/resume"
+	RootRolloutAbortPath  = "/v1/root/rollout/abort"
+
+	ApprovalsPath = "/v1/approvals"
+	ApprovePath   = "/v1/approvals/approve"
+	DenyPath      = "/v1/approvals/deny"
+
+	FlushCachesPath = "/v1/caches/flush"
+
+	// The query parameter of RevokePath holding the hex-encoded serial number.
+	SerialParam = "serial"
+
+	// The query parameters of InventoryPath holding the maximum number of
+	// identities to return, and the continue token of the previous page.
+	LimitParam    = "limit"
+	ContinueParam = "continue"
+
+	// The query parameters of ReissuePath holding the duration within which
+	// the certificates to reissue expire, and the optional namespace of their secrets.
+	ExpiringWithinParam = "expiringWithin"
+	NamespaceParam      = "namespace"
+
+	// The query parameters of ApprovePath and DenyPath holding the ID of the
+	// approval request, and who approves or denies it for the audit trail,
+	// see Server.ApproverTokens.
+	RequestParam  = "request"
+	ApproverParam = "approver"
+
+	// The query parameter of FlushCachesPath naming a cache to flush, which
+	// may be repeated. All the caches are flushed if it is absent.
+	CacheParam = "cache"
+)
+
+const (
+	defaultInventoryLimit = 500
+	maxInventoryLimit     = 5000
+)
+
+// CA is the subset of Istio CA operations exposed by the admin API.
+type CA interface {
+	GetRootCertificate() []byte
+	IssuedCertificates() []certmanager.IssuanceRecord
+	RotateRoot() error
+}
+
+// Certificate describes an issued certificate in admin API responses.
+type Certificate struct {
+	// The hex-encoded serial number.
+	SerialNumber string    `json:"serialNumber"`
+	ID           string    `json:"id"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+	Revoked      bool      `json:"revoked"`
+
+	// The identity that requested the certificate on behalf of ID, if any.
+	RequestedBy string `json:"requestedBy,omitempty"`
+}
+
+// Status summarizes the state of the CA in admin API responses.
+type Status struct {
+	RootNotAfter        time.Time `json:"rootNotAfter"`
+	IssuedCertificates  int       `json:"issuedCertificates"`
+	RevokedCertificates int       `json:"revokedCertificates"`
+}
+
+// RootRollout is a staged root rotation, which re-issues the workload
+// certificates by the new root namespace by namespace.
+type RootRollout interface {
+	Start() error
+	Resume() error
+	Abort() error
+	Status() RootRolloutStatus
+}
+
+// RootRolloutStatus describes the progress of a staged root rotation in admin API responses.
+type RootRolloutStatus struct {
+	// One of "idle", "propagating", "rolling-out", "paused", "completed" and "aborted".
+	Phase string `json:"phase"`
+
+	// The namespace being rolled out, if any.
+	Current string `json:"current,omitempty"`
+
+	// The namespaces whose certificates have been re-issued by the new root.
+	RolledOut []string `json:"rolledOut"`
+
+	// The error that paused or stopped the rollout, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Identity describes a managed workload identity in admin API responses.
+type Identity struct {
+	ID         string    `json:"id"`
+	Namespace  string    `json:"namespace"`
+	SecretName string    `json:"secretName"`
+	NotAfter   time.Time `json:"notAfter"`
+	KeyType    string    `json:"keyType"`
+
+	// The hex-encoded SHA-256 fingerprint of the root certificate the identity is provisioned with.
+	RootFingerprint string `json:"rootFingerprint"`
+}
+
+// InventoryPage is a page of the identity inventory in admin API responses.
+type InventoryPage struct {
+	Identities []Identity `json:"identities"`
+
+	// The token to pass as ContinueParam to get the next page; empty on the last page.
+	Continue string `json:"continue,omitempty"`
+}
+
+// ApprovalRequest describes a request for issuing a certificate for a
+// sensitive identity in admin API responses.
+type ApprovalRequest struct {
+	ID          string `json:"id"`
+	Identity    string `json:"identity"`
+	RequestedBy string `json:"requestedBy,omitempty"`
+	// One of "pending", "approved" and "denied".
+	State     string `json:"state"`
+	DecidedBy string `json:"decidedBy,omitempty"`
+	// How DecidedBy is known, "authenticated" or "claimed".
+	DecisionSource string `json:"decisionSource,omitempty"`
+
+	RequestedAt time.Time `json:"requestedAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// ApprovalEvent describes an entry of the approval audit trail in admin API responses.
+type ApprovalEvent struct {
+	ID       string `json:"id"`
+	Identity string `json:"identity"`
+	// One of "requested", "approved", "denied", "expired" and "issued".
+	Decision string `json:"decision"`
+	By       string `json:"by,omitempty"`
+	// How By is known, "authenticated" or "claimed".
+	Source string    `json:"source,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// Approvals lists the approval requests and the audit trail of the approval
+// queue in admin API responses.
+type Approvals struct {
+	Requests []ApprovalRequest `json:"requests"`
+	Audit    []ApprovalEvent   `json:"audit"`
+}
+
+// Server serves the admin API. Every request must present the admin token as
+// a bearer token in the Authorization header.
+type Server struct {
+	ca          CA
+	revocations *certmanager.RevocationList
+
+	// The effective configuration of the CA, keyed by flag name.
+	config map[string]string
+
+	token []byte
+
+	// If not nil, Inventory returns the managed identities served at InventoryPath.
+	Inventory func() []Identity
+
+	// If not nil, Reissue rotates the secrets of the identities in the
+	// namespace, or in all namespaces if it is empty, whose certificates
+	// expire within the given duration. It returns the rotated identities.
+	Reissue func(within time.Duration, namespace string) []Identity
+
+	// If not nil, the staged root rotation driven at the RootRollout paths.
+	RootRollout RootRollout
+
+	// If not nil, the approval queue served at ApprovalsPath, whose requests
+	// are decided at ApprovePath and DenyPath.
+	Approvals *certmanager.ApprovalQueue
+
+	// If not empty, the tokens of the approvers by name. The approval
+	// requests are then only decided with the token of an approver, who is
+	// recorded as authenticated. Otherwise, they are decided with the admin
+	// token on behalf of the approver named by ApproverParam, who is recorded
+	// as claimed.
+	ApproverTokens map[string][]byte
+
+	// The functions flushing the caches of external state, e.g. the keys of
+	// identity providers, by cache name; see AddCache.
+	cachesMutex sync.Mutex
+	caches      map[string][]func() error
+}
+
+// NewServer returns a pointer to a newly constructed admin Server.
+func NewServer(ca CA, revocations *certmanager.RevocationList, config map[string]string, token []byte) *Server {
+	return &Server{
+		ca:          ca,
+		revocations: revocations,
+		config:      config,
+		token:       token,
+		caches:      map[string][]func() error{},
+	}
+}
+
+// AddCache registers a function flushing a cache at FlushCachesPath under
+// the name, which is shared by the caches flushed together. It may be called
+// while the admin API is served.
+func (s *Server) AddCache(name string, flush func() error) {
+	s.cachesMutex.Lock()
+	defer s.cachesMutex.Unlock()
+	s.caches[name] = append(s.caches[name], flush)
+}
+
+// Handler returns the HTTP handler of the admin API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(CertificatesPath, s.authenticated(http.MethodGet, s.listCertificates))
+	mux.HandleFunc(RevokePath, s.authenticated(http.MethodPost, s.revoke))
+	mux.HandleFunc(RotateRootPath, s.authenticated(http.MethodPost, s.rotateRoot))
+	mux.HandleFunc(ConfigPath, s.authenticated(http.MethodGet, s.dumpConfig))
+	mux.HandleFunc(StatusPath, s.authenticated(http.MethodGet, s.status))
+	mux.HandleFunc(InventoryPath, s.authenticated(http.MethodGet, s.inventory))
+	mux.HandleFunc(ReissuePath, s.authenticated(http.MethodPost, s.reissue))
+	mux.HandleFunc(RootRolloutPath, s.authenticated(http.MethodGet, s.rootRollout(nil)))
+	mux.HandleFunc(RootRolloutStartPath, s.authenticated(http.MethodPost, s.rootRollout(RootRollout.Start)))
+	mux.HandleFunc(RootRolloutResumePath, s.authenticated(http.MethodPost, s.rootRollout(RootRollout.Resume)))
+	mux.HandleFunc(RootRolloutAbortPath, s.authenticated(http.MethodPost, s.rootRollout(RootRollout.Abort)))
+	mux.HandleFunc(ApprovalsPath, s.authenticated(http.MethodGet, s.approvals))
+	mux.HandleFunc(ApprovePath, s.authenticatedApprover(s.decide((*certmanager.ApprovalQueue).Approve)))
+	mux.HandleFunc(DenyPath, s.authenticatedApprover(s.decide((*certmanager.ApprovalQueue).Deny)))
+	mux.HandleFunc(FlushCachesPath, s.authenticated(http.MethodPost, s.flushCaches))
+	return mux
+}
+
+// Run serves the admin API over TLS on the given address, presenting the
+// certificate returned by getCertificate, e.g. one issued by the CA. It only
+// returns on error.
+func (s *Server) Run(addr string, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) error {
+	glog.Infof("Serving the admin API on %s", addr)
+	server := &http.Server{
+		Addr:      addr,
+		Handler:   s.Handler(),
+		TLSConfig: &tls.Config{GetCertificate: getCertificate},
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+func (s *Server) authenticated(method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if len(s.token) == 0 || subtle.ConstantTimeCompare([]byte(token), s.token) != 1 {
+			http.Error(w, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != method {
+			http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// approverHandler handles a request on behalf of the approver of the request.
+type approverHandler func(w http.ResponseWriter, r *http.Request, approver certmanager.Approver)
+
+// authenticatedApprover returns a handler authenticating the approver of a
+// POST request, see ApproverTokens, before passing it to h.
+func (s *Server) authenticatedApprover(h approverHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := []byte(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		claimed := r.URL.Query().Get(ApproverParam)
+		approver := certmanager.Approver{Name: claimed, Source: certmanager.ApproverClaimed}
+		if len(s.ApproverTokens) > 0 {
+			approver = certmanager.Approver{Source: certmanager.ApproverAuthenticated}
+			for name, t := range s.ApproverTokens {
+				if subtle.ConstantTimeCompare(token, t) == 1 {
+					approver.Name = name
+				}
+			}
+			if approver.Name == "" {
+				http.Error(w, "invalid approver token", http.StatusUnauthorized)
+				return
+			}
+			if claimed != "" && claimed != approver.Name {
+				http.Error(w, fmt.Sprintf("the approver token is not the token of %s", claimed), http.StatusForbidden)
+				return
+			}
+		} else if len(s.token) == 0 || subtle.ConstantTimeCompare(token, s.token) != 1 {
+			http.Error(w, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		if approver.Name == "" {
+			http.Error(w, fmt.Sprintf("the %s parameter is required", ApproverParam), http.StatusBadRequest)
+			return
+		}
+		h(w, r, approver)
+	}
+}
+
+func (s *Server) listCertificates(w http.ResponseWriter, r *http.Request) {
+	certs := []Certificate{}
+	for _, rec := range s.ca.IssuedCertificates() {
+		certs = append(certs, Certificate{
+			SerialNumber: fmt.Sprintf("%x", rec.SerialNumber),
+			ID:           rec.ID,
+			NotBefore:    rec.NotBefore,
+			NotAfter:     rec.NotAfter,
+			Revoked:      s.revocations.IsRevoked(rec.SerialNumber),
+			RequestedBy:  rec.RequestedBy,
+		})
+	}
+	writeJSON(w, certs)
+}
+
+func (s *Server) revoke(w http.ResponseWriter, r *http.Request) {
+	param := r.URL.Query().Get(SerialParam)
+	serial, ok := new(big.Int).SetString(param, 16)
+	if !ok {
+		http.Error(w, fmt.Sprintf("invalid serial number %q", param), http.StatusBadRequest)
+		return
+	}
+
+	found := false
+	for _, rec := range s.ca.IssuedCertificates() {
+		if rec.SerialNumber.Cmp(serial) == 0 {
+			found = true
+			break
+		}
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf("no unexpired certificate with serial number %x", serial), http.StatusNotFound)
+		return
+	}
+
+	glog.Infof("Revoking certificate with serial number %x via the admin API", serial)
+	s.revocations.Revoke(serial)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) rotateRoot(w http.ResponseWriter, r *http.Request) {
+	glog.Info("Rotating the root certificate via the admin API")
+	if err := s.ca.RotateRoot(); err != nil {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) dumpConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.config)
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	st := Status{}
+	for _, rec := range s.ca.IssuedCertificates() {
+		st.IssuedCertificates++
+		if s.revocations.IsRevoked(rec.SerialNumber) {
+			st.RevokedCertificates++
+		}
+	}
+	if b, _ := pem.Decode(s.ca.GetRootCertificate()); b != nil {
+		if root, err := x509.ParseCertificate(b.Bytes); err == nil {
+			st.RootNotAfter = root.NotAfter
+		}
+	}
+	writeJSON(w, st)
+}
+
+// inventory serves a page of at most LimitParam identities following the
+// identity named by ContinueParam, which is the "<namespace>/<secret name>" of
+// the last identity of the previous page.
+func (s *Server) inventory(w http.ResponseWriter, r *http.Request) {
+	if s.Inventory == nil {
+		http.Error(w, "the identity inventory is not available", http.StatusNotFound)
+		return
+	}
+
+	limit := defaultInventoryLimit
+	if param := r.URL.Query().Get(LimitParam); param != "" {
+		n, err := strconv.Atoi(param)
+		if err != nil || n <= 0 || n > maxInventoryLimit {
+			http.Error(w, fmt.Sprintf("invalid limit %q (expecting 1 to %d)", param, maxInventoryLimit),
+				http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	identities := s.Inventory()
+	sort.Slice(identities, func(i, j int) bool {
+		return inventoryKey(identities[i]) < inventoryKey(identities[j])
+	})
+	start := 0
+	if cont := r.URL.Query().Get(ContinueParam); cont != "" {
+		start = sort.Search(len(identities), func(i int) bool {
+			return inventoryKey(identities[i]) > cont
+		})
+	}
+	page := InventoryPage{Identities: identities[start:]}
+	if len(page.Identities) > limit {
+		page.Identities = page.Identities[:limit]
+		page.Continue = inventoryKey(page.Identities[limit-1])
+	}
+	writeJSON(w, page)
+}
+
+func (s *Server) reissue(w http.ResponseWriter, r *http.Request) {
+	if s.Reissue == nil {
+		http.Error(w, "reissuing certificates is not available", http.StatusNotFound)
+		return
+	}
+	param := r.URL.Query().Get(ExpiringWithinParam)
+	within, err := time.ParseDuration(param)
+	if err != nil || within <= 0 {
+		http.Error(w, fmt.Sprintf("invalid duration %q", param), http.StatusBadRequest)
+		return
+	}
+	namespace := r.URL.Query().Get(NamespaceParam)
+
+	glog.Infof("Reissuing the certificates expiring within %v in namespace %q via the admin API", within, namespace)
+	writeJSON(w, s.Reissue(within, namespace))
+}
+
+// rootRollout returns a handler applying the action, if not nil, to the
+// staged root rotation and serving its status.
+func (s *Server) rootRollout(action func(RootRollout) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.RootRollout == nil {
+			http.Error(w, "staged root rotation is not available", http.StatusNotFound)
+			return
+		}
+		if action != nil {
+			glog.Infof("Applying %s to the staged root rotation via the admin API", r.URL.Path)
+			if err := action(s.RootRollout); err != nil {
+				http.Error(w, err.Error(), http.StatusPreconditionFailed)
+				return
+			}
+		}
+		writeJSON(w, s.RootRollout.Status())
+	}
+}
+
+// approvals serves the approval queue.
+func (s *Server) approvals(w http.ResponseWriter, r *http.Request) {
+	if s.Approvals == nil {
+		http.Error(w, "the approval queue is not available", http.StatusNotFound)
+		return
+	}
+	approvals := Approvals{Requests: []ApprovalRequest{}, Audit: []ApprovalEvent{}}
+	for _, req := range s.Approvals.Requests() {
+		approvals.Requests = append(approvals.Requests, ApprovalRequest(req))
+	}
+	for _, e := range s.Approvals.Audit() {
+		approvals.Audit = append(approvals.Audit, ApprovalEvent(e))
+	}
+	writeJSON(w, approvals)
+}
+
+// decide returns a handler applying the decision of the approver to the
+// approval request named by RequestParam and serving the approval queue.
+func (s *Server) decide(decide func(*certmanager.ApprovalQueue, string, certmanager.Approver) error) approverHandler {
+	return func(w http.ResponseWriter, r *http.Request, approver certmanager.Approver) {
+		if s.Approvals == nil {
+			http.Error(w, "the approval queue is not available", http.StatusNotFound)
+			return
+		}
+		id := r.URL.Query().Get(RequestParam)
+		glog.Infof("Applying %s to approval request %s on behalf of %s, %s via the admin API", r.URL.Path, id,
+			approver.Name, approver.Source)
+		if err := decide(s.Approvals, id, approver); err != nil {
+			status := http.StatusPreconditionFailed
+			switch err {
+			case certmanager.ErrApprovalNotFound:
+				status = http.StatusNotFound
+			case certmanager.ErrSelfApproval:
+				status = http.StatusForbidden
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		s.approvals(w, r)
+	}
+}
+
+// flushCaches flushes the caches named by CacheParam, or all of them, and
+// serves the names of the flushed caches.
+func (s *Server) flushCaches(w http.ResponseWriter, r *http.Request) {
+	s.cachesMutex.Lock()
+	defer s.cachesMutex.Unlock()
+
+	names := r.URL.Query()[CacheParam]
+	if len(names) == 0 {
+		for name := range s.caches {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := s.caches[name]; !ok {
+			http.Error(w, fmt.Sprintf("unknown cache %q", name), http.StatusNotFound)
+			return
+		}
+	}
+
+	flushed := []string{}
+	for _, name := range names {
+		glog.Infof("Flushing the %s cache via the admin API", name)
+		for _, flush := range s.caches[name] {
+			if err := flush(); err != nil {
+				http.Error(w, fmt.Sprintf("failed to flush the %s cache (error: %v)", name, err),
+					http.StatusInternalServerError)
+				return
+			}
+		}
+		flushed = append(flushed, name)
+	}
+	writeJSON(w, flushed)
+}
+
+func inventoryKey(id Identity) string {
+	return id.Namespace + "/" + id.SecretName
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		glog.Errorf("Failed to write the admin API response (error: %v)", err)
+	}
+}
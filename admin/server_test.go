@@ -0,0 +1,625 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager"
+)
+
+type fakeCA struct {
+	records   []certmanager.IssuanceRecord
+	rotateErr error
+	rotated   bool
+}
+
+func (ca *fakeCA) GetRootCertificate() []byte {
+	return []byte("fake root cert")
+}
+
+func (ca *fakeCA) IssuedCertificates() []certmanager.IssuanceRecord {
+	return ca.records
+}
+
+func (ca *fakeCA) RotateRoot() error {
+	ca.rotated = ca.rotateErr == nil
+	return ca.rotateErr
+}
+
+func TestServer(t *testing.T) {
+	notBefore := time.Date(2017, time.May, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(time.Hour)
+	records := []certmanager.IssuanceRecord{
+		{SerialNumber: big.NewInt(0x1f), ID: "spiffe://cluster.local/ns/bar/sa/foo", NotBefore: notBefore, NotAfter: notAfter,
+			RequestedBy: "spiffe://cluster.local/ns/bar/sa/controller"},
+	}
+
+	testCases := map[string]struct {
+		method         string
+		path           string
+		token          string
+		rotateErr      error
+		expectedStatus int
+		expectedBody   interface{}
+		expectRevoked  bool
+		expectRotated  bool
+	}{
+		"Missing token": {
+			method:         http.MethodGet,
+			path:           CertificatesPath,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"Wrong token": {
+			method:         http.MethodGet,
+			path:           CertificatesPath,
+			token:          "wrong",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"Wrong method": {
+			method:         http.MethodGet,
+			path:           RotateRootPath,
+			token:          "secret",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		"List certificates": {
+			method:         http.MethodGet,
+			path:           CertificatesPath,
+			token:          "secret",
+			expectedStatus: http.StatusOK,
+			expectedBody: &[]Certificate{
+				{SerialNumber: "1f", ID: "spiffe://cluster.local/ns/bar/sa/foo", NotBefore: notBefore, NotAfter: notAfter,
+					RequestedBy: "spiffe://cluster.local/ns/bar/sa/controller"},
+			},
+		},
+		"Revoke a certificate": {
+			method:         http.MethodPost,
+			path:           RevokePath + "?serial=1f",
+			token:          "secret",
+			expectedStatus: http.StatusNoContent,
+			expectRevoked:  true,
+		},
+		"Revoke an unknown certificate": {
+			method:         http.MethodPost,
+			path:           RevokePath + "?serial=2f",
+			token:          "secret",
+			expectedStatus: http.StatusNotFound,
+		},
+		"Revoke with a malformed serial number": {
+			method:         http.MethodPost,
+			path:           RevokePath + "?serial=xyz",
+			token:          "secret",
+			expectedStatus: http.StatusBadRequest,
+		},
+		"Rotate the root certificate": {
+			method:         http.MethodPost,
+			path:           RotateRootPath,
+			token:          "secret",
+			expectedStatus: http.StatusNoContent,
+			expectRotated:  true,
+		},
+		"Rotating the root certificate fails": {
+			method:         http.MethodPost,
+			path:           RotateRootPath,
+			token:          "secret",
+			rotateErr:      errors.New("not supported"),
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		"Dump config": {
+			method:         http.MethodGet,
+			path:           ConfigPath,
+			token:          "secret",
+			expectedStatus: http.StatusOK,
+			expectedBody:   &map[string]string{"cert-ttl": "1h0m0s"},
+		},
+		"Status": {
+			method:         http.MethodGet,
+			path:           StatusPath,
+			token:          "secret",
+			expectedStatus: http.StatusOK,
+			expectedBody:   &Status{IssuedCertificates: 1},
+		},
+	}
+
+	for id, tc := range testCases {
+		ca := &fakeCA{records: records, rotateErr: tc.rotateErr}
+		revocations := certmanager.NewRevocationList()
+		s := NewServer(ca, revocations, map[string]string{"cert-ttl": "1h0m0s"}, []byte("secret"))
+
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+
+		if w.Code != tc.expectedStatus {
+			t.Errorf("%s: unexpected status code (expecting %d, actual %d)", id, tc.expectedStatus, w.Code)
+			continue
+		}
+		if tc.expectedBody != nil {
+			actual := reflect.New(reflect.TypeOf(tc.expectedBody).Elem()).Interface()
+			if err := json.Unmarshal(w.Body.Bytes(), actual); err != nil {
+				t.Errorf("%s: failed to decode the response body: %v", id, err)
+			} else if !reflect.DeepEqual(actual, tc.expectedBody) {
+				t.Errorf("%s: unexpected response body (expecting %v, actual %v)", id, tc.expectedBody, actual)
+			}
+		}
+		if revoked := revocations.IsRevoked(big.NewInt(0x1f)); revoked != tc.expectRevoked {
+			t.Errorf("%s: unexpected revocation state (expecting %v, actual %v)", id, tc.expectRevoked, revoked)
+		}
+		if ca.rotated != tc.expectRotated {
+			t.Errorf("%s: unexpected rotation state (expecting %v, actual %v)", id, tc.expectRotated, ca.rotated)
+		}
+	}
+}
+
+func TestInventory(t *testing.T) {
+	notAfter := time.Date(2017, time.May, 1, 0, 0, 0, 0, time.UTC)
+	identities := []Identity{
+		{ID: "spiffe://cluster.local/ns/foo/sa/b", Namespace: "foo", SecretName: "istio.b", NotAfter: notAfter},
+		{ID: "spiffe://cluster.local/ns/foo-bar/sa/a", Namespace: "foo-bar", SecretName: "istio.a", NotAfter: notAfter},
+		{ID: "spiffe://cluster.local/ns/foo/sa/a", Namespace: "foo", SecretName: "istio.a", NotAfter: notAfter},
+	}
+
+	testCases := map[string]struct {
+		query          string
+		noInventory    bool
+		expectedStatus int
+		expectedPage   InventoryPage
+	}{
+		"All identities fit in the default page": {
+			expectedStatus: http.StatusOK,
+			expectedPage:   InventoryPage{Identities: []Identity{identities[1], identities[2], identities[0]}},
+		},
+		"The first page holds a continue token": {
+			query:          "?limit=2",
+			expectedStatus: http.StatusOK,
+			expectedPage: InventoryPage{
+				Identities: []Identity{identities[1], identities[2]},
+				Continue:   "foo/istio.a",
+			},
+		},
+		"The last page follows the continue token": {
+			query:          "?limit=2&continue=foo/istio.a",
+			expectedStatus: http.StatusOK,
+			expectedPage:   InventoryPage{Identities: []Identity{identities[0]}},
+		},
+		"A page past the end is empty": {
+			query:          "?continue=zzz",
+			expectedStatus: http.StatusOK,
+			expectedPage:   InventoryPage{Identities: []Identity{}},
+		},
+		"Invalid limit": {
+			query:          "?limit=0",
+			expectedStatus: http.StatusBadRequest,
+		},
+		"No inventory": {
+			noInventory:    true,
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for id, tc := range testCases {
+		s := NewServer(&fakeCA{}, certmanager.NewRevocationList(), nil, []byte("secret"))
+		if !tc.noInventory {
+			s.Inventory = func() []Identity { return append([]Identity{}, identities...) }
+		}
+
+		req := httptest.NewRequest(http.MethodGet, InventoryPath+tc.query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+
+		if w.Code != tc.expectedStatus {
+			t.Errorf("%s: unexpected status code (expecting %d, actual %d)", id, tc.expectedStatus, w.Code)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		page := InventoryPage{}
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Errorf("%s: failed to decode the response body: %v", id, err)
+		} else if !reflect.DeepEqual(page, tc.expectedPage) {
+			t.Errorf("%s: unexpected page (expecting %v, actual %v)", id, tc.expectedPage, page)
+		}
+	}
+}
+
+func TestReissue(t *testing.T) {
+	testCases := map[string]struct {
+		query             string
+		noReissue         bool
+		expectedStatus    int
+		expectedWithin    time.Duration
+		expectedNamespace string
+	}{
+		"Reissue in all namespaces": {
+			query:          "?expiringWithin=24h",
+			expectedStatus: http.StatusOK,
+			expectedWithin: 24 * time.Hour,
+		},
+		"Reissue in a namespace": {
+			query:             "?expiringWithin=1h&namespace=foo",
+			expectedStatus:    http.StatusOK,
+			expectedWithin:    time.Hour,
+			expectedNamespace: "foo",
+		},
+		"Missing duration": {
+			expectedStatus: http.StatusBadRequest,
+		},
+		"Negative duration": {
+			query:          "?expiringWithin=-1h",
+			expectedStatus: http.StatusBadRequest,
+		},
+		"No reissue": {
+			query:          "?expiringWithin=24h",
+			noReissue:      true,
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for id, tc := range testCases {
+		var within time.Duration
+		var namespace string
+		s := NewServer(&fakeCA{}, certmanager.NewRevocationList(), nil, []byte("secret"))
+		if !tc.noReissue {
+			s.Reissue = func(w time.Duration, ns string) []Identity {
+				within, namespace = w, ns
+				return []Identity{{Namespace: "foo", SecretName: "istio.foo"}}
+			}
+		}
+
+		req := httptest.NewRequest(http.MethodPost, ReissuePath+tc.query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+
+		if w.Code != tc.expectedStatus {
+			t.Errorf("%s: unexpected status code (expecting %d, actual %d)", id, tc.expectedStatus, w.Code)
+			continue
+		}
+		if within != tc.expectedWithin || namespace != tc.expectedNamespace {
+			t.Errorf("%s: unexpected reissue of %v in namespace %q", id, within, namespace)
+		}
+	}
+}
+
+type fakeRootRollout struct {
+	status RootRolloutStatus
+	err    error
+}
+
+func (r *fakeRootRollout) Start() error {
+	if r.err == nil {
+		r.status.Phase = "propagating"
+	}
+	return r.err
+}
+
+func (r *fakeRootRollout) Resume() error {
+	if r.err == nil {
+		r.status.Phase = "rolling-out"
+	}
+	return r.err
+}
+
+func (r *fakeRootRollout) Abort() error {
+	if r.err == nil {
+		r.status.Phase = "aborted"
+	}
+	return r.err
+}
+
+func (r *fakeRootRollout) Status() RootRolloutStatus {
+	return r.status
+}
+
+func TestRootRollout(t *testing.T) {
+	testCases := map[string]struct {
+		method         string
+		path           string
+		noRollout      bool
+		err            error
+		expectedStatus int
+		expectedPhase  string
+	}{
+		"Status": {
+			method:         http.MethodGet,
+			path:           RootRolloutPath,
+			expectedStatus: http.StatusOK,
+			expectedPhase:  "idle",
+		},
+		"Start": {
+			method:         http.MethodPost,
+			path:           RootRolloutStartPath,
+			expectedStatus: http.StatusOK,
+			expectedPhase:  "propagating",
+		},
+		"Resume": {
+			method:         http.MethodPost,
+			path:           RootRolloutResumePath,
+			expectedStatus: http.StatusOK,
+			expectedPhase:  "rolling-out",
+		},
+		"Abort": {
+			method:         http.MethodPost,
+			path:           RootRolloutAbortPath,
+			expectedStatus: http.StatusOK,
+			expectedPhase:  "aborted",
+		},
+		"Failed action": {
+			method:         http.MethodPost,
+			path:           RootRolloutStartPath,
+			err:            errors.New("a root rotation is already in progress"),
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		"Wrong method": {
+			method:         http.MethodGet,
+			path:           RootRolloutStartPath,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		"No rollout": {
+			method:         http.MethodGet,
+			path:           RootRolloutPath,
+			noRollout:      true,
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for id, tc := range testCases {
+		s := NewServer(&fakeCA{}, certmanager.NewRevocationList(), nil, []byte("secret"))
+		if !tc.noRollout {
+			s.RootRollout = &fakeRootRollout{status: RootRolloutStatus{Phase: "idle"}, err: tc.err}
+		}
+
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+
+		if w.Code != tc.expectedStatus {
+			t.Errorf("%s: unexpected status code (expecting %d, actual %d)", id, tc.expectedStatus, w.Code)
+			continue
+		}
+		if tc.expectedStatus != http.StatusOK {
+			continue
+		}
+		st := RootRolloutStatus{}
+		if err := json.NewDecoder(w.Body).Decode(&st); err != nil {
+			t.Errorf("%s: failed to decode the response body: %v", id, err)
+		} else if st.Phase != tc.expectedPhase {
+			t.Errorf("%s: unexpected phase (expecting %q, actual %q)", id, tc.expectedPhase, st.Phase)
+		}
+	}
+}
+
+func TestApprovals(t *testing.T) {
+	testCases := map[string]struct {
+		method         string
+		path           string
+		request        string
+		approver       string
+		token          string
+		approverTokens map[string][]byte
+		noQueue        bool
+		expectedStatus int
+		expectedState  string
+		expectedSource string
+	}{
+		"List": {
+			method:         http.MethodGet,
+			path:           ApprovalsPath,
+			expectedStatus: http.StatusOK,
+			expectedState:  certmanager.ApprovalPending,
+		},
+		"Approve": {
+			method:         http.MethodPost,
+			path:           ApprovePath,
+			approver:       "alice",
+			expectedStatus: http.StatusOK,
+			expectedState:  certmanager.ApprovalApproved,
+			expectedSource: certmanager.ApproverClaimed,
+		},
+		"Deny": {
+			method:         http.MethodPost,
+			path:           DenyPath,
+			approver:       "alice",
+			expectedStatus: http.StatusOK,
+			expectedState:  certmanager.ApprovalDenied,
+			expectedSource: certmanager.ApproverClaimed,
+		},
+		"Approve with an approver token": {
+			method:         http.MethodPost,
+			path:           ApprovePath,
+			token:          "alice-token",
+			approverTokens: map[string][]byte{"alice": []byte("alice-token"), "bob": []byte("bob-token")},
+			expectedStatus: http.StatusOK,
+			expectedState:  certmanager.ApprovalApproved,
+			expectedSource: certmanager.ApproverAuthenticated,
+		},
+		"Admin token with approver tokens": {
+			method:         http.MethodPost,
+			path:           ApprovePath,
+			approver:       "alice",
+			approverTokens: map[string][]byte{"alice": []byte("alice-token")},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"Token of another approver": {
+			method:         http.MethodPost,
+			path:           DenyPath,
+			approver:       "alice",
+			token:          "bob-token",
+			approverTokens: map[string][]byte{"alice": []byte("alice-token"), "bob": []byte("bob-token")},
+			expectedStatus: http.StatusForbidden,
+		},
+		"Self approval": {
+			method:         http.MethodPost,
+			path:           ApprovePath,
+			approver:       "spiffe://cluster.local/ns/foo/sa/bar",
+			expectedStatus: http.StatusForbidden,
+		},
+		"Unknown request": {
+			method:         http.MethodPost,
+			path:           ApprovePath,
+			request:        "unknown",
+			approver:       "alice",
+			expectedStatus: http.StatusNotFound,
+		},
+		"No approver": {
+			method:         http.MethodPost,
+			path:           ApprovePath,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"No queue": {
+			method:         http.MethodGet,
+			path:           ApprovalsPath,
+			noQueue:        true,
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for id, tc := range testCases {
+		s := NewServer(&fakeCA{}, certmanager.NewRevocationList(), nil, []byte("secret"))
+		s.ApproverTokens = tc.approverTokens
+		request := tc.request
+		if !tc.noQueue {
+			q, err := certmanager.NewApprovalQueue([]string{"spiffe://cluster.local/ns/foo/sa/*"}, time.Hour)
+			if err != nil {
+				t.Fatalf("%s: failed to create the approval queue: %v", id, err)
+			}
+			ca, err := certmanager.NewSelfSignedIstioCA(&certmanager.SelfSignedIstioCAOptions{
+				CACertTTL:     time.Hour,
+				CertTTL:       time.Minute,
+				MaxPathLen:    -1,
+				ApprovalQueue: q,
+			})
+			if err != nil {
+				t.Fatalf("%s: failed to create the CA: %v", id, err)
+			}
+			_, _, err = ca.Generate("bar", "foo")
+			pe, ok := err.(*certmanager.ApprovalPendingError)
+			if !ok {
+				t.Fatalf("%s: expecting the issuance to await approval, but got %v", id, err)
+			}
+			if request == "" {
+				request = pe.ID
+			}
+			s.Approvals = q
+		}
+
+		query := url.Values{RequestParam: {request}, ApproverParam: {tc.approver}}
+		req := httptest.NewRequest(tc.method, tc.path+"?"+query.Encode(), nil)
+		token := tc.token
+		if token == "" {
+			token = "secret"
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+
+		if w.Code != tc.expectedStatus {
+			t.Errorf("%s: unexpected status code (expecting %d, actual %d)", id, tc.expectedStatus, w.Code)
+			continue
+		}
+		if tc.expectedStatus != http.StatusOK {
+			continue
+		}
+		approvals := Approvals{}
+		if err := json.NewDecoder(w.Body).Decode(&approvals); err != nil {
+			t.Errorf("%s: failed to decode the response body: %v", id, err)
+			continue
+		}
+		if len(approvals.Requests) != 1 || approvals.Requests[0].ID != request ||
+			approvals.Requests[0].State != tc.expectedState {
+			t.Errorf("%s: expecting request %s to be %s, but got %v", id, request, tc.expectedState, approvals.Requests)
+		}
+		if tc.expectedSource != "" {
+			last := approvals.Audit[len(approvals.Audit)-1]
+			if last.Decision != tc.expectedState || last.By != "alice" || last.Source != tc.expectedSource {
+				t.Errorf("%s: unexpected audit event %v", id, last)
+			}
+		}
+	}
+}
+
+func TestFlushCaches(t *testing.T) {
+	testCases := map[string]struct {
+		query           string
+		expectedStatus  int
+		expectedFlushed []string
+	}{
+		"Flush all caches": {
+			expectedStatus:  http.StatusOK,
+			expectedFlushed: []string{"jwks", "jwks", "registry", "secrets"},
+		},
+		"Flush a cache": {
+			query:           "?cache=registry",
+			expectedStatus:  http.StatusOK,
+			expectedFlushed: []string{"registry"},
+		},
+		"Flush a shared cache name": {
+			query:           "?cache=jwks&cache=registry",
+			expectedStatus:  http.StatusOK,
+			expectedFlushed: []string{"jwks", "jwks", "registry"},
+		},
+		"Unknown cache": {
+			query:          "?cache=registry&cache=unknown",
+			expectedStatus: http.StatusNotFound,
+		},
+		"Failed flush": {
+			query:          "?cache=secrets&fail=true",
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for id, tc := range testCases {
+		s := NewServer(&fakeCA{}, certmanager.NewRevocationList(), nil, []byte("secret"))
+		flushed := []string{}
+		var flushErr error
+		for _, name := range []string{"jwks", "jwks", "registry", "secrets"} {
+			name := name
+			s.AddCache(name, func() error {
+				flushed = append(flushed, name)
+				return flushErr
+			})
+		}
+
+		req := httptest.NewRequest(http.MethodPost, FlushCachesPath+tc.query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		if req.URL.Query().Get("fail") != "" {
+			flushErr = errors.New("relist failed")
+		}
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+
+		if w.Code != tc.expectedStatus {
+			t.Errorf("%s: unexpected status code (expecting %d, actual %d)", id, tc.expectedStatus, w.Code)
+			continue
+		}
+		if tc.expectedStatus == http.StatusOK && !reflect.DeepEqual(flushed, tc.expectedFlushed) {
+			t.Errorf("%s: unexpected flushes (expecting %v, actual %v)", id, tc.expectedFlushed, flushed)
+		}
+	}
+}
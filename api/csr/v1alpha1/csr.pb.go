@@ -0,0 +1,689 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: api/csr/v1alpha1/csr.proto
+
+/*
+Package v1alpha1 is a generated protocol buffer package.
+
+The v1alpha1 CSR API of Istio CA. csr.pb.go is generated from this file
+with protoc-gen-go and its grpc plugin, and checked in:
+
+	protoc --go_out=plugins=grpc:. api/csr/v1alpha1/csr.proto
+
+Compatibility rules, so that deployed callers keep working as the API
+evolves within v1alpha1:
+
+  - Fields are only ever added. Existing fields are never renumbered,
+    retyped or reused; removed fields have their numbers reserved.
+  - The zero value of every added field requests the behavior from before
+    the field existed, e.g. an empty credential_type selects the only
+    configured authenticator.
+  - Servers ignore unknown fields, so callers may send fields newer than
+    the server understands; such fields must not be required for the
+    request to be authorized safely.
+
+Breaking changes go into a new package, e.g. istio.auth.csr.v1beta1,
+served alongside this one.
+
+It is generated from these files:
+
+	api/csr/v1alpha1/csr.proto
+
+It has these top-level messages:
+
+	SignRequest
+	SignResponse
+	BatchSignRequest
+	BatchSignResponse
+	SignResult
+	ErrorDetail
+	GetNonceRequest
+	GetNonceResponse
+	WatchTrustBundleRequest
+	TrustBundle
+*/
+package v1alpha1
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+// ErrorCode is the cause of a failed request. Callers branch on it rather
+// than on the status messages, which may change. Codes are only ever added;
+// callers treat unknown codes like ERROR_CODE_UNSPECIFIED.
+type ErrorCode int32
+
+const (
+	// The cause is unknown, e.g. the server predates the error codes.
+	ErrorCode_ERROR_CODE_UNSPECIFIED ErrorCode = 0
+	// The credential is invalid, expired or of an unsupported type.
+	ErrorCode_UNAUTHENTICATED ErrorCode = 1
+	// The caller may not request a certificate for the identity or the DNS
+	// names of the certificate signing request.
+	ErrorCode_UNAUTHORIZED_IDENTITY ErrorCode = 2
+	// The certificate signing request is malformed, not signed by its key, or
+	// holds an invalid nonce; or the batch is empty or too large.
+	ErrorCode_INVALID_CSR ErrorCode = 3
+	// The caller has sent too many requests; it should back off and retry.
+	ErrorCode_RATE_LIMITED ErrorCode = 5
+	// The CA cannot sign for now, e.g. its signing key or the identity
+	// registry cannot be reached; the caller should back off and retry.
+	ErrorCode_BACKEND_UNAVAILABLE ErrorCode = 6
+	// The issuance for the identity awaits manual approval by an operator. The
+	// caller may retry once it is approved, which may take hours.
+	ErrorCode_APPROVAL_PENDING ErrorCode = 7
+	// The CA refuses to sign as its clock has been rolled back or is skewed
+	// too much, until an operator corrects it.
+	ErrorCode_CLOCK_UNTRUSTED ErrorCode = 8
+)
+
+var ErrorCode_name = map[int32]string{
+	0: "ERROR_CODE_UNSPECIFIED",
+	1: "UNAUTHENTICATED",
+	2: "UNAUTHORIZED_IDENTITY",
+	3: "INVALID_CSR",
+	5: "RATE_LIMITED",
+	6: "BACKEND_UNAVAILABLE",
+	7: "APPROVAL_PENDING",
+	8: "CLOCK_UNTRUSTED",
+}
+var ErrorCode_value = map[string]int32{
+	"ERROR_CODE_UNSPECIFIED": 0,
+	"UNAUTHENTICATED":        1,
+	"UNAUTHORIZED_IDENTITY":  2,
+	"INVALID_CSR":            3,
+	"RATE_LIMITED":           5,
+	"BACKEND_UNAVAILABLE":    6,
+	"APPROVAL_PENDING":       7,
+	"CLOCK_UNTRUSTED":        8,
+}
+
+func (x ErrorCode) String() string {
+	return proto.EnumName(ErrorCode_name, int32(x))
+}
+func (ErrorCode) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+type SignRequest struct {
+	// The PEM-encoded certificate signing request. Its only subject alternative
+	// name must be the SPIFFE URI of the identity that the credential maps to,
+	// or of an identity that the delegation policy of the server allows the
+	// caller to request certificates on behalf of. Besides, it may ask for the
+	// DNS names that the server authorizes for the identity, e.g. the stable
+	// DNS name of a StatefulSet pod. The CSR must be signed by
+	// the key it requests the certificate for. A nonce from GetNonce is
+	// presented as a CSR extension with the OID 1.3.6.1.5.5.7.7.7
+	// (id-cmc-recipientNonce), whose value is the nonce as a DER OCTET STRING.
+	CsrPem []byte `protobuf:"bytes,1,opt,name=csr_pem,json=csrPem,proto3" json:"csr_pem,omitempty"`
+	// The credential authenticating the caller, e.g. a GCP ID token, an Azure
+	// AD access token, the token of a Kubernetes service account or an ID
+	// token of an OpenID Connect provider.
+	Credential string `protobuf:"bytes,2,opt,name=credential" json:"credential,omitempty"`
+	// The type of the credential, "gcp", "azure", "kubernetes" or "oidc". It
+	// may be empty if the server accepts a single type of credential.
+	CredentialType string `protobuf:"bytes,3,opt,name=credential_type,json=credentialType" json:"credential_type,omitempty"`
+}
+
+func (m *SignRequest) Reset()                    { *m = SignRequest{} }
+func (m *SignRequest) String() string            { return proto.CompactTextString(m) }
+func (*SignRequest) ProtoMessage()               {}
+func (*SignRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+func (m *SignRequest) GetCsrPem() []byte {
+	if m != nil {
+		return m.CsrPem
+	}
+	return nil
+}
+
+func (m *SignRequest) GetCredential() string {
+	if m != nil {
+		return m.Credential
+	}
+	return ""
+}
+
+func (m *SignRequest) GetCredentialType() string {
+	if m != nil {
+		return m.CredentialType
+	}
+	return ""
+}
+
+type SignResponse struct {
+	// The PEM-encoded certificate chain, leaf first.
+	CertChain []byte `protobuf:"bytes,1,opt,name=cert_chain,json=certChain,proto3" json:"cert_chain,omitempty"`
+	// The PEM-encoded root certificates to verify the chain and the peers with.
+	RootCert []byte `protobuf:"bytes,2,opt,name=root_cert,json=rootCert,proto3" json:"root_cert,omitempty"`
+	// The time in Unix seconds after which the caller should renew the
+	// certificate. The server staggers it across callers so that certificates
+	// issued in bulk are not renewed all at once. Zero if the server predates
+	// the field, in which case the caller picks the time itself.
+	RenewAfter int64 `protobuf:"varint,3,opt,name=renew_after,json=renewAfter" json:"renew_after,omitempty"`
+}
+
+func (m *SignResponse) Reset()                    { *m = SignResponse{} }
+func (m *SignResponse) String() string            { return proto.CompactTextString(m) }
+func (*SignResponse) ProtoMessage()               {}
+func (*SignResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *SignResponse) GetCertChain() []byte {
+	if m != nil {
+		return m.CertChain
+	}
+	return nil
+}
+
+func (m *SignResponse) GetRootCert() []byte {
+	if m != nil {
+		return m.RootCert
+	}
+	return nil
+}
+
+func (m *SignResponse) GetRenewAfter() int64 {
+	if m != nil {
+		return m.RenewAfter
+	}
+	return 0
+}
+
+type BatchSignRequest struct {
+	// The PEM-encoded certificate signing requests, each of them like the
+	// csr_pem of a SignRequest. A batch has at most 64 requests.
+	CsrPems [][]byte `protobuf:"bytes,1,rep,name=csr_pems,json=csrPems,proto3" json:"csr_pems,omitempty"`
+	// The credential authenticating the caller, as in a SignRequest.
+	Credential string `protobuf:"bytes,2,opt,name=credential" json:"credential,omitempty"`
+	// The type of the credential, as in a SignRequest.
+	CredentialType string `protobuf:"bytes,3,opt,name=credential_type,json=credentialType" json:"credential_type,omitempty"`
+}
+
+func (m *BatchSignRequest) Reset()                    { *m = BatchSignRequest{} }
+func (m *BatchSignRequest) String() string            { return proto.CompactTextString(m) }
+func (*BatchSignRequest) ProtoMessage()               {}
+func (*BatchSignRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *BatchSignRequest) GetCsrPems() [][]byte {
+	if m != nil {
+		return m.CsrPems
+	}
+	return nil
+}
+
+func (m *BatchSignRequest) GetCredential() string {
+	if m != nil {
+		return m.Credential
+	}
+	return ""
+}
+
+func (m *BatchSignRequest) GetCredentialType() string {
+	if m != nil {
+		return m.CredentialType
+	}
+	return ""
+}
+
+type BatchSignResponse struct {
+	// The results of the certificate signing requests, in the order of the request.
+	Results []*SignResult `protobuf:"bytes,1,rep,name=results" json:"results,omitempty"`
+	// The PEM-encoded root certificates to verify the chains and the peers with.
+	RootCert []byte `protobuf:"bytes,2,opt,name=root_cert,json=rootCert,proto3" json:"root_cert,omitempty"`
+}
+
+func (m *BatchSignResponse) Reset()                    { *m = BatchSignResponse{} }
+func (m *BatchSignResponse) String() string            { return proto.CompactTextString(m) }
+func (*BatchSignResponse) ProtoMessage()               {}
+func (*BatchSignResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *BatchSignResponse) GetResults() []*SignResult {
+	if m != nil {
+		return m.Results
+	}
+	return nil
+}
+
+func (m *BatchSignResponse) GetRootCert() []byte {
+	if m != nil {
+		return m.RootCert
+	}
+	return nil
+}
+
+type SignResult struct {
+	// The PEM-encoded certificate chain, leaf first, if the request is signed.
+	CertChain []byte `protobuf:"bytes,1,opt,name=cert_chain,json=certChain,proto3" json:"cert_chain,omitempty"`
+	// The gRPC status code of the request, OK (0) if it is signed.
+	Code int32 `protobuf:"varint,2,opt,name=code" json:"code,omitempty"`
+	// The reason that the request is not signed.
+	Message string `protobuf:"bytes,3,opt,name=message" json:"message,omitempty"`
+	// The cause of the failure, if the request is not signed.
+	ErrorCode ErrorCode `protobuf:"varint,4,opt,name=error_code,json=errorCode,enum=istio.auth.csr.v1alpha1.ErrorCode" json:"error_code,omitempty"`
+	// The time in Unix seconds after which the caller should renew the
+	// certificate, as in a SignResponse, if the request is signed.
+	RenewAfter int64 `protobuf:"varint,5,opt,name=renew_after,json=renewAfter" json:"renew_after,omitempty"`
+}
+
+func (m *SignResult) Reset()                    { *m = SignResult{} }
+func (m *SignResult) String() string            { return proto.CompactTextString(m) }
+func (*SignResult) ProtoMessage()               {}
+func (*SignResult) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *SignResult) GetCertChain() []byte {
+	if m != nil {
+		return m.CertChain
+	}
+	return nil
+}
+
+func (m *SignResult) GetCode() int32 {
+	if m != nil {
+		return m.Code
+	}
+	return 0
+}
+
+func (m *SignResult) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *SignResult) GetErrorCode() ErrorCode {
+	if m != nil {
+		return m.ErrorCode
+	}
+	return ErrorCode_ERROR_CODE_UNSPECIFIED
+}
+
+func (m *SignResult) GetRenewAfter() int64 {
+	if m != nil {
+		return m.RenewAfter
+	}
+	return 0
+}
+
+// ErrorDetail is attached to the details of the gRPC status of every failed
+// call, and carries the cause of the failure.
+type ErrorDetail struct {
+	Code ErrorCode `protobuf:"varint,1,opt,name=code,enum=istio.auth.csr.v1alpha1.ErrorCode" json:"code,omitempty"`
+}
+
+func (m *ErrorDetail) Reset()                    { *m = ErrorDetail{} }
+func (m *ErrorDetail) String() string            { return proto.CompactTextString(m) }
+func (*ErrorDetail) ProtoMessage()               {}
+func (*ErrorDetail) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *ErrorDetail) GetCode() ErrorCode {
+	if m != nil {
+		return m.Code
+	}
+	return ErrorCode_ERROR_CODE_UNSPECIFIED
+}
+
+type GetNonceRequest struct {
+}
+
+func (m *GetNonceRequest) Reset()                    { *m = GetNonceRequest{} }
+func (m *GetNonceRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetNonceRequest) ProtoMessage()               {}
+func (*GetNonceRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+type GetNonceResponse struct {
+	Nonce []byte `protobuf:"bytes,1,opt,name=nonce,proto3" json:"nonce,omitempty"`
+}
+
+func (m *GetNonceResponse) Reset()                    { *m = GetNonceResponse{} }
+func (m *GetNonceResponse) String() string            { return proto.CompactTextString(m) }
+func (*GetNonceResponse) ProtoMessage()               {}
+func (*GetNonceResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *GetNonceResponse) GetNonce() []byte {
+	if m != nil {
+		return m.Nonce
+	}
+	return nil
+}
+
+type WatchTrustBundleRequest struct {
+}
+
+func (m *WatchTrustBundleRequest) Reset()                    { *m = WatchTrustBundleRequest{} }
+func (m *WatchTrustBundleRequest) String() string            { return proto.CompactTextString(m) }
+func (*WatchTrustBundleRequest) ProtoMessage()               {}
+func (*WatchTrustBundleRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+type TrustBundle struct {
+	// The PEM-encoded root certificates to verify the certificates of the mesh with.
+	RootCert []byte `protobuf:"bytes,1,opt,name=root_cert,json=rootCert,proto3" json:"root_cert,omitempty"`
+}
+
+func (m *TrustBundle) Reset()                    { *m = TrustBundle{} }
+func (m *TrustBundle) String() string            { return proto.CompactTextString(m) }
+func (*TrustBundle) ProtoMessage()               {}
+func (*TrustBundle) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *TrustBundle) GetRootCert() []byte {
+	if m != nil {
+		return m.RootCert
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*SignRequest)(nil), "istio.auth.csr.v1alpha1.SignRequest")
+	proto.RegisterType((*SignResponse)(nil), "istio.auth.csr.v1alpha1.SignResponse")
+	proto.RegisterType((*BatchSignRequest)(nil), "istio.auth.csr.v1alpha1.BatchSignRequest")
+	proto.RegisterType((*BatchSignResponse)(nil), "istio.auth.csr.v1alpha1.BatchSignResponse")
+	proto.RegisterType((*SignResult)(nil), "istio.auth.csr.v1alpha1.SignResult")
+	proto.RegisterType((*ErrorDetail)(nil), "istio.auth.csr.v1alpha1.ErrorDetail")
+	proto.RegisterType((*GetNonceRequest)(nil), "istio.auth.csr.v1alpha1.GetNonceRequest")
+	proto.RegisterType((*GetNonceResponse)(nil), "istio.auth.csr.v1alpha1.GetNonceResponse")
+	proto.RegisterType((*WatchTrustBundleRequest)(nil), "istio.auth.csr.v1alpha1.WatchTrustBundleRequest")
+	proto.RegisterType((*TrustBundle)(nil), "istio.auth.csr.v1alpha1.TrustBundle")
+	proto.RegisterEnum("istio.auth.csr.v1alpha1.ErrorCode", ErrorCode_name, ErrorCode_value)
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for CertificateService service
+
+type CertificateServiceClient interface {
+	// Sign returns a certificate chain for the public key in the certificate
+	// signing request, certifying the Istio identity that the credential maps to.
+	Sign(ctx context.Context, in *SignRequest, opts ...grpc.CallOption) (*SignResponse, error)
+	// BatchSign signs several certificate signing requests of the same caller,
+	// authenticating the credential once. Each request succeeds or fails on its
+	// own; the call only fails as a whole if the caller is not authenticated or
+	// the batch is empty or too large.
+	BatchSign(ctx context.Context, in *BatchSignRequest, opts ...grpc.CallOption) (*BatchSignResponse, error)
+	// GetNonce returns a nonce to embed in certificate signing requests, valid
+	// for a few minutes. Since the CSR is signed by the workload key, the nonce
+	// shows that the request was made recently by the holder of the key, rather
+	// than replayed. Servers may require a nonce in every request.
+	GetNonce(ctx context.Context, in *GetNonceRequest, opts ...grpc.CallOption) (*GetNonceResponse, error)
+	// WatchTrustBundle streams the trust bundle, first the current one and then
+	// every change, e.g. on root rotation, until the call is cancelled. The
+	// trust bundle is public, so the call needs no credential.
+	WatchTrustBundle(ctx context.Context, in *WatchTrustBundleRequest, opts ...grpc.CallOption) (CertificateService_WatchTrustBundleClient, error)
+}
+
+type certificateServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewCertificateServiceClient(cc *grpc.ClientConn) CertificateServiceClient {
+	return &certificateServiceClient{cc}
+}
+
+func (c *certificateServiceClient) Sign(ctx context.Context, in *SignRequest, opts ...grpc.CallOption) (*SignResponse, error) {
+	out := new(SignResponse)
+	err := grpc.Invoke(ctx, "/istio.auth.csr.v1alpha1.CertificateService/Sign", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *certificateServiceClient) BatchSign(ctx context.Context, in *BatchSignRequest, opts ...grpc.CallOption) (*BatchSignResponse, error) {
+	out := new(BatchSignResponse)
+	err := grpc.Invoke(ctx, "/istio.auth.csr.v1alpha1.CertificateService/BatchSign", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *certificateServiceClient) GetNonce(ctx context.Context, in *GetNonceRequest, opts ...grpc.CallOption) (*GetNonceResponse, error) {
+	out := new(GetNonceResponse)
+	err := grpc.Invoke(ctx, "/istio.auth.csr.v1alpha1.CertificateService/GetNonce", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *certificateServiceClient) WatchTrustBundle(ctx context.Context, in *WatchTrustBundleRequest, opts ...grpc.CallOption) (CertificateService_WatchTrustBundleClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_CertificateService_serviceDesc.Streams[0], c.cc, "/istio.auth.csr.v1alpha1.CertificateService/WatchTrustBundle", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &certificateServiceWatchTrustBundleClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CertificateService_WatchTrustBundleClient interface {
+	Recv() (*TrustBundle, error)
+	grpc.ClientStream
+}
+
+type certificateServiceWatchTrustBundleClient struct {
+	grpc.ClientStream
+}
+
+func (x *certificateServiceWatchTrustBundleClient) Recv() (*TrustBundle, error) {
+	m := new(TrustBundle)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Server API for CertificateService service
+
+type CertificateServiceServer interface {
+	// Sign returns a certificate chain for the public key in the certificate
+	// signing request, certifying the Istio identity that the credential maps to.
+	Sign(context.Context, *SignRequest) (*SignResponse, error)
+	// BatchSign signs several certificate signing requests of the same caller,
+	// authenticating the credential once. Each request succeeds or fails on its
+	// own; the call only fails as a whole if the caller is not authenticated or
+	// the batch is empty or too large.
+	BatchSign(context.Context, *BatchSignRequest) (*BatchSignResponse, error)
+	// GetNonce returns a nonce to embed in certificate signing requests, valid
+	// for a few minutes. Since the CSR is signed by the workload key, the nonce
+	// shows that the request was made recently by the holder of the key, rather
+	// than replayed. Servers may require a nonce in every request.
+	GetNonce(context.Context, *GetNonceRequest) (*GetNonceResponse, error)
+	// WatchTrustBundle streams the trust bundle, first the current one and then
+	// every change, e.g. on root rotation, until the call is cancelled. The
+	// trust bundle is public, so the call needs no credential.
+	WatchTrustBundle(*WatchTrustBundleRequest, CertificateService_WatchTrustBundleServer) error
+}
+
+func RegisterCertificateServiceServer(s *grpc.Server, srv CertificateServiceServer) {
+	s.RegisterService(&_CertificateService_serviceDesc, srv)
+}
+
+func _CertificateService_Sign_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SignRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertificateServiceServer).Sign(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/istio.auth.csr.v1alpha1.CertificateService/Sign",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertificateServiceServer).Sign(ctx, req.(*SignRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CertificateService_BatchSign_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchSignRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertificateServiceServer).BatchSign(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/istio.auth.csr.v1alpha1.CertificateService/BatchSign",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertificateServiceServer).BatchSign(ctx, req.(*BatchSignRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CertificateService_GetNonce_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetNonceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertificateServiceServer).GetNonce(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/istio.auth.csr.v1alpha1.CertificateService/GetNonce",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertificateServiceServer).GetNonce(ctx, req.(*GetNonceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CertificateService_WatchTrustBundle_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchTrustBundleRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CertificateServiceServer).WatchTrustBundle(m, &certificateServiceWatchTrustBundleServer{stream})
+}
+
+type CertificateService_WatchTrustBundleServer interface {
+	Send(*TrustBundle) error
+	grpc.ServerStream
+}
+
+type certificateServiceWatchTrustBundleServer struct {
+	grpc.ServerStream
+}
+
+func (x *certificateServiceWatchTrustBundleServer) Send(m *TrustBundle) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _CertificateService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "istio.auth.csr.v1alpha1.CertificateService",
+	HandlerType: (*CertificateServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Sign",
+			Handler:    _CertificateService_Sign_Handler,
+		},
+		{
+			MethodName: "BatchSign",
+			Handler:    _CertificateService_BatchSign_Handler,
+		},
+		{
+			MethodName: "GetNonce",
+			Handler:    _CertificateService_GetNonce_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchTrustBundle",
+			Handler:       _CertificateService_WatchTrustBundle_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "api/csr/v1alpha1/csr.proto",
+}
+
+func init() { proto.RegisterFile("api/csr/v1alpha1/csr.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 690 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x54, 0x5f, 0x73, 0xd2, 0x4e,
+	0x14, 0xfd, 0xa5, 0x40, 0x81, 0x0b, 0x53, 0xd2, 0x6d, 0x7f, 0x42, 0x71, 0x54, 0x26, 0xea, 0x48,
+	0x79, 0xa0, 0x7f, 0x9c, 0xf1, 0xcd, 0x87, 0x90, 0xc4, 0x9a, 0x69, 0x4c, 0x98, 0x25, 0xd4, 0xb1,
+	0x2f, 0x3b, 0x69, 0xd8, 0x96, 0x8c, 0x34, 0xc1, 0xcd, 0x52, 0xa7, 0xdf, 0xc5, 0xaf, 0xe2, 0x97,
+	0xf0, 0x13, 0x39, 0x1b, 0x42, 0xa1, 0x38, 0xb1, 0x7d, 0xf0, 0x2d, 0x7b, 0x72, 0xce, 0x9e, 0x7b,
+	0xef, 0xb9, 0x09, 0x34, 0xbd, 0x69, 0x70, 0xe0, 0xc7, 0xec, 0xe0, 0xe6, 0xc8, 0x9b, 0x4c, 0xc7,
+	0xde, 0x91, 0x38, 0x74, 0xa7, 0x2c, 0xe2, 0x11, 0xaa, 0x07, 0x31, 0x0f, 0xa2, 0xae, 0x37, 0xe3,
+	0xe3, 0xae, 0x40, 0x17, 0x14, 0x25, 0x82, 0xca, 0x20, 0xb8, 0x0a, 0x31, 0xfd, 0x36, 0xa3, 0x31,
+	0x47, 0x75, 0x28, 0xfa, 0x31, 0x23, 0x53, 0x7a, 0xdd, 0x90, 0x5a, 0x52, 0xbb, 0x8a, 0x37, 0xfd,
+	0x98, 0xf5, 0xe9, 0x35, 0x7a, 0x0e, 0xe0, 0x33, 0x3a, 0xa2, 0x21, 0x0f, 0xbc, 0x49, 0x63, 0xa3,
+	0x25, 0xb5, 0xcb, 0x78, 0x05, 0x41, 0x6f, 0xa0, 0xb6, 0x3c, 0x11, 0x7e, 0x3b, 0xa5, 0x8d, 0x5c,
+	0x42, 0xda, 0x5a, 0xc2, 0xee, 0xed, 0x94, 0x2a, 0x5f, 0xa1, 0x3a, 0x37, 0x8c, 0xa7, 0x51, 0x18,
+	0x53, 0xf4, 0x0c, 0xc0, 0xa7, 0x8c, 0x13, 0x7f, 0xec, 0x05, 0x61, 0x6a, 0x5a, 0x16, 0x88, 0x26,
+	0x00, 0xf4, 0x14, 0xca, 0x2c, 0x8a, 0x38, 0x11, 0x48, 0x62, 0x5b, 0xc5, 0x25, 0x01, 0x68, 0x94,
+	0x71, 0xf4, 0x02, 0x2a, 0x8c, 0x86, 0xf4, 0x3b, 0xf1, 0x2e, 0x39, 0x65, 0x89, 0x61, 0x0e, 0x43,
+	0x02, 0xa9, 0x02, 0x51, 0x6e, 0x40, 0xee, 0x79, 0xdc, 0x1f, 0xaf, 0xb6, 0xb8, 0x07, 0xa5, 0xb4,
+	0xc5, 0xb8, 0x21, 0xb5, 0x72, 0xed, 0x2a, 0x2e, 0xce, 0x7b, 0x8c, 0xff, 0x5d, 0x93, 0x11, 0x6c,
+	0xaf, 0xf8, 0xa6, 0x9d, 0xbe, 0x87, 0x22, 0xa3, 0xf1, 0x6c, 0xc2, 0xe7, 0xbe, 0x95, 0xe3, 0x97,
+	0xdd, 0x8c, 0x54, 0xba, 0xa9, 0x6e, 0x36, 0xe1, 0x78, 0xa1, 0xf9, 0xeb, 0x24, 0x94, 0x9f, 0x12,
+	0xc0, 0x52, 0xf4, 0xd0, 0x50, 0x11, 0xe4, 0xfd, 0x68, 0x44, 0x93, 0x5b, 0x0a, 0x38, 0x79, 0x46,
+	0x0d, 0x28, 0x5e, 0xd3, 0x38, 0xf6, 0xae, 0x16, 0x3d, 0x2d, 0x8e, 0x48, 0x05, 0xa0, 0x8c, 0x45,
+	0x8c, 0x24, 0x9a, 0x7c, 0x4b, 0x6a, 0x6f, 0x1d, 0x2b, 0x99, 0xa5, 0x1b, 0x82, 0xaa, 0x45, 0x23,
+	0x8a, 0xcb, 0x74, 0xf1, 0xb8, 0x1e, 0x54, 0xe1, 0x8f, 0xa0, 0x0c, 0xa8, 0x24, 0x42, 0x9d, 0x72,
+	0x2f, 0x98, 0xa0, 0x77, 0x69, 0x81, 0xd2, 0xa3, 0xcd, 0x12, 0xbe, 0xb2, 0x0d, 0xb5, 0x13, 0xca,
+	0xed, 0x28, 0xf4, 0x69, 0x1a, 0xb7, 0xd2, 0x06, 0x79, 0x09, 0xa5, 0x49, 0xec, 0x42, 0x21, 0x14,
+	0x40, 0x3a, 0x99, 0xf9, 0x41, 0xd9, 0x83, 0xfa, 0x67, 0x11, 0x9a, 0xcb, 0x66, 0x31, 0xef, 0xcd,
+	0xc2, 0xd1, 0xe4, 0xee, 0x92, 0x0e, 0x54, 0x56, 0xd0, 0xfb, 0x51, 0x48, 0xf7, 0xa3, 0xe8, 0xfc,
+	0x92, 0xa0, 0x7c, 0x57, 0x17, 0x6a, 0xc2, 0x13, 0x03, 0x63, 0x07, 0x13, 0xcd, 0xd1, 0x0d, 0x32,
+	0xb4, 0x07, 0x7d, 0x43, 0x33, 0x3f, 0x98, 0x86, 0x2e, 0xff, 0x87, 0x76, 0xa0, 0x36, 0xb4, 0xd5,
+	0xa1, 0xfb, 0xd1, 0xb0, 0x5d, 0x53, 0x53, 0x5d, 0x43, 0x97, 0x25, 0xb4, 0x07, 0xff, 0xcf, 0x41,
+	0x07, 0x9b, 0xe7, 0x86, 0x4e, 0x4c, 0x5d, 0xbc, 0x75, 0xbf, 0xc8, 0x1b, 0xa8, 0x06, 0x15, 0xd3,
+	0x3e, 0x53, 0x2d, 0x53, 0x27, 0xda, 0x00, 0xcb, 0x39, 0x24, 0x43, 0x15, 0xab, 0xae, 0x41, 0x2c,
+	0xf3, 0x93, 0x29, 0xd4, 0x05, 0x54, 0x87, 0x9d, 0x9e, 0xaa, 0x9d, 0x1a, 0xb6, 0x4e, 0x86, 0xb6,
+	0x7a, 0xa6, 0x9a, 0x96, 0xda, 0xb3, 0x0c, 0x79, 0x13, 0xed, 0x82, 0xac, 0xf6, 0xfb, 0xd8, 0x39,
+	0x53, 0x2d, 0xd2, 0x37, 0x6c, 0xdd, 0xb4, 0x4f, 0xe4, 0xa2, 0xa8, 0x40, 0xb3, 0x1c, 0xed, 0x94,
+	0x0c, 0x6d, 0x17, 0x0f, 0x07, 0xe2, 0x8e, 0x92, 0x92, 0x2f, 0xe5, 0xe5, 0x7c, 0xa7, 0xea, 0xba,
+	0x16, 0x71, 0x1d, 0x87, 0x58, 0x8e, 0x7d, 0x72, 0xfc, 0x23, 0x07, 0x48, 0x74, 0x17, 0x5c, 0x06,
+	0xbe, 0xc7, 0xe9, 0x80, 0xb2, 0x9b, 0xc0, 0xa7, 0x68, 0x00, 0x79, 0xb1, 0x75, 0xe8, 0xd5, 0x03,
+	0x9b, 0x9c, 0x4c, 0xb1, 0xf9, 0xfa, 0x01, 0x56, 0x9a, 0xce, 0x05, 0x94, 0xef, 0x3e, 0x1e, 0xb4,
+	0x9f, 0xa9, 0x59, 0xff, 0xb0, 0x9b, 0x9d, 0xc7, 0x50, 0x53, 0x0f, 0x02, 0xa5, 0xc5, 0x56, 0xa0,
+	0x76, 0xa6, 0x6e, 0x6d, 0x97, 0x9a, 0xfb, 0x8f, 0x60, 0xa6, 0x06, 0x13, 0x90, 0xd7, 0x97, 0x09,
+	0x1d, 0x66, 0xca, 0x33, 0xf6, 0xae, 0x99, 0x3d, 0xd7, 0x15, 0xf2, 0xa1, 0xd4, 0x83, 0xf3, 0xd2,
+	0xe2, 0xcd, 0xc5, 0x66, 0xf2, 0xc7, 0x7f, 0xfb, 0x7b, 0x00, 0x0c, 0xf8, 0x8a, 0xe5, 0x0f, 0x06,
+	0x00, 0x00,
+}
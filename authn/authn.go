@@ -0,0 +1,57 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package authn authenticates the callers of Istio CA with credentials issued
+// by external identity providers, and maps them to Istio identities.
+package authn
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+)
+
+// Authenticator verifies the credential presented by a caller.
+type Authenticator interface {
+	// Authenticate returns the Istio identity, a SPIFFE URI, that the
+	// verified credential maps to.
+	Authenticate(credential string) (string, error)
+}
+
+// KeyFlusher is implemented by the Authenticators caching the keys that their
+// identity providers sign the credentials with.
+type KeyFlusher interface {
+	// FlushKeys drops the cached keys, which are fetched again on next use.
+	FlushKeys()
+}
+
+// LoadMapping reads an identity mapping from a JSON file holding an object
+// from external identities to SPIFFE URIs.
+func LoadMapping(path string) (map[string]string, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	mapping := map[string]string{}
+	if err := json.Unmarshal(bs, &mapping); err != nil {
+		return nil, fmt.Errorf("invalid identity mapping in %s (error: %v)", path, err)
+	}
+	for k, id := range mapping {
+		if u, err := url.Parse(id); err != nil || u.Scheme != "spiffe" {
+			return nil, fmt.Errorf("identity %q is mapped to %q, which is not a SPIFFE URI", k, id)
+		}
+	}
+	return mapping, nil
+}
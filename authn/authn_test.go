@@ -0,0 +1,61 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authn
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLoadMapping(t *testing.T) {
+	testCases := map[string]struct {
+		content         string
+		expectedMapping map[string]string
+	}{
+		"Valid mapping": {
+			content: `{"foo@project.iam.gserviceaccount.com": "spiffe://cluster.local/ns/foo/sa/foo"}`,
+			expectedMapping: map[string]string{
+				"foo@project.iam.gserviceaccount.com": "spiffe://cluster.local/ns/foo/sa/foo",
+			},
+		},
+		"Not a SPIFFE URI": {
+			content: `{"foo@project.iam.gserviceaccount.com": "https://foo.com"}`,
+		},
+		"Malformed JSON": {
+			content: `{"foo"`,
+		},
+	}
+
+	for k, tc := range testCases {
+		f, err := ioutil.TempFile("", "mapping")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(tc.content) // nolint: errcheck
+		f.Close()                 // nolint: errcheck
+
+		mapping, err := LoadMapping(f.Name())
+		os.Remove(f.Name()) // nolint: errcheck
+		if tc.expectedMapping == nil {
+			if err == nil {
+				t.Errorf("%s: expecting an error but got mapping %v", k, mapping)
+			}
+		} else if err != nil || !reflect.DeepEqual(mapping, tc.expectedMapping) {
+			t.Errorf("%s: expecting mapping %v but got %v (error: %v)", k, tc.expectedMapping, mapping, err)
+		}
+	}
+}
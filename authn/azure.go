@@ -0,0 +1,92 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authn
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AzureAuthenticator authenticates callers with Azure AD access tokens of
+// managed identities, which Azure VMs obtain from the instance metadata
+// service (IMDS), and maps the Azure resource of the managed identity to an
+// Istio identity.
+//
+// The mapping is keyed by lower-cased Azure resource IDs, or by
+// "<subscription>/<resource group>" to map all the managed identities of a
+// resource group. Resource IDs take precedence.
+type AzureAuthenticator struct {
+	verifier *jwtVerifier
+	tenantID string
+
+	mapping map[string]string
+}
+
+// NewAzureAuthenticator returns a pointer to an AzureAuthenticator accepting
+// tokens issued by the Azure AD tenant for the audience, and the identity
+// mapping from Azure resources to SPIFFE URIs.
+func NewAzureAuthenticator(tenantID, audience string, mapping map[string]string) *AzureAuthenticator {
+	keysURL := fmt.Sprintf("https://login.microsoftonline.com/%s/discovery/keys", tenantID)
+	keys := newKeySet(keysURL, &http.Client{Timeout: 10 * time.Second})
+	issuers := []string{fmt.Sprintf("https://sts.windows.net/%s/", tenantID)}
+
+	normalized := map[string]string{}
+	for k, id := range mapping {
+		normalized[strings.ToLower(strings.Trim(k, "/"))] = id
+	}
+	return &AzureAuthenticator{
+		verifier: newJWTVerifier(issuers, audience, keys),
+		tenantID: tenantID,
+		mapping:  normalized,
+	}
+}
+
+// FlushKeys implements KeyFlusher.
+func (a *AzureAuthenticator) FlushKeys() {
+	a.verifier.keys.flush()
+}
+
+// Authenticate implements Authenticator.
+func (a *AzureAuthenticator) Authenticate(credential string) (string, error) {
+	claims, err := a.verifier.verify(credential)
+	if err != nil {
+		return "", err
+	}
+	if tid, _ := claims["tid"].(string); tid != a.tenantID {
+		return "", fmt.Errorf("the token is issued by tenant %q", tid)
+	}
+
+	// The resource ID of the managed identity, e.g.
+	// /subscriptions/<sub>/resourcegroups/<rg>/providers/Microsoft.Compute/virtualMachines/<vm>.
+	mirid, _ := claims["xms_mirid"].(string)
+	if mirid == "" {
+		return "", errors.New("the token is not issued for a managed identity")
+	}
+	resource := strings.ToLower(strings.Trim(mirid, "/"))
+	if id, ok := a.mapping[resource]; ok {
+		return id, nil
+	}
+
+	parts := strings.Split(resource, "/")
+	if len(parts) >= 4 && parts[0] == "subscriptions" && parts[2] == "resourcegroups" {
+		if id, ok := a.mapping[parts[1]+"/"+parts[3]]; ok {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("managed identity resource %s is not mapped to an Istio identity", mirid)
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAzureAuthenticator(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.server.Close()
+
+	const tenant = "72f988bf-86f1-41af-91ab-2d7cd011db47"
+	mapping := map[string]string{
+		"/subscriptions/sub1/resourceGroups/RG1/providers/Microsoft.Compute/virtualMachines/db": "spiffe://cluster.local/ns/db/sa/db",
+		"sub1/rg1": "spiffe://cluster.local/ns/vms/sa/default",
+	}
+	header := map[string]interface{}{"alg": "RS256", "kid": testKeyID}
+
+	testCases := map[string]struct {
+		tenant     string
+		mirid      string
+		expectedID string
+	}{
+		"Mapped resource": {
+			tenant:     tenant,
+			mirid:      "/subscriptions/sub1/resourcegroups/rg1/providers/Microsoft.Compute/virtualMachines/db",
+			expectedID: "spiffe://cluster.local/ns/db/sa/db",
+		},
+		"Mapped resource group": {
+			tenant:     tenant,
+			mirid:      "/subscriptions/sub1/resourcegroups/rg1/providers/Microsoft.Compute/virtualMachines/web",
+			expectedID: "spiffe://cluster.local/ns/vms/sa/default",
+		},
+		"Unmapped resource group": {
+			tenant: tenant,
+			mirid:  "/subscriptions/sub1/resourcegroups/rg2/providers/Microsoft.Compute/virtualMachines/web",
+		},
+		"Other tenant": {
+			tenant: "other",
+			mirid:  "/subscriptions/sub1/resourcegroups/rg1/providers/Microsoft.Compute/virtualMachines/db",
+		},
+		"Not a managed identity": {
+			tenant: tenant,
+		},
+	}
+
+	for k, tc := range testCases {
+		a := NewAzureAuthenticator(tenant, "https://management.azure.com/", mapping)
+		a.verifier.keys = issuer.keySet()
+
+		token := issuer.sign(t, header, map[string]interface{}{
+			"iss":       "https://sts.windows.net/" + tenant + "/",
+			"aud":       "https://management.azure.com/",
+			"exp":       time.Now().Add(time.Hour).Unix(),
+			"tid":       tc.tenant,
+			"xms_mirid": tc.mirid,
+		})
+		id, err := a.Authenticate(token)
+		if tc.expectedID == "" {
+			if err == nil {
+				t.Errorf("%s: expecting an error but got identity %s", k, id)
+			}
+		} else if err != nil || id != tc.expectedID {
+			t.Errorf("%s: expecting identity %s but got %s (error: %v)", k, tc.expectedID, id, err)
+		}
+	}
+}
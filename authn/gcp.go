@@ -0,0 +1,76 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authn
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// The URL of the keys signing Google ID tokens.
+const googleCertsURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+var googleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+// GCPAuthenticator authenticates callers with Google-signed ID tokens, such as
+// those that GCE instances and GKE workloads with Workload Identity obtain from
+// the metadata server, and maps the service account of the token to an Istio
+// identity.
+type GCPAuthenticator struct {
+	verifier *jwtVerifier
+
+	// Maps from GCP service account emails to SPIFFE URIs.
+	mapping map[string]string
+}
+
+// NewGCPAuthenticator returns a pointer to a GCPAuthenticator accepting ID
+// tokens issued for the audience, and the identity mapping from GCP service
+// account emails to SPIFFE URIs.
+func NewGCPAuthenticator(audience string, mapping map[string]string) *GCPAuthenticator {
+	keys := newKeySet(googleCertsURL, &http.Client{Timeout: 10 * time.Second})
+	return &GCPAuthenticator{
+		verifier: newJWTVerifier(googleIssuers, audience, keys),
+		mapping:  mapping,
+	}
+}
+
+// FlushKeys implements KeyFlusher.
+func (a *GCPAuthenticator) FlushKeys() {
+	a.verifier.keys.flush()
+}
+
+// Authenticate implements Authenticator.
+func (a *GCPAuthenticator) Authenticate(credential string) (string, error) {
+	claims, err := a.verifier.verify(credential)
+	if err != nil {
+		return "", err
+	}
+
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return "", errors.New("the ID token carries no service account email")
+	}
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		return "", fmt.Errorf("the email %s of the ID token is not verified", email)
+	}
+
+	id, ok := a.mapping[email]
+	if !ok {
+		return "", fmt.Errorf("GCP service account %s is not mapped to an Istio identity", email)
+	}
+	return id, nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGCPAuthenticator(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.server.Close()
+
+	mapping := map[string]string{
+		"billing@project.iam.gserviceaccount.com": "spiffe://cluster.local/ns/billing/sa/billing",
+	}
+	header := map[string]interface{}{"alg": "RS256", "kid": testKeyID}
+
+	testCases := map[string]struct {
+		email         string
+		emailVerified bool
+		expectedID    string
+	}{
+		"Mapped service account": {
+			email:         "billing@project.iam.gserviceaccount.com",
+			emailVerified: true,
+			expectedID:    "spiffe://cluster.local/ns/billing/sa/billing",
+		},
+		"Unmapped service account": {
+			email:         "other@project.iam.gserviceaccount.com",
+			emailVerified: true,
+		},
+		"Unverified email": {
+			email: "billing@project.iam.gserviceaccount.com",
+		},
+		"No email": {
+			emailVerified: true,
+		},
+	}
+
+	for k, tc := range testCases {
+		a := NewGCPAuthenticator("istio-ca", mapping)
+		a.verifier.keys = issuer.keySet()
+
+		token := issuer.sign(t, header, map[string]interface{}{
+			"iss":            "https://accounts.google.com",
+			"aud":            "istio-ca",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"email":          tc.email,
+			"email_verified": tc.emailVerified,
+		})
+		id, err := a.Authenticate(token)
+		if tc.expectedID == "" {
+			if err == nil {
+				t.Errorf("%s: expecting an error but got identity %s", k, id)
+			}
+		} else if err != nil || id != tc.expectedID {
+			t.Errorf("%s: expecting identity %s but got %s (error: %v)", k, tc.expectedID, id, err)
+		}
+	}
+}
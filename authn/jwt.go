@@ -0,0 +1,289 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authn
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/auth/parse"
+)
+
+const (
+	// The tolerated clock skew when checking the validity period of a token.
+	jwtLeeway = time.Minute
+
+	// The key set is refetched after keySetRefreshInterval, or on an unknown
+	// key ID but at most once per keySetMinRefreshInterval.
+	keySetRefreshInterval    = time.Hour
+	keySetMinRefreshInterval = time.Minute
+
+	// While the key set fails to be refetched, the cached keys are served
+	// for up to keySetMaxStale after they were fetched, and the refetch is
+	// retried at most once per keySetMinRefreshInterval.
+	keySetMaxStale = 24 * time.Hour
+)
+
+// jwtVerifier verifies RS256 signed JWTs against the keys published by the
+// identity provider, along with their issuer, audience and validity period.
+type jwtVerifier struct {
+	issuers  []string
+	audience string
+	keys     *keySet
+
+	now func() time.Time
+}
+
+func newJWTVerifier(issuers []string, audience string, keys *keySet) *jwtVerifier {
+	return &jwtVerifier{issuers: issuers, audience: audience, keys: keys, now: time.Now}
+}
+
+// verify returns the claims of the token if it is valid.
+func (v *jwtVerifier) verify(token string) (map[string]interface{}, error) {
+	jwt, err := parse.UnverifiedJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	if alg, _ := jwt.Header["alg"].(string); alg != "RS256" {
+		return nil, fmt.Errorf("unsupported JWT signing algorithm %q", alg)
+	}
+	kid, _ := jwt.Header["kid"].(string)
+	key, err := v.keys.key(kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(jwt.SigningInput))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], jwt.Signature); err != nil {
+		return nil, errors.New("invalid JWT signature")
+	}
+
+	iss, _ := jwt.Claims["iss"].(string)
+	if !contains(v.issuers, iss) {
+		return nil, fmt.Errorf("unexpected JWT issuer %q", iss)
+	}
+	if !hasAudience(jwt.Claims["aud"], v.audience) {
+		return nil, fmt.Errorf("JWT is not issued for audience %q", v.audience)
+	}
+
+	now := v.now()
+	exp, ok := jwt.Claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("JWT has no expiration time")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return nil, errors.New("JWT has expired")
+	}
+	if nbf, ok := jwt.Claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0).Add(-jwtLeeway)) {
+		return nil, errors.New("JWT is not valid yet")
+	}
+	return jwt.Claims, nil
+}
+
+// hasAudience returns true if the "aud" claim, a string or an array of
+// strings, includes the audience.
+func hasAudience(claim interface{}, audience string) bool {
+	switch aud := claim.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// keySet caches the RSA keys of a JSON Web Key Set published at a URL.
+type keySet struct {
+	mutex sync.Mutex
+
+	url    string
+	client *http.Client
+
+	// The issuer whose OpenID configuration publishes the URL, if the URL is
+	// yet to be discovered.
+	issuer string
+
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+
+	// The time before which a failed refetch is not retried while the cached
+	// keys are served instead.
+	retry time.Time
+}
+
+func newKeySet(url string, client *http.Client) *keySet {
+	return &keySet{url: url, client: client}
+}
+
+// newDiscoveredKeySet returns a keySet whose URL is the jwks_uri of the
+// OpenID configuration of the issuer, which is discovered on first use.
+func newDiscoveredKeySet(issuer string, client *http.Client) *keySet {
+	return &keySet{issuer: issuer, client: client}
+}
+
+// key returns the key with the given ID, refetching the key set if needed.
+// If the refetch fails, the cached keys are served until they are older than
+// keySetMaxStale.
+func (s *keySet) key(kid string) (*rsa.PublicKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	age := now.Sub(s.fetched)
+	_, known := s.keys[kid]
+	stale := len(s.keys) == 0 || age > keySetMaxStale
+	if (age > keySetRefreshInterval || (!known && age > keySetMinRefreshInterval)) && (stale || now.After(s.retry)) {
+		if err := s.refresh(); err != nil {
+			if stale {
+				return nil, err
+			}
+			glog.Warningf("Serving the JSON Web Key Set fetched %v ago (error: %v)", age, err)
+			s.retry = now.Add(keySetMinRefreshInterval)
+		} else {
+			s.fetched = now
+		}
+	}
+
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown JWT key ID %q", kid)
+	}
+	return key, nil
+}
+
+// flush drops the cached keys, and the discovered URL if any.
+func (s *keySet) flush() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.keys = nil
+	s.fetched = time.Time{}
+	s.retry = time.Time{}
+	if s.issuer != "" {
+		s.url = ""
+	}
+}
+
+// refresh refetches the keys, discovering the URL of the key set first if
+// needed.
+func (s *keySet) refresh() error {
+	if s.url == "" {
+		url, err := s.discover()
+		if err != nil {
+			return fmt.Errorf("failed to discover the JSON Web Key Set of issuer %s (error: %v)", s.issuer, err)
+		}
+		s.url = url
+	}
+	keys, err := s.fetch()
+	if err != nil {
+		return fmt.Errorf("failed to fetch the JSON Web Key Set from %s (error: %v)", s.url, err)
+	}
+	s.keys = keys
+	return nil
+}
+
+// discover returns the jwks_uri of the OpenID configuration of the issuer
+// (OpenID Connect Discovery 1.0, section 4).
+func (s *keySet) discover() (string, error) {
+	resp, err := s.client.Get(strings.TrimSuffix(s.issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var config struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return "", err
+	}
+	if config.Issuer != s.issuer {
+		return "", fmt.Errorf("the OpenID configuration is published for issuer %q", config.Issuer)
+	}
+	if !strings.HasPrefix(config.JWKSURI, "https://") {
+		return "", fmt.Errorf("invalid jwks_uri %q: expect an https:// URL", config.JWKSURI)
+	}
+	return config.JWKSURI, nil
+}
+
+func (s *keySet) fetch() (map[string]*rsa.PublicKey, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of key %q (error: %v)", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid exponent of key %q", k.Kid)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
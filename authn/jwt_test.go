@@ -0,0 +1,213 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authn
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testKeyID = "test-key"
+
+// testIssuer signs JWTs and publishes its key set over HTTP.
+type testIssuer struct {
+	key    *rsa.PrivateKey
+	server *httptest.Server
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := &testIssuer{key: key}
+	i.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := big.NewInt(int64(key.E)).Bytes()
+		json.NewEncoder(w).Encode(map[string]interface{}{ // nolint: errcheck
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": testKeyID,
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(e),
+			}},
+		})
+	}))
+	return i
+}
+
+func (i *testIssuer) keySet() *keySet {
+	return newKeySet(i.server.URL, i.server.Client())
+}
+
+func (i *testIssuer) sign(t *testing.T, header, claims map[string]interface{}) string {
+	encode := func(v interface{}) string {
+		bs, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(bs)
+	}
+	input := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTVerifier(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.server.Close()
+
+	now := time.Now()
+	header := map[string]interface{}{"alg": "RS256", "kid": testKeyID}
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": "https://issuer.example.com",
+			"aud": "istio-ca",
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	testCases := map[string]struct {
+		header        map[string]interface{}
+		claims        map[string]interface{}
+		tamper        bool
+		expectedError string
+	}{
+		"Valid token": {
+			header: header,
+			claims: claims(nil),
+		},
+		"Audience in a list": {
+			header: header,
+			claims: claims(map[string]interface{}{"aud": []string{"other", "istio-ca"}}),
+		},
+		"Wrong issuer": {
+			header:        header,
+			claims:        claims(map[string]interface{}{"iss": "https://evil.example.com"}),
+			expectedError: "unexpected JWT issuer",
+		},
+		"Wrong audience": {
+			header:        header,
+			claims:        claims(map[string]interface{}{"aud": "other"}),
+			expectedError: "not issued for audience",
+		},
+		"Expired token": {
+			header:        header,
+			claims:        claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()}),
+			expectedError: "expired",
+		},
+		"Token not valid yet": {
+			header:        header,
+			claims:        claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()}),
+			expectedError: "not valid yet",
+		},
+		"Unknown key": {
+			header:        map[string]interface{}{"alg": "RS256", "kid": "other"},
+			claims:        claims(nil),
+			expectedError: "unknown JWT key ID",
+		},
+		"Unsupported algorithm": {
+			header:        map[string]interface{}{"alg": "HS256", "kid": testKeyID},
+			claims:        claims(nil),
+			expectedError: "unsupported JWT signing algorithm",
+		},
+		"Tampered token": {
+			header:        header,
+			claims:        claims(nil),
+			tamper:        true,
+			expectedError: "invalid JWT signature",
+		},
+	}
+
+	for k, tc := range testCases {
+		v := newJWTVerifier([]string{"https://issuer.example.com"}, "istio-ca", issuer.keySet())
+		token := issuer.sign(t, tc.header, tc.claims)
+		if tc.tamper {
+			parts := strings.Split(token, ".")
+			forged, _ := json.Marshal(claims(map[string]interface{}{"sub": "admin"}))
+			token = parts[0] + "." + base64.RawURLEncoding.EncodeToString(forged) + "." + parts[2]
+		}
+
+		_, err := v.verify(token)
+		if tc.expectedError == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+		} else if tc.expectedError != "" && (err == nil || !strings.Contains(err.Error(), tc.expectedError)) {
+			t.Errorf("%s: expecting error %q but got %v", k, tc.expectedError, err)
+		}
+	}
+}
+
+func TestKeySetFlush(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.server.Close()
+	s := issuer.keySet()
+
+	if _, err := s.key(testKeyID); err != nil {
+		t.Fatalf("Failed to fetch the key: %v", err)
+	}
+	fetched := s.fetched
+	s.flush()
+	if len(s.keys) != 0 || !s.fetched.IsZero() {
+		t.Error("Expecting the cached keys to be dropped")
+	}
+	if _, err := s.key(testKeyID); err != nil {
+		t.Fatalf("Failed to fetch the key after the flush: %v", err)
+	}
+	if !s.fetched.After(fetched) {
+		t.Error("Expecting the key set to be fetched again after the flush")
+	}
+}
+
+func TestKeySetServesStaleKeys(t *testing.T) {
+	issuer := newTestIssuer(t)
+	s := issuer.keySet()
+	if _, err := s.key(testKeyID); err != nil {
+		t.Fatalf("Failed to fetch the key: %v", err)
+	}
+	issuer.server.Close()
+
+	s.fetched = time.Now().Add(-2 * keySetRefreshInterval)
+	if _, err := s.key(testKeyID); err != nil {
+		t.Errorf("Expecting the cached key to be served when the refetch fails, got %v", err)
+	}
+	if !s.retry.After(time.Now()) {
+		t.Error("Expecting the failed refetch to be retried later")
+	}
+	if _, err := s.key("other-key"); err == nil || !strings.Contains(err.Error(), "unknown JWT key ID") {
+		t.Errorf("Expecting an unknown key ID to be refused, got %v", err)
+	}
+
+	s.fetched = time.Now().Add(-2 * keySetMaxStale)
+	if _, err := s.key(testKeyID); err == nil || !strings.Contains(err.Error(), "failed to fetch") {
+		t.Errorf("Expecting the keys older than keySetMaxStale to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authn
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	authenticationv1beta1 "k8s.io/client-go/kubernetes/typed/authentication/v1beta1"
+	authentication "k8s.io/client-go/pkg/apis/authentication/v1beta1"
+	rbac "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+
+	"istio.io/auth/parse"
+)
+
+// The prefix of the usernames of Kubernetes service accounts, which are
+// followed by "<namespace>:<name>".
+const serviceAccountUsernamePrefix = "system:serviceaccount:"
+
+// KubernetesAuthenticator authenticates pods with the tokens of their
+// Kubernetes service accounts, which it verifies with the TokenReview API,
+// and maps each service account to its Istio identity. Since every pod
+// generates its own key and requests its own certificate, the replicas of a
+// workload share an identity but not a key.
+type KubernetesAuthenticator struct {
+	reviews     authenticationv1beta1.TokenReviewInterface
+	audience    string
+	trustDomain func(namespace string) (string, error)
+}
+
+// NewKubernetesAuthenticator returns a pointer to a KubernetesAuthenticator
+// verifying tokens with the TokenReview API, and mapping service accounts to
+// SPIFFE URIs of the trust domain that trustDomain returns for their
+// namespace. If the audience is not empty, only the tokens issued for it are
+// accepted, e.g. projected service account tokens, so that the tokens that
+// pods present to other services cannot be replayed to the CA.
+func NewKubernetesAuthenticator(reviews authenticationv1beta1.TokenReviewInterface, audience string,
+	trustDomain func(namespace string) (string, error)) *KubernetesAuthenticator {
+
+	return &KubernetesAuthenticator{reviews: reviews, audience: audience, trustDomain: trustDomain}
+}
+
+// Authenticate implements Authenticator.
+func (a *KubernetesAuthenticator) Authenticate(credential string) (string, error) {
+	review, err := a.reviews.Create(&authentication.TokenReview{
+		Spec: authentication.TokenReviewSpec{Token: credential},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to review the token (error: %v)", err)
+	}
+	if !review.Status.Authenticated {
+		if review.Status.Error != "" {
+			return "", fmt.Errorf("the token is not authenticated (error: %s)", review.Status.Error)
+		}
+		return "", errors.New("the token is not authenticated")
+	}
+
+	username := review.Status.User.Username
+	parts := strings.Split(strings.TrimPrefix(username, serviceAccountUsernamePrefix), ":")
+	if !strings.HasPrefix(username, serviceAccountUsernamePrefix) || len(parts) != 2 || parts[0] == "" ||
+		parts[1] == "" {
+		return "", fmt.Errorf("the token of %q is not issued for a service account", username)
+	}
+	// The TokenReview API of the supported Kubernetes versions does not
+	// review the audiences of the token, so the "aud" claim of the token,
+	// authenticated by the review, is checked instead.
+	if a.audience != "" {
+		jwt, err := parse.UnverifiedJWT(credential)
+		if err != nil || !hasAudience(jwt.Claims["aud"], a.audience) {
+			return "", fmt.Errorf("the token of %q is not issued for audience %q", username, a.audience)
+		}
+	}
+	trustDomain, err := a.trustDomain(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("failed to select the trust domain of %q (error: %v)", username, err)
+	}
+	return fmt.Sprintf("spiffe://%s/ns/%s/sa/%s", trustDomain, parts[0], parts[1]), nil
+}
+
+// KubernetesAuthenticatorRules returns the cluster-wide RBAC rules needed by a
+// KubernetesAuthenticator.
+func KubernetesAuthenticatorRules() []rbac.PolicyRule {
+	return []rbac.PolicyRule{
+		{
+			APIGroups: []string{"authentication.k8s.io"},
+			Resources: []string{"tokenreviews"},
+			Verbs:     []string{"create"},
+		},
+	}
+}
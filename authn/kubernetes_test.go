@@ -0,0 +1,152 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authn
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	authentication "k8s.io/client-go/pkg/apis/authentication/v1beta1"
+)
+
+// fakeTokenReviews maps the tokens to the usernames they authenticate.
+type fakeTokenReviews map[string]string
+
+func (r fakeTokenReviews) Create(review *authentication.TokenReview) (*authentication.TokenReview, error) {
+	if review.Spec.Token == "unreachable" {
+		return nil, errors.New("connection refused")
+	}
+	result := *review
+	if username, ok := r[review.Spec.Token]; ok {
+		result.Status = authentication.TokenReviewStatus{
+			Authenticated: true,
+			User:          authentication.UserInfo{Username: username},
+		}
+	}
+	return &result, nil
+}
+
+// unsignedJWT returns a JWT with the claims, whose signature is left to the
+// fake TokenReview API.
+func unsignedJWT(t *testing.T, claims map[string]interface{}) string {
+	bs, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"RS256"}`)) + "." + encode(bs) + "." + encode([]byte("signature"))
+}
+
+func trustDomains(namespace string) (string, error) {
+	switch namespace {
+	case "tenant":
+		return "tenant.com", nil
+	case "unknown":
+		return "", fmt.Errorf("namespace %s is labeled with unknown trust domain", namespace)
+	}
+	return "cluster.local", nil
+}
+
+func TestKubernetesAuthenticator(t *testing.T) {
+	reviews := fakeTokenReviews{
+		"foo-token":      "system:serviceaccount:default:foo",
+		"tenant-token":   "system:serviceaccount:tenant:bar",
+		"unknown-token":  "system:serviceaccount:unknown:bar",
+		"user-token":     "alice",
+		"malformed-name": "system:serviceaccount:default",
+	}
+
+	testCases := map[string]struct {
+		token      string
+		expectedID string
+	}{
+		"Service account": {
+			token:      "foo-token",
+			expectedID: "spiffe://cluster.local/ns/default/sa/foo",
+		},
+		"Service account in another trust domain": {
+			token:      "tenant-token",
+			expectedID: "spiffe://tenant.com/ns/tenant/sa/bar",
+		},
+		"Service account in an unknown trust domain": {
+			token: "unknown-token",
+		},
+		"Unauthenticated token": {
+			token: "other-token",
+		},
+		"Not a service account": {
+			token: "user-token",
+		},
+		"Malformed service account username": {
+			token: "malformed-name",
+		},
+		"TokenReview failure": {
+			token: "unreachable",
+		},
+	}
+
+	a := NewKubernetesAuthenticator(reviews, "", trustDomains)
+	for k, tc := range testCases {
+		id, err := a.Authenticate(tc.token)
+		if tc.expectedID == "" {
+			if err == nil {
+				t.Errorf("%s: the token should be refused, but is authenticated as %s", k, id)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+		} else if id != tc.expectedID {
+			t.Errorf("%s: unexpected identity %s (expecting %s)", k, id, tc.expectedID)
+		}
+	}
+}
+
+func TestKubernetesAuthenticatorAudience(t *testing.T) {
+	caToken := unsignedJWT(t, map[string]interface{}{"aud": []string{"istio-ca"}})
+	otherToken := unsignedJWT(t, map[string]interface{}{"aud": "vault"})
+	reviews := fakeTokenReviews{
+		caToken:       "system:serviceaccount:default:foo",
+		otherToken:    "system:serviceaccount:default:foo",
+		"dummy-token": "system:serviceaccount:default:foo",
+	}
+
+	testCases := map[string]struct {
+		token         string
+		expectedError bool
+	}{
+		"Token issued for the audience": {
+			token: caToken,
+		},
+		"Token issued for another audience": {
+			token:         otherToken,
+			expectedError: true,
+		},
+		"Token without audiences": {
+			token:         "dummy-token",
+			expectedError: true,
+		},
+	}
+
+	a := NewKubernetesAuthenticator(reviews, "istio-ca", trustDomains)
+	for k, tc := range testCases {
+		if _, err := a.Authenticate(tc.token); (err != nil) != tc.expectedError {
+			t.Errorf("%s: unexpected error (expecting an error: %v, actual %v)", k, tc.expectedError, err)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"istio.io/auth/parse"
+	"istio.io/auth/pkg/spiffe"
+)
+
+// The pattern of the claim values substituted into the identities of OIDCRule,
+// which may not add path segments.
+var identityClaimPattern = regexp.MustCompile(`^[A-Za-z0-9._~-]+$`)
+
+// The placeholders of claims in the identities of OIDCRule, e.g. "{repository_owner}".
+var claimPlaceholder = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// OIDCIssuer configures an OpenID Connect provider whose ID tokens are
+// accepted, and the rules mapping the tokens to Istio identities.
+type OIDCIssuer struct {
+	// The issuer of the tokens, an https:// URL.
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+
+	// The URL of the JSON Web Key Set of the issuer; discovered from the
+	// OpenID configuration of the issuer if empty.
+	JWKSURI string `json:"jwksURI"`
+
+	// The rules mapping the tokens to identities. The first matching rule applies.
+	Rules []OIDCRule `json:"rules"`
+}
+
+// OIDCRule maps the ID tokens carrying the given claims to an Istio identity.
+type OIDCRule struct {
+	// The claims that the token must carry, by name. A value is either the
+	// value of the claim, or a prefix of the value followed by "*".
+	Claims map[string]string `json:"claims"`
+
+	// The SPIFFE URI the token maps to, where every "{<claim>}" is replaced
+	// with the value of the claim, e.g.
+	// "spiffe://cluster.local/ns/ci/sa/{repository_owner}". A substituted
+	// value may only consist of letters, digits, '.', '_', '~' and '-'.
+	Identity string `json:"identity"`
+}
+
+// OIDCAuthenticator authenticates callers with the ID tokens of an OpenID
+// Connect provider, such as those that CI systems issue to their jobs, and
+// maps them to Istio identities by the claims they carry.
+type OIDCAuthenticator struct {
+	verifier *jwtVerifier
+	issuer   string
+	rules    []OIDCRule
+}
+
+// NewOIDCAuthenticator returns a pointer to an OIDCAuthenticator accepting ID
+// tokens of the issuer for the audience, and the identity mapping from the
+// values of the claim to SPIFFE URIs. A value may end with "*" to map all
+// the values with the prefix, the longest matching value taking precedence.
+// The keys of the issuer are discovered from its OpenID configuration.
+func NewOIDCAuthenticator(issuer, audience, claim string, mapping map[string]string) *OIDCAuthenticator {
+	values := []string{}
+	for v := range mapping {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+
+	rules := []OIDCRule{}
+	for _, v := range values {
+		rules = append(rules, OIDCRule{Claims: map[string]string{claim: v}, Identity: mapping[v]})
+	}
+	return newOIDCAuthenticator(OIDCIssuer{Issuer: issuer, Audience: audience, Rules: rules})
+}
+
+func newOIDCAuthenticator(config OIDCIssuer) *OIDCAuthenticator {
+	client := &http.Client{Timeout: 10 * time.Second}
+	keys := newDiscoveredKeySet(config.Issuer, client)
+	if config.JWKSURI != "" {
+		keys = newKeySet(config.JWKSURI, client)
+	}
+	return &OIDCAuthenticator{
+		verifier: newJWTVerifier([]string{config.Issuer}, config.Audience, keys),
+		issuer:   config.Issuer,
+		rules:    config.Rules,
+	}
+}
+
+// FlushKeys implements KeyFlusher.
+func (a *OIDCAuthenticator) FlushKeys() {
+	a.verifier.keys.flush()
+}
+
+// Authenticate implements Authenticator.
+func (a *OIDCAuthenticator) Authenticate(credential string) (string, error) {
+	claims, err := a.verifier.verify(credential)
+	if err != nil {
+		return "", err
+	}
+
+	for _, r := range a.rules {
+		if !r.matches(claims) {
+			continue
+		}
+		return r.identity(claims)
+	}
+	sub, _ := claims["sub"].(string)
+	return "", fmt.Errorf("no rule maps the ID token of %s (subject %q) to an Istio identity", a.issuer, sub)
+}
+
+// matches returns true if the claims carry the claims of the rule.
+func (r OIDCRule) matches(claims map[string]interface{}) bool {
+	for name, pattern := range r.Claims {
+		value, _ := claims[name].(string)
+		// Claims are not SPIFFE IDs, but follow the same pattern syntax.
+		if value == "" || !spiffe.Matcher([]string{pattern}).Matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// identity returns the identity of the rule with the claims substituted.
+func (r OIDCRule) identity(claims map[string]interface{}) (string, error) {
+	var err error
+	id := claimPlaceholder.ReplaceAllStringFunc(r.Identity, func(p string) string {
+		name := p[1 : len(p)-1]
+		value, _ := claims[name].(string)
+		if !identityClaimPattern.MatchString(value) && err == nil {
+			err = fmt.Errorf("claim %s %q may not be substituted into identity %s", name, value, r.Identity)
+		}
+		return value
+	})
+	return id, err
+}
+
+// OIDCFederation authenticates callers with the ID tokens of any of several
+// OpenID Connect providers, by the authenticator of the issuer of the token.
+type OIDCFederation map[string]*OIDCAuthenticator
+
+// NewOIDCFederation returns an OIDCFederation accepting the ID tokens of the issuers.
+func NewOIDCFederation(issuers []OIDCIssuer) OIDCFederation {
+	f := OIDCFederation{}
+	for _, config := range issuers {
+		f[config.Issuer] = newOIDCAuthenticator(config)
+	}
+	return f
+}
+
+// FlushKeys implements KeyFlusher.
+func (f OIDCFederation) FlushKeys() {
+	for _, a := range f {
+		a.FlushKeys()
+	}
+}
+
+// Authenticate implements Authenticator.
+func (f OIDCFederation) Authenticate(credential string) (string, error) {
+	jwt, err := parse.UnverifiedJWT(credential)
+	if err != nil {
+		return "", err
+	}
+	iss, _ := jwt.Claims["iss"].(string)
+	a, ok := f[iss]
+	if !ok {
+		return "", fmt.Errorf("unexpected JWT issuer %q", iss)
+	}
+	return a.Authenticate(credential)
+}
+
+// LoadOIDCIssuers reads the OpenID Connect providers from a JSON file holding
+// an array of OIDCIssuer.
+func LoadOIDCIssuers(path string) ([]OIDCIssuer, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	issuers := []OIDCIssuer{}
+	if err := json.Unmarshal(bs, &issuers); err != nil {
+		return nil, fmt.Errorf("invalid OIDC issuers in %s (error: %v)", path, err)
+	}
+	if len(issuers) == 0 {
+		return nil, fmt.Errorf("no OIDC issuers in %s", path)
+	}
+
+	seen := map[string]bool{}
+	for _, config := range issuers {
+		if err := config.validate(); err != nil {
+			return nil, fmt.Errorf("invalid OIDC issuer %q in %s (error: %v)", config.Issuer, path, err)
+		}
+		if seen[config.Issuer] {
+			return nil, fmt.Errorf("OIDC issuer %q is configured more than once in %s", config.Issuer, path)
+		}
+		seen[config.Issuer] = true
+	}
+	return issuers, nil
+}
+
+func (c OIDCIssuer) validate() error {
+	if !strings.HasPrefix(c.Issuer, "https://") {
+		return errors.New("the issuer must be an https:// URL")
+	}
+	if c.Audience == "" {
+		return errors.New("no audience is specified")
+	}
+	if c.JWKSURI != "" && !strings.HasPrefix(c.JWKSURI, "https://") {
+		return fmt.Errorf("invalid jwksURI %q: expect an https:// URL", c.JWKSURI)
+	}
+	if len(c.Rules) == 0 {
+		return errors.New("no rules are specified")
+	}
+	for i, r := range c.Rules {
+		if len(r.Claims) == 0 {
+			return fmt.Errorf("rule %d matches no claims", i)
+		}
+		// The placeholders are substituted before the identity is parsed.
+		id := claimPlaceholder.ReplaceAllString(r.Identity, "x")
+		if u, err := url.Parse(id); err != nil || u.Scheme != "spiffe" {
+			return fmt.Errorf("rule %d maps to %q, which is not a SPIFFE URI", i, r.Identity)
+		}
+	}
+	return nil
+}
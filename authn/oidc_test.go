@@ -0,0 +1,325 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authn
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOIDCAuthenticator(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.server.Close()
+
+	mapping := map[string]string{
+		"example/deploy": "spiffe://cluster.local/ns/ci/sa/deploy",
+	}
+	header := map[string]interface{}{"alg": "RS256", "kid": testKeyID}
+
+	testCases := map[string]struct {
+		repository string
+		expectedID string
+	}{
+		"Mapped claim": {
+			repository: "example/deploy",
+			expectedID: "spiffe://cluster.local/ns/ci/sa/deploy",
+		},
+		"Unmapped claim": {
+			repository: "example/other",
+		},
+		"No claim": {},
+	}
+
+	for k, tc := range testCases {
+		a := NewOIDCAuthenticator("https://ci.example.com", "istio-ca", "repository", mapping)
+		a.verifier.keys = issuer.keySet()
+
+		claims := map[string]interface{}{
+			"iss": "https://ci.example.com",
+			"aud": "istio-ca",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		if tc.repository != "" {
+			claims["repository"] = tc.repository
+		}
+		id, err := a.Authenticate(issuer.sign(t, header, claims))
+		if tc.expectedID == "" {
+			if err == nil {
+				t.Errorf("%s: expecting an error but got identity %s", k, id)
+			}
+		} else if err != nil || id != tc.expectedID {
+			t.Errorf("%s: expecting identity %s but got %s (error: %v)", k, tc.expectedID, id, err)
+		}
+	}
+}
+
+func TestOIDCRules(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.server.Close()
+
+	rules := []OIDCRule{
+		{
+			Claims:   map[string]string{"repository": "example/deploy", "ref": "refs/heads/main"},
+			Identity: "spiffe://cluster.local/ns/ci/sa/deploy",
+		},
+		{
+			Claims:   map[string]string{"repository": "example/*"},
+			Identity: "spiffe://cluster.local/ns/ci/sa/{repository_owner}-{environment}",
+		},
+	}
+	header := map[string]interface{}{"alg": "RS256", "kid": testKeyID}
+
+	testCases := map[string]struct {
+		claims     map[string]interface{}
+		expectedID string
+	}{
+		"First rule": {
+			claims:     map[string]interface{}{"repository": "example/deploy", "ref": "refs/heads/main"},
+			expectedID: "spiffe://cluster.local/ns/ci/sa/deploy",
+		},
+		"Prefix and substitutions": {
+			claims: map[string]interface{}{"repository": "example/deploy", "ref": "refs/heads/dev",
+				"repository_owner": "example", "environment": "staging"},
+			expectedID: "spiffe://cluster.local/ns/ci/sa/example-staging",
+		},
+		"Unsafe substitution": {
+			claims: map[string]interface{}{"repository": "example/build",
+				"repository_owner": "example", "environment": "../../istio-system/sa/admin"},
+		},
+		"Missing substitution": {
+			claims: map[string]interface{}{"repository": "example/build", "repository_owner": "example"},
+		},
+		"No matching rule": {
+			claims: map[string]interface{}{"repository": "other/deploy", "ref": "refs/heads/main"},
+		},
+	}
+
+	for k, tc := range testCases {
+		a := newOIDCAuthenticator(OIDCIssuer{Issuer: "https://ci.example.com", Audience: "istio-ca", Rules: rules})
+		a.verifier.keys = issuer.keySet()
+
+		claims := map[string]interface{}{
+			"iss": "https://ci.example.com",
+			"aud": "istio-ca",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		for name, v := range tc.claims {
+			claims[name] = v
+		}
+		id, err := a.Authenticate(issuer.sign(t, header, claims))
+		if tc.expectedID == "" {
+			if err == nil {
+				t.Errorf("%s: expecting an error but got identity %s", k, id)
+			}
+		} else if err != nil || id != tc.expectedID {
+			t.Errorf("%s: expecting identity %s but got %s (error: %v)", k, tc.expectedID, id, err)
+		}
+	}
+}
+
+func TestOIDCFederation(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.server.Close()
+
+	f := NewOIDCFederation([]OIDCIssuer{
+		{
+			Issuer:   "https://ci.example.com",
+			Audience: "istio-ca",
+			Rules: []OIDCRule{
+				{Claims: map[string]string{"sub": "deploy"}, Identity: "spiffe://cluster.local/ns/ci/sa/deploy"},
+			},
+		},
+		{
+			Issuer:   "https://k8s.example.com",
+			Audience: "istio-ca",
+			Rules: []OIDCRule{
+				{Claims: map[string]string{"sub": "*"}, Identity: "spiffe://cluster.local/ns/remote/sa/default"},
+			},
+		},
+	})
+	for _, a := range f {
+		a.verifier.keys = issuer.keySet()
+	}
+	header := map[string]interface{}{"alg": "RS256", "kid": testKeyID}
+
+	testCases := map[string]struct {
+		issuer     string
+		expectedID string
+	}{
+		"CI issuer": {
+			issuer:     "https://ci.example.com",
+			expectedID: "spiffe://cluster.local/ns/ci/sa/deploy",
+		},
+		"Kubernetes issuer": {
+			issuer:     "https://k8s.example.com",
+			expectedID: "spiffe://cluster.local/ns/remote/sa/default",
+		},
+		"Unknown issuer": {
+			issuer: "https://other.example.com",
+		},
+	}
+
+	for k, tc := range testCases {
+		token := issuer.sign(t, header, map[string]interface{}{
+			"iss": tc.issuer,
+			"aud": "istio-ca",
+			"exp": time.Now().Add(time.Hour).Unix(),
+			"sub": "deploy",
+		})
+		id, err := f.Authenticate(token)
+		if tc.expectedID == "" {
+			if err == nil {
+				t.Errorf("%s: expecting an error but got identity %s", k, id)
+			}
+		} else if err != nil || id != tc.expectedID {
+			t.Errorf("%s: expecting identity %s but got %s (error: %v)", k, tc.expectedID, id, err)
+		}
+	}
+}
+
+func TestLoadOIDCIssuers(t *testing.T) {
+	testCases := map[string]struct {
+		content     string
+		expectedErr string
+	}{
+		"Valid issuers": {
+			content: `[{"issuer": "https://ci.example.com", "audience": "istio-ca", "rules": [
+				{"claims": {"repository": "example/*"}, "identity": "spiffe://cluster.local/ns/ci/sa/{repository_owner}"}]}]`,
+		},
+		"No issuers": {
+			content:     `[]`,
+			expectedErr: "no OIDC issuers",
+		},
+		"Insecure issuer": {
+			content:     `[{"issuer": "http://ci.example.com", "audience": "istio-ca"}]`,
+			expectedErr: "the issuer must be an https:// URL",
+		},
+		"No audience": {
+			content:     `[{"issuer": "https://ci.example.com"}]`,
+			expectedErr: "no audience is specified",
+		},
+		"No rules": {
+			content:     `[{"issuer": "https://ci.example.com", "audience": "istio-ca"}]`,
+			expectedErr: "no rules are specified",
+		},
+		"Rule without claims": {
+			content: `[{"issuer": "https://ci.example.com", "audience": "istio-ca", "rules": [
+				{"identity": "spiffe://cluster.local/ns/ci/sa/deploy"}]}]`,
+			expectedErr: "rule 0 matches no claims",
+		},
+		"Not a SPIFFE URI": {
+			content: `[{"issuer": "https://ci.example.com", "audience": "istio-ca", "rules": [
+				{"claims": {"sub": "deploy"}, "identity": "https://ci.example.com"}]}]`,
+			expectedErr: `rule 0 maps to "https://ci.example.com", which is not a SPIFFE URI`,
+		},
+		"Duplicate issuer": {
+			content: `[{"issuer": "https://ci.example.com", "audience": "a", "rules": [
+				{"claims": {"sub": "x"}, "identity": "spiffe://cluster.local/ns/ci/sa/x"}]},
+				{"issuer": "https://ci.example.com", "audience": "b", "rules": [
+				{"claims": {"sub": "y"}, "identity": "spiffe://cluster.local/ns/ci/sa/y"}]}]`,
+			expectedErr: "is configured more than once",
+		},
+		"Malformed JSON": {
+			content:     `[{"issuer"`,
+			expectedErr: "invalid OIDC issuers",
+		},
+	}
+
+	for k, tc := range testCases {
+		f, err := ioutil.TempFile("", "oidc-issuers")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(tc.content) // nolint: errcheck
+		f.Close()                 // nolint: errcheck
+
+		issuers, err := LoadOIDCIssuers(f.Name())
+		os.Remove(f.Name()) // nolint: errcheck
+		if tc.expectedErr == "" {
+			if err != nil || len(issuers) != 1 {
+				t.Errorf("%s: expecting 1 issuer but got %v (error: %v)", k, issuers, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+			t.Errorf("%s: expecting error %q, but got %v", k, tc.expectedErr, err)
+		}
+	}
+}
+
+func TestKeySetDiscovery(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		// The issuer of the OpenID configuration; the URL of the server if empty.
+		configIssuer string
+		expectedErr  string
+	}{
+		"Discovered": {},
+		"Wrong issuer": {
+			configIssuer: "https://other.example.com",
+			expectedErr:  `the OpenID configuration is published for issuer "https://other.example.com"`,
+		},
+	}
+
+	for k, tc := range testCases {
+		var server *httptest.Server
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/.well-known/openid-configuration" {
+				issuer := tc.configIssuer
+				if issuer == "" {
+					issuer = server.URL
+				}
+				json.NewEncoder(w).Encode(map[string]string{ // nolint: errcheck
+					"issuer":   issuer,
+					"jwks_uri": server.URL + "/keys",
+				})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{ // nolint: errcheck
+				"keys": []map[string]string{{
+					"kty": "RSA",
+					"kid": testKeyID,
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				}},
+			})
+		}))
+
+		s := newDiscoveredKeySet(server.URL, server.Client())
+		_, err := s.key(testKeyID)
+		if tc.expectedErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", k, err)
+			} else if s.url != server.URL+"/keys" {
+				t.Errorf("%s: unexpected key set URL %s", k, s.url)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+			t.Errorf("%s: expecting error %q, but got %v", k, tc.expectedErr, err)
+		}
+		server.Close()
+	}
+}
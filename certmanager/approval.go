@@ -0,0 +1,342 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The states of an ApprovalRequest.
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalDenied   = "denied"
+)
+
+// The decisions recorded in the audit trail of an ApprovalQueue, besides
+// ApprovalApproved and ApprovalDenied.
+const (
+	ApprovalRequested = "requested"
+	ApprovalExpired   = "expired"
+	ApprovalIssued    = "issued"
+)
+
+// The sources of the name of an Approver.
+const (
+	// The approver is authenticated, e.g. by their own token of the admin API.
+	ApproverAuthenticated = "authenticated"
+	// The approver is named by a caller only authenticated as an
+	// administrator, e.g. with the shared token of the admin API.
+	ApproverClaimed = "claimed"
+)
+
+// The number of the latest decisions kept in the audit trail.
+const maxApprovalAudit = 1000
+
+// ErrApprovalNotFound is returned when deciding on an approval request that
+// does not exist or is no longer pending.
+var ErrApprovalNotFound = errors.New("no such pending approval request")
+
+// ErrSelfApproval is returned when the approver of an approval request is its
+// identity, or the identity requesting the issuance on its behalf.
+var ErrSelfApproval = errors.New("an approval request may not be decided by its identity or requester")
+
+var pendingApprovals = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "istio_ca",
+	Name:      "pending_approvals",
+	Help:      "The number of issuances awaiting manual approval, see ApprovalQueue.",
+})
+
+func init() {
+	prometheus.MustRegister(pendingApprovals)
+}
+
+// ApprovalPendingError is returned when an issuance for a sensitive identity
+// awaits manual approval. Retrying it once the request is approved succeeds.
+type ApprovalPendingError struct {
+	ID       string
+	Identity string
+}
+
+func (e *ApprovalPendingError) Error() string {
+	return fmt.Sprintf("the issuance for %s awaits manual approval (request %s)", e.Identity, e.ID)
+}
+
+// ApprovalDeniedError is returned when an issuance for a sensitive identity
+// is refused as its approval request has been denied.
+type ApprovalDeniedError struct {
+	ID       string
+	Identity string
+	By       string
+}
+
+func (e *ApprovalDeniedError) Error() string {
+	return fmt.Sprintf("the issuance for %s has been denied by %s (request %s)", e.Identity, e.By, e.ID)
+}
+
+// Approver is who approves or denies an approval request.
+type Approver struct {
+	Name string
+	// How the name is known, ApproverAuthenticated or ApproverClaimed.
+	Source string
+}
+
+// ApprovalRequest is a request for issuing a certificate for a sensitive identity.
+type ApprovalRequest struct {
+	ID       string
+	Identity string
+	// The identity requesting the issuance on behalf of Identity, if any.
+	RequestedBy string
+	// One of ApprovalPending, ApprovalApproved and ApprovalDenied.
+	State string
+	// Who approved or denied the request, if anyone, and the source of the name.
+	DecidedBy      string
+	DecisionSource string
+
+	RequestedAt time.Time
+	// When the request expires unless approved, the approval unless used, or
+	// the denial.
+	ExpiresAt time.Time
+}
+
+// ApprovalEvent is an entry of the audit trail of an ApprovalQueue.
+type ApprovalEvent struct {
+	ID       string
+	Identity string
+	// One of ApprovalRequested, ApprovalApproved, ApprovalDenied,
+	// ApprovalExpired and ApprovalIssued.
+	Decision string
+	// Who approved or denied the request, and the source of the name; empty
+	// for the other decisions.
+	By     string
+	Source string
+	At     time.Time
+}
+
+// ApprovalQueue parks the issuances for sensitive identities until an
+// operator explicitly approves them, e.g. via the admin API. The first
+// issuance for such an identity files an approval request and fails with
+// ApprovalPendingError; once approved, the next issuance for the identity
+// succeeds and uses up the approval. Requests, approvals and denials expire
+// after a timeout, and every decision is logged and kept in an audit trail.
+type ApprovalQueue struct {
+	mutex sync.Mutex
+
+	// The path.Match patterns of the sensitive identities.
+	patterns []string
+	timeout  time.Duration
+
+	// The requests by identity.
+	requests map[string]*ApprovalRequest
+	// The latest decisions, oldest first.
+	audit []ApprovalEvent
+
+	now func() time.Time
+}
+
+// NewApprovalQueue returns a pointer to a new ApprovalQueue requiring
+// approval for the identities matching any of the path.Match patterns, e.g.
+// spiffe://cluster.local/ns/istio-system/sa/*. Approval requests time out
+// after the given duration.
+func NewApprovalQueue(patterns []string, timeout time.Duration) (*ApprovalQueue, error) {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid identity pattern %q (error: %v)", p, err)
+		}
+	}
+	if timeout <= 0 {
+		return nil, fmt.Errorf("invalid approval timeout %v", timeout)
+	}
+	return &ApprovalQueue{
+		patterns: patterns,
+		timeout:  timeout,
+		requests: map[string]*ApprovalRequest{},
+		now:      time.Now,
+	}, nil
+}
+
+// Requires returns true if issuances for the identity require approval.
+func (q *ApprovalQueue) Requires(id string) bool {
+	for _, p := range q.patterns {
+		if ok, _ := path.Match(p, id); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// admit returns nil if the identity does not require approval, and
+// otherwise uses up its approval, or returns the error the issuance is
+// refused with. The requester is the identity requesting the issuance on
+// behalf of id, if any.
+func (q *ApprovalQueue) admit(id, requester string) error {
+	if !q.Requires(id) {
+		return nil
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := q.now()
+	q.expire(now)
+	r, ok := q.requests[id]
+	if !ok {
+		r = &ApprovalRequest{
+			ID:          newApprovalID(),
+			Identity:    id,
+			RequestedBy: requester,
+			State:       ApprovalPending,
+			RequestedAt: now,
+			ExpiresAt:   now.Add(q.timeout),
+		}
+		q.requests[id] = r
+		q.record(r, ApprovalRequested, Approver{}, now)
+		q.updatePending()
+		glog.Warningf("The issuance for %s awaits manual approval (request %s)", id, r.ID)
+	}
+
+	switch r.State {
+	case ApprovalApproved:
+		delete(q.requests, id)
+		q.record(r, ApprovalIssued, Approver{}, now)
+		return nil
+	case ApprovalDenied:
+		return &ApprovalDeniedError{ID: r.ID, Identity: id, By: r.DecidedBy}
+	default:
+		return &ApprovalPendingError{ID: r.ID, Identity: id}
+	}
+}
+
+// Requests returns the unexpired approval requests, oldest first.
+func (q *ApprovalQueue) Requests() []ApprovalRequest {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.expire(q.now())
+	requests := []ApprovalRequest{}
+	for _, r := range q.requests {
+		requests = append(requests, *r)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].RequestedAt.Before(requests[j].RequestedAt)
+	})
+	return requests
+}
+
+// Audit returns the latest decisions, oldest first.
+func (q *ApprovalQueue) Audit() []ApprovalEvent {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.expire(q.now())
+	return append([]ApprovalEvent{}, q.audit...)
+}
+
+// Approve approves the pending request with the given ID on behalf of the
+// approver, who may be neither its identity nor its requester. The approval
+// expires unless used within the timeout.
+func (q *ApprovalQueue) Approve(id string, approver Approver) error {
+	return q.decide(id, approver, ApprovalApproved)
+}
+
+// Deny denies the pending request with the given ID on behalf of the
+// approver like Approve. The issuances for the identity are refused until
+// the denial expires after the timeout.
+func (q *ApprovalQueue) Deny(id string, approver Approver) error {
+	return q.decide(id, approver, ApprovalDenied)
+}
+
+func (q *ApprovalQueue) decide(id string, approver Approver, state string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := q.now()
+	q.expire(now)
+	for _, r := range q.requests {
+		if r.ID != id || r.State != ApprovalPending {
+			continue
+		}
+		if approver.Name == r.Identity || (r.RequestedBy != "" && approver.Name == r.RequestedBy) {
+			return ErrSelfApproval
+		}
+		r.State = state
+		r.DecidedBy, r.DecisionSource = approver.Name, approver.Source
+		r.ExpiresAt = now.Add(q.timeout)
+		q.record(r, state, approver, now)
+		q.updatePending()
+		glog.Infof("The issuance for %s has been %s by %s, %s (request %s)", r.Identity, state, approver.Name,
+			approver.Source, id)
+		return nil
+	}
+	return ErrApprovalNotFound
+}
+
+// expire must be called with the lock held.
+func (q *ApprovalQueue) expire(now time.Time) {
+	for id, r := range q.requests {
+		if now.Before(r.ExpiresAt) {
+			continue
+		}
+		delete(q.requests, id)
+		if r.State != ApprovalDenied {
+			q.record(r, ApprovalExpired, Approver{}, now)
+			glog.Warningf("The %s approval request %s for %s has expired", r.State, r.ID, id)
+		}
+	}
+	q.updatePending()
+}
+
+// updatePending must be called with the lock held.
+func (q *ApprovalQueue) updatePending() {
+	pending := 0
+	for _, r := range q.requests {
+		if r.State == ApprovalPending {
+			pending++
+		}
+	}
+	pendingApprovals.Set(float64(pending))
+}
+
+// record must be called with the lock held.
+func (q *ApprovalQueue) record(r *ApprovalRequest, decision string, by Approver, now time.Time) {
+	q.audit = append(q.audit, ApprovalEvent{
+		ID:       r.ID,
+		Identity: r.Identity,
+		Decision: decision,
+		By:       by.Name,
+		Source:   by.Source,
+		At:       now,
+	})
+	if len(q.audit) > maxApprovalAudit {
+		q.audit = q.audit[len(q.audit)-maxApprovalAudit:]
+	}
+}
+
+func newApprovalID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		glog.Errorf("Failed to generate a random approval request ID (error: %v)", err)
+	}
+	return fmt.Sprintf("%x", b)
+}
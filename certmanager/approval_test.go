@@ -0,0 +1,267 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApprovalQueue(t *testing.T) {
+	const sensitive = "spiffe://cluster.local/ns/istio-system/sa/admin"
+	type step struct {
+		// The time of the step, relative to the first one.
+		after time.Duration
+		// One of "issue", "approve" and "deny".
+		action           string
+		id               string
+		expectedDecision string
+		// One of "", "pending" and "denied", for issuances.
+		expectedError string
+	}
+	testCases := map[string]struct {
+		steps []step
+	}{
+		"Insensitive identity": {
+			steps: []step{
+				{action: "issue", id: "spiffe://cluster.local/ns/default/sa/admin"},
+			},
+		},
+		"Approved": {
+			steps: []step{
+				{action: "issue", id: sensitive, expectedDecision: ApprovalRequested, expectedError: "pending"},
+				{action: "issue", id: sensitive, expectedError: "pending"},
+				{action: "approve", after: time.Minute, expectedDecision: ApprovalApproved},
+				{action: "issue", after: 2 * time.Minute, id: sensitive, expectedDecision: ApprovalIssued},
+				// The approval is used up.
+				{action: "issue", after: 3 * time.Minute, id: sensitive, expectedDecision: ApprovalRequested,
+					expectedError: "pending"},
+			},
+		},
+		"Denied": {
+			steps: []step{
+				{action: "issue", id: sensitive, expectedDecision: ApprovalRequested, expectedError: "pending"},
+				{action: "deny", expectedDecision: ApprovalDenied},
+				{action: "issue", after: 30 * time.Minute, id: sensitive, expectedError: "denied"},
+				// The denial has expired.
+				{action: "issue", after: 61 * time.Minute, id: sensitive, expectedDecision: ApprovalRequested,
+					expectedError: "pending"},
+			},
+		},
+		"Request expired": {
+			steps: []step{
+				{action: "issue", id: sensitive, expectedDecision: ApprovalRequested, expectedError: "pending"},
+				{action: "issue", after: time.Hour, id: sensitive, expectedDecision: ApprovalRequested,
+					expectedError: "pending"},
+			},
+		},
+		"Approval expired": {
+			steps: []step{
+				{action: "issue", id: sensitive, expectedDecision: ApprovalRequested, expectedError: "pending"},
+				{action: "approve", expectedDecision: ApprovalApproved},
+				{action: "issue", after: time.Hour, id: sensitive, expectedDecision: ApprovalRequested,
+					expectedError: "pending"},
+			},
+		},
+	}
+
+	start := time.Now()
+	for k, tc := range testCases {
+		q, err := NewApprovalQueue([]string{"spiffe://cluster.local/ns/istio-system/sa/*"}, time.Hour)
+		if err != nil {
+			t.Fatalf("%s: failed to create the approval queue: %v", k, err)
+		}
+		requestID := ""
+		for i, s := range tc.steps {
+			now := start.Add(s.after)
+			q.now = func() time.Time { return now }
+			auditLen := len(q.Audit())
+
+			switch s.action {
+			case "issue":
+				err = q.admit(s.id, "")
+				actualError := ""
+				switch e := err.(type) {
+				case *ApprovalPendingError:
+					actualError = "pending"
+					requestID = e.ID
+				case *ApprovalDeniedError:
+					actualError = "denied"
+				}
+				if actualError != s.expectedError || (actualError == "" && err != nil) {
+					t.Errorf("%s: step %d: expect error %q, but got %v", k, i, s.expectedError, err)
+				}
+			case "approve":
+				err = q.Approve(requestID, Approver{Name: "alice", Source: ApproverAuthenticated})
+			case "deny":
+				err = q.Deny(requestID, Approver{Name: "alice", Source: ApproverAuthenticated})
+			}
+			if s.action != "issue" && err != nil {
+				t.Errorf("%s: step %d: failed to %s request %s: %v", k, i, s.action, requestID, err)
+			}
+
+			audit := q.Audit()
+			if s.expectedDecision == "" {
+				if len(audit) != auditLen {
+					t.Errorf("%s: step %d: unexpected audit event %v", k, i, audit[len(audit)-1])
+				}
+				continue
+			}
+			if last := audit[len(audit)-1]; last.Decision != s.expectedDecision {
+				t.Errorf("%s: step %d: expect decision %s, but got %v", k, i, s.expectedDecision, last)
+			}
+		}
+	}
+}
+
+func TestApprovalQueueDecisions(t *testing.T) {
+	q, err := NewApprovalQueue([]string{"spiffe://cluster.local/ns/foo/sa/*"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create the approval queue: %v", err)
+	}
+	alice := Approver{Name: "alice", Source: ApproverAuthenticated}
+	if err := q.Approve("unknown", alice); err != ErrApprovalNotFound {
+		t.Errorf("Expecting ErrApprovalNotFound for an unknown request, but got %v", err)
+	}
+
+	const operator = "spiffe://cluster.local/ns/gw/sa/operator"
+	err = q.admit("spiffe://cluster.local/ns/foo/sa/bar", operator)
+	pe, ok := err.(*ApprovalPendingError)
+	if !ok {
+		t.Fatalf("Expecting an ApprovalPendingError, but got %v", err)
+	}
+	requests := q.Requests()
+	if len(requests) != 1 || requests[0].ID != pe.ID || requests[0].State != ApprovalPending {
+		t.Errorf("Expecting the pending request %s, but got %v", pe.ID, requests)
+	}
+
+	for _, name := range []string{"spiffe://cluster.local/ns/foo/sa/bar", operator} {
+		if err := q.Approve(pe.ID, Approver{Name: name, Source: ApproverClaimed}); err != ErrSelfApproval {
+			t.Errorf("Expecting ErrSelfApproval for approver %s, but got %v", name, err)
+		}
+	}
+	if err := q.Deny(pe.ID, Approver{Name: "bob", Source: ApproverClaimed}); err != nil {
+		t.Fatalf("Failed to deny request %s: %v", pe.ID, err)
+	}
+	if err := q.Approve(pe.ID, alice); err != ErrApprovalNotFound {
+		t.Errorf("Expecting ErrApprovalNotFound for a denied request, but got %v", err)
+	}
+	requests = q.Requests()
+	if len(requests) != 1 || requests[0].State != ApprovalDenied || requests[0].DecidedBy != "bob" ||
+		requests[0].DecisionSource != ApproverClaimed || requests[0].RequestedBy != operator {
+		t.Errorf("Expecting the request denied by bob, but got %v", requests)
+	}
+
+	decisions := []string{}
+	for _, e := range q.Audit() {
+		decisions = append(decisions, e.Decision+" "+e.Source)
+	}
+	expected := []string{ApprovalRequested + " ", ApprovalDenied + " " + ApproverClaimed}
+	if !reflect.DeepEqual(decisions, expected) {
+		t.Errorf("Expecting decisions %v, but got %v", expected, decisions)
+	}
+}
+
+func TestNewApprovalQueue(t *testing.T) {
+	testCases := map[string]struct {
+		patterns    []string
+		timeout     time.Duration
+		expectedErr string
+	}{
+		"Valid": {
+			patterns: []string{"spiffe://cluster.local/ns/istio-system/sa/*"},
+			timeout:  time.Hour,
+		},
+		"Invalid pattern": {
+			patterns:    []string{"spiffe://cluster.local/ns/[/sa/*"},
+			timeout:     time.Hour,
+			expectedErr: `invalid identity pattern "spiffe://cluster.local/ns/[/sa/*" (error: syntax error in pattern)`,
+		},
+		"Invalid timeout": {
+			timeout:     0,
+			expectedErr: "invalid approval timeout 0s",
+		},
+	}
+	for k, tc := range testCases {
+		_, err := NewApprovalQueue(tc.patterns, tc.timeout)
+		if tc.expectedErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", k, err)
+			}
+		} else if err == nil || err.Error() != tc.expectedErr {
+			t.Errorf("%s: expect error %q, but got %v", k, tc.expectedErr, err)
+		}
+	}
+}
+
+func TestApprovalQueueIssuance(t *testing.T) {
+	q, err := NewApprovalQueue([]string{"spiffe://cluster.local/ns/bar/sa/foo"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create the approval queue: %v", err)
+	}
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:     time.Hour,
+		CertTTL:       30 * time.Minute,
+		MaxPathLen:    -1,
+		ApprovalQueue: q,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+
+	if _, _, err := ca.Generate("baz", "bar"); err != nil {
+		t.Errorf("Failed to generate the certificate of an insensitive identity: %v", err)
+	}
+	_, _, err = ca.Generate("foo", "bar")
+	pe, ok := err.(*ApprovalPendingError)
+	if !ok {
+		t.Fatalf("Expecting the issuance for a sensitive identity to await approval, but got %v", err)
+	}
+	if err := q.Approve(pe.ID, Approver{Name: "alice", Source: ApproverAuthenticated}); err != nil {
+		t.Fatalf("Failed to approve request %s: %v", pe.ID, err)
+	}
+	_, key, err := ca.Generate("foo", "bar")
+	if err != nil {
+		t.Fatalf("Failed to generate the certificate of an approved identity: %v", err)
+	}
+	if _, err := ca.Recertify("foo", "bar", key); err == nil {
+		t.Error("Expecting the re-certification to await another approval")
+	}
+}
+
+func TestApprovalQueueNoKeyEscrow(t *testing.T) {
+	q, err := NewApprovalQueue([]string{"spiffe://cluster.local/ns/bar/sa/foo"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create the approval queue: %v", err)
+	}
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:     time.Hour,
+		CertTTL:       30 * time.Minute,
+		MaxPathLen:    -1,
+		NoKeyEscrow:   true,
+		ApprovalQueue: q,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+
+	if _, _, err := ca.Generate("foo", "bar"); err != ErrKeyEscrowDisabled {
+		t.Errorf("Expecting key generation to be refused but got error %v", err)
+	}
+	if requests := q.Requests(); len(requests) != 0 {
+		t.Errorf("Expecting no approval request for a refused key generation, but got %v", requests)
+	}
+}
@@ -15,11 +15,22 @@
 package certmanager
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/auth/parse"
 )
 
 const (
@@ -28,11 +39,53 @@ const (
 
 	// The size of a private key for a self-signed Istio CA.
 	caKeySize = 2048
+
+	// The trust domain of Istio identities unless configured otherwise.
+	defaultTrustDomain = "cluster.local"
 )
 
+// ErrKeyEscrowDisabled is returned when the CA is asked to generate a private
+// key for a workload while key escrow is disabled.
+var ErrKeyEscrowDisabled = errors.New("key escrow is disabled: the CA only signs certificate signing requests")
+
+// ErrInjectedFault is returned by the issuances failed on purpose, see
+// IstioCAOptions.InjectedErrorRate.
+var ErrInjectedFault = errors.New("injected issuance failure")
+
+// IdentityRegistry confirms that the Istio identities belong to known,
+// approved workloads before the CA issues their certificates, e.g. from the
+// CMDB of an organization.
+type IdentityRegistry interface {
+	// Approve returns an error unless the service account of the namespace,
+	// in the trust domain, is an approved workload: an
+	// IdentityNotApprovedError if the registry refuses it, and any other
+	// error if the registry cannot be reached.
+	Approve(trustDomain, namespace, serviceAccount string) error
+}
+
+// IdentityNotApprovedError is returned when an issuance is refused as the
+// identity registry does not approve the service account.
+type IdentityNotApprovedError struct {
+	Namespace      string
+	ServiceAccount string
+	// Why the service account is not approved, if the registry tells.
+	Reason string
+}
+
+func (e *IdentityNotApprovedError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("service account %s/%s is not approved by the identity registry", e.Namespace,
+			e.ServiceAccount)
+	}
+	return fmt.Sprintf("service account %s/%s is not approved by the identity registry: %s", e.Namespace,
+		e.ServiceAccount, e.Reason)
+}
+
 // CertificateAuthority contains methods to be supported by a CA.
 type CertificateAuthority interface {
-	Generate(name, namespace string) (chain, key []byte)
+	Generate(name, namespace string) (chain, key []byte, err error)
+	Recertify(name, namespace string, key []byte) (chain []byte, err error)
+	GenerateServerCert(hosts []string) (chain, key []byte, err error)
 	GetRootCertificate() []byte
 }
 
@@ -43,102 +96,832 @@ type IstioCAOptions struct {
 	SigningCertBytes []byte
 	SigningKeyBytes  []byte
 	RootCertBytes    []byte
+
+	// The signing key held by a key device, e.g. a PIV token, which is used
+	// instead of SigningKeyBytes if set. Optional.
+	Signer crypto.Signer
+
+	// The store persisting the latest issuance time, which guards against
+	// backdated issuance after a clock rollback across restarts. Optional.
+	IssuanceTimeStore IssuanceTimeStore
+
+	// The store persisting the issued certificates and the latest issuance
+	// time, shared by the replicas of the CA. It serves as the
+	// IssuanceTimeStore if that is not set. Optional.
+	StateStore StateStore
+
+	// The monitor of the clock skew against a reference clock, and the
+	// maximum skew beyond which issuance is refused. A zero MaxClockSkew
+	// disables the check. Optional.
+	ClockSkew    *ClockSkewMonitor
+	MaxClockSkew time.Duration
+
+	// Whether to refuse generating private keys for workloads, so that
+	// certificates are only issued by signing certificate signing requests.
+	NoKeyEscrow bool
+
+	// The TTL of the per-namespace intermediate CAs issuing the workload
+	// certificates of their namespaces. Workload certificates are issued by
+	// the signing certificate directly if it is zero.
+	NamespaceCATTL time.Duration
+
+	// The trust domain of the issued Istio identities; "cluster.local" if empty.
+	TrustDomain string
+
+	// The minimum TTL of the issued certificates. CertTTL must not be
+	// shorter, and issuance is refused once the signing certificate expires
+	// too soon to issue a certificate valid for at least this long.
+	MinCertTTL time.Duration
+
+	// The algorithm to sign the certificates with; the default of the signing
+	// key if zero. It must suit the signing key, and be RSA if NamespaceCATTL
+	// is set, as the intermediate CAs of the namespaces have RSA keys.
+	SignatureAlgorithm x509.SignatureAlgorithm
+
+	// The probability, between 0 and 1, at which an issuance fails with
+	// ErrInjectedFault, to soak-test the retries of the callers. Zero in
+	// production.
+	InjectedErrorRate float64
+
+	// The quota on the issuances for the Istio identities of each namespace.
+	// Optional.
+	NamespaceQuota NamespaceQuota
+
+	// The registry approving the Istio identities before their issuances.
+	// Optional.
+	IdentityRegistry IdentityRegistry
+
+	// The queue parking the issuances for sensitive Istio identities until
+	// they are manually approved. Optional.
+	ApprovalQueue *ApprovalQueue
 }
 
 // IstioCA generates keys and certificates for Istio identities.
 type IstioCA struct {
+	// Guards the signing material, which changes on root rotation.
+	mutex sync.RWMutex
+
 	certTTL     time.Duration
+	minCertTTL  time.Duration
 	signingCert *x509.Certificate
 	signingKey  crypto.PrivateKey
 
 	certChainBytes []byte
 	rootCertBytes  []byte
+
+	// The root certificates of the peer CAs, appended to the trust bundle.
+	peerRootCertBytes []byte
+
+	// The options to re-generate the self-signed CA certificate on root
+	// rotation; nil if the CA is not self-signed.
+	selfSignedOpts *SelfSignedIstioCAOptions
+
+	issued    *IssuanceLog
+	listeners []IssuanceListener
+	clock     *issuanceClock
+
+	noKeyEscrow        bool
+	trustDomain        string
+	signatureAlgorithm x509.SignatureAlgorithm
+	injectedErrorRate  float64
+
+	// The per-namespace intermediate CAs; nil if they are disabled.
+	namespaceCAs *namespaceCAs
+
+	// The per-namespace issuance quotas; nil if they are disabled.
+	quotas *namespaceQuotas
+
+	registry  IdentityRegistry
+	approvals *ApprovalQueue
+
+	// The new root of the staged root rotation in progress, if any.
+	staged *stagedRoot
+}
+
+// SelfSignedIstioCAOptions holds the configurations for creating a self-signed Istio CA.
+type SelfSignedIstioCAOptions struct {
+	CACertTTL time.Duration
+	CertTTL   time.Duration
+
+	// The subject of the self-signed CA certificate.
+	Subject pkix.Name
+
+	// The maximum number of intermediate CAs allowed below the self-signed CA
+	// certificate. A negative value leaves the path length unconstrained.
+	MaxPathLen int
+
+	// Whether to add a critical name constraint to the self-signed CA
+	// certificate that only permits URI SANs in the Istio trust domain.
+	NameConstrained bool
+
+	// See IstioCAOptions.
+	IssuanceTimeStore IssuanceTimeStore
+	StateStore        StateStore
+	ClockSkew         *ClockSkewMonitor
+	MaxClockSkew      time.Duration
+	NoKeyEscrow       bool
+	NamespaceCATTL    time.Duration
+	TrustDomain       string
+	MinCertTTL        time.Duration
+
+	// See IstioCAOptions. The self-signed CA certificate is signed with it too,
+	// so it must be RSA.
+	SignatureAlgorithm x509.SignatureAlgorithm
+
+	// See IstioCAOptions.
+	InjectedErrorRate float64
+	NamespaceQuota    NamespaceQuota
+	IdentityRegistry  IdentityRegistry
+	ApprovalQueue     *ApprovalQueue
 }
 
 // NewSelfSignedIstioCA returns a new IstioCA instance using self-signed certificate.
-func NewSelfSignedIstioCA(caCertTTL, certTTL time.Duration, org string) (*IstioCA, error) {
-	now := time.Now()
-	options := CertOptions{
-		NotBefore:    now,
-		NotAfter:     now.Add(caCertTTL),
-		Org:          org,
-		IsCA:         true,
-		IsSelfSigned: true,
-		RSAKeySize:   caKeySize,
+func NewSelfSignedIstioCA(opts *SelfSignedIstioCAOptions) (*IstioCA, error) {
+	// The self-signed CA certificate has an RSA key.
+	if err := checkSignatureAlgorithm(opts.SignatureAlgorithm, x509.RSA); err != nil {
+		return nil, err
 	}
-	pemCert, pemKey := GenCert(options)
+	pemCert, pemKey := genSelfSignedCACert(opts)
 
-	opts := &IstioCAOptions{
-		CertTTL:          certTTL,
+	caOpts := &IstioCAOptions{
+		CertTTL:          opts.CertTTL,
 		SigningCertBytes: pemCert,
 		SigningKeyBytes:  pemKey,
 		RootCertBytes:    pemCert,
+
+		IssuanceTimeStore: opts.IssuanceTimeStore,
+		StateStore:        opts.StateStore,
+		ClockSkew:         opts.ClockSkew,
+		MaxClockSkew:      opts.MaxClockSkew,
+		NoKeyEscrow:       opts.NoKeyEscrow,
+		NamespaceCATTL:    opts.NamespaceCATTL,
+		TrustDomain:       opts.TrustDomain,
+		MinCertTTL:        opts.MinCertTTL,
+
+		SignatureAlgorithm: opts.SignatureAlgorithm,
+		InjectedErrorRate:  opts.InjectedErrorRate,
+		NamespaceQuota:     opts.NamespaceQuota,
+		IdentityRegistry:   opts.IdentityRegistry,
+		ApprovalQueue:      opts.ApprovalQueue,
+	}
+	ca, err := NewIstioCA(caOpts)
+	// The generated key is only held by the CA once parsed.
+	wipeBytes(pemKey)
+	if err != nil {
+		return nil, err
 	}
-	return NewIstioCA(opts)
+
+	selfSignedOpts := *opts
+	ca.selfSignedOpts = &selfSignedOpts
+	return ca, nil
 }
 
 // NewIstioCA returns a new IstioCA instance.
 func NewIstioCA(opts *IstioCAOptions) (*IstioCA, error) {
-	ca := &IstioCA{certTTL: opts.CertTTL}
+	if opts.CertTTL < opts.MinCertTTL {
+		return nil, fmt.Errorf("invalid parameters: the certificate TTL %v is shorter than the minimum of %v",
+			opts.CertTTL, opts.MinCertTTL)
+	}
+
+	timeStore := opts.IssuanceTimeStore
+	issued := NewIssuanceLog()
+	if opts.StateStore != nil {
+		if timeStore == nil {
+			timeStore = opts.StateStore
+		}
+		issued = NewPersistentIssuanceLog(opts.StateStore)
+	}
+	clock, err := newIssuanceClock(timeStore)
+	if err != nil {
+		return nil, err
+	}
+	clock.skew = opts.ClockSkew
+	clock.maxSkew = opts.MaxClockSkew
+
+	ca := &IstioCA{
+		certTTL:    opts.CertTTL,
+		minCertTTL: opts.MinCertTTL,
+		issued:     issued,
+		clock:      clock,
+
+		noKeyEscrow:        opts.NoKeyEscrow,
+		trustDomain:        opts.TrustDomain,
+		signatureAlgorithm: opts.SignatureAlgorithm,
+		injectedErrorRate:  opts.InjectedErrorRate,
+		registry:           opts.IdentityRegistry,
+		approvals:          opts.ApprovalQueue,
+	}
+	if ca.trustDomain == "" {
+		ca.trustDomain = defaultTrustDomain
+	}
+	if opts.NamespaceCATTL > 0 {
+		ca.namespaceCAs = newNamespaceCAs(opts.NamespaceCATTL)
+	}
+	if opts.NamespaceQuota != (NamespaceQuota{}) {
+		ca.quotas = newNamespaceQuotas(opts.NamespaceQuota, opts.CertTTL)
+	}
 
 	ca.certChainBytes = copyBytes(opts.CertChainBytes)
 	ca.rootCertBytes = copyBytes(opts.RootCertBytes)
 
 	ca.signingCert = ParsePemEncodedCertificate(opts.SigningCertBytes)
-	ca.signingKey = parsePemEncodedKey(ca.signingCert.PublicKeyAlgorithm, opts.SigningKeyBytes)
+	if opts.Signer != nil {
+		if err := verifySigner(ca.signingCert, opts.Signer); err != nil {
+			return nil, err
+		}
+		ca.signingKey = opts.Signer
+	} else {
+		ca.signingKey = parsePemEncodedKey(ca.signingCert.PublicKeyAlgorithm, opts.SigningKeyBytes)
+	}
 
 	if err := ca.verify(); err != nil {
 		return nil, err
 	}
+	if err := checkNotWeak(ca.signingCert, ca.certChainBytes); err != nil {
+		return nil, err
+	}
+	if err := checkSignatureAlgorithm(ca.signatureAlgorithm, ca.signingCert.PublicKeyAlgorithm); err != nil {
+		return nil, err
+	}
+	if err := checkSignatureAlgorithm(ca.signatureAlgorithm, x509.RSA); ca.namespaceCAs != nil && err != nil {
+		return nil, fmt.Errorf("invalid parameters: the intermediate CAs of namespaces need an RSA signature "+
+			"algorithm, not %s", ca.signatureAlgorithm)
+	}
+	if lifetime := ca.signingCert.NotAfter.Sub(ca.signingCert.NotBefore); ca.certTTL > lifetime {
+		return nil, fmt.Errorf("invalid parameters: the certificate TTL %v exceeds the lifetime of the signing "+
+			"cert (%v)", ca.certTTL, lifetime)
+	}
+	if ca.namespaceCAs != nil && ca.signingCert.MaxPathLenZero {
+		return nil, errors.New(
+			"invalid parameters: the signing cert does not allow the intermediate CAs of namespaces")
+	}
 
 	return ca, nil
 }
 
 // Generate returns a certificate chain and a key for the Istio identity defined by
 // the name and the namespace.
-func (ca IstioCA) Generate(name, namespace string) (chain, key []byte) {
-	// Currently the domain is always set to "cluster.local" since we only
-	// support in-cluster identities.
-	id := fmt.Sprintf("%s://cluster.local/ns/%s/sa/%s", uriScheme, namespace, name)
-	now := time.Now()
-	options := CertOptions{
-		Host:         id,
-		NotBefore:    now,
-		NotAfter:     now.Add(ca.certTTL),
-		SignerCert:   ca.signingCert,
-		SignerPriv:   ca.signingKey,
+func (ca *IstioCA) Generate(name, namespace string) (chain, key []byte, err error) {
+	// Refuse before filing an approval request that could never be used.
+	if ca.noKeyEscrow {
+		return nil, nil, ErrKeyEscrowDisabled
+	}
+	if err := ca.approve(ca.trustDomain, namespace, name); err != nil {
+		return nil, nil, err
+	}
+	options := ca.workloadCertOptions(name, namespace)
+	if err := ca.awaitApproval(options.Host, ""); err != nil {
+		return nil, nil, err
+	}
+	return ca.issue(options, namespace)
+}
+
+// Recertify returns a certificate chain for the service account like
+// Generate, but certifies the given PEM-encoded RSA private key instead of a
+// newly generated one.
+func (ca *IstioCA) Recertify(name, namespace string, key []byte) (chain []byte, err error) {
+	if ca.noKeyEscrow {
+		return nil, ErrKeyEscrowDisabled
+	}
+	if err := ca.injectFault(); err != nil {
+		return nil, err
+	}
+	kb, _ := pem.Decode(key)
+	if kb == nil {
+		return nil, errors.New("invalid PEM encoding for the private key")
+	}
+	priv, err := x509.ParsePKCS1PrivateKey(kb.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the RSA private key (error: %v)", err)
+	}
+
+	now, err := ca.clock.Next()
+	if err != nil {
+		return nil, err
+	}
+	if err := ca.approve(ca.trustDomain, namespace, name); err != nil {
+		return nil, err
+	}
+	options := ca.workloadCertOptions(name, namespace)
+	if err := ca.awaitApproval(options.Host, ""); err != nil {
+		return nil, err
+	}
+	if err := ca.admit(namespace, options.Host, now); err != nil {
+		return nil, err
+	}
+
+	ca.mutex.RLock()
+	signerCert, signerKey, intermediate, err := ca.signer(namespace, now)
+	if err == nil {
+		options.NotBefore = now
+		options.NotAfter, err = ca.notAfter(now, ca.certTTL, signerCert)
+	}
+	if err != nil {
+		ca.mutex.RUnlock()
+		return nil, err
+	}
+	template := genCertTemplate(options)
+	der, err := createCertificate(&template, signerCert, &priv.PublicKey, signerKey)
+	certChainBytes := ca.certChainBytes
+	ca.mutex.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to re-certify the private key (error: %v)", err)
+	}
+
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	ca.record(cert, options.Host, "")
+	return append(append(cert, intermediate...), certChainBytes...), nil
+}
+
+// workloadCertOptions returns the options of the certificate of the service account.
+func (ca *IstioCA) workloadCertOptions(name, namespace string) CertOptions {
+	return CertOptions{
+		Host:         fmt.Sprintf("%s://%s/ns/%s/sa/%s", uriScheme, ca.trustDomain, namespace, name),
 		IsCA:         false,
 		IsClient:     true,
 		IsSelfSigned: false,
 		IsServer:     true,
 		RSAKeySize:   keySize,
+
+		SignatureAlgorithm: ca.signatureAlgorithm,
+	}
+}
+
+// GenerateServerCert returns a certificate chain and a key for a server
+// reachable at the given DNS names, e.g. the hosts of an Ingress.
+func (ca *IstioCA) GenerateServerCert(hosts []string) (chain, key []byte, err error) {
+	options := CertOptions{
+		Host:         strings.Join(hosts, ","),
+		IsCA:         false,
+		IsClient:     false,
+		IsSelfSigned: false,
+		IsServer:     true,
+		RSAKeySize:   keySize,
+
+		SignatureAlgorithm: ca.signatureAlgorithm,
+	}
+	return ca.issue(options, "")
+}
+
+// Sign returns a certificate chain for the public key and the subject
+// alternative names in the PEM-encoded certificate signing request. The
+// caller is responsible for authorizing the requested names. The first Istio
+// identity among them, if any, is approved by the identity registry and the
+// approval queue, and counted in the quota of its namespace like those of
+// Generate.
+func (ca *IstioCA) Sign(csrPEM []byte) (chain []byte, err error) {
+	return ca.SignOnBehalf(csrPEM, "")
+}
+
+// SignOnBehalf is like Sign, but records in the issuance log that the
+// requester, e.g. a trusted controller, requested the certificate on behalf
+// of the identities in the certificate signing request. An empty requester
+// is the identity itself. The DNS names, e.g. the stable DNS name of a
+// StatefulSet pod found by the caller, are added to those of the
+// certificate signing request.
+func (ca *IstioCA) SignOnBehalf(csrPEM []byte, requester string, dnsNames ...string) (chain []byte, err error) {
+	return ca.signCSR(csrPEM, requester, 0, dnsNames, nil)
+}
+
+// SignForPod is like Sign, but the certificate carries the identity of the
+// pod that requested it in the PodIdentityExtensionID extension. The DNS names
+// are added like for SignOnBehalf.
+func (ca *IstioCA) SignForPod(csrPEM []byte, pod PodIdentity, dnsNames ...string) (chain []byte, err error) {
+	return ca.signCSR(csrPEM, "", 0, dnsNames, &pod)
+}
+
+// SignShortLived is like Sign, but the certificate expires after the given
+// TTL if it is shorter than the certificate TTL of the CA, e.g. for external
+// jobs that only call the mesh for a while.
+func (ca *IstioCA) SignShortLived(csrPEM []byte, ttl time.Duration) (chain []byte, err error) {
+	if ttl < ca.minCertTTL {
+		return nil, fmt.Errorf("the certificate TTL %v is shorter than the minimum of %v", ttl, ca.minCertTTL)
+	}
+	return ca.signCSR(csrPEM, "", ttl, nil, nil)
+}
+
+// signCSR signs the certificate signing request for SignOnBehalf, with the
+// certificate TTL of the CA unless ttl is positive and shorter, and with the
+// pod identity extension if the pod is not nil.
+func (ca *IstioCA) signCSR(csrPEM []byte, requester string, ttl time.Duration, dnsNames []string,
+	pod *PodIdentity) (chain []byte, err error) {
+
+	if err := ca.injectFault(); err != nil {
+		return nil, err
+	}
+	csr, err := parse.CSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := []string{}
+	for _, u := range csr.URIs {
+		hosts = append(hosts, u.String())
+	}
+	hosts = append(hosts, csr.DNSNames...)
+	for _, name := range dnsNames {
+		if !contains(hosts, name) {
+			hosts = append(hosts, name)
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("the certificate signing request has no subject alternative names")
+	}
+	var podExtension *pkix.Extension
+	if pod != nil {
+		ext, err := pod.extension()
+		if err != nil {
+			return nil, err
+		}
+		podExtension = &ext
+	}
+
+	now, err := ca.clock.Next()
+	if err != nil {
+		return nil, err
+	}
+	id, namespace, serviceAccount, err := istioIdentity(csr.URIs)
+	if err != nil {
+		return nil, err
+	}
+	if id != nil {
+		if err := ca.approve(id.Host, namespace, serviceAccount); err != nil {
+			return nil, err
+		}
+		if err := ca.awaitApproval(id.String(), requester); err != nil {
+			return nil, err
+		}
+		if err := ca.admit(namespace, id.String(), now); err != nil {
+			return nil, err
+		}
+	}
+
+	ca.mutex.RLock()
+	signerCert, signerKey, intermediate, err := ca.signer(namespace, now)
+	var notAfter time.Time
+	if err == nil {
+		notAfter, err = ca.notAfter(now, ca.certTTL, signerCert)
+	}
+	if err != nil {
+		ca.mutex.RUnlock()
+		return nil, err
+	}
+	if ttl > 0 && now.Add(ttl).Before(notAfter) {
+		notAfter = now.Add(ttl)
+	}
+	template := genCertTemplate(CertOptions{
+		Host:      strings.Join(hosts, ","),
+		NotBefore: now,
+		NotAfter:  notAfter,
+		IsClient:  true,
+		IsServer:  true,
+
+		SignatureAlgorithm: ca.signatureAlgorithm,
+	})
+	if podExtension != nil {
+		template.ExtraExtensions = append(template.ExtraExtensions, *podExtension)
+	}
+	der, err := createCertificate(&template, signerCert, csr.PublicKey, signerKey)
+	certChainBytes := ca.certChainBytes
+	ca.mutex.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign the certificate signing request (error: %v)", err)
+	}
+
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	ca.record(cert, strings.Join(hosts, ","), requester)
+	return append(append(cert, intermediate...), certChainBytes...), nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRootCertificate returns the PEM-encoded root certificate, followed by
+// the new root during a staged root rotation and by the roots of the peers.
+func (ca *IstioCA) GetRootCertificate() []byte {
+	ca.mutex.RLock()
+	defer ca.mutex.RUnlock()
+
+	return append(ca.ownRootCertificates(), ca.peerRootCertBytes...)
+}
+
+// IssuedCertificates returns the records of the unexpired certificates issued by the CA.
+func (ca *IstioCA) IssuedCertificates() []IssuanceRecord {
+	return ca.issued.List()
+}
+
+// ImportIssuances seeds the issuance log with the records of certificates
+// issued before the CA was created, e.g. found in the secrets provisioned by a
+// previous version, so that they are tracked like those issued since. The
+// records of expired or already recorded certificates are skipped, and the
+// issuance listeners are not notified. It returns the number of imported records.
+func (ca *IstioCA) ImportIssuances(records []IssuanceRecord) int {
+	return ca.issued.Import(records)
+}
+
+// RotateRoot replaces the self-signed CA certificate and signing key with newly
+// generated ones. Certificates issued afterwards chain to the new root.
+func (ca *IstioCA) RotateRoot() error {
+	if ca.selfSignedOpts == nil {
+		return errors.New("root rotation is only supported by self-signed CA")
+	}
+
+	pemCert, pemKey := genSelfSignedCACert(ca.selfSignedOpts)
+	cert := ParsePemEncodedCertificate(pemCert)
+	key := parsePemEncodedKey(cert.PublicKeyAlgorithm, pemKey)
+	wipeBytes(pemKey)
+
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	if ca.staged != nil {
+		return errors.New("a staged root rotation is in progress")
+	}
+	ca.signingCert = cert
+	ca.signingKey = key
+	ca.rootCertBytes = pemCert
+	if ca.namespaceCAs != nil {
+		ca.namespaceCAs.reset()
+	}
+	return nil
+}
+
+// UpdateSigningMaterial replaces the certificate chain, the signing cert and
+// key, and the root certificate of the CA, e.g. once they are rotated in the
+// secret they are loaded from. The new material is verified as in NewIstioCA,
+// and the CA is left unchanged if it is invalid.
+func (ca *IstioCA) UpdateSigningMaterial(certChain, signingCert, signingKey, rootCert []byte) error {
+	if ca.selfSignedOpts != nil {
+		return errors.New("the signing material of a self-signed CA can not be replaced")
+	}
+
+	cert, err := parseCertificate(signingCert)
+	if err != nil {
+		return err
+	}
+	key, err := parseKey(cert.PublicKeyAlgorithm, signingKey)
+	if err != nil {
+		return err
+	}
+	if err := verifySigningCert(cert, certChain, rootCert); err != nil {
+		return err
+	}
+	if err := checkNotWeak(cert, certChain); err != nil {
+		return err
+	}
+	if err := checkSignatureAlgorithm(ca.signatureAlgorithm, cert.PublicKeyAlgorithm); err != nil {
+		return err
+	}
+	if lifetime := cert.NotAfter.Sub(cert.NotBefore); ca.certTTL > lifetime {
+		return fmt.Errorf("invalid parameters: the certificate TTL %v exceeds the lifetime of the signing "+
+			"cert (%v)", ca.certTTL, lifetime)
+	}
+	if ca.namespaceCAs != nil && cert.MaxPathLenZero {
+		return errors.New("invalid parameters: the signing cert does not allow the intermediate CAs of namespaces")
+	}
+
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	ca.signingCert = cert
+	ca.signingKey = key
+	ca.certChainBytes = copyBytes(certChain)
+	ca.rootCertBytes = copyBytes(rootCert)
+	if ca.namespaceCAs != nil {
+		ca.namespaceCAs.reset()
+	}
+	return nil
+}
+
+// SelfTest generates a key, signs a test certificate for it and verifies the
+// certificate against the certificate chain and the root certificate, without
+// recording the issuance.
+func (ca *IstioCA) SelfTest() error {
+	ca.mutex.RLock()
+	now := time.Now()
+	options := CertOptions{
+		Host:       fmt.Sprintf("%s://%s/ns/istio-ca-self-test/sa/self-test", uriScheme, ca.trustDomain),
+		NotBefore:  now,
+		NotAfter:   now.Add(time.Minute),
+		SignerCert: ca.signingCert,
+		SignerPriv: ca.signingKey,
+		IsClient:   true,
+		IsServer:   true,
+		RSAKeySize: keySize,
+
+		SignatureAlgorithm: ca.signatureAlgorithm,
+	}
+	certBytes, keyBytes := GenCert(options)
+	chainBytes := append(certBytes, ca.certChainBytes...)
+	rootBytes := copyBytes(ca.rootCertBytes)
+	ca.mutex.RUnlock()
+
+	if _, err := tls.X509KeyPair(certBytes, keyBytes); err != nil {
+		return fmt.Errorf("the test certificate does not match its key (error: %v)", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(rootBytes) {
+		return errors.New("failed to parse the root certificate")
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AppendCertsFromPEM(chainBytes)
+
+	_, err := ParsePemEncodedCertificate(certBytes).Verify(x509.VerifyOptions{
+		Intermediates: intermediates,
+		Roots:         roots,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to verify the test certificate (error: %v)", err)
+	}
+	return nil
+}
+
+// issue signs a certificate with the given options, which are completed with
+// the validity period and the signer of the CA, and records the issuance. The
+// certificates of a namespace are signed by its intermediate CA, if enabled.
+// Issuance is refused if the clock has been rolled back or is skewed too much,
+// if key escrow is disabled, or if the namespace exceeds its quota.
+func (ca *IstioCA) issue(options CertOptions, namespace string) (chain, key []byte, err error) {
+	if ca.noKeyEscrow {
+		return nil, nil, ErrKeyEscrowDisabled
+	}
+	if err := ca.injectFault(); err != nil {
+		return nil, nil, err
+	}
+
+	now, err := ca.clock.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+	if namespace != "" {
+		if err := ca.admit(namespace, options.Host, now); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	ca.mutex.RLock()
+	var intermediate []byte
+	options.SignerCert, options.SignerPriv, intermediate, err = ca.signer(namespace, now)
+	if err == nil {
+		options.NotBefore = now
+		options.NotAfter, err = ca.notAfter(now, ca.certTTL, options.SignerCert)
+	}
+	if err != nil {
+		ca.mutex.RUnlock()
+		return nil, nil, err
 	}
 	cert, key := GenCert(options)
-	chain = append(cert, ca.certChainBytes...)
+	chain = append(append(cert, intermediate...), ca.certChainBytes...)
+	ca.mutex.RUnlock()
 
+	ca.record(cert, options.Host, "")
 	return
 }
 
-// GetRootCertificate returns the PEM-encoded root certificate.
-func (ca IstioCA) GetRootCertificate() []byte {
-	return copyBytes(ca.rootCertBytes)
+// approve has the identity registry, if any, approve the service account of
+// the namespace in the trust domain.
+func (ca *IstioCA) approve(trustDomain, namespace, serviceAccount string) error {
+	if ca.registry == nil {
+		return nil
+	}
+	return ca.registry.Approve(trustDomain, namespace, serviceAccount)
+}
+
+// awaitApproval returns an ApprovalPendingError or an ApprovalDeniedError if
+// the issuance for the Istio identity, requested on its behalf by the
+// requester if not empty, is not manually approved, see
+// IstioCAOptions.ApprovalQueue.
+func (ca *IstioCA) awaitApproval(id, requester string) error {
+	if ca.approvals == nil {
+		return nil
+	}
+	return ca.approvals.admit(id, requester)
+}
+
+// admit returns a QuotaExceededError if the namespace exceeds its quota, see
+// IstioCAOptions.NamespaceQuota, and otherwise counts the issuance for the ID.
+func (ca *IstioCA) admit(namespace, id string, now time.Time) error {
+	if ca.quotas == nil {
+		return nil
+	}
+	return ca.quotas.admit(namespace, id, now)
+}
+
+// injectFault returns ErrInjectedFault at the injected error rate.
+func (ca *IstioCA) injectFault() error {
+	// #nosec: the injected faults do not need a cryptographically secure source.
+	if ca.injectedErrorRate > 0 && rand.Float64() < ca.injectedErrorRate {
+		return ErrInjectedFault
+	}
+	return nil
+}
+
+// notAfter returns the expiry of a certificate issued by the signer at now,
+// which is the TTL from now unless the signer expires sooner. It must be
+// called with the read lock held.
+func (ca *IstioCA) notAfter(now time.Time, ttl time.Duration, signer *x509.Certificate) (time.Time, error) {
+	notAfter := now.Add(ttl)
+	if signer.NotAfter.Before(notAfter) {
+		glog.Warningf("The signing certificate expires at %v, within the TTL of %v; "+
+			"clamping the certificate expiry", signer.NotAfter, ttl)
+		notAfter = signer.NotAfter
+	}
+	if ttl := notAfter.Sub(now); ttl <= 0 || ttl < ca.minCertTTL {
+		return time.Time{}, fmt.Errorf("the signing certificate expires at %v, too soon to issue a certificate "+
+			"valid for the minimum TTL of %v", signer.NotAfter, ca.minCertTTL)
+	}
+	return notAfter, nil
+}
+
+// AddIssuanceListener registers a listener to be notified of the certificates
+// issued afterwards.
+func (ca *IstioCA) AddIssuanceListener(l IssuanceListener) {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	ca.listeners = append(ca.listeners, l)
+}
+
+// record adds the PEM-encoded certificate issued for the ID to the issuance
+// log and notifies the listeners. It must be called without holding the lock.
+func (ca *IstioCA) record(cert []byte, id, requestedBy string) {
+	c := ParsePemEncodedCertificate(cert)
+	ca.issued.Add(IssuanceRecord{
+		SerialNumber: c.SerialNumber,
+		ID:           id,
+		RequestedBy:  requestedBy,
+		NotBefore:    c.NotBefore,
+		NotAfter:     c.NotAfter,
+	})
+
+	ca.mutex.RLock()
+	listeners := make([]IssuanceListener, len(ca.listeners))
+	copy(listeners, ca.listeners)
+	ca.mutex.RUnlock()
+
+	for _, l := range listeners {
+		l(c)
+	}
+}
+
+func genSelfSignedCACert(opts *SelfSignedIstioCAOptions) (pemCert, pemKey []byte) {
+	now := time.Now()
+	subject := opts.Subject
+	options := CertOptions{
+		NotBefore:      now,
+		NotAfter:       now.Add(opts.CACertTTL),
+		Subject:        &subject,
+		IsCA:           true,
+		IsSelfSigned:   true,
+		MaxPathLen:     opts.MaxPathLen,
+		MaxPathLenZero: opts.MaxPathLen == 0,
+		RSAKeySize:     caKeySize,
+
+		SignatureAlgorithm: opts.SignatureAlgorithm,
+	}
+	if opts.MaxPathLen < 0 {
+		options.MaxPathLen = 0
+	}
+	if opts.NameConstrained {
+		trustDomain := opts.TrustDomain
+		if trustDomain == "" {
+			trustDomain = defaultTrustDomain
+		}
+		options.PermittedURIDomains = []string{trustDomain}
+	}
+	return GenCert(options)
 }
 
 // verify that the cert chain, root cert and signing key/cert match.
-func (ca IstioCA) verify() error {
+func (ca *IstioCA) verify() error {
+	return verifySigningCert(ca.signingCert, ca.certChainBytes, ca.rootCertBytes)
+}
+
+func verifySigningCert(signingCert *x509.Certificate, certChainBytes, rootCertBytes []byte) error {
 	// Create another CertPool to hold the root.
 	rcp := x509.NewCertPool()
-	rcp.AppendCertsFromPEM(ca.rootCertBytes)
+	rcp.AppendCertsFromPEM(rootCertBytes)
 
 	icp := x509.NewCertPool()
-	icp.AppendCertsFromPEM(ca.certChainBytes)
+	icp.AppendCertsFromPEM(certChainBytes)
 
 	opts := x509.VerifyOptions{
 		Intermediates: icp,
 		Roots:         rcp,
 	}
 
-	chains, err := ca.signingCert.Verify(opts)
+	chains, err := signingCert.Verify(opts)
 	if len(chains) == 0 || err != nil {
 		return errors.New(
 			"invalid parameters: cannot verify the signing cert with the provided root chain and cert pool")
@@ -146,6 +929,22 @@ func (ca IstioCA) verify() error {
 	return nil
 }
 
+// verifySigner returns an error unless the signer holds the key of the signing cert.
+func verifySigner(signingCert *x509.Certificate, signer crypto.Signer) error {
+	certKey, err := x509.MarshalPKIXPublicKey(signingCert.PublicKey)
+	if err != nil {
+		return err
+	}
+	signerKey, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return fmt.Errorf("invalid parameters: unsupported signer key (error: %v)", err)
+	}
+	if !bytes.Equal(certKey, signerKey) {
+		return errors.New("invalid parameters: the signer does not hold the key of the signing cert")
+	}
+	return nil
+}
+
 func copyBytes(src []byte) []byte {
 	bs := make([]byte, len(src))
 	copy(bs, src)
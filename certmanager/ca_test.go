@@ -16,9 +16,20 @@ package certmanager
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/asn1"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -27,7 +38,13 @@ func TestSelfSignedIstioCA(t *testing.T) {
 	certTTL := 30 * time.Minute
 	caCertTTL := time.Hour
 	org := "test.ca.org"
-	ca, err := NewSelfSignedIstioCA(caCertTTL, certTTL, org)
+	caOpts := &SelfSignedIstioCAOptions{
+		CACertTTL:  caCertTTL,
+		CertTTL:    certTTL,
+		Subject:    pkix.Name{Organization: []string{org}},
+		MaxPathLen: -1,
+	}
+	ca, err := NewSelfSignedIstioCA(caOpts)
 	if err != nil {
 		t.Errorf("Failed to create a self-signed CA: %v", err)
 	}
@@ -35,7 +52,10 @@ func TestSelfSignedIstioCA(t *testing.T) {
 	name := "foo"
 	namespace := "bar"
 
-	cb, _ := ca.Generate(name, namespace)
+	cb, _, err := ca.Generate(name, namespace)
+	if err != nil {
+		t.Fatalf("Failed to generate a certificate: %v", err)
+	}
 	rcb := ca.GetRootCertificate()
 
 	certPool := x509.NewCertPool()
@@ -86,6 +106,105 @@ func TestSelfSignedIstioCA(t *testing.T) {
 	}
 }
 
+func TestSelfSignedIstioCASubjectAndPathLen(t *testing.T) {
+	subject := pkix.Name{
+		CommonName:         "Istio CA",
+		Country:            []string{"US"},
+		Organization:       []string{"org1", "org2"},
+		OrganizationalUnit: []string{"unit1", "unit2"},
+	}
+
+	testCases := map[string]struct {
+		maxPathLen         int
+		expectedMaxPathLen int
+		expectedZero       bool
+	}{
+		"Unconstrained path length": {
+			maxPathLen:         -1,
+			expectedMaxPathLen: -1,
+		},
+		"Zero path length": {
+			maxPathLen:         0,
+			expectedMaxPathLen: 0,
+			expectedZero:       true,
+		},
+		"Positive path length": {
+			maxPathLen:         2,
+			expectedMaxPathLen: 2,
+		},
+	}
+
+	for k, tc := range testCases {
+		ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+			CACertTTL:  time.Hour,
+			CertTTL:    time.Minute,
+			Subject:    subject,
+			MaxPathLen: tc.maxPathLen,
+		})
+		if err != nil {
+			t.Errorf("%s: failed to create a self-signed CA: %v", k, err)
+			continue
+		}
+
+		rootCert := ParsePemEncodedCertificate(ca.GetRootCertificate())
+		if rootCert.Subject.CommonName != subject.CommonName ||
+			!reflect.DeepEqual(rootCert.Subject.Country, subject.Country) ||
+			!reflect.DeepEqual(rootCert.Subject.Organization, subject.Organization) ||
+			!reflect.DeepEqual(rootCert.Subject.OrganizationalUnit, subject.OrganizationalUnit) {
+			t.Errorf("%s: unexpected CA certificate subject %+v", k, rootCert.Subject)
+		}
+		if rootCert.MaxPathLen != tc.expectedMaxPathLen || rootCert.MaxPathLenZero != tc.expectedZero {
+			t.Errorf("%s: unexpected path length constraint (expecting %d/%t, actual %d/%t)", k,
+				tc.expectedMaxPathLen, tc.expectedZero, rootCert.MaxPathLen, rootCert.MaxPathLenZero)
+		}
+	}
+}
+
+func TestNameConstrainedSelfSignedIstioCA(t *testing.T) {
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:       time.Hour,
+		CertTTL:         time.Minute,
+		Subject:         pkix.Name{Organization: []string{"test.ca.org"}},
+		MaxPathLen:      0,
+		NameConstrained: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+
+	rootCert := ParsePemEncodedCertificate(ca.GetRootCertificate())
+	if !reflect.DeepEqual(rootCert.PermittedURIDomains, []string{defaultTrustDomain}) || !rootCert.PermittedDNSDomainsCritical {
+		t.Errorf("Unexpected name constraints: %v (critical: %t)",
+			rootCert.PermittedURIDomains, rootCert.PermittedDNSDomainsCritical)
+	}
+
+	rootPool := x509.NewCertPool()
+	rootPool.AppendCertsFromPEM(ca.GetRootCertificate())
+	verifyOpts := x509.VerifyOptions{Roots: rootPool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+
+	cb, _, err := ca.Generate("foo", "bar")
+	if err != nil {
+		t.Fatalf("Failed to generate a certificate: %v", err)
+	}
+	if _, err := ParsePemEncodedCertificate(cb).Verify(verifyOpts); err != nil {
+		t.Errorf("Failed to verify a certificate within the trust domain: %v", err)
+	}
+
+	// A certificate for an identity outside of the trust domain must be rejected.
+	outsider, _ := GenCert(CertOptions{
+		Host:       "spiffe://other.domain/ns/bar/sa/foo",
+		NotBefore:  time.Now(),
+		NotAfter:   time.Now().Add(time.Minute),
+		SignerCert: ca.signingCert,
+		SignerPriv: ca.signingKey,
+		IsClient:   true,
+		RSAKeySize: 512,
+	})
+	if _, err := ParsePemEncodedCertificate(outsider).Verify(verifyOpts); err == nil {
+		t.Errorf("Expecting a certificate outside of the trust domain to fail verification")
+	}
+}
+
 // Pass in unmatched chain and cert to make sure the `verify` method yeilds an error.
 func TestInvalidIstioCAOptions(t *testing.T) {
 	rootCert := `
@@ -180,3 +299,595 @@ RRoQIlr5T8PG4vXwsn2/hohILCJJyHAee/4gIq42jLu6hQsQxcoy
 		t.Errorf("Unexpected error message: expecting '%s' but the actual is '%s'", errMsg, err.Error())
 	}
 }
+
+func TestGenerateServerCert(t *testing.T) {
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:  time.Hour,
+		CertTTL:    30 * time.Minute,
+		Subject:    pkix.Name{Organization: []string{"test.ca.org"}},
+		MaxPathLen: -1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+
+	hosts := []string{"foo.com", "bar.foo.com"}
+	cb, _, err := ca.GenerateServerCert(hosts)
+	if err != nil {
+		t.Fatalf("Failed to generate a server certificate: %v", err)
+	}
+
+	cert := ParsePemEncodedCertificate(cb)
+	if !reflect.DeepEqual(cert.DNSNames, hosts) {
+		t.Errorf("Unexpected DNS names (expecting %v, actual %v)", hosts, cert.DNSNames)
+	}
+	if !reflect.DeepEqual(cert.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}) {
+		t.Errorf("Unexpected extended key usages: %v", cert.ExtKeyUsage)
+	}
+
+	rootPool := x509.NewCertPool()
+	rootPool.AppendCertsFromPEM(ca.GetRootCertificate())
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "bar.foo.com", Roots: rootPool}); err != nil {
+		t.Errorf("Failed to verify the server certificate: %v", err)
+	}
+}
+
+func TestTrustDomain(t *testing.T) {
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:       time.Hour,
+		CertTTL:         time.Minute,
+		MaxPathLen:      -1,
+		NameConstrained: true,
+		TrustDomain:     "tenant.com",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+
+	cb, _, err := ca.Generate("foo", "bar")
+	if err != nil {
+		t.Fatalf("Failed to generate a certificate: %v", err)
+	}
+	cert := ParsePemEncodedCertificate(cb)
+	if len(cert.URIs) != 1 || cert.URIs[0].String() != "spiffe://tenant.com/ns/bar/sa/foo" {
+		t.Errorf("Unexpected URI SANs: %v", cert.URIs)
+	}
+	root := ParsePemEncodedCertificate(ca.GetRootCertificate())
+	if !reflect.DeepEqual(root.PermittedURIDomains, []string{"tenant.com"}) {
+		t.Errorf("Unexpected permitted URI domains: %v", root.PermittedURIDomains)
+	}
+}
+
+func TestSign(t *testing.T) {
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:   time.Hour,
+		CertTTL:     30 * time.Minute,
+		MaxPathLen:  -1,
+		NoKeyEscrow: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+
+	if _, _, err := ca.Generate("foo", "bar"); err != ErrKeyEscrowDisabled {
+		t.Errorf("Expecting key generation to be refused but got error %v", err)
+	}
+	if _, _, err := ca.GenerateServerCert([]string{"foo.com"}); err != ErrKeyEscrowDisabled {
+		t.Errorf("Expecting key generation to be refused but got error %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := url.Parse("spiffe://cluster.local/ns/bar/sa/foo")
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{URIs: []*url.URL{id}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, err := ca.Sign(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	if err != nil {
+		t.Fatalf("Failed to sign the certificate signing request: %v", err)
+	}
+
+	cert := ParsePemEncodedCertificate(cb)
+	if len(cert.URIs) != 1 || cert.URIs[0].String() != id.String() {
+		t.Errorf("Unexpected URI SANs (expecting %v, actual %v)", id, cert.URIs)
+	}
+	if pub, ok := cert.PublicKey.(*ecdsa.PublicKey); !ok || pub.X.Cmp(key.X) != 0 {
+		t.Error("The certificate does not carry the public key of the certificate signing request")
+	}
+	rootPool := x509.NewCertPool()
+	rootPool.AppendCertsFromPEM(ca.GetRootCertificate())
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: rootPool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		t.Errorf("Failed to verify the certificate: %v", err)
+	}
+	if n := len(ca.IssuedCertificates()); n != 1 {
+		t.Errorf("Expecting 1 recorded issuance but got %d", n)
+	}
+
+	const requester = "spiffe://cluster.local/ns/istio-system/sa/controller"
+	if _, err := ca.SignOnBehalf(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}),
+		requester); err != nil {
+		t.Fatalf("Failed to sign the certificate signing request on behalf of %s: %v", id, err)
+	}
+	delegated := 0
+	for _, r := range ca.IssuedCertificates() {
+		if r.RequestedBy == requester {
+			delegated++
+		}
+	}
+	if delegated != 1 {
+		t.Errorf("Expecting 1 issuance recorded as requested by %s but got %d", requester, delegated)
+	}
+
+	cb, err = ca.SignShortLived(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), 5*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to sign a short-lived certificate: %v", err)
+	}
+	if ttl := time.Until(ParsePemEncodedCertificate(cb).NotAfter); ttl > 5*time.Minute {
+		t.Errorf("Expecting the short-lived certificate to expire within 5m, but it expires in %v", ttl)
+	}
+
+	if _, err := ca.Sign([]byte("invalid")); err == nil {
+		t.Error("Expecting an invalid certificate signing request to be rejected")
+	}
+
+	// Other URIs would bypass the approval and the quotas of the Istio identities.
+	other, _ := url.Parse("spiffe://cluster.local/ns/bar/anything")
+	der, err = x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{URIs: []*url.URL{id, other}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ca.Sign(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})); err == nil {
+		t.Error("Expecting a certificate signing request with a non-Istio URI to be rejected")
+	}
+}
+
+func TestRecertify(t *testing.T) {
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:  time.Hour,
+		CertTTL:    30 * time.Minute,
+		MaxPathLen: -1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+
+	chain, key, err := ca.Generate("foo", "bar")
+	if err != nil {
+		t.Fatalf("Failed to generate a certificate: %v", err)
+	}
+	renewed, err := ca.Recertify("foo", "bar", key)
+	if err != nil {
+		t.Fatalf("Failed to re-certify the key: %v", err)
+	}
+
+	oldCert, newCert := ParsePemEncodedCertificate(chain), ParsePemEncodedCertificate(renewed)
+	if oldCert.SerialNumber.Cmp(newCert.SerialNumber) == 0 {
+		t.Error("Expecting the re-certified key to get a new certificate")
+	}
+	if _, err := tls.X509KeyPair(renewed, key); err != nil {
+		t.Errorf("The new certificate does not certify the key: %v", err)
+	}
+	if !reflect.DeepEqual(oldCert.URIs, newCert.URIs) {
+		t.Errorf("Unexpected URI SANs (expecting %v, actual %v)", oldCert.URIs, newCert.URIs)
+	}
+	if n := len(ca.IssuedCertificates()); n != 2 {
+		t.Errorf("Expecting 2 recorded issuances but got %d", n)
+	}
+
+	if _, err := ca.Recertify("foo", "bar", []byte("invalid")); err == nil {
+		t.Error("Expecting an invalid key to be rejected")
+	}
+}
+
+func TestIssuanceListener(t *testing.T) {
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:  time.Hour,
+		CertTTL:    30 * time.Minute,
+		MaxPathLen: -1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+
+	var notified []*x509.Certificate
+	ca.AddIssuanceListener(func(cert *x509.Certificate) {
+		// The listener must be able to query the CA.
+		ca.GetRootCertificate()
+		notified = append(notified, cert)
+	})
+
+	chain, _, err := ca.Generate("foo", "bar")
+	if err != nil {
+		t.Fatalf("Failed to generate a certificate: %v", err)
+	}
+	if len(notified) != 1 {
+		t.Fatalf("Expecting 1 notified issuance but got %d", len(notified))
+	}
+	if cert := ParsePemEncodedCertificate(chain); cert.SerialNumber.Cmp(notified[0].SerialNumber) != 0 {
+		t.Errorf("Unexpected notified serial number (expecting %v, actual %v)",
+			cert.SerialNumber, notified[0].SerialNumber)
+	}
+}
+
+func TestStateStore(t *testing.T) {
+	store := newFakeStateStore()
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:  time.Hour,
+		CertTTL:    30 * time.Minute,
+		MaxPathLen: -1,
+		StateStore: store,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+
+	chain, _, err := ca.Generate("foo", "bar")
+	if err != nil {
+		t.Fatalf("Failed to generate a certificate: %v", err)
+	}
+	cert := ParsePemEncodedCertificate(chain)
+	if _, ok := store.issuances[cert.SerialNumber.String()]; !ok {
+		t.Errorf("The issuance of %v has not been persisted", cert.SerialNumber)
+	}
+	if store.last.IsZero() {
+		t.Errorf("The latest issuance time has not been persisted")
+	}
+	if n := len(ca.IssuedCertificates()); n != 1 {
+		t.Errorf("Expecting 1 issued certificate but got %d", n)
+	}
+}
+
+func TestInjectedErrorRate(t *testing.T) {
+	testCases := map[string]struct {
+		rate      float64
+		expectErr error
+	}{
+		"No injected errors": {rate: 0},
+		"Every issuance fails": {
+			rate:      1,
+			expectErr: ErrInjectedFault,
+		},
+	}
+
+	for k, tc := range testCases {
+		ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+			CACertTTL:         time.Hour,
+			CertTTL:           30 * time.Minute,
+			MaxPathLen:        -1,
+			InjectedErrorRate: tc.rate,
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to create a self-signed CA: %v", k, err)
+		}
+
+		_, key, err := ca.Generate("foo", "bar")
+		if err != tc.expectErr {
+			t.Errorf("%s: expecting error %v from Generate, but got %v", k, tc.expectErr, err)
+		}
+		if err == nil {
+			if _, err := ca.Recertify("foo", "bar", key); err != tc.expectErr {
+				t.Errorf("%s: expecting error %v from Recertify, but got %v", k, tc.expectErr, err)
+			}
+		}
+		if _, err := ca.SignOnBehalf([]byte("invalid"), ""); tc.expectErr != nil && err != tc.expectErr {
+			t.Errorf("%s: expecting error %v from Sign, but got %v", k, tc.expectErr, err)
+		}
+	}
+}
+
+// fakeRegistry approves the service accounts of the approved namespace.
+type fakeRegistry struct {
+	approved []string
+}
+
+func (r *fakeRegistry) Approve(trustDomain, namespace, serviceAccount string) error {
+	if namespace != "approved" {
+		return fmt.Errorf("service account %s/%s is not approved", namespace, serviceAccount)
+	}
+	r.approved = append(r.approved, trustDomain+"/"+namespace+"/"+serviceAccount)
+	return nil
+}
+
+func TestIdentityRegistry(t *testing.T) {
+	registry := &fakeRegistry{}
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:        time.Hour,
+		CertTTL:          30 * time.Minute,
+		MaxPathLen:       -1,
+		IdentityRegistry: registry,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+
+	_, key, err := ca.Generate("foo", "approved")
+	if err != nil {
+		t.Fatalf("Failed to generate the certificate of an approved identity: %v", err)
+	}
+	if _, err := ca.Recertify("foo", "approved", key); err != nil {
+		t.Errorf("Failed to re-certify the key of an approved identity: %v", err)
+	}
+	if _, _, err := ca.Generate("foo", "unknown"); err == nil {
+		t.Error("Expecting the issuance for an unapproved identity to be refused")
+	}
+	if _, err := ca.Recertify("foo", "unknown", key); err == nil {
+		t.Error("Expecting the re-certification for an unapproved identity to be refused")
+	}
+	if _, _, err := ca.GenerateServerCert([]string{"foo.com"}); err != nil {
+		t.Errorf("Failed to generate a server certificate, which has no Istio identity: %v", err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"spiffe://tenant.com/ns/approved/sa/bar", "spiffe://tenant.com/ns/unknown/sa/bar"} {
+		id, _ := url.Parse(s)
+		der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{URIs: []*url.URL{id}}, ecKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ca.Sign(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+		if approved := strings.Contains(s, "/approved/"); approved != (err == nil) {
+			t.Errorf("Unexpected error signing for %s: %v", s, err)
+		}
+	}
+
+	expected := []string{
+		"cluster.local/approved/foo",
+		"cluster.local/approved/foo",
+		"tenant.com/approved/bar",
+	}
+	if !reflect.DeepEqual(registry.approved, expected) {
+		t.Errorf("Expecting the approvals %v but got %v", expected, registry.approved)
+	}
+}
+
+func TestCertTTLBounds(t *testing.T) {
+	if _, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:  time.Hour,
+		CertTTL:    time.Second,
+		MaxPathLen: -1,
+		MinCertTTL: time.Minute,
+	}); err == nil {
+		t.Error("Expecting a certificate TTL below the minimum to be rejected")
+	}
+
+	if _, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:  20 * time.Minute,
+		CertTTL:    time.Hour,
+		MaxPathLen: -1,
+	}); err == nil {
+		t.Error("Expecting a certificate TTL beyond the lifetime of the CA certificate to be rejected")
+	}
+
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:  time.Hour,
+		CertTTL:    time.Hour,
+		MaxPathLen: -1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+	// The root certificate expires within the certificate TTL once the CA is
+	// a little older.
+	ca.certTTL = time.Hour + time.Minute
+	chain, _, err := ca.Generate("foo", "bar")
+	if err != nil {
+		t.Fatalf("Failed to generate a certificate: %v", err)
+	}
+	root := ParsePemEncodedCertificate(ca.GetRootCertificate())
+	if cert := ParsePemEncodedCertificate(chain); !cert.NotAfter.Equal(root.NotAfter) {
+		t.Errorf("Expecting the certificate expiry to be clamped to %v, but got %v", root.NotAfter, cert.NotAfter)
+	}
+
+	ca.minCertTTL = 2 * time.Hour
+	ca.certTTL = 2 * time.Hour
+	if _, _, err := ca.Generate("foo", "bar"); err == nil {
+		t.Error("Expecting issuance to be refused when the clamped TTL is below the minimum")
+	}
+}
+
+func TestRotateRoot(t *testing.T) {
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:  time.Hour,
+		CertTTL:    time.Minute,
+		Subject:    pkix.Name{Organization: []string{"test.ca.org"}},
+		MaxPathLen: -1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+
+	oldRoot := ca.GetRootCertificate()
+	if err := ca.RotateRoot(); err != nil {
+		t.Fatalf("Failed to rotate the root: %v", err)
+	}
+	newRoot := ca.GetRootCertificate()
+	if bytes.Equal(oldRoot, newRoot) {
+		t.Errorf("Root certificate is not changed by rotation")
+	}
+
+	cb, _, err := ca.Generate("foo", "bar")
+	if err != nil {
+		t.Fatalf("Failed to generate a certificate: %v", err)
+	}
+	rootPool := x509.NewCertPool()
+	rootPool.AppendCertsFromPEM(newRoot)
+	cert := ParsePemEncodedCertificate(cb)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: rootPool}); err != nil {
+		t.Errorf("Failed to verify a certificate against the rotated root: %v", err)
+	}
+
+	records := ca.IssuedCertificates()
+	if len(records) != 1 || records[0].SerialNumber.Cmp(cert.SerialNumber) != 0 ||
+		records[0].ID != "spiffe://cluster.local/ns/bar/sa/foo" {
+		t.Errorf("Unexpected issuance records: %v", records)
+	}
+}
+
+// deviceSigner only exposes the crypto.Signer of a key, like a key device.
+type deviceSigner struct {
+	crypto.Signer
+	signed int
+}
+
+func (s *deviceSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.signed++
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+func TestSigner(t *testing.T) {
+	selfSigned, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:  time.Hour,
+		CertTTL:    time.Minute,
+		Subject:    pkix.Name{Organization: []string{"test.ca.org"}},
+		MaxPathLen: -1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+	rootCert := selfSigned.GetRootCertificate()
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		signer      crypto.Signer
+		expectedErr string
+	}{
+		"Key of the signing cert": {
+			signer: selfSigned.signingKey.(crypto.Signer),
+		},
+		"Another key": {
+			signer:      otherKey,
+			expectedErr: "invalid parameters: the signer does not hold the key of the signing cert",
+		},
+	}
+	for id, tc := range testCases {
+		signer := &deviceSigner{Signer: tc.signer}
+		ca, err := NewIstioCA(&IstioCAOptions{
+			CertTTL:          time.Minute,
+			SigningCertBytes: rootCert,
+			RootCertBytes:    rootCert,
+			Signer:           signer,
+		})
+		if tc.expectedErr != "" {
+			if err == nil || err.Error() != tc.expectedErr {
+				t.Errorf("%s: expected error %q, got %v", id, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: failed to create the CA: %v", id, err)
+			continue
+		}
+
+		chain, _, err := ca.Generate("foo", "bar")
+		if err != nil {
+			t.Errorf("%s: failed to generate a certificate: %v", id, err)
+			continue
+		}
+		if signer.signed != 1 {
+			t.Errorf("%s: the signer signed %d times, expected once", id, signer.signed)
+		}
+		rootPool := x509.NewCertPool()
+		rootPool.AppendCertsFromPEM(rootCert)
+		verifyOpts := x509.VerifyOptions{Roots: rootPool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+		if _, err := ParsePemEncodedCertificate(chain).Verify(verifyOpts); err != nil {
+			t.Errorf("%s: failed to verify the certificate: %v", id, err)
+		}
+	}
+}
+
+func TestUpdateSigningMaterial(t *testing.T) {
+	selfSignedOpts := &SelfSignedIstioCAOptions{
+		CACertTTL:  time.Hour,
+		Subject:    pkix.Name{Organization: []string{"test.ca.org"}},
+		MaxPathLen: -1,
+	}
+	oldCert, oldKey := genSelfSignedCACert(selfSignedOpts)
+	newCert, newKey := genSelfSignedCACert(selfSignedOpts)
+
+	ca, err := NewIstioCA(&IstioCAOptions{
+		CertTTL:          time.Minute,
+		SigningCertBytes: oldCert,
+		SigningKeyBytes:  oldKey,
+		RootCertBytes:    oldCert,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create an Istio CA: %v", err)
+	}
+
+	// The new signing cert does not chain to the old root.
+	if err := ca.UpdateSigningMaterial(nil, newCert, newKey, oldCert); err == nil {
+		t.Error("Expecting signing material failing the verification to be rejected")
+	}
+	if err := ca.UpdateSigningMaterial(nil, newCert, []byte("not a key"), newCert); err == nil {
+		t.Error("Expecting a malformed signing key to be rejected")
+	}
+	if !bytes.Equal(ca.GetRootCertificate(), oldCert) {
+		t.Fatal("The root certificate is replaced by rejected signing material")
+	}
+
+	if err := ca.UpdateSigningMaterial(nil, newCert, newKey, newCert); err != nil {
+		t.Fatalf("Failed to update the signing material: %v", err)
+	}
+	if !bytes.Equal(ca.GetRootCertificate(), newCert) {
+		t.Error("The root certificate is not replaced")
+	}
+	cb, _, err := ca.Generate("foo", "bar")
+	if err != nil {
+		t.Fatalf("Failed to generate a certificate: %v", err)
+	}
+	rootPool := x509.NewCertPool()
+	rootPool.AppendCertsFromPEM(newCert)
+	if _, err := ParsePemEncodedCertificate(cb).Verify(x509.VerifyOptions{Roots: rootPool}); err != nil {
+		t.Errorf("Failed to verify a certificate against the new root: %v", err)
+	}
+
+	selfSigned, err := NewSelfSignedIstioCA(selfSignedOpts)
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+	if err := selfSigned.UpdateSigningMaterial(nil, newCert, newKey, newCert); err == nil {
+		t.Error("Expecting the signing material of a self-signed CA to be immutable")
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:  time.Hour,
+		CertTTL:    time.Minute,
+		Subject:    pkix.Name{Organization: []string{"test.ca.org"}},
+		MaxPathLen: -1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+
+	if err := ca.SelfTest(); err != nil {
+		t.Errorf("Self-test of a valid CA failed: %v", err)
+	}
+	if records := ca.IssuedCertificates(); len(records) != 0 {
+		t.Errorf("Self-test should not record issuances, got %v", records)
+	}
+
+	// Replacing the root with an unrelated certificate breaks the chain.
+	other, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:  time.Hour,
+		CertTTL:    time.Minute,
+		MaxPathLen: -1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+	ca.rootCertBytes = other.GetRootCertificate()
+	if err := ca.SelfTest(); err == nil {
+		t.Error("Self-test of a CA with a mismatched root should fail")
+	}
+}
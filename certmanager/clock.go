@@ -0,0 +1,159 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The amount by which the clock may step backward without being considered rolled back.
+const clockRollbackTolerance = 5 * time.Second
+
+var clockRollbacks = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "istio_ca",
+	Name:      "clock_rollbacks_total",
+	Help:      "The number of issuances refused because the clock has been rolled back.",
+})
+
+func init() {
+	prometheus.MustRegister(clockRollbacks)
+}
+
+// IssuanceTimeStore persists the time of the latest issuance across restarts.
+type IssuanceTimeStore interface {
+	// Load returns the persisted time, or the zero time if none is persisted.
+	Load() (time.Time, error)
+	Store(t time.Time) error
+}
+
+// FileIssuanceTimeStore is an IssuanceTimeStore backed by a file holding an RFC 3339 timestamp.
+type FileIssuanceTimeStore struct {
+	Path string
+}
+
+// Load implements IssuanceTimeStore.
+func (s FileIssuanceTimeStore) Load() (time.Time, error) {
+	bs, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, strings.TrimSpace(string(bs)))
+}
+
+// Store implements IssuanceTimeStore. The file is replaced atomically.
+func (s FileIssuanceTimeStore) Store(t time.Time) error {
+	tmp := s.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(t.UTC().Format(time.RFC3339Nano)+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
+
+// issuanceClock hands out the NotBefore times of issued certificates and
+// refuses to go backward: neither behind the wall time expected from the
+// monotonic clock since startup, nor behind the latest issuance, which is
+// persisted if a store is configured.
+type issuanceClock struct {
+	mutex sync.Mutex
+
+	store IssuanceTimeStore
+	last  time.Time
+
+	// The time of construction, which carries a monotonic clock reading.
+	start time.Time
+
+	// Issuance is refused if the measured skew exceeds maxSkew, unless it is zero.
+	skew    *ClockSkewMonitor
+	maxSkew time.Duration
+
+	now func() time.Time
+}
+
+func newIssuanceClock(store IssuanceTimeStore) (*issuanceClock, error) {
+	c := &issuanceClock{store: store, start: time.Now(), now: time.Now}
+	if store != nil {
+		last, err := store.Load()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the latest issuance time (error: %v)", err)
+		}
+		c.last = last
+	}
+	return c, nil
+}
+
+// Next returns the current time to be used as the NotBefore of a certificate,
+// or an error if the clock has been rolled back or is skewed too much.
+func (c *issuanceClock) Next() (time.Time, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.skew != nil && c.maxSkew > 0 {
+		if skew, ok := c.skew.Skew(); ok && (skew > c.maxSkew || skew < -c.maxSkew) {
+			glog.Errorf("Refusing to issue a certificate: the clock skew %v exceeds %v", skew, c.maxSkew)
+			return time.Time{}, &ClockError{
+				Reason: fmt.Sprintf("the clock skew %v exceeds the maximum of %v", skew, c.maxSkew),
+			}
+		}
+	}
+
+	now := c.now()
+	// Round(0) strips the monotonic reading, so that the wall clocks are compared.
+	if expected := c.start.Add(time.Since(c.start)); now.Round(0).Before(expected.Round(0).Add(-clockRollbackTolerance)) {
+		return time.Time{}, c.rollback(now, expected, "the wall clock is behind the monotonic clock")
+	}
+	if now.Before(c.last.Add(-clockRollbackTolerance)) {
+		return time.Time{}, c.rollback(now, c.last, "the wall clock is behind the latest issuance")
+	}
+
+	if now.After(c.last) {
+		c.last = now
+		if c.store != nil {
+			if err := c.store.Store(now); err != nil {
+				glog.Errorf("Failed to persist the latest issuance time (error: %v)", err)
+			}
+		}
+	}
+	return now, nil
+}
+
+func (c *issuanceClock) rollback(now, expected time.Time, reason string) error {
+	clockRollbacks.Inc()
+	glog.Errorf("Refusing to issue a certificate: clock rollback detected, %s (now %v, expected at least %v)",
+		reason, now, expected)
+	return &ClockError{
+		Reason: fmt.Sprintf("clock rollback detected: %s (now %v, expected at least %v)", reason, now, expected),
+	}
+}
+
+// ClockError is returned when issuance is refused as the clock of the CA has
+// been rolled back or is skewed too much. It persists until an operator
+// corrects the clock.
+type ClockError struct {
+	Reason string
+}
+
+func (e *ClockError) Error() string {
+	return e.Reason
+}
@@ -0,0 +1,139 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type memIssuanceTimeStore struct {
+	t time.Time
+}
+
+func (s *memIssuanceTimeStore) Load() (time.Time, error) {
+	return s.t, nil
+}
+
+func (s *memIssuanceTimeStore) Store(t time.Time) error {
+	s.t = t
+	return nil
+}
+
+func TestIssuanceClock(t *testing.T) {
+	testCases := map[string]struct {
+		persisted   time.Duration
+		offsets     []time.Duration
+		expectedErr bool
+	}{
+		"Clock moving forward": {
+			offsets: []time.Duration{0, time.Second, time.Minute},
+		},
+		"Small step backward is tolerated": {
+			offsets: []time.Duration{0, -time.Second},
+		},
+		"Rollback behind the monotonic clock": {
+			offsets:     []time.Duration{-time.Hour},
+			expectedErr: true,
+		},
+		"Rollback behind the persisted issuance": {
+			persisted:   time.Hour,
+			offsets:     []time.Duration{time.Hour, 0},
+			expectedErr: true,
+		},
+	}
+
+	for k, tc := range testCases {
+		base := time.Now().Round(0)
+		store := &memIssuanceTimeStore{}
+		if tc.persisted != 0 {
+			store.t = base.Add(tc.persisted)
+		}
+		c, err := newIssuanceClock(store)
+		if err != nil {
+			t.Fatalf("%s: failed to create the clock: %v", k, err)
+		}
+
+		var last time.Time
+		for i, offset := range tc.offsets {
+			now := base.Add(offset)
+			c.now = func() time.Time { return now }
+			last, err = c.Next()
+			if err != nil {
+				if !tc.expectedErr || i != len(tc.offsets)-1 {
+					t.Errorf("%s: unexpected error at step %d: %v", k, i, err)
+				}
+				break
+			}
+			if !last.Equal(now) {
+				t.Errorf("%s: expect %v but got %v", k, now, last)
+			}
+		}
+		if tc.expectedErr && err == nil {
+			t.Errorf("%s: expect a rollback error but got none", k)
+		}
+		if !tc.expectedErr && store.t.Before(last) {
+			t.Errorf("%s: expect the persisted time %v to be no earlier than %v", k, store.t, last)
+		}
+	}
+}
+
+func TestFileIssuanceTimeStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "issuance-time")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := FileIssuanceTimeStore{Path: filepath.Join(dir, "last-issuance")}
+	if loaded, err := store.Load(); err != nil || !loaded.IsZero() {
+		t.Errorf("expect the zero time for a missing file but got %v (error: %v)", loaded, err)
+	}
+
+	now := time.Now()
+	if err := store.Store(now); err != nil {
+		t.Fatalf("failed to store the time: %v", err)
+	}
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatalf("failed to load the time: %v", err)
+	}
+	if !loaded.Equal(now.Round(0)) {
+		t.Errorf("expect %v but got %v", now, loaded)
+	}
+}
+
+func TestIssueAfterRollback(t *testing.T) {
+	store := &memIssuanceTimeStore{t: time.Now().Add(time.Hour)}
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:         time.Hour,
+		CertTTL:           time.Minute,
+		MaxPathLen:        -1,
+		IssuanceTimeStore: store,
+	})
+	if err != nil {
+		t.Fatalf("failed to create a self-signed CA: %v", err)
+	}
+
+	if _, _, err := ca.Generate("foo", "bar"); err == nil {
+		t.Error("expect issuance to be refused after a clock rollback")
+	}
+	if n := len(ca.IssuedCertificates()); n != 0 {
+		t.Errorf("expect no recorded issuance but got %d", n)
+	}
+}
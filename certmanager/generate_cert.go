@@ -21,7 +21,6 @@ package certmanager
 import (
 	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
@@ -54,9 +53,22 @@ type CertOptions struct {
 	// Organization for this certificate.
 	Org string
 
+	// The full subject of this certificate. When set, it takes precedence over Org.
+	Subject *pkix.Name
+
 	// Whether this certificate should be a Cerificate Authority.
 	IsCA bool
 
+	// The path length constraint of a CA certificate, with the same semantics
+	// as the fields of `x509.Certificate`: MaxPathLen 0 without MaxPathLenZero
+	// leaves the path length unconstrained.
+	MaxPathLen     int
+	MaxPathLenZero bool
+
+	// The URI domains a CA certificate permits in the certificates it signs.
+	// When set, the name constraints extension is marked critical.
+	PermittedURIDomains []string
+
 	// Whether this cerificate is self-signed.
 	IsSelfSigned bool
 
@@ -68,6 +80,9 @@ type CertOptions struct {
 
 	// The size of RSA private key to be generated.
 	RSAKeySize int
+
+	// The algorithm to sign the certificate with; the default of the signer key if zero.
+	SignatureAlgorithm x509.SignatureAlgorithm
 }
 
 const (
@@ -90,7 +105,7 @@ func GenCert(options CertOptions) ([]byte, []byte) {
 	// private key will be used to sign this certificate in the self-signed
 	// case, otherwise the certificate is signed by the signer private key
 	// as specified in the CertOptions.
-	priv, err := rsa.GenerateKey(rand.Reader, options.RSAKeySize)
+	priv, err := takeRSAKey(options.RSAKeySize)
 	if err != nil {
 		glog.Fatalf("RSA key generation failed with error %s.", err)
 	}
@@ -99,7 +114,7 @@ func GenCert(options CertOptions) ([]byte, []byte) {
 	if !options.IsSelfSigned {
 		signerCert, signerKey = options.SignerCert, options.SignerPriv
 	}
-	certBytes, err := x509.CreateCertificate(rand.Reader, &template, signerCert, &priv.PublicKey, signerKey)
+	certBytes, err := createCertificate(&template, signerCert, &priv.PublicKey, signerKey)
 	if err != nil {
 		glog.Fatalf("Could not create certificate (err = %s).", err)
 	}
@@ -110,6 +125,7 @@ func GenCert(options CertOptions) ([]byte, []byte) {
 
 	privDer := x509.MarshalPKCS1PrivateKey(priv)
 	privPem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: privDer})
+	wipeBytes(privDer)
 	return certPem, privPem
 }
 
@@ -135,7 +151,7 @@ func LoadSignerCredsFromFiles(signerCertFile string, signerPrivFile string) (*x5
 
 func genSerialNum() *big.Int {
 	serialNumLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNum, err := rand.Int(rand.Reader, serialNumLimit)
+	serialNum, err := rand.Int(RandomSource(), serialNumLimit)
 	if err != nil {
 		glog.Fatalf("Failed to generate serial number: %s.", err)
 	}
@@ -161,16 +177,22 @@ func genCertTemplate(options CertOptions) x509.Certificate {
 		extKeyUsages = append(extKeyUsages, x509.ExtKeyUsageClientAuth)
 	}
 
+	subject := pkix.Name{
+		Organization: []string{options.Org},
+	}
+	if options.Subject != nil {
+		subject = *options.Subject
+	}
+
 	template := x509.Certificate{
-		SerialNumber: genSerialNum(),
-		Subject: pkix.Name{
-			Organization: []string{options.Org},
-		},
+		SerialNumber:          genSerialNum(),
+		Subject:               subject,
 		NotBefore:             options.NotBefore,
 		NotAfter:              options.NotAfter,
 		KeyUsage:              keyUsage,
 		ExtKeyUsage:           extKeyUsages,
 		BasicConstraintsValid: true,
+		SignatureAlgorithm:    options.SignatureAlgorithm,
 	}
 
 	if h := options.Host; len(h) > 0 {
@@ -181,6 +203,14 @@ func genCertTemplate(options CertOptions) x509.Certificate {
 	if options.IsCA {
 		template.IsCA = true
 		template.KeyUsage |= x509.KeyUsageCertSign
+		template.MaxPathLen = options.MaxPathLen
+		template.MaxPathLenZero = options.MaxPathLenZero
+
+		if len(options.PermittedURIDomains) > 0 {
+			template.PermittedURIDomains = options.PermittedURIDomains
+			// Despite its name, this marks the whole name constraints extension critical.
+			template.PermittedDNSDomainsCritical = true
+		}
 	}
 	return template
 }
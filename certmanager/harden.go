@@ -0,0 +1,74 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"fmt"
+	"regexp"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/golang/glog"
+)
+
+// Whether the hardening mode is enabled, see EnableHardening; accessed atomically.
+var hardened int32
+
+var (
+	// PEM blocks, e.g. of private keys.
+	pemBlockPattern = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]+-----[\s\S]*?-----END [A-Z0-9 ]+-----`)
+	// Long runs of base64 or hexadecimal characters, e.g. encoded keys.
+	encodedRunPattern = regexp.MustCompile(`[A-Za-z0-9+/]{48,}={0,2}`)
+	// Byte slices formatted with %v, e.g. [48 130 4 164 ...].
+	byteSlicePattern = regexp.MustCompile(`\[(?:\d{1,3} ){15,}\d{1,3}\]`)
+)
+
+// EnableHardening disables the core dumps of the process, and makes the key
+// generations and the certificate signings recover from panics, e.g. of an
+// external signer, into errors. The panics are logged with their stack, from
+// which anything that may be key material is redacted.
+func EnableHardening() error {
+	if err := disableCoreDumps(); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&hardened, 1)
+	return nil
+}
+
+// recoverCryptoPanic turns a panic into an error returned through err once
+// the hardening mode is enabled. It must be deferred by the crypto function
+// performing op, e.g. "sign the certificate"; without the hardening mode, it
+// does not recover, so that the panic crashes the CA as usual.
+func recoverCryptoPanic(op string, err *error) {
+	if atomic.LoadInt32(&hardened) == 0 {
+		return
+	}
+	r := recover()
+	if r == nil {
+		return
+	}
+	glog.Errorf("Recovered from a panic while trying to %s: %s\n%s", op, redact(fmt.Sprint(r)),
+		redact(string(debug.Stack())))
+	// The panic value is only logged, as the error may reach the clients.
+	*err = fmt.Errorf("internal error while trying to %s", op)
+}
+
+// redact replaces what may be key material in s, i.e. PEM blocks, long runs
+// of encoded bytes and formatted byte slices, with placeholders.
+func redact(s string) string {
+	s = pemBlockPattern.ReplaceAllString(s, "[REDACTED PEM]")
+	s = byteSlicePattern.ReplaceAllString(s, "[REDACTED BYTES]")
+	return encodedRunPattern.ReplaceAllString(s, "[REDACTED]")
+}
@@ -0,0 +1,200 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"crypto/x509"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const pruneInterval = time.Minute
+
+// IssuanceRecord describes a certificate issued by the CA.
+type IssuanceRecord struct {
+	SerialNumber *big.Int
+
+	// The identity (or comma-separated hosts) the certificate is issued for.
+	// For the intermediate CA of a namespace, it is the namespace URI, e.g.
+	// spiffe://cluster.local/ns/foo.
+	ID string
+
+	// The identity that requested the certificate on behalf of ID, or empty
+	// if the certificate is not requested by a delegate.
+	RequestedBy string
+
+	NotBefore, NotAfter time.Time
+}
+
+// IssuanceListener is invoked with every certificate issued by the CA.
+type IssuanceListener func(cert *x509.Certificate)
+
+// IssuanceLog is a thread-safe record of the issued certificates, kept in
+// memory and, if the log is persistent, written through to a StateStore.
+// Records of expired certificates are dropped at most once per pruneInterval.
+type IssuanceLog struct {
+	mutex sync.RWMutex
+
+	// Maps from the string form of a serial number to the issuance record.
+	records map[string]IssuanceRecord
+
+	lastPruned time.Time
+
+	// If not nil, the records are read from the store, which holds those of
+	// the other replicas too. The records in memory serve if it fails.
+	store StateStore
+}
+
+// NewIssuanceLog returns a pointer to a new, empty IssuanceLog.
+func NewIssuanceLog() *IssuanceLog {
+	return &IssuanceLog{records: map[string]IssuanceRecord{}}
+}
+
+// NewPersistentIssuanceLog returns a pointer to an IssuanceLog persisted in the store.
+func NewPersistentIssuanceLog(store StateStore) *IssuanceLog {
+	return &IssuanceLog{records: map[string]IssuanceRecord{}, store: store}
+}
+
+// Add records the issuance of a certificate.
+func (l *IssuanceLog) Add(r IssuanceRecord) {
+	if l.store != nil {
+		if err := l.store.AddIssuance(r); err != nil {
+			glog.Errorf("Failed to persist the issuance of certificate %x for %s (error: %v)",
+				r.SerialNumber, r.ID, err)
+		}
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.pruneExpired(time.Now())
+	l.records[r.SerialNumber.String()] = r
+}
+
+// Import adds the records of certificates issued before the log was created,
+// e.g. by a previous version of the CA, skipping the expired ones and those
+// already recorded. It returns the number of added records.
+func (l *IssuanceLog) Import(records []IssuanceRecord) int {
+	now := time.Now()
+	known := map[string]bool{}
+	for _, r := range l.List() {
+		known[r.SerialNumber.String()] = true
+	}
+
+	imported := 0
+	for _, r := range records {
+		key := r.SerialNumber.String()
+		if known[key] || !r.NotAfter.After(now) {
+			continue
+		}
+		known[key] = true
+		if l.store != nil {
+			// Another replica may be importing the same records.
+			if err := l.store.AddIssuance(r); err == ErrDuplicateSerial {
+				continue
+			} else if err != nil {
+				glog.Errorf("Failed to persist the imported issuance of certificate %x for %s (error: %v)",
+					r.SerialNumber, r.ID, err)
+			}
+		}
+
+		l.mutex.Lock()
+		l.records[key] = r
+		l.mutex.Unlock()
+		imported++
+	}
+	return imported
+}
+
+// Get returns the record of the certificate with the given serial number.
+func (l *IssuanceLog) Get(serial *big.Int) (IssuanceRecord, bool) {
+	if records, ok := l.listStore(time.Now()); ok {
+		for _, r := range records {
+			if r.SerialNumber.Cmp(serial) == 0 {
+				return r, true
+			}
+		}
+		return IssuanceRecord{}, false
+	}
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	r, ok := l.records[serial.String()]
+	return r, ok
+}
+
+// List returns the records of the unexpired certificates, ordered by issuance
+// time and then by serial number.
+func (l *IssuanceLog) List() []IssuanceRecord {
+	now := time.Now()
+	records, ok := l.listStore(now)
+	if !ok {
+		records = l.listMemory(now)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].NotBefore.Equal(records[j].NotBefore) {
+			return records[i].NotBefore.Before(records[j].NotBefore)
+		}
+		return records[i].SerialNumber.Cmp(records[j].SerialNumber) < 0
+	})
+	return records
+}
+
+// listStore returns the records of the store unexpired at now, and false if
+// the log is not persistent or the store fails.
+func (l *IssuanceLog) listStore(now time.Time) ([]IssuanceRecord, bool) {
+	if l.store == nil {
+		return nil, false
+	}
+	records, err := l.store.Issuances(now)
+	if err != nil {
+		glog.Errorf("Failed to read the issuances from the state store; listing those of this replica (error: %v)",
+			err)
+		return nil, false
+	}
+	return append([]IssuanceRecord{}, records...), true
+}
+
+func (l *IssuanceLog) listMemory(now time.Time) []IssuanceRecord {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	records := []IssuanceRecord{}
+	for _, r := range l.records {
+		if r.NotAfter.After(now) {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// pruneExpired must be called with the write lock held.
+func (l *IssuanceLog) pruneExpired(now time.Time) {
+	if now.Sub(l.lastPruned) < pruneInterval {
+		return
+	}
+	l.lastPruned = now
+
+	for k, r := range l.records {
+		if !r.NotAfter.After(now) {
+			delete(l.records, k)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// fakeStateStore is an in-memory StateStore failing every call if err is set.
+type fakeStateStore struct {
+	last        time.Time
+	issuances   map[string]IssuanceRecord
+	revocations map[string]time.Time
+	err         error
+}
+
+func newFakeStateStore() *fakeStateStore {
+	return &fakeStateStore{issuances: map[string]IssuanceRecord{}, revocations: map[string]time.Time{}}
+}
+
+func (s *fakeStateStore) Load() (time.Time, error) {
+	return s.last, s.err
+}
+
+func (s *fakeStateStore) Store(t time.Time) error {
+	if s.err != nil {
+		return s.err
+	}
+	if t.After(s.last) {
+		s.last = t
+	}
+	return nil
+}
+
+func (s *fakeStateStore) AddIssuance(r IssuanceRecord) error {
+	if s.err != nil {
+		return s.err
+	}
+	if _, ok := s.issuances[r.SerialNumber.String()]; ok {
+		return ErrDuplicateSerial
+	}
+	s.issuances[r.SerialNumber.String()] = r
+	return nil
+}
+
+func (s *fakeStateStore) Issuances(now time.Time) ([]IssuanceRecord, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	records := []IssuanceRecord{}
+	for _, r := range s.issuances {
+		if r.NotAfter.After(now) {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+func (s *fakeStateStore) AddRevocation(serial *big.Int, t time.Time) error {
+	if s.err != nil {
+		return s.err
+	}
+	if _, ok := s.revocations[serial.String()]; !ok {
+		s.revocations[serial.String()] = t
+	}
+	return nil
+}
+
+func (s *fakeStateStore) Revocations() (map[string]time.Time, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	revocations := map[string]time.Time{}
+	for k, t := range s.revocations {
+		revocations[k] = t
+	}
+	return revocations, nil
+}
+
+func TestIssuanceLog(t *testing.T) {
+	now := time.Now()
+	l := NewIssuanceLog()
+
+	l.Add(IssuanceRecord{SerialNumber: big.NewInt(2), ID: "second", NotBefore: now, NotAfter: now.Add(time.Hour)})
+	l.Add(IssuanceRecord{
+		SerialNumber: big.NewInt(1),
+		ID:           "first",
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+	})
+	l.Add(IssuanceRecord{
+		SerialNumber: big.NewInt(3),
+		ID:           "expired",
+		NotBefore:    now.Add(-2 * time.Hour),
+		NotAfter:     now.Add(-time.Hour),
+	})
+
+	records := l.List()
+	if len(records) != 2 || records[0].ID != "first" || records[1].ID != "second" {
+		t.Errorf("Unexpected records: %v", records)
+	}
+
+	if r, ok := l.Get(big.NewInt(2)); !ok || r.ID != "second" {
+		t.Errorf("Failed to get the record of serial number 2: %v", r)
+	}
+	if _, ok := l.Get(big.NewInt(4)); ok {
+		t.Errorf("Unexpected record for serial number 4")
+	}
+	// The certificates issued at the same time are ordered by serial number.
+	l.Add(IssuanceRecord{SerialNumber: big.NewInt(4), ID: "tied", NotBefore: now, NotAfter: now.Add(time.Hour)})
+	records = l.List()
+	if len(records) != 3 || records[1].ID != "second" || records[2].ID != "tied" {
+		t.Errorf("Unexpected records of the same issuance time: %v", records)
+	}
+}
+
+func TestPersistentIssuanceLog(t *testing.T) {
+	now := time.Now()
+	store := newFakeStateStore()
+	// Issued by another replica.
+	store.issuances["1"] = IssuanceRecord{
+		SerialNumber: big.NewInt(1),
+		ID:           "other",
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+	}
+	l := NewPersistentIssuanceLog(store)
+
+	l.Add(IssuanceRecord{SerialNumber: big.NewInt(2), ID: "local", NotBefore: now, NotAfter: now.Add(time.Hour)})
+	if _, ok := store.issuances["2"]; !ok {
+		t.Errorf("The issuance has not been written to the store")
+	}
+
+	records := l.List()
+	if len(records) != 2 || records[0].ID != "other" || records[1].ID != "local" {
+		t.Errorf("Unexpected records: %v", records)
+	}
+	if r, ok := l.Get(big.NewInt(1)); !ok || r.ID != "other" {
+		t.Errorf("Failed to get the record of serial number 1: %v", r)
+	}
+
+	// The records of this replica serve while the store is failing.
+	store.err = errors.New("unavailable")
+	l.Add(IssuanceRecord{
+		SerialNumber: big.NewInt(3),
+		ID:           "unpersisted",
+		NotBefore:    now.Add(time.Second),
+		NotAfter:     now.Add(time.Hour),
+	})
+	records = l.List()
+	if len(records) != 2 || records[0].ID != "local" || records[1].ID != "unpersisted" {
+		t.Errorf("Unexpected records while the store is failing: %v", records)
+	}
+	if _, ok := l.Get(big.NewInt(1)); ok {
+		t.Errorf("Unexpected record of another replica while the store is failing")
+	}
+}
+
+func TestImportIssuances(t *testing.T) {
+	now := time.Now()
+	existing := IssuanceRecord{SerialNumber: big.NewInt(1), ID: "existing", NotBefore: now, NotAfter: now.Add(time.Hour)}
+	imported := []IssuanceRecord{
+		{SerialNumber: big.NewInt(1), ID: "duplicate", NotBefore: now, NotAfter: now.Add(time.Hour)},
+		{SerialNumber: big.NewInt(2), ID: "unexpired", NotBefore: now.Add(-time.Minute), NotAfter: now.Add(time.Hour)},
+		{SerialNumber: big.NewInt(3), ID: "expired", NotBefore: now.Add(-2 * time.Hour), NotAfter: now.Add(-time.Hour)},
+	}
+
+	testCases := map[string]struct {
+		store *fakeStateStore
+	}{
+		"In memory": {},
+		"Persistent": {
+			store: newFakeStateStore(),
+		},
+	}
+
+	for k, tc := range testCases {
+		l := NewIssuanceLog()
+		if tc.store != nil {
+			l = NewPersistentIssuanceLog(tc.store)
+		}
+		l.Add(existing)
+
+		if n := l.Import(imported); n != 1 {
+			t.Errorf("%s: expecting 1 imported record but got %d", k, n)
+		}
+		// Importing again, e.g. on the next start, adds nothing.
+		if n := l.Import(imported); n != 0 {
+			t.Errorf("%s: expecting no imported record on the second import but got %d", k, n)
+		}
+		records := l.List()
+		if len(records) != 2 || records[0].ID != "unexpired" || records[1].ID != "existing" {
+			t.Errorf("%s: unexpected records: %v", k, records)
+		}
+		if tc.store != nil {
+			if _, ok := tc.store.issuances["2"]; !ok {
+				t.Errorf("%s: the imported record has not been written to the store", k)
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"crypto/rsa"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	keyGenerationsWaiting = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "istio_ca",
+		Name:      "key_generations_waiting",
+		Help:      "The number of RSA key generations waiting for a slot, see SetMaxConcurrentKeyGenerations.",
+	})
+	keyGenerationsRunning = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "istio_ca",
+		Name:      "key_generations_running",
+		Help:      "The number of RSA key generations in progress.",
+	})
+	keyGenerationWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "istio_ca",
+		Name:      "key_generation_wait_seconds",
+		Help:      "The time RSA key generations waited for a slot.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+	})
+)
+
+func init() {
+	prometheus.MustRegister(keyGenerationsWaiting)
+	prometheus.MustRegister(keyGenerationsRunning)
+	prometheus.MustRegister(keyGenerationWait)
+}
+
+var (
+	keyGenerationMutex sync.RWMutex
+	// The slots of the concurrent key generations, or nil if unbounded.
+	keyGenerationSlots chan struct{}
+)
+
+// SetMaxConcurrentKeyGenerations bounds the number of RSA keys generated at
+// once by the CA, so that a mass provisioning does not saturate all CPUs and
+// starve the other goroutines, e.g. the informers. The other generations wait
+// for a slot. n <= 0 removes the bound, which is the default.
+func SetMaxConcurrentKeyGenerations(n int) {
+	keyGenerationMutex.Lock()
+	defer keyGenerationMutex.Unlock()
+	if n <= 0 {
+		keyGenerationSlots = nil
+		return
+	}
+	keyGenerationSlots = make(chan struct{}, n)
+}
+
+// generateRSAKey is like rsa.GenerateKey with the random source of the CA,
+// but waits for a slot if the key generations are bounded.
+func generateRSAKey(bits int) (key *rsa.PrivateKey, err error) {
+	defer recoverCryptoPanic("generate the key", &err)
+
+	keyGenerationMutex.RLock()
+	slots := keyGenerationSlots
+	keyGenerationMutex.RUnlock()
+
+	if slots != nil {
+		keyGenerationsWaiting.Inc()
+		start := time.Now()
+		slots <- struct{}{}
+		keyGenerationWait.Observe(time.Since(start).Seconds())
+		keyGenerationsWaiting.Dec()
+		// The slot is released to the channel it is taken from, even if the
+		// bound changes in the meantime.
+		defer func() { <-slots }()
+	}
+
+	keyGenerationsRunning.Inc()
+	defer keyGenerationsRunning.Dec()
+	return rsa.GenerateKey(RandomSource(), bits)
+}
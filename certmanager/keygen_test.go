@@ -0,0 +1,71 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMaxConcurrentKeyGenerations(t *testing.T) {
+	defer SetMaxConcurrentKeyGenerations(0)
+	SetMaxConcurrentKeyGenerations(1)
+
+	// Hold the only slot, so that the generations wait.
+	keyGenerationMutex.RLock()
+	slots := keyGenerationSlots
+	keyGenerationMutex.RUnlock()
+	slots <- struct{}{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := generateRSAKey(512); err != nil {
+				t.Errorf("Failed to generate the key (error: %v)", err)
+			}
+		}()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(keyGenerationsWaiting) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expect 2 waiting key generations but got %v", testutil.ToFloat64(keyGenerationsWaiting))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if running := testutil.ToFloat64(keyGenerationsRunning); running != 0 {
+		t.Errorf("Expect no key generation to run without a slot but got %v", running)
+	}
+
+	<-slots
+	wg.Wait()
+	if waiting := testutil.ToFloat64(keyGenerationsWaiting); waiting != 0 {
+		t.Errorf("Expect no waiting key generation but got %v", waiting)
+	}
+	if len(slots) != 0 {
+		t.Errorf("Expect the slots to be released but %d are taken", len(slots))
+	}
+
+	// Without a bound, the key generations never wait.
+	SetMaxConcurrentKeyGenerations(0)
+	if _, err := generateRSAKey(512); err != nil {
+		t.Errorf("Failed to generate the key without a bound (error: %v)", err)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	keyPoolKeys = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "istio_ca",
+		Name:      "key_pool_keys",
+		Help:      "The number of pre-generated RSA keys in the key pool.",
+	})
+	keyPoolTakes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "istio_ca",
+		Name:      "key_pool_takes_total",
+		Help: "The number of RSA keys of the workload size requested from the key pool, by result ('hit' " +
+			"if a pre-generated key is served, 'miss' if the pool is empty and the key is generated inline).",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(keyPoolKeys)
+	prometheus.MustRegister(keyPoolTakes)
+}
+
+var (
+	keyPoolMutex sync.RWMutex
+	// The pool installed by SetKeyPool, or nil.
+	installedKeyPool *KeyPool
+)
+
+// KeyPool pre-generates the RSA keys of the workload certificates in the
+// background, so that bursts of new service accounts are served without
+// paying the key generation inline. The pooled keys are kept out of the Go
+// heap, in memory locked against swapping, and are wiped once taken.
+type KeyPool struct {
+	bits int
+	keys chan *SecureBuffer
+}
+
+// NewKeyPool returns a pointer to a KeyPool holding up to size keys. The keys
+// are only generated once the pool runs, see Run.
+func NewKeyPool(size int) *KeyPool {
+	return &KeyPool{bits: keySize, keys: make(chan *SecureBuffer, size)}
+}
+
+// SetKeyPool installs the pool the CA takes the keys of the workload
+// certificates from, falling back to generating them when it is empty. A nil
+// pool uninstalls the previous one.
+func SetKeyPool(p *KeyPool) {
+	keyPoolMutex.Lock()
+	defer keyPoolMutex.Unlock()
+	installedKeyPool = p
+}
+
+// Run fills the pool until stopCh is closed, then wipes the remaining keys.
+// The keys are generated one at a time, taking the slots of the bounded key
+// generations like the other generations. If the memory cannot be locked,
+// e.g. beyond RLIMIT_MEMLOCK, the pool stops filling rather than keeping the
+// keys in swappable memory.
+func (p *KeyPool) Run(stopCh <-chan struct{}) {
+	defer p.wipe()
+	for {
+		key, err := generateRSAKey(p.bits)
+		if err != nil {
+			glog.Errorf("Failed to pre-generate a key for the key pool (error: %v)", err)
+			return
+		}
+		der := x509.MarshalPKCS1PrivateKey(key)
+		buf, err := NewSecureBuffer(der)
+		wipeBytes(der)
+		if err != nil {
+			glog.Errorf("Failed to lock the memory of the key pool; the keys are generated inline (error: %v)", err)
+			return
+		}
+
+		select {
+		case p.keys <- buf:
+			keyPoolKeys.Inc()
+		case <-stopCh:
+			buf.Wipe()
+			return
+		}
+	}
+}
+
+// take returns a pre-generated key, or false if the pool is empty.
+func (p *KeyPool) take() (*rsa.PrivateKey, bool) {
+	select {
+	case buf := <-p.keys:
+		keyPoolKeys.Dec()
+		defer buf.Wipe()
+		key, err := x509.ParsePKCS1PrivateKey(buf.Bytes())
+		return key, err == nil
+	default:
+		return nil, false
+	}
+}
+
+// wipe drops the keys left in the pool.
+func (p *KeyPool) wipe() {
+	for {
+		select {
+		case buf := <-p.keys:
+			keyPoolKeys.Dec()
+			buf.Wipe()
+		default:
+			return
+		}
+	}
+}
+
+// takeRSAKey returns a key of the given size from the installed pool if it
+// holds keys of that size, or generates one otherwise.
+func takeRSAKey(bits int) (*rsa.PrivateKey, error) {
+	keyPoolMutex.RLock()
+	p := installedKeyPool
+	keyPoolMutex.RUnlock()
+
+	if p != nil && p.bits == bits {
+		if key, ok := p.take(); ok {
+			keyPoolTakes.WithLabelValues("hit").Inc()
+			return key, nil
+		}
+		keyPoolTakes.WithLabelValues("miss").Inc()
+	}
+	return generateRSAKey(bits)
+}
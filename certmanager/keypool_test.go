@@ -0,0 +1,72 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestKeyPool(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("The key pool needs locked memory, which is only supported on Linux")
+	}
+	defer SetKeyPool(nil)
+
+	pool := NewKeyPool(2)
+	pool.bits = 512
+	SetKeyPool(pool)
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		pool.Run(stopCh)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for len(pool.keys) < 2 {
+		if time.Now().After(deadline) {
+			close(stopCh)
+			t.Fatalf("The pool is not filled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	hits := testutil.ToFloat64(keyPoolTakes.WithLabelValues("hit"))
+	key, err := takeRSAKey(512)
+	if err != nil || key.N.BitLen() != 512 {
+		t.Fatalf("Failed to take a key from the pool (error: %v)", err)
+	}
+	if err := key.Validate(); err != nil {
+		t.Errorf("The pooled key is invalid (error: %v)", err)
+	}
+	if h := testutil.ToFloat64(keyPoolTakes.WithLabelValues("hit")); h != hits+1 {
+		t.Errorf("Expect the key to be served by the pool (hits: %v -> %v)", hits, h)
+	}
+
+	// Keys of another size are not served by the pool.
+	if key, err := takeRSAKey(768); err != nil || key.N.BitLen() != 768 {
+		t.Errorf("Failed to generate a key of another size (error: %v)", err)
+	}
+
+	close(stopCh)
+	<-done
+	if len(pool.keys) != 0 {
+		t.Errorf("Expect the pool to be wiped once stopped, but it holds %d keys", len(pool.keys))
+	}
+}
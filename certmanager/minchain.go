@@ -0,0 +1,68 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+)
+
+// MinimizeChain drops from the PEM-encoded certificate chain the duplicated
+// certificates, the certificates in rootCerts and the other self-signed
+// certificates, as peers verify the chain against their own copy of the root
+// and sending it only adds bytes to every handshake. The first certificate,
+// i.e. the leaf, is always kept, and the order of the others is preserved.
+// The chain is returned unchanged if it is not entirely PEM-encoded.
+func MinimizeChain(chain, rootCerts []byte) []byte {
+	roots := map[string]bool{}
+	for rest := rootCerts; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		roots[string(block.Bytes)] = true
+	}
+
+	var buf bytes.Buffer
+	seen := map[string]bool{}
+	rest := chain
+	for first := true; len(bytes.TrimSpace(rest)) != 0; first = false {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return chain
+		}
+		if !first && block.Type == "CERTIFICATE" {
+			if seen[string(block.Bytes)] || roots[string(block.Bytes)] || isSelfSigned(block.Bytes) {
+				continue
+			}
+		}
+		seen[string(block.Bytes)] = true
+		if err := pem.Encode(&buf, block); err != nil {
+			return chain
+		}
+	}
+	return buf.Bytes()
+}
+
+// isSelfSigned returns true if the DER-encoded certificate is signed by its own key.
+func isSelfSigned(der []byte) bool {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
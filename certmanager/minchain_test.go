@@ -0,0 +1,97 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMinimizeChain(t *testing.T) {
+	now := time.Now()
+	genCert := func(host string, isCA bool, signerCert, signerKey []byte) ([]byte, []byte) {
+		opts := CertOptions{
+			Host:         host,
+			NotBefore:    now,
+			NotAfter:     now.Add(time.Hour),
+			Org:          "Istio CA",
+			IsCA:         isCA,
+			IsSelfSigned: signerCert == nil,
+			RSAKeySize:   512,
+		}
+		if signerCert != nil {
+			opts.SignerCert = ParsePemEncodedCertificate(signerCert)
+			opts.SignerPriv = parsePemEncodedKey(x509.RSA, signerKey)
+		}
+		return GenCert(opts)
+	}
+	root, rootKey := genCert("root", true, nil, nil)
+	otherRoot, _ := genCert("other-root", true, nil, nil)
+	intermediate, intermediateKey := genCert("intermediate", true, root, rootKey)
+	leaf, _ := genCert("spiffe://cluster.local/ns/default/sa/default", false, intermediate, intermediateKey)
+
+	join := func(certs ...[]byte) string {
+		s := []string{}
+		for _, c := range certs {
+			s = append(s, string(c))
+		}
+		return strings.Join(s, "")
+	}
+
+	testCases := map[string]struct {
+		chain    string
+		roots    []byte
+		expected string
+	}{
+		"Minimal chain is kept": {
+			chain:    join(leaf, intermediate),
+			roots:    root,
+			expected: join(leaf, intermediate),
+		},
+		"Root is dropped": {
+			chain:    join(leaf, intermediate, root),
+			roots:    root,
+			expected: join(leaf, intermediate),
+		},
+		"Duplicated intermediates are dropped": {
+			chain:    join(leaf, intermediate, intermediate),
+			roots:    root,
+			expected: join(leaf, intermediate),
+		},
+		"Self-signed certificates not in the roots are dropped": {
+			chain:    join(leaf, intermediate, otherRoot),
+			roots:    root,
+			expected: join(leaf, intermediate),
+		},
+		"Leaf is kept even if self-signed": {
+			chain:    join(root, root),
+			roots:    root,
+			expected: join(root),
+		},
+		"Data that is not PEM-encoded is kept as is": {
+			chain:    join(leaf, root) + "trailing garbage",
+			roots:    root,
+			expected: join(leaf, root) + "trailing garbage",
+		},
+	}
+
+	for k, tc := range testCases {
+		if actual := string(MinimizeChain([]byte(tc.chain), tc.roots)); actual != tc.expected {
+			t.Errorf("%s: expect %d bytes but got %d bytes", k, len(tc.expected), len(actual))
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// namespaceCA is the intermediate CA issuing the workload certificates of a namespace.
+type namespaceCA struct {
+	cert    *x509.Certificate
+	key     crypto.PrivateKey
+	certPEM []byte
+}
+
+// namespaceCAs holds the intermediate CAs of the namespaces, which are created
+// on first use and replaced before they expire or once they are revoked.
+type namespaceCAs struct {
+	mutex sync.Mutex
+
+	ttl time.Duration
+	cas map[string]*namespaceCA
+}
+
+func newNamespaceCAs(ttl time.Duration) *namespaceCAs {
+	return &namespaceCAs{ttl: ttl, cas: map[string]*namespaceCA{}}
+}
+
+// signer returns the signer for the workload certificates of the namespace,
+// and the PEM-encoded certificate chain between the signer and the signing
+// certificate of the CA. It must be called with the read lock of the CA held.
+func (ca *IstioCA) signer(namespace string, now time.Time) (*x509.Certificate, crypto.PrivateKey, []byte, error) {
+	signingCert, signingKey := ca.signingCert, ca.signingKey
+	if ca.staged != nil && ca.staged.namespaces[namespace] {
+		signingCert, signingKey = ca.staged.cert, ca.staged.key
+	}
+	if ca.namespaceCAs == nil || namespace == "" {
+		return signingCert, signingKey, nil, nil
+	}
+
+	ns := ca.namespaceCAs
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+
+	// Replace the intermediate once it would expire before the issued
+	// certificate, unless a new one would not outlive it as it is clamped to
+	// the signing certificate too.
+	nsCA, ok := ns.cas[namespace]
+	if !ok || nsCA.cert.NotAfter.Before(now.Add(ca.certTTL)) && nsCA.cert.NotAfter.Before(signingCert.NotAfter) {
+		var err error
+		if nsCA, err = ca.newNamespaceCA(namespace, now, signingCert, signingKey); err != nil {
+			return nil, nil, nil, err
+		}
+		ns.cas[namespace] = nsCA
+	}
+	return nsCA.cert, nsCA.key, nsCA.certPEM, nil
+}
+
+// newNamespaceCA returns a new intermediate CA for the namespace, which
+// expires after the TTL of the intermediates unless the signing certificate
+// expires sooner.
+func (ca *IstioCA) newNamespaceCA(namespace string, now time.Time, signingCert *x509.Certificate,
+	signingKey crypto.PrivateKey) (*namespaceCA, error) {
+
+	notAfter, err := ca.notAfter(now, ca.namespaceCAs.ttl, signingCert)
+	if err != nil {
+		return nil, err
+	}
+	subject := pkix.Name{
+		Organization: signingCert.Subject.Organization,
+		CommonName:   fmt.Sprintf("Istio CA for namespace %s", namespace),
+	}
+	certPEM, keyPEM := GenCert(CertOptions{
+		NotBefore:      now,
+		NotAfter:       notAfter,
+		SignerCert:     signingCert,
+		SignerPriv:     signingKey,
+		Subject:        &subject,
+		IsCA:           true,
+		MaxPathLenZero: true,
+		RSAKeySize:     caKeySize,
+
+		SignatureAlgorithm: ca.signatureAlgorithm,
+	})
+	cert := ParsePemEncodedCertificate(certPEM)
+	ca.issued.Add(IssuanceRecord{
+		SerialNumber: cert.SerialNumber,
+		ID:           fmt.Sprintf("%s://%s/ns/%s", uriScheme, ca.trustDomain, namespace),
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+	})
+	glog.Infof("Created the intermediate CA with serial number %x for namespace %s", cert.SerialNumber, namespace)
+
+	return &namespaceCA{
+		cert:    cert,
+		key:     parsePemEncodedKey(cert.PublicKeyAlgorithm, keyPEM),
+		certPEM: certPEM,
+	}, nil
+}
+
+// HandleRevocation discards the intermediate CA with the serial number, if
+// any, so that the subsequent certificates of its namespace are issued by a new
+// intermediate CA. It can be registered as a RevocationListener.
+func (ca *IstioCA) HandleRevocation(serial *big.Int) {
+	if ca.namespaceCAs == nil {
+		return
+	}
+
+	ns := ca.namespaceCAs
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+
+	for namespace, nsCA := range ns.cas {
+		if nsCA.cert.SerialNumber.Cmp(serial) == 0 {
+			glog.Infof("The intermediate CA of namespace %s has been revoked", namespace)
+			delete(ns.cas, namespace)
+			return
+		}
+	}
+}
+
+// discard discards the intermediate CA of the namespace, if any.
+func (ns *namespaceCAs) discard(namespace string) {
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+
+	delete(ns.cas, namespace)
+}
+
+// reset discards all the intermediate CAs, e.g. after the root rotation.
+func (ns *namespaceCAs) reset() {
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+
+	ns.cas = map[string]*namespaceCA{}
+}
@@ -0,0 +1,186 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func parseChain(t *testing.T, chain []byte) []*x509.Certificate {
+	certs := []*x509.Certificate{}
+	for block, rest := pem.Decode(chain); block != nil; block, rest = pem.Decode(rest) {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatalf("Failed to parse the certificate chain: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs
+}
+
+func TestNamespaceCAs(t *testing.T) {
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:      time.Hour,
+		CertTTL:        time.Minute,
+		MaxPathLen:     -1,
+		NamespaceCATTL: 30 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.GetRootCertificate())
+
+	generate := func(namespace string) []*x509.Certificate {
+		chain, _, err := ca.Generate("foo", namespace)
+		if err != nil {
+			t.Fatalf("Failed to generate a certificate: %v", err)
+		}
+		certs := parseChain(t, chain)
+		if len(certs) != 2 {
+			t.Fatalf("Expecting the leaf and the intermediate certificates, but the chain has %d", len(certs))
+		}
+		intermediates := x509.NewCertPool()
+		intermediates.AddCert(certs[1])
+		opts := x509.VerifyOptions{Intermediates: intermediates, Roots: roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+		if _, err := certs[0].Verify(opts); err != nil {
+			t.Errorf("Failed to verify the certificate of namespace %s: %v", namespace, err)
+		}
+		return certs
+	}
+
+	foo := generate("foo")[1]
+	if again := generate("foo")[1]; again.SerialNumber.Cmp(foo.SerialNumber) != 0 {
+		t.Error("Expecting the certificates of a namespace to be issued by the same intermediate CA")
+	}
+	if bar := generate("bar")[1]; bar.SerialNumber.Cmp(foo.SerialNumber) == 0 {
+		t.Error("Expecting the namespaces to have distinct intermediate CAs")
+	}
+	if !foo.IsCA || !foo.MaxPathLenZero {
+		t.Error("Expecting the intermediate CA to be unable to sign further CAs")
+	}
+
+	found := false
+	for _, rec := range ca.IssuedCertificates() {
+		if rec.SerialNumber.Cmp(foo.SerialNumber) == 0 && rec.ID == "spiffe://cluster.local/ns/foo" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expecting the issuance of the intermediate CA to be recorded")
+	}
+
+	ca.HandleRevocation(foo.SerialNumber)
+	if renewed := generate("foo")[1]; renewed.SerialNumber.Cmp(foo.SerialNumber) == 0 {
+		t.Error("Expecting a revoked intermediate CA to be replaced")
+	}
+
+	// Server certificates are not namespaced.
+	chain, _, err := ca.GenerateServerCert([]string{"foo.com"})
+	if err != nil {
+		t.Fatalf("Failed to generate a server certificate: %v", err)
+	}
+	if n := len(parseChain(t, chain)); n != 1 {
+		t.Errorf("Expecting the server certificate to be issued by the root, but the chain has %d certificates", n)
+	}
+}
+
+func TestNamespaceCAsSign(t *testing.T) {
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:      time.Hour,
+		CertTTL:        time.Minute,
+		MaxPathLen:     -1,
+		NamespaceCATTL: 30 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := url.Parse("spiffe://cluster.local/ns/foo/sa/bar")
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{URIs: []*url.URL{id}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := ca.Sign(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	if err != nil {
+		t.Fatalf("Failed to sign the certificate signing request: %v", err)
+	}
+	certs := parseChain(t, chain)
+	if len(certs) != 2 {
+		t.Fatalf("Expecting the leaf and the intermediate certificates, but the chain has %d", len(certs))
+	}
+	generated, _, err := ca.Generate("bar", "foo")
+	if err != nil {
+		t.Fatalf("Failed to generate a certificate: %v", err)
+	}
+	if intermediate := parseChain(t, generated)[1]; intermediate.SerialNumber.Cmp(certs[1].SerialNumber) != 0 {
+		t.Error("Expecting the certificate signing requests to be signed by the intermediate CA of the namespace")
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.GetRootCertificate())
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(certs[1])
+	opts := x509.VerifyOptions{Intermediates: intermediates, Roots: roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+	if _, err := certs[0].Verify(opts); err != nil {
+		t.Errorf("Failed to verify the signed certificate: %v", err)
+	}
+}
+
+func TestNamespaceCAsClampedToRoot(t *testing.T) {
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:      time.Hour,
+		CertTTL:        time.Minute,
+		MaxPathLen:     -1,
+		NamespaceCATTL: 2 * time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+	root := ParsePemEncodedCertificate(ca.GetRootCertificate())
+
+	chain, _, err := ca.Generate("bar", "foo")
+	if err != nil {
+		t.Fatalf("Failed to generate a certificate: %v", err)
+	}
+	intermediate := parseChain(t, chain)[1]
+	if intermediate.NotAfter.After(root.NotAfter) {
+		t.Errorf("Expecting the intermediate CA to expire by %v, but it expires at %v", root.NotAfter, intermediate.NotAfter)
+	}
+}
+
+func TestNamespaceCAsRequirePathLen(t *testing.T) {
+	_, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:      time.Hour,
+		CertTTL:        time.Minute,
+		MaxPathLen:     0,
+		NamespaceCATTL: time.Hour,
+	})
+	if err == nil {
+		t.Error("Expecting an error for a signing cert that does not allow intermediate CAs")
+	}
+}
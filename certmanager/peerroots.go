@@ -0,0 +1,44 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+// SetPeerRootCertificates replaces the PEM-encoded root certificates of the
+// peer CAs, e.g. of the other clusters of a multi-primary mesh, which
+// GetRootCertificate returns after the roots of the CA itself, so that the
+// workloads trust the certificates issued by the peers.
+func (ca *IstioCA) SetPeerRootCertificates(roots []byte) {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	ca.peerRootCertBytes = copyBytes(roots)
+}
+
+// GetOwnRootCertificates returns the PEM-encoded root certificates of the CA
+// itself, including the new root of a staged rotation, but not the roots of
+// the peers. It is the trust bundle to share with the peers.
+func (ca *IstioCA) GetOwnRootCertificates() []byte {
+	ca.mutex.RLock()
+	defer ca.mutex.RUnlock()
+
+	return ca.ownRootCertificates()
+}
+
+// ownRootCertificates must be called with the lock held.
+func (ca *IstioCA) ownRootCertificates() []byte {
+	if ca.staged != nil {
+		return append(copyBytes(ca.rootCertBytes), ca.staged.certPEM...)
+	}
+	return copyBytes(ca.rootCertBytes)
+}
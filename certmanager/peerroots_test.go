@@ -0,0 +1,50 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPeerRootCertificates(t *testing.T) {
+	newCA := func() *IstioCA {
+		ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+			CACertTTL:  time.Hour,
+			CertTTL:    30 * time.Minute,
+			MaxPathLen: -1,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create a self-signed CA: %v", err)
+		}
+		return ca
+	}
+	ca, peer := newCA(), newCA()
+	own := ca.GetRootCertificate()
+
+	ca.SetPeerRootCertificates(peer.GetOwnRootCertificates())
+	if expected := append(copyBytes(own), peer.GetRootCertificate()...); !bytes.Equal(ca.GetRootCertificate(), expected) {
+		t.Error("Expecting the trust bundle to hold the own root followed by the root of the peer")
+	}
+	if !bytes.Equal(ca.GetOwnRootCertificates(), own) {
+		t.Error("Expecting the own root certificates to exclude the roots of the peers")
+	}
+
+	ca.SetPeerRootCertificates(nil)
+	if !bytes.Equal(ca.GetRootCertificate(), own) {
+		t.Error("Expecting the roots of the peers to be removed from the trust bundle")
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CommentPEM prefixes every certificate in the PEM-encoded data with
+// human-readable comment lines naming its identity, issuer and expiry, e.g.
+//
+//	# Identity: spiffe://cluster.local/ns/default/sa/default
+//	# Issuer: Istio CA
+//	# Not after: 2017-06-01T00:00:00Z
+//
+// PEM decoders skip the text before each block, so the commented data can be
+// consumed as is. Blocks other than certificates are kept without comments.
+// The data is returned unchanged if it is not entirely PEM-encoded.
+func CommentPEM(data []byte) []byte {
+	var buf bytes.Buffer
+	rest := data
+	for len(bytes.TrimSpace(rest)) != 0 {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return data
+		}
+		if block.Type == "CERTIFICATE" {
+			if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+				fmt.Fprintf(&buf, "# Identity: %s\n", certIdentity(cert))
+				fmt.Fprintf(&buf, "# Issuer: %s\n", certIssuer(cert))
+				fmt.Fprintf(&buf, "# Not after: %s\n", cert.NotAfter.UTC().Format(time.RFC3339))
+			}
+		}
+		if err := pem.Encode(&buf, block); err != nil {
+			return data
+		}
+	}
+	return buf.Bytes()
+}
+
+// certIdentity returns the URI SANs of the certificate, or its DNS SANs, or its common name.
+func certIdentity(cert *x509.Certificate) string {
+	if len(cert.URIs) > 0 {
+		uris := make([]string, len(cert.URIs))
+		for i, u := range cert.URIs {
+			uris[i] = u.String()
+		}
+		return strings.Join(uris, ", ")
+	}
+	if len(cert.DNSNames) > 0 {
+		return strings.Join(cert.DNSNames, ", ")
+	}
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	return "unknown"
+}
+
+// certIssuer returns the common name of the issuer of the certificate, or its organization.
+func certIssuer(cert *x509.Certificate) string {
+	if cert.Issuer.CommonName != "" {
+		return cert.Issuer.CommonName
+	}
+	if len(cert.Issuer.Organization) > 0 {
+		return strings.Join(cert.Issuer.Organization, ", ")
+	}
+	return "unknown"
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/auth/parse"
+)
+
+func TestCommentPEM(t *testing.T) {
+	notAfter := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	cert, _ := GenCert(CertOptions{
+		Host:         "spiffe://cluster.local/ns/default/sa/default",
+		NotBefore:    notAfter.Add(-time.Hour),
+		NotAfter:     notAfter,
+		Org:          "Istio CA",
+		IsSelfSigned: true,
+		RSAKeySize:   512,
+	})
+	chain := append(append([]byte{}, cert...), cert...)
+
+	testCases := map[string]struct {
+		data     string
+		expected string
+	}{
+		"Certificates are commented": {
+			data: string(chain),
+			expected: strings.Repeat("# Identity: spiffe://cluster.local/ns/default/sa/default\n"+
+				"# Issuer: Istio CA\n# Not after: 2030-01-02T03:04:05Z\n"+string(cert), 2),
+		},
+		"Other blocks are kept as is": {
+			data:     "-----BEGIN TEST-----\nAAAA\n-----END TEST-----\n",
+			expected: "-----BEGIN TEST-----\nAAAA\n-----END TEST-----\n",
+		},
+		"Data that is not PEM-encoded is kept as is": {
+			data:     string(cert) + "trailing garbage",
+			expected: string(cert) + "trailing garbage",
+		},
+	}
+
+	for k, tc := range testCases {
+		if actual := string(CommentPEM([]byte(tc.data))); actual != tc.expected {
+			t.Errorf("%s: expect\n%s\nbut got\n%s", k, tc.expected, actual)
+		}
+	}
+
+	if _, err := parse.CertificateChain(CommentPEM(chain)); err != nil {
+		t.Errorf("Failed to parse the commented certificate chain (error: %v)", err)
+	}
+}
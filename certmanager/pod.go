@@ -0,0 +1,81 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+// PodIdentityExtensionID is the OID of the non-critical extension carrying
+// the PodIdentity of the pod that a certificate is issued to, see
+// IstioCA.SignForPod. It is private to Istio CA, so relying parties should
+// only interpret it in the certificates of the mesh.
+var PodIdentityExtensionID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 1, 1}
+
+// PodIdentity identifies the Kubernetes pod that requested a certificate, for
+// tracing a certificate back to the pod holding its key, e.g. to revoke the
+// certificates of a compromised pod or node.
+type PodIdentity struct {
+	Namespace string
+	Name      string
+	UID       string
+	NodeName  string
+}
+
+// The DER encoding of PodIdentity:
+//
+//	PodIdentity ::= SEQUENCE {
+//	  namespace UTF8String,
+//	  name      UTF8String,
+//	  uid       UTF8String,
+//	  nodeName  UTF8String }
+type podIdentity struct {
+	Namespace string `asn1:"utf8"`
+	Name      string `asn1:"utf8"`
+	UID       string `asn1:"utf8"`
+	NodeName  string `asn1:"utf8"`
+}
+
+func (p PodIdentity) extension() (pkix.Extension, error) {
+	if p.Namespace == "" || p.Name == "" || p.UID == "" {
+		return pkix.Extension{}, errors.New("the pod identity needs the namespace, name and UID of the pod")
+	}
+	value, err := asn1.Marshal(podIdentity(p))
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: PodIdentityExtensionID, Value: value}, nil
+}
+
+// ParsePodIdentity returns the pod identity that the certificate carries, or
+// nil if it carries none.
+func ParsePodIdentity(cert *x509.Certificate) (*PodIdentity, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(PodIdentityExtensionID) {
+			continue
+		}
+		var p podIdentity
+		if rest, err := asn1.Unmarshal(ext.Value, &p); err != nil || len(rest) > 0 {
+			return nil, fmt.Errorf("invalid pod identity extension (error: %v)", err)
+		}
+		pod := PodIdentity(p)
+		return &pod, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSignForPod(t *testing.T) {
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:   time.Hour,
+		CertTTL:     30 * time.Minute,
+		MaxPathLen:  -1,
+		NoKeyEscrow: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := url.Parse("spiffe://cluster.local/ns/db/sa/etcd")
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{URIs: []*url.URL{id}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+
+	testCases := map[string]struct {
+		pod         PodIdentity
+		expectedErr bool
+	}{
+		"Pod identity": {
+			pod: PodIdentity{Namespace: "db", Name: "etcd-0", UID: "1234-abcd", NodeName: "node-1"},
+		},
+		"Pod identity without node": {
+			pod: PodIdentity{Namespace: "db", Name: "etcd-0", UID: "1234-abcd"},
+		},
+		"Pod identity without UID": {
+			pod:         PodIdentity{Namespace: "db", Name: "etcd-0", NodeName: "node-1"},
+			expectedErr: true,
+		},
+	}
+	for k, tc := range testCases {
+		chain, err := ca.SignForPod(csrPEM, tc.pod, "etcd-0.etcd.db.svc.cluster.local")
+		if tc.expectedErr {
+			if err == nil {
+				t.Errorf("%s: expecting an error", k)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: failed to sign the certificate signing request: %v", k, err)
+			continue
+		}
+		cert := ParsePemEncodedCertificate(chain)
+		if len(cert.DNSNames) != 1 || cert.DNSNames[0] != "etcd-0.etcd.db.svc.cluster.local" {
+			t.Errorf("%s: unexpected DNS names %v", k, cert.DNSNames)
+		}
+		pod, err := ParsePodIdentity(cert)
+		if err != nil || pod == nil || !reflect.DeepEqual(*pod, tc.pod) {
+			t.Errorf("%s: unexpected pod identity %v (expecting %v, error: %v)", k, pod, tc.pod, err)
+		}
+	}
+
+	chain, err := ca.Sign(csrPEM)
+	if err != nil {
+		t.Fatalf("Failed to sign the certificate signing request: %v", err)
+	}
+	if pod, err := ParsePodIdentity(ParsePemEncodedCertificate(chain)); pod != nil || err != nil {
+		t.Errorf("Expecting no pod identity but got %v (error: %v)", pod, err)
+	}
+}
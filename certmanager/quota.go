@@ -0,0 +1,159 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"istio.io/auth/pkg/spiffe"
+)
+
+// The quotas reported by QuotaExceededError.
+const (
+	QuotaActiveIdentities = "active_identities"
+	QuotaIssuancesPerHour = "issuances_per_hour"
+)
+
+var quotaExceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "istio_ca",
+	Name:      "quota_exceeded_total",
+	Help:      "The number of issuances refused as their namespace exceeds a quota, see NamespaceQuota.",
+}, []string{"namespace", "quota"})
+
+func init() {
+	prometheus.MustRegister(quotaExceeded)
+}
+
+// NamespaceQuota bounds the certificates the CA issues for the Istio
+// identities of each namespace, so that a misbehaving tenant of a shared
+// cluster cannot exhaust the CA. A zero field leaves its quota unlimited.
+type NamespaceQuota struct {
+	// The maximum number of identities of a namespace holding unexpired
+	// certificates. The identities already holding one are renewed regardless.
+	MaxActiveIdentities int
+
+	// The maximum number of certificates issued for a namespace within an hour.
+	MaxIssuancesPerHour int
+}
+
+// QuotaExceededError is returned when an issuance is refused as its namespace
+// exceeds a quota.
+type QuotaExceededError struct {
+	Namespace string
+	// Either QuotaActiveIdentities or QuotaIssuancesPerHour.
+	Quota string
+	Limit int
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("namespace %s exceeds its quota of %d %s", e.Namespace, e.Limit,
+		strings.Replace(e.Quota, "_", " ", -1))
+}
+
+// namespaceQuotas enforces a NamespaceQuota on the issuances of the CA.
+type namespaceQuotas struct {
+	mutex sync.Mutex
+
+	quota NamespaceQuota
+	// The TTL of the issued certificates, until which an identity is active.
+	certTTL time.Duration
+
+	// The issuance times within the last hour, oldest first, by namespace.
+	issuances map[string][]time.Time
+	// The expiry of the latest certificate of each identity, by namespace.
+	identities map[string]map[string]time.Time
+}
+
+func newNamespaceQuotas(quota NamespaceQuota, certTTL time.Duration) *namespaceQuotas {
+	return &namespaceQuotas{
+		quota:      quota,
+		certTTL:    certTTL,
+		issuances:  map[string][]time.Time{},
+		identities: map[string]map[string]time.Time{},
+	}
+}
+
+// admit returns a QuotaExceededError if issuing a certificate for the
+// identity of the namespace at now would exceed the quota, and otherwise
+// counts the issuance. An issuance failing afterwards still counts, which
+// errs on the side of containing the namespace.
+func (q *namespaceQuotas) admit(namespace, id string, now time.Time) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	times := q.issuances[namespace]
+	for len(times) > 0 && !times[0].After(now.Add(-time.Hour)) {
+		times = times[1:]
+	}
+	if max := q.quota.MaxIssuancesPerHour; max > 0 && len(times) >= max {
+		q.issuances[namespace] = times
+		return exceededQuota(namespace, QuotaIssuancesPerHour, max)
+	}
+
+	ids := q.identities[namespace]
+	for k, expiry := range ids {
+		if !expiry.After(now) {
+			delete(ids, k)
+		}
+	}
+	if _, active := ids[id]; !active {
+		if max := q.quota.MaxActiveIdentities; max > 0 && len(ids) >= max {
+			return exceededQuota(namespace, QuotaActiveIdentities, max)
+		}
+	}
+
+	if q.quota.MaxIssuancesPerHour > 0 {
+		q.issuances[namespace] = append(times, now)
+	}
+	if q.quota.MaxActiveIdentities > 0 {
+		if ids == nil {
+			ids = map[string]time.Time{}
+			q.identities[namespace] = ids
+		}
+		ids[id] = now.Add(q.certTTL)
+	}
+	return nil
+}
+
+func exceededQuota(namespace, quota string, limit int) error {
+	quotaExceeded.WithLabelValues(namespace, quota).Inc()
+	return &QuotaExceededError{Namespace: namespace, Quota: quota, Limit: limit}
+}
+
+// istioIdentity returns the Istio identity of the URIs, e.g.
+// spiffe://cluster.local/ns/foo/sa/bar, with its namespace and service
+// account, or nil if there are no URIs. Any other URIs are an error, so that
+// a certificate never carries an identity that bypasses the approval and the
+// quotas.
+func istioIdentity(uris []*url.URL) (id *url.URL, namespace, serviceAccount string, err error) {
+	if len(uris) == 0 {
+		return nil, "", "", nil
+	}
+	if len(uris) > 1 {
+		return nil, "", "", errors.New("the certificate signing request may ask for a single identity only")
+	}
+	namespace, serviceAccount, err = spiffe.ParseIstioID(uris[0].String())
+	if err != nil {
+		return nil, "", "", err
+	}
+	return uris[0], namespace, serviceAccount, nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNamespaceQuotas(t *testing.T) {
+	type issuance struct {
+		namespace, id string
+		// The time of the issuance, relative to the first one.
+		after         time.Duration
+		expectedQuota string
+	}
+	testCases := map[string]struct {
+		quota     NamespaceQuota
+		issuances []issuance
+	}{
+		"Active identities": {
+			quota: NamespaceQuota{MaxActiveIdentities: 2},
+			issuances: []issuance{
+				{namespace: "foo", id: "a"},
+				{namespace: "foo", id: "b"},
+				{namespace: "foo", id: "a"},
+				{namespace: "foo", id: "c", expectedQuota: QuotaActiveIdentities},
+				{namespace: "bar", id: "c"},
+				// The certificates of a and b have expired.
+				{namespace: "foo", id: "c", after: 2 * time.Hour},
+			},
+		},
+		"Issuances per hour": {
+			quota: NamespaceQuota{MaxIssuancesPerHour: 2},
+			issuances: []issuance{
+				{namespace: "foo", id: "a"},
+				{namespace: "foo", id: "a", after: 30 * time.Minute},
+				{namespace: "foo", id: "b", after: 45 * time.Minute, expectedQuota: QuotaIssuancesPerHour},
+				{namespace: "bar", id: "b", after: 45 * time.Minute},
+				{namespace: "foo", id: "b", after: 61 * time.Minute},
+				{namespace: "foo", id: "c", after: 62 * time.Minute, expectedQuota: QuotaIssuancesPerHour},
+			},
+		},
+		"Refused issuances do not count": {
+			quota: NamespaceQuota{MaxActiveIdentities: 1, MaxIssuancesPerHour: 2},
+			issuances: []issuance{
+				{namespace: "foo", id: "a"},
+				{namespace: "foo", id: "b", expectedQuota: QuotaActiveIdentities},
+				{namespace: "foo", id: "a"},
+				{namespace: "foo", id: "a", expectedQuota: QuotaIssuancesPerHour},
+			},
+		},
+	}
+
+	start := time.Now()
+	for k, tc := range testCases {
+		q := newNamespaceQuotas(tc.quota, time.Hour)
+		for i, is := range tc.issuances {
+			err := q.admit(is.namespace, is.id, start.Add(is.after))
+			if is.expectedQuota == "" {
+				if err != nil {
+					t.Errorf("%s: issuance %d: unexpected error: %v", k, i, err)
+				}
+				continue
+			}
+			qe, ok := err.(*QuotaExceededError)
+			if !ok || qe.Quota != is.expectedQuota || qe.Namespace != is.namespace {
+				t.Errorf("%s: issuance %d: expect quota %s of namespace %s to be exceeded, but got %v",
+					k, i, is.expectedQuota, is.namespace, err)
+			}
+		}
+	}
+}
+
+func TestNamespaceQuotaIssuance(t *testing.T) {
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:      time.Hour,
+		CertTTL:        30 * time.Minute,
+		MaxPathLen:     -1,
+		NamespaceQuota: NamespaceQuota{MaxActiveIdentities: 1},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+
+	_, key, err := ca.Generate("foo", "bar")
+	if err != nil {
+		t.Fatalf("Failed to generate the first certificate of the namespace: %v", err)
+	}
+	if _, err := ca.Recertify("foo", "bar", key); err != nil {
+		t.Errorf("Failed to re-certify an active identity: %v", err)
+	}
+	if _, _, err := ca.Generate("baz", "bar"); err == nil {
+		t.Error("Expecting the issuance for another identity of the namespace to be refused")
+	} else if err.Error() != "namespace bar exceeds its quota of 1 active identities" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, _, err := ca.Generate("baz", "other"); err != nil {
+		t.Errorf("Failed to generate the certificate of another namespace: %v", err)
+	}
+	if _, _, err := ca.GenerateServerCert([]string{"foo.com"}); err != nil {
+		t.Errorf("Failed to generate a server certificate, which has no namespace: %v", err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := url.Parse("spiffe://cluster.local/ns/bar/sa/qux")
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{URIs: []*url.URL{id}}, ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ca.Sign(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	if _, ok := err.(*QuotaExceededError); !ok {
+		t.Errorf("Expecting the signing for another identity of the namespace to be refused, but got %v", err)
+	}
+}
+
+func TestIstioIdentity(t *testing.T) {
+	testCases := map[string]struct {
+		uris                   []string
+		expectedID             string
+		expectedNamespace      string
+		expectedServiceAccount string
+		expectError            bool
+	}{
+		"Istio identity": {
+			uris:                   []string{"spiffe://cluster.local/ns/foo/sa/bar"},
+			expectedID:             "spiffe://cluster.local/ns/foo/sa/bar",
+			expectedNamespace:      "foo",
+			expectedServiceAccount: "bar",
+		},
+		"No URIs": {},
+		"Other URI besides the Istio identity": {
+			uris:        []string{"https://foo.com/ns/a/sa/b", "spiffe://td/ns/baz/sa/qux"},
+			expectError: true,
+		},
+		"Several Istio identities": {
+			uris:        []string{"spiffe://td/ns/foo/sa/bar", "spiffe://td/ns/baz/sa/qux"},
+			expectError: true,
+		},
+		"Namespace identity": {
+			uris:        []string{"spiffe://cluster.local/ns/foo"},
+			expectError: true,
+		},
+		"Empty namespace": {
+			uris:        []string{"spiffe://cluster.local/ns//sa/bar"},
+			expectError: true,
+		},
+		"Extra path segment": {
+			uris:        []string{"spiffe://cluster.local/ns/foo/sa/bar/baz"},
+			expectError: true,
+		},
+	}
+	for k, tc := range testCases {
+		var uris []*url.URL
+		for _, s := range tc.uris {
+			u, err := url.Parse(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			uris = append(uris, u)
+		}
+		id, namespace, serviceAccount, err := istioIdentity(uris)
+		if (err != nil) != tc.expectError {
+			t.Errorf("%s: unexpected error %v", k, err)
+			continue
+		}
+		actualID := ""
+		if id != nil {
+			actualID = id.String()
+		}
+		if actualID != tc.expectedID || namespace != tc.expectedNamespace || serviceAccount != tc.expectedServiceAccount {
+			t.Errorf("%s: expect %q (%s/%s), but got %q (%s/%s)", k, tc.expectedID, tc.expectedNamespace,
+				tc.expectedServiceAccount, actualID, namespace, serviceAccount)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// The number of bytes read from each random source by CheckRandomSource.
+const randomProbeSize = 32
+
+var (
+	randomSourceMutex sync.RWMutex
+	// The source plugged by SetRandomSource, or nil.
+	pluggedRandomSource io.Reader
+)
+
+// SetRandomSource plugs an additional source of randomness, e.g. a hardware
+// RNG device opened with os.Open, into the generation of the keys, serial
+// numbers and signatures of the CA. Its output is XORed with that of
+// crypto/rand, so that a faulty source never weakens the keys; reads block
+// until both sources deliver. A nil source unplugs the previous one.
+func SetRandomSource(r io.Reader) {
+	randomSourceMutex.Lock()
+	defer randomSourceMutex.Unlock()
+	pluggedRandomSource = r
+}
+
+// RandomSource returns the source of randomness of the CA: crypto/rand,
+// mixed with the source plugged by SetRandomSource if any.
+func RandomSource() io.Reader {
+	randomSourceMutex.RLock()
+	defer randomSourceMutex.RUnlock()
+	if pluggedRandomSource == nil {
+		return rand.Reader
+	}
+	return xorReader{rand.Reader, pluggedRandomSource}
+}
+
+// xorReader reads the XOR of the output of two readers.
+type xorReader struct {
+	a, b io.Reader
+}
+
+func (r xorReader) Read(p []byte) (int, error) {
+	if _, err := io.ReadFull(r.a, p); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, len(p))
+	if _, err := io.ReadFull(r.b, buf); err != nil {
+		return 0, err
+	}
+	for i := range p {
+		p[i] ^= buf[i]
+	}
+	return len(p), nil
+}
+
+// CheckRandomSource verifies that crypto/rand and the plugged random source
+// deliver within timeout, e.g. that the entropy pool of the kernel is
+// initialized, and that their output is not stuck. A source that blocks
+// would otherwise stall every key generation without an error.
+func CheckRandomSource(timeout time.Duration) error {
+	randomSourceMutex.RLock()
+	plugged := pluggedRandomSource
+	randomSourceMutex.RUnlock()
+
+	if err := probeRandomSource(rand.Reader, timeout); err != nil {
+		return fmt.Errorf("crypto/rand: %v", err)
+	}
+	if plugged != nil {
+		if err := probeRandomSource(plugged, timeout); err != nil {
+			return fmt.Errorf("the plugged random source: %v", err)
+		}
+	}
+	return nil
+}
+
+// probeRandomSource reads twice from r, failing if a read does not complete
+// within timeout, or if the reads are all equal bytes or equal to each other.
+func probeRandomSource(r io.Reader, timeout time.Duration) error {
+	type result struct {
+		data []byte
+		err  error
+	}
+	// The reader cannot be interrupted; a blocked probe is left behind, and
+	// its buffered channel lets it exit whenever the read completes.
+	done := make(chan result, 1)
+	go func() {
+		data := make([]byte, 2*randomProbeSize)
+		_, err := io.ReadFull(r, data)
+		done <- result{data, err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return fmt.Errorf("failed to read (error: %v)", res.err)
+		}
+		first, second := res.data[:randomProbeSize], res.data[randomProbeSize:]
+		if bytes.Equal(first, second) || bytes.Count(first, first[:1]) == randomProbeSize {
+			return fmt.Errorf("the output is stuck at %x", first)
+		}
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("no output within %v, the source may lack entropy", timeout)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingReader never delivers.
+type blockingReader struct {
+	unblock chan struct{}
+}
+
+func (r blockingReader) Read(p []byte) (int, error) {
+	<-r.unblock
+	return 0, io.EOF
+}
+
+// constantReader delivers the same byte forever.
+type constantReader byte
+
+func (r constantReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r)
+	}
+	return len(p), nil
+}
+
+func TestRandomSource(t *testing.T) {
+	defer SetRandomSource(nil)
+
+	if RandomSource() != rand.Reader {
+		t.Errorf("Expect crypto/rand to be the default random source")
+	}
+
+	// A stuck plugged source does not make the mixed output stuck.
+	SetRandomSource(constantReader(0))
+	a, b := make([]byte, 32), make([]byte, 32)
+	if _, err := io.ReadFull(RandomSource(), a); err != nil {
+		t.Fatalf("Failed to read the random source (error: %v)", err)
+	}
+	if _, err := io.ReadFull(RandomSource(), b); err != nil {
+		t.Fatalf("Failed to read the random source (error: %v)", err)
+	}
+	if bytes.Equal(a, b) || bytes.Equal(a, make([]byte, 32)) {
+		t.Errorf("Expect the mixed output to be random but got %x and %x", a, b)
+	}
+
+	// The keys are still generated with a plugged source.
+	if cert, key := GenCert(CertOptions{Host: "test", NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour),
+		IsSelfSigned: true, RSAKeySize: 512}); len(cert) == 0 || len(key) == 0 {
+		t.Errorf("Failed to generate a certificate with a plugged random source")
+	}
+}
+
+func TestCheckRandomSource(t *testing.T) {
+	defer SetRandomSource(nil)
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	testCases := map[string]struct {
+		source      io.Reader
+		expectedErr string
+	}{
+		"crypto/rand only": {},
+		"Healthy plugged source": {
+			source: rand.Reader,
+		},
+		"Blocking plugged source": {
+			source:      blockingReader{unblock},
+			expectedErr: "the plugged random source: no output within",
+		},
+		"Stuck plugged source": {
+			source:      constantReader(7),
+			expectedErr: "the plugged random source: the output is stuck",
+		},
+		"Failing plugged source": {
+			source:      strings.NewReader("short"),
+			expectedErr: "the plugged random source: failed to read",
+		},
+	}
+
+	for k, tc := range testCases {
+		SetRandomSource(tc.source)
+		err := CheckRandomSource(100 * time.Millisecond)
+		if tc.expectedErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", k, err)
+			}
+		} else if err == nil || !strings.HasPrefix(err.Error(), tc.expectedErr) {
+			t.Errorf("%s: expecting error %q but got %v", k, tc.expectedErr, err)
+		}
+	}
+}
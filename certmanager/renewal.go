@@ -0,0 +1,46 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// The fraction of the certificate lifetime after which renewal is suggested.
+	renewalFraction = 0.8
+
+	// The fraction of the certificate lifetime by which the suggested renewal
+	// time is randomly moved earlier.
+	renewalJitterFraction = 0.1
+)
+
+// RenewalTime returns the suggested time to renew a certificate valid from
+// notBefore to notAfter: 80% into its lifetime, moved earlier by a random
+// jitter of up to 10% of the lifetime. Certificates issued in bulk thus get
+// renewed in a staggered fashion rather than all at once.
+func RenewalTime(notBefore, notAfter time.Time) time.Time {
+	lifetime := notAfter.Sub(notBefore)
+	if lifetime <= 0 {
+		return notBefore
+	}
+	renewAt := time.Duration(float64(lifetime) * renewalFraction)
+	if jitter := int64(float64(lifetime) * renewalJitterFraction); jitter > 0 {
+		// #nosec: the jitter does not need a cryptographically secure source.
+		renewAt -= time.Duration(rand.Int63n(jitter))
+	}
+	return notBefore.Add(renewAt)
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenewalTime(t *testing.T) {
+	notBefore := time.Date(2017, time.May, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := map[string]struct {
+		notAfter      time.Time
+		expectedEarly time.Time
+		expectedLate  time.Time
+	}{
+		"One hour certificate": {
+			notAfter:      notBefore.Add(time.Hour),
+			expectedEarly: notBefore.Add(42 * time.Minute),
+			expectedLate:  notBefore.Add(48 * time.Minute),
+		},
+		"Ten day certificate": {
+			notAfter:      notBefore.Add(240 * time.Hour),
+			expectedEarly: notBefore.Add(168 * time.Hour),
+			expectedLate:  notBefore.Add(192 * time.Hour),
+		},
+		"Invalid lifetime": {
+			notAfter:      notBefore.Add(-time.Hour),
+			expectedEarly: notBefore,
+			expectedLate:  notBefore,
+		},
+	}
+
+	for id, tc := range testCases {
+		jittered := false
+		var first time.Time
+		for i := 0; i < 100; i++ {
+			renewAt := RenewalTime(notBefore, tc.notAfter)
+			if renewAt.Before(tc.expectedEarly) || renewAt.After(tc.expectedLate) {
+				t.Errorf("%s: renewal time %v is not within [%v, %v]", id, renewAt, tc.expectedEarly, tc.expectedLate)
+			}
+			if i == 0 {
+				first = renewAt
+			} else if !renewAt.Equal(first) {
+				jittered = true
+			}
+		}
+		if !jittered && tc.expectedEarly != tc.expectedLate {
+			t.Errorf("%s: renewal time is not jittered", id)
+		}
+	}
+}
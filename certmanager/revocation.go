@@ -0,0 +1,148 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// RevocationListener is invoked with the serial number of every newly revoked certificate.
+type RevocationListener func(serial *big.Int)
+
+// RevocationList is a thread-safe record of revoked certificates. Listeners
+// registered on the list are notified synchronously on each revocation.
+type RevocationList struct {
+	mutex sync.RWMutex
+
+	// Maps from the string form of a serial number to the revocation time.
+	revoked   map[string]time.Time
+	listeners []RevocationListener
+
+	// If not nil, the revocations are written through to the store, and
+	// those of the other replicas are read by Sync.
+	store StateStore
+}
+
+// NewRevocationList returns a pointer to a new, empty RevocationList.
+func NewRevocationList() *RevocationList {
+	return &RevocationList{revoked: map[string]time.Time{}}
+}
+
+// NewPersistentRevocationList returns a pointer to a RevocationList
+// persisted in the store, holding the revocations already in the store.
+func NewPersistentRevocationList(store StateStore) (*RevocationList, error) {
+	revoked, err := store.Revocations()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the revocations (error: %v)", err)
+	}
+	return &RevocationList{revoked: revoked, store: store}, nil
+}
+
+// AddListener registers a listener to be notified of future revocations.
+func (rl *RevocationList) AddListener(l RevocationListener) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	rl.listeners = append(rl.listeners, l)
+}
+
+// Revoke marks the certificate with the given serial number as revoked and
+// notifies the listeners. Revoking an already revoked certificate is a no-op.
+func (rl *RevocationList) Revoke(serial *big.Int) {
+	rl.mutex.Lock()
+	key := serial.String()
+	if _, ok := rl.revoked[key]; ok {
+		rl.mutex.Unlock()
+		return
+	}
+	now := time.Now()
+	rl.revoked[key] = now
+	listeners := make([]RevocationListener, len(rl.listeners))
+	copy(listeners, rl.listeners)
+	rl.mutex.Unlock()
+
+	if rl.store != nil {
+		if err := rl.store.AddRevocation(serial, now); err != nil {
+			glog.Errorf("Failed to persist the revocation of certificate %x (error: %v)", serial, err)
+		}
+	}
+
+	// Listeners are called without holding the lock so that they can query the list.
+	for _, l := range listeners {
+		l(serial)
+	}
+}
+
+// Sync adds the revocations in the store made by the other replicas, and
+// notifies the listeners of them. It is a no-op if the list is not persistent.
+func (rl *RevocationList) Sync() error {
+	if rl.store == nil {
+		return nil
+	}
+	revoked, err := rl.store.Revocations()
+	if err != nil {
+		return err
+	}
+
+	rl.mutex.Lock()
+	added := []*big.Int{}
+	for key, t := range revoked {
+		if _, ok := rl.revoked[key]; ok {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(key, 10)
+		if !ok {
+			glog.Warningf("Ignoring the revocation of the malformed serial number %q in the state store", key)
+			continue
+		}
+		rl.revoked[key] = t
+		added = append(added, serial)
+	}
+	listeners := make([]RevocationListener, len(rl.listeners))
+	copy(listeners, rl.listeners)
+	rl.mutex.Unlock()
+
+	for _, serial := range added {
+		glog.Infof("Certificate %x has been revoked by another replica", serial)
+		for _, l := range listeners {
+			l(serial)
+		}
+	}
+	return nil
+}
+
+// IsRevoked returns whether the certificate with the given serial number has been revoked.
+func (rl *RevocationList) IsRevoked(serial *big.Int) bool {
+	rl.mutex.RLock()
+	defer rl.mutex.RUnlock()
+
+	_, ok := rl.revoked[serial.String()]
+	return ok
+}
+
+// RevokedAt returns when the certificate with the given serial number has been
+// revoked, and false if it has not been revoked.
+func (rl *RevocationList) RevokedAt(serial *big.Int) (time.Time, bool) {
+	rl.mutex.RLock()
+	defer rl.mutex.RUnlock()
+
+	t, ok := rl.revoked[serial.String()]
+	return t, ok
+}
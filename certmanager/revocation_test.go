@@ -0,0 +1,100 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestRevocationList(t *testing.T) {
+	rl := NewRevocationList()
+
+	notified := []*big.Int{}
+	rl.AddListener(func(serial *big.Int) {
+		notified = append(notified, serial)
+	})
+
+	serial := big.NewInt(12345)
+	if rl.IsRevoked(serial) {
+		t.Errorf("Serial %v should not be revoked before Revoke is called", serial)
+	}
+
+	rl.Revoke(serial)
+	// Revoking the same serial again must not notify the listener twice.
+	rl.Revoke(big.NewInt(12345))
+
+	if !rl.IsRevoked(serial) {
+		t.Errorf("Serial %v should be revoked", serial)
+	}
+	if rl.IsRevoked(big.NewInt(54321)) {
+		t.Errorf("Unexpected revocation of a serial that has not been revoked")
+	}
+	if len(notified) != 1 || notified[0].Cmp(serial) != 0 {
+		t.Errorf("Expecting the listener to be notified once with %v, actual notifications: %v", serial, notified)
+	}
+	if at, ok := rl.RevokedAt(serial); !ok || time.Since(at) > time.Minute {
+		t.Errorf("Unexpected revocation time %v of serial %v", at, serial)
+	}
+	if _, ok := rl.RevokedAt(big.NewInt(54321)); ok {
+		t.Errorf("Unexpected revocation time of a serial that has not been revoked")
+	}
+}
+
+func TestPersistentRevocationList(t *testing.T) {
+	store := newFakeStateStore()
+	revokedAt := time.Now().Add(-time.Hour)
+	store.revocations["1"] = revokedAt
+
+	rl, err := NewPersistentRevocationList(store)
+	if err != nil {
+		t.Fatalf("Failed to create the revocation list: %v", err)
+	}
+	if at, ok := rl.RevokedAt(big.NewInt(1)); !ok || !at.Equal(revokedAt) {
+		t.Errorf("Unexpected revocation time %v of the persisted revocation", at)
+	}
+
+	notified := []*big.Int{}
+	rl.AddListener(func(serial *big.Int) {
+		notified = append(notified, serial)
+	})
+
+	rl.Revoke(big.NewInt(2))
+	if _, ok := store.revocations["2"]; !ok {
+		t.Errorf("The revocation has not been written to the store")
+	}
+
+	// Revoked by another replica.
+	store.revocations["3"] = time.Now()
+	if err := rl.Sync(); err != nil {
+		t.Fatalf("Failed to sync the revocation list: %v", err)
+	}
+	if !rl.IsRevoked(big.NewInt(3)) {
+		t.Errorf("The revocation by another replica has not been synced")
+	}
+	if len(notified) != 2 || notified[0].Cmp(big.NewInt(2)) != 0 || notified[1].Cmp(big.NewInt(3)) != 0 {
+		t.Errorf("Unexpected notifications: %v", notified)
+	}
+
+	store.err = errors.New("unavailable")
+	if err := rl.Sync(); err == nil {
+		t.Errorf("Expecting an error syncing from a failing store")
+	}
+	if _, err := NewPersistentRevocationList(store); err == nil {
+		t.Errorf("Expecting an error loading from a failing store")
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"sort"
+
+	"github.com/golang/glog"
+)
+
+// stagedRoot is the new self-signed root of a staged root rotation. Until the
+// rotation completes, it only signs the certificates of the namespaces rolled
+// out to it, while both roots are trusted.
+type stagedRoot struct {
+	cert    *x509.Certificate
+	key     crypto.PrivateKey
+	certPEM []byte
+
+	namespaces map[string]bool
+}
+
+// StartStagedRootRotation generates a new self-signed CA certificate and key
+// and adds the new root to the trust bundle returned by GetRootCertificate,
+// without signing anything with it yet. The workload certificates of a
+// namespace are only issued by the new root once the namespace is rolled out
+// with RolloutRoot, so that the new root can be rolled out gradually.
+func (ca *IstioCA) StartStagedRootRotation() error {
+	if ca.selfSignedOpts == nil {
+		return errors.New("root rotation is only supported by self-signed CA")
+	}
+
+	pemCert, pemKey := genSelfSignedCACert(ca.selfSignedOpts)
+	cert := ParsePemEncodedCertificate(pemCert)
+	key := parsePemEncodedKey(cert.PublicKeyAlgorithm, pemKey)
+
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	if ca.staged != nil {
+		return errors.New("a staged root rotation is already in progress")
+	}
+	ca.staged = &stagedRoot{
+		cert:       cert,
+		key:        key,
+		certPEM:    pemCert,
+		namespaces: map[string]bool{},
+	}
+	glog.Infof("Started a staged root rotation to the root with serial number %x", cert.SerialNumber)
+	return nil
+}
+
+// RolloutRoot makes the new root of the staged root rotation issue the
+// workload certificates of the namespace from now on.
+func (ca *IstioCA) RolloutRoot(namespace string) error {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	if ca.staged == nil {
+		return errors.New("no staged root rotation is in progress")
+	}
+	ca.staged.namespaces[namespace] = true
+	if ca.namespaceCAs != nil {
+		ca.namespaceCAs.discard(namespace)
+	}
+	return nil
+}
+
+// StagedRootNamespaces returns the namespaces rolled out to the new root, in
+// order, and whether a staged root rotation is in progress.
+func (ca *IstioCA) StagedRootNamespaces() ([]string, bool) {
+	ca.mutex.RLock()
+	defer ca.mutex.RUnlock()
+
+	if ca.staged == nil {
+		return nil, false
+	}
+	namespaces := []string{}
+	for ns := range ca.staged.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces, true
+}
+
+// GetStagedRootCertificate returns the PEM-encoded new root of the staged
+// root rotation, or nil if none is in progress.
+func (ca *IstioCA) GetStagedRootCertificate() []byte {
+	ca.mutex.RLock()
+	defer ca.mutex.RUnlock()
+
+	if ca.staged == nil {
+		return nil
+	}
+	return copyBytes(ca.staged.certPEM)
+}
+
+// CompleteStagedRootRotation makes the new root issue all the certificates
+// and removes the old root from the trust bundle. The workload certificates
+// of all namespaces should have been re-issued by the new root beforehand.
+func (ca *IstioCA) CompleteStagedRootRotation() error {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	if ca.staged == nil {
+		return errors.New("no staged root rotation is in progress")
+	}
+	ca.signingCert = ca.staged.cert
+	ca.signingKey = ca.staged.key
+	ca.rootCertBytes = ca.staged.certPEM
+	ca.staged = nil
+	if ca.namespaceCAs != nil {
+		ca.namespaceCAs.reset()
+	}
+	glog.Infof("Completed the staged root rotation to the root with serial number %x", ca.signingCert.SerialNumber)
+	return nil
+}
+
+// AbortStagedRootRotation discards the new root of the staged root rotation,
+// if any. The namespaces rolled out to it are issued by the old root again.
+func (ca *IstioCA) AbortStagedRootRotation() {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	if ca.staged == nil {
+		return
+	}
+	if ca.namespaceCAs != nil {
+		for ns := range ca.staged.namespaces {
+			ca.namespaceCAs.discard(ns)
+		}
+	}
+	ca.staged = nil
+	glog.Info("Aborted the staged root rotation")
+}
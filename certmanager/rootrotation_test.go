@@ -0,0 +1,151 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStagedRootRotation(t *testing.T) {
+	testCases := map[string]struct {
+		namespaceCATTL time.Duration
+	}{
+		"Workload certificates signed by the root": {},
+		"Workload certificates signed by the namespace CAs": {
+			namespaceCATTL: time.Hour,
+		},
+	}
+
+	for id, tc := range testCases {
+		ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+			CACertTTL:      2 * time.Hour,
+			CertTTL:        time.Minute,
+			Subject:        pkix.Name{Organization: []string{"test.ca.org"}},
+			MaxPathLen:     -1,
+			NamespaceCATTL: tc.namespaceCATTL,
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to create a self-signed CA: %v", id, err)
+		}
+		oldRoot := ca.GetRootCertificate()
+
+		// verifiedBy returns "old" or "new" depending on the root that issues the certificates of the namespace.
+		verifiedBy := func(namespace string, newRoot []byte) string {
+			chain, _, err := ca.Generate("foo", namespace)
+			if err != nil {
+				t.Fatalf("%s: failed to generate a certificate: %v", id, err)
+			}
+			leaf := ParsePemEncodedCertificate(chain)
+			intermediates := x509.NewCertPool()
+			intermediates.AppendCertsFromPEM(chain)
+			for name, root := range map[string][]byte{"old": oldRoot, "new": newRoot} {
+				pool := x509.NewCertPool()
+				pool.AppendCertsFromPEM(root)
+				if _, err := leaf.Verify(x509.VerifyOptions{Roots: pool, Intermediates: intermediates}); err == nil {
+					return name
+				}
+			}
+			return "none"
+		}
+
+		if err := ca.StartStagedRootRotation(); err != nil {
+			t.Fatalf("%s: failed to start the staged root rotation: %v", id, err)
+		}
+		if err := ca.StartStagedRootRotation(); err == nil {
+			t.Errorf("%s: starting a second staged root rotation should fail", id)
+		}
+		if err := ca.RotateRoot(); err == nil {
+			t.Errorf("%s: rotating the root during a staged root rotation should fail", id)
+		}
+		newRoot := ca.GetStagedRootCertificate()
+		if bundle := ca.GetRootCertificate(); !bytes.Equal(bundle, append(append([]byte{}, oldRoot...), newRoot...)) {
+			t.Errorf("%s: expect the trust bundle to hold both roots", id)
+		}
+		if by := verifiedBy("bar", newRoot); by != "old" {
+			t.Errorf("%s: expect the old root to issue certificates before the rollout, but got %s", id, by)
+		}
+
+		if err := ca.RolloutRoot("bar"); err != nil {
+			t.Fatalf("%s: failed to roll out namespace bar: %v", id, err)
+		}
+		if by := verifiedBy("bar", newRoot); by != "new" {
+			t.Errorf("%s: expect the new root to issue the certificates of bar, but got %s", id, by)
+		}
+		if by := verifiedBy("baz", newRoot); by != "old" {
+			t.Errorf("%s: expect the old root to issue the certificates of baz, but got %s", id, by)
+		}
+		if namespaces, ok := ca.StagedRootNamespaces(); !ok || !reflect.DeepEqual(namespaces, []string{"bar"}) {
+			t.Errorf("%s: unexpected rolled out namespaces %v", id, namespaces)
+		}
+
+		if err := ca.CompleteStagedRootRotation(); err != nil {
+			t.Fatalf("%s: failed to complete the staged root rotation: %v", id, err)
+		}
+		if root := ca.GetRootCertificate(); !bytes.Equal(root, newRoot) {
+			t.Errorf("%s: expect the new root to be the only root after the rotation", id)
+		}
+		if by := verifiedBy("baz", newRoot); by != "new" {
+			t.Errorf("%s: expect the new root to issue the certificates of baz, but got %s", id, by)
+		}
+		if _, ok := ca.StagedRootNamespaces(); ok {
+			t.Errorf("%s: expect no staged root rotation to be in progress", id)
+		}
+	}
+}
+
+func TestAbortStagedRootRotation(t *testing.T) {
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:  time.Hour,
+		CertTTL:    time.Minute,
+		Subject:    pkix.Name{Organization: []string{"test.ca.org"}},
+		MaxPathLen: -1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+	oldRoot := ca.GetRootCertificate()
+
+	if err := ca.RolloutRoot("bar"); err == nil {
+		t.Error("Rolling out a namespace without a staged root rotation should fail")
+	}
+	if err := ca.StartStagedRootRotation(); err != nil {
+		t.Fatalf("Failed to start the staged root rotation: %v", err)
+	}
+	if err := ca.RolloutRoot("bar"); err != nil {
+		t.Fatalf("Failed to roll out namespace bar: %v", err)
+	}
+	ca.AbortStagedRootRotation()
+
+	if root := ca.GetRootCertificate(); !bytes.Equal(root, oldRoot) {
+		t.Error("Expect the old root to be the only root after the abort")
+	}
+	chain, _, err := ca.Generate("foo", "bar")
+	if err != nil {
+		t.Fatalf("Failed to generate a certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(oldRoot)
+	if _, err := ParsePemEncodedCertificate(chain).Verify(x509.VerifyOptions{Roots: pool}); err != nil {
+		t.Errorf("Expect the old root to issue the certificates of bar again (error: %v)", err)
+	}
+	if err := ca.CompleteStagedRootRotation(); err == nil {
+		t.Error("Completing an aborted staged root rotation should fail")
+	}
+}
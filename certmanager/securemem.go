@@ -0,0 +1,53 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+// SecureBuffer holds secret bytes, e.g. a DER-encoded private key, out of the
+// Go heap in memory locked against swapping, and zeroes them once wiped. Go
+// copies the keys it parses onto the heap, so the buffer suits keys at rest;
+// the keys in use are protected by LockProcessMemory.
+type SecureBuffer struct {
+	mem []byte
+	n   int
+}
+
+// NewSecureBuffer copies data into a SecureBuffer. The caller should wipe
+// data afterwards. It fails where memory cannot be locked.
+func NewSecureBuffer(data []byte) (*SecureBuffer, error) {
+	mem, err := lockMemory(len(data))
+	if err != nil {
+		return nil, err
+	}
+	return &SecureBuffer{mem: mem, n: copy(mem, data)}, nil
+}
+
+// Bytes returns the content of the buffer, which is only valid until Wipe.
+func (b *SecureBuffer) Bytes() []byte {
+	return b.mem[:b.n]
+}
+
+// Wipe zeroes and releases the memory of the buffer.
+func (b *SecureBuffer) Wipe() {
+	wipeBytes(b.mem)
+	unlockMemory(b.mem)
+	b.mem, b.n = nil, 0
+}
+
+// wipeBytes zeroes b, e.g. a transient encoding of a private key.
+func wipeBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
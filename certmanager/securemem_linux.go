@@ -0,0 +1,83 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// RLIMIT_MEMLOCK and RLIM_INFINITY as a limit value, which the syscall
+// package does not define.
+const (
+	rlimitMemlock   = 0x8
+	rlimitUnlimited = ^uint64(0)
+)
+
+// lockMemory returns n bytes of anonymous memory mapped out of the Go heap
+// and locked against swapping.
+func lockMemory(n int) ([]byte, error) {
+	mem, err := syscall.Mmap(-1, 0, n, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Mlock(mem); err != nil {
+		// nolint: errcheck
+		syscall.Munmap(mem)
+		return nil, err
+	}
+	return mem, nil
+}
+
+// unlockMemory releases memory returned by lockMemory.
+func unlockMemory(mem []byte) {
+	// nolint: errcheck
+	syscall.Munlock(mem)
+	// nolint: errcheck
+	syscall.Munmap(mem)
+}
+
+// LockProcessMemory locks the current and future memory of the process
+// against swapping, which covers the signing key of the CA and the transient
+// workload keys, and disables the core dumps of the process. It requires an
+// unlimited RLIMIT_MEMLOCK, e.g. the IPC_LOCK capability, as the allocations
+// beyond a finite limit would fail once the future memory is locked.
+func LockProcessMemory() error {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(rlimitMemlock, &limit); err != nil {
+		return fmt.Errorf("failed to read RLIMIT_MEMLOCK (error: %v)", err)
+	}
+	if limit.Cur != rlimitUnlimited {
+		return errors.New("RLIMIT_MEMLOCK is limited: grant the IPC_LOCK capability or raise the limit")
+	}
+	if err := syscall.Mlockall(syscall.MCL_CURRENT | syscall.MCL_FUTURE); err != nil {
+		return fmt.Errorf("failed to lock the memory (error: %v)", err)
+	}
+
+	return disableCoreDumps()
+}
+
+// disableCoreDumps sets RLIMIT_CORE to 0 and makes the process non-dumpable,
+// which also prevents the other processes of the user from tracing it.
+func disableCoreDumps() error {
+	if err := syscall.Setrlimit(syscall.RLIMIT_CORE, &syscall.Rlimit{}); err != nil {
+		return fmt.Errorf("failed to disable the core dumps (error: %v)", err)
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_SET_DUMPABLE, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to make the process non-dumpable (error: %v)", errno)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package certmanager
+
+import "errors"
+
+var (
+	errSecureMemoryUnsupported = errors.New("secure memory is only supported on Linux")
+	errCoreDumpsUnsupported    = errors.New("disabling the core dumps is only supported on Linux")
+)
+
+// lockMemory always fails: locking memory against swapping is only
+// implemented on Linux.
+func lockMemory(n int) ([]byte, error) {
+	return nil, errSecureMemoryUnsupported
+}
+
+func unlockMemory(mem []byte) {}
+
+// LockProcessMemory always fails, see lockMemory.
+func LockProcessMemory() error {
+	return errSecureMemoryUnsupported
+}
+
+// disableCoreDumps always fails: it is only implemented on Linux.
+func disableCoreDumps() error {
+	return errCoreDumpsUnsupported
+}
@@ -0,0 +1,46 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestSecureBuffer(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Locked memory is only supported on Linux")
+	}
+	data := []byte("secret")
+	buf, err := NewSecureBuffer(data)
+	if err != nil {
+		t.Fatalf("Failed to lock memory (error: %v)", err)
+	}
+	if string(buf.Bytes()) != "secret" {
+		t.Errorf("Unexpected content %q", buf.Bytes())
+	}
+	buf.Wipe()
+	if len(buf.Bytes()) != 0 {
+		t.Errorf("Expect the buffer to be empty once wiped")
+	}
+}
+
+func TestWipeBytes(t *testing.T) {
+	b := []byte("secret")
+	wipeBytes(b)
+	if string(b) != string(make([]byte, 6)) {
+		t.Errorf("Expect the bytes to be zeroed but got %q", b)
+	}
+}
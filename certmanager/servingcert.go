@@ -0,0 +1,110 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// ServingCertificate is the TLS certificate that a server of Istio CA, e.g.
+// the CSR or admin API, presents for its hosts. It generates the key itself
+// and has the CA sign it, so that it works with key escrow disabled. The
+// certificate is reissued once half of its lifetime has passed.
+type ServingCertificate struct {
+	sign  func(csrPEM []byte) (chain []byte, err error)
+	hosts []string
+
+	mutex   sync.Mutex
+	cert    *tls.Certificate
+	renewAt time.Time
+}
+
+// NewServingCertificate returns a pointer to a ServingCertificate for the DNS
+// names and IP addresses in hosts, which is signed with the sign function,
+// e.g. the Sign method of the CA.
+func NewServingCertificate(sign func(csrPEM []byte) (chain []byte, err error), hosts []string) *ServingCertificate {
+	return &ServingCertificate{sign: sign, hosts: hosts}
+}
+
+// GetCertificate returns the certificate, issuing it first if it has not been
+// issued yet or half of its lifetime has passed. It may be used as the
+// GetCertificate of a tls.Config.
+func (c *ServingCertificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if c.cert != nil && now.Before(c.renewAt) {
+		return c.cert, nil
+	}
+
+	cert, err := c.issue()
+	if err != nil {
+		if c.cert != nil && now.Before(c.cert.Leaf.NotAfter) {
+			glog.Errorf("Failed to renew the TLS certificate for %v (error: %v)", c.hosts, err)
+			return c.cert, nil
+		}
+		return nil, err
+	}
+	c.cert = cert
+	c.renewAt = cert.Leaf.NotBefore.Add(cert.Leaf.NotAfter.Sub(cert.Leaf.NotBefore) / 2)
+	return c.cert, nil
+}
+
+func (c *ServingCertificate) issue() (*tls.Certificate, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.CertificateRequest{}
+	for _, h := range c.hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, priv)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := c.sign(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(chain, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"crypto/x509/pkix"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestServingCertificate(t *testing.T) {
+	ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+		CACertTTL:  time.Hour,
+		CertTTL:    30 * time.Minute,
+		Subject:    pkix.Name{Organization: []string{"test.ca.org"}},
+		MaxPathLen: -1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+
+	failing := false
+	c := NewServingCertificate(func(csrPEM []byte) ([]byte, error) {
+		if failing {
+			return nil, errors.New("signing failed")
+		}
+		return ca.Sign(csrPEM)
+	}, []string{"istio-ca", "10.0.0.1"})
+	cert, err := c.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("Failed to get the TLS certificate: %v", err)
+	}
+	if len(cert.Leaf.DNSNames) != 1 || cert.Leaf.DNSNames[0] != "istio-ca" {
+		t.Errorf("Unexpected DNS names %v", cert.Leaf.DNSNames)
+	}
+	if len(cert.Leaf.IPAddresses) != 1 || cert.Leaf.IPAddresses[0].String() != "10.0.0.1" {
+		t.Errorf("Unexpected IP addresses %v", cert.Leaf.IPAddresses)
+	}
+
+	if again, _ := c.GetCertificate(nil); again != cert {
+		t.Error("The TLS certificate should be reused until half of its lifetime has passed")
+	}
+
+	c.renewAt = time.Now()
+	failing = true
+	if kept, err := c.GetCertificate(nil); err != nil || kept != cert {
+		t.Errorf("The unexpired TLS certificate should be kept if renewing it fails (error: %v)", err)
+	}
+	failing = false
+	if renewed, _ := c.GetCertificate(nil); renewed == cert {
+		t.Error("The TLS certificate should be reissued once half of its lifetime has passed")
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sort"
+)
+
+// The signature algorithms the CA may be configured to sign with, keyed by
+// their names, and the type of signing key each needs.
+var signatureAlgorithms = map[string]struct {
+	algo    x509.SignatureAlgorithm
+	keyAlgo x509.PublicKeyAlgorithm
+}{
+	x509.SHA256WithRSA.String():   {x509.SHA256WithRSA, x509.RSA},
+	x509.SHA384WithRSA.String():   {x509.SHA384WithRSA, x509.RSA},
+	x509.SHA512WithRSA.String():   {x509.SHA512WithRSA, x509.RSA},
+	x509.ECDSAWithSHA256.String(): {x509.ECDSAWithSHA256, x509.ECDSA},
+	x509.ECDSAWithSHA384.String(): {x509.ECDSAWithSHA384, x509.ECDSA},
+	x509.ECDSAWithSHA512.String(): {x509.ECDSAWithSHA512, x509.ECDSA},
+}
+
+// The signature algorithms whose hashes are broken, which the CA neither signs
+// with nor accepts in the signing certificate and its chain.
+var weakSignatureAlgorithms = map[x509.SignatureAlgorithm]bool{
+	x509.MD2WithRSA:    true,
+	x509.MD5WithRSA:    true,
+	x509.SHA1WithRSA:   true,
+	x509.DSAWithSHA1:   true,
+	x509.ECDSAWithSHA1: true,
+}
+
+// SignatureAlgorithmNames returns the sorted names of the signature algorithms
+// accepted by ParseSignatureAlgorithm.
+func SignatureAlgorithmNames() []string {
+	names := []string{}
+	for name := range signatureAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseSignatureAlgorithm returns the signature algorithm of the name, e.g.
+// "SHA384-RSA" or "ECDSA-SHA256", or zero, which stands for the default of the
+// signing key, if the name is empty. Weak algorithms are refused.
+func ParseSignatureAlgorithm(name string) (x509.SignatureAlgorithm, error) {
+	if name == "" {
+		return x509.UnknownSignatureAlgorithm, nil
+	}
+	if a, ok := signatureAlgorithms[name]; ok {
+		return a.algo, nil
+	}
+	for weak := range weakSignatureAlgorithms {
+		if weak.String() == name {
+			return x509.UnknownSignatureAlgorithm, fmt.Errorf("the signature algorithm %s is too weak: use one of %v",
+				name, SignatureAlgorithmNames())
+		}
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported signature algorithm %q: expect one of %v",
+		name, SignatureAlgorithmNames())
+}
+
+// checkSignatureAlgorithm returns an error unless a key of keyAlgo can sign
+// with the algorithm, or the algorithm is zero.
+func checkSignatureAlgorithm(algo x509.SignatureAlgorithm, keyAlgo x509.PublicKeyAlgorithm) error {
+	if algo == x509.UnknownSignatureAlgorithm {
+		return nil
+	}
+	a, ok := signatureAlgorithms[algo.String()]
+	if !ok {
+		return fmt.Errorf("invalid parameters: unsupported signature algorithm %s", algo)
+	}
+	if a.keyAlgo != keyAlgo {
+		return fmt.Errorf("invalid parameters: the signature algorithm %s needs an %s signing key, not %s",
+			algo, a.keyAlgo, keyAlgo)
+	}
+	return nil
+}
+
+// checkNotWeak returns an error if the signing cert or a certificate of the
+// PEM-encoded chain is signed with a weak algorithm. The root certificate is
+// not checked, as its signature is never relied upon.
+func checkNotWeak(signingCert *x509.Certificate, certChainBytes []byte) error {
+	certs := []*x509.Certificate{signingCert}
+	for rest := certChainBytes; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("invalid parameters: malformed certificate in the cert chain (error: %v)", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	for i, cert := range certs {
+		if !weakSignatureAlgorithms[cert.SignatureAlgorithm] {
+			continue
+		}
+		name := "the signing cert"
+		if i > 0 {
+			name = fmt.Sprintf("certificate %d of the cert chain", i)
+		}
+		return fmt.Errorf("invalid parameters: %s (%q) is signed with the weak algorithm %s; re-issue it with "+
+			"SHA-256 or stronger", name, cert.Subject.String(), cert.SignatureAlgorithm)
+	}
+	return nil
+}
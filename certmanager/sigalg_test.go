@@ -0,0 +1,187 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSignatureAlgorithm(t *testing.T) {
+	testCases := map[string]struct {
+		name        string
+		expected    x509.SignatureAlgorithm
+		expectedErr string
+	}{
+		"Default": {
+			name:     "",
+			expected: x509.UnknownSignatureAlgorithm,
+		},
+		"RSA with SHA-384": {
+			name:     "SHA384-RSA",
+			expected: x509.SHA384WithRSA,
+		},
+		"ECDSA with SHA-512": {
+			name:     "ECDSA-SHA512",
+			expected: x509.ECDSAWithSHA512,
+		},
+		"SHA-1": {
+			name:        "SHA1-RSA",
+			expectedErr: "the signature algorithm SHA1-RSA is too weak",
+		},
+		"MD5": {
+			name:        "MD5-RSA",
+			expectedErr: "the signature algorithm MD5-RSA is too weak",
+		},
+		"Unsupported": {
+			name:        "SHA256-RSAPSS",
+			expectedErr: "unsupported signature algorithm \"SHA256-RSAPSS\"",
+		},
+	}
+	for id, tc := range testCases {
+		algo, err := ParseSignatureAlgorithm(tc.name)
+		if tc.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: expected error %q, got %v", id, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil || algo != tc.expected {
+			t.Errorf("%s: expected %v, got %v (error: %v)", id, tc.expected, algo, err)
+		}
+	}
+}
+
+func TestSignatureAlgorithm(t *testing.T) {
+	testCases := map[string]struct {
+		algo           x509.SignatureAlgorithm
+		namespaceCATTL time.Duration
+		expectedErr    string
+	}{
+		"Default": {
+			algo: x509.UnknownSignatureAlgorithm,
+		},
+		"RSA with SHA-512": {
+			algo:           x509.SHA512WithRSA,
+			namespaceCATTL: time.Hour,
+		},
+		"ECDSA for an RSA key": {
+			algo:        x509.ECDSAWithSHA256,
+			expectedErr: "invalid parameters: the signature algorithm ECDSA-SHA256 needs an ECDSA signing key, not RSA",
+		},
+	}
+	for id, tc := range testCases {
+		ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+			CACertTTL:          2 * time.Hour,
+			CertTTL:            time.Minute,
+			Subject:            pkix.Name{Organization: []string{"test.ca.org"}},
+			MaxPathLen:         -1,
+			NamespaceCATTL:     tc.namespaceCATTL,
+			SignatureAlgorithm: tc.algo,
+		})
+		if tc.expectedErr != "" {
+			if err == nil || err.Error() != tc.expectedErr {
+				t.Errorf("%s: expected error %q, got %v", id, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: failed to create the CA: %v", id, err)
+			continue
+		}
+
+		expected := tc.algo
+		if expected == x509.UnknownSignatureAlgorithm {
+			expected = x509.SHA256WithRSA
+		}
+		chain, _, err := ca.Generate("foo", "bar")
+		if err != nil {
+			t.Errorf("%s: failed to generate a certificate: %v", id, err)
+			continue
+		}
+		for rest := append(chain, ca.GetRootCertificate()...); ; {
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				break
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cert.SignatureAlgorithm != expected {
+				t.Errorf("%s: %q is signed with %v, expected %v", id, cert.Subject, cert.SignatureAlgorithm, expected)
+			}
+		}
+	}
+}
+
+func TestCheckNotWeak(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := genCertTemplate(CertOptions{
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+		Org:       "sha1.ca.org",
+		IsCA:      true,
+	})
+	template.SignatureAlgorithm = x509.SHA1WithRSA
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Skipf("SHA-1 signatures are not supported: %v", err)
+	}
+	sha1Cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha1PEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	strong := &x509.Certificate{SignatureAlgorithm: x509.SHA256WithRSA}
+
+	testCases := map[string]struct {
+		signingCert *x509.Certificate
+		certChain   []byte
+		expectedErr string
+	}{
+		"Strong": {
+			signingCert: strong,
+		},
+		"SHA-1 signing cert": {
+			signingCert: sha1Cert,
+			expectedErr: "the signing cert (\"O=sha1.ca.org\") is signed with the weak algorithm SHA1-RSA",
+		},
+		"SHA-1 intermediate": {
+			signingCert: strong,
+			certChain:   sha1PEM,
+			expectedErr: "certificate 1 of the cert chain (\"O=sha1.ca.org\") is signed with the weak algorithm SHA1-RSA",
+		},
+	}
+	for id, tc := range testCases {
+		err := checkNotWeak(tc.signingCert, tc.certChain)
+		if tc.expectedErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", id, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+			t.Errorf("%s: expected error %q, got %v", id, tc.expectedErr, err)
+		}
+	}
+}
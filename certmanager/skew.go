@@ -0,0 +1,200 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	ntpPort      = "123"
+	ntpPacketLen = 48
+
+	// The seconds between the NTP epoch (1900) and the Unix epoch (1970).
+	ntpEpochOffset = 2208988800
+)
+
+var clockSkew = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "istio_ca",
+	Name:      "clock_skew_seconds",
+	Help:      "The measured offset of the local clock against the reference clock; positive if the local clock is ahead.",
+})
+
+func init() {
+	prometheus.MustRegister(clockSkew)
+}
+
+// ClockSkewSource measures the offset of the local clock against a reference clock.
+type ClockSkewSource interface {
+	// ClockSkew returns the offset, which is positive if the local clock is ahead.
+	ClockSkew() (time.Duration, error)
+}
+
+// HTTPDateClockSkewSource measures the clock skew against the Date header
+// in the responses of an HTTP server, e.g. the Kubernetes API server. The
+// Date header has a resolution of one second.
+type HTTPDateClockSkewSource struct {
+	Client *http.Client
+	URL    string
+}
+
+// ClockSkew implements ClockSkewSource.
+func (s HTTPDateClockSkewSource) ClockSkew() (time.Duration, error) {
+	sent := time.Now()
+	resp, err := s.Client.Get(s.URL)
+	if err != nil {
+		return 0, err
+	}
+	received := time.Now()
+	defer resp.Body.Close() // nolint: errcheck
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid Date header %q in the response of %s", resp.Header.Get("Date"), s.URL)
+	}
+	// Assume the server took its time halfway through the round trip.
+	local := sent.Add(received.Sub(sent) / 2)
+	return local.Sub(date), nil
+}
+
+// NTPClockSkewSource measures the clock skew against an NTP server with a
+// single SNTP query.
+type NTPClockSkewSource struct {
+	// The host of the NTP server, optionally with a port.
+	Server  string
+	Timeout time.Duration
+}
+
+// ClockSkew implements ClockSkewSource.
+func (s NTPClockSkewSource) ClockSkew() (time.Duration, error) {
+	addr := s.Server
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, ntpPort)
+	}
+	conn, err := net.DialTimeout("udp", addr, s.Timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close() // nolint: errcheck
+	if s.Timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(s.Timeout)); err != nil {
+			return 0, err
+		}
+	}
+
+	req := make([]byte, ntpPacketLen)
+	// Leap indicator 0, version 4, client mode.
+	req[0] = 0x23
+	sent := time.Now()
+	binary.BigEndian.PutUint64(req[40:], toNTPTime(sent))
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+
+	resp := make([]byte, ntpPacketLen)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return 0, err
+	}
+	received := time.Now()
+	if n < ntpPacketLen || resp[0]&0x7 != 4 {
+		return 0, errors.New("invalid NTP response")
+	}
+
+	// The clock offset of the server as defined by RFC 4330; the skew of the
+	// local clock is its negation.
+	serverReceived := fromNTPTime(binary.BigEndian.Uint64(resp[32:]))
+	serverSent := fromNTPTime(binary.BigEndian.Uint64(resp[40:]))
+	offset := (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2
+	return -offset, nil
+}
+
+func toNTPTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+func fromNTPTime(ts uint64) time.Time {
+	secs := int64(ts>>32) - ntpEpochOffset
+	nanos := int64((ts & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(secs, nanos)
+}
+
+// ClockSkewMonitor periodically measures the clock skew and exports it as a metric.
+type ClockSkewMonitor struct {
+	mutex sync.RWMutex
+
+	source   ClockSkewSource
+	interval time.Duration
+
+	skew     time.Duration
+	measured bool
+}
+
+// NewClockSkewMonitor returns a pointer to a ClockSkewMonitor measuring
+// against the source every interval.
+func NewClockSkewMonitor(source ClockSkewSource, interval time.Duration) *ClockSkewMonitor {
+	return &ClockSkewMonitor{source: source, interval: interval}
+}
+
+// Run measures the clock skew right away and then every interval until stopCh is closed.
+func (m *ClockSkewMonitor) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.measure()
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Skew returns the latest measured clock skew, and false if none has been
+// measured successfully.
+func (m *ClockSkewMonitor) Skew() (time.Duration, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.skew, m.measured
+}
+
+// measure keeps the previous measurement on error.
+func (m *ClockSkewMonitor) measure() {
+	skew, err := m.source.ClockSkew()
+	if err != nil {
+		glog.Warningf("Failed to measure the clock skew (error: %v)", err)
+		return
+	}
+
+	m.mutex.Lock()
+	m.skew = skew
+	m.measured = true
+	m.mutex.Unlock()
+
+	clockSkew.Set(skew.Seconds())
+}
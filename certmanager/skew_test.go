@@ -0,0 +1,152 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeClockSkewSource struct {
+	skew time.Duration
+	err  error
+}
+
+func (s fakeClockSkewSource) ClockSkew() (time.Duration, error) {
+	return s.skew, s.err
+}
+
+func TestHTTPDateClockSkewSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	}))
+	defer server.Close()
+
+	skew, err := HTTPDateClockSkewSource{Client: http.DefaultClient, URL: server.URL}.ClockSkew()
+	if err != nil {
+		t.Fatalf("Failed to measure the clock skew: %v", err)
+	}
+	if skew < time.Hour-2*time.Second || skew > time.Hour+2*time.Second {
+		t.Errorf("Expect a clock skew of about 1h but got %v", skew)
+	}
+}
+
+func TestNTPClockSkewSource(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close() // nolint: errcheck
+
+	go func() {
+		req := make([]byte, ntpPacketLen)
+		_, addr, err := conn.ReadFrom(req)
+		if err != nil {
+			return
+		}
+		// A server whose clock is 30 seconds behind.
+		now := toNTPTime(time.Now().Add(-30 * time.Second))
+		resp := make([]byte, ntpPacketLen)
+		resp[0] = 0x24
+		copy(resp[24:], req[40:48])
+		binary.BigEndian.PutUint64(resp[32:], now)
+		binary.BigEndian.PutUint64(resp[40:], now)
+		conn.WriteTo(resp, addr) // nolint: errcheck
+	}()
+
+	skew, err := NTPClockSkewSource{Server: conn.LocalAddr().String(), Timeout: 5 * time.Second}.ClockSkew()
+	if err != nil {
+		t.Fatalf("Failed to measure the clock skew: %v", err)
+	}
+	if skew < 29*time.Second || skew > 31*time.Second {
+		t.Errorf("Expect a clock skew of about 30s but got %v", skew)
+	}
+}
+
+func TestNTPTime(t *testing.T) {
+	now := time.Unix(1500000000, 123456789)
+	if d := fromNTPTime(toNTPTime(now)).Sub(now); d < -time.Nanosecond || d > time.Nanosecond {
+		t.Errorf("Expect the NTP time to round trip but it is off by %v", d)
+	}
+}
+
+func TestClockSkewMonitor(t *testing.T) {
+	m := NewClockSkewMonitor(fakeClockSkewSource{err: errors.New("unreachable")}, time.Minute)
+	m.measure()
+	if _, ok := m.Skew(); ok {
+		t.Error("Expect no skew to be measured after an error")
+	}
+
+	m.source = fakeClockSkewSource{skew: time.Minute}
+	m.measure()
+	m.source = fakeClockSkewSource{err: errors.New("unreachable")}
+	m.measure()
+	if skew, ok := m.Skew(); !ok || skew != time.Minute {
+		t.Errorf("Expect the previous skew of 1m to be kept but got %v (measured: %t)", skew, ok)
+	}
+}
+
+func TestIssueWithClockSkew(t *testing.T) {
+	testCases := map[string]struct {
+		skew         time.Duration
+		maxSkew      time.Duration
+		expectRefuse bool
+	}{
+		"Skew within the maximum": {
+			skew:    time.Second,
+			maxSkew: time.Minute,
+		},
+		"Local clock too far ahead": {
+			skew:         time.Hour,
+			maxSkew:      time.Minute,
+			expectRefuse: true,
+		},
+		"Local clock too far behind": {
+			skew:         -time.Hour,
+			maxSkew:      time.Minute,
+			expectRefuse: true,
+		},
+		"Gate disabled": {
+			skew: time.Hour,
+		},
+	}
+
+	for k, tc := range testCases {
+		m := NewClockSkewMonitor(fakeClockSkewSource{skew: tc.skew}, time.Minute)
+		m.measure()
+		ca, err := NewSelfSignedIstioCA(&SelfSignedIstioCAOptions{
+			CACertTTL:    time.Hour,
+			CertTTL:      time.Minute,
+			MaxPathLen:   -1,
+			ClockSkew:    m,
+			MaxClockSkew: tc.maxSkew,
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to create a self-signed CA: %v", k, err)
+		}
+
+		_, _, err = ca.Generate("foo", "bar")
+		if tc.expectRefuse && err == nil {
+			t.Errorf("%s: expect issuance to be refused", k)
+		} else if !tc.expectRefuse && err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"errors"
+	"math/big"
+	"time"
+)
+
+// ErrDuplicateSerial is returned by StateStore.AddIssuance if a certificate
+// with the same serial number has already been recorded.
+var ErrDuplicateSerial = errors.New("a certificate with the same serial number has already been issued")
+
+// StateStore persists the issuance state of Istio CA, i.e. the issued
+// certificates by serial number, the revocations and the time of the latest
+// issuance, so that the state survives restarts and is shared by the
+// replicas of a highly available deployment. The implementations are in
+// package istio.io/auth/state.
+type StateStore interface {
+	// Load and Store persist the time of the latest issuance of all replicas:
+	// Store keeps the persisted time if it is later than t.
+	IssuanceTimeStore
+
+	// AddIssuance records an issued certificate, or returns
+	// ErrDuplicateSerial if its serial number has already been recorded.
+	AddIssuance(r IssuanceRecord) error
+
+	// Issuances returns the records of the certificates unexpired at now.
+	Issuances(now time.Time) ([]IssuanceRecord, error)
+
+	// AddRevocation records the revocation of the certificate with the serial
+	// number at t. Revoking a revoked certificate keeps the first revocation.
+	AddRevocation(serial *big.Int, t time.Time) error
+
+	// Revocations returns the revocation times by the string form of the serial numbers.
+	Revocations() (map[string]time.Time, error)
+}
@@ -0,0 +1,136 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"sync"
+)
+
+// TBSSigner is a crypto.Signer that signs whole to-be-signed certificates
+// rather than their digests, e.g. an external signing service that inspects
+// what it signs. The CA calls SignTBS instead of Sign.
+type TBSSigner interface {
+	crypto.Signer
+
+	// SignTBS returns the signature of the DER-encoded TBSCertificate with the algorithm.
+	SignTBS(tbs []byte, algo x509.SignatureAlgorithm) ([]byte, error)
+}
+
+// The ASN.1 structure of a certificate, to replace its signature.
+type certificate struct {
+	TBSCertificate     asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	SignatureValue     asn1.BitString
+}
+
+// Local keys standing in for the keys of TBSSigners while the TBSCertificates
+// are built, keyed by the type of the key.
+var (
+	placeholderKeysMutex sync.Mutex
+	placeholderKeys      = map[string]crypto.Signer{}
+)
+
+// createCertificate is like x509.CreateCertificate, but has the TBSCertificate
+// signed as a whole if priv is a TBSSigner.
+func createCertificate(template, parent *x509.Certificate, pub crypto.PublicKey,
+	priv crypto.PrivateKey) (der []byte, err error) {
+
+	defer recoverCryptoPanic("sign the certificate", &err)
+	return signCertificate(template, parent, pub, priv)
+}
+
+func signCertificate(template, parent *x509.Certificate, pub crypto.PublicKey,
+	priv crypto.PrivateKey) ([]byte, error) {
+
+	signer, ok := priv.(TBSSigner)
+	if !ok {
+		return x509.CreateCertificate(RandomSource(), template, parent, pub, priv)
+	}
+
+	// x509 only builds a TBSCertificate to sign it, so build it signed by a
+	// local key of the same type, which yields the same signature algorithm,
+	// and replace the signature.
+	placeholder, err := placeholderKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	placeholderParent := *parent
+	placeholderParent.PublicKey = placeholder.Public()
+	der, err := x509.CreateCertificate(RandomSource(), template, &placeholderParent, pub, placeholder)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	var c certificate
+	if _, err := asn1.Unmarshal(der, &c); err != nil {
+		return nil, err
+	}
+
+	sig, err := signer.SignTBS(cert.RawTBSCertificate, cert.SignatureAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("the signer failed to sign the certificate (error: %v)", err)
+	}
+	c.SignatureValue = asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)}
+	if der, err = asn1.Marshal(c); err != nil {
+		return nil, err
+	}
+
+	signed, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := parent.CheckSignature(signed.SignatureAlgorithm, signed.RawTBSCertificate, signed.Signature); err != nil {
+		return nil, fmt.Errorf("the signer returned an invalid signature (error: %v)", err)
+	}
+	return der, nil
+}
+
+// placeholderKey returns a local key of the same type as the public key.
+func placeholderKey(pub crypto.PublicKey) (crypto.Signer, error) {
+	var keyType string
+	var generate func() (crypto.Signer, error)
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		keyType = "RSA"
+		generate = func() (crypto.Signer, error) { return generateRSAKey(caKeySize) }
+	case *ecdsa.PublicKey:
+		keyType = "ECDSA " + k.Curve.Params().Name
+		generate = func() (crypto.Signer, error) { return ecdsa.GenerateKey(k.Curve, RandomSource()) }
+	default:
+		return nil, fmt.Errorf("unsupported signer key type %T", pub)
+	}
+
+	placeholderKeysMutex.Lock()
+	defer placeholderKeysMutex.Unlock()
+	if key, ok := placeholderKeys[keyType]; ok {
+		return key, nil
+	}
+	key, err := generate()
+	if err != nil {
+		return nil, err
+	}
+	placeholderKeys[keyType] = key
+	return key, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certmanager
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTBSSigner signs the SHA-256 digest of the TBSCertificate with a local key.
+type fakeTBSSigner struct {
+	key     crypto.Signer
+	corrupt bool
+}
+
+func (s fakeTBSSigner) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s fakeTBSSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return nil, errors.New("only whole certificates are signed")
+}
+
+func (s fakeTBSSigner) SignTBS(tbs []byte, algo x509.SignatureAlgorithm) ([]byte, error) {
+	if algo != x509.SHA256WithRSA && algo != x509.ECDSAWithSHA256 {
+		return nil, errors.New("unexpected signature algorithm " + algo.String())
+	}
+	digest := sha256.Sum256(tbs)
+	sig, err := s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if s.corrupt {
+		sig[len(sig)-1] ^= 0xff
+	}
+	return sig, err
+}
+
+func TestTBSSigner(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		signer      fakeTBSSigner
+		expectedErr string
+	}{
+		"ECDSA signer": {
+			signer: fakeTBSSigner{key: ecKey},
+		},
+		"RSA signer": {
+			signer: fakeTBSSigner{key: rsaKey},
+		},
+		"Invalid signature": {
+			signer:      fakeTBSSigner{key: ecKey, corrupt: true},
+			expectedErr: "the signer returned an invalid signature",
+		},
+	}
+	for id, tc := range testCases {
+		now := time.Now()
+		template := genCertTemplate(CertOptions{
+			NotBefore:    now,
+			NotAfter:     now.Add(time.Hour),
+			Org:          "test.ca.org",
+			IsCA:         true,
+			IsSelfSigned: true,
+		})
+		caDER, err := x509.CreateCertificate(rand.Reader, &template, &template, tc.signer.Public(), tc.signer.key)
+		if err != nil {
+			t.Fatalf("%s: failed to create the CA certificate: %v", id, err)
+		}
+		parent, err := x509.ParseCertificate(caDER)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		leaf := genCertTemplate(CertOptions{
+			Host:      "spiffe://cluster.local/ns/foo/sa/bar",
+			NotBefore: now,
+			NotAfter:  now.Add(time.Minute),
+			IsClient:  true,
+		})
+		leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := createCertificate(&leaf, parent, leafKey.Public(), tc.signer)
+		if tc.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: expected error %q, got %v", id, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: failed to create the certificate: %v", id, err)
+			continue
+		}
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Errorf("%s: failed to parse the certificate: %v", id, err)
+			continue
+		}
+		if err := c.CheckSignatureFrom(parent); err != nil {
+			t.Errorf("%s: the certificate is not signed by the signer: %v", id, err)
+		}
+	}
+}
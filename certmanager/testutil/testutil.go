@@ -0,0 +1,53 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package testutil provides the CA and the authenticator that the tests of the
+// servers built on the CA share.
+package testutil
+
+import (
+	"crypto/x509/pkix"
+	"errors"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager"
+)
+
+// NewCA returns a self-signed CA without key escrow, issuing certificates
+// valid for the certTTL.
+func NewCA(t *testing.T, certTTL time.Duration) *certmanager.IstioCA {
+	ca, err := certmanager.NewSelfSignedIstioCA(&certmanager.SelfSignedIstioCAOptions{
+		CACertTTL:   time.Hour,
+		CertTTL:     certTTL,
+		Subject:     pkix.Name{Organization: []string{"test.ca.org"}},
+		MaxPathLen:  -1,
+		NoKeyEscrow: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+	return ca
+}
+
+// FakeAuthenticator maps the credentials to the identities.
+type FakeAuthenticator map[string]string
+
+// Authenticate implements authn.Authenticator.
+func (a FakeAuthenticator) Authenticate(credential string) (string, error) {
+	if id, ok := a[credential]; ok {
+		return id, nil
+	}
+	return "", errors.New("unknown credential")
+}
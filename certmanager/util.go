@@ -18,6 +18,8 @@ import (
 	"crypto"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
+	"fmt"
 
 	"github.com/golang/glog"
 )
@@ -25,41 +27,59 @@ import (
 // ParsePemEncodedCertificate constructs a `x509.Certificate` object using the
 // given a PEM-encoded certificate,
 func ParsePemEncodedCertificate(certBytes []byte) *x509.Certificate {
+	cert, err := parseCertificate(certBytes)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	return cert
+}
+
+// parseCertificate is like ParsePemEncodedCertificate, but returns an error on malformed input.
+func parseCertificate(certBytes []byte) (*x509.Certificate, error) {
 	cb, _ := pem.Decode(certBytes)
 	if cb == nil {
-		glog.Fatalf("Invalid PEM encoding for the certificate: %s", certBytes)
+		// Neither is the input, which may be a key given in place of a certificate.
+		return nil, fmt.Errorf("invalid PEM encoding for the certificate (%d bytes)", len(certBytes))
 	}
 	cert, err := x509.ParseCertificate(cb.Bytes)
 	if err != nil {
-		glog.Fatalf("Failed to parse X.509 certificate (error: %s)", err)
+		return nil, fmt.Errorf("failed to parse X.509 certificate (error: %s)", err)
 	}
-	return cert
+	return cert, nil
 }
 
 // Given a PEM-encoded key, parse the bytes into a `crypto.PrivateKey`
 // according to the provided `x509.PublicKeyAlgorithm`.
 func parsePemEncodedKey(algo x509.PublicKeyAlgorithm, keyBytes []byte) crypto.PrivateKey {
+	key, err := parseKey(algo, keyBytes)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	return key
+}
+
+// parseKey is like parsePemEncodedKey, but returns an error on malformed input.
+func parseKey(algo x509.PublicKeyAlgorithm, keyBytes []byte) (crypto.PrivateKey, error) {
 	kb, _ := pem.Decode(keyBytes)
 	if kb == nil {
-		glog.Fatalf("Invalid PEM encoding for the key: %s", keyBytes)
+		// The key is not included in the error, so that it is never logged.
+		return nil, errors.New("invalid PEM encoding for the key")
 	}
 
 	switch algo {
 	case x509.RSA:
 		key, err := x509.ParsePKCS1PrivateKey(kb.Bytes)
 		if err != nil {
-			glog.Fatalf("Failed to parse the RSA private key (error: %s)", err)
+			return nil, fmt.Errorf("failed to parse the RSA private key (error: %s)", err)
 		}
-		return key
+		return key, nil
 	case x509.ECDSA:
 		key, err := x509.ParseECPrivateKey(kb.Bytes)
 		if err != nil {
-			glog.Fatalf("Failed to parse the ECDSA private key (error: %s)", err)
+			return nil, fmt.Errorf("failed to parse the ECDSA private key (error: %s)", err)
 		}
-		return key
+		return key, nil
 	default:
-		glog.Fatalf("Unknown public key algorithm: %d", algo)
+		return nil, fmt.Errorf("unknown public key algorithm: %d", algo)
 	}
-
-	return nil
 }
@@ -0,0 +1,114 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/spf13/pflag"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+const (
+	// The prefix of the environment variables bound to the flags.
+	envPrefix = "ISTIO_CA_"
+
+	configFileFlag = "config-file"
+)
+
+// envName returns the environment variable bound to the flag, e.g.
+// ISTIO_CA_CERT_TTL for '--cert-ttl'.
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flag, "-", "_", -1))
+}
+
+// applyConfig sets the flags that are not set on the command line from their
+// environment variables, and then the remaining ones from the config file
+// named by '--config-file'. The config file holds a YAML or JSON object from
+// flag names to values, where lists stand for the values of slice flags.
+func applyConfig(flags *pflag.FlagSet, lookupEnv func(string) (string, bool)) error {
+	errs := []error{}
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Changed {
+			return
+		}
+		if value, ok := lookupEnv(envName(f.Name)); ok {
+			if err := flags.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s %q (error: %v)", envName(f.Name), value, err))
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return utilerrors.NewAggregate(errs)
+	}
+
+	f := flags.Lookup(configFileFlag)
+	if f == nil || f.Value.String() == "" {
+		return nil
+	}
+	bs, err := ioutil.ReadFile(f.Value.String())
+	if err != nil {
+		return fmt.Errorf("failed to read the config file (error: %v)", err)
+	}
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(bs, &values); err != nil {
+		return fmt.Errorf("failed to parse the config file %s (error: %v)", f.Value.String(), err)
+	}
+
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cf := flags.Lookup(name)
+		if cf == nil || name == configFileFlag {
+			errs = append(errs, fmt.Errorf("unknown flag %q in the config file", name))
+			continue
+		}
+		if cf.Changed {
+			continue
+		}
+		value := configValue(values[name])
+		if err := flags.Set(name, value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %q %q in the config file (error: %v)", name, value, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// configValue returns the flag value of a value in the config file.
+func configValue(v interface{}) string {
+	switch v := v.(type) {
+	case []interface{}:
+		elems := []string{}
+		for _, e := range v {
+			elems = append(elems, configValue(e))
+		}
+		return strings.Join(elems, ",")
+	case float64:
+		// JSON numbers are floats, which are formatted without exponents for the integer flags.
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
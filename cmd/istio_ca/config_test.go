@@ -0,0 +1,129 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+func TestEnvName(t *testing.T) {
+	if name := envName("max-clock-skew"); name != "ISTIO_CA_MAX_CLOCK_SKEW" {
+		t.Errorf("unexpected environment variable %s", name)
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	configFile := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(configFile, []byte(`
+cert-ttl: 2h
+monitoring-port: 1000000
+self-signed-ca-org: [foo.com, bar.com]
+trust-domain: file.local
+namespace: file-ns
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badConfigFile := filepath.Join(dir, "bad.yaml")
+	if err := ioutil.WriteFile(badConfigFile, []byte("unknown-flag: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	type values struct {
+		certTTL        time.Duration
+		monitoringPort int
+		orgs           []string
+		trustDomain    string
+		namespace      string
+	}
+	testCases := map[string]struct {
+		args           []string
+		env            map[string]string
+		expectedValues values
+		expectedErr    string
+	}{
+		"Defaults": {
+			expectedValues: values{time.Hour, 9093, []string{"default.org"}, "cluster.local", ""},
+		},
+		"Environment variables": {
+			env:            map[string]string{"ISTIO_CA_CERT_TTL": "3h", "ISTIO_CA_SELF_SIGNED_CA_ORG": "a.com,b.com"},
+			expectedValues: values{3 * time.Hour, 9093, []string{"a.com", "b.com"}, "cluster.local", ""},
+		},
+		"Config file named by an environment variable": {
+			env:            map[string]string{"ISTIO_CA_CONFIG_FILE": configFile},
+			expectedValues: values{2 * time.Hour, 1000000, []string{"foo.com", "bar.com"}, "file.local", "file-ns"},
+		},
+		"Command line overrides environment variables which override the config file": {
+			args:           []string{"--config-file=" + configFile, "--trust-domain=flag.local"},
+			env:            map[string]string{"ISTIO_CA_TRUST_DOMAIN": "env.local", "ISTIO_CA_NAMESPACE": "env-ns"},
+			expectedValues: values{2 * time.Hour, 1000000, []string{"foo.com", "bar.com"}, "flag.local", "env-ns"},
+		},
+		"Invalid environment variable": {
+			env:         map[string]string{"ISTIO_CA_CERT_TTL": "forever"},
+			expectedErr: `invalid ISTIO_CA_CERT_TTL "forever"`,
+		},
+		"Unknown flag in the config file": {
+			args:        []string{"--config-file=" + badConfigFile},
+			expectedErr: `unknown flag "unknown-flag" in the config file`,
+		},
+	}
+
+	for k, tc := range testCases {
+		var o cliOptions
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		flags.StringVar(&o.configFile, configFileFlag, "", "")
+		flags.DurationVar(&o.certTTL, "cert-ttl", time.Hour, "")
+		flags.IntVar(&o.monitoringPort, "monitoring-port", 9093, "")
+		flags.StringSliceVar(&o.selfSignedCAOrgs, "self-signed-ca-org", []string{"default.org"}, "")
+		flags.StringVar(&o.trustDomain, "trust-domain", "cluster.local", "")
+		flags.StringVar(&o.namespace, "namespace", "", "")
+		if err := flags.Parse(tc.args); err != nil {
+			t.Fatalf("%s: %v", k, err)
+		}
+
+		err := applyConfig(flags, func(name string) (string, bool) {
+			v, ok := tc.env[name]
+			return v, ok
+		})
+		if tc.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: expect error %q but got %v", k, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+
+		actual := values{o.certTTL, o.monitoringPort, o.selfSignedCAOrgs, o.trustDomain, o.namespace}
+		if !reflect.DeepEqual(actual, tc.expectedValues) {
+			t.Errorf("%s: expect %+v but got %+v", k, tc.expectedValues, actual)
+		}
+	}
+}
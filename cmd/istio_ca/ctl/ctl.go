@@ -0,0 +1,467 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/auth/admin"
+	"istio.io/auth/csr"
+)
+
+const defaultAdminAddress = "localhost:8061"
+
+var (
+	adminAddress      string
+	adminTokenFile    string
+	adminRootCertFile string
+
+	inventoryPageSize int
+
+	reissueExpiringWithin time.Duration
+	reissueNamespace      string
+
+	approver string
+
+	policyConfig string
+
+	// this is used for testing command output
+	printFunc = fmt.Printf
+
+	// Command manages a running Istio CA through its admin API.
+	Command = &cobra.Command{
+		Use:   "ctl",
+		Short: "Manage a running Istio CA through its admin API",
+	}
+
+	listCommand = &cobra.Command{
+		Use:   "list",
+		Short: "List the unexpired certificates issued by the CA",
+		RunE: func(*cobra.Command, []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			certs, err := c.ListCertificates()
+			if err != nil {
+				return err
+			}
+			printCertificates(certs)
+			return nil
+		},
+	}
+
+	revokeCommand = &cobra.Command{
+		Use:   "revoke <serial>...",
+		Short: "Revoke the certificates with the given hex-encoded serial numbers",
+		RunE: func(_ *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("at least one serial number must be specified")
+			}
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			for _, serial := range args {
+				if err := c.Revoke(serial); err != nil {
+					return err
+				}
+				// nolint: errcheck,gas
+				printFunc("Revoked certificate %s\n", serial)
+			}
+			return nil
+		},
+	}
+
+	rotateRootCommand = &cobra.Command{
+		Use:   "rotate-root",
+		Short: "Replace the self-signed root certificate of the CA",
+		RunE: func(*cobra.Command, []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			if err := c.RotateRoot(); err != nil {
+				return err
+			}
+			// nolint: errcheck,gas
+			printFunc("Rotated the root certificate\n")
+			return nil
+		},
+	}
+
+	statusCommand = &cobra.Command{
+		Use:   "status",
+		Short: "Display the status of the CA",
+		RunE: func(*cobra.Command, []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			st, err := c.Status()
+			if err != nil {
+				return err
+			}
+			// nolint: errcheck,gas
+			printFunc(`Root certificate expires: %v
+Issued certificates: %d
+Revoked certificates: %d
+`, st.RootNotAfter.Format(time.RFC3339), st.IssuedCertificates, st.RevokedCertificates)
+			return nil
+		},
+	}
+
+	inventoryCommand = &cobra.Command{
+		Use:   "inventory",
+		Short: "List the workload identities managed by the CA",
+		RunE: func(*cobra.Command, []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			identities := []admin.Identity{}
+			for cont := ""; ; {
+				page, err := c.Inventory(inventoryPageSize, cont)
+				if err != nil {
+					return err
+				}
+				identities = append(identities, page.Identities...)
+				if cont = page.Continue; cont == "" {
+					break
+				}
+			}
+			printIdentities(identities)
+			return nil
+		},
+	}
+
+	rootRolloutCommand = &cobra.Command{
+		Use:   "root-rollout",
+		Short: "Rotate the self-signed root of the CA in stages, namespace by namespace",
+	}
+
+	reissueCommand = &cobra.Command{
+		Use:   "reissue",
+		Short: "Immediately rotate the workload certificates expiring soon",
+		RunE: func(*cobra.Command, []string) error {
+			if reissueExpiringWithin <= 0 {
+				return errors.New("a positive duration must be specified via '--expiring-within'")
+			}
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			identities, err := c.Reissue(reissueExpiringWithin, reissueNamespace)
+			if err != nil {
+				return err
+			}
+			for _, id := range identities {
+				// nolint: errcheck,gas
+				printFunc("Reissuing secret %s/%s (expires %s)\n", id.Namespace, id.SecretName,
+					id.NotAfter.Format(time.RFC3339))
+			}
+			// nolint: errcheck,gas
+			printFunc("%d certificates are being reissued\n", len(identities))
+			return nil
+		},
+	}
+
+	flushCachesCommand = &cobra.Command{
+		Use:   "flush-caches [<cache>...]",
+		Short: "Flush the caches of the CA, e.g. 'jwks', 'secrets' or 'registry', or all of them if none is given",
+		RunE: func(_ *cobra.Command, args []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			flushed, err := c.FlushCaches(args...)
+			if err != nil {
+				return err
+			}
+			for _, name := range flushed {
+				// nolint: errcheck,gas
+				printFunc("Flushed the %s cache\n", name)
+			}
+			return nil
+		},
+	}
+
+	approvalsCommand = &cobra.Command{
+		Use:   "approvals",
+		Short: "Manage the issuances for sensitive identities awaiting manual approval",
+	}
+
+	approvalsListCommand = &cobra.Command{
+		Use:   "list",
+		Short: "List the approval requests",
+		RunE: func(*cobra.Command, []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			approvals, err := c.Approvals()
+			if err != nil {
+				return err
+			}
+			printApprovalRequests(approvals.Requests)
+			return nil
+		},
+	}
+
+	approvalsAuditCommand = &cobra.Command{
+		Use:   "audit",
+		Short: "Display the audit trail of the approval requests",
+		RunE: func(*cobra.Command, []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			approvals, err := c.Approvals()
+			if err != nil {
+				return err
+			}
+			printApprovalEvents(approvals.Audit)
+			return nil
+		},
+	}
+
+	policyCommand = &cobra.Command{
+		Use:   "policy",
+		Short: "Evaluate the policy changes of the CA before rolling them out",
+	}
+
+	policySimulateCommand = &cobra.Command{
+		Use:   "simulate",
+		Short: "List the recent delegated issuances that a proposed delegation policy would deny",
+		Long: "Replays the issuances that delegates requested on behalf of other identities, for the unexpired " +
+			"certificates in the issuance log of the CA, against the delegation policy in '--config', in the " +
+			"format of '--csr-delegation-policy', and lists those that it would deny. The issuances that the " +
+			"identities requested themselves are not subject to the delegation policy.",
+		RunE: func(*cobra.Command, []string) error {
+			if policyConfig == "" {
+				return errors.New("no policy has been specified; specify a policy file via '--config'")
+			}
+			policy, err := csr.LoadDelegationPolicy(policyConfig)
+			if err != nil {
+				return err
+			}
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			certs, err := c.ListCertificates()
+			if err != nil {
+				return err
+			}
+			printDeniedIssuances(policy, certs)
+			return nil
+		},
+	}
+)
+
+func init() {
+	flags := Command.PersistentFlags()
+	flags.StringVar(&adminAddress, "admin-address", defaultAdminAddress,
+		"The address of the admin API of the Istio CA")
+	flags.StringVar(&adminTokenFile, "admin-token-file", "",
+		"Specifies path to the file holding the admin token of the Istio CA")
+	flags.StringVar(&adminRootCertFile, "admin-root-cert-file", "",
+		"Specifies path to the file holding the root certificate of the Istio CA, which the TLS certificate of "+
+			"the admin API is verified against. The system roots are used if unspecified.")
+
+	inventoryCommand.Flags().IntVar(&inventoryPageSize, "page-size", 0,
+		"The number of identities to fetch per request; the server default if zero")
+
+	reissueCommand.Flags().DurationVar(&reissueExpiringWithin, "expiring-within", 0,
+		"Rotate the certificates expiring within this duration, e.g. 24h")
+	reissueCommand.Flags().StringVar(&reissueNamespace, "namespace", "",
+		"Only rotate the certificates of the secrets in this namespace")
+
+	approvalsCommand.PersistentFlags().StringVar(&approver, "approver", "",
+		"Who approves or denies the requests, as recorded in the audit trail. It is required unless "+
+			"'--admin-token-file' holds an approver token, which names the approver.")
+
+	for _, action := range []struct {
+		use, short string
+		call       func(*admin.Client) (*admin.RootRolloutStatus, error)
+	}{
+		{"start", "Start a staged root rotation", (*admin.Client).StartRootRollout},
+		{"status", "Display the progress of the staged root rotation", (*admin.Client).RootRolloutStatus},
+		{"resume", "Resume the paused staged root rotation with the next namespace", (*admin.Client).ResumeRootRollout},
+		{"abort", "Abort the staged root rotation and discard the new root", (*admin.Client).AbortRootRollout},
+	} {
+		call := action.call
+		rootRolloutCommand.AddCommand(&cobra.Command{
+			Use:   action.use,
+			Short: action.short,
+			RunE: func(*cobra.Command, []string) error {
+				c, err := newClient()
+				if err != nil {
+					return err
+				}
+				st, err := call(c)
+				if err != nil {
+					return err
+				}
+				printRootRolloutStatus(st)
+				return nil
+			},
+		})
+	}
+
+	for _, action := range []struct {
+		use, short, done string
+		call             func(c *admin.Client, id, approver string) (*admin.Approvals, error)
+	}{
+		{"approve <request>...", "Approve the pending requests with the given IDs", "Approved", (*admin.Client).Approve},
+		{"deny <request>...", "Deny the pending requests with the given IDs", "Denied", (*admin.Client).Deny},
+	} {
+		done, call := action.done, action.call
+		approvalsCommand.AddCommand(&cobra.Command{
+			Use:   action.use,
+			Short: action.short,
+			RunE: func(_ *cobra.Command, args []string) error {
+				if len(args) == 0 {
+					return errors.New("at least one request ID must be specified")
+				}
+				c, err := newClient()
+				if err != nil {
+					return err
+				}
+				for _, id := range args {
+					if _, err := call(c, id, approver); err != nil {
+						return err
+					}
+					// nolint: errcheck,gas
+					printFunc("%s request %s\n", done, id)
+				}
+				return nil
+			},
+		})
+	}
+	approvalsCommand.AddCommand(approvalsListCommand, approvalsAuditCommand)
+
+	policySimulateCommand.Flags().StringVar(&policyConfig, "config", "",
+		"Specifies path to the JSON file holding the proposed delegation policy")
+	policyCommand.AddCommand(policySimulateCommand)
+
+	Command.AddCommand(listCommand, revokeCommand, rotateRootCommand, statusCommand, inventoryCommand,
+		reissueCommand, rootRolloutCommand, approvalsCommand, flushCachesCommand, policyCommand)
+}
+
+func newClient() (*admin.Client, error) {
+	if adminTokenFile == "" {
+		return nil, errors.New("no admin token has been specified; specify a token file via '--admin-token-file'")
+	}
+	token, err := ioutil.ReadFile(adminTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the admin token (error: %v)", err)
+	}
+	var roots *x509.CertPool
+	if adminRootCertFile != "" {
+		rootCert, err := ioutil.ReadFile(adminRootCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the root certificate (error: %v)", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(rootCert) {
+			return nil, fmt.Errorf("no PEM-encoded certificate found in %s", adminRootCertFile)
+		}
+	}
+	return admin.NewClient(adminAddress, strings.TrimSpace(string(token)), roots), nil
+}
+
+func printCertificates(certs []admin.Certificate) {
+	// nolint: errcheck,gas
+	printFunc("%-40s %-25s %-8s %s\n", "SERIAL", "EXPIRES", "REVOKED", "ID")
+	for _, c := range certs {
+		// nolint: errcheck,gas
+		printFunc("%-40s %-25s %-8v %s\n", c.SerialNumber, c.NotAfter.Format(time.RFC3339), c.Revoked, c.ID)
+	}
+}
+
+func printIdentities(identities []admin.Identity) {
+	// nolint: errcheck,gas
+	printFunc("%-25s %-12s %-16s %-64s %s\n", "EXPIRES", "KEY", "SECRET", "ROOT", "ID")
+	for _, id := range identities {
+		// nolint: errcheck,gas
+		printFunc("%-25s %-12s %-16s %-64s %s\n", id.NotAfter.Format(time.RFC3339), id.KeyType,
+			id.Namespace+"/"+id.SecretName, id.RootFingerprint, id.ID)
+	}
+}
+
+func printRootRolloutStatus(st *admin.RootRolloutStatus) {
+	// nolint: errcheck,gas
+	printFunc("Phase: %s\n", st.Phase)
+	if st.Current != "" {
+		// nolint: errcheck,gas
+		printFunc("Current namespace: %s\n", st.Current)
+	}
+	// nolint: errcheck,gas
+	printFunc("Rolled out namespaces: %s\n", strings.Join(st.RolledOut, ", "))
+	if st.Error != "" {
+		// nolint: errcheck,gas
+		printFunc("Error: %s\n", st.Error)
+	}
+}
+
+func printApprovalRequests(requests []admin.ApprovalRequest) {
+	// nolint: errcheck,gas
+	printFunc("%-16s %-8s %-25s %-25s %-16s %s\n", "REQUEST", "STATE", "REQUESTED", "EXPIRES", "DECIDED BY", "ID")
+	for _, r := range requests {
+		// nolint: errcheck,gas
+		printFunc("%-16s %-8s %-25s %-25s %-16s %s\n", r.ID, r.State, r.RequestedAt.Format(time.RFC3339),
+			r.ExpiresAt.Format(time.RFC3339), r.DecidedBy, r.Identity)
+	}
+}
+
+func printApprovalEvents(events []admin.ApprovalEvent) {
+	// nolint: errcheck,gas
+	printFunc("%-25s %-16s %-10s %-16s %-13s %s\n", "TIME", "REQUEST", "DECISION", "BY", "SOURCE", "ID")
+	for _, e := range events {
+		// nolint: errcheck,gas
+		printFunc("%-25s %-16s %-10s %-16s %-13s %s\n", e.At.Format(time.RFC3339), e.ID, e.Decision, e.By,
+			e.Source, e.Identity)
+	}
+}
+
+// printDeniedIssuances lists the delegated issuances of the certificates that
+// the delegation policy denies.
+func printDeniedIssuances(policy csr.DelegationPolicy, certs []admin.Certificate) {
+	// nolint: errcheck,gas
+	printFunc("%-40s %-50s %s\n", "SERIAL", "REQUESTED BY", "ID")
+	delegated, denied := 0, 0
+	for _, c := range certs {
+		if c.RequestedBy == "" {
+			continue
+		}
+		delegated++
+		if policy.Allows(c.RequestedBy, c.ID) {
+			continue
+		}
+		denied++
+		// nolint: errcheck,gas
+		printFunc("%-40s %-50s %s\n", c.SerialNumber, c.RequestedBy, c.ID)
+	}
+	// nolint: errcheck,gas
+	printFunc("%d of %d delegated issuances would be denied\n", denied, delegated)
+}
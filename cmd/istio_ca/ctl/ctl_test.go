@@ -0,0 +1,284 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"istio.io/auth/admin"
+	"istio.io/auth/certmanager"
+)
+
+const operatorID = "spiffe://cluster.local/ns/istio-system/sa/operator"
+
+type fakeCA struct{}
+
+func (ca fakeCA) GetRootCertificate() []byte {
+	return nil
+}
+
+func (ca fakeCA) IssuedCertificates() []certmanager.IssuanceRecord {
+	notBefore := time.Date(2017, time.May, 1, 0, 0, 0, 0, time.UTC)
+	return []certmanager.IssuanceRecord{
+		{SerialNumber: big.NewInt(0xab), ID: "spiffe://cluster.local/ns/bar/sa/foo",
+			NotBefore: notBefore, NotAfter: notBefore.Add(time.Hour)},
+		{SerialNumber: big.NewInt(0xcd), ID: "spiffe://cluster.local/ns/bar/sa/baz", RequestedBy: operatorID,
+			NotBefore: notBefore, NotAfter: notBefore.Add(time.Hour)},
+	}
+}
+
+func (ca fakeCA) RotateRoot() error {
+	return nil
+}
+
+func fakeInventory() []admin.Identity {
+	notAfter := time.Date(2017, time.May, 1, 1, 0, 0, 0, time.UTC)
+	return []admin.Identity{
+		{ID: "spiffe://cluster.local/ns/bar/sa/foo", Namespace: "bar", SecretName: "istio.foo",
+			NotAfter: notAfter, KeyType: "RSA 2048", RootFingerprint: "abc"},
+		{ID: "spiffe://cluster.local/ns/bar/sa/baz", Namespace: "bar", SecretName: "istio.baz",
+			NotAfter: notAfter, KeyType: "RSA 2048", RootFingerprint: "abc"},
+	}
+}
+
+type fakeRootRollout struct{}
+
+func (r fakeRootRollout) Start() error  { return nil }
+func (r fakeRootRollout) Resume() error { return nil }
+func (r fakeRootRollout) Abort() error  { return nil }
+
+func (r fakeRootRollout) Status() admin.RootRolloutStatus {
+	return admin.RootRolloutStatus{Phase: "paused", Current: "foo", RolledOut: []string{"bar"},
+		Error: "the health metric increased by 3"}
+}
+
+func TestCtlCommands(t *testing.T) {
+	tokenFile, err := ioutil.TempFile("", "admin-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tokenFile.Name()) // nolint: errcheck
+	if _, err := tokenFile.WriteString("secret\n"); err != nil {
+		t.Fatal(err)
+	}
+	tokenFile.Close() // nolint: errcheck
+
+	adminTokenFile = tokenFile.Name()
+
+	rootCertFile, err := ioutil.TempFile("", "admin-root-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(rootCertFile.Name()) // nolint: errcheck
+	rootCertFile.Close()                 // nolint: errcheck
+	adminRootCertFile = rootCertFile.Name()
+
+	policyFile, err := ioutil.TempFile("", "delegation-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(policyFile.Name()) // nolint: errcheck
+	if _, err := policyFile.WriteString(`{"` + operatorID + `": ["spiffe://cluster.local/ns/foo/*"]}`); err != nil {
+		t.Fatal(err)
+	}
+	policyFile.Close() // nolint: errcheck
+
+	var buffer bytes.Buffer
+	printFunc = func(format string, a ...interface{}) (int, error) {
+		buffer.WriteString(fmt.Sprintf(format, a...))
+		return 0, nil
+	}
+
+	testCases := map[string]struct {
+		args           []string
+		expectedOutput string
+	}{
+		"list": {
+			args: []string{"list"},
+			expectedOutput: fmt.Sprintf("%-40s %-25s %-8s %s\n%-40s %-25s %-8v %s\n%-40s %-25s %-8v %s\n",
+				"SERIAL", "EXPIRES", "REVOKED", "ID",
+				"ab", "2017-05-01T01:00:00Z", false, "spiffe://cluster.local/ns/bar/sa/foo",
+				"cd", "2017-05-01T01:00:00Z", false, "spiffe://cluster.local/ns/bar/sa/baz"),
+		},
+		"revoke": {
+			args:           []string{"revoke", "ab"},
+			expectedOutput: "Revoked certificate ab\n",
+		},
+		"inventory": {
+			args: []string{"inventory", "--page-size", "1"},
+			expectedOutput: fmt.Sprintf("%-25s %-12s %-16s %-64s %s\n%-25s %-12s %-16s %-64s %s\n%-25s %-12s %-16s %-64s %s\n",
+				"EXPIRES", "KEY", "SECRET", "ROOT", "ID",
+				"2017-05-01T01:00:00Z", "RSA 2048", "bar/istio.baz", "abc", "spiffe://cluster.local/ns/bar/sa/baz",
+				"2017-05-01T01:00:00Z", "RSA 2048", "bar/istio.foo", "abc", "spiffe://cluster.local/ns/bar/sa/foo"),
+		},
+		"reissue": {
+			args: []string{"reissue", "--expiring-within", "24h", "--namespace", "bar"},
+			expectedOutput: "Reissuing secret bar/istio.foo (expires 2017-05-01T01:00:00Z)\n" +
+				"1 certificates are being reissued\n",
+		},
+		"root-rollout status": {
+			args: []string{"root-rollout", "status"},
+			expectedOutput: "Phase: paused\nCurrent namespace: foo\nRolled out namespaces: bar\n" +
+				"Error: the health metric increased by 3\n",
+		},
+		"rotate-root": {
+			args:           []string{"rotate-root"},
+			expectedOutput: "Rotated the root certificate\n",
+		},
+		"approvals list": {
+			args: []string{"approvals", "list"},
+			expectedOutput: fmt.Sprintf("%-16s %-8s %-25s %-25s %-16s %s\n",
+				"REQUEST", "STATE", "REQUESTED", "EXPIRES", "DECIDED BY", "ID"),
+		},
+		"approvals audit": {
+			args: []string{"approvals", "audit"},
+			expectedOutput: fmt.Sprintf("%-25s %-16s %-10s %-16s %-13s %s\n",
+				"TIME", "REQUEST", "DECISION", "BY", "SOURCE", "ID"),
+		},
+		"flush-caches": {
+			args:           []string{"flush-caches"},
+			expectedOutput: "Flushed the jwks cache\nFlushed the registry cache\n",
+		},
+		"flush-caches registry": {
+			args:           []string{"flush-caches", "registry"},
+			expectedOutput: "Flushed the registry cache\n",
+		},
+		"policy simulate": {
+			args: []string{"policy", "simulate", "--config", policyFile.Name()},
+			expectedOutput: fmt.Sprintf("%-40s %-50s %s\n%-40s %-50s %s\n", "SERIAL", "REQUESTED BY", "ID",
+				"cd", operatorID, "spiffe://cluster.local/ns/bar/sa/baz") +
+				"1 of 1 delegated issuances would be denied\n",
+		},
+	}
+
+	for id, tc := range testCases {
+		s := admin.NewServer(fakeCA{}, certmanager.NewRevocationList(), nil, []byte("secret"))
+		s.Inventory = fakeInventory
+		s.RootRollout = fakeRootRollout{}
+		s.Approvals, err = certmanager.NewApprovalQueue(nil, time.Hour)
+		if err != nil {
+			t.Fatalf("%s: failed to create the approval queue: %v", id, err)
+		}
+		s.Reissue = func(within time.Duration, namespace string) []admin.Identity {
+			identities := []admin.Identity{}
+			for _, id := range fakeInventory() {
+				if id.Namespace == namespace && id.SecretName == "istio.foo" {
+					identities = append(identities, id)
+				}
+			}
+			return identities
+		}
+		s.AddCache("jwks", func() error { return nil })
+		s.AddCache("registry", func() error { return nil })
+		ts := serveTLS(t, s.Handler())
+		buffer.Reset()
+		cmd, args, err := Command.Find(tc.args)
+		if err != nil {
+			t.Fatalf("%s: failed to find the command: %v", id, err)
+		}
+		if err := cmd.ParseFlags(args); err != nil {
+			t.Fatalf("%s: failed to parse the flags: %v", id, err)
+		}
+		args = cmd.Flags().Args()
+		if err := cmd.RunE(cmd, args); err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+		}
+		if actual := buffer.String(); actual != tc.expectedOutput {
+			t.Errorf("%s: unexpected output: wanted %q but got %q", id, tc.expectedOutput, actual)
+		}
+		ts.Close()
+	}
+
+	approvalID, ts := newApprovalServer(t)
+	defer ts.Close()
+	cmd, args, err := Command.Find([]string{"approvals", "approve", approvalID})
+	if err != nil {
+		t.Fatalf("Failed to find the approve command: %v", err)
+	}
+	if err := cmd.RunE(cmd, args); err == nil {
+		t.Error("Approving without an approver should fail")
+	}
+	if err := cmd.ParseFlags([]string{"--approver", "alice"}); err != nil {
+		t.Fatalf("Failed to parse the flags: %v", err)
+	}
+	buffer.Reset()
+	if err := cmd.RunE(cmd, args); err != nil {
+		t.Errorf("Failed to approve request %s: %v", approvalID, err)
+	} else if expected := "Approved request " + approvalID + "\n"; buffer.String() != expected {
+		t.Errorf("Unexpected output: wanted %q but got %q", expected, buffer.String())
+	}
+	if err := cmd.RunE(cmd, args); err == nil {
+		t.Error("Approving a decided request should fail")
+	}
+
+	adminRootCertFile = ""
+	if err := listCommand.RunE(listCommand, nil); err == nil {
+		t.Error("Running without trusting the TLS certificate of the admin API should fail")
+	}
+
+	adminTokenFile = ""
+	if err := listCommand.RunE(listCommand, nil); err == nil {
+		t.Error("Running without an admin token should fail")
+	}
+}
+
+// serveTLS serves the handler over TLS, and points the commands to the test
+// server and its certificate.
+func serveTLS(t *testing.T, h http.Handler) *httptest.Server {
+	ts := httptest.NewTLSServer(h)
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := ioutil.WriteFile(adminRootCertFile, cert, 0644); err != nil {
+		t.Fatalf("Failed to write the root certificate: %v", err)
+	}
+	adminAddress = ts.URL
+	return ts
+}
+
+// newApprovalServer returns the ID of the approval request pending in the
+// admin API served by the returned test server, which the commands are
+// pointed to.
+func newApprovalServer(t *testing.T) (string, *httptest.Server) {
+	q, err := certmanager.NewApprovalQueue([]string{"spiffe://cluster.local/ns/bar/sa/*"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create the approval queue: %v", err)
+	}
+	ca, err := certmanager.NewSelfSignedIstioCA(&certmanager.SelfSignedIstioCAOptions{
+		CACertTTL:     time.Hour,
+		CertTTL:       time.Minute,
+		MaxPathLen:    -1,
+		ApprovalQueue: q,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the CA: %v", err)
+	}
+	_, _, err = ca.Generate("foo", "bar")
+	pe, ok := err.(*certmanager.ApprovalPendingError)
+	if !ok {
+		t.Fatalf("Expecting the issuance to await approval, but got %v", err)
+	}
+
+	s := admin.NewServer(fakeCA{}, certmanager.NewRevocationList(), nil, []byte("secret"))
+	s.Approvals = q
+	return pe.ID, serveTLS(t, s.Handler())
+}
@@ -0,0 +1,290 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deploy
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ghodss/yaml"
+	"github.com/spf13/cobra"
+
+	"istio.io/auth/controller"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	rbac "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+)
+
+const (
+	// The name of the ServiceAccount, the RBAC roles, the Service and the Deployment of Istio CA.
+	name = "istio-ca"
+
+	// The path the CA secret is mounted at when the CA is not self-signed.
+	caCertsDir = "/etc/cacerts"
+
+	// The namespace of the discovery ConfigMap if Istio CA listens to all
+	// namespaces, as in Istio CA itself.
+	discoveryNamespaceDefault = "istio-system"
+)
+
+var rbacAPIVersion = rbac.SchemeGroupVersion.String()
+
+type options struct {
+	namespace      string
+	watchNamespace string
+	image          string
+
+	selfSigned bool
+	caSecret   string
+
+	noKeyEscrow        bool
+	enableServerCerts  bool
+	discoveryAddress   string
+	discoveryConfigMap string
+
+	monitoringPort int
+}
+
+var (
+	opts options
+
+	// this is used for testing command output
+	printFunc = fmt.Printf
+
+	// Command writes the Kubernetes manifests to deploy Istio CA to stdout.
+	Command = &cobra.Command{
+		Use:   "gen-deploy",
+		Short: "Generate the Kubernetes manifests to deploy Istio CA with the given options",
+		Long: "Generates the ServiceAccount, RBAC rules, Service and Deployment to run Istio CA with the given " +
+			"options. The RBAC rules only grant the API access needed by the enabled controllers.",
+		RunE: func(*cobra.Command, []string) error {
+			objs, err := manifests(opts)
+			if err != nil {
+				return err
+			}
+			for _, obj := range objs {
+				data, err := yaml.Marshal(obj)
+				if err != nil {
+					return err
+				}
+				// nolint: errcheck,gas
+				printFunc("---\n%s", data)
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	flags := Command.Flags()
+	flags.StringVar(&opts.namespace, "namespace", "istio-system", "The namespace to deploy Istio CA in")
+	flags.StringVar(&opts.watchNamespace, "watch-namespace", "",
+		"The namespace for Istio CA to listen to. If unspecified, Istio CA listens to all namespaces and is "+
+			"granted cluster-wide access.")
+	flags.StringVar(&opts.image, "image", "istio-ca", "The container image of Istio CA")
+
+	flags.BoolVar(&opts.selfSigned, "self-signed", false,
+		"Indicates whether Istio CA uses an auto-generated self-signed CA certificate")
+	flags.StringVar(&opts.caSecret, "ca-secret", "cacerts",
+		"The secret holding 'ca-cert.pem', 'ca-key.pem', 'cert-chain.pem' and 'root-cert.pem' of a CA that is "+
+			"not self-signed. It is mounted into the Istio CA pod.")
+
+	flags.BoolVar(&opts.noKeyEscrow, "no-key-escrow", false,
+		"Indicates whether to forbid Istio CA from generating the private keys of workloads")
+	flags.BoolVar(&opts.enableServerCerts, "enable-server-certs", false,
+		"Indicates whether to provision server certificates for annotated Services and Ingresses")
+	flags.StringVar(&opts.discoveryAddress, "discovery-address", "",
+		"The address that workloads reach Istio CA at, published in the '--discovery-configmap' ConfigMap")
+	flags.StringVar(&opts.discoveryConfigMap, "discovery-configmap", "istio-ca",
+		"The name of the ConfigMap to publish the discovery data in")
+
+	flags.IntVar(&opts.monitoringPort, "monitoring-port", 9093, "The port to serve the monitoring endpoints on")
+}
+
+// manifests returns the objects to deploy Istio CA with the options, in the order to create them.
+func manifests(o options) ([]runtime.Object, error) {
+	if o.namespace == "" {
+		return nil, errors.New("no '--namespace' has been specified")
+	}
+	if !o.selfSigned && o.caSecret == "" {
+		return nil, errors.New("'--ca-secret' must be specified unless '--self-signed' is set")
+	}
+	if o.noKeyEscrow && o.enableServerCerts {
+		return nil, errors.New("'--enable-server-certs', '--no-key-escrow' are mutually exclusive")
+	}
+
+	labels := map[string]string{"istio": name}
+	subjects := []rbac.Subject{{Kind: "ServiceAccount", Name: name, Namespace: o.namespace}}
+	objs := []runtime.Object{&v1.ServiceAccount{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: o.namespace, Labels: labels},
+	}}
+
+	rules := []rbac.PolicyRule{}
+	if !o.noKeyEscrow {
+		rules = append(rules, controller.SecretControllerRules()...)
+	}
+	if o.enableServerCerts {
+		rules = append(rules, controller.ServerCertControllerRules()...)
+	}
+	if len(rules) > 0 {
+		objs = append(objs, roleObjects(name, o.watchNamespace, labels, rules, subjects)...)
+	}
+
+	if o.discoveryAddress != "" {
+		ns := o.watchNamespace
+		if ns == "" {
+			ns = discoveryNamespaceDefault
+		}
+		objs = append(objs, roleObjects(name+"-discovery", ns, labels,
+			controller.DiscoveryControllerRules(o.discoveryConfigMap), subjects)...)
+	}
+
+	objs = append(objs, service(o, labels), deployment(o, labels))
+	return objs, nil
+}
+
+// RoleManifest returns the YAML manifest of a Role in the namespace, or of a
+// ClusterRole if the namespace is empty, granting the rules.
+func RoleManifest(roleName, namespace string, rules []rbac.PolicyRule) ([]byte, error) {
+	return yaml.Marshal(role(roleName, namespace, nil, rules))
+}
+
+func role(roleName, namespace string, labels map[string]string, rules []rbac.PolicyRule) runtime.Object {
+	meta := metav1.ObjectMeta{Name: roleName, Namespace: namespace, Labels: labels}
+	if namespace == "" {
+		return &rbac.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacAPIVersion, Kind: "ClusterRole"},
+			ObjectMeta: meta,
+			Rules:      rules,
+		}
+	}
+	return &rbac.Role{
+		TypeMeta:   metav1.TypeMeta{APIVersion: rbacAPIVersion, Kind: "Role"},
+		ObjectMeta: meta,
+		Rules:      rules,
+	}
+}
+
+// roleObjects returns a Role and a RoleBinding in the namespace, or a
+// ClusterRole and a ClusterRoleBinding if the namespace is empty.
+func roleObjects(roleName, namespace string, labels map[string]string, rules []rbac.PolicyRule,
+	subjects []rbac.Subject) []runtime.Object {
+
+	meta := metav1.ObjectMeta{Name: roleName, Namespace: namespace, Labels: labels}
+	if namespace == "" {
+		return []runtime.Object{
+			role(roleName, namespace, labels, rules),
+			&rbac.ClusterRoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacAPIVersion, Kind: "ClusterRoleBinding"},
+				ObjectMeta: meta,
+				Subjects:   subjects,
+				RoleRef:    rbac.RoleRef{APIGroup: rbac.GroupName, Kind: "ClusterRole", Name: roleName},
+			},
+		}
+	}
+	return []runtime.Object{
+		role(roleName, namespace, labels, rules),
+		&rbac.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacAPIVersion, Kind: "RoleBinding"},
+			ObjectMeta: meta,
+			Subjects:   subjects,
+			RoleRef:    rbac.RoleRef{APIGroup: rbac.GroupName, Kind: "Role", Name: roleName},
+		},
+	}
+}
+
+func service(o options, labels map[string]string) *v1.Service {
+	return &v1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: o.namespace, Labels: labels},
+		Spec: v1.ServiceSpec{
+			Selector: labels,
+			Ports: []v1.ServicePort{{
+				Name:       "monitoring",
+				Port:       int32(o.monitoringPort),
+				TargetPort: intstr.FromInt(o.monitoringPort),
+			}},
+		},
+	}
+}
+
+func deployment(o options, labels map[string]string) *v1beta1.Deployment {
+	args := []string{fmt.Sprintf("--monitoring-port=%d", o.monitoringPort)}
+	if o.watchNamespace != "" {
+		args = append(args, "--namespace="+o.watchNamespace)
+	}
+	if o.noKeyEscrow {
+		args = append(args, "--no-key-escrow")
+	}
+	if o.enableServerCerts {
+		args = append(args, "--enable-server-certs")
+	}
+	if o.discoveryAddress != "" {
+		args = append(args, "--discovery-address="+o.discoveryAddress,
+			"--discovery-configmap="+o.discoveryConfigMap)
+	}
+
+	container := v1.Container{
+		Name:  name,
+		Image: o.image,
+		Ports: []v1.ContainerPort{{Name: "monitoring", ContainerPort: int32(o.monitoringPort)}},
+		ReadinessProbe: &v1.Probe{
+			Handler: v1.Handler{
+				HTTPGet: &v1.HTTPGetAction{Path: "/ready", Port: intstr.FromInt(o.monitoringPort)},
+			},
+		},
+	}
+	var volumes []v1.Volume
+	if o.selfSigned {
+		args = append(args, "--self-signed-ca")
+	} else {
+		args = append(args,
+			"--signing-cert="+caCertsDir+"/ca-cert.pem",
+			"--signing-key="+caCertsDir+"/ca-key.pem",
+			"--cert-chain="+caCertsDir+"/cert-chain.pem",
+			"--root-cert="+caCertsDir+"/root-cert.pem")
+		container.VolumeMounts = []v1.VolumeMount{{Name: "cacerts", MountPath: caCertsDir, ReadOnly: true}}
+		volumes = []v1.Volume{{
+			Name:         "cacerts",
+			VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: o.caSecret}},
+		}}
+	}
+	// The image entrypoint may add flags of its own, so the binary is invoked explicitly.
+	container.Command = []string{"/usr/local/bin/istio_ca"}
+	container.Args = args
+
+	// A single replica, since replicas with self-signed CAs would issue certificates under different roots.
+	replicas := int32(1)
+	return &v1beta1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "extensions/v1beta1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: o.namespace, Labels: labels},
+		Spec: v1beta1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: v1.PodSpec{
+					ServiceAccountName: name,
+					Containers:         []v1.Container{container},
+					Volumes:            volumes,
+				},
+			},
+		},
+	}
+}
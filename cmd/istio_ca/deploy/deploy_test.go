@@ -0,0 +1,136 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deploy
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	rbac "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+)
+
+func kinds(objs []runtime.Object) []string {
+	ks := []string{}
+	for _, obj := range objs {
+		ks = append(ks, obj.GetObjectKind().GroupVersionKind().Kind)
+	}
+	return ks
+}
+
+func TestManifests(t *testing.T) {
+	testCases := map[string]struct {
+		opts          options
+		expectedKinds []string
+		expectedArgs  []string
+		expectedErr   string
+	}{
+		"Self-signed CA listening to all namespaces": {
+			opts: options{namespace: "istio-system", selfSigned: true, monitoringPort: 9093},
+			expectedKinds: []string{"ServiceAccount", "ClusterRole", "ClusterRoleBinding", "Service",
+				"Deployment"},
+			expectedArgs: []string{"--monitoring-port=9093", "--self-signed-ca"},
+		},
+		"CA listening to one namespace with discovery": {
+			opts: options{namespace: "istio-system", watchNamespace: "foo", caSecret: "cacerts",
+				discoveryAddress: "istio-ca.istio-system:8060", discoveryConfigMap: "istio-ca", monitoringPort: 9093},
+			expectedKinds: []string{"ServiceAccount", "Role", "RoleBinding", "Role", "RoleBinding", "Service",
+				"Deployment"},
+			expectedArgs: []string{"--monitoring-port=9093", "--namespace=foo",
+				"--discovery-address=istio-ca.istio-system:8060", "--discovery-configmap=istio-ca",
+				"--signing-cert=/etc/cacerts/ca-cert.pem", "--signing-key=/etc/cacerts/ca-key.pem",
+				"--cert-chain=/etc/cacerts/cert-chain.pem", "--root-cert=/etc/cacerts/root-cert.pem"},
+		},
+		"CA without key escrow needs no RBAC rules": {
+			opts:          options{namespace: "istio-system", selfSigned: true, noKeyEscrow: true, monitoringPort: 9093},
+			expectedKinds: []string{"ServiceAccount", "Service", "Deployment"},
+			expectedArgs:  []string{"--monitoring-port=9093", "--no-key-escrow", "--self-signed-ca"},
+		},
+		"CA secret is required without a self-signed CA": {
+			opts:        options{namespace: "istio-system"},
+			expectedErr: "'--ca-secret' must be specified unless '--self-signed' is set",
+		},
+		"Server certificates require key escrow": {
+			opts:        options{namespace: "istio-system", selfSigned: true, noKeyEscrow: true, enableServerCerts: true},
+			expectedErr: "'--enable-server-certs', '--no-key-escrow' are mutually exclusive",
+		},
+	}
+
+	for k, tc := range testCases {
+		objs, err := manifests(tc.opts)
+		if tc.expectedErr != "" {
+			if err == nil || err.Error() != tc.expectedErr {
+				t.Errorf("%s: expect error %q but got %v", k, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+
+		if actual := kinds(objs); !reflect.DeepEqual(actual, tc.expectedKinds) {
+			t.Errorf("%s: expect kinds %v but got %v", k, tc.expectedKinds, actual)
+			continue
+		}
+		d := objs[len(objs)-1].(*v1beta1.Deployment)
+		if actual := d.Spec.Template.Spec.Containers[0].Args; !reflect.DeepEqual(actual, tc.expectedArgs) {
+			t.Errorf("%s: expect args %v but got %v", k, tc.expectedArgs, actual)
+		}
+	}
+}
+
+func TestDiscoveryRoleNamespace(t *testing.T) {
+	objs, err := manifests(options{namespace: "ca-ns", selfSigned: true, noKeyEscrow: true,
+		discoveryAddress: "istio-ca.ca-ns:8060", discoveryConfigMap: "istio-ca"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	role, ok := objs[1].(*rbac.Role)
+	if !ok {
+		t.Fatalf("expect a Role but got %v", objs[1])
+	}
+	// Istio CA listening to all namespaces publishes in the default namespace.
+	if role.Namespace != discoveryNamespaceDefault || role.Name != "istio-ca-discovery" {
+		t.Errorf("unexpected discovery Role %s/%s", role.Namespace, role.Name)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	var buffer bytes.Buffer
+	printFunc = func(format string, a ...interface{}) (int, error) {
+		buffer.WriteString(fmt.Sprintf(format, a...))
+		return 0, nil
+	}
+
+	Command.SetArgs([]string{"--self-signed", "--enable-server-certs"})
+	if err := Command.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buffer.String()
+	if n := strings.Count(out, "---\n"); n != 5 {
+		t.Errorf("expect 5 manifests but got %d:\n%s", n, out)
+	}
+	for _, s := range []string{"kind: Deployment", "namespace: istio-system", "- ingresses", "- --self-signed-ca"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expect %q in the output:\n%s", s, out)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// latencyInjector delays every request by a random duration of up to max
+// before sending it, to soak-test the controllers against a slow API server.
+type latencyInjector struct {
+	rt  http.RoundTripper
+	max time.Duration
+}
+
+func (l *latencyInjector) RoundTrip(req *http.Request) (*http.Response, error) {
+	// #nosec: the injected latency does not need a cryptographically secure source.
+	time.Sleep(time.Duration(rand.Int63n(int64(l.max) + 1)))
+	return l.rt.RoundTrip(req)
+}
+
+// withInjectedLatency returns a copy of the config whose requests are delayed
+// by up to max, or the config itself if max is zero.
+func withInjectedLatency(c *rest.Config, max time.Duration) *rest.Config {
+	if max <= 0 {
+		return c
+	}
+	injected := *c
+	wrap := c.WrapTransport
+	injected.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return &latencyInjector{rt: rt, max: max}
+	}
+	return &injected
+}
@@ -0,0 +1,66 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+func TestWithInjectedLatency(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	testCases := map[string]struct {
+		max          time.Duration
+		expectInject bool
+	}{
+		"No injected latency": {},
+		"Injected latency": {
+			max:          50 * time.Millisecond,
+			expectInject: true,
+		},
+	}
+
+	for k, tc := range testCases {
+		config := &rest.Config{Host: server.URL}
+		injected := withInjectedLatency(config, tc.max)
+		if !tc.expectInject {
+			if injected != config {
+				t.Errorf("%s: expecting the config to be left unchanged", k)
+			}
+			continue
+		}
+		if config.WrapTransport != nil {
+			t.Errorf("%s: expecting the original config to be left unchanged", k)
+		}
+
+		rt := injected.WrapTransport(http.DefaultTransport)
+		if _, ok := rt.(*latencyInjector); !ok {
+			t.Fatalf("%s: unexpected transport %T", k, rt)
+		}
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		resp.Body.Close() // nolint: errcheck
+	}
+}
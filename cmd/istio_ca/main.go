@@ -15,18 +15,67 @@
 package main
 
 import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/json"
+	"expvar"
 	"fmt"
 	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/pprof"
 	"os"
+	"os/signal"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strings"
+	"syscall"
 	"time"
 
+	"istio.io/auth/adcs"
+	"istio.io/auth/admin"
+	"istio.io/auth/authn"
 	"istio.io/auth/certmanager"
+	"istio.io/auth/cmd/istio_ca/ctl"
+	"istio.io/auth/cmd/istio_ca/deploy"
+	"istio.io/auth/cmd/istio_ca/offline"
+	"istio.io/auth/cmd/istio_ca/verify"
 	"istio.io/auth/cmd/istio_ca/version"
 	"istio.io/auth/controller"
+	"istio.io/auth/csr"
+	"istio.io/auth/est"
+	"istio.io/auth/export"
+	"istio.io/auth/federation"
+	"istio.io/auth/kms"
+	"istio.io/auth/lint"
+	"istio.io/auth/parse"
+	"istio.io/auth/piv"
+	"istio.io/auth/registry"
+	"istio.io/auth/replication"
+	"istio.io/auth/scep"
+	"istio.io/auth/selftest"
+	"istio.io/auth/signer"
+	"istio.io/auth/state"
+	"istio.io/auth/sts"
 
+	// The drivers of the '--state-sql-driver' databases.
+	_ "github.com/go-sql-driver/mysql"
 	"github.com/golang/glog"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	authenticationv1beta1 "k8s.io/client-go/kubernetes/typed/authentication/v1beta1"
+	authorizationv1beta1 "k8s.io/client-go/kubernetes/typed/authorization/v1beta1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	rbac "k8s.io/client-go/pkg/apis/rbac/v1beta1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
@@ -37,22 +86,227 @@ const (
 
 	// The key for the environment variable that specifies the namespace.
 	namespaceKey = "NAMESPACE"
+
+	// The namespace of the Istio control plane, e.g. of the discovery
+	// ConfigMap, if Istio CA listens to all namespaces.
+	controlPlaneNamespaceDefault = "istio-system"
+
+	// The values of '--key-rotation-policy'.
+	keyRotationPolicyRekey = "rekey"
+	keyRotationPolicyReuse = "reuse"
+
+	// The values of '--pki-lint'.
+	pkiLintOff = "off"
+	pkiLintCA  = "ca"
+	pkiLintAll = "all"
+
+	// The values of '--secure-memory'.
+	secureMemoryOff       = "off"
+	secureMemoryPreferred = "preferred"
+	secureMemoryRequired  = "required"
+
+	// The values of '--state-store'.
+	stateStoreMemory    = ""
+	stateStoreConfigMap = "configmap"
+	stateStoreSQL       = "sql"
+
+	// The interval between the runs of the warning checks served on '/warnz'.
+	warningsCheckInterval = time.Minute
+
+	// The synthetic identity of the canary certificates.
+	canaryNamespace      = "istio-ca-canary"
+	canaryServiceAccount = "canary"
 )
 
 type cliOptions struct {
+	configFile string
+
 	certChainFile   string
 	signingCertFile string
 	signingKeyFile  string
 	rootCertFile    string
+	signingSecret   string
+
+	signingKeyPIVSlot string
+	pivCard           string
+	pivPINFile        string
+
+	signingWebhook           string
+	signingWebhookCACertFile string
+	signingWebhookTokenFile  string
+
+	identityRegistryWebhook    string
+	identityRegistryCACertFile string
+	identityRegistryTokenFile  string
+	identityRegistryCacheTTL   time.Duration
+
+	approvalRequiredIdentities []string
+	approvalTimeout            time.Duration
+	approverTokensFile         string
+
+	adcsURL          string
+	adcsTemplate     string
+	adcsUsername     string
+	adcsPasswordFile string
+	adcsCACertFile   string
+	adcsCommonName   string
 
 	namespace      string
 	kubeConfigFile string
 
-	selfSignedCA    bool
-	selfSignedCAOrg string
+	trustDomain            string
+	trustDomainsConfigFile string
+	// Parsed from trustDomainsConfigFile by validate.
+	trustDomainConfigs map[string]trustDomainConfig
+
+	signingProfilesConfigFile string
+	// Parsed from signingProfilesConfigFile by validate.
+	signingProfileConfigs map[string]signingProfileConfig
+
+	selfSignedCA                bool
+	selfSignedCAOrgs            []string
+	selfSignedCAOrgUnits        []string
+	selfSignedCACommonName      string
+	selfSignedCACountries       []string
+	selfSignedCAMaxPathLen      int
+	selfSignedCANameConstrained bool
+
+	caCertTTL      time.Duration
+	certTTL        time.Duration
+	minCertTTL     time.Duration
+	namespaceCATTL time.Duration
+
+	namespaceMaxActiveIdentities int
+	namespaceMaxIssuancesPerHour int
+
+	signatureAlgorithm string
+
+	pkiLint       string
+	pkiLintStrict bool
+
+	issuanceTimeFile string
+
+	stateStore        string
+	stateConfigMap    string
+	stateSQLDriver    string
+	stateSQLDSN       string
+	stateSyncInterval time.Duration
+
+	ntpServer              string
+	clockSkewCheckInterval time.Duration
+	maxClockSkew           time.Duration
+
+	harden              bool
+	secureMemory        string
+	randomSourceFile    string
+	entropyProbeTimeout time.Duration
+
+	maxConcurrentKeyGenerations int
+	keyPoolSize                 int
+
+	deleteRevokedSecrets bool
+
+	noKeyEscrow bool
+
+	adoptUnlabeledSecrets   bool
+	orphanSecretsOnShutdown bool
+
+	pemComments bool
+
+	secretTLSKeys       bool
+	secretMinimalChains bool
+	maxCertExpirySeries int
+	secretDataKeys      []string
+	// Parsed from secretDataKeys by validate.
+	secretDataKeyAliases map[string]string
+	migrateOnly          bool
+
+	enableServerCerts bool
+
+	secretEncryptionKMSKey string
+
+	keyRotationPolicy string
+	maxKeyAge         time.Duration
+
+	discoveryAddress   string
+	discoveryConfigMap string
+
+	bootstrapControlPlane bool
+
+	skipPermissionCheck bool
+
+	adminAddress   string
+	adminHosts     []string
+	adminTokenFile string
+
+	csrAddress              string
+	csrHosts                []string
+	csrGCPAudience          string
+	csrGCPIdentityMapping   string
+	csrAzureTenant          string
+	csrAzureAudience        string
+	csrAzureIdentityMapping string
+	csrKubernetesTokens     bool
+	csrKubernetesAudience   string
+	csrPodIdentity          bool
+	clusterDomain           string
+	csrOIDCIssuers          string
+	csrDelegationPolicy     string
+	csrRequireNonce         bool
+	csrMethodPolicy         string
+	csrRateLimit            int
+	csrMaxStreams           int
+	csrMaxPeerConnections   int
+
+	estAddress string
+
+	tokenExchangeAddress         string
+	tokenExchangeOIDCIssuer      string
+	tokenExchangeOIDCAudience    string
+	tokenExchangeOIDCClaim       string
+	tokenExchangeIdentityMapping string
+	tokenExchangeCertTTL         time.Duration
+
+	scepAddress        string
+	scepChallengesFile string
+
+	federationAddress      string
+	federationPeersFile    string
+	federationID           string
+	federationSyncInterval time.Duration
+
+	rootRolloutInterval          time.Duration
+	rootRolloutCanaries          []string
+	rootRolloutHealthMetric      string
+	rootRolloutMaxMetricIncrease float64
+
+	exportDir       string
+	exportURL       string
+	exportTokenFile string
+	exportInterval  time.Duration
+	exportOpenSSLDB bool
+
+	replicateConsulAddress string
+	replicateEtcdAddress   string
+	replicateTokenFile     string
+	replicatePrefix        string
+	replicateInterval      time.Duration
 
-	caCertTTL time.Duration
-	certTTL   time.Duration
+	monitoringPort  int
+	enableProfiling bool
+
+	maxExpiringCertFraction float64
+	expiringCertWindow      time.Duration
+	minRootCertLifetime     time.Duration
+	warningsFailReadiness   bool
+
+	canaryInterval time.Duration
+
+	mtlsReadinessInterval     time.Duration
+	mtlsReadinessMinRemaining time.Duration
+
+	injectSignErrors  float64
+	injectKubeLatency time.Duration
 }
 
 var (
@@ -60,7 +314,7 @@ var (
 
 	rootCmd = &cobra.Command{
 		Run: func(cmd *cobra.Command, args []string) {
-			runCA()
+			runCA(cmd.Flags())
 		},
 	}
 )
@@ -68,10 +322,77 @@ var (
 func init() {
 	flags := rootCmd.Flags()
 
+	flags.StringVar(&opts.configFile, configFileFlag, "",
+		"Specifies path to a YAML or JSON file holding an object from flag names to values. Every flag can also "+
+			"be set by an environment variable, e.g. "+envName("cert-ttl")+" for '--cert-ttl'. The flags on the "+
+			"command line take precedence over the environment variables, which take precedence over the file.")
+
 	flags.StringVar(&opts.certChainFile, "cert-chain", "", "Speicifies path to the certificate chain file")
 	flags.StringVar(&opts.signingCertFile, "signing-cert", "", "Specifies path to the CA signing certificate file")
 	flags.StringVar(&opts.signingKeyFile, "signing-key", "", "Specifies path to the CA signing key file")
 	flags.StringVar(&opts.rootCertFile, "root-cert", "", "Specifies path to the root certificate file")
+	flags.StringVar(&opts.signingSecret, "signing-secret", "",
+		"The Kubernetes secret, as '<namespace>/<name>', holding the signing material in its '"+
+			controller.SigningSecretCertChainID+"', '"+controller.SigningSecretSigningCertID+"', '"+
+			controller.SigningSecretSigningKeyID+"' and '"+controller.SigningSecretRootCertID+"' keys, instead of "+
+			"the files. The secret is watched, so that the signing material is rotated by updating it.")
+	flags.StringVar(&opts.signingKeyPIVSlot, "signing-key-piv-slot", "",
+		"The slot, one of "+strings.Join(piv.Slots, ", ")+", of the PIV token (e.g. a YubiKey) holding the CA "+
+			"signing key, instead of '--signing-key'. The key never leaves the token, which signs every certificate.")
+	flags.StringVar(&opts.pivCard, "piv-card", "",
+		"A substring of the name of the smart card reader of the PIV token. If unspecified, the first YubiKey is used.")
+	flags.StringVar(&opts.pivPINFile, "piv-pin-file", "", "Specifies path to the file holding the PIN of the PIV token")
+	flags.StringVar(&opts.signingWebhook, "signing-webhook", "",
+		"The https:// URL of an external signing service holding the CA signing key, instead of '--signing-key'. "+
+			"Istio CA POSTs '{\"tbsCertificate\": ..., \"signatureAlgorithm\": ...}' with the base64-encoded "+
+			"DER of every certificate to sign, and expects '{\"signature\": ...}' back.")
+	flags.StringVar(&opts.signingWebhookCACertFile, "signing-webhook-ca-cert", "",
+		"Specifies path to the CA certificate verifying the '--signing-webhook' server. If unspecified, the "+
+			"system roots are used.")
+	flags.StringVar(&opts.signingWebhookTokenFile, "signing-webhook-token-file", "",
+		"Specifies path to the file holding the bearer token sent to the '--signing-webhook'")
+	flags.StringVar(&opts.identityRegistryWebhook, "identity-registry-webhook", "",
+		"The https:// URL of an external identity registry, e.g. a CMDB, approving every workload before the CA "+
+			"issues its certificate. Istio CA POSTs '{\"trustDomain\": ..., \"namespace\": ..., "+
+			"\"serviceAccount\": ...}' and expects '{\"approved\": true}' back, or '{\"approved\": false, "+
+			"\"reason\": ...}'. The certificates are not issued while the registry cannot be reached.")
+	flags.StringVar(&opts.identityRegistryCACertFile, "identity-registry-ca-cert", "",
+		"Specifies path to the CA certificate verifying the '--identity-registry-webhook' server. If unspecified, "+
+			"the system roots are used.")
+	flags.StringVar(&opts.identityRegistryTokenFile, "identity-registry-token-file", "",
+		"Specifies path to the file holding the bearer token sent to the '--identity-registry-webhook'")
+	flags.DurationVar(&opts.identityRegistryCacheTTL, "identity-registry-cache-ttl", 5*time.Minute,
+		"How long the approvals of the '--identity-registry-webhook' are cached. The refusals are never cached.")
+	flags.StringSliceVar(&opts.approvalRequiredIdentities, "approval-required-identities", nil,
+		"Comma-separated patterns of sensitive identities, e.g. spiffe://cluster.local/ns/istio-system/sa/*, "+
+			"whose certificates are only issued once an operator approves each issuance via the admin API, e.g. "+
+			"'istio_ca ctl approvals approve'. Requires '--admin-address'.")
+	flags.DurationVar(&opts.approvalTimeout, "approval-timeout", time.Hour,
+		"How long the approval requests of '--approval-required-identities' wait for a decision, and the "+
+			"approvals and denials remain in effect")
+	flags.StringVar(&opts.approverTokensFile, "approver-tokens-file", "",
+		"Specifies path to the JSON file mapping the names of the approvers of '--approval-required-identities' "+
+			"to their own admin API tokens, e.g. '{\"alice\": \"<token>\"}'. The issuances are then only approved "+
+			"or denied with an approver token, whose approver is recorded in the audit trail. Otherwise, they are "+
+			"decided with the admin token on behalf of the approver that the caller names.")
+	flags.StringVar(&opts.adcsURL, "adcs-url", "",
+		"The https:// URL of the web enrollment pages (certsrv) of a Microsoft ADCS CA, e.g. "+
+			"https://adcs.example.com/certsrv. Istio CA then runs as an intermediate of the ADCS hierarchy, "+
+			"enrolling its own signing key and certificate at startup and renewing them after two thirds of "+
+			"their lifetime, instead of '--signing-cert', '--signing-key' and '--cert-chain'. '--root-cert' must "+
+			"hold the root certificate of the hierarchy.")
+	flags.StringVar(&opts.adcsTemplate, "adcs-template", "SubCA",
+		"The ADCS certificate template issuing the '--adcs-url' signing certificates, which must issue CA "+
+			"certificates and accept the subject from the request")
+	flags.StringVar(&opts.adcsUsername, "adcs-username", "",
+		"The user authenticating to '--adcs-url' with HTTP basic authentication, e.g. 'EXAMPLE\\istio-ca'")
+	flags.StringVar(&opts.adcsPasswordFile, "adcs-password-file", "",
+		"Specifies path to the file holding the password of '--adcs-username'")
+	flags.StringVar(&opts.adcsCACertFile, "adcs-ca-cert", "",
+		"Specifies path to the CA certificate verifying the '--adcs-url' server. If unspecified, the system "+
+			"roots are used.")
+	flags.StringVar(&opts.adcsCommonName, "adcs-common-name", "Istio CA",
+		"The common name of the signing certificates requested from '--adcs-url'")
 
 	flags.StringVar(&opts.namespace, "namespace", "",
 		"Select a namespace for the CA to listen to. If unspecified, Istio CA tries to use the ${"+namespaceKey+"} "+
@@ -79,17 +400,400 @@ func init() {
 	flags.StringVar(&opts.kubeConfigFile, "kube-config", "",
 		"Specifies path to kubeconfig file. This must be specified when not running inside a Kubernetes pod.")
 
+	flags.StringVar(&opts.trustDomain, "trust-domain", "cluster.local",
+		"The trust domain of the Istio identities issued by the CA")
+	flags.StringVar(&opts.trustDomainsConfigFile, "trust-domains-config", "",
+		"Specifies path to a JSON file configuring the CAs of additional trust domains, as an object from trust "+
+			"domains to '{\"selfSigned\": true}' or to '{\"certChain\": ..., \"signingCert\": ..., "+
+			"\"signingKey\": ..., \"rootCert\": ...}' file paths, with \"signingKeyPIVSlot\" or \"signingWebhook\" "+
+			"optionally replacing \"signingKey\", or to '{\"signingSecret\": \"<namespace>/<name>\"}', or to "+
+			"'{\"adcsURL\": ..., \"rootCert\": ...}'. The "+
+			"workloads of a namespace labeled with 'istio.io/trust-domain' get their certificates from the CA of "+
+			"that trust domain.")
+	flags.StringVar(&opts.signingProfilesConfigFile, "signing-profiles-config", "",
+		"Specifies path to a JSON file configuring the signing profiles of the trust domain, as an object from "+
+			"profile names to the configurations of their CAs, like those of '--trust-domains-config' but never "+
+			"self-signed, with the \"namespaces\" allowed to select the profile. The workloads of an allowed "+
+			"namespace annotated with 'istio.io/signing-profile' get their certificates from the CA of that "+
+			"profile, e.g. under a dedicated intermediate CA.")
+
 	flags.BoolVar(&opts.selfSignedCA, "self-signed-ca", false,
 		"Indicates whether to use auto-generated self-signed CA certificate. "+
 			"When set to true, the '--signing-cert' and '--signing-key' options are ignored.")
-	flags.StringVar(&opts.selfSignedCAOrg, "self-signed-ca-org", "k8s.cluster.local",
-		fmt.Sprintf("The issuer organization used in self-signed CA certificate (default to %s)",
+	flags.StringSliceVar(&opts.selfSignedCAOrgs, "self-signed-ca-org", []string{selfSignedCAOrgDefault},
+		fmt.Sprintf("Comma-separated issuer organizations used in self-signed CA certificate (default to %s)",
 			selfSignedCAOrgDefault))
+	flags.StringSliceVar(&opts.selfSignedCAOrgUnits, "self-signed-ca-org-unit", nil,
+		"Comma-separated issuer organizational units used in self-signed CA certificate")
+	flags.StringVar(&opts.selfSignedCACommonName, "self-signed-ca-common-name", "",
+		"The issuer common name used in self-signed CA certificate")
+	flags.StringSliceVar(&opts.selfSignedCACountries, "self-signed-ca-country", nil,
+		"Comma-separated issuer countries used in self-signed CA certificate")
+	flags.IntVar(&opts.selfSignedCAMaxPathLen, "self-signed-ca-max-path-len", -1,
+		"The maximum number of intermediate CAs allowed below the self-signed CA certificate. "+
+			"A negative value leaves the path length unconstrained (default to -1)")
+	flags.BoolVar(&opts.selfSignedCANameConstrained, "self-signed-ca-name-constraints", false,
+		"Indicates whether to add a name constraint to the self-signed CA certificate that only permits "+
+			"Istio identities of the trust domain")
 
 	flags.DurationVar(&opts.caCertTTL, "ca-cert-ttl", 240*time.Hour,
 		"The TTL of self-signed CA root certificate (default to 10 days)")
 	flags.DurationVar(&opts.certTTL, "cert-ttl", time.Hour, "The TTL of issued certificates (default to 1 hour)")
+	flags.DurationVar(&opts.minCertTTL, "min-cert-ttl", 10*time.Minute,
+		"The minimum TTL of issued certificates. '--cert-ttl' must not be shorter, and certificates are not "+
+			"issued once the CA certificate expires too soon to issue a certificate valid this long "+
+			"(default to 10 minutes)")
+	flags.StringVar(&opts.signatureAlgorithm, "signature-algorithm", "",
+		"The algorithm to sign certificates with, one of "+strings.Join(certmanager.SignatureAlgorithmNames(), ", ")+
+			". It must suit the signing key. If unspecified, the default of the signing key is used. SHA-1 and "+
+			"weaker algorithms are refused, also in the signing certificate and its chain.")
+	flags.StringVar(&opts.pkiLint, "pki-lint", pkiLintOff,
+		"Which certificates to check against the baseline requirements of RFC 5280 and the CA/Browser Forum, "+
+			"logging the violations and counting them in the metrics: '"+pkiLintOff+"', '"+pkiLintCA+
+			"' for the operator-supplied CA certificates at startup, or '"+pkiLintAll+
+			"' for every issued certificate as well, which is meant for debugging")
+	flags.BoolVar(&opts.pkiLintStrict, "pki-lint-strict", false,
+		"Refuse to start if the operator-supplied CA certificates violate the checks of '--pki-lint'")
+	flags.DurationVar(&opts.namespaceCATTL, "namespace-ca-ttl", 0,
+		"The TTL of the per-namespace intermediate CAs. When set, the workload certificates of each namespace are "+
+			"issued by an intermediate CA of the namespace, which can be revoked on its own. "+
+			"Workload certificates are issued by the CA certificate directly if unspecified.")
+	flags.IntVar(&opts.namespaceMaxActiveIdentities, "namespace-max-active-identities", 0,
+		"The maximum number of identities of each namespace holding unexpired certificates, beyond which the "+
+			"CA refuses to issue certificates for new identities of the namespace. Unlimited if unspecified.")
+	flags.IntVar(&opts.namespaceMaxIssuancesPerHour, "namespace-max-issuances-per-hour", 0,
+		"The maximum number of certificates the CA issues for the identities of each namespace within an hour. "+
+			"Unlimited if unspecified.")
+	flags.StringVar(&opts.issuanceTimeFile, "issuance-time-file", "",
+		"Specifies path to the file persisting the time of the latest issuance, so that certificates are not "+
+			"backdated after the clock is rolled back across restarts. If unspecified, the time is only kept in memory.")
+	flags.StringVar(&opts.stateStore, "state-store", stateStoreMemory,
+		"Where to persist the issued certificates, the revocations and the time of the latest issuance, so that "+
+			"they survive restarts and are shared by the replicas of Istio CA: either '"+stateStoreConfigMap+
+			"' for the '--state-configmap' ConfigMap, or '"+stateStoreSQL+"' for the '--state-sql-dsn' database. "+
+			"If unspecified, the state is only kept in memory.")
+	flags.StringVar(&opts.stateConfigMap, "state-configmap", "istio-ca-state",
+		"The name of the ConfigMap persisting the state with '--state-store="+stateStoreConfigMap+"'. It is "+
+			"created in the namespace of Istio CA, or in '"+controlPlaneNamespaceDefault+"' if Istio CA listens to "+
+			"all namespaces.")
+	flags.StringVar(&opts.stateSQLDriver, "state-sql-driver", "",
+		"The SQL driver of the database persisting the state with '--state-store="+stateStoreSQL+"', one of "+
+			strings.Join(state.SQLDialects, ", ")+".")
+	flags.StringVar(&opts.stateSQLDSN, "state-sql-dsn", "",
+		"The data source name of the database persisting the state with '--state-store="+stateStoreSQL+"'.")
+	flags.DurationVar(&opts.stateSyncInterval, "state-sync-interval", 30*time.Second,
+		"The interval between the loads of the revocations made by the other replicas from '--state-store' "+
+			"(default to 30 seconds)")
+
+	flags.StringVar(&opts.ntpServer, "ntp-server", "",
+		"The NTP server to measure the clock skew against. If unspecified, the clock skew is measured against "+
+			"the Kubernetes API server.")
+	flags.DurationVar(&opts.clockSkewCheckInterval, "clock-skew-check-interval", time.Minute,
+		"The interval between clock skew measurements (default to 1 minute)")
+	flags.DurationVar(&opts.maxClockSkew, "max-clock-skew", 0,
+		"The measured clock skew beyond which Istio CA refuses to issue certificates. "+
+			"The check is disabled if unspecified.")
+	flags.BoolVar(&opts.harden, "harden", false,
+		"Whether to disable the core dumps of Istio CA and have its key generations and certificate signings "+
+			"recover from panics, which are logged with any key material redacted. Only supported on Linux.")
+	flags.StringVar(&opts.secureMemory, "secure-memory", secureMemoryOff,
+		"Whether to lock the memory of Istio CA against swapping and disable its core dumps, so that the signing "+
+			"key and the workload keys never leave the memory: '"+secureMemoryOff+"', '"+secureMemoryPreferred+
+			"' to warn if the memory cannot be locked, or '"+secureMemoryRequired+"' to refuse to start then. "+
+			"Locking needs the IPC_LOCK capability and is only supported on Linux.")
+	flags.StringVar(&opts.randomSourceFile, "random-source", "",
+		"The device or file of an additional source of randomness, e.g. '/dev/hwrng' for a hardware RNG, mixed "+
+			"with the randomness of the kernel in the keys, serial numbers and signatures generated by the CA")
+	flags.DurationVar(&opts.entropyProbeTimeout, "entropy-probe-timeout", 5*time.Second,
+		"The time the random sources have to deliver in the entropy check run on startup and on '/warnz', "+
+			"beyond which they are reported to lack entropy")
+	flags.IntVar(&opts.maxConcurrentKeyGenerations, "max-concurrent-key-generations", 0,
+		"The maximum number of RSA keys generated at once, so that mass provisionings do not saturate the CPUs "+
+			"and starve the other work; the others wait, see the 'istio_ca_key_generation*' metrics. "+
+			"0 bounds them to half the CPUs, at least 1.")
+	flags.IntVar(&opts.keyPoolSize, "key-pool-size", 0,
+		"The number of workload keys pre-generated in the background, in memory locked against swapping, to serve "+
+			"bursts of new service accounts without generating their keys inline (0 disables the pool). "+
+			"The pool needs an RLIMIT_MEMLOCK of at least one page per key.")
+
+	flags.BoolVar(&opts.deleteRevokedSecrets, "delete-revoked-secrets", false,
+		"Indicates whether to delete and re-create the secret holding a revoked certificate, "+
+			"instead of rotating its key and certificate in place.")
+
+	flags.BoolVar(&opts.noKeyEscrow, "no-key-escrow", false,
+		"Indicates whether to forbid Istio CA from generating the private keys of workloads. When set to true, "+
+			"certificates are only issued by signing certificate signing requests, and Istio CA neither "+
+			"provisions Istio secrets nor server certificate secrets.")
+
+	flags.BoolVar(&opts.adoptUnlabeledSecrets, "adopt-unlabeled", false,
+		"Indicates whether to take over existing Istio secrets that lack both the 'istio.io/managed-by' label "+
+			"and the 'istio.io/service-account.name' annotation. Otherwise such secrets are considered user-managed "+
+			"and are never modified. Unlabeled secrets that carry the annotation, as written by earlier versions of "+
+			"Istio CA, are always taken over; secrets labeled with another owner are never modified.")
+	flags.BoolVar(&opts.orphanSecretsOnShutdown, "orphan-on-shutdown", false,
+		"Indicates whether to relabel the managed Istio secrets with 'istio.io/managed-by=user' "+
+			"when Istio CA shuts down, so that they are left to their users.")
+
+	flags.BoolVar(&opts.pemComments, "pem-comments", false,
+		"Indicates whether to prefix the certificates in the Istio secrets with comments naming their identity, "+
+			"issuer and expiry, to ease debugging certificates on the hosts.")
+	flags.BoolVar(&opts.secretTLSKeys, "secret-tls-keys", false,
+		"Indicates whether to also write the certificate chain, the private key and the root certificate of the "+
+			"Istio secrets under the 'tls.crt', 'tls.key' and 'ca.crt' keys, during a transition in which consumers "+
+			"move to those keys while running sidecars still read the Istio keys.")
+	flags.BoolVar(&opts.secretMinimalChains, "secret-minimal-chains", false,
+		"Indicates whether to drop the duplicated certificates and the root certificate from the certificate chains "+
+			"of the Istio secrets, reducing the size of the secrets and of the TLS handshakes of the workloads.")
+	flags.IntVar(&opts.maxCertExpirySeries, "max-cert-expiry-series", 0,
+		"The maximum number of service accounts whose certificate lifetime is exported by the "+
+			"'istio_ca_managed_cert_expiry_seconds' metric; if there are more, those expiring first are exported "+
+			"(0 disables the metric). One series is exported per service account, so mind the cardinality.")
+	flags.StringSliceVar(&opts.secretDataKeys, "secret-data-key", nil,
+		"An additional name of a data key of the Istio secrets, as '<Istio data key>=<name>', e.g. 'key.pem=tls.key', "+
+			"for applications expecting the certificates under hard-coded names. The data is written under both names.")
+	flags.BoolVar(&opts.migrateOnly, "migrate-only", false,
+		"Indicates whether to only migrate the Istio secrets of older schema versions to the current one, and exit. "+
+			"Otherwise, they are migrated when the secret controller starts.")
+
+	flags.BoolVar(&opts.enableServerCerts, "enable-server-certs", false,
+		"Indicates whether to provision server certificates for Services and Ingresses annotated with "+
+			"'istio.io/tls-secret', so that gateways can terminate TLS with certificates issued by Istio CA.")
+
+	flags.StringVar(&opts.discoveryAddress, "discovery-address", "",
+		"The address that workloads reach Istio CA at, e.g. 'istio-ca.istio-system:8060'. When set, the address "+
+			"and the root certificate fingerprint are published in the '--discovery-configmap' ConfigMap.")
+	flags.StringVar(&opts.discoveryConfigMap, "discovery-configmap", "istio-ca",
+		"The name of the ConfigMap to publish the discovery data in. It is created in the namespace of Istio CA, "+
+			"or in '"+controlPlaneNamespaceDefault+"' if Istio CA listens to all namespaces.")
+
+	flags.BoolVar(&opts.bootstrapControlPlane, "bootstrap-control-plane", false,
+		"Whether to provision the Istio secrets of the control-plane service accounts in the 'istio.io/control-plane' "+
+			"profile, i.e. those of Pilot, Mixer and Istio CA, before provisioning those of the workloads. They are "+
+			"created in the namespace of Istio CA, or in '"+controlPlaneNamespaceDefault+"' if Istio CA listens to "+
+			"all namespaces.")
+
+	flags.BoolVar(&opts.skipPermissionCheck, "skip-permission-check", false,
+		"Indicates whether to skip the startup check that Istio CA is granted the API access needed by the "+
+			"enabled controllers. The check requires SelfSubjectAccessReviews to be served.")
+
+	flags.StringVar(&opts.adminAddress, "admin-address", "",
+		"The address to serve the admin API on, e.g. 'localhost:8061'. The admin API is served over TLS with a "+
+			"certificate issued by the CA, and is disabled if unspecified.")
+	flags.StringSliceVar(&opts.adminHosts, "admin-hosts", []string{"localhost", "127.0.0.1"},
+		"The DNS names and IP addresses that the TLS certificate of the admin API is issued for.")
+	flags.StringVar(&opts.adminTokenFile, "admin-token-file", "",
+		"Specifies path to the file holding the bearer token that admin API clients must present. "+
+			"This must be specified when '--admin-address' is set.")
+
+	flags.StringVar(&opts.csrAddress, "csr-address", "",
+		"The address to serve the gRPC CSR API on, e.g. ':8060', which signs the certificate signing requests of "+
+			"workloads authenticated by the '--csr-gcp-*', '--csr-azure-*', '--csr-kubernetes-tokens' or '--csr-oidc-issuers' "+
+			"credentials. The CSR API is served over TLS with a certificate issued by the CA, and is disabled if "+
+			"unspecified.")
+	flags.StringSliceVar(&opts.csrHosts, "csr-hosts", nil,
+		"The DNS names and IP addresses that the TLS certificate of the CSR API is issued for. "+
+			"This must be specified when '--csr-address' is set.")
+	flags.StringVar(&opts.csrGCPAudience, "csr-gcp-audience", "",
+		"The audience of the Google-signed ID tokens accepted by the CSR API. GCP credentials are not accepted "+
+			"if unspecified.")
+	flags.StringVar(&opts.csrGCPIdentityMapping, "csr-gcp-identity-mapping", "",
+		"Specifies path to the JSON file mapping GCP service account emails to the SPIFFE URIs they are issued "+
+			"certificates for.")
+	flags.StringVar(&opts.csrAzureTenant, "csr-azure-tenant", "",
+		"The Azure AD tenant of the managed identity access tokens accepted by the CSR API. Azure credentials "+
+			"are not accepted if unspecified.")
+	flags.StringVar(&opts.csrAzureAudience, "csr-azure-audience", "",
+		"The audience of the Azure AD access tokens accepted by the CSR API.")
+	flags.StringVar(&opts.csrAzureIdentityMapping, "csr-azure-identity-mapping", "",
+		"Specifies path to the JSON file mapping Azure resource IDs to the SPIFFE URIs they are issued "+
+			"certificates for.")
+	flags.BoolVar(&opts.csrKubernetesTokens, "csr-kubernetes-tokens", false,
+		"Whether the CSR API accepts the tokens of Kubernetes service accounts, verified with the TokenReview API. "+
+			"Pods then request certificates for their service accounts with keys of their own, rather than "+
+			"sharing the key in the secret of the service account, and StatefulSet pods get their stable DNS "+
+			"names, e.g. 'web-0.web.default.svc.cluster.local', added to their certificates.")
+	flags.StringVar(&opts.csrKubernetesAudience, "csr-kubernetes-audience", "",
+		"The audience of the Kubernetes service account tokens accepted by the CSR API, e.g. 'istio-ca' for "+
+			"projected tokens. If unspecified, the tokens issued for any audience are accepted.")
+	flags.BoolVar(&opts.csrPodIdentity, "csr-pod-identity", false,
+		"Whether the certificates that pods request with the tokens of '--csr-kubernetes-tokens' carry the "+
+			"namespace, name, UID and node name of the pod in a non-critical X.509 extension, for tracing the "+
+			"certificates back to their pods. The pod is found by the IP address of the caller.")
+	flags.StringVar(&opts.clusterDomain, "cluster-domain", "cluster.local",
+		"The DNS domain of the Kubernetes cluster, in which the stable DNS names of StatefulSet pods are "+
+			"'<pod>.<service>.<namespace>.svc.<cluster domain>'.")
+	flags.StringVar(&opts.csrOIDCIssuers, "csr-oidc-issuers", "",
+		"Specifies path to the JSON file listing the OpenID Connect providers, e.g. CI systems or the service "+
+			"account issuers of other clusters, whose ID tokens the CSR API accepts with the '"+
+			csr.CredentialTypeOIDC+"' credential type: '[{\"issuer\": ..., \"audience\": ..., \"jwksURI\": ..., "+
+			"\"rules\": [{\"claims\": {\"<claim>\": \"<value or prefix*>\"}, \"identity\": "+
+			"\"spiffe://cluster.local/ns/<namespace>/sa/{<claim>}\"}]}]'. The keys are discovered from the OpenID "+
+			"configuration of an issuer unless 'jwksURI' is set, and the first rule matching the claims of a token "+
+			"maps it to an identity.")
+	flags.StringVar(&opts.csrDelegationPolicy, "csr-delegation-policy", "",
+		"Specifies path to the JSON file mapping the SPIFFE URIs of trusted callers, e.g. an ingress operator, to "+
+			"the identities they may request certificates on behalf of, e.g. 'spiffe://cluster.local/ns/gw/*'. "+
+			"Callers may only request certificates for their own identities if unspecified.")
+	flags.BoolVar(&opts.csrRequireNonce, "csr-require-nonce", false,
+		"Whether every certificate signing request on the CSR API must carry a recent nonce from GetNonce, "+
+			"which prevents replaying requests signed by a workload key.")
+	flags.StringVar(&opts.csrMethodPolicy, "csr-method-policy", "",
+		"Specifies path to the JSON file mapping the methods of the CSR API, 'Sign' or 'BatchSign', to the SPIFFE "+
+			"URIs of the callers that may call them, e.g. '{\"BatchSign\": [\"spiffe://cluster.local/ns/gw/*\"]}'. "+
+			"The methods it does not list may be called by every authenticated caller.")
+	flags.IntVar(&opts.csrRateLimit, "csr-rate-limit", 0,
+		"The maximum number of calls of each method of the CSR API by each caller within a minute, "+
+			"or 0 for no limit.")
+	flags.IntVar(&opts.csrMaxStreams, "csr-max-concurrent-streams", csr.DefaultMaxConcurrentStreams,
+		"The maximum number of concurrent calls on each connection to the CSR API.")
+	flags.IntVar(&opts.csrMaxPeerConnections, "csr-max-connections-per-peer", csr.DefaultMaxConnectionsPerPeer,
+		"The maximum number of concurrent connections to the CSR API from each IP address.")
+	flags.StringVar(&opts.estAddress, "est-address", "",
+		"The address to serve EST (RFC 7030) on, e.g. ':8443', for network devices and embedded systems that "+
+			"enroll with EST. Enrollments authenticate with HTTP basic authentication, whose username is a CSR "+
+			"API credential type and password the credential; re-enrollments with the certificate being renewed. "+
+			"EST shares the TLS certificate and the authentication of the CSR API, and is disabled if unspecified.")
+	flags.StringVar(&opts.tokenExchangeAddress, "token-exchange-address", "",
+		"The address to serve the token exchange endpoint on, e.g. ':8444', for CI systems and other external "+
+			"jobs calling mesh services. A job POSTs an ID token of '--token-exchange-oidc-issuer' and a "+
+			"certificate signing request to "+sts.Path+", as in OAuth 2.0 Token Exchange (RFC 8693), and gets a "+
+			"certificate valid for '--token-exchange-cert-ttl' of the identity the token maps to. The endpoint "+
+			"shares the TLS certificate of the CSR API, and is disabled if unspecified.")
+	flags.StringVar(&opts.tokenExchangeOIDCIssuer, "token-exchange-oidc-issuer", "",
+		"The https:// URL of the OpenID Connect provider whose ID tokens the token exchange endpoint accepts, e.g. "+
+			"https://token.actions.githubusercontent.com. Its keys are discovered from its OpenID configuration.")
+	flags.StringVar(&opts.tokenExchangeOIDCAudience, "token-exchange-oidc-audience", "",
+		"The audience of the ID tokens accepted by the token exchange endpoint.")
+	flags.StringVar(&opts.tokenExchangeOIDCClaim, "token-exchange-oidc-claim", "sub",
+		"The claim of the ID tokens that '--token-exchange-identity-mapping' maps to the Istio identities.")
+	flags.StringVar(&opts.tokenExchangeIdentityMapping, "token-exchange-identity-mapping", "",
+		"Specifies path to the JSON file mapping the values of '--token-exchange-oidc-claim' to the SPIFFE URIs "+
+			"they are issued certificates for.")
+	flags.DurationVar(&opts.tokenExchangeCertTTL, "token-exchange-cert-ttl", 15*time.Minute,
+		"The TTL of the certificates issued by the token exchange endpoint. It must not be shorter than "+
+			"'--min-cert-ttl' nor longer than '--cert-ttl'.")
+	flags.StringVar(&opts.scepAddress, "scep-address", "",
+		"The address to serve SCEP on over HTTP, e.g. ':8080', for legacy appliances that only enroll with SCEP. "+
+			"A device authenticates with the challenge password of its certificate signing request, which must "+
+			"ask for an identity that '--scep-challenges' allows for the password. SCEP is disabled if unspecified.")
+	flags.StringVar(&opts.scepChallengesFile, "scep-challenges", "",
+		"Specifies path to the JSON file mapping the SCEP challenge passwords to the SPIFFE URIs that devices "+
+			"presenting them may enroll for, e.g. 'spiffe://cluster.local/ns/devices/*'. "+
+			"This must be specified when '--scep-address' is set.")
+
+	flags.StringVar(&opts.federationAddress, "federation-address", "",
+		"The address to serve the root certificates of the CA to the peer CAs of '--federation-peers' on, e.g. "+
+			"':8070'. The peers are authenticated with mutual TLS, and federation is disabled if unspecified.")
+	flags.StringVar(&opts.federationPeersFile, "federation-peers", "",
+		"Specifies path to a JSON file listing the CAs of the other clusters of a multi-primary mesh, as an array "+
+			"of '{\"name\": ..., \"address\": ..., \"id\": ..., \"rootCert\": ...}' with the URI SAN that the "+
+			"peer authenticates with and the path to its current root certificates. The roots of the peers are "+
+			"fetched every '--federation-sync-interval' and added to the distributed trust bundle.")
+	flags.StringVar(&opts.federationID, "federation-id", "",
+		"The URI SAN of the certificate the CA authenticates to the peers with (default to "+
+			"'spiffe://<trust domain>/ns/<control-plane namespace>/sa/istio-ca-service-account')")
+	flags.DurationVar(&opts.federationSyncInterval, "federation-sync-interval", time.Minute,
+		"The interval to fetch the root certificates of the peers")
+
+	flags.DurationVar(&opts.rootRolloutInterval, "root-rollout-interval", 10*time.Minute,
+		"The time to observe each namespace during a staged root rotation, which a self-signed CA rolls out "+
+			"namespace by namespace via the admin API, before checking its health and rolling out the next one.")
+	flags.StringSliceVar(&opts.rootRolloutCanaries, "root-rollout-canaries", nil,
+		"The namespaces to roll out first during a staged root rotation, in order.")
+	flags.StringVar(&opts.rootRolloutHealthMetric, "root-rollout-health-metric", "istio_ca_controller_failures_total",
+		"The metric whose increase while a namespace is observed pauses a staged root rotation. "+
+			"Set it to an empty string to only check that the certificates of the namespace are re-issued.")
+	flags.Float64Var(&opts.rootRolloutMaxMetricIncrease, "root-rollout-max-metric-increase", 0,
+		"The maximum increase of '--root-rollout-health-metric' while a namespace is observed.")
+
+	flags.StringVar(&opts.secretEncryptionKMSKey, "secret-encryption-kms-key", "",
+		"The resource name of a Cloud KMS key, e.g. 'projects/<project>/locations/<location>/keyRings/<ring>/"+
+			"cryptoKeys/<key>', to envelope-encrypt the private keys with before they are written to the Istio "+
+			"secrets. The encrypted key is stored as 'key.pem.enc' in place of 'key.pem', and must be decrypted "+
+			"by an agent with access to the KMS key. The private keys are stored in plaintext if unspecified.")
+
+	flags.StringVar(&opts.keyRotationPolicy, "key-rotation-policy", keyRotationPolicyRekey,
+		"Whether a new private key is generated ('"+keyRotationPolicyRekey+"') or the existing private key is "+
+			"re-certified ('"+keyRotationPolicyReuse+"') when the certificate of an Istio secret is rotated. The key "+
+			"is always regenerated when its certificate is revoked.")
+	flags.DurationVar(&opts.maxKeyAge, "max-key-age", 0,
+		"The maximum age of a re-certified private key, beyond which a new key is generated on rotation. "+
+			"Only used with '--key-rotation-policy="+keyRotationPolicyReuse+"'; unlimited if unspecified.")
+
+	flags.StringVar(&opts.exportDir, "export-dir", "",
+		"The directory, e.g. a mounted GCS or S3 bucket, to periodically export CSV reports of the issued "+
+			"certificates to. The reports are not exported if neither '--export-dir' nor '--export-url' is set.")
+	flags.StringVar(&opts.exportURL, "export-url", "",
+		"The base URL to periodically upload CSV reports of the issued certificates to with HTTP PUT, "+
+			"e.g. 'https://storage.googleapis.com/<bucket>/<prefix>'")
+	flags.StringVar(&opts.exportTokenFile, "export-token-file", "",
+		"Specifies path to the file holding the bearer token to authorize the uploads to '--export-url' with")
+	flags.DurationVar(&opts.exportInterval, "export-interval", 24*time.Hour,
+		"The interval between the exported reports of the issued certificates (default to 24 hours)")
+	flags.BoolVar(&opts.exportOpenSSLDB, "export-openssl-db", false,
+		"Indicates whether every export also replaces an OpenSSL CA database ('index.txt', 'index.txt.attr' and "+
+			"'serial') of the unexpired certificates, so that existing PKI tooling can inspect the issuance state")
 
+	flags.StringVar(&opts.replicateConsulAddress, "replicate-consul-address", "",
+		"The address of a Consul agent, e.g. 'https://consul:8501', to replicate the issued certificates and the "+
+			"trust bundle into for consumers outside of Kubernetes")
+	flags.StringVar(&opts.replicateEtcdAddress, "replicate-etcd-address", "",
+		"The client URL of an etcd member, e.g. 'https://etcd:2379', to replicate the issued certificates and the "+
+			"trust bundle into for consumers outside of Kubernetes")
+	flags.StringVar(&opts.replicateTokenFile, "replicate-token-file", "",
+		"Specifies path to the file holding the ACL token to write to '--replicate-consul-address' with")
+	flags.StringVar(&opts.replicatePrefix, "replicate-prefix", "istio-ca",
+		"The key prefix of the replicated certificates ('<prefix>/certs/<serial number>') and trust bundle "+
+			"('<prefix>/trust-bundle')")
+	flags.DurationVar(&opts.replicateInterval, "replicate-interval", time.Minute,
+		"The interval between the checks of the replicated trust bundle and the deletions of the expired "+
+			"certificates (default to 1 minute)")
+
+	flags.IntVar(&opts.monitoringPort, "monitoring-port", 9093,
+		"The port to serve the readiness endpoint ('/ready'), the warnings ('/warnz'), Prometheus metrics "+
+			"('/metrics') and the build information ('/version') on")
+	flags.BoolVar(&opts.enableProfiling, "enable-profiling", false,
+		"Indicates whether to serve pprof profiles ('/debug/pprof/'), expvar variables ('/debug/vars') and a dump "+
+			"of all goroutine stacks ('/debug/goroutines') on the monitoring port")
+
+	flags.Float64Var(&opts.maxExpiringCertFraction, "max-expiring-cert-fraction", 0,
+		"The fraction of the provisioned certificates expiring within '--expiring-cert-window' beyond which a "+
+			"warning is reported on '/warnz' (0 disables the warning)")
+	flags.DurationVar(&opts.expiringCertWindow, "expiring-cert-window", 10*time.Minute,
+		"The window in which a certificate is counted as expiring by '--max-expiring-cert-fraction'")
+	flags.DurationVar(&opts.minRootCertLifetime, "min-root-cert-lifetime", 0,
+		"The remaining lifetime of the root certificate below which a warning is reported on '/warnz' "+
+			"(0 disables the warning)")
+	flags.BoolVar(&opts.warningsFailReadiness, "warnings-fail-readiness", false,
+		"Indicates whether the warnings reported on '/warnz' also fail the readiness endpoint")
+	flags.DurationVar(&opts.canaryInterval, "canary-interval", 0,
+		"The interval to sign a certificate signing request for the synthetic identity "+
+			"'spiffe://<trust domain>/ns/"+canaryNamespace+"/sa/"+canaryServiceAccount+"' with the CA of each "+
+			"trust domain and verify the chain, recording the results in the 'istio_ca_canary_*' metrics "+
+			"(0 disables the canaries). The canary certificates are recorded like any other issued certificate.")
+	flags.DurationVar(&opts.mtlsReadinessInterval, "mtls-readiness-interval", 0,
+		"The interval to check whether every service account has a valid Istio secret, annotating each namespace "+
+			"with 'istio.io/mtls-ready' and recording the results in the 'istio_ca_controller_mtls_*' metrics "+
+			"(0 disables the check). The mesh is safe to switch to strict mutual TLS once all namespaces are ready.")
+	flags.DurationVar(&opts.mtlsReadinessMinRemaining, "mtls-readiness-min-remaining", 10*time.Minute,
+		"The remaining lifetime below which the certificate of a service account is not counted as ready")
+
+	// The fault injection flags soak-test the retries and rotations in staging
+	// clusters; they are hidden from the usage.
+	flags.Float64Var(&opts.injectSignErrors, "inject-sign-errors", 0,
+		"The probability, between 0 and 1, at which the issuances of the CAs fail with an injected error")
+	flags.DurationVar(&opts.injectKubeLatency, "inject-kube-latency", 0,
+		"The maximum random latency injected before every request to the Kubernetes API server")
+	for _, name := range []string{"inject-sign-errors", "inject-kube-latency"} {
+		if err := flags.MarkHidden(name); err != nil {
+			glog.Fatalf("Failed to hide flag '--%s' (error: %v)", name, err)
+		}
+	}
+
+	rootCmd.AddCommand(ctl.Command)
+	rootCmd.AddCommand(deploy.Command)
+	rootCmd.AddCommand(offline.Command)
+	rootCmd.AddCommand(verify.Command)
 	rootCmd.AddCommand(version.Command)
 }
 
@@ -100,7 +804,10 @@ func main() {
 	}
 }
 
-func runCA() {
+func runCA(flags *pflag.FlagSet) {
+	if err := applyConfig(flags, os.LookupEnv); err != nil {
+		glog.Fatalf("Invalid configuration (error: %v)", err)
+	}
 	if opts.namespace == "" {
 		// When -namespace is not set, try to read the namespace from environment variable.
 		if value, exists := os.LookupEnv(namespaceKey); exists {
@@ -108,21 +815,658 @@ func runCA() {
 		}
 	}
 
-	verifyCommandLineOptions()
+	if err := opts.validate(); err != nil {
+		if agg, ok := err.(utilerrors.Aggregate); ok {
+			for _, e := range agg.Errors() {
+				glog.Error(e)
+			}
+		}
+		glog.Fatalf("Invalid command line options (error: %v)", err)
+	}
+
+	if opts.harden {
+		if err := certmanager.EnableHardening(); err != nil {
+			glog.Fatalf("Failed to harden Istio CA (error: %v)", err)
+		}
+		glog.Info("Istio CA is hardened: its core dumps are disabled and the crypto panics are recovered")
+	}
+	if opts.secureMemory != secureMemoryOff {
+		if err := certmanager.LockProcessMemory(); err == nil {
+			glog.Info("The memory of Istio CA is locked and its core dumps are disabled")
+		} else if opts.secureMemory == secureMemoryRequired {
+			glog.Fatalf("Failed to secure the memory of Istio CA (error: %v)", err)
+		} else {
+			glog.Warningf("Failed to secure the memory of Istio CA; the keys may be swapped (error: %v)", err)
+		}
+	}
+	if opts.randomSourceFile != "" {
+		f, err := os.Open(opts.randomSourceFile)
+		if err != nil {
+			glog.Fatalf("Failed to open the random source %s (error: %v)", opts.randomSourceFile, err)
+		}
+		certmanager.SetRandomSource(f)
+	}
+
+	maxKeyGenerations := opts.maxConcurrentKeyGenerations
+	if maxKeyGenerations == 0 {
+		maxKeyGenerations = (runtime.NumCPU() + 1) / 2
+	}
+	certmanager.SetMaxConcurrentKeyGenerations(maxKeyGenerations)
+
+	config := generateConfig()
+	skew := certmanager.NewClockSkewMonitor(createClockSkewSource(config), opts.clockSkewCheckInterval)
+	var store certmanager.IssuanceTimeStore
+	if opts.issuanceTimeFile != "" {
+		store = certmanager.FileIssuanceTimeStore{Path: opts.issuanceTimeFile}
+	}
+	if opts.injectSignErrors > 0 || opts.injectKubeLatency > 0 {
+		glog.Warningf("Injecting faults for soak testing: %v of the issuances fail, and the requests to the "+
+			"Kubernetes API server are delayed by up to %v", opts.injectSignErrors, opts.injectKubeLatency)
+	}
+	cs := createClientset(withInjectedLatency(config, opts.injectKubeLatency))
+	identityRegistry = createIdentityRegistry()
+	approvalQueue = createApprovalQueue()
+	stateStore := createStateStore(cs.CoreV1())
+	ca := createCA(opts.trustDomain, opts.defaultTrustDomainConfig(), store, stateStore, skew, cs.CoreV1())
+	trustDomainCAs := createTrustDomainCAs(skew, cs.CoreV1())
+	signingProfileCAs := createSigningProfileCAs(skew, cs.CoreV1())
+
+	root := certmanager.ParsePemEncodedCertificate(ca.GetRootCertificate())
+	checks := []selftest.Check{
+		{Name: "ca", Run: ca.SelfTest},
+		selftest.ClockCheck(root.NotBefore, root.NotAfter),
+		entropyCheck(),
+		{Name: "kubernetes", Run: func() error {
+			_, err := cs.Discovery().ServerVersion()
+			return err
+		}},
+	}
+	for domain, tdca := range trustDomainCAs {
+		checks = append(checks, selftest.Check{Name: "ca-" + domain, Run: tdca.SelfTest})
+	}
+	for name, pca := range signingProfileCAs {
+		checks = append(checks, selftest.Check{Name: "ca-profile-" + name, Run: pca.SelfTest})
+	}
+	if !opts.skipPermissionCheck {
+		checks = append(checks, selftest.Check{Name: "permissions", Run: func() error {
+			return checkPermissions(cs.AuthorizationV1beta1().SelfSubjectAccessReviews(),
+				len(trustDomainCAs) > 0 || len(signingProfileCAs) > 0)
+		}})
+	}
+	suite := selftest.NewSuite(checks...)
 
-	ca := createCA()
-	cs := createClientset()
-	sc := controller.NewSecretController(ca, cs.CoreV1(), opts.namespace)
+	// The warning checks only run once the secret controller is created, see below.
+	var sc *controller.SecretController
+	warningChecks := []selftest.Check{entropyCheck()}
+	if opts.maxExpiringCertFraction > 0 && !opts.noKeyEscrow {
+		warningChecks = append(warningChecks, selftest.ExpiringCertificatesCheck(func() []time.Time {
+			return sc.CertificateExpiries()
+		}, opts.expiringCertWindow, opts.maxExpiringCertFraction))
+	}
+	if opts.minRootCertLifetime > 0 {
+		warningChecks = append(warningChecks, selftest.RootExpiryCheck(func() time.Time {
+			return certmanager.ParsePemEncodedCertificate(ca.GetRootCertificate()).NotAfter
+		}, opts.minRootCertLifetime))
+	}
+	warnings := selftest.NewSuite(warningChecks...)
+	go runMonitoring(suite, warnings)
 
 	stopCh := make(chan struct{})
-	sc.Run(stopCh)
+	go skew.Run(stopCh)
+	if opts.keyPoolSize > 0 {
+		pool := certmanager.NewKeyPool(opts.keyPoolSize)
+		certmanager.SetKeyPool(pool)
+		go pool.Run(stopCh)
+	}
+	for _, ssc := range signingSecretControllers {
+		go ssc.Run(stopCh)
+	}
+	for _, renew := range adcsRenewals {
+		go renew(stopCh)
+	}
+	if !suite.Run() {
+		// Stay up without serving, so that the failed checks are visible via
+		// the readiness endpoint and the metrics.
+		glog.Error("Istio CA failed the startup self-test and will not become ready")
+		<-stopCh
+	}
+
+	revocations := certmanager.NewRevocationList()
+	if stateStore != nil {
+		var err error
+		if revocations, err = certmanager.NewPersistentRevocationList(stateStore); err != nil {
+			glog.Fatalf("Failed to load the revocations from '--state-store' (error: %v)", err)
+		}
+		go wait.Until(func() {
+			if err := revocations.Sync(); err != nil {
+				glog.Errorf("Failed to sync the revocations from '--state-store' (error: %v)", err)
+			}
+		}, opts.stateSyncInterval, stopCh)
+	}
+	// The CA replaces a revoked intermediate before the secrets issued by it are rotated.
+	revocations.AddListener(ca.HandleRevocation)
+	trustDomains := map[string]certmanager.CertificateAuthority{}
+	for domain, tdca := range trustDomainCAs {
+		revocations.AddListener(tdca.HandleRevocation)
+		trustDomains[domain] = tdca
+	}
+	signingProfiles := map[string]controller.SigningProfile{}
+	for name, pca := range signingProfileCAs {
+		revocations.AddListener(pca.HandleRevocation)
+		signingProfiles[name] = controller.SigningProfile{
+			CA:         pca,
+			Namespaces: sets.NewString(opts.signingProfileConfigs[name].Namespaces...),
+		}
+	}
+
+	var keyEncryption kms.KMS
+	if opts.secretEncryptionKMSKey != "" {
+		keyEncryption = kms.NewGCPKMS(opts.secretEncryptionKMSKey)
+	}
+	// Istio secrets carry private keys generated by the CA, so they are not
+	// provisioned when key escrow is disabled.
+	if !opts.noKeyEscrow {
+		sc = controller.NewSecretController(ca, cs.CoreV1(), opts.namespace, controller.SecretControllerOptions{
+			AdoptUnlabeled:     opts.adoptUnlabeledSecrets,
+			OrphanOnShutdown:   opts.orphanSecretsOnShutdown,
+			TrustDomains:       trustDomains,
+			DefaultTrustDomain: opts.trustDomain,
+			SigningProfiles:    signingProfiles,
+			KeyEncryption:      keyEncryption,
+			ReuseKeys:          opts.keyRotationPolicy == keyRotationPolicyReuse,
+			MaxKeyAge:          opts.maxKeyAge,
+			PEMComments:        opts.pemComments,
+			TLSKeys:            opts.secretTLSKeys,
+			MinimalChains:      opts.secretMinimalChains,
+			MaxExpirySeries:    opts.maxCertExpirySeries,
+			DataKeyAliases:     opts.secretDataKeyAliases,
+		})
+		revocations.AddListener(func(serial *big.Int) {
+			sc.HandleRevocation(serial, opts.deleteRevokedSecrets)
+		})
+		prometheus.MustRegister(sc.Collector())
+
+		if opts.bootstrapControlPlane {
+			err := sc.BootstrapControlPlane(controlPlaneNamespace(), controller.ControlPlaneProfile)
+			if err != nil {
+				glog.Errorf("Failed to bootstrap the Istio secrets of the control plane (error: %v)", err)
+			}
+		}
+		if opts.migrateOnly {
+			migrated, err := sc.MigrateSecrets()
+			if err != nil {
+				glog.Fatalf("Failed to migrate the Istio secrets (error: %v)", err)
+			}
+			glog.Infof("Migrated %d Istio secrets; exiting as '--migrate-only' is set", migrated)
+			return
+		}
+	}
+
+	// The cached keys of the identity providers are registered as the
+	// authenticators are created below.
+	var as *admin.Server
+	if opts.adminAddress != "" {
+		token := strings.TrimSpace(string(readFile(opts.adminTokenFile)))
+		as = admin.NewServer(ca, revocations, effectiveConfig(flags), []byte(token))
+		if w, ok := identityRegistry.(*registry.Webhook); ok {
+			as.AddCache("registry", func() error {
+				w.Flush()
+				return nil
+			})
+		}
+		if sc != nil {
+			as.AddCache("secrets", sc.FlushSecretCache)
+			as.Inventory = func() []admin.Identity {
+				identities := []admin.Identity{}
+				for _, id := range sc.ManagedIdentities() {
+					identities = append(identities, admin.Identity(id))
+				}
+				return identities
+			}
+			as.Reissue = func(within time.Duration, namespace string) []admin.Identity {
+				identities := []admin.Identity{}
+				for _, id := range sc.ReissueExpiring(within, namespace) {
+					identities = append(identities, admin.Identity(id))
+				}
+				return identities
+			}
+			if opts.selfSignedCA {
+				as.RootRollout = rootRolloutAPI{controller.NewRootRollout(ca, sc, rootRolloutOptions())}
+			}
+		}
+		as.Approvals = approvalQueue
+		if opts.approverTokensFile != "" {
+			as.ApproverTokens = loadApproverTokens(opts.approverTokensFile, token)
+		}
+		go func() {
+			glog.Errorf("The admin API has stopped (error: %v)", as.Run(opts.adminAddress,
+				certmanager.NewServingCertificate(ca.Sign, opts.adminHosts).GetCertificate))
+		}()
+	}
+
+	if opts.csrAddress != "" {
+		authenticators := createAuthenticators(cs.AuthenticationV1beta1().TokenReviews(), csrTrustDomain(sc))
+		for _, a := range authenticators {
+			addKeyCache(as, a)
+		}
+		csrServer := csr.NewServer(ca, authenticators, opts.csrHosts)
+		if opts.csrDelegationPolicy != "" {
+			policy, err := csr.LoadDelegationPolicy(opts.csrDelegationPolicy)
+			if err != nil {
+				glog.Fatalf("Failed to load the delegation policy (error: %v)", err)
+			}
+			csrServer.Delegation = policy
+		}
+		csrServer.RequireNonce = opts.csrRequireNonce
+		csrServer.MaxConcurrentStreams = uint32(opts.csrMaxStreams)
+		csrServer.MaxConnectionsPerPeer = opts.csrMaxPeerConnections
+		if opts.csrMethodPolicy != "" {
+			policy, err := csr.LoadMethodPolicy(opts.csrMethodPolicy)
+			if err != nil {
+				glog.Fatalf("Failed to load the method policy (error: %v)", err)
+			}
+			csrServer.Authorizer = policy
+		}
+		if opts.csrRateLimit > 0 {
+			csrServer.Quota = csr.NewCallerRateLimit(opts.csrRateLimit)
+		}
+		if opts.csrKubernetesTokens {
+			statefulSetNames := csr.NewStatefulSetDNSNames(cs.CoreV1(), opts.clusterDomain)
+			csrServer.DNSNames = statefulSetNames
+			csrServer.PeerDNSNames = statefulSetNames
+			if opts.csrPodIdentity {
+				csrServer.PeerPods = csr.NewPeerPods(cs.CoreV1())
+			}
+		}
+		go func() {
+			glog.Errorf("The CSR API has stopped (error: %v)", csrServer.Run(opts.csrAddress))
+		}()
+		if opts.estAddress != "" {
+			estServer := est.NewServer(csrServer, ca)
+			go func() {
+				glog.Errorf("The EST endpoint has stopped (error: %v)", estServer.Run(opts.estAddress))
+			}()
+		}
+		if opts.tokenExchangeAddress != "" {
+			a := authn.NewOIDCAuthenticator(opts.tokenExchangeOIDCIssuer, opts.tokenExchangeOIDCAudience,
+				opts.tokenExchangeOIDCClaim, loadMapping(opts.tokenExchangeIdentityMapping))
+			addKeyCache(as, a)
+			stsServer := sts.NewServer(ca, a, opts.tokenExchangeCertTTL, csrServer.GetCertificate)
+			go func() {
+				glog.Errorf("The token exchange endpoint has stopped (error: %v)", stsServer.Run(opts.tokenExchangeAddress))
+			}()
+		}
+	}
+
+	if opts.scepAddress != "" {
+		challenges, err := scep.LoadChallengePolicy(opts.scepChallengesFile)
+		if err != nil {
+			glog.Fatalf("Failed to load the SCEP challenge passwords (error: %v)", err)
+		}
+		scepServer := scep.NewServer(ca, challenges, caIdentity())
+		go func() {
+			glog.Errorf("The SCEP endpoint has stopped (error: %v)", scepServer.Run(opts.scepAddress))
+		}()
+	}
+
+	if opts.federationAddress != "" {
+		fc := createFederationController(ca)
+		go func() {
+			glog.Errorf("The federation endpoint has stopped (error: %v)", fc.ListenAndServe(opts.federationAddress))
+		}()
+		go fc.Run(stopCh)
+	}
+
+	if sink := createExportSink(); sink != nil {
+		exporter := export.NewExporter(ca, revocations, sink, opts.exportInterval)
+		exporter.OpenSSLDatabase = opts.exportOpenSSLDB
+		go exporter.Run(stopCh)
+	}
+
+	if store := createReplicationStore(); store != nil {
+		go replication.NewReplicator(ca, store, opts.replicatePrefix, opts.replicateInterval).Run(stopCh)
+	}
+
+	if opts.discoveryAddress != "" {
+		dc := controller.NewDiscoveryController(ca, cs.CoreV1(), controlPlaneNamespace(), opts.discoveryConfigMap,
+			opts.discoveryAddress)
+		go dc.Run(stopCh)
+	}
+
+	if opts.enableServerCerts {
+		scc := controller.NewServerCertController(ca, cs.CoreV1(), cs.ExtensionsV1beta1(), opts.namespace)
+		go scc.Run(stopCh)
+	}
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		glog.Warningf("Received signal %v, shutting down", <-sigCh)
+		close(stopCh)
+	}()
+	go warnings.RunEvery(warningsCheckInterval, stopCh)
+	if opts.canaryInterval > 0 {
+		runCanary(opts.trustDomain, ca, stopCh)
+		for domain, tdca := range trustDomainCAs {
+			runCanary(domain, tdca, stopCh)
+		}
+	}
+	if sc != nil {
+		if opts.mtlsReadinessInterval > 0 {
+			go sc.RunMTLSReadiness(opts.mtlsReadinessInterval, opts.mtlsReadinessMinRemaining, stopCh)
+		}
+		sc.Run(stopCh)
+	} else {
+		glog.Info("Key escrow is disabled; Istio CA only signs certificate signing requests")
+		<-stopCh
+	}
 
-	<-stopCh
 	glog.Warning("Istio CA has stopped")
 }
 
-func createClientset() *kubernetes.Clientset {
-	c := generateConfig()
+// controlPlaneNamespace returns the namespace of the Istio control plane,
+// which is that of Istio CA unless Istio CA listens to all namespaces.
+func controlPlaneNamespace() string {
+	if opts.namespace == "" {
+		return controlPlaneNamespaceDefault
+	}
+	return opts.namespace
+}
+
+// checkPermissions verifies that the CA is granted the API access needed by
+// the enabled controllers. On missing permissions, the error is logged along
+// with the Roles granting them, so that they can be applied as they are.
+// watchNamespaces tells whether the secret controller watches the namespaces
+// for their trust domains or signing profiles.
+func checkPermissions(reviews authorizationv1beta1.SelfSubjectAccessReviewInterface, watchNamespaces bool) error {
+	// The rules needed by namespace, where the empty namespace is cluster-wide.
+	needed := map[string][]rbac.PolicyRule{}
+	if !opts.noKeyEscrow {
+		needed[opts.namespace] = append(needed[opts.namespace], controller.SecretControllerRules()...)
+		if watchNamespaces {
+			needed[""] = append(needed[""], controller.TrustDomainRules()...)
+		}
+		if namespaceQuota() != (certmanager.NamespaceQuota{}) {
+			needed[opts.namespace] = append(needed[opts.namespace], controller.IssuanceQuotaRules()...)
+		}
+		if opts.mtlsReadinessInterval > 0 {
+			needed[""] = append(needed[""], controller.MTLSReadinessRules()...)
+		}
+	}
+	if opts.enableServerCerts {
+		needed[opts.namespace] = append(needed[opts.namespace], controller.ServerCertControllerRules()...)
+	}
+	if opts.bootstrapControlPlane {
+		ns := controlPlaneNamespace()
+		needed[ns] = append(needed[ns], controller.ControlPlaneRules()...)
+	}
+	if opts.csrAddress != "" && opts.csrKubernetesTokens {
+		needed[""] = append(needed[""], authn.KubernetesAuthenticatorRules()...)
+		needed[""] = append(needed[""], csr.StatefulSetDNSNamesRules()...)
+	}
+	if opts.discoveryAddress != "" {
+		ns := controlPlaneNamespace()
+		needed[ns] = append(needed[ns], controller.DiscoveryControllerRules(opts.discoveryConfigMap)...)
+	}
+	if opts.stateStore == stateStoreConfigMap {
+		ns := controlPlaneNamespace()
+		needed[ns] = append(needed[ns], state.ConfigMapStoreRules(opts.stateConfigMap)...)
+	}
+	cfgs := []trustDomainConfig{opts.defaultTrustDomainConfig()}
+	for _, cfg := range opts.trustDomainConfigs {
+		cfgs = append(cfgs, cfg)
+	}
+	for _, cfg := range opts.signingProfileConfigs {
+		cfgs = append(cfgs, cfg.trustDomainConfig)
+	}
+	for _, cfg := range cfgs {
+		if cfg.SigningSecret != "" && !cfg.SelfSigned {
+			ns, name := splitSigningSecret(cfg.SigningSecret)
+			needed[ns] = append(needed[ns], controller.SigningSecretRules(name)...)
+		}
+	}
+
+	var missing []rbac.PolicyRule
+	for ns, rules := range needed {
+		m, err := controller.MissingPermissions(reviews, ns, rules)
+		if err != nil {
+			return err
+		}
+		if len(m) == 0 {
+			continue
+		}
+		missing = append(missing, m...)
+		manifest, err := deploy.RoleManifest("istio-ca", ns, m)
+		if err != nil {
+			return err
+		}
+		glog.Errorf("Istio CA is missing permissions; grant them to its service account with:\n%s", manifest)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing permissions: %s", controller.DescribeRules(missing))
+	}
+	return nil
+}
+
+// runCanary starts the canary issuance of the CA of the trust domain until stopCh is closed.
+func runCanary(trustDomain string, ca *certmanager.IstioCA, stopCh chan struct{}) {
+	id := fmt.Sprintf("spiffe://%s/ns/%s/sa/%s", trustDomain, canaryNamespace, canaryServiceAccount)
+	canary, err := selftest.NewCanary(trustDomain, id, ca.Sign, ca.GetRootCertificate)
+	if err != nil {
+		glog.Fatalf("Failed to create the canary of trust domain %s (error: %v)", trustDomain, err)
+	}
+	go canary.RunEvery(opts.canaryInterval, stopCh)
+}
+
+// runMonitoring serves the monitoring endpoints. The warnings are always
+// reported on '/warnz', and on '/ready' too if '--warnings-fail-readiness' is set.
+func runMonitoring(suite, warnings *selftest.Suite) {
+	mux := http.NewServeMux()
+	if opts.warningsFailReadiness {
+		mux.Handle("/ready", selftest.Handler(suite, warnings))
+	} else {
+		mux.Handle("/ready", suite)
+	}
+	mux.Handle("/warnz", warnings)
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/version", version.Handler())
+	if opts.enableProfiling {
+		glog.Warning("Profiling is enabled; the debug endpoints are served without authentication")
+		registerProfiling(mux)
+	}
+
+	addr := fmt.Sprintf(":%d", opts.monitoringPort)
+	glog.Infof("Serving monitoring endpoints on %s", addr)
+	glog.Errorf("The monitoring server has stopped (error: %v)", http.ListenAndServe(addr, mux))
+}
+
+// registerProfiling adds the runtime debug endpoints to the mux.
+func registerProfiling(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := runtimepprof.Lookup("goroutine").WriteTo(w, 2); err != nil {
+			glog.Errorf("Failed to dump the goroutines (error: %v)", err)
+		}
+	})
+}
+
+// addKeyCache registers the cached keys of the authenticator, if any, as the
+// "jwks" cache of the admin API, unless the admin API is not served.
+func addKeyCache(as *admin.Server, a authn.Authenticator) {
+	if f, ok := a.(authn.KeyFlusher); ok && as != nil {
+		as.AddCache("jwks", func() error {
+			f.FlushKeys()
+			return nil
+		})
+	}
+}
+
+// csrTrustDomain returns the trust domain of the service accounts of a
+// namespace, which the namespace selects like for its Istio secrets. The CSR
+// API signs with the CA of '--trust-domain', so it refuses the service
+// accounts of the other trust domains.
+func csrTrustDomain(sc *controller.SecretController) func(namespace string) (string, error) {
+	return func(namespace string) (string, error) {
+		if sc == nil {
+			return opts.trustDomain, nil
+		}
+		domain, err := sc.TrustDomain(namespace)
+		if err == nil && domain != opts.trustDomain {
+			err = fmt.Errorf("the CSR API does not issue the identities of trust domain %s", domain)
+		}
+		return domain, err
+	}
+}
+
+// createAuthenticators returns the authenticators of the credential types
+// accepted by the CSR API, mapping the Kubernetes service accounts to the
+// trust domains of their namespaces.
+func createAuthenticators(reviews authenticationv1beta1.TokenReviewInterface,
+	trustDomain func(namespace string) (string, error)) map[string]authn.Authenticator {
+
+	authenticators := map[string]authn.Authenticator{}
+	if opts.csrGCPAudience != "" {
+		authenticators[csr.CredentialTypeGCP] = authn.NewGCPAuthenticator(opts.csrGCPAudience,
+			loadMapping(opts.csrGCPIdentityMapping))
+	}
+	if opts.csrAzureTenant != "" {
+		authenticators[csr.CredentialTypeAzure] = authn.NewAzureAuthenticator(opts.csrAzureTenant,
+			opts.csrAzureAudience, loadMapping(opts.csrAzureIdentityMapping))
+	}
+	if opts.csrKubernetesTokens {
+		authenticators[csr.CredentialTypeKubernetes] = authn.NewKubernetesAuthenticator(reviews,
+			opts.csrKubernetesAudience, trustDomain)
+	}
+	if opts.csrOIDCIssuers != "" {
+		issuers, err := authn.LoadOIDCIssuers(opts.csrOIDCIssuers)
+		if err != nil {
+			glog.Fatalf("Failed to load the OIDC issuers (error: %v)", err)
+		}
+		authenticators[csr.CredentialTypeOIDC] = authn.NewOIDCFederation(issuers)
+	}
+	return authenticators
+}
+
+func loadMapping(path string) map[string]string {
+	mapping, err := authn.LoadMapping(path)
+	if err != nil {
+		glog.Fatalf("Failed to load the identity mapping (error: %v)", err)
+	}
+	return mapping
+}
+
+// createFederationController returns the controller federating the trust
+// bundle of the CA with the peers in '--federation-peers'.
+func createFederationController(ca *certmanager.IstioCA) *federation.Controller {
+	peers, err := federation.LoadPeers(opts.federationPeersFile)
+	if err != nil {
+		glog.Fatalf("Failed to load the federation peers (error: %v)", err)
+	}
+	id := opts.federationID
+	if id == "" {
+		id = caIdentity()
+	}
+	fc, err := federation.NewController(ca, id, peers, opts.federationSyncInterval)
+	if err != nil {
+		glog.Fatalf("Failed to create the federation controller (error: %v)", err)
+	}
+	return fc
+}
+
+// caIdentity returns the SPIFFE URI of the service account of Istio CA.
+func caIdentity() string {
+	return fmt.Sprintf("spiffe://%s/ns/%s/sa/istio-ca-service-account", opts.trustDomain, controlPlaneNamespace())
+}
+
+// createExportSink returns the sink of the issuance reports, or nil if the
+// reports are not exported.
+func createExportSink() export.Sink {
+	if opts.exportDir != "" {
+		return export.FileSink{Dir: opts.exportDir}
+	}
+	if opts.exportURL != "" {
+		sink := export.HTTPSink{Client: &http.Client{Timeout: time.Minute}, BaseURL: opts.exportURL}
+		if opts.exportTokenFile != "" {
+			sink.Token = strings.TrimSpace(string(readFile(opts.exportTokenFile)))
+		}
+		return sink
+	}
+	return nil
+}
+
+// createReplicationStore returns the key-value store to replicate the
+// certificates into, or nil if they are not replicated.
+func createReplicationStore() replication.Store {
+	client := &http.Client{Timeout: time.Minute}
+	if opts.replicateConsulAddress != "" {
+		store := replication.ConsulStore{Client: client, Address: opts.replicateConsulAddress}
+		if opts.replicateTokenFile != "" {
+			store.Token = strings.TrimSpace(string(readFile(opts.replicateTokenFile)))
+		}
+		return store
+	}
+	if opts.replicateEtcdAddress != "" {
+		return replication.EtcdStore{Client: client, Address: opts.replicateEtcdAddress}
+	}
+	return nil
+}
+
+// createStateStore returns the store of '--state-store', or nil if the state is only kept in memory.
+func createStateStore(core corev1.CoreV1Interface) certmanager.StateStore {
+	switch opts.stateStore {
+	case stateStoreConfigMap:
+		glog.Infof("Persisting the issuance state in ConfigMap %s/%s", controlPlaneNamespace(), opts.stateConfigMap)
+		return state.NewConfigMapStore(core, controlPlaneNamespace(), opts.stateConfigMap)
+	case stateStoreSQL:
+		db, err := sql.Open(opts.stateSQLDriver, opts.stateSQLDSN)
+		if err != nil {
+			glog.Fatalf("Failed to open the state database (error: %v)", err)
+		}
+		store, err := state.NewSQLStore(db, opts.stateSQLDriver)
+		if err != nil {
+			glog.Fatalf("Failed to initialize the state database (error: %v)", err)
+		}
+		glog.Infof("Persisting the issuance state in the %s database", opts.stateSQLDriver)
+		return store
+	}
+	return nil
+}
+
+// entropyCheck returns a Check that the random sources of the CA deliver
+// within '--entropy-probe-timeout'.
+func entropyCheck() selftest.Check {
+	return selftest.Check{Name: "entropy", Run: func() error {
+		return certmanager.CheckRandomSource(opts.entropyProbeTimeout)
+	}}
+}
+
+// createClockSkewSource returns the NTP server if configured, or otherwise the
+// Kubernetes API server as the reference clock.
+func createClockSkewSource(c *rest.Config) certmanager.ClockSkewSource {
+	if opts.ntpServer != "" {
+		return certmanager.NTPClockSkewSource{Server: opts.ntpServer, Timeout: 5 * time.Second}
+	}
+
+	rt, err := rest.TransportFor(c)
+	if err != nil {
+		glog.Fatalf("Failed to create a transport for the Kubernetes API server (error: %s)", err)
+	}
+	host := c.Host
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	return certmanager.HTTPDateClockSkewSource{
+		Client: &http.Client{Transport: rt, Timeout: 5 * time.Second},
+		URL:    strings.TrimSuffix(host, "/") + "/version",
+	}
+}
+
+func createClientset(c *rest.Config) *kubernetes.Clientset {
 	cs, err := kubernetes.NewForConfig(c)
 	if err != nil {
 		glog.Fatalf("Failed to create a clientset (error: %s)", err)
@@ -130,31 +1474,378 @@ func createClientset() *kubernetes.Clientset {
 	return cs
 }
 
-func createCA() certmanager.CertificateAuthority {
-	if opts.selfSignedCA {
-		glog.Info("Use self-signed certificate as the CA certificate")
+// signatureAlgorithm returns the validated '--signature-algorithm'.
+// namespaceQuota returns the issuance quota of each namespace.
+func namespaceQuota() certmanager.NamespaceQuota {
+	return certmanager.NamespaceQuota{
+		MaxActiveIdentities: opts.namespaceMaxActiveIdentities,
+		MaxIssuancesPerHour: opts.namespaceMaxIssuancesPerHour,
+	}
+}
+
+func signatureAlgorithm() x509.SignatureAlgorithm {
+	algo, err := certmanager.ParseSignatureAlgorithm(opts.signatureAlgorithm)
+	if err != nil {
+		glog.Fatalf("Invalid signature algorithm (error: %v)", err)
+	}
+	return algo
+}
 
-		ca, err := certmanager.NewSelfSignedIstioCA(opts.caCertTTL, opts.certTTL, opts.selfSignedCAOrg)
+// openPIVSigner opens the key of the signing cert in the slot of the PIV
+// token. The token stays open for the lifetime of the process.
+func openPIVSigner(trustDomain, slot string, signingCert []byte) piv.Signer {
+	pivOpts := piv.Options{Card: opts.pivCard, Slot: slot}
+	if opts.pivPINFile != "" {
+		pivOpts.PIN = strings.TrimSpace(string(readFile(opts.pivPINFile)))
+	}
+	signer, err := piv.Open(pivOpts, certmanager.ParsePemEncodedCertificate(signingCert).PublicKey)
+	if err != nil {
+		glog.Fatalf("Failed to open the signing key of trust domain %s (error: %v)", trustDomain, err)
+	}
+	glog.Infof("Use the signing key in PIV slot %s for trust domain %s", slot, trustDomain)
+	return signer
+}
+
+// createWebhookSigner returns the signer of the key of the signing cert behind the webhook.
+func createWebhookSigner(trustDomain, endpoint string, signingCert []byte) *signer.Webhook {
+	var caCert []byte
+	if opts.signingWebhookCACertFile != "" {
+		caCert = readFile(opts.signingWebhookCACertFile)
+	}
+	var token string
+	if opts.signingWebhookTokenFile != "" {
+		token = strings.TrimSpace(string(readFile(opts.signingWebhookTokenFile)))
+	}
+	w, err := signer.NewWebhook(endpoint, certmanager.ParsePemEncodedCertificate(signingCert).PublicKey, caCert, token)
+	if err != nil {
+		glog.Fatalf("Failed to create the signing webhook of trust domain %s (error: %v)", trustDomain, err)
+	}
+	glog.Infof("Use the signing webhook %s for trust domain %s", endpoint, trustDomain)
+	return w
+}
+
+// createIdentityRegistry returns the registry of '--identity-registry-webhook', if set.
+func createIdentityRegistry() certmanager.IdentityRegistry {
+	if opts.identityRegistryWebhook == "" {
+		return nil
+	}
+	var caCert []byte
+	if opts.identityRegistryCACertFile != "" {
+		caCert = readFile(opts.identityRegistryCACertFile)
+	}
+	var token string
+	if opts.identityRegistryTokenFile != "" {
+		token = strings.TrimSpace(string(readFile(opts.identityRegistryTokenFile)))
+	}
+	w, err := registry.NewWebhook(opts.identityRegistryWebhook, caCert, token, opts.identityRegistryCacheTTL)
+	if err != nil {
+		glog.Fatalf("Failed to create the identity registry webhook (error: %v)", err)
+	}
+	glog.Infof("Use the identity registry webhook %s to approve the workloads", opts.identityRegistryWebhook)
+	return w
+}
+
+// loadApproverTokens reads the tokens of the approvers by name from a JSON
+// file. The tokens may be neither empty nor the admin token.
+func loadApproverTokens(path, adminToken string) map[string][]byte {
+	names := map[string]string{}
+	if err := json.Unmarshal(readFile(path), &names); err != nil {
+		glog.Fatalf("Invalid approver tokens in %s (error: %v)", path, err)
+	}
+	tokens := map[string][]byte{}
+	for name, token := range names {
+		if token = strings.TrimSpace(token); token == "" || token == adminToken {
+			glog.Fatalf("The approver token of %s in %s is empty or the admin token", name, path)
+		}
+		tokens[name] = []byte(token)
+	}
+	return tokens
+}
+
+// createApprovalQueue returns the approval queue of '--approval-required-identities', if set.
+func createApprovalQueue() *certmanager.ApprovalQueue {
+	if len(opts.approvalRequiredIdentities) == 0 {
+		return nil
+	}
+	q, err := certmanager.NewApprovalQueue(opts.approvalRequiredIdentities, opts.approvalTimeout)
+	if err != nil {
+		glog.Fatalf("Failed to create the approval queue (error: %v)", err)
+	}
+	glog.Infof("The issuances for %v require manual approval", opts.approvalRequiredIdentities)
+	return q
+}
+
+// trustDomainConfig holds the configurations of the CA of a trust domain.
+type trustDomainConfig struct {
+	SelfSigned bool `json:"selfSigned"`
+
+	// The files holding the signing material if the CA is not self-signed.
+	CertChainFile   string `json:"certChain"`
+	SigningCertFile string `json:"signingCert"`
+	SigningKeyFile  string `json:"signingKey"`
+	RootCertFile    string `json:"rootCert"`
+
+	// The secret holding the signing material instead of the files, as '<namespace>/<name>'.
+	SigningSecret string `json:"signingSecret"`
+
+	// The slot of the PIV token holding the signing key instead of SigningKeyFile.
+	SigningKeyPIVSlot string `json:"signingKeyPIVSlot"`
+
+	// The URL of the signing webhook holding the signing key instead of SigningKeyFile.
+	SigningWebhook string `json:"signingWebhook"`
+
+	// The URL of the ADCS CA enrolling the signing material instead of the files, except RootCertFile.
+	ADCSURL string `json:"adcsURL"`
+}
+
+// signingProfileConfig holds the configurations of the CA of a signing
+// profile of the default trust domain.
+type signingProfileConfig struct {
+	trustDomainConfig
+
+	// The namespaces allowed to select the profile.
+	Namespaces []string `json:"namespaces"`
+}
+
+// identityRegistry approves the identities before the CAs issue their
+// certificates; nil without '--identity-registry-webhook'.
+var identityRegistry certmanager.IdentityRegistry
+
+// approvalQueue parks the issuances for the sensitive identities until they
+// are approved; nil without '--approval-required-identities'.
+var approvalQueue *certmanager.ApprovalQueue
+
+// signingSecretControllers keep the CAs loaded from signing secrets up to date with the secrets.
+var signingSecretControllers []*controller.SigningSecretController
+
+// adcsRenewals renew the signing material of the CAs enrolled with ADCS until stopCh is closed.
+var adcsRenewals []func(stopCh <-chan struct{})
+
+// splitSigningSecret returns the namespace and the name of a validated signing secret.
+func splitSigningSecret(s string) (namespace, name string) {
+	parts := strings.SplitN(s, "/", 2)
+	return parts[0], parts[1]
+}
+
+func createCA(trustDomain string, cfg trustDomainConfig, store certmanager.IssuanceTimeStore,
+	stateStore certmanager.StateStore, skew *certmanager.ClockSkewMonitor,
+	core corev1.CoreV1Interface) *certmanager.IstioCA {
+
+	if cfg.SelfSigned {
+		glog.Infof("Use self-signed certificate as the CA certificate of trust domain %s", trustDomain)
+
+		caOpts := &certmanager.SelfSignedIstioCAOptions{
+			CACertTTL: opts.caCertTTL,
+			CertTTL:   opts.certTTL,
+			Subject: pkix.Name{
+				CommonName:         opts.selfSignedCACommonName,
+				Country:            opts.selfSignedCACountries,
+				Organization:       opts.selfSignedCAOrgs,
+				OrganizationalUnit: opts.selfSignedCAOrgUnits,
+			},
+			MaxPathLen:        opts.selfSignedCAMaxPathLen,
+			NameConstrained:   opts.selfSignedCANameConstrained,
+			IssuanceTimeStore: store,
+			StateStore:        stateStore,
+			ClockSkew:         skew,
+			MaxClockSkew:      opts.maxClockSkew,
+			NoKeyEscrow:       opts.noKeyEscrow,
+			NamespaceCATTL:    opts.namespaceCATTL,
+			NamespaceQuota:    namespaceQuota(),
+			IdentityRegistry:  identityRegistry,
+			ApprovalQueue:     approvalQueue,
+			TrustDomain:       trustDomain,
+			MinCertTTL:        opts.minCertTTL,
+
+			SignatureAlgorithm: signatureAlgorithm(),
+			InjectedErrorRate:  opts.injectSignErrors,
+		}
+		ca, err := certmanager.NewSelfSignedIstioCA(caOpts)
 		if err != nil {
-			glog.Fatalf("Failed to create a self-signed Istio CA (error: %v)", err)
+			glog.Fatalf("Failed to create a self-signed Istio CA for trust domain %s (error: %v)", trustDomain, err)
 		}
+		lintIssuedCertificates(ca)
 		return ca
 	}
 
+	var material *controller.SigningMaterial
+	var enroller *adcs.Enroller
+	var enrolled *adcs.Material
+	if cfg.ADCSURL != "" {
+		enroller = createADCSEnroller(trustDomain, cfg)
+		var err error
+		if enrolled, err = enroller.Enroll(); err != nil {
+			glog.Fatalf("Failed to enroll the signing material of trust domain %s with ADCS (error: %v)",
+				trustDomain, err)
+		}
+		material = &controller.SigningMaterial{
+			CertChain:   enrolled.CertChain,
+			SigningCert: enrolled.SigningCert,
+			SigningKey:  enrolled.SigningKey,
+			RootCert:    enrolled.RootCert,
+		}
+	} else if cfg.SigningSecret != "" {
+		ns, name := splitSigningSecret(cfg.SigningSecret)
+		var err error
+		if material, err = controller.LoadSigningMaterial(core, ns, name); err != nil {
+			glog.Fatalf("Failed to load the signing material of trust domain %s (error: %v)", trustDomain, err)
+		}
+	} else {
+		material = &controller.SigningMaterial{
+			CertChain:   readFile(cfg.CertChainFile),
+			SigningCert: readFile(cfg.SigningCertFile),
+			RootCert:    readFile(cfg.RootCertFile),
+		}
+		if cfg.SigningKeyPIVSlot == "" && cfg.SigningWebhook == "" {
+			material.SigningKey = readFile(cfg.SigningKeyFile)
+		}
+	}
+
 	caOpts := &certmanager.IstioCAOptions{
-		CertChainBytes:   readFile(opts.certChainFile),
+		CertChainBytes:   material.CertChain,
 		CertTTL:          opts.certTTL,
-		SigningCertBytes: readFile(opts.signingCertFile),
-		SigningKeyBytes:  readFile(opts.signingKeyFile),
-		RootCertBytes:    readFile(opts.rootCertFile),
+		SigningCertBytes: material.SigningCert,
+		SigningKeyBytes:  material.SigningKey,
+		RootCertBytes:    material.RootCert,
+
+		IssuanceTimeStore: store,
+		StateStore:        stateStore,
+		ClockSkew:         skew,
+		MaxClockSkew:      opts.maxClockSkew,
+		NoKeyEscrow:       opts.noKeyEscrow,
+		NamespaceCATTL:    opts.namespaceCATTL,
+		NamespaceQuota:    namespaceQuota(),
+		IdentityRegistry:  identityRegistry,
+		ApprovalQueue:     approvalQueue,
+		TrustDomain:       trustDomain,
+		MinCertTTL:        opts.minCertTTL,
+
+		SignatureAlgorithm: signatureAlgorithm(),
+		InjectedErrorRate:  opts.injectSignErrors,
+	}
+	if cfg.SigningKeyPIVSlot != "" {
+		caOpts.Signer = openPIVSigner(trustDomain, cfg.SigningKeyPIVSlot, material.SigningCert)
+	}
+	if cfg.SigningWebhook != "" {
+		caOpts.Signer = createWebhookSigner(trustDomain, cfg.SigningWebhook, material.SigningCert)
 	}
 	ca, err := certmanager.NewIstioCA(caOpts)
 	if err != nil {
-		glog.Errorf("Failed to create an Istio CA (error %v)", err)
+		glog.Fatalf("Failed to create an Istio CA for trust domain %s (error: %v)", trustDomain, err)
+	}
+	lintCACertificates(trustDomain, material)
+	lintIssuedCertificates(ca)
+	if cfg.SigningSecret != "" {
+		ns, name := splitSigningSecret(cfg.SigningSecret)
+		signingSecretControllers = append(signingSecretControllers,
+			controller.NewSigningSecretController(ca, core, ns, name, material))
+	}
+	if enroller != nil {
+		adcsRenewals = append(adcsRenewals, func(stopCh <-chan struct{}) {
+			enroller.Run(ca, enrolled, stopCh)
+		})
 	}
 	return ca
 }
 
+// createADCSEnroller returns the enroller of the signing material of the
+// trust domain with the ADCS CA.
+func createADCSEnroller(trustDomain string, cfg trustDomainConfig) *adcs.Enroller {
+	var caCert []byte
+	if opts.adcsCACertFile != "" {
+		caCert = readFile(opts.adcsCACertFile)
+	}
+	var password string
+	if opts.adcsPasswordFile != "" {
+		password = strings.TrimSpace(string(readFile(opts.adcsPasswordFile)))
+	}
+	client, err := adcs.NewClient(cfg.ADCSURL, opts.adcsTemplate, opts.adcsUsername, password, caCert)
+	if err != nil {
+		glog.Fatalf("Failed to create the ADCS client of trust domain %s (error: %v)", trustDomain, err)
+	}
+	glog.Infof("Enroll the signing certificate of trust domain %s with ADCS %s (template: %s)",
+		trustDomain, cfg.ADCSURL, opts.adcsTemplate)
+	return adcs.NewEnroller(client, pkix.Name{CommonName: opts.adcsCommonName}, readFile(cfg.RootCertFile))
+}
+
+// lintCACertificates checks the operator-supplied signing certificate, chain
+// and root certificate of the trust domain if '--pki-lint' is enabled.
+func lintCACertificates(trustDomain string, material *controller.SigningMaterial) {
+	if opts.pkiLint == pkiLintOff {
+		return
+	}
+
+	var certs []*x509.Certificate
+	for _, bs := range [][]byte{material.SigningCert, material.CertChain, material.RootCert} {
+		chain, err := parse.CertificateChain(bs)
+		if err != nil {
+			// NewIstioCA already refuses malformed certificates.
+			continue
+		}
+		certs = append(certs, chain...)
+	}
+
+	linted := map[string]bool{}
+	violations := 0
+	for _, cert := range certs {
+		if linted[string(cert.Raw)] {
+			continue
+		}
+		linted[string(cert.Raw)] = true
+		violations += len(lint.Report(lint.KindCA, cert))
+	}
+	if violations > 0 && opts.pkiLintStrict {
+		glog.Fatalf("The CA certificates of trust domain %s have %d lint violations", trustDomain, violations)
+	}
+}
+
+// lintIssuedCertificates checks every certificate the CA issues if
+// '--pki-lint' is set to 'all'.
+func lintIssuedCertificates(ca *certmanager.IstioCA) {
+	if opts.pkiLint != pkiLintAll {
+		return
+	}
+	ca.AddIssuanceListener(func(cert *x509.Certificate) {
+		lint.Report(lint.KindIssued, cert)
+	})
+}
+
+// createTrustDomainCAs returns the CAs of the additional trust domains in
+// '--trust-domains-config', which holds a JSON object from trust domains to
+// their configurations. Their latest issuance times are only kept in memory.
+func createTrustDomainCAs(skew *certmanager.ClockSkewMonitor,
+	core corev1.CoreV1Interface) map[string]*certmanager.IstioCA {
+
+	cas := map[string]*certmanager.IstioCA{}
+	for domain, cfg := range opts.trustDomainConfigs {
+		cas[domain] = createCA(domain, cfg, nil, nil, skew, core)
+	}
+	return cas
+}
+
+// createSigningProfileCAs returns the CAs of the signing profiles in
+// '--signing-profiles-config', which issue the identities of the default trust
+// domain. Their latest issuance times are only kept in memory.
+func createSigningProfileCAs(skew *certmanager.ClockSkewMonitor,
+	core corev1.CoreV1Interface) map[string]*certmanager.IstioCA {
+
+	cas := map[string]*certmanager.IstioCA{}
+	for name, cfg := range opts.signingProfileConfigs {
+		glog.Infof("Use signing profile %s for namespaces %v", name, cfg.Namespaces)
+		cas[name] = createCA(opts.trustDomain, cfg.trustDomainConfig, nil, nil, skew, core)
+	}
+	return cas
+}
+
+// effectiveConfig returns the values of all command line flags, keyed by flag name.
+func effectiveConfig(flags *pflag.FlagSet) map[string]string {
+	config := map[string]string{}
+	flags.VisitAll(func(f *pflag.Flag) {
+		config[f.Name] = f.Value.String()
+	})
+	return config
+}
+
 func generateConfig() *rest.Config {
 	if opts.kubeConfigFile != "" {
 		c, err := clientcmd.BuildConfigFromFlags("", opts.kubeConfigFile)
@@ -180,32 +1871,26 @@ func readFile(filename string) []byte {
 	return bs
 }
 
-func verifyCommandLineOptions() {
-	if opts.selfSignedCA {
-		return
+func rootRolloutOptions() controller.RootRolloutOptions {
+	ro := controller.RootRolloutOptions{
+		Canaries: opts.rootRolloutCanaries,
+		Interval: opts.rootRolloutInterval,
 	}
-
-	if opts.certChainFile == "" {
-		glog.Fatalf(
-			"No certificate chain has been specified. Either specify a cert chain file via '-cert-chain' option " +
-				"or use '-self-signed-ca'")
-	}
-
-	if opts.signingCertFile == "" {
-		glog.Fatalf(
-			"No signing cert has been specified. Either specify a cert file via '-signing-cert' option " +
-				"or use '-self-signed-ca'")
+	if opts.rootRolloutHealthMetric != "" {
+		ro.Gate = &controller.MetricGate{
+			Gatherer:    prometheus.DefaultGatherer,
+			Metric:      opts.rootRolloutHealthMetric,
+			MaxIncrease: opts.rootRolloutMaxMetricIncrease,
+		}
 	}
+	return ro
+}
 
-	if opts.signingKeyFile == "" {
-		glog.Fatalf(
-			"No signing key has been specified. Either specify a key file via '-signing-key' option " +
-				"or use '-self-signed-ca'")
-	}
+// rootRolloutAPI serves a controller.RootRollout on the admin API.
+type rootRolloutAPI struct {
+	*controller.RootRollout
+}
 
-	if opts.rootCertFile == "" {
-		glog.Fatalf(
-			"No root cert has been specified. Either specify a root cert file via '-root-cert' option " +
-				"or use '-self-signed-ca'")
-	}
+func (r rootRolloutAPI) Status() admin.RootRolloutStatus {
+	return admin.RootRolloutStatus(r.RootRollout.Status())
 }
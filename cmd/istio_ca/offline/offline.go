@@ -0,0 +1,218 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package offline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/auth/certmanager"
+	"istio.io/auth/cmd/istio_ca/kubeclient"
+	"istio.io/auth/controller"
+	"istio.io/auth/parse"
+)
+
+type options struct {
+	kubeConfigFile string
+	namespace      string
+	trustDomain    string
+	renewWithin    time.Duration
+
+	certChainFile   string
+	signingCertFile string
+	signingKeyFile  string
+	rootCertFile    string
+	certTTL         time.Duration
+}
+
+var (
+	opts options
+
+	// this is used for testing command output
+	printFunc = fmt.Printf
+
+	// Command issues Istio certificates with a CA whose signing key is never online.
+	Command = &cobra.Command{
+		Use:   "offline",
+		Short: "Issue Istio certificates with an offline CA",
+		Long: "Exports the certificate signing requests of the service accounts whose Istio secrets are missing or " +
+			"expiring, signs them on an offline machine holding the signing key, and imports the certificates " +
+			"into the Istio secrets. The private keys never leave the cluster; the signing key never enters it.",
+	}
+
+	exportCommand = &cobra.Command{
+		Use:   "export-csrs",
+		Short: "Write the pending certificate signing requests of the cluster to stdout",
+		RunE: func(*cobra.Command, []string) error {
+			core, err := kubeclient.NewCoreClient(opts.kubeConfigFile)
+			if err != nil {
+				return err
+			}
+			requests, err := controller.ExportCSRs(core, opts.namespace, opts.trustDomain, opts.renewWithin)
+			if err != nil {
+				return err
+			}
+			return printJSON(requests)
+		},
+	}
+
+	signCommand = &cobra.Command{
+		Use:   "sign <requests-file>",
+		Short: "Sign the exported certificate signing requests and write the certificates to stdout",
+		RunE: func(_ *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("exactly one file of certificate signing requests must be specified")
+			}
+			var requests []controller.OfflineRequest
+			if err := readJSON(args[0], &requests); err != nil {
+				return err
+			}
+			ca, err := newCA()
+			if err != nil {
+				return err
+			}
+			certs, err := signRequests(ca, opts.trustDomain, requests)
+			if err != nil {
+				return err
+			}
+			return printJSON(certs)
+		},
+	}
+
+	importCommand = &cobra.Command{
+		Use:   "import-certs <certs-file>",
+		Short: "Write the signed certificates into the Istio secrets of the cluster",
+		RunE: func(_ *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("exactly one file of certificates must be specified")
+			}
+			var certs []controller.OfflineCertificate
+			if err := readJSON(args[0], &certs); err != nil {
+				return err
+			}
+			core, err := kubeclient.NewCoreClient(opts.kubeConfigFile)
+			if err != nil {
+				return err
+			}
+			n, err := controller.ImportCertificates(core, certs)
+			// nolint: errcheck,gas
+			printFunc("Imported %d of %d certificates\n", n, len(certs))
+			return err
+		},
+	}
+)
+
+func init() {
+	flags := Command.PersistentFlags()
+	flags.StringVar(&opts.trustDomain, "trust-domain", "cluster.local", "The trust domain of the Istio identities")
+
+	for _, c := range []*cobra.Command{exportCommand, importCommand} {
+		c.Flags().StringVar(&opts.kubeConfigFile, "kube-config", "",
+			"Specifies path to kubeconfig file. If unspecified, the in-cluster configuration is used.")
+	}
+	exportCommand.Flags().StringVar(&opts.namespace, "namespace", "",
+		"The namespace of the service accounts. If unspecified, all namespaces are exported.")
+	exportCommand.Flags().DurationVar(&opts.renewWithin, "renew-within", 24*time.Hour,
+		"Export the service accounts whose certificates expire within this duration")
+
+	signFlags := signCommand.Flags()
+	signFlags.StringVar(&opts.certChainFile, "cert-chain", "", "Specifies path to the certificate chain file")
+	signFlags.StringVar(&opts.signingCertFile, "signing-cert", "", "Specifies path to the CA signing certificate file")
+	signFlags.StringVar(&opts.signingKeyFile, "signing-key", "", "Specifies path to the CA signing key file")
+	signFlags.StringVar(&opts.rootCertFile, "root-cert", "", "Specifies path to the root certificate file")
+	signFlags.DurationVar(&opts.certTTL, "cert-ttl", 30*24*time.Hour,
+		"The TTL of issued certificates (default to 30 days, as they are only renewed by another export)")
+
+	Command.AddCommand(exportCommand)
+	Command.AddCommand(signCommand)
+	Command.AddCommand(importCommand)
+}
+
+// signRequests signs the requests, each of which must only request the SPIFFE
+// identity of its service account in the trust domain.
+func signRequests(ca *certmanager.IstioCA, trustDomain string,
+	requests []controller.OfflineRequest) ([]controller.OfflineCertificate, error) {
+
+	certs := []controller.OfflineCertificate{}
+	for _, r := range requests {
+		id := fmt.Sprintf("spiffe://%s/ns/%s/sa/%s", trustDomain, r.Namespace, r.ServiceAccount)
+		csr, err := parse.CSR([]byte(r.CSR))
+		if err != nil {
+			return nil, fmt.Errorf("the request of %s/%s is malformed (error: %v)", r.Namespace, r.ServiceAccount, err)
+		}
+		if len(csr.URIs) != 1 || csr.URIs[0].String() != id || len(csr.DNSNames) > 0 || len(csr.IPAddresses) > 0 {
+			return nil, fmt.Errorf("the request of %s/%s is not for %s alone", r.Namespace, r.ServiceAccount, id)
+		}
+
+		chain, err := ca.Sign([]byte(r.CSR))
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign the request of %s/%s (error: %v)", r.Namespace, r.ServiceAccount, err)
+		}
+		certs = append(certs, controller.OfflineCertificate{
+			Namespace:      r.Namespace,
+			ServiceAccount: r.ServiceAccount,
+			CertChain:      string(chain),
+			RootCert:       string(ca.GetRootCertificate()),
+		})
+	}
+	return certs, nil
+}
+
+func newCA() (*certmanager.IstioCA, error) {
+	caOpts := &certmanager.IstioCAOptions{
+		CertTTL:     opts.certTTL,
+		TrustDomain: opts.trustDomain,
+		NoKeyEscrow: true,
+	}
+	for _, f := range []struct {
+		name string
+		dst  *[]byte
+	}{
+		{opts.certChainFile, &caOpts.CertChainBytes},
+		{opts.signingCertFile, &caOpts.SigningCertBytes},
+		{opts.signingKeyFile, &caOpts.SigningKeyBytes},
+		{opts.rootCertFile, &caOpts.RootCertBytes},
+	} {
+		data, err := ioutil.ReadFile(f.name)
+		if err != nil {
+			return nil, err
+		}
+		*f.dst = data
+	}
+	return certmanager.NewIstioCA(caOpts)
+}
+
+func readJSON(file string, v interface{}) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	// nolint: errcheck,gas
+	printFunc("%s\n", data)
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package offline
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager"
+	"istio.io/auth/controller"
+)
+
+func createCSR(t *testing.T, id string, dnsNames ...string) string {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri, err := url.Parse(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.CertificateRequest{URIs: []*url.URL{uri}, DNSNames: dnsNames}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+func TestSignRequests(t *testing.T) {
+	ca, err := certmanager.NewSelfSignedIstioCA(&certmanager.SelfSignedIstioCAOptions{
+		CACertTTL:  time.Hour,
+		CertTTL:    30 * time.Minute,
+		Subject:    pkix.Name{Organization: []string{"test.ca.org"}},
+		MaxPathLen: -1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+
+	testCases := map[string]struct {
+		csr         string
+		expectedErr string
+	}{
+		"Valid": {
+			csr: createCSR(t, "spiffe://cluster.local/ns/default/sa/foo"),
+		},
+		"Another identity": {
+			csr:         createCSR(t, "spiffe://cluster.local/ns/default/sa/bar"),
+			expectedErr: "not for spiffe://cluster.local/ns/default/sa/foo alone",
+		},
+		"Another trust domain": {
+			csr:         createCSR(t, "spiffe://example.com/ns/default/sa/foo"),
+			expectedErr: "not for spiffe://cluster.local/ns/default/sa/foo alone",
+		},
+		"Extra DNS names": {
+			csr:         createCSR(t, "spiffe://cluster.local/ns/default/sa/foo", "foo.example.com"),
+			expectedErr: "not for spiffe://cluster.local/ns/default/sa/foo alone",
+		},
+		"Malformed": {
+			csr:         "not a csr",
+			expectedErr: "is malformed",
+		},
+	}
+	for id, tc := range testCases {
+		requests := []controller.OfflineRequest{{Namespace: "default", ServiceAccount: "foo", CSR: tc.csr}}
+		certs, err := signRequests(ca, "cluster.local", requests)
+		if tc.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: expected error %q, got %v", id, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+		if len(certs) != 1 || certs[0].ServiceAccount != "foo" || certs[0].RootCert != string(ca.GetRootCertificate()) {
+			t.Errorf("%s: unexpected certificates: %v", id, certs)
+		}
+	}
+}
@@ -0,0 +1,394 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"istio.io/auth/certmanager"
+	"istio.io/auth/controller"
+	"istio.io/auth/piv"
+	"istio.io/auth/state"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// optionsValidator collects the problems of the command line options, so that
+// all of them are reported together rather than one per attempt to start.
+type optionsValidator struct {
+	errs []error
+}
+
+// check records the error described by the format unless ok is true.
+func (v *optionsValidator) check(ok bool, format string, args ...interface{}) {
+	if !ok {
+		v.errs = append(v.errs, fmt.Errorf(format, args...))
+	}
+}
+
+// exclusive records an error if more than one of the named flags is set.
+func (v *optionsValidator) exclusive(set map[string]bool) {
+	names := []string{}
+	for name, isSet := range set {
+		if isSet {
+			names = append(names, "'--"+name+"'")
+		}
+	}
+	if len(names) > 1 {
+		sort.Strings(names)
+		v.errs = append(v.errs, fmt.Errorf("%s are mutually exclusive", strings.Join(names, ", ")))
+	}
+}
+
+// validate checks all the command line options together, and returns an
+// aggregate of all the problems found, or nil if there is none. It also parses
+// '--trust-domains-config' into trustDomainConfigs, '--signing-profiles-config'
+// into signingProfileConfigs and '--secret-data-key' into secretDataKeyAliases.
+func (o *cliOptions) validate() error {
+	v := &optionsValidator{}
+
+	v.exclusive(map[string]bool{"export-dir": o.exportDir != "", "export-url": o.exportURL != ""})
+	v.exclusive(map[string]bool{
+		"replicate-consul-address": o.replicateConsulAddress != "",
+		"replicate-etcd-address":   o.replicateEtcdAddress != "",
+	})
+	v.exclusive(map[string]bool{"no-key-escrow": o.noKeyEscrow, "enable-server-certs": o.enableServerCerts})
+	v.check(!o.noKeyEscrow || !o.bootstrapControlPlane,
+		"'--bootstrap-control-plane' provisions private keys, which '--no-key-escrow' disallows")
+	v.check(!o.noKeyEscrow || !o.migrateOnly,
+		"'--migrate-only' requires the Istio secrets, which '--no-key-escrow' disables")
+	v.check(!o.noKeyEscrow || o.mtlsReadinessInterval == 0,
+		"'--mtls-readiness-interval' requires the Istio secrets, which '--no-key-escrow' disables")
+	v.check(o.adminAddress == "" || o.adminTokenFile != "",
+		"'--admin-address' requires an admin token file via '--admin-token-file'")
+	v.check(o.adminAddress == "" || len(o.adminHosts) > 0,
+		"'--admin-address' requires the hosts of its TLS certificate via '--admin-hosts'")
+	v.check(o.csrAddress == "" || len(o.csrHosts) > 0,
+		"'--csr-address' requires the hosts of its TLS certificate via '--csr-hosts'")
+	v.check(o.csrAddress == "" || o.csrGCPAudience != "" || o.csrAzureTenant != "" || o.csrKubernetesTokens ||
+		o.csrOIDCIssuers != "",
+		"'--csr-address' requires '--csr-gcp-audience', '--csr-azure-tenant', '--csr-kubernetes-tokens' or "+
+			"'--csr-oidc-issuers' to authenticate the callers")
+	v.check(o.csrAddress != "" || !o.csrKubernetesTokens, "'--csr-kubernetes-tokens' requires '--csr-address'")
+	v.check(o.csrKubernetesTokens || o.csrKubernetesAudience == "",
+		"'--csr-kubernetes-audience' requires '--csr-kubernetes-tokens'")
+	v.check(o.csrKubernetesTokens || !o.csrPodIdentity, "'--csr-pod-identity' requires '--csr-kubernetes-tokens'")
+	v.check(o.csrAddress != "" || o.csrOIDCIssuers == "", "'--csr-oidc-issuers' requires '--csr-address'")
+	v.check(o.csrAddress != "" || o.csrDelegationPolicy == "", "'--csr-delegation-policy' requires '--csr-address'")
+	v.check(o.csrAddress != "" || o.csrMethodPolicy == "", "'--csr-method-policy' requires '--csr-address'")
+	v.check(o.csrAddress != "" || o.csrRateLimit == 0, "'--csr-rate-limit' requires '--csr-address'")
+	v.check(o.csrRateLimit >= 0, "'--csr-rate-limit' must not be negative")
+	v.check(o.csrMaxStreams > 0, "'--csr-max-concurrent-streams' must be positive")
+	v.check(o.csrMaxPeerConnections > 0, "'--csr-max-connections-per-peer' must be positive")
+	v.check(o.csrAddress != "" || o.estAddress == "", "'--est-address' requires '--csr-address'")
+	v.check(o.csrAddress != "" || o.tokenExchangeAddress == "", "'--token-exchange-address' requires '--csr-address'")
+	v.check(o.tokenExchangeAddress == "" ||
+		(o.tokenExchangeOIDCIssuer != "" && o.tokenExchangeOIDCAudience != "" && o.tokenExchangeIdentityMapping != ""),
+		"'--token-exchange-address' requires '--token-exchange-oidc-issuer', '--token-exchange-oidc-audience' "+
+			"and '--token-exchange-identity-mapping'")
+	v.check(o.tokenExchangeOIDCIssuer == "" || strings.HasPrefix(o.tokenExchangeOIDCIssuer, "https://"),
+		"invalid '--token-exchange-oidc-issuer' %q: expect an https:// URL", o.tokenExchangeOIDCIssuer)
+	v.check(o.tokenExchangeAddress == "" ||
+		(o.tokenExchangeCertTTL >= o.minCertTTL && o.tokenExchangeCertTTL <= o.certTTL),
+		"'--token-exchange-cert-ttl' %v must be between '--min-cert-ttl' %v and '--cert-ttl' %v",
+		o.tokenExchangeCertTTL, o.minCertTTL, o.certTTL)
+	v.check((o.scepAddress == "") == (o.scepChallengesFile == ""),
+		"'--scep-address' and '--scep-challenges' must be specified together")
+	v.check(o.csrGCPAudience == "" || o.csrGCPIdentityMapping != "",
+		"'--csr-gcp-audience' requires '--csr-gcp-identity-mapping'")
+	v.check(o.csrAzureTenant == "" || (o.csrAzureAudience != "" && o.csrAzureIdentityMapping != ""),
+		"'--csr-azure-tenant' requires '--csr-azure-audience' and '--csr-azure-identity-mapping'")
+	v.check((o.federationAddress == "") == (o.federationPeersFile == ""),
+		"'--federation-address' and '--federation-peers' must be specified together")
+	v.check(o.federationID == "" || strings.HasPrefix(o.federationID, "spiffe://"),
+		"invalid '--federation-id' %q: specify a SPIFFE URI", o.federationID)
+	v.check(!o.exportOpenSSLDB || o.exportDir != "" || o.exportURL != "",
+		"'--export-openssl-db' requires '--export-dir' or '--export-url'")
+	v.check(o.replicateTokenFile == "" || o.replicateConsulAddress != "",
+		"'--replicate-token-file' requires '--replicate-consul-address'")
+	v.check(o.stateStore == stateStoreMemory || o.stateStore == stateStoreConfigMap || o.stateStore == stateStoreSQL,
+		"invalid '--state-store' %q: specify either '%s' or '%s'", o.stateStore, stateStoreConfigMap, stateStoreSQL)
+	v.check(o.stateStore != stateStoreSQL || (o.stateSQLDriver != "" && o.stateSQLDSN != ""),
+		"'--state-store=%s' requires '--state-sql-driver' and '--state-sql-dsn'", stateStoreSQL)
+	v.check(o.stateStore == stateStoreSQL || (o.stateSQLDriver == "" && o.stateSQLDSN == ""),
+		"'--state-sql-driver' and '--state-sql-dsn' require '--state-store=%s'", stateStoreSQL)
+	v.check(o.stateSQLDriver == "" || sets.NewString(state.SQLDialects...).Has(o.stateSQLDriver),
+		"invalid '--state-sql-driver' %q: specify one of %s", o.stateSQLDriver, strings.Join(state.SQLDialects, ", "))
+	v.check(o.stateStore == stateStoreMemory || o.issuanceTimeFile == "",
+		"'--issuance-time-file' is redundant with '--state-store', which persists the latest issuance time")
+	v.check(o.stateSyncInterval > 0, "'--state-sync-interval' must be positive")
+
+	v.check(o.identityRegistryWebhook == "" || strings.HasPrefix(o.identityRegistryWebhook, "https://"),
+		"invalid '--identity-registry-webhook' %q: expect an https:// URL", o.identityRegistryWebhook)
+	v.check(o.identityRegistryCacheTTL >= 0, "'--identity-registry-cache-ttl' must not be negative")
+	v.check(len(o.approvalRequiredIdentities) == 0 || o.adminAddress != "",
+		"'--approval-required-identities' requires '--admin-address' to approve the issuances")
+	v.check(len(o.approvalRequiredIdentities) == 0 || o.approvalTimeout > 0, "'--approval-timeout' must be positive")
+	v.check(o.approverTokensFile == "" || len(o.approvalRequiredIdentities) > 0,
+		"'--approver-tokens-file' requires '--approval-required-identities'")
+
+	v.check(o.keyRotationPolicy == keyRotationPolicyRekey || o.keyRotationPolicy == keyRotationPolicyReuse,
+		"invalid '--key-rotation-policy' %q: specify either '%s' or '%s'",
+		o.keyRotationPolicy, keyRotationPolicyRekey, keyRotationPolicyReuse)
+	v.check(o.maxKeyAge >= 0, "'--max-key-age' must not be negative")
+	v.check(o.maxKeyAge == 0 || o.keyRotationPolicy == keyRotationPolicyReuse,
+		"'--max-key-age' requires '--key-rotation-policy=%s'", keyRotationPolicyReuse)
+	v.check(o.secretEncryptionKMSKey == "" || strings.HasPrefix(o.secretEncryptionKMSKey, "projects/"),
+		"invalid '--secret-encryption-kms-key' %q: specify the resource name of a Cloud KMS key",
+		o.secretEncryptionKMSKey)
+
+	v.check(o.minCertTTL > 0, "'--min-cert-ttl' must be positive")
+	v.check(o.certTTL >= o.minCertTTL,
+		"'--cert-ttl' (%v) must not be shorter than '--min-cert-ttl' (%v)", o.certTTL, o.minCertTTL)
+	v.check(!o.selfSignedCA || o.certTTL < o.caCertTTL,
+		"'--cert-ttl' (%v) must be shorter than '--ca-cert-ttl' (%v)", o.certTTL, o.caCertTTL)
+	v.check(o.namespaceCATTL == 0 || o.certTTL < o.namespaceCATTL,
+		"'--cert-ttl' (%v) must be shorter than '--namespace-ca-ttl' (%v)", o.certTTL, o.namespaceCATTL)
+	v.check(!o.selfSignedCA || o.namespaceCATTL <= o.caCertTTL,
+		"'--namespace-ca-ttl' (%v) must not exceed '--ca-cert-ttl' (%v)", o.namespaceCATTL, o.caCertTTL)
+	v.check(o.namespaceMaxActiveIdentities >= 0, "'--namespace-max-active-identities' must not be negative")
+	v.check(o.namespaceMaxIssuancesPerHour >= 0, "'--namespace-max-issuances-per-hour' must not be negative")
+	if _, err := certmanager.ParseSignatureAlgorithm(o.signatureAlgorithm); err != nil {
+		v.check(false, "invalid '--signature-algorithm': %v", err)
+	}
+	v.check(o.pkiLint == pkiLintOff || o.pkiLint == pkiLintCA || o.pkiLint == pkiLintAll,
+		"invalid '--pki-lint' %q: specify '%s', '%s' or '%s'", o.pkiLint, pkiLintOff, pkiLintCA, pkiLintAll)
+	v.check(!o.pkiLintStrict || o.pkiLint != pkiLintOff, "'--pki-lint-strict' requires '--pki-lint'")
+	v.check(o.clockSkewCheckInterval > 0, "'--clock-skew-check-interval' must be positive")
+	v.check(o.maxClockSkew >= 0, "'--max-clock-skew' must not be negative")
+	v.check(o.exportInterval > 0 || (o.exportDir == "" && o.exportURL == ""),
+		"'--export-interval' must be positive")
+	v.check(o.replicateInterval > 0 || (o.replicateConsulAddress == "" && o.replicateEtcdAddress == ""),
+		"'--replicate-interval' must be positive")
+	v.check(o.monitoringPort > 0 && o.monitoringPort < 1<<16, "invalid '--monitoring-port' %d", o.monitoringPort)
+	v.check(o.maxExpiringCertFraction >= 0 && o.maxExpiringCertFraction <= 1,
+		"'--max-expiring-cert-fraction' (%v) must be between 0 and 1", o.maxExpiringCertFraction)
+	v.check(o.expiringCertWindow > 0 || o.maxExpiringCertFraction == 0, "'--expiring-cert-window' must be positive")
+	v.check(o.minRootCertLifetime >= 0, "'--min-root-cert-lifetime' must not be negative")
+	v.check(o.federationSyncInterval > 0, "'--federation-sync-interval' must be positive")
+	v.check(o.maxConcurrentKeyGenerations >= 0, "'--max-concurrent-key-generations' must not be negative")
+	v.check(o.secureMemory == secureMemoryOff || o.secureMemory == secureMemoryPreferred ||
+		o.secureMemory == secureMemoryRequired, "invalid '--secure-memory' %q: specify '%s', '%s' or '%s'",
+		o.secureMemory, secureMemoryOff, secureMemoryPreferred, secureMemoryRequired)
+	v.check(o.keyPoolSize >= 0, "'--key-pool-size' must not be negative")
+	v.check(o.entropyProbeTimeout > 0, "'--entropy-probe-timeout' must be positive")
+	v.check(o.maxCertExpirySeries >= 0, "'--max-cert-expiry-series' must not be negative")
+	v.check(o.canaryInterval >= 0, "'--canary-interval' must not be negative")
+	v.check(o.mtlsReadinessInterval >= 0, "'--mtls-readiness-interval' must not be negative")
+	v.check(o.mtlsReadinessMinRemaining >= 0, "'--mtls-readiness-min-remaining' must not be negative")
+	v.check(o.injectSignErrors >= 0 && o.injectSignErrors <= 1,
+		"'--inject-sign-errors' (%v) must be between 0 and 1", o.injectSignErrors)
+	v.check(o.injectKubeLatency >= 0, "'--inject-kube-latency' must not be negative")
+	v.check(o.rootRolloutInterval > 0, "'--root-rollout-interval' must be positive")
+	v.check(o.rootRolloutMaxMetricIncrease >= 0, "'--root-rollout-max-metric-increase' must not be negative")
+
+	aliases, err := controller.ParseDataKeyAliases(o.secretDataKeys)
+	if err == nil {
+		err = controller.ValidateDataKeyAliases(aliases, o.secretTLSKeys)
+	}
+	v.check(err == nil, "invalid '--secret-data-key' (error: %v)", err)
+	o.secretDataKeyAliases = aliases
+
+	validateTrustDomainConfig(v, "", o.defaultTrustDomainConfig())
+
+	o.trustDomainConfigs = map[string]trustDomainConfig{}
+	if o.trustDomainsConfigFile != "" {
+		bs, err := ioutil.ReadFile(o.trustDomainsConfigFile)
+		if err == nil {
+			err = json.Unmarshal(bs, &o.trustDomainConfigs)
+		}
+		v.check(err == nil, "failed to load the trust domains in '--trust-domains-config' %s (error: %v)",
+			o.trustDomainsConfigFile, err)
+	}
+	for domain, cfg := range o.trustDomainConfigs {
+		v.check(domain != o.trustDomain,
+			"trust domain %s is configured for both the default and an additional CA", domain)
+		validateTrustDomainConfig(v, domain, cfg)
+	}
+
+	o.signingProfileConfigs = map[string]signingProfileConfig{}
+	if o.signingProfilesConfigFile != "" {
+		bs, err := ioutil.ReadFile(o.signingProfilesConfigFile)
+		if err == nil {
+			err = json.Unmarshal(bs, &o.signingProfileConfigs)
+		}
+		v.check(err == nil, "failed to load the signing profiles in '--signing-profiles-config' %s (error: %v)",
+			o.signingProfilesConfigFile, err)
+	}
+	v.check(len(o.signingProfileConfigs) == 0 || !o.noKeyEscrow,
+		"'--signing-profiles-config' requires the Istio secrets, which '--no-key-escrow' disables")
+	for name, cfg := range o.signingProfileConfigs {
+		v.check(len(cfg.Namespaces) > 0, "signing profile %s has no \"namespaces\" allowed to select it", name)
+		v.check(!cfg.SelfSigned, "signing profile %s cannot be self-signed: its CA must chain to the root of "+
+			"trust domain %s", name, o.trustDomain)
+		if !cfg.SelfSigned {
+			validateTrustDomainConfig(v, fmt.Sprintf("%s (signing profile %s)", o.trustDomain, name),
+				cfg.trustDomainConfig)
+		}
+	}
+
+	return utilerrors.NewAggregate(v.errs)
+}
+
+// defaultTrustDomainConfig returns the configuration of the CA of the default
+// trust domain, which is set by the flags.
+func (o *cliOptions) defaultTrustDomainConfig() trustDomainConfig {
+	return trustDomainConfig{
+		SelfSigned:      o.selfSignedCA,
+		CertChainFile:   o.certChainFile,
+		SigningCertFile: o.signingCertFile,
+		SigningKeyFile:  o.signingKeyFile,
+		RootCertFile:    o.rootCertFile,
+		SigningSecret:   o.signingSecret,
+
+		SigningKeyPIVSlot: o.signingKeyPIVSlot,
+		SigningWebhook:    o.signingWebhook,
+
+		ADCSURL: o.adcsURL,
+	}
+}
+
+// validateTrustDomainConfig checks that the CA of the trust domain is either
+// self-signed or has all of its signing material, in files or in a secret,
+// with the signing key optionally on a PIV token or behind a webhook, or
+// enrolled with ADCS. An empty domain stands for the default trust domain,
+// which is configured by the flags.
+func validateTrustDomainConfig(v *optionsValidator, domain string, cfg trustDomainConfig) {
+	if cfg.ADCSURL != "" {
+		validateADCS(v, domain, cfg)
+		return
+	}
+	if cfg.SelfSigned {
+		return
+	}
+	files := []struct {
+		path, flag, key string
+	}{
+		{cfg.CertChainFile, "cert-chain", "certChain"},
+		{cfg.SigningCertFile, "signing-cert", "signingCert"},
+		{cfg.SigningKeyFile, "signing-key", "signingKey"},
+		{cfg.RootCertFile, "root-cert", "rootCert"},
+	}
+	if cfg.SigningKeyPIVSlot != "" || cfg.SigningWebhook != "" {
+		validateExternalSigningKey(v, domain, cfg)
+		// The key is held outside of Istio CA rather than in a file.
+		files = append(files[:2], files[3:]...)
+	}
+	if cfg.SigningSecret != "" {
+		parts := strings.SplitN(cfg.SigningSecret, "/", 2)
+		valid := len(parts) == 2 && parts[0] != "" && parts[1] != ""
+		for _, f := range files {
+			if domain == "" {
+				v.check(f.path == "", "'--%s', '--signing-secret' are mutually exclusive", f.flag)
+			} else {
+				v.check(f.path == "", "trust domain %s has both %q and \"signingSecret\"", domain, f.key)
+			}
+		}
+		if domain == "" {
+			v.check(valid, "invalid '--signing-secret' %q: expect '<namespace>/<name>'", cfg.SigningSecret)
+		} else {
+			v.check(valid, "trust domain %s has an invalid \"signingSecret\" %q: expect '<namespace>/<name>'",
+				domain, cfg.SigningSecret)
+		}
+		return
+	}
+	for _, f := range files {
+		if domain == "" {
+			v.check(f.path != "", "no '--%s' has been specified: specify it, '--signing-secret' or use "+
+				"'--self-signed-ca'", f.flag)
+		} else {
+			v.check(f.path != "", "trust domain %s has no %q: specify it, \"signingSecret\" or set \"selfSigned\"",
+				domain, f.key)
+		}
+	}
+}
+
+// validateADCS checks that the signing material of the trust domain is only
+// enrolled with ADCS, and that the root certificate of the ADCS hierarchy is given.
+func validateADCS(v *optionsValidator, domain string, cfg trustDomainConfig) {
+	exclusive := []struct {
+		set       bool
+		flag, key string
+	}{
+		{cfg.SelfSigned, "self-signed-ca", "selfSigned"},
+		{cfg.CertChainFile != "", "cert-chain", "certChain"},
+		{cfg.SigningCertFile != "", "signing-cert", "signingCert"},
+		{cfg.SigningKeyFile != "", "signing-key", "signingKey"},
+		{cfg.SigningSecret != "", "signing-secret", "signingSecret"},
+		{cfg.SigningKeyPIVSlot != "", "signing-key-piv-slot", "signingKeyPIVSlot"},
+		{cfg.SigningWebhook != "", "signing-webhook", "signingWebhook"},
+	}
+	for _, e := range exclusive {
+		if domain == "" {
+			v.check(!e.set, "'--adcs-url', '--%s' are mutually exclusive", e.flag)
+		} else {
+			v.check(!e.set, "trust domain %s has both \"adcsURL\" and %q", domain, e.key)
+		}
+	}
+
+	validURL := strings.HasPrefix(cfg.ADCSURL, "https://")
+	if domain == "" {
+		v.check(validURL, "invalid '--adcs-url' %q: expect an https:// URL", cfg.ADCSURL)
+		v.check(cfg.RootCertFile != "", "no '--root-cert' has been specified: specify the root certificate of "+
+			"the '--adcs-url' hierarchy")
+	} else {
+		v.check(validURL, "trust domain %s has an invalid \"adcsURL\" %q: expect an https:// URL",
+			domain, cfg.ADCSURL)
+		v.check(cfg.RootCertFile != "", "trust domain %s has no \"rootCert\": specify the root certificate of "+
+			"the \"adcsURL\" hierarchy", domain)
+	}
+}
+
+// validateExternalSigningKey checks that the signing key of the trust domain
+// comes from exactly one of a file, a PIV token, a webhook and a secret.
+func validateExternalSigningKey(v *optionsValidator, domain string, cfg trustDomainConfig) {
+	sources := []struct {
+		value, flag, key string
+	}{
+		{cfg.SigningKeyFile, "signing-key", "signingKey"},
+		{cfg.SigningKeyPIVSlot, "signing-key-piv-slot", "signingKeyPIVSlot"},
+		{cfg.SigningWebhook, "signing-webhook", "signingWebhook"},
+		{cfg.SigningSecret, "signing-secret", "signingSecret"},
+	}
+	for i, a := range sources {
+		for _, b := range sources[i+1:] {
+			if a.value == "" || b.value == "" {
+				continue
+			}
+			if domain == "" {
+				v.check(false, "'--%s', '--%s' are mutually exclusive", a.flag, b.flag)
+			} else {
+				v.check(false, "trust domain %s has both %q and %q", domain, a.key, b.key)
+			}
+		}
+	}
+
+	validSlot := cfg.SigningKeyPIVSlot == "" || piv.ValidSlot(cfg.SigningKeyPIVSlot)
+	validWebhook := cfg.SigningWebhook == "" || strings.HasPrefix(cfg.SigningWebhook, "https://")
+	if domain == "" {
+		v.check(validSlot, "invalid '--signing-key-piv-slot' %q: expect one of %v", cfg.SigningKeyPIVSlot, piv.Slots)
+		v.check(validWebhook, "invalid '--signing-webhook' %q: expect an https:// URL", cfg.SigningWebhook)
+	} else {
+		v.check(validSlot, "trust domain %s has an invalid \"signingKeyPIVSlot\" %q: expect one of %v",
+			domain, cfg.SigningKeyPIVSlot, piv.Slots)
+		v.check(validWebhook, "trust domain %s has an invalid \"signingWebhook\" %q: expect an https:// URL",
+			domain, cfg.SigningWebhook)
+	}
+}
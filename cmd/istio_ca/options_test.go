@@ -0,0 +1,596 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// validOptions returns the options of a self-signed CA with the flag defaults.
+func validOptions() cliOptions {
+	return cliOptions{
+		selfSignedCA:           true,
+		trustDomain:            "cluster.local",
+		caCertTTL:              240 * time.Hour,
+		certTTL:                time.Hour,
+		minCertTTL:             10 * time.Minute,
+		clockSkewCheckInterval: time.Minute,
+		keyRotationPolicy:      keyRotationPolicyRekey,
+		pkiLint:                pkiLintOff,
+		federationSyncInterval: time.Minute,
+		exportInterval:         24 * time.Hour,
+		monitoringPort:         9093,
+		expiringCertWindow:     10 * time.Minute,
+		rootRolloutInterval:    10 * time.Minute,
+		entropyProbeTimeout:    5 * time.Second,
+		secureMemory:           secureMemoryOff,
+		stateSyncInterval:      30 * time.Second,
+		csrMaxStreams:          100,
+		csrMaxPeerConnections:  16,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "options-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	trustDomainsFile := filepath.Join(dir, "trust-domains.json")
+	err = ioutil.WriteFile(trustDomainsFile,
+		[]byte(`{"cluster.local": {"selfSigned": true}, "tenant.com": {"certChain": "chain.pem"}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingProfilesFile := filepath.Join(dir, "signing-profiles.json")
+	err = ioutil.WriteFile(signingProfilesFile, []byte(`{"pci": {"signingSecret": "istio-system/pci-ca", `+
+		`"namespaces": ["payments"]}, "self": {"selfSigned": true}, "partial": {"certChain": "chain.pem", `+
+		`"namespaces": ["billing"]}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		modify         func(o *cliOptions)
+		expectedErrors []string
+	}{
+		"Valid options": {
+			modify: func(o *cliOptions) {},
+		},
+		"Mutually exclusive flags": {
+			modify: func(o *cliOptions) {
+				o.exportDir = "/reports"
+				o.exportURL = "https://reports"
+				o.noKeyEscrow = true
+				o.enableServerCerts = true
+			},
+			expectedErrors: []string{
+				"'--export-dir', '--export-url' are mutually exclusive",
+				"'--enable-server-certs', '--no-key-escrow' are mutually exclusive",
+			},
+		},
+		"Certificate TTL must be shorter than the CA certificate TTLs": {
+			modify: func(o *cliOptions) {
+				o.certTTL = 300 * time.Hour
+				o.namespaceCATTL = 2 * time.Hour
+			},
+			expectedErrors: []string{
+				"'--cert-ttl' (300h0m0s) must be shorter than '--ca-cert-ttl' (240h0m0s)",
+				"'--cert-ttl' (300h0m0s) must be shorter than '--namespace-ca-ttl' (2h0m0s)",
+			},
+		},
+		"Certificate TTL must not be shorter than the minimum": {
+			modify: func(o *cliOptions) {
+				o.certTTL = time.Second
+			},
+			expectedErrors: []string{"'--cert-ttl' (1s) must not be shorter than '--min-cert-ttl' (10m0s)"},
+		},
+		"OpenSSL CA database requires an export destination": {
+			modify: func(o *cliOptions) {
+				o.exportOpenSSLDB = true
+			},
+			expectedErrors: []string{"'--export-openssl-db' requires '--export-dir' or '--export-url'"},
+		},
+		"Replication requires a single store and a positive interval": {
+			modify: func(o *cliOptions) {
+				o.replicateConsulAddress = "https://consul:8501"
+				o.replicateEtcdAddress = "https://etcd:2379"
+			},
+			expectedErrors: []string{
+				"'--replicate-consul-address', '--replicate-etcd-address' are mutually exclusive",
+				"'--replicate-interval' must be positive",
+			},
+		},
+		"Replication token requires Consul": {
+			modify: func(o *cliOptions) {
+				o.replicateEtcdAddress = "https://etcd:2379"
+				o.replicateInterval = time.Minute
+				o.replicateTokenFile = "/etc/consul/token"
+			},
+			expectedErrors: []string{"'--replicate-token-file' requires '--replicate-consul-address'"},
+		},
+		"SQL state store requires a supported driver and a DSN": {
+			modify: func(o *cliOptions) {
+				o.stateStore = stateStoreSQL
+				o.stateSQLDriver = "oracle"
+				o.issuanceTimeFile = "/var/lib/istio-ca/last-issuance"
+			},
+			expectedErrors: []string{
+				"'--state-store=sql' requires '--state-sql-driver' and '--state-sql-dsn'",
+				"invalid '--state-sql-driver' \"oracle\": specify one of mysql, postgres, sqlite3",
+				"'--issuance-time-file' is redundant with '--state-store', which persists the latest issuance time",
+			},
+		},
+		"State store flags require a valid state store": {
+			modify: func(o *cliOptions) {
+				o.stateStore = "etcd"
+				o.stateSQLDSN = "postgres://istio-ca@db/istio-ca"
+				o.stateSyncInterval = 0
+			},
+			expectedErrors: []string{
+				"invalid '--state-store' \"etcd\": specify either 'configmap' or 'sql'",
+				"'--state-sql-driver' and '--state-sql-dsn' require '--state-store=sql'",
+				"'--state-sync-interval' must be positive",
+			},
+		},
+		"Admin API requires a token and TLS hosts": {
+			modify: func(o *cliOptions) {
+				o.adminAddress = "localhost:8061"
+				o.adminHosts = nil
+			},
+			expectedErrors: []string{
+				"'--admin-address' requires an admin token file via '--admin-token-file'",
+				"'--admin-address' requires the hosts of its TLS certificate via '--admin-hosts'",
+			},
+		},
+		"CSR API requires TLS hosts and an authenticator": {
+			modify: func(o *cliOptions) {
+				o.csrAddress = ":8060"
+				o.csrAzureAudience = "https://istio-ca"
+			},
+			expectedErrors: []string{
+				"'--csr-address' requires the hosts of its TLS certificate via '--csr-hosts'",
+				"'--csr-address' requires '--csr-gcp-audience', '--csr-azure-tenant', '--csr-kubernetes-tokens' or " +
+					"'--csr-oidc-issuers' to authenticate the callers",
+			},
+		},
+		"Control-plane bootstrap requires key escrow": {
+			modify: func(o *cliOptions) {
+				o.noKeyEscrow = true
+				o.bootstrapControlPlane = true
+			},
+			expectedErrors: []string{"'--bootstrap-control-plane' provisions private keys, which '--no-key-escrow' disallows"},
+		},
+		"Migration requires key escrow": {
+			modify: func(o *cliOptions) {
+				o.noKeyEscrow = true
+				o.migrateOnly = true
+			},
+			expectedErrors: []string{"'--migrate-only' requires the Istio secrets, which '--no-key-escrow' disables"},
+		},
+		"Kubernetes tokens require the CSR API": {
+			modify: func(o *cliOptions) {
+				o.csrKubernetesTokens = true
+			},
+			expectedErrors: []string{"'--csr-kubernetes-tokens' requires '--csr-address'"},
+		},
+		"Kubernetes audience requires Kubernetes tokens": {
+			modify: func(o *cliOptions) {
+				o.csrKubernetesAudience = "istio-ca"
+			},
+			expectedErrors: []string{"'--csr-kubernetes-audience' requires '--csr-kubernetes-tokens'"},
+		},
+		"Pod identity requires Kubernetes tokens": {
+			modify: func(o *cliOptions) {
+				o.csrPodIdentity = true
+			},
+			expectedErrors: []string{"'--csr-pod-identity' requires '--csr-kubernetes-tokens'"},
+		},
+		"OIDC issuers require the CSR API": {
+			modify: func(o *cliOptions) {
+				o.csrOIDCIssuers = "issuers.json"
+			},
+			expectedErrors: []string{"'--csr-oidc-issuers' requires '--csr-address'"},
+		},
+		"Delegation policy requires the CSR API": {
+			modify: func(o *cliOptions) {
+				o.csrDelegationPolicy = "delegation.json"
+			},
+			expectedErrors: []string{"'--csr-delegation-policy' requires '--csr-address'"},
+		},
+		"Method policy and rate limit require the CSR API": {
+			modify: func(o *cliOptions) {
+				o.csrMethodPolicy = "methods.json"
+				o.csrRateLimit = -1
+			},
+			expectedErrors: []string{
+				"'--csr-method-policy' requires '--csr-address'",
+				"'--csr-rate-limit' requires '--csr-address'",
+				"'--csr-rate-limit' must not be negative",
+			},
+		},
+		"Connection limits must be positive": {
+			modify: func(o *cliOptions) {
+				o.csrMaxStreams = 0
+				o.csrMaxPeerConnections = -1
+			},
+			expectedErrors: []string{
+				"'--csr-max-concurrent-streams' must be positive",
+				"'--csr-max-connections-per-peer' must be positive",
+			},
+		},
+		"EST requires the CSR API": {
+			modify: func(o *cliOptions) {
+				o.estAddress = ":8443"
+			},
+			expectedErrors: []string{"'--est-address' requires '--csr-address'"},
+		},
+		"SCEP requires challenge passwords": {
+			modify: func(o *cliOptions) {
+				o.scepAddress = ":8080"
+			},
+			expectedErrors: []string{"'--scep-address' and '--scep-challenges' must be specified together"},
+		},
+		"CSR authenticators require identity mappings": {
+			modify: func(o *cliOptions) {
+				o.csrAddress = ":8060"
+				o.csrHosts = []string{"istio-ca"}
+				o.csrGCPAudience = "istio-ca"
+				o.csrAzureTenant = "tenant"
+			},
+			expectedErrors: []string{
+				"'--csr-gcp-audience' requires '--csr-gcp-identity-mapping'",
+				"'--csr-azure-tenant' requires '--csr-azure-audience' and '--csr-azure-identity-mapping'",
+			},
+		},
+		"Maximum key age requires reused keys": {
+			modify: func(o *cliOptions) {
+				o.maxKeyAge = time.Hour
+			},
+			expectedErrors: []string{"'--max-key-age' requires '--key-rotation-policy=reuse'"},
+		},
+		"Warning thresholds must be in range": {
+			modify: func(o *cliOptions) {
+				o.maxExpiringCertFraction = 1.5
+				o.minRootCertLifetime = -time.Hour
+			},
+			expectedErrors: []string{
+				"'--max-expiring-cert-fraction' (1.5) must be between 0 and 1",
+				"'--min-root-cert-lifetime' must not be negative",
+			},
+		},
+		"Federation peers without an address": {
+			modify: func(o *cliOptions) {
+				o.federationPeersFile = "peers.json"
+				o.federationID = "istio-ca"
+			},
+			expectedErrors: []string{
+				"'--federation-address' and '--federation-peers' must be specified together",
+				"invalid '--federation-id' \"istio-ca\": specify a SPIFFE URI",
+			},
+		},
+		"Negative canary interval": {
+			modify: func(o *cliOptions) {
+				o.canaryInterval = -time.Minute
+			},
+			expectedErrors: []string{"'--canary-interval' must not be negative"},
+		},
+		"Invalid secure memory": {
+			modify: func(o *cliOptions) {
+				o.secureMemory = "on"
+			},
+			expectedErrors: []string{"invalid '--secure-memory' \"on\": specify 'off', 'preferred' or 'required'"},
+		},
+		"Invalid key generation options": {
+			modify: func(o *cliOptions) {
+				o.entropyProbeTimeout = 0
+				o.maxConcurrentKeyGenerations = -1
+				o.keyPoolSize = -1
+			},
+			expectedErrors: []string{
+				"'--max-concurrent-key-generations' must not be negative",
+				"'--key-pool-size' must not be negative",
+				"'--entropy-probe-timeout' must be positive",
+			},
+		},
+		"Negative maximum of certificate expiry series": {
+			modify: func(o *cliOptions) {
+				o.maxCertExpirySeries = -1
+			},
+			expectedErrors: []string{"'--max-cert-expiry-series' must not be negative"},
+		},
+		"Invalid secret data key": {
+			modify: func(o *cliOptions) {
+				o.secretDataKeys = []string{"key.pem=cert-chain.pem"}
+			},
+			expectedErrors: []string{"invalid '--secret-data-key' (error: alias \"cert-chain.pem\" of data key \"key.pem\" " +
+				"collides with another data key)"},
+		},
+		"Invalid mutual TLS readiness": {
+			modify: func(o *cliOptions) {
+				o.mtlsReadinessInterval = -time.Minute
+				o.mtlsReadinessMinRemaining = -time.Minute
+			},
+			expectedErrors: []string{
+				"'--mtls-readiness-interval' must not be negative",
+				"'--mtls-readiness-min-remaining' must not be negative",
+			},
+		},
+		"Mutual TLS readiness without key escrow": {
+			modify: func(o *cliOptions) {
+				o.noKeyEscrow = true
+				o.mtlsReadinessInterval = time.Minute
+			},
+			expectedErrors: []string{"'--mtls-readiness-interval' requires the Istio secrets, which '--no-key-escrow' disables"},
+		},
+		"Invalid fault injection": {
+			modify: func(o *cliOptions) {
+				o.injectSignErrors = 2
+				o.injectKubeLatency = -time.Second
+			},
+			expectedErrors: []string{
+				"'--inject-sign-errors' (2) must be between 0 and 1",
+				"'--inject-kube-latency' must not be negative",
+			},
+		},
+		"Signing material is required without a self-signed CA": {
+			modify: func(o *cliOptions) {
+				o.selfSignedCA = false
+				o.certChainFile = "chain.pem"
+				o.signingCertFile = "cert.pem"
+			},
+			expectedErrors: []string{
+				"no '--signing-key' has been specified",
+				"no '--root-cert' has been specified",
+			},
+		},
+		"Signing secret instead of the files": {
+			modify: func(o *cliOptions) {
+				o.selfSignedCA = false
+				o.signingSecret = "istio-system/cacerts"
+			},
+		},
+		"Signing secret excludes the files": {
+			modify: func(o *cliOptions) {
+				o.selfSignedCA = false
+				o.signingSecret = "cacerts"
+				o.signingKeyFile = "key.pem"
+			},
+			expectedErrors: []string{
+				"'--signing-key', '--signing-secret' are mutually exclusive",
+				"invalid '--signing-secret' \"cacerts\": expect '<namespace>/<name>'",
+			},
+		},
+		"Signing key on a PIV token": {
+			modify: func(o *cliOptions) {
+				o.selfSignedCA = false
+				o.certChainFile = "chain.pem"
+				o.signingCertFile = "cert.pem"
+				o.rootCertFile = "root.pem"
+				o.signingKeyPIVSlot = "9c"
+			},
+		},
+		"PIV slot excludes the key file": {
+			modify: func(o *cliOptions) {
+				o.selfSignedCA = false
+				o.certChainFile = "chain.pem"
+				o.signingCertFile = "cert.pem"
+				o.rootCertFile = "root.pem"
+				o.signingKeyFile = "key.pem"
+				o.signingKeyPIVSlot = "82"
+			},
+			expectedErrors: []string{
+				"invalid '--signing-key-piv-slot' \"82\": expect one of [9a 9c 9d 9e]",
+				"'--signing-key', '--signing-key-piv-slot' are mutually exclusive",
+			},
+		},
+		"Signing key behind a webhook": {
+			modify: func(o *cliOptions) {
+				o.selfSignedCA = false
+				o.certChainFile = "chain.pem"
+				o.signingCertFile = "cert.pem"
+				o.rootCertFile = "root.pem"
+				o.signingWebhook = "https://signer.example.com/sign"
+			},
+		},
+		"Signing webhook excludes the other key sources": {
+			modify: func(o *cliOptions) {
+				o.selfSignedCA = false
+				o.certChainFile = "chain.pem"
+				o.signingCertFile = "cert.pem"
+				o.rootCertFile = "root.pem"
+				o.signingKeyPIVSlot = "9c"
+				o.signingWebhook = "http://signer.example.com/sign"
+			},
+			expectedErrors: []string{
+				"'--signing-key-piv-slot', '--signing-webhook' are mutually exclusive",
+				"invalid '--signing-webhook' \"http://signer.example.com/sign\": expect an https:// URL",
+			},
+		},
+		"Signing material enrolled with ADCS": {
+			modify: func(o *cliOptions) {
+				o.selfSignedCA = false
+				o.rootCertFile = "root.pem"
+				o.adcsURL = "https://adcs.example.com/certsrv"
+			},
+		},
+		"ADCS excludes the other signing material": {
+			modify: func(o *cliOptions) {
+				o.signingCertFile = "cert.pem"
+				o.signingWebhook = "https://signer.example.com/sign"
+				o.adcsURL = "http://adcs.example.com/certsrv"
+			},
+			expectedErrors: []string{
+				"'--adcs-url', '--self-signed-ca' are mutually exclusive",
+				"'--adcs-url', '--signing-cert' are mutually exclusive",
+				"'--adcs-url', '--signing-webhook' are mutually exclusive",
+				"invalid '--adcs-url' \"http://adcs.example.com/certsrv\": expect an https:// URL",
+				"no '--root-cert' has been specified: specify the root certificate of the '--adcs-url' hierarchy",
+			},
+		},
+		"Weak signature algorithm": {
+			modify: func(o *cliOptions) {
+				o.signatureAlgorithm = "SHA1-RSA"
+			},
+			expectedErrors: []string{"invalid '--signature-algorithm': the signature algorithm SHA1-RSA is too weak"},
+		},
+		"Negative namespace quotas": {
+			modify: func(o *cliOptions) {
+				o.namespaceMaxActiveIdentities = -1
+				o.namespaceMaxIssuancesPerHour = -1
+			},
+			expectedErrors: []string{
+				"'--namespace-max-active-identities' must not be negative",
+				"'--namespace-max-issuances-per-hour' must not be negative",
+			},
+		},
+		"Invalid identity registry": {
+			modify: func(o *cliOptions) {
+				o.identityRegistryWebhook = "http://cmdb.example.com/approve"
+				o.identityRegistryCacheTTL = -time.Minute
+			},
+			expectedErrors: []string{
+				"invalid '--identity-registry-webhook' \"http://cmdb.example.com/approve\": expect an https:// URL",
+				"'--identity-registry-cache-ttl' must not be negative",
+			},
+		},
+		"Invalid token exchange": {
+			modify: func(o *cliOptions) {
+				o.tokenExchangeAddress = ":8444"
+				o.tokenExchangeOIDCIssuer = "http://ci.example.com"
+				o.tokenExchangeCertTTL = 5 * time.Minute
+			},
+			expectedErrors: []string{
+				"'--token-exchange-address' requires '--csr-address'",
+				"'--token-exchange-address' requires '--token-exchange-oidc-issuer', '--token-exchange-oidc-audience' " +
+					"and '--token-exchange-identity-mapping'",
+				"invalid '--token-exchange-oidc-issuer' \"http://ci.example.com\": expect an https:// URL",
+				"'--token-exchange-cert-ttl' 5m0s must be between '--min-cert-ttl' 10m0s and '--cert-ttl' 1h0m0s",
+			},
+		},
+		"Invalid approval queue": {
+			modify: func(o *cliOptions) {
+				o.approvalRequiredIdentities = []string{"spiffe://cluster.local/ns/istio-system/sa/*"}
+			},
+			expectedErrors: []string{
+				"'--approval-required-identities' requires '--admin-address' to approve the issuances",
+				"'--approval-timeout' must be positive",
+			},
+		},
+		"Approver tokens without approval queue": {
+			modify: func(o *cliOptions) {
+				o.approverTokensFile = "/etc/istio/approvers.json"
+			},
+			expectedErrors: []string{
+				"'--approver-tokens-file' requires '--approval-required-identities'",
+			},
+		},
+		"Invalid PKI lint": {
+			modify: func(o *cliOptions) {
+				o.pkiLint = "strict"
+				o.pkiLintStrict = true
+			},
+			expectedErrors: []string{"invalid '--pki-lint' \"strict\": specify 'off', 'ca' or 'all'"},
+		},
+		"Strict PKI lint without lint": {
+			modify: func(o *cliOptions) {
+				o.pkiLintStrict = true
+			},
+			expectedErrors: []string{"'--pki-lint-strict' requires '--pki-lint'"},
+		},
+		"Invalid trust domains": {
+			modify: func(o *cliOptions) {
+				o.trustDomainsConfigFile = trustDomainsFile
+			},
+			expectedErrors: []string{
+				"trust domain cluster.local is configured for both the default and an additional CA",
+				"trust domain tenant.com has no \"signingCert\"",
+				"trust domain tenant.com has no \"signingKey\"",
+				"trust domain tenant.com has no \"rootCert\"",
+			},
+		},
+		"Missing trust domains file": {
+			modify: func(o *cliOptions) {
+				o.trustDomainsConfigFile = filepath.Join(dir, "missing.json")
+			},
+			expectedErrors: []string{"failed to load the trust domains"},
+		},
+		"Invalid signing profiles": {
+			modify: func(o *cliOptions) {
+				o.signingProfilesConfigFile = signingProfilesFile
+			},
+			expectedErrors: []string{
+				"signing profile self has no \"namespaces\" allowed to select it",
+				"signing profile self cannot be self-signed",
+				"trust domain cluster.local (signing profile partial) has no \"signingCert\"",
+				"trust domain cluster.local (signing profile partial) has no \"signingKey\"",
+				"trust domain cluster.local (signing profile partial) has no \"rootCert\"",
+			},
+		},
+		"Signing profiles without key escrow": {
+			modify: func(o *cliOptions) {
+				o.signingProfilesConfigFile = signingProfilesFile
+				o.noKeyEscrow = true
+			},
+			expectedErrors: []string{
+				"'--signing-profiles-config' requires the Istio secrets, which '--no-key-escrow' disables",
+				"signing profile self has no \"namespaces\" allowed to select it",
+				"signing profile self cannot be self-signed",
+				"trust domain cluster.local (signing profile partial) has no \"signingCert\"",
+				"trust domain cluster.local (signing profile partial) has no \"signingKey\"",
+				"trust domain cluster.local (signing profile partial) has no \"rootCert\"",
+			},
+		},
+		"Missing signing profiles file": {
+			modify: func(o *cliOptions) {
+				o.signingProfilesConfigFile = filepath.Join(dir, "missing.json")
+			},
+			expectedErrors: []string{"failed to load the signing profiles"},
+		},
+	}
+
+	for k, tc := range testCases {
+		o := validOptions()
+		tc.modify(&o)
+		err := o.validate()
+
+		if len(tc.expectedErrors) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", k, err)
+			}
+			continue
+		}
+		agg, ok := err.(utilerrors.Aggregate)
+		if !ok {
+			t.Errorf("%s: expect an aggregated error but got %v", k, err)
+			continue
+		}
+		if len(agg.Errors()) != len(tc.expectedErrors) {
+			t.Errorf("%s: expect %d errors but got %v", k, len(tc.expectedErrors), err)
+		}
+		for _, expected := range tc.expectedErrors {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("%s: expect error %q in %v", k, expected, err)
+			}
+		}
+	}
+}
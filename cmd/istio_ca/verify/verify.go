@@ -0,0 +1,193 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/auth/cmd/istio_ca/kubeclient"
+	"istio.io/auth/controller"
+	"istio.io/auth/pkg/spiffe"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const dialTimeout = 10 * time.Second
+
+type options struct {
+	kubeConfigFile string
+	namespace      string
+	serviceAccount string
+	trustDomain    string
+	minRemaining   time.Duration
+
+	rootNamespace string
+	rootConfigMap string
+
+	trustedIssuersFile string
+
+	pod  string
+	port int
+}
+
+var (
+	opts options
+
+	// this is used for testing command output
+	printFunc = fmt.Printf
+
+	// Command verifies the Istio secret of a service account end to end.
+	Command = &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the Istio secret of a service account",
+		Long: "Reads the Istio secret of the service account and verifies that its certificate chain is issued for " +
+			"the identity of the service account, is not expiring, matches the private key, and verifies against " +
+			"the root certificate in the discovery ConfigMap or an issuer in '--trusted-issuers'. With '--pod', " +
+			"also dials the pod over TLS to verify that it serves the certificate of the secret.",
+		RunE: func(*cobra.Command, []string) error {
+			if opts.namespace == "" || opts.serviceAccount == "" {
+				return errors.New("both '--namespace' and '--serviceaccount' must be specified")
+			}
+			var issuers spiffe.TrustedIssuers
+			if opts.trustedIssuersFile != "" {
+				var err error
+				if issuers, err = spiffe.LoadTrustedIssuers(opts.trustedIssuersFile); err != nil {
+					return err
+				}
+			}
+			core, err := kubeclient.NewCoreClient(opts.kubeConfigFile)
+			if err != nil {
+				return err
+			}
+			v, err := controller.VerifyIstioSecretWithIssuers(core, opts.namespace, opts.serviceAccount,
+				opts.trustDomain, opts.rootNamespace, opts.rootConfigMap, opts.minRemaining, issuers)
+			if err != nil {
+				return err
+			}
+			problems := v.Problems
+			if opts.pod != "" && len(problems) == 0 {
+				problems = verifyPod(core, v)
+			}
+			return report(v, problems)
+		},
+	}
+)
+
+func init() {
+	flags := Command.Flags()
+	flags.StringVar(&opts.kubeConfigFile, "kube-config", "",
+		"Specifies path to kubeconfig file. If unspecified, the in-cluster configuration is used.")
+	flags.StringVar(&opts.namespace, "namespace", "", "The namespace of the service account")
+	flags.StringVar(&opts.serviceAccount, "serviceaccount", "", "The name of the service account")
+	flags.StringVar(&opts.trustDomain, "trust-domain", "cluster.local", "The trust domain of the Istio identities")
+	flags.DurationVar(&opts.minRemaining, "min-remaining", 0,
+		"The remaining lifetime of the certificate below which it is reported as expiring")
+	flags.StringVar(&opts.rootNamespace, "root-configmap-namespace", "istio-system",
+		"The namespace of the discovery ConfigMap holding the distributed root certificate")
+	flags.StringVar(&opts.rootConfigMap, "root-configmap", "istio-ca",
+		"The name of the discovery ConfigMap holding the distributed root certificate")
+	flags.StringVar(&opts.trustedIssuersFile, "trusted-issuers", "",
+		"A JSON file of additional trusted issuers, e.g. a legacy PKI, whose certificates are accepted for the "+
+			"identities they are trusted for")
+	flags.StringVar(&opts.pod, "pod", "",
+		"The name of a pod of the service account to dial over TLS, in the namespace of the service account")
+	flags.IntVar(&opts.port, "port", 15443, "The TLS port of the pod to dial")
+}
+
+// verifyPod dials the pod and returns the problems with the certificate it serves.
+func verifyPod(core corev1.CoreV1Interface, v *controller.SecretVerification) []string {
+	pod, err := core.Pods(opts.namespace).Get(opts.pod, metav1.GetOptions{})
+	if err != nil {
+		return []string{fmt.Sprintf("failed to get pod %s/%s (error: %v)", opts.namespace, opts.pod, err)}
+	}
+	if pod.Spec.ServiceAccountName != opts.serviceAccount {
+		return []string{fmt.Sprintf("pod %s/%s runs as service account %q instead of %q",
+			opts.namespace, opts.pod, pod.Spec.ServiceAccountName, opts.serviceAccount)}
+	}
+	if pod.Status.PodIP == "" {
+		return []string{fmt.Sprintf("pod %s/%s has no IP address", opts.namespace, opts.pod)}
+	}
+	return dial(net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(opts.port)), v)
+}
+
+// dial performs a TLS handshake with the address and returns the problems
+// with the certificate chain it serves, which must hold the certificate of
+// the secret. The certificate of the secret is presented as the client
+// certificate if its key is readable, as sidecars require mutual TLS. The
+// served chain is inspected even if the peer rejects the handshake afterwards.
+func dial(address string, v *controller.SecretVerification) []string {
+	var served [][]byte
+	config := &tls.Config{
+		// The served chain is verified below against the identity rather than a host name.
+		InsecureSkipVerify: true, // nolint: gas
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			served = rawCerts
+			return nil
+		},
+	}
+	if v.PrivateKey != nil {
+		if pair, err := tls.X509KeyPair(v.CertChain, v.PrivateKey); err == nil {
+			config.Certificates = []tls.Certificate{pair}
+		}
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", address, config)
+	if err == nil {
+		conn.Close() // nolint: errcheck
+	}
+	if len(served) == 0 {
+		return []string{fmt.Sprintf("failed to get the certificate served on %s (error: %v)", address, err)}
+	}
+
+	// The chain of the secret has been verified, so the served chain is only
+	// compared with it.
+	if block, _ := pem.Decode(v.CertChain); block != nil && bytes.Equal(block.Bytes, served[0]) {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(served[0])
+	if err != nil {
+		return []string{fmt.Sprintf("%s serves a malformed certificate (error: %v)", address, err)}
+	}
+	return []string{fmt.Sprintf("%s serves the certificate with serial number %x issued for %v, "+
+		"not the certificate of the secret", address, cert.SerialNumber, cert.URIs)}
+}
+
+func report(v *controller.SecretVerification, problems []string) error {
+	if len(problems) == 0 {
+		// nolint: errcheck,gas
+		printFunc("Secret %s/%s is valid for %s until %v\n", opts.namespace, v.SecretName, v.ID, v.NotAfter)
+		if v.Issuer != "" {
+			// nolint: errcheck,gas
+			printFunc("The certificate is issued by the additional trusted issuer %s\n", v.Issuer)
+		}
+		return nil
+	}
+	for _, p := range problems {
+		// nolint: errcheck,gas
+		printFunc("%s\n", p)
+	}
+	return fmt.Errorf("secret %s/%s failed %d checks", opts.namespace, v.SecretName, len(problems))
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager"
+	"istio.io/auth/controller"
+)
+
+// serveTLS serves the certificate chain and key on a local address until the
+// returned listener is closed. Client certificates issued by the CA are required.
+func serveTLS(t *testing.T, ca *certmanager.IstioCA, chain, key []byte) net.Listener {
+	pair, err := tls.X509KeyPair(chain, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.GetRootCertificate())
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    roots,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake() // nolint: errcheck
+			conn.Close()                 // nolint: errcheck
+		}
+	}()
+	return l
+}
+
+func TestDial(t *testing.T) {
+	ca, err := certmanager.NewSelfSignedIstioCA(&certmanager.SelfSignedIstioCAOptions{
+		CACertTTL:  time.Hour,
+		CertTTL:    30 * time.Minute,
+		Subject:    pkix.Name{Organization: []string{"test.ca.org"}},
+		MaxPathLen: -1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+	chain, key, _ := ca.Generate("foo", "default")
+	otherChain, otherKey, _ := ca.Generate("foo", "default")
+	v := &controller.SecretVerification{CertChain: chain, PrivateKey: key}
+
+	serving := serveTLS(t, ca, chain, key)
+	defer serving.Close() // nolint: errcheck
+	stale := serveTLS(t, ca, otherChain, otherKey)
+	defer stale.Close() // nolint: errcheck
+	closed := serveTLS(t, ca, chain, key)
+	closed.Close() // nolint: errcheck
+
+	testCases := map[string]struct {
+		address         string
+		verification    *controller.SecretVerification
+		expectedProblem string
+	}{
+		"Serves the certificate of the secret": {
+			address:      serving.Addr().String(),
+			verification: v,
+		},
+		"Serves the certificate without a client certificate": {
+			address:      serving.Addr().String(),
+			verification: &controller.SecretVerification{CertChain: chain},
+		},
+		"Serves another certificate": {
+			address:         stale.Addr().String(),
+			verification:    v,
+			expectedProblem: "not the certificate of the secret",
+		},
+		"Not listening": {
+			address:         closed.Addr().String(),
+			verification:    v,
+			expectedProblem: "failed to get the certificate served",
+		},
+	}
+
+	for id, tc := range testCases {
+		problems := dial(tc.address, tc.verification)
+		if tc.expectedProblem == "" {
+			if len(problems) != 0 {
+				t.Errorf("%s: Unexpected problems %v", id, problems)
+			}
+			continue
+		}
+		if len(problems) != 1 || !strings.Contains(problems[0], tc.expectedProblem) {
+			t.Errorf("%s: Unexpected problems (expecting %q, actual %v)", id, tc.expectedProblem, problems)
+		}
+	}
+}
@@ -15,9 +15,12 @@
 package version
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"runtime"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
 )
 
@@ -27,6 +30,7 @@ var (
 	gitRevision string
 	user        string
 	version     string
+	buildDate   string
 
 	// this is used for testing command output
 	printFunc = fmt.Printf
@@ -40,9 +44,55 @@ GitRevision: %v
 GitBranch: %v
 User: %v@%v
 Golang version: %v
-`, version, gitRevision, gitBranch, user, host, runtime.Version())
+Build date: %v
+`, version, gitRevision, gitBranch, user, host, runtime.Version(), buildDate)
 		},
 		Use:   "version",
 		Short: "Display version information",
 	}
+
+	buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "istio_ca",
+		Name:      "build_info",
+		Help:      "A metric with a constant value of 1, labeled by the version information of Istio CA.",
+	}, []string{"version", "git_revision", "go_version", "build_date"})
 )
+
+func init() {
+	prometheus.MustRegister(buildInfo)
+	buildInfo.WithLabelValues(version, gitRevision, runtime.Version(), buildDate).Set(1)
+}
+
+// Info describes the build of the binary.
+type Info struct {
+	Version     string `json:"version"`
+	GitRevision string `json:"gitRevision"`
+	GitBranch   string `json:"gitBranch"`
+	GoVersion   string `json:"goVersion"`
+	BuildDate   string `json:"buildDate"`
+}
+
+// Version returns the version stamped into the binary, or an empty string if
+// the binary is not built with a link stamp.
+func Version() string {
+	return version
+}
+
+// Get returns the build information stamped into the binary.
+func Get() Info {
+	return Info{
+		Version:     version,
+		GitRevision: gitRevision,
+		GitBranch:   gitBranch,
+		GoVersion:   runtime.Version(),
+		BuildDate:   buildDate,
+	}
+}
+
+// Handler returns an HTTP handler serving the build information as JSON.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Get()) // nolint: errcheck
+	})
+}
@@ -16,7 +16,9 @@ package version
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"net/http/httptest"
 	"runtime"
 	"testing"
 )
@@ -27,10 +29,11 @@ func TestVersionCommand(t *testing.T) {
 	gitRevision = "test-git-revision"
 	user = "test-user"
 	version = "test-version"
+	buildDate = "test-build-date"
 
 	expectedOutput := fmt.Sprintf(
-		"Version: %v\nGitRevision: %v\nGitBranch: %v\nUser: %v@%v\nGolang version: %v\n",
-		version, gitRevision, gitBranch, user, host, runtime.Version())
+		"Version: %v\nGitRevision: %v\nGitBranch: %v\nUser: %v@%v\nGolang version: %v\nBuild date: %v\n",
+		version, gitRevision, gitBranch, user, host, runtime.Version(), buildDate)
 
 	var buffer bytes.Buffer
 	printFunc = func(format string, a ...interface{}) (int, error) {
@@ -44,3 +47,28 @@ func TestVersionCommand(t *testing.T) {
 		t.Errorf("Unexpected output: wanted %v but got %v", expectedOutput, actualOutput)
 	}
 }
+
+func TestHandler(t *testing.T) {
+	gitBranch = "test-git-branch"
+	gitRevision = "test-git-revision"
+	version = "test-version"
+	buildDate = "test-build-date"
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/version", nil))
+
+	var info Info
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Failed to decode the response %q: %v", w.Body.String(), err)
+	}
+	expected := Info{
+		Version:     version,
+		GitRevision: gitRevision,
+		GitBranch:   gitBranch,
+		GoVersion:   runtime.Version(),
+		BuildDate:   buildDate,
+	}
+	if info != expected {
+		t.Errorf("Unexpected build information: wanted %+v but got %+v", expected, info)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	rbac "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+)
+
+// ControlPlaneProfile lists the service accounts of the Istio control-plane
+// components in the `istio.io/control-plane` profile: Pilot, Mixer and Istio
+// CA itself.
+var ControlPlaneProfile = []string{
+	"istio-pilot-service-account",
+	"istio-mixer-service-account",
+	"istio-ca-service-account",
+}
+
+// BootstrapControlPlane provisions the Istio secrets of the control-plane
+// service accounts in the namespace before the controller runs, so that the
+// control plane does not wait behind the workloads for its certificates.
+// Service accounts that do not exist, i.e. components not deployed, and
+// existing secrets are skipped. The secrets are rotated by the controller
+// like any other, so its namespace must include the control-plane namespace.
+func (sc *SecretController) BootstrapControlPlane(namespace string, serviceAccounts []string) error {
+	var errs []error
+	for _, saName := range serviceAccounts {
+		_, err := sc.core.ServiceAccounts(namespace).Get(saName, metav1.GetOptions{})
+		recordAPIRequest("get", err)
+		if errors.IsNotFound(err) {
+			glog.Warningf("Control-plane service account %s/%s does not exist; skipping its secret", namespace, saName)
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get service account %s/%s (error: %v)", namespace, saName, err))
+			continue
+		}
+
+		_, err = sc.core.Secrets(namespace).Get(getSecretName(saName), metav1.GetOptions{})
+		recordAPIRequest("get", err)
+		if err == nil {
+			continue
+		}
+		if !errors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to get the Istio secret of %s/%s (error: %v)", namespace, saName, err))
+			continue
+		}
+		if err := sc.createSecret(saName, namespace); err != nil {
+			errs = append(errs, fmt.Errorf("failed to create the Istio secret of %s/%s (error: %v)",
+				namespace, saName, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// ControlPlaneRules returns the RBAC rules needed by BootstrapControlPlane in
+// the control-plane namespace.
+func ControlPlaneRules() []rbac.PolicyRule {
+	return []rbac.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"secrets"},
+			Verbs:     []string{"get", "create"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"serviceaccounts"},
+			Verbs:     []string{"get"},
+		},
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestBootstrapControlPlane(t *testing.T) {
+	existing := createSecret("istio-mixer-service-account", "istio.istio-mixer-service-account", "istio-system")
+	existing.Data[certChainID] = []byte("existing cert chain")
+	client := fake.NewSimpleClientset(
+		createServiceAccount("istio-pilot-service-account", "istio-system"),
+		createServiceAccount("istio-mixer-service-account", "istio-system"),
+		existing,
+	)
+	controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+
+	if err := controller.BootstrapControlPlane("istio-system", ControlPlaneProfile); err != nil {
+		t.Fatalf("Failed to bootstrap the control plane: %v", err)
+	}
+
+	secrets, err := client.CoreV1().Secrets("istio-system").List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chains := map[string]string{}
+	names := []string{}
+	for _, s := range secrets.Items {
+		chains[s.Name] = string(s.Data[certChainID])
+		names = append(names, s.Name)
+	}
+	sort.Strings(names)
+	// The secret of the CA service account, which does not exist, is skipped.
+	expected := []string{"istio.istio-mixer-service-account", "istio.istio-pilot-service-account"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Unexpected secrets %v (expecting %v)", names, expected)
+	}
+	if chains["istio.istio-pilot-service-account"] != "fake cert chain" {
+		t.Errorf("The secret of Pilot should be provisioned by the CA")
+	}
+	if chains["istio.istio-mixer-service-account"] != "existing cert chain" {
+		t.Errorf("The existing secret of Mixer should not be overwritten")
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// The annotation on a managed secret listing the data key aliases it is
+// written with, as comma-separated `<Istio data key>=<alias>` pairs, so that
+// the aliases dropped from the configuration are also dropped from the secret.
+const dataKeyAliasesAnnotationKey = "istio.io/data-key-aliases"
+
+// The Istio data keys that can be aliased, i.e. those readable by workloads.
+var aliasableDataKeys = sets.NewString(certChainID, privateKeyID, rootCertID)
+
+// ParseDataKeyAliases parses `<Istio data key>=<alias>` pairs, e.g.
+// `key.pem=tls.key`, into a map from the Istio data keys to their aliases.
+func ParseDataKeyAliases(pairs []string) (map[string]string, error) {
+	aliases := map[string]string{}
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid data key alias %q: expecting <Istio data key>=<alias>", pair)
+		}
+		if _, ok := aliases[parts[0]]; ok {
+			return nil, fmt.Errorf("data key %q is aliased more than once", parts[0])
+		}
+		aliases[parts[0]] = parts[1]
+	}
+	return aliases, nil
+}
+
+// ValidateDataKeyAliases checks that the aliases are valid secret data keys
+// for the aliasable Istio data keys, and collide with no other data key. The
+// data keys of kubernetes.io/tls secrets are free unless tlsKeys is set, i.e.
+// SecretControllerOptions.TLSKeys.
+func ValidateDataKeyAliases(aliases map[string]string, tlsKeys bool) error {
+	reserved := reservedDataKeys(tlsKeys)
+	used := sets.NewString()
+	for key, alias := range aliases {
+		if !aliasableDataKeys.Has(key) {
+			return fmt.Errorf("data key %q cannot be aliased: expecting one of %s", key,
+				strings.Join(aliasableDataKeys.List(), ", "))
+		}
+		if errs := validation.IsConfigMapKey(alias); len(errs) > 0 {
+			return fmt.Errorf("invalid alias %q of data key %q: %s", alias, key, strings.Join(errs, "; "))
+		}
+		if reserved.Has(alias) || used.Has(alias) {
+			return fmt.Errorf("alias %q of data key %q collides with another data key", alias, key)
+		}
+		used.Insert(alias)
+	}
+	return nil
+}
+
+// setDataKeyAliases writes the data of the secret under the configured
+// aliases, and removes the aliases it was previously written with that are no
+// longer configured. An encrypted private key is not aliased.
+func (sc *SecretController) setDataKeyAliases(scrt *v1.Secret) {
+	reserved := reservedDataKeys(sc.opts.TLSKeys)
+	for _, alias := range parseDataKeyAliasesAnnotation(scrt) {
+		// The annotation may have been edited; never drop the data keys of the controller.
+		if !reserved.Has(alias) {
+			delete(scrt.Data, alias)
+		}
+	}
+
+	aliases := map[string]string{}
+	for key, alias := range sc.opts.DataKeyAliases {
+		if data, ok := scrt.Data[key]; ok {
+			scrt.Data[alias] = data
+			aliases[key] = alias
+		}
+	}
+
+	if len(aliases) == 0 {
+		delete(scrt.Annotations, dataKeyAliasesAnnotationKey)
+		return
+	}
+	if scrt.Annotations == nil {
+		scrt.Annotations = map[string]string{}
+	}
+	scrt.Annotations[dataKeyAliasesAnnotationKey] = formatDataKeyAliases(aliases)
+}
+
+// dataKeyAliasesOutdated returns true if the secret is not written with the
+// configured aliases. It only reads the annotation, so that it also works on
+// the secrets of the informer cache, which come without their private keys.
+func (sc *SecretController) dataKeyAliasesOutdated(scrt *v1.Secret) bool {
+	expected := map[string]string{}
+	for key, alias := range sc.opts.DataKeyAliases {
+		if key != privateKeyID || sc.opts.KeyEncryption == nil {
+			expected[key] = alias
+		}
+	}
+	return scrt.Annotations[dataKeyAliasesAnnotationKey] != formatDataKeyAliases(expected)
+}
+
+// updateDataKeyAliases rewrites the secret with the configured aliases,
+// keeping its certificates and private key.
+func (sc *SecretController) updateDataKeyAliases(scrt *v1.Secret) {
+	_, err := sc.updateCachedSecret(scrt, func(s *v1.Secret) bool {
+		if !sc.isManaged(s) || s.Data == nil {
+			return false
+		}
+		before := s.Annotations[dataKeyAliasesAnnotationKey]
+		sc.setDataKeyAliases(s)
+		return s.Annotations[dataKeyAliasesAnnotationKey] != before
+	})
+	if err != nil {
+		glog.Errorf("Failed to update the data key aliases of secret %s/%s (error: %s)",
+			scrt.GetNamespace(), scrt.GetName(), err)
+	}
+}
+
+func formatDataKeyAliases(aliases map[string]string) string {
+	pairs := []string{}
+	for key, alias := range aliases {
+		pairs = append(pairs, key+"="+alias)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// parseDataKeyAliasesAnnotation returns the aliases recorded on the secret,
+// keyed by Istio data key. Malformed pairs are ignored.
+func parseDataKeyAliasesAnnotation(scrt *v1.Secret) map[string]string {
+	aliases := map[string]string{}
+	value := scrt.Annotations[dataKeyAliasesAnnotationKey]
+	if value == "" {
+		return aliases
+	}
+	for _, pair := range strings.Split(value, ",") {
+		if parts := strings.SplitN(pair, "=", 2); len(parts) == 2 && parts[1] != "" {
+			aliases[parts[0]] = parts[1]
+		}
+	}
+	return aliases
+}
+
+// reservedDataKeys returns the data keys written by the controller itself,
+// including those of kubernetes.io/tls secrets if tlsKeys is set.
+func reservedDataKeys(tlsKeys bool) sets.String {
+	reserved := aliasableDataKeys.Union(sets.NewString(encryptedPrivateKeyID))
+	if tlsKeys {
+		for _, tlsKey := range tlsDataKeys {
+			reserved.Insert(tlsKey)
+		}
+	}
+	return reserved
+}
@@ -0,0 +1,211 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestParseDataKeyAliases(t *testing.T) {
+	testCases := map[string]struct {
+		pairs           []string
+		tlsKeys         bool
+		expectedAliases map[string]string
+		expectedErr     string
+	}{
+		"No aliases": {
+			expectedAliases: map[string]string{},
+		},
+		"Aliases": {
+			pairs:           []string{"key.pem=tls.key", "cert-chain.pem=cert.pem"},
+			expectedAliases: map[string]string{privateKeyID: "tls.key", certChainID: "cert.pem"},
+		},
+		"Malformed pair": {
+			pairs:       []string{"key.pem"},
+			expectedErr: "expecting <Istio data key>=<alias>",
+		},
+		"Key aliased twice": {
+			pairs:       []string{"key.pem=a.key", "key.pem=b.key"},
+			expectedErr: "aliased more than once",
+		},
+		"Unknown key": {
+			pairs:       []string{"key.pem.enc=a.key"},
+			expectedErr: "cannot be aliased",
+		},
+		"Invalid alias": {
+			pairs:       []string{"key.pem=a/key"},
+			expectedErr: "invalid alias",
+		},
+		"Alias colliding with an Istio key": {
+			pairs:       []string{"key.pem=cert-chain.pem"},
+			expectedErr: "collides with another data key",
+		},
+		"TLS keys are free without TLSKeys": {
+			pairs:           []string{"key.pem=tls.key"},
+			expectedAliases: map[string]string{privateKeyID: "tls.key"},
+		},
+		"Alias colliding with a TLS key": {
+			pairs:       []string{"cert-chain.pem=tls.crt"},
+			tlsKeys:     true,
+			expectedErr: "collides with another data key",
+		},
+		"Aliases colliding with each other": {
+			pairs:       []string{"cert-chain.pem=a.pem", "root-cert.pem=a.pem"},
+			expectedErr: "collides with another data key",
+		},
+	}
+
+	for k, tc := range testCases {
+		aliases, err := ParseDataKeyAliases(tc.pairs)
+		if err == nil {
+			err = ValidateDataKeyAliases(aliases, tc.tlsKeys)
+		}
+		if tc.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: expecting error %q but got %v", k, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", k, err)
+		} else if !reflect.DeepEqual(aliases, tc.expectedAliases) {
+			t.Errorf("%s: unexpected aliases %v (expecting %v)", k, aliases, tc.expectedAliases)
+		}
+	}
+}
+
+func TestDataKeyAliases(t *testing.T) {
+	testCases := map[string]struct {
+		opts               SecretControllerOptions
+		expectedData       map[string]string
+		expectedAnnotation string
+	}{
+		"No aliases": {
+			expectedData: map[string]string{
+				certChainID:  "fake cert chain",
+				privateKeyID: "fake key",
+				rootCertID:   "fake root cert",
+			},
+		},
+		"Aliases": {
+			opts: SecretControllerOptions{
+				DataKeyAliases: map[string]string{privateKeyID: "tls.key", rootCertID: "ca.pem"},
+			},
+			expectedData: map[string]string{
+				certChainID:         "fake cert chain",
+				privateKeyID:        "fake key",
+				rootCertID:          "fake root cert",
+				v1.TLSPrivateKeyKey: "fake key",
+				"ca.pem":            "fake root cert",
+			},
+			expectedAnnotation: "key.pem=tls.key,root-cert.pem=ca.pem",
+		},
+		"Encrypted private key is not aliased": {
+			opts: SecretControllerOptions{
+				DataKeyAliases: map[string]string{privateKeyID: "app.key", rootCertID: "ca.pem"},
+				KeyEncryption:  identityKMS{},
+			},
+			expectedData: map[string]string{
+				certChainID:           "fake cert chain",
+				encryptedPrivateKeyID: "*",
+				rootCertID:            "fake root cert",
+				"ca.pem":              "fake root cert",
+			},
+			expectedAnnotation: "root-cert.pem=ca.pem",
+		},
+	}
+
+	for k, tc := range testCases {
+		client := fake.NewSimpleClientset()
+		controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, tc.opts)
+		controller.saAdded(createServiceAccount("test", "test-ns"))
+		drainQueue(controller)
+
+		scrt, err := client.CoreV1().Secrets("test-ns").Get("istio.test", metav1.GetOptions{})
+		if err != nil {
+			t.Errorf("%s: failed to get the secret (error %v)", k, err)
+			continue
+		}
+		// The KMS envelope is not deterministic, so only its presence is checked.
+		data := map[string]string{}
+		for key, value := range scrt.Data {
+			data[key] = string(value)
+			if tc.expectedData[key] == "*" {
+				data[key] = "*"
+			}
+		}
+		if !reflect.DeepEqual(data, tc.expectedData) {
+			t.Errorf("%s: unexpected data %v (expecting %v)", k, data, tc.expectedData)
+		}
+		if a := scrt.Annotations[dataKeyAliasesAnnotationKey]; a != tc.expectedAnnotation {
+			t.Errorf("%s: unexpected annotation %q (expecting %q)", k, a, tc.expectedAnnotation)
+		}
+		if controller.dataKeyAliasesOutdated(scrt) {
+			t.Errorf("%s: the aliases of a newly created secret should not be outdated", k)
+		}
+	}
+}
+
+func TestUpdateDataKeyAliases(t *testing.T) {
+	scrt := createSecret("test", "istio.test", "test-ns")
+	scrt.Data["old.key"] = []byte("fake key")
+	scrt.Annotations[dataKeyAliasesAnnotationKey] = "key.pem=old.key"
+	client := fake.NewSimpleClientset(scrt)
+	controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{
+		DataKeyAliases: map[string]string{privateKeyID: "new.key"},
+	})
+
+	// The cached secret comes without its private key or its alias.
+	cached, _ := client.CoreV1().Secrets("test-ns").Get("istio.test", metav1.GetOptions{})
+	slimSecret(cached)
+	if _, ok := cached.Data["old.key"]; ok {
+		t.Fatalf("The alias of the private key should be dropped from the cache")
+	}
+	if !controller.dataKeyAliasesOutdated(cached) {
+		t.Fatalf("The aliases should be outdated after the configuration changed")
+	}
+
+	controller.updateDataKeyAliases(cached)
+	updated, _ := client.CoreV1().Secrets("test-ns").Get("istio.test", metav1.GetOptions{})
+	if _, ok := updated.Data["old.key"]; ok {
+		t.Errorf("The alias no longer configured should be removed")
+	}
+	if key := string(updated.Data["new.key"]); key != "fake key" {
+		t.Errorf("The private key should be written under the new alias, got %q", key)
+	}
+	if key := string(updated.Data[privateKeyID]); key != "fake key" {
+		t.Errorf("The private key should be kept, got %q", key)
+	}
+	if controller.dataKeyAliasesOutdated(updated) {
+		t.Errorf("The aliases should be up to date after the update")
+	}
+
+	// An edited annotation never drops the data keys of the controller.
+	updated.Annotations[dataKeyAliasesAnnotationKey] = "key.pem=cert-chain.pem"
+	controller = NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+	controller.setDataKeyAliases(updated)
+	if _, ok := updated.Data[certChainID]; !ok {
+		t.Errorf("The certificate chain should be kept")
+	}
+	if _, ok := updated.Annotations[dataKeyAliasesAnnotationKey]; ok {
+		t.Errorf("The annotation should be removed without aliases")
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/auth/certmanager"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// The data keys of the discovery ConfigMap.
+const (
+	// The address that workloads reach Istio CA at.
+	discoveryAddressKey = "ca-address"
+	// The hex-encoded SHA-256 fingerprint of the root certificate.
+	discoveryFingerprintKey = "root-cert-fingerprint"
+	// The PEM-encoded root certificate.
+	discoveryRootCertKey = "root-cert.pem"
+
+	discoveryResyncPeriod = time.Minute
+)
+
+// DiscoveryController publishes the address of Istio CA and its root
+// certificate in a ConfigMap, so that Pilot and sidecar injectors can discover
+// the issuance endpoint. The ConfigMap is labeled as managed by Istio CA, and
+// an existing ConfigMap without the label is never overwritten.
+type DiscoveryController struct {
+	ca   certmanager.CertificateAuthority
+	core corev1.CoreV1Interface
+
+	namespace string
+	name      string
+	address   string
+}
+
+// NewDiscoveryController returns a pointer to a DiscoveryController that
+// publishes the address in the named ConfigMap.
+func NewDiscoveryController(ca certmanager.CertificateAuthority, core corev1.CoreV1Interface,
+	namespace, name, address string) *DiscoveryController {
+
+	return &DiscoveryController{
+		ca:        ca,
+		core:      core,
+		namespace: namespace,
+		name:      name,
+		address:   address,
+	}
+}
+
+// Run publishes the discovery data periodically, so that it follows root
+// rotations, until stopCh is closed.
+func (dc *DiscoveryController) Run(stopCh chan struct{}) {
+	wait.Until(dc.publish, discoveryResyncPeriod, stopCh)
+}
+
+func (dc *DiscoveryController) publish() {
+	data := map[string]string{discoveryAddressKey: dc.address}
+	rootCert := dc.ca.GetRootCertificate()
+	data[discoveryRootCertKey] = string(rootCert)
+	if root, err := parseCertificate(rootCert); err == nil {
+		data[discoveryFingerprintKey] = fingerprint(root)
+	}
+
+	cms := dc.core.ConfigMaps(dc.namespace)
+	existing, err := cms.Get(dc.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = cms.Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels:    map[string]string{managedByLabelKey: managedByLabelValue},
+				Name:      dc.name,
+				Namespace: dc.namespace,
+			},
+			Data: data,
+		})
+		if err != nil {
+			glog.Errorf("Failed to create the discovery ConfigMap %s/%s (error: %s)", dc.namespace, dc.name, err)
+			return
+		}
+		glog.Infof("Published the Istio CA address %s in ConfigMap %s/%s", dc.address, dc.namespace, dc.name)
+		return
+	} else if err != nil {
+		glog.Errorf("Failed to get the discovery ConfigMap %s/%s (error: %s)", dc.namespace, dc.name, err)
+		return
+	}
+
+	if existing.Labels[managedByLabelKey] != managedByLabelValue {
+		glog.Warningf("ConfigMap %s/%s is not managed by Istio CA; refusing to overwrite it", dc.namespace, dc.name)
+		return
+	}
+	if reflect.DeepEqual(existing.Data, data) {
+		return
+	}
+
+	// The update carries the resource version of the existing ConfigMap; on a
+	// conflict it is retried with the next resync.
+	updated := *existing
+	updated.Data = data
+	if _, err := cms.Update(&updated); err != nil {
+		glog.Errorf("Failed to update the discovery ConfigMap %s/%s (error: %s)", dc.namespace, dc.name, err)
+		return
+	}
+	glog.Infof("Updated the discovery ConfigMap %s/%s", dc.namespace, dc.name)
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func createDiscoveryConfigMap(labels, data map[string]string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:    labels,
+			Name:      "istio-ca",
+			Namespace: "istio-system",
+		},
+		Data: data,
+	}
+}
+
+func TestDiscoveryController(t *testing.T) {
+	gvr := schema.GroupVersionResource{
+		Resource: "configmaps",
+		Version:  "v1",
+	}
+	managed := map[string]string{managedByLabelKey: managedByLabelValue}
+	data := map[string]string{
+		discoveryAddressKey:  "istio-ca.istio-system:8060",
+		discoveryRootCertKey: "fake root cert",
+	}
+	stale := map[string]string{
+		discoveryAddressKey:  "istio-ca.istio-system:8060",
+		discoveryRootCertKey: "old root cert",
+	}
+
+	testCases := map[string]struct {
+		existing        *v1.ConfigMap
+		expectedActions []ktesting.Action
+	}{
+		"ConfigMap is created": {
+			expectedActions: []ktesting.Action{
+				ktesting.NewGetAction(gvr, "istio-system", "istio-ca"),
+				ktesting.NewCreateAction(gvr, "istio-system", createDiscoveryConfigMap(managed, data)),
+			},
+		},
+		"Up-to-date ConfigMap is not updated": {
+			existing: createDiscoveryConfigMap(managed, data),
+			expectedActions: []ktesting.Action{
+				ktesting.NewGetAction(gvr, "istio-system", "istio-ca"),
+			},
+		},
+		"Stale ConfigMap is updated": {
+			existing: createDiscoveryConfigMap(managed, stale),
+			expectedActions: []ktesting.Action{
+				ktesting.NewGetAction(gvr, "istio-system", "istio-ca"),
+				ktesting.NewUpdateAction(gvr, "istio-system", createDiscoveryConfigMap(managed, data)),
+			},
+		},
+		"Unmanaged ConfigMap is not overwritten": {
+			existing: createDiscoveryConfigMap(nil, stale),
+			expectedActions: []ktesting.Action{
+				ktesting.NewGetAction(gvr, "istio-system", "istio-ca"),
+			},
+		},
+	}
+
+	for k, tc := range testCases {
+		objs := []runtime.Object{}
+		if tc.existing != nil {
+			objs = append(objs, tc.existing)
+		}
+		client := fake.NewSimpleClientset(objs...)
+		controller := NewDiscoveryController(fakeCa{}, client.CoreV1(), "istio-system", "istio-ca",
+			"istio-ca.istio-system:8060")
+		controller.publish()
+
+		actions := client.Actions()
+		if !reflect.DeepEqual(actions, tc.expectedActions) {
+			t.Errorf("%s: expect actions to be \n\t%v\n but actual actions are \n\t%v", k, tc.expectedActions, actions)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// IdentityExtractor decides which Istio identity a workload runs as.
+type IdentityExtractor interface {
+	// GetIdentity returns the name of the identity the pod runs as. The identity
+	// is scoped to the namespace of the pod.
+	GetIdentity(pod *v1.Pod) (string, error)
+}
+
+// ServiceAccountIdentityExtractor maps a pod to the service account it runs as.
+type ServiceAccountIdentityExtractor struct{}
+
+// GetIdentity implements IdentityExtractor.
+func (e ServiceAccountIdentityExtractor) GetIdentity(pod *v1.Pod) (string, error) {
+	if pod.Spec.ServiceAccountName == "" {
+		return "", fmt.Errorf("pod %s/%s has no service account", pod.GetNamespace(), pod.GetName())
+	}
+	return pod.Spec.ServiceAccountName, nil
+}
+
+// LabelIdentityExtractor maps a pod to the value of one of its labels.
+type LabelIdentityExtractor struct {
+	// The key of the label holding the identity.
+	Label string
+}
+
+// GetIdentity implements IdentityExtractor.
+func (e LabelIdentityExtractor) GetIdentity(pod *v1.Pod) (string, error) {
+	id, ok := pod.GetLabels()[e.Label]
+	if !ok || id == "" {
+		return "", fmt.Errorf("pod %s/%s has no label %q", pod.GetNamespace(), pod.GetName(), e.Label)
+	}
+	return id, nil
+}
+
+// OwnerReferenceIdentityExtractor maps a pod to its controlling owner (e.g. a
+// ReplicaSet or a StatefulSet), so that all replicas share the same identity.
+// Pods without a controller fall back to their own name.
+type OwnerReferenceIdentityExtractor struct{}
+
+// GetIdentity implements IdentityExtractor.
+func (e OwnerReferenceIdentityExtractor) GetIdentity(pod *v1.Pod) (string, error) {
+	for _, ref := range pod.GetOwnerReferences() {
+		if ref.Controller != nil && *ref.Controller {
+			return fmt.Sprintf("%s.%s", ref.Kind, ref.Name), nil
+		}
+	}
+	if pod.GetName() == "" {
+		return "", fmt.Errorf("pod in namespace %s has neither a controller nor a name", pod.GetNamespace())
+	}
+	return pod.GetName(), nil
+}
+
+// NewIdentityExtractor returns the IdentityExtractor for the given mode:
+// "serviceaccount", "label:<key>" or "ownerreference".
+func NewIdentityExtractor(mode string) (IdentityExtractor, error) {
+	const labelPrefix = "label:"
+
+	switch {
+	case mode == "serviceaccount":
+		return ServiceAccountIdentityExtractor{}, nil
+	case mode == "ownerreference":
+		return OwnerReferenceIdentityExtractor{}, nil
+	case strings.HasPrefix(mode, labelPrefix) && len(mode) > len(labelPrefix):
+		return LabelIdentityExtractor{Label: strings.TrimPrefix(mode, labelPrefix)}, nil
+	default:
+		return nil, fmt.Errorf("unknown identity extraction mode %q", mode)
+	}
+}
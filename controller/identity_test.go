@@ -0,0 +1,93 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestIdentityExtractors(t *testing.T) {
+	isController := true
+	ownedPod := createPod(&podSpec{
+		labels:             map[string]string{"identity": "from-label"},
+		name:               "owned-pod",
+		serviceAccountName: "acct",
+	})
+	ownedPod.OwnerReferences = []metav1.OwnerReference{
+		{Kind: "ReplicaSet", Name: "frontend", Controller: &isController},
+	}
+	orphanPod := createPod(&podSpec{name: "orphan-pod"})
+
+	testCases := map[string]struct {
+		mode        string
+		pod         *v1.Pod
+		expectedID  string
+		expectedErr bool
+	}{
+		"Service account": {
+			mode:       "serviceaccount",
+			pod:        ownedPod,
+			expectedID: "acct",
+		},
+		"Label": {
+			mode:       "label:identity",
+			pod:        ownedPod,
+			expectedID: "from-label",
+		},
+		"Missing label": {
+			mode:        "label:identity",
+			pod:         orphanPod,
+			expectedErr: true,
+		},
+		"Owner reference": {
+			mode:       "ownerreference",
+			pod:        ownedPod,
+			expectedID: "ReplicaSet.frontend",
+		},
+		"Owner reference falls back to pod name": {
+			mode:       "ownerreference",
+			pod:        orphanPod,
+			expectedID: "orphan-pod",
+		},
+	}
+
+	for k, tc := range testCases {
+		e, err := NewIdentityExtractor(tc.mode)
+		if err != nil {
+			t.Errorf("%s: failed to create an identity extractor (error: %v)", k, err)
+			continue
+		}
+
+		id, err := e.GetIdentity(tc.pod)
+		if tc.expectedErr {
+			if err == nil {
+				t.Errorf("%s: expecting an error but got identity %q", k, id)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+		} else if id != tc.expectedID {
+			t.Errorf("%s: expecting identity %q but actual is %q", k, tc.expectedID, id)
+		}
+	}
+
+	if _, err := NewIdentityExtractor("unknown"); err == nil {
+		t.Errorf("Expecting an error for an unknown identity extraction mode")
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"github.com/golang/glog"
+
+	"istio.io/auth/certmanager"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// IssuanceImporter is a CA seeding its issuance log with certificates it
+// issued before, e.g. a certmanager.IstioCA.
+type IssuanceImporter interface {
+	ImportIssuances(records []certmanager.IssuanceRecord) int
+}
+
+// ImportIssuances seeds the issuance logs of the CAs with the certificates in
+// the Istio secrets, so that the certificates issued before a restart or by a
+// previous version of Istio CA are tracked, e.g. listed and revoked, like
+// those issued since. Each certificate is imported into the CA of the
+// namespace of its secret, if the CA is an IssuanceImporter and the chain of
+// the secret verifies against its root, whether or not the controller manages
+// the secret. It returns the number of imported certificates.
+func (sc *SecretController) ImportIssuances() (int, error) {
+	selector := fields.SelectorFromSet(map[string]string{"type": istioSecretType}).String()
+	list, err := sc.core.Secrets(sc.namespace).List(metav1.ListOptions{FieldSelector: selector})
+	recordAPIRequest("list", err)
+	if err != nil {
+		return 0, err
+	}
+
+	records := map[IssuanceImporter][]certmanager.IssuanceRecord{}
+	for i := range list.Items {
+		scrt := &list.Items[i]
+		ca, err := sc.caFor(scrt.GetNamespace())
+		if err != nil {
+			glog.Warningf("Not importing the certificate of secret %s/%s (error: %v)",
+				scrt.GetNamespace(), scrt.GetName(), err)
+			continue
+		}
+		importer, ok := ca.(IssuanceImporter)
+		if !ok || !chainVerifies(scrt.Data[certChainID], ca.GetRootCertificate()) {
+			continue
+		}
+		r, err := issuanceRecord(scrt)
+		if err != nil {
+			glog.Warningf("Not importing the malformed certificate of secret %s/%s (error: %v)",
+				scrt.GetNamespace(), scrt.GetName(), err)
+			continue
+		}
+		records[importer] = append(records[importer], r)
+	}
+
+	imported := 0
+	for importer, rs := range records {
+		imported += importer.ImportIssuances(rs)
+	}
+	if imported > 0 {
+		glog.Infof("Imported %d certificates of the Istio secrets into the issuance logs", imported)
+	}
+	return imported, nil
+}
+
+// issuanceRecord describes the certificate of the secret.
+func issuanceRecord(scrt *v1.Secret) (certmanager.IssuanceRecord, error) {
+	cert, err := parseCertificate(scrt.Data[certChainID])
+	if err != nil {
+		return certmanager.IssuanceRecord{}, err
+	}
+	return certmanager.IssuanceRecord{
+		SerialNumber: cert.SerialNumber,
+		ID:           certificateID(cert),
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+	}, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// fakeImporter is a CA with the given root recording the imported issuances.
+type fakeImporter struct {
+	fakeCa
+	root     []byte
+	imported []certmanager.IssuanceRecord
+}
+
+func (ca *fakeImporter) GetRootCertificate() []byte {
+	return ca.root
+}
+
+func (ca *fakeImporter) ImportIssuances(records []certmanager.IssuanceRecord) int {
+	ca.imported = append(ca.imported, records...)
+	return len(records)
+}
+
+func TestImportIssuances(t *testing.T) {
+	newCA := func() *certmanager.IstioCA {
+		ca, err := certmanager.NewSelfSignedIstioCA(&certmanager.SelfSignedIstioCAOptions{
+			CACertTTL:  time.Hour,
+			CertTTL:    30 * time.Minute,
+			Subject:    pkix.Name{Organization: []string{"test.ca.org"}},
+			MaxPathLen: -1,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create a self-signed CA: %v", err)
+		}
+		return ca
+	}
+	issuer, other := newCA(), newCA()
+
+	var err error
+
+	issued := createSecret("foo", "istio.foo", "test-ns")
+	issued.Data[certChainID], _, err = issuer.Generate("foo", "test-ns")
+	if err != nil {
+		t.Fatalf("Failed to generate a certificate: %v", err)
+	}
+	unmanaged := createSecret("bar", "istio.bar", "test-ns")
+	unmanaged.Data[certChainID], _, _ = issuer.Generate("bar", "test-ns")
+	unmanaged.Labels = map[string]string{managedByLabelKey: managedByUserLabelValue}
+	foreign := createSecret("qux", "istio.qux", "test-ns")
+	foreign.Data[certChainID], _, _ = other.Generate("qux", "test-ns")
+	malformed := createSecret("baz", "istio.baz", "test-ns")
+
+	client := fake.NewSimpleClientset(issued, unmanaged, foreign, malformed)
+	ca := &fakeImporter{root: issuer.GetRootCertificate()}
+	controller := NewSecretController(ca, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+
+	imported, err := controller.ImportIssuances()
+	if err != nil {
+		t.Fatalf("Failed to import the issuances: %v", err)
+	}
+	if imported != 2 || len(ca.imported) != 2 {
+		t.Fatalf("Expecting the certificates of 2 secrets to be imported, actual imports: %v", ca.imported)
+	}
+
+	expectedIDs := map[*v1.Secret]string{
+		issued:    "spiffe://cluster.local/ns/test-ns/sa/foo",
+		unmanaged: "spiffe://cluster.local/ns/test-ns/sa/bar",
+	}
+	for scrt, id := range expectedIDs {
+		cert, err := parseCertificate(scrt.Data[certChainID])
+		if err != nil {
+			t.Fatalf("Failed to parse the certificate: %v", err)
+		}
+		found := false
+		for _, r := range ca.imported {
+			if r.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+				continue
+			}
+			found = true
+			if r.ID != id || !r.NotBefore.Equal(cert.NotBefore) || !r.NotAfter.Equal(cert.NotAfter) {
+				t.Errorf("Unexpected imported record %v of certificate %v", r, cert.SerialNumber)
+			}
+		}
+		if !found {
+			t.Errorf("The certificate of secret %s has not been imported", scrt.GetName())
+		}
+	}
+}
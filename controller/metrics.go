@@ -0,0 +1,178 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// The reasons of the secret controller failures.
+const (
+	failureSelectCA = "select_ca"
+	failureIssue    = "issue"
+	failureStoreKey = "store_key"
+	failureAPI      = "api"
+)
+
+var (
+	apiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "istio_ca",
+		Subsystem: "controller",
+		Name:      "api_requests_total",
+		Help: "The number of Kubernetes API requests on the secrets managed by Istio CA, by verb and result " +
+			"('success', 'conflict' or 'error').",
+	}, []string{"verb", "result"})
+
+	controllerFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "istio_ca",
+		Subsystem: "controller",
+		Name:      "failures_total",
+		Help:      "The number of failed provisionings and rotations of the secrets managed by Istio CA, by reason.",
+	}, []string{"reason"})
+)
+
+func init() {
+	prometheus.MustRegister(apiRequests)
+	prometheus.MustRegister(controllerFailures)
+}
+
+// recordAPIRequest counts a Kubernetes API request on a managed secret. A
+// NotFound error of a get or a deletion is counted as a success, since it
+// answers the request, and a conflict is retried rather than failing.
+func recordAPIRequest(verb string, err error) {
+	result := "success"
+	switch {
+	case err == nil, (verb == "get" || verb == "delete") && errors.IsNotFound(err):
+	case errors.IsConflict(err):
+		result = "conflict"
+	default:
+		result = "error"
+		controllerFailures.WithLabelValues(failureAPI).Inc()
+	}
+	apiRequests.WithLabelValues(verb, result).Inc()
+}
+
+// recordFailure counts a failure of the secret controller other than an API error.
+func recordFailure(reason string) {
+	controllerFailures.WithLabelValues(reason).Inc()
+}
+
+var (
+	cachedSecretsDesc = prometheus.NewDesc("istio_ca_controller_cached_secrets",
+		"The number of Istio secrets in the cache of the secret controller.", nil, nil)
+	cachedServiceAccountsDesc = prometheus.NewDesc("istio_ca_controller_cached_service_accounts",
+		"The number of service accounts in the cache of the secret controller.", nil, nil)
+	managedSecretsDesc = prometheus.NewDesc("istio_ca_controller_managed_secrets",
+		"The number of Istio secrets managed by Istio CA, by namespace.", []string{"namespace"}, nil)
+	pendingIssuancesDesc = prometheus.NewDesc("istio_ca_controller_pending_issuances",
+		"The number of pending certificate issuances, by kind ('creation' or 'renewal').",
+		[]string{"kind"}, nil)
+	managedCertExpiryDesc = prometheus.NewDesc("istio_ca_managed_cert_expiry_seconds",
+		"The remaining lifetime in seconds of the certificate of each service account, by namespace and service "+
+			"account, for up to SecretControllerOptions.MaxExpirySeries certificates expiring first.",
+		[]string{"namespace", "serviceaccount"}, nil)
+)
+
+// secretControllerCollector reports the state of a secret controller, which
+// is read from its caches and its issuance queue on every scrape.
+type secretControllerCollector struct {
+	sc *SecretController
+}
+
+// Collector returns a Prometheus collector of the state of the controller.
+// It is not registered, so that several controllers can coexist.
+func (sc *SecretController) Collector() prometheus.Collector {
+	return secretControllerCollector{sc: sc}
+}
+
+// Describe implements prometheus.Collector.
+func (c secretControllerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cachedSecretsDesc
+	ch <- cachedServiceAccountsDesc
+	ch <- managedSecretsDesc
+	ch <- pendingIssuancesDesc
+	if c.sc.opts.MaxExpirySeries > 0 {
+		ch <- managedCertExpiryDesc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c secretControllerCollector) Collect(ch chan<- prometheus.Metric) {
+	secrets := c.sc.scrtStore.List()
+	perNamespace := map[string]int{}
+	managed := []*v1.Secret{}
+	for _, obj := range secrets {
+		if scrt, ok := obj.(*v1.Secret); ok && c.sc.isManaged(scrt) {
+			perNamespace[scrt.GetNamespace()]++
+			managed = append(managed, scrt)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(cachedSecretsDesc, prometheus.GaugeValue, float64(len(secrets)))
+	ch <- prometheus.MustNewConstMetric(cachedServiceAccountsDesc, prometheus.GaugeValue,
+		float64(len(c.sc.saStore.ListKeys())))
+	for namespace, n := range perNamespace {
+		ch <- prometheus.MustNewConstMetric(managedSecretsDesc, prometheus.GaugeValue, float64(n), namespace)
+	}
+
+	creations, renewals := c.sc.queue.Counts()
+	ch <- prometheus.MustNewConstMetric(pendingIssuancesDesc, prometheus.GaugeValue, float64(creations), "creation")
+	ch <- prometheus.MustNewConstMetric(pendingIssuancesDesc, prometheus.GaugeValue, float64(renewals), "renewal")
+
+	if c.sc.opts.MaxExpirySeries > 0 {
+		c.collectExpiries(ch, managed)
+	}
+}
+
+// collectExpiries reports the remaining lifetime of the certificates of the
+// secrets. Beyond MaxExpirySeries secrets, only the certificates expiring
+// first are reported, as they are those worth alerting on.
+func (c secretControllerCollector) collectExpiries(ch chan<- prometheus.Metric, secrets []*v1.Secret) {
+	type expiry struct {
+		namespace, serviceAccount string
+		remaining                 time.Duration
+	}
+	expiries := []expiry{}
+	for _, scrt := range secrets {
+		saName, ok := scrt.Annotations[serviceAccountNameAnnotationKey]
+		if !ok {
+			continue
+		}
+		cert, err := parseCertificate(scrt.Data[certChainID])
+		if err != nil {
+			continue
+		}
+		expiries = append(expiries, expiry{
+			namespace:      scrt.GetNamespace(),
+			serviceAccount: saName,
+			remaining:      time.Until(cert.NotAfter),
+		})
+	}
+	sort.Slice(expiries, func(i, j int) bool { return expiries[i].remaining < expiries[j].remaining })
+	if len(expiries) > c.sc.opts.MaxExpirySeries {
+		expiries = expiries[:c.sc.opts.MaxExpirySeries]
+	}
+
+	for _, e := range expiries {
+		ch <- prometheus.MustNewConstMetric(managedCertExpiryDesc, prometheus.GaugeValue, e.remaining.Seconds(),
+			e.namespace, e.serviceAccount)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"istio.io/auth/certmanager"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSecretControllerCollector(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+
+	unmanaged := createSecret("user", "istio.user", "ns-a")
+	delete(unmanaged.Labels, managedByLabelKey)
+	for _, obj := range []interface{}{
+		createSecret("sa1", "istio.sa1", "ns-a"),
+		createSecret("sa2", "istio.sa2", "ns-a"),
+		createSecret("sa3", "istio.sa3", "ns-b"),
+		unmanaged,
+	} {
+		if err := controller.scrtStore.Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := controller.saStore.Add(createServiceAccount("sa1", "ns-a")); err != nil {
+		t.Fatal(err)
+	}
+	controller.enqueueUpsert("sa4", "ns-b")
+	controller.enqueueRefresh(createSecret("sa1", "istio.sa1", "ns-a"), time.Now(), false)
+	controller.enqueueRefresh(createSecret("sa2", "istio.sa2", "ns-a"), time.Now(), false)
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(controller.Collector())
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather the metrics: %v", err)
+	}
+
+	actual := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			name := f.GetName()
+			for _, l := range m.GetLabel() {
+				name += "/" + l.GetValue()
+			}
+			actual[name] = m.GetGauge().GetValue()
+		}
+	}
+	expected := map[string]float64{
+		"istio_ca_controller_cached_secrets":             4,
+		"istio_ca_controller_cached_service_accounts":    1,
+		"istio_ca_controller_managed_secrets/ns-a":       2,
+		"istio_ca_controller_managed_secrets/ns-b":       1,
+		"istio_ca_controller_pending_issuances/creation": 1,
+		"istio_ca_controller_pending_issuances/renewal":  2,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected metrics (expecting %v, actual %v)", expected, actual)
+	}
+}
+
+func TestManagedCertExpiry(t *testing.T) {
+	controller := NewSecretController(fakeCa{}, fake.NewSimpleClientset().CoreV1(), metav1.NamespaceAll,
+		SecretControllerOptions{MaxExpirySeries: 2})
+
+	malformed := createSecret("malformed", "istio.malformed", "ns-b")
+	for i, ttl := range []time.Duration{time.Hour, time.Minute, 10 * time.Minute} {
+		saName := []string{"sa1", "sa2", "sa3"}[i]
+		scrt := createSecret(saName, "istio."+saName, "ns-a")
+		scrt.Data[certChainID], _ = certmanager.GenCert(certmanager.CertOptions{
+			Host:         "spiffe://cluster.local/ns/ns-a/sa/" + saName,
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(ttl),
+			IsSelfSigned: true,
+			RSAKeySize:   512,
+		})
+		if err := controller.scrtStore.Add(scrt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := controller.scrtStore.Add(malformed); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(controller.Collector())
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather the metrics: %v", err)
+	}
+
+	// Only the two certificates expiring first are exported.
+	actual := map[string]float64{}
+	for _, f := range families {
+		if f.GetName() != "istio_ca_managed_cert_expiry_seconds" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			actual[m.GetLabel()[0].GetValue()+"/"+m.GetLabel()[1].GetValue()] = m.GetGauge().GetValue()
+		}
+	}
+	if len(actual) != 2 {
+		t.Fatalf("Expect 2 series but got %v", actual)
+	}
+	for name, ttl := range map[string]time.Duration{"ns-a/sa2": time.Minute, "ns-a/sa3": 10 * time.Minute} {
+		if v, ok := actual[name]; !ok || v > ttl.Seconds() || v < ttl.Seconds()-60 {
+			t.Errorf("Unexpected remaining lifetime of %s: %v (expecting about %v)", name, v, ttl.Seconds())
+		}
+	}
+}
+
+func TestRecordAPIRequest(t *testing.T) {
+	gr := schema.GroupResource{Resource: "secrets"}
+	testCases := map[string]struct {
+		verb           string
+		err            error
+		expectedResult string
+		expectFailure  bool
+	}{
+		"Successful request": {
+			verb:           "create",
+			expectedResult: "success",
+		},
+		"Deleting a missing secret succeeds": {
+			verb:           "delete",
+			err:            kerrors.NewNotFound(gr, "istio.test"),
+			expectedResult: "success",
+		},
+		"Conflict": {
+			verb:           "update",
+			err:            kerrors.NewConflict(gr, "istio.test", errors.New("conflict")),
+			expectedResult: "conflict",
+		},
+		"Failed request": {
+			verb:           "create",
+			err:            errors.New("unavailable"),
+			expectedResult: "error",
+			expectFailure:  true,
+		},
+	}
+
+	for k, tc := range testCases {
+		requests := apiRequests.WithLabelValues(tc.verb, tc.expectedResult)
+		failures := controllerFailures.WithLabelValues(failureAPI)
+		requestsBefore, failuresBefore := testutil.ToFloat64(requests), testutil.ToFloat64(failures)
+
+		recordAPIRequest(tc.verb, tc.err)
+
+		if d := testutil.ToFloat64(requests) - requestsBefore; d != 1 {
+			t.Errorf("%s: expect the %s/%s count to grow by 1 but it grew by %v", k, tc.verb, tc.expectedResult, d)
+		}
+		expectedFailures := 0.0
+		if tc.expectFailure {
+			expectedFailures = 1
+		}
+		if d := testutil.ToFloat64(failures) - failuresBefore; d != expectedFailures {
+			t.Errorf("%s: expect the API failure count to grow by %v but it grew by %v", k, expectedFailures, d)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// The annotation on a managed secret holding the version of its schema, i.e.
+// of the data keys and annotations it carries. Secrets without it predate
+// the versioning and are of version 1.
+const secretSchemaVersionAnnotationKey = "istio.io/secret-schema-version"
+
+// secretMigrations upgrade the managed secrets in place, where the migration
+// at index i upgrades a secret from schema version i+1 to i+2. The current
+// schema version is thus len(secretMigrations)+1.
+var secretMigrations = []func(sc *SecretController, scrt *v1.Secret){
+	// Version 2 describes the certificates with status annotations, and
+	// mirrors the data under the TLS keys if configured.
+	func(sc *SecretController, scrt *v1.Secret) {
+		setStatusAnnotations(scrt, scrt.Data[certChainID], scrt.Data[rootCertID])
+		sc.setTLSKeys(scrt)
+	},
+}
+
+var (
+	secretMigrationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "istio_ca",
+		Subsystem: "controller",
+		Name:      "secret_migrations_total",
+		Help:      "The number of Istio secrets migrated to the current schema version, by result ('success' or 'error').",
+	}, []string{"result"})
+
+	pendingSecretMigrations = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "istio_ca",
+		Subsystem: "controller",
+		Name:      "pending_secret_migrations",
+		Help:      "The number of Istio secrets left to migrate by the running migration pass.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(secretMigrationsTotal)
+	prometheus.MustRegister(pendingSecretMigrations)
+}
+
+func currentSecretSchemaVersion() int {
+	return len(secretMigrations) + 1
+}
+
+// secretSchemaVersion returns the schema version of the secret, or an error
+// if its annotation is malformed.
+func secretSchemaVersion(scrt *v1.Secret) (int, error) {
+	value, ok := scrt.Annotations[secretSchemaVersionAnnotationKey]
+	if !ok {
+		return 1, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("malformed schema version %q", value)
+	}
+	return version, nil
+}
+
+// setSchemaVersion annotates the secret, which is written in the current
+// schema, with the current schema version.
+func setSchemaVersion(scrt *v1.Secret) {
+	if scrt.Annotations == nil {
+		scrt.Annotations = map[string]string{}
+	}
+	scrt.Annotations[secretSchemaVersionAnnotationKey] = strconv.Itoa(currentSecretSchemaVersion())
+}
+
+// MigrateSecrets upgrades the managed secrets of older schema versions to the
+// current one in place, keeping their certificates and private keys. This
+// includes the unlabeled secrets that the controller adopts, e.g. written by
+// earlier versions without the ownership label, which are labeled along the
+// way. Secrets of newer versions, e.g. written by a newer controller before a
+// rollback, are left as they are. It returns the number of migrated secrets.
+func (sc *SecretController) MigrateSecrets() (int, error) {
+	selector := fields.SelectorFromSet(map[string]string{"type": istioSecretType}).String()
+	list, err := sc.core.Secrets(sc.namespace).List(metav1.ListOptions{FieldSelector: selector})
+	recordAPIRequest("list", err)
+	if err != nil {
+		return 0, err
+	}
+
+	current := currentSecretSchemaVersion()
+	outdated := []*v1.Secret{}
+	for i := range list.Items {
+		scrt := &list.Items[i]
+		if !sc.isOwned(scrt) {
+			continue
+		}
+		version, err := secretSchemaVersion(scrt)
+		if err != nil {
+			glog.Warningf("Secret %s/%s has a %v; migrating it from version 1", scrt.GetNamespace(), scrt.GetName(), err)
+			version = 1
+		}
+		if version > current {
+			glog.Warningf("Secret %s/%s is of schema version %d, newer than %d; leaving it as it is",
+				scrt.GetNamespace(), scrt.GetName(), version, current)
+		} else if version < current {
+			outdated = append(outdated, scrt)
+		}
+	}
+
+	pendingSecretMigrations.Set(float64(len(outdated)))
+	defer pendingSecretMigrations.Set(0)
+	var errs []error
+	migrated := 0
+	for _, scrt := range outdated {
+		_, err := updateSecret(sc.core, scrt, func(s *v1.Secret) bool {
+			if !sc.isOwned(s) {
+				return false
+			}
+			version, err := secretSchemaVersion(s)
+			if err != nil {
+				version = 1
+			} else if version >= current {
+				return false
+			}
+			if s.Data == nil {
+				s.Data = map[string][]byte{}
+			}
+			for _, migrate := range secretMigrations[version-1:] {
+				migrate(sc, s)
+			}
+			setSchemaVersion(s)
+			if s.Labels == nil {
+				s.Labels = map[string]string{}
+			}
+			s.Labels[managedByLabelKey] = managedByLabelValue
+			return true
+		})
+		pendingSecretMigrations.Dec()
+		if err != nil {
+			secretMigrationsTotal.WithLabelValues("error").Inc()
+			errs = append(errs, fmt.Errorf("failed to migrate secret %s/%s (error: %v)",
+				scrt.GetNamespace(), scrt.GetName(), err))
+			continue
+		}
+		secretMigrationsTotal.WithLabelValues("success").Inc()
+		migrated++
+	}
+
+	if migrated > 0 {
+		glog.Infof("Migrated %d Istio secrets to schema version %d", migrated, current)
+	}
+	return migrated, utilerrors.NewAggregate(errs)
+}
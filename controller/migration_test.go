@@ -0,0 +1,92 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestMigrateSecrets(t *testing.T) {
+	createVersionedSecret := func(sa, version string) *v1.Secret {
+		scrt := createSecret(sa, "istio."+sa, "test-ns")
+		if version == "" {
+			delete(scrt.Annotations, secretSchemaVersionAnnotationKey)
+		} else {
+			scrt.Annotations[secretSchemaVersionAnnotationKey] = version
+		}
+		return scrt
+	}
+	// Written by an earlier version of the controller without the ownership label.
+	unlabeled := createVersionedSecret("unlabeled", "")
+	unlabeled.Labels = nil
+	unmanaged := createVersionedSecret("unmanaged", "")
+	unmanaged.Labels = map[string]string{managedByLabelKey: managedByUserLabelValue}
+
+	client := fake.NewSimpleClientset(
+		createVersionedSecret("unversioned", ""),
+		createVersionedSecret("malformed", "latest"),
+		createVersionedSecret("current", "2"),
+		createVersionedSecret("newer", "3"),
+		unlabeled,
+		unmanaged,
+	)
+	controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll,
+		SecretControllerOptions{TLSKeys: true})
+
+	migrated, err := controller.MigrateSecrets()
+	if err != nil {
+		t.Fatalf("Failed to migrate the secrets: %v", err)
+	}
+	if migrated != 3 {
+		t.Errorf("Unexpected number of migrated secrets %d (expecting 3)", migrated)
+	}
+
+	testCases := map[string]struct {
+		expectedVersion string
+		expectMigrated  bool
+		expectedOwner   string
+	}{
+		"unversioned": {expectedVersion: "2", expectMigrated: true, expectedOwner: managedByLabelValue},
+		"malformed":   {expectedVersion: "2", expectMigrated: true, expectedOwner: managedByLabelValue},
+		"current":     {expectedVersion: "2", expectedOwner: managedByLabelValue},
+		"newer":       {expectedVersion: "3", expectedOwner: managedByLabelValue},
+		"unlabeled":   {expectedVersion: "2", expectMigrated: true, expectedOwner: managedByLabelValue},
+		"unmanaged":   {expectedOwner: managedByUserLabelValue},
+	}
+	for sa, tc := range testCases {
+		scrt, err := client.CoreV1().Secrets("test-ns").Get("istio."+sa, metav1.GetOptions{})
+		if err != nil {
+			t.Errorf("%s: failed to get the secret (error %v)", sa, err)
+			continue
+		}
+		if v := scrt.Annotations[secretSchemaVersionAnnotationKey]; v != tc.expectedVersion {
+			t.Errorf("%s: unexpected schema version %q (expecting %q)", sa, v, tc.expectedVersion)
+		}
+		// The migration to version 2 mirrors the data under the TLS keys.
+		if _, ok := scrt.Data[v1.TLSCertKey]; ok != tc.expectMigrated {
+			t.Errorf("%s: the secret should be migrated: %v, but it is: %v", sa, tc.expectMigrated, ok)
+		}
+		if string(scrt.Data[privateKeyID]) != "fake key" {
+			t.Errorf("%s: the private key should be kept", sa)
+		}
+		if owner := scrt.Labels[managedByLabelKey]; owner != tc.expectedOwner {
+			t.Errorf("%s: unexpected owner %q (expecting %q)", sa, owner, tc.expectedOwner)
+		}
+	}
+}
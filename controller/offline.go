@@ -0,0 +1,313 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"time"
+
+	"istio.io/auth/parse"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+/* #nosec: disable gas linter */
+const (
+	// The type of the secrets holding the private keys and the certificate
+	// signing requests exported for an offline CA until their certificates are
+	// imported. The secret controller does not watch them.
+	pendingSecretType       = "istio.io/pending-key-and-csr"
+	pendingSecretNameSuffix = ".pending"
+
+	// The annotation on a pending secret holding the identity the certificate is requested for.
+	pendingIDAnnotationKey = "istio.io/pending-id"
+
+	csrID = "csr.pem"
+
+	// The size of the private keys generated for the offline CA.
+	offlineKeySize = 2048
+)
+
+// OfflineRequest is a certificate signing request exported for a service
+// account, to be signed by a CA whose signing key is never online.
+type OfflineRequest struct {
+	Namespace      string `json:"namespace"`
+	ServiceAccount string `json:"serviceAccount"`
+
+	// The SPIFFE identity the certificate is requested for, e.g.
+	// spiffe://cluster.local/ns/default/sa/default.
+	ID string `json:"id"`
+
+	// The PEM-encoded certificate signing request.
+	CSR string `json:"csr"`
+}
+
+// OfflineCertificate is the certificate an offline CA signed for an OfflineRequest.
+type OfflineCertificate struct {
+	Namespace      string `json:"namespace"`
+	ServiceAccount string `json:"serviceAccount"`
+
+	// The PEM-encoded certificate chain, leaf first, and root certificate.
+	CertChain string `json:"certChain"`
+	RootCert  string `json:"rootCert"`
+}
+
+// ExportCSRs returns the certificate signing requests of the service accounts
+// in the namespace, or in all namespaces if it is empty, whose Istio secrets
+// are missing or expire within renewWithin. The private key and the request
+// of each service account are kept in a pending secret until ImportCertificates
+// consumes it, so that exporting again returns the same requests.
+func ExportCSRs(core corev1.CoreV1Interface, namespace, trustDomain string,
+	renewWithin time.Duration) ([]OfflineRequest, error) {
+
+	sas, err := core.ServiceAccounts(namespace).List(metav1.ListOptions{})
+	recordAPIRequest("list", err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list service accounts (error: %v)", err)
+	}
+
+	requests := []OfflineRequest{}
+	var errs []error
+	for _, sa := range sas.Items {
+		scrt, err := core.Secrets(sa.Namespace).Get(getSecretName(sa.Name), metav1.GetOptions{})
+		recordAPIRequest("get", err)
+		if err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to get the Istio secret of %s/%s (error: %v)",
+				sa.Namespace, sa.Name, err))
+			continue
+		}
+		if err == nil {
+			if cert, err := parseCertificate(scrt.Data[certChainID]); err == nil &&
+				time.Until(cert.NotAfter) > renewWithin {
+				continue
+			}
+		}
+
+		id := fmt.Sprintf("spiffe://%s/ns/%s/sa/%s", trustDomain, sa.Namespace, sa.Name)
+		csr, err := pendingCSR(core, sa.Namespace, sa.Name, id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to prepare the request of %s/%s (error: %v)",
+				sa.Namespace, sa.Name, err))
+			continue
+		}
+		requests = append(requests, OfflineRequest{
+			Namespace:      sa.Namespace,
+			ServiceAccount: sa.Name,
+			ID:             id,
+			CSR:            string(csr),
+		})
+	}
+	return requests, utilerrors.NewAggregate(errs)
+}
+
+// pendingCSR returns the certificate signing request in the pending secret of
+// the service account, creating the secret with a new private key if there is
+// none for the identity.
+func pendingCSR(core corev1.CoreV1Interface, namespace, saName, id string) ([]byte, error) {
+	name := getPendingSecretName(saName)
+	existing, err := core.Secrets(namespace).Get(name, metav1.GetOptions{})
+	recordAPIRequest("get", err)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	exists := err == nil
+	if exists && existing.Annotations[pendingIDAnnotationKey] == id {
+		return existing.Data[csrID], nil
+	}
+
+	key, csr, err := generateCSR(id)
+	if err != nil {
+		return nil, err
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				serviceAccountNameAnnotationKey: saName,
+				pendingIDAnnotationKey:          id,
+			},
+			Labels:    map[string]string{managedByLabelKey: managedByLabelValue},
+			Name:      name,
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			csrID:        csr,
+			privateKeyID: key,
+		},
+		Type: pendingSecretType,
+	}
+
+	if exists {
+		// The trust domain changed since the request was exported.
+		_, err = updateSecret(core, existing, func(s *v1.Secret) bool {
+			s.Annotations = secret.Annotations
+			s.Data = secret.Data
+			return true
+		})
+	} else {
+		_, err = core.Secrets(namespace).Create(secret)
+		recordAPIRequest("create", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return csr, nil
+}
+
+// ImportCertificates writes the certificates signed by an offline CA into the
+// Istio secrets of their service accounts, together with the private keys
+// kept by ExportCSRs, and returns how many secrets have been written. A
+// certificate is rejected unless it is issued for the identity and the key of
+// a pending request, and chains to its root certificate.
+func ImportCertificates(core corev1.CoreV1Interface, certs []OfflineCertificate) (int, error) {
+	imported := 0
+	var errs []error
+	for _, c := range certs {
+		if err := importCertificate(core, c); err != nil {
+			errs = append(errs, fmt.Errorf("failed to import the certificate of %s/%s (error: %v)",
+				c.Namespace, c.ServiceAccount, err))
+			continue
+		}
+		imported++
+	}
+	return imported, utilerrors.NewAggregate(errs)
+}
+
+func importCertificate(core corev1.CoreV1Interface, c OfflineCertificate) error {
+	pending, err := core.Secrets(c.Namespace).Get(getPendingSecretName(c.ServiceAccount), metav1.GetOptions{})
+	recordAPIRequest("get", err)
+	if errors.IsNotFound(err) {
+		return fmt.Errorf("no certificate signing request is pending")
+	}
+	if err != nil {
+		return err
+	}
+
+	chain, rootCert := []byte(c.CertChain), []byte(c.RootCert)
+	if err := verifyOfflineCertificate(pending, chain, rootCert); err != nil {
+		return err
+	}
+
+	name := getSecretName(c.ServiceAccount)
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{serviceAccountNameAnnotationKey: c.ServiceAccount},
+			Labels:      map[string]string{managedByLabelKey: managedByLabelValue},
+			Name:        name,
+			Namespace:   c.Namespace,
+		},
+		Data: map[string][]byte{
+			certChainID:  chain,
+			privateKeyID: pending.Data[privateKeyID],
+			rootCertID:   rootCert,
+		},
+		Type: istioSecretType,
+	}
+	setStatusAnnotations(secret, chain, rootCert)
+	setSchemaVersion(secret)
+
+	existing, err := core.Secrets(c.Namespace).Get(name, metav1.GetOptions{})
+	recordAPIRequest("get", err)
+	switch {
+	case err == nil:
+		_, err = updateSecret(core, existing, func(s *v1.Secret) bool {
+			s.Annotations = secret.Annotations
+			s.Labels = secret.Labels
+			s.Data = secret.Data
+			return true
+		})
+	case errors.IsNotFound(err):
+		_, err = core.Secrets(c.Namespace).Create(secret)
+		recordAPIRequest("create", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = core.Secrets(c.Namespace).Delete(pending.Name, nil)
+	recordAPIRequest("delete", err)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete the pending secret (error: %v)", err)
+	}
+	return nil
+}
+
+// verifyOfflineCertificate returns an error unless the leaf of the chain
+// certifies the public key and the identity of the pending secret, and the
+// chain verifies against the root certificate.
+func verifyOfflineCertificate(pending *v1.Secret, chain, rootCert []byte) error {
+	certs, err := parse.CertificateChain(chain)
+	if err != nil {
+		return err
+	}
+	csr, err := parse.CSR(pending.Data[csrID])
+	if err != nil {
+		return fmt.Errorf("the pending certificate signing request is malformed (error: %v)", err)
+	}
+
+	certKey, err := x509.MarshalPKIXPublicKey(certs[0].PublicKey)
+	if err != nil {
+		return err
+	}
+	csrKey, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(certKey, csrKey) {
+		return fmt.Errorf("the certificate does not certify the key of the pending request")
+	}
+
+	id := pending.Annotations[pendingIDAnnotationKey]
+	if len(certs[0].URIs) != 1 || certs[0].URIs[0].String() != id {
+		return fmt.Errorf("the certificate is not issued for %s", id)
+	}
+	if !chainVerifies(chain, rootCert) {
+		return fmt.Errorf("the certificate chain does not verify against the root certificate")
+	}
+	return nil
+}
+
+// generateCSR returns a new PEM-encoded RSA private key and a certificate
+// signing request for the identity.
+func generateCSR(id string) (key, csr []byte, err error) {
+	uri, err := url.Parse(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	priv, err := rsa.GenerateKey(rand.Reader, offlineKeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{URIs: []*url.URL{uri}}, priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	key = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	csr = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	return key, csr, nil
+}
+
+func getPendingSecretName(saName string) string {
+	return getSecretName(saName) + pendingSecretNameSuffix
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"crypto/x509/pkix"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestOfflineSigning(t *testing.T) {
+	ca, err := certmanager.NewSelfSignedIstioCA(&certmanager.SelfSignedIstioCAOptions{
+		CACertTTL:  time.Hour,
+		CertTTL:    30 * time.Minute,
+		Subject:    pkix.Name{Organization: []string{"test.ca.org"}},
+		MaxPathLen: -1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+	fresh := createSecret("fresh", "istio.fresh", "default")
+	fresh.Data[certChainID], _, _ = ca.Generate("fresh", "default")
+	client := fake.NewSimpleClientset(
+		createServiceAccount("fresh", "default"),
+		createServiceAccount("new", "default"),
+		createServiceAccount("other", "other"),
+		fresh,
+	)
+	core := client.CoreV1()
+
+	requests, err := ExportCSRs(core, "default", "cluster.local", 10*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to export the requests: %v", err)
+	}
+	if len(requests) != 1 || requests[0].ServiceAccount != "new" ||
+		requests[0].ID != "spiffe://cluster.local/ns/default/sa/new" {
+		t.Fatalf("Unexpected requests: %v", requests)
+	}
+
+	again, err := ExportCSRs(core, "default", "cluster.local", 10*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to export the requests again: %v", err)
+	}
+	if !reflect.DeepEqual(again, requests) {
+		t.Errorf("Exporting again returned different requests: %v", again)
+	}
+
+	chain, err := ca.Sign([]byte(requests[0].CSR))
+	if err != nil {
+		t.Fatalf("Failed to sign the request: %v", err)
+	}
+	rootCert := string(ca.GetRootCertificate())
+	otherChain, _, _ := ca.Generate("new", "default")
+
+	testCases := map[string]struct {
+		cert        OfflineCertificate
+		expectedErr string
+	}{
+		"Not pending": {
+			cert:        OfflineCertificate{Namespace: "default", ServiceAccount: "fresh"},
+			expectedErr: "no certificate signing request is pending",
+		},
+		"Another key": {
+			cert: OfflineCertificate{
+				Namespace: "default", ServiceAccount: "new", CertChain: string(otherChain), RootCert: rootCert,
+			},
+			expectedErr: "does not certify the key",
+		},
+		"Another root": {
+			cert: OfflineCertificate{
+				Namespace: "default", ServiceAccount: "new", CertChain: string(chain), RootCert: string(otherChain),
+			},
+			expectedErr: "does not verify",
+		},
+	}
+	for id, tc := range testCases {
+		n, err := ImportCertificates(core, []OfflineCertificate{tc.cert})
+		if n != 0 || err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+			t.Errorf("%s: expected error %q, got %d imported (error: %v)", id, tc.expectedErr, n, err)
+		}
+	}
+
+	cert := OfflineCertificate{Namespace: "default", ServiceAccount: "new", CertChain: string(chain), RootCert: rootCert}
+	if n, err := ImportCertificates(core, []OfflineCertificate{cert}); n != 1 || err != nil {
+		t.Fatalf("Failed to import the certificate: %d imported (error: %v)", n, err)
+	}
+	scrt, err := core.Secrets("default").Get("istio.new", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get the imported secret: %v", err)
+	}
+	if string(scrt.Data[certChainID]) != string(chain) || scrt.Type != istioSecretType ||
+		scrt.Labels[managedByLabelKey] != managedByLabelValue {
+		t.Errorf("Unexpected imported secret: %v", scrt)
+	}
+	if _, err := core.Secrets("default").Get("istio.new.pending", metav1.GetOptions{}); err == nil {
+		t.Error("The pending secret is not deleted after the import")
+	}
+}
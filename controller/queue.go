@@ -0,0 +1,178 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// issuanceRequest is a pending certificate issuance for an Istio secret.
+type issuanceRequest struct {
+	// The namespace/name of the secret; at most one request per key is pending.
+	key string
+
+	// The expiry of the certificate being renewed, or zero for initial provisioning.
+	deadline time.Time
+
+	// The order in which the request is enqueued.
+	seq uint64
+
+	run func()
+
+	index int
+}
+
+// isRenewal returns true if the request renews an existing certificate.
+func (r *issuanceRequest) isRenewal() bool {
+	return !r.deadline.IsZero()
+}
+
+// issuanceQueue is a thread-safe priority queue of issuance requests.
+// Renewals are served before initial provisioning, the ones whose
+// certificates expire first at the front, so that a burst of new service
+// accounts cannot starve certificates that are about to expire. Initial
+// provisioning requests are served in FIFO order.
+type issuanceQueue struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+
+	requests requestHeap
+	pending  map[string]*issuanceRequest
+	seq      uint64
+	shutdown bool
+}
+
+func newIssuanceQueue() *issuanceQueue {
+	q := &issuanceQueue{pending: map[string]*issuanceRequest{}}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+// Add enqueues an issuance for the key. If a request for the key is already
+// pending, run replaces its work, and the request is moved forward if the new
+// deadline makes it more urgent.
+func (q *issuanceQueue) Add(key string, deadline time.Time, run func()) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.shutdown {
+		return
+	}
+
+	if r, ok := q.pending[key]; ok {
+		r.run = run
+		if !deadline.IsZero() && (r.deadline.IsZero() || deadline.Before(r.deadline)) {
+			r.deadline = deadline
+			heap.Fix(&q.requests, r.index)
+		}
+		return
+	}
+
+	q.seq++
+	r := &issuanceRequest{key: key, deadline: deadline, seq: q.seq, run: run}
+	q.pending[key] = r
+	heap.Push(&q.requests, r)
+	q.cond.Signal()
+}
+
+// Get blocks until a request is available and removes it from the queue. It
+// returns false once the queue is shut down.
+func (q *issuanceQueue) Get() (*issuanceRequest, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for len(q.requests) == 0 && !q.shutdown {
+		q.cond.Wait()
+	}
+	if q.shutdown {
+		return nil, false
+	}
+
+	r := heap.Pop(&q.requests).(*issuanceRequest)
+	delete(q.pending, r.key)
+	return r, true
+}
+
+// Len returns the number of pending requests.
+func (q *issuanceQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.requests)
+}
+
+// Counts returns the numbers of pending initial provisionings and renewals.
+func (q *issuanceQueue) Counts() (creations, renewals int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, r := range q.requests {
+		if r.isRenewal() {
+			renewals++
+		} else {
+			creations++
+		}
+	}
+	return
+}
+
+// ShutDown drops the pending requests and unblocks the callers of Get.
+func (q *issuanceQueue) ShutDown() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.shutdown = true
+	q.requests = nil
+	q.pending = map[string]*issuanceRequest{}
+	q.cond.Broadcast()
+}
+
+// requestHeap implements heap.Interface over issuance requests.
+type requestHeap []*issuanceRequest
+
+func (h requestHeap) Len() int { return len(h) }
+
+func (h requestHeap) Less(i, j int) bool {
+	a, b := h[i], h[j]
+	if a.isRenewal() != b.isRenewal() {
+		return a.isRenewal()
+	}
+	if a.isRenewal() && !a.deadline.Equal(b.deadline) {
+		return a.deadline.Before(b.deadline)
+	}
+	return a.seq < b.seq
+}
+
+func (h requestHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *requestHeap) Push(x interface{}) {
+	r := x.(*issuanceRequest)
+	r.index = len(*h)
+	*h = append(*h, r)
+}
+
+func (h *requestHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	r := old[n-1]
+	*h = old[:n-1]
+	return r
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type queuedRequest struct {
+	key      string
+	deadline time.Time
+}
+
+func TestIssuanceQueue(t *testing.T) {
+	now := time.Now()
+
+	testCases := map[string]struct {
+		requests      []queuedRequest
+		expectedOrder []string
+	}{
+		"Initial provisioning is served in FIFO order": {
+			requests: []queuedRequest{
+				{key: "ns/a"},
+				{key: "ns/b"},
+				{key: "ns/c"},
+			},
+			expectedOrder: []string{"ns/a", "ns/b", "ns/c"},
+		},
+		"Renewals are served before initial provisioning": {
+			requests: []queuedRequest{
+				{key: "ns/new-1"},
+				{key: "ns/new-2"},
+				{key: "ns/renew", deadline: now.Add(time.Hour)},
+			},
+			expectedOrder: []string{"ns/renew", "ns/new-1", "ns/new-2"},
+		},
+		"Renewals expiring sooner are served first": {
+			requests: []queuedRequest{
+				{key: "ns/later", deadline: now.Add(time.Hour)},
+				{key: "ns/sooner", deadline: now.Add(time.Minute)},
+			},
+			expectedOrder: []string{"ns/sooner", "ns/later"},
+		},
+		"Duplicate requests are merged and take the more urgent deadline": {
+			requests: []queuedRequest{
+				{key: "ns/a", deadline: now.Add(time.Hour)},
+				{key: "ns/b", deadline: now.Add(30 * time.Minute)},
+				{key: "ns/a", deadline: now.Add(time.Minute)},
+				{key: "ns/b"},
+			},
+			expectedOrder: []string{"ns/a", "ns/b"},
+		},
+	}
+
+	for id, tc := range testCases {
+		q := newIssuanceQueue()
+		served := []string{}
+		for _, r := range tc.requests {
+			key := r.key
+			q.Add(key, r.deadline, func() { served = append(served, key) })
+		}
+
+		for q.Len() > 0 {
+			r, ok := q.Get()
+			if !ok {
+				t.Fatalf("%s: queue is unexpectedly shut down", id)
+			}
+			r.run()
+		}
+
+		if !reflect.DeepEqual(served, tc.expectedOrder) {
+			t.Errorf("%s: unexpected order (expecting %v, actual %v)", id, tc.expectedOrder, served)
+		}
+	}
+}
+
+func TestIssuanceQueueShutDown(t *testing.T) {
+	q := newIssuanceQueue()
+	done := make(chan bool)
+	go func() {
+		_, ok := q.Get()
+		done <- ok
+	}()
+
+	q.ShutDown()
+	if ok := <-done; ok {
+		t.Error("Get should return false after the queue is shut down")
+	}
+
+	q.Add("ns/a", time.Time{}, func() {})
+	if q.Len() != 0 {
+		t.Error("Requests added after shutdown should be dropped")
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/auth/certmanager"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// The reason of the events recorded when a namespace exceeds its issuance quota.
+	quotaExceededEventReason = "IssuanceQuotaExceeded"
+
+	// The minimum interval between the quota events of a namespace and a
+	// quota, so that a misbehaving namespace does not flood its events.
+	quotaEventInterval = 10 * time.Minute
+)
+
+// reportQuotaExceeded records a warning event on the service account if err
+// is a certmanager.QuotaExceededError, so that the owners of the namespace
+// find out why its workloads get no certificates.
+func (sc *SecretController) reportQuotaExceeded(saName, saNamespace string, err error) {
+	qe, ok := err.(*certmanager.QuotaExceededError)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	key := qe.Namespace + "/" + qe.Quota
+	sc.quotaEventsMutex.Lock()
+	if last, ok := sc.quotaEvents[key]; ok && now.Sub(last) < quotaEventInterval {
+		sc.quotaEventsMutex.Unlock()
+		return
+	}
+	sc.quotaEvents[key] = now
+	sc.quotaEventsMutex.Unlock()
+
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", saName, now.UnixNano()),
+			Namespace: saNamespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind:       "ServiceAccount",
+			APIVersion: "v1",
+			Name:       saName,
+			Namespace:  saNamespace,
+		},
+		Reason:         quotaExceededEventReason,
+		Message:        fmt.Sprintf("No certificate has been issued for service account %s: %v", saName, err),
+		Source:         v1.EventSource{Component: "istio-ca"},
+		FirstTimestamp: metav1.NewTime(now),
+		LastTimestamp:  metav1.NewTime(now),
+		Count:          1,
+		Type:           v1.EventTypeWarning,
+	}
+	_, err = sc.core.Events(saNamespace).Create(event)
+	recordAPIRequest("create", err)
+	if err != nil {
+		glog.Errorf("Failed to record the quota event of namespace %s (error: %v)", saNamespace, err)
+	}
+}
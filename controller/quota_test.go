@@ -0,0 +1,97 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"istio.io/auth/certmanager"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// quotaCa is a fake CA refusing to issue certificates for namespace "full".
+type quotaCa struct {
+	fakeCa
+}
+
+func (ca quotaCa) Generate(name, namespace string) (chain, key []byte, err error) {
+	if namespace == "full" {
+		return nil, nil, &certmanager.QuotaExceededError{
+			Namespace: namespace,
+			Quota:     certmanager.QuotaActiveIdentities,
+			Limit:     10,
+		}
+	}
+	return ca.fakeCa.Generate(name, namespace)
+}
+
+func TestReportQuotaExceeded(t *testing.T) {
+	testCases := map[string]struct {
+		namespace       string
+		serviceAccounts []string
+		expectedEvents  int
+	}{
+		"Namespace within its quota": {
+			namespace:       "ok",
+			serviceAccounts: []string{"foo"},
+		},
+		"Namespace exceeding its quota": {
+			namespace:       "full",
+			serviceAccounts: []string{"foo"},
+			expectedEvents:  1,
+		},
+		"Repeated quota events are suppressed": {
+			namespace:       "full",
+			serviceAccounts: []string{"foo", "bar", "foo"},
+			expectedEvents:  1,
+		},
+	}
+
+	for k, tc := range testCases {
+		client := fake.NewSimpleClientset()
+		controller := NewSecretController(quotaCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+		for _, sa := range tc.serviceAccounts {
+			controller.upsertSecret(sa, tc.namespace)
+		}
+
+		var events []*v1.Event
+		for _, a := range client.Actions() {
+			if a.GetVerb() == "create" && a.GetResource().Resource == "events" {
+				events = append(events, a.(ktesting.CreateAction).GetObject().(*v1.Event))
+			}
+		}
+		if len(events) != tc.expectedEvents {
+			t.Errorf("%s: expect %d events but got %v", k, tc.expectedEvents, events)
+			continue
+		}
+		for _, e := range events {
+			if e.Reason != quotaExceededEventReason || e.Type != v1.EventTypeWarning {
+				t.Errorf("%s: unexpected event %+v", k, e)
+			}
+			if e.InvolvedObject.Kind != "ServiceAccount" || e.InvolvedObject.Name != "foo" ||
+				e.InvolvedObject.Namespace != tc.namespace {
+				t.Errorf("%s: unexpected involved object %+v", k, e.InvolvedObject)
+			}
+			if !strings.Contains(e.Message, "namespace full exceeds its quota of 10 active identities") {
+				t.Errorf("%s: unexpected event message %q", k, e.Message)
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	authorizationv1beta1 "k8s.io/client-go/kubernetes/typed/authorization/v1beta1"
+	authorization "k8s.io/client-go/pkg/apis/authorization/v1beta1"
+	rbac "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+)
+
+// The RBAC rules below are kept next to the API calls of the controllers, so
+// that the generated deployment manifests grant Istio CA no more than it uses.
+
+// SecretControllerRules returns the RBAC rules needed by a SecretController in
+// the namespaces it listens to.
+func SecretControllerRules() []rbac.PolicyRule {
+	return []rbac.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"secrets"},
+			Verbs:     []string{"get", "list", "watch", "create", "update", "delete"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"serviceaccounts"},
+			Verbs:     []string{"list", "watch"},
+		},
+	}
+}
+
+// TrustDomainRules returns the cluster-wide RBAC rules needed by a
+// SecretController with additional trust domains or signing profiles, which
+// watches the namespaces.
+func TrustDomainRules() []rbac.PolicyRule {
+	return []rbac.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"namespaces"},
+			Verbs:     []string{"list", "watch"},
+		},
+	}
+}
+
+// IssuanceQuotaRules returns the RBAC rules needed by a SecretController in
+// the namespaces it listens to, to record the events of the namespaces
+// exceeding their issuance quotas.
+func IssuanceQuotaRules() []rbac.PolicyRule {
+	return []rbac.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"events"},
+			Verbs:     []string{"create"},
+		},
+	}
+}
+
+// MTLSReadinessRules returns the cluster-wide RBAC rules needed to annotate
+// the namespaces with their mutual TLS readiness.
+func MTLSReadinessRules() []rbac.PolicyRule {
+	return []rbac.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"namespaces"},
+			Verbs:     []string{"get", "update"},
+		},
+	}
+}
+
+// ServerCertControllerRules returns the RBAC rules needed by a ServerCertController.
+func ServerCertControllerRules() []rbac.PolicyRule {
+	return []rbac.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"secrets"},
+			Verbs:     []string{"get", "create", "update", "delete"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"services"},
+			Verbs:     []string{"list", "watch"},
+		},
+		{
+			APIGroups: []string{"extensions"},
+			Resources: []string{"ingresses"},
+			Verbs:     []string{"list", "watch"},
+		},
+	}
+}
+
+// DiscoveryControllerRules returns the RBAC rules needed by a
+// DiscoveryController publishing in the named ConfigMap. A create can not be
+// restricted to a resource name, so only the updates are.
+func DiscoveryControllerRules(name string) []rbac.PolicyRule {
+	return []rbac.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"configmaps"},
+			Verbs:     []string{"create"},
+		},
+		{
+			APIGroups:     []string{""},
+			Resources:     []string{"configmaps"},
+			ResourceNames: []string{name},
+			Verbs:         []string{"get", "update"},
+		},
+	}
+}
+
+// SigningSecretRules returns the RBAC rules needed to load and watch the named
+// signing secret. The secret is watched with a field selector, which does not
+// restrict the list and watch to its name.
+func SigningSecretRules(name string) []rbac.PolicyRule {
+	return []rbac.PolicyRule{
+		{
+			APIGroups:     []string{""},
+			Resources:     []string{"secrets"},
+			ResourceNames: []string{name},
+			Verbs:         []string{"get"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"secrets"},
+			Verbs:     []string{"list", "watch"},
+		},
+	}
+}
+
+// MissingPermissions returns the part of the rules that the caller is not
+// allowed in the namespace, or cluster-wide if the namespace is empty, as
+// reported by SelfSubjectAccessReviews. The returned rules have one verb each.
+func MissingPermissions(reviews authorizationv1beta1.SelfSubjectAccessReviewInterface, namespace string,
+	rules []rbac.PolicyRule) ([]rbac.PolicyRule, error) {
+
+	missing := []rbac.PolicyRule{}
+	for _, r := range rules {
+		names := r.ResourceNames
+		if len(names) == 0 {
+			names = []string{""}
+		}
+		for _, group := range r.APIGroups {
+			for _, resource := range r.Resources {
+				for _, verb := range r.Verbs {
+					for _, name := range names {
+						review, err := reviews.Create(&authorization.SelfSubjectAccessReview{
+							Spec: authorization.SelfSubjectAccessReviewSpec{
+								ResourceAttributes: &authorization.ResourceAttributes{
+									Namespace: namespace,
+									Verb:      verb,
+									Group:     group,
+									Resource:  resource,
+									Name:      name,
+								},
+							},
+						})
+						if err != nil {
+							return nil, fmt.Errorf("failed to review the access to %s %s (error: %v)",
+								verb, describeResource(group, resource), err)
+						}
+						if review.Status.Allowed {
+							continue
+						}
+						m := rbac.PolicyRule{APIGroups: []string{group}, Resources: []string{resource}, Verbs: []string{verb}}
+						if name != "" {
+							m.ResourceNames = []string{name}
+						}
+						missing = append(missing, m)
+					}
+				}
+			}
+		}
+	}
+	return missing, nil
+}
+
+// DescribeRules returns a short description of the rules like
+// "create,get configmaps, list namespaces".
+func DescribeRules(rules []rbac.PolicyRule) string {
+	descs := []string{}
+	for _, r := range rules {
+		for _, group := range r.APIGroups {
+			for _, resource := range r.Resources {
+				desc := strings.Join(r.Verbs, ",") + " " + describeResource(group, resource)
+				if len(r.ResourceNames) > 0 {
+					desc += "/" + strings.Join(r.ResourceNames, ",")
+				}
+				descs = append(descs, desc)
+			}
+		}
+	}
+	sort.Strings(descs)
+	return strings.Join(descs, ", ")
+}
+
+func describeResource(group, resource string) string {
+	if group == "" {
+		return resource
+	}
+	return resource + "." + group
+}
@@ -0,0 +1,194 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	authorization "k8s.io/client-go/pkg/apis/authorization/v1beta1"
+	rbac "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+	ktesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+// allows returns true if one of the rules authorizes the action. As in
+// Kubernetes, a rule restricted to resource names never authorizes a create,
+// list or watch.
+func allows(rules []rbac.PolicyRule, a ktesting.Action) bool {
+	// Get and delete actions carry the name, update actions the object.
+	name := ""
+	switch a := a.(type) {
+	case ktesting.GetAction:
+		name = a.GetName()
+	case ktesting.UpdateAction:
+		if m, err := meta.Accessor(a.GetObject()); err == nil && a.GetVerb() == "update" {
+			name = m.GetName()
+		}
+	}
+
+	for _, r := range rules {
+		if !sets.NewString(r.APIGroups...).Has(a.GetResource().Group) ||
+			!sets.NewString(r.Resources...).Has(a.GetResource().Resource) ||
+			!sets.NewString(r.Verbs...).Has(a.GetVerb()) {
+			continue
+		}
+		if len(r.ResourceNames) == 0 || (name != "" && sets.NewString(r.ResourceNames...).Has(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// runInformers runs the informers until each of them lists and watches its resource.
+func runInformers(t *testing.T, client *fake.Clientset, informers ...cache.Controller) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	for _, i := range informers {
+		go i.Run(stopCh)
+	}
+	err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		watches := 0
+		for _, a := range client.Actions() {
+			if a.GetVerb() == "watch" {
+				watches++
+			}
+		}
+		return watches == len(informers), nil
+	})
+	if err != nil {
+		t.Fatalf("The informers have not started watching (error: %v)", err)
+	}
+}
+
+func checkActions(t *testing.T, name string, rules []rbac.PolicyRule, client *fake.Clientset) {
+	if len(client.Actions()) == 0 {
+		t.Errorf("%s: no API requests have been made", name)
+	}
+	for _, a := range client.Actions() {
+		if !allows(rules, a) {
+			t.Errorf("%s: the RBAC rules do not allow %s %s/%s", name, a.GetVerb(), a.GetResource().Group,
+				a.GetResource().Resource)
+		}
+	}
+}
+
+func TestSecretControllerRules(t *testing.T) {
+	client := fake.NewSimpleClientset(createSecret("stale", "istio.stale", "test-ns"))
+	sc := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{
+		TrustDomains: map[string]certmanager.CertificateAuthority{"tenant.com": fakeCa{}},
+	})
+	runInformers(t, client, sc.scrtController, sc.saController, sc.nsController)
+
+	sc.saAdded(createServiceAccount("new", "test-ns"))
+	sc.saUpdated(createServiceAccount("old", "test-ns"), createServiceAccount("renamed", "test-ns"))
+	// "fake cert chain" is not a PEM-encoded certificate, so the secret is refreshed.
+	sc.scrtUpdated(nil, createSecret("stale", "istio.stale", "test-ns"))
+	drainQueue(sc)
+
+	checkActions(t, "SecretController", append(SecretControllerRules(), TrustDomainRules()...), client)
+}
+
+func TestIssuanceQuotaRules(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	sc := NewSecretController(quotaCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+	sc.upsertSecret("foo", "full")
+
+	checkActions(t, "issuance quotas", append(SecretControllerRules(), IssuanceQuotaRules()...), client)
+}
+
+func TestMTLSReadinessRules(t *testing.T) {
+	sc, client := newReadinessTestController(t)
+	sc.reportMTLSReadiness(sc.MTLSReadiness(0))
+
+	checkActions(t, "mTLS readiness", MTLSReadinessRules(), client)
+}
+
+func TestServerCertControllerRules(t *testing.T) {
+	annotations := map[string]string{tlsSecretAnnotationKey: "gateway-certs"}
+	client := fake.NewSimpleClientset(createServerCertSecret("ingress/ing", "ingress-certs", "ns"))
+	scc := NewServerCertController(fakeCa{}, client.CoreV1(), client.ExtensionsV1beta1(), metav1.NamespaceAll)
+	runInformers(t, client, scc.ingressController, scc.serviceController)
+
+	scc.serviceUpserted(&v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Annotations: annotations, Name: "svc", Namespace: "ns"},
+	})
+	ing := createIngress("ing", "ns", map[string]string{tlsSecretAnnotationKey: "ingress-certs"}, "foo.com")
+	scc.ingressUpserted(ing)
+	scc.ingressDeleted(ing)
+
+	checkActions(t, "ServerCertController", ServerCertControllerRules(), client)
+}
+
+func TestDiscoveryControllerRules(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	dc := NewDiscoveryController(fakeCa{}, client.CoreV1(), "istio-system", "istio-ca", "istio-ca:8060")
+	dc.publish()
+	dc.address = "istio-ca.istio-system:8060"
+	dc.publish()
+
+	checkActions(t, "DiscoveryController", DiscoveryControllerRules("istio-ca"), client)
+}
+
+func TestSigningSecretRules(t *testing.T) {
+	client := fake.NewSimpleClientset(createSigningSecret("cert"))
+	c := NewSigningSecretController(&fakeUpdater{}, client.CoreV1(), "istio-system", "cacerts", nil)
+	if _, err := LoadSigningMaterial(client.CoreV1(), "istio-system", "cacerts"); err != nil {
+		t.Fatal(err)
+	}
+	runInformers(t, client, c.controller)
+
+	checkActions(t, "SigningSecretController", SigningSecretRules("cacerts"), client)
+}
+
+func TestMissingPermissions(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	// Only the discovery ConfigMap can be read in istio-system.
+	client.PrependReactor("create", "selfsubjectaccessreviews",
+		func(action ktesting.Action) (bool, runtime.Object, error) {
+			review := action.(ktesting.CreateAction).GetObject().(*authorization.SelfSubjectAccessReview)
+			attrs := review.Spec.ResourceAttributes
+			review.Status.Allowed = attrs.Namespace == "istio-system" && attrs.Resource == "configmaps" &&
+				attrs.Verb == "get" && attrs.Name == "istio-ca"
+			return true, review, nil
+		})
+
+	missing, err := MissingPermissions(client.AuthorizationV1beta1().SelfSubjectAccessReviews(), "istio-system",
+		DiscoveryControllerRules("istio-ca"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []rbac.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"create"}},
+		{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"update"},
+			ResourceNames: []string{"istio-ca"}},
+	}
+	if !reflect.DeepEqual(missing, expected) {
+		t.Errorf("expect missing permissions %v but got %v", expected, missing)
+	}
+	if desc, expected := DescribeRules(missing), "create configmaps, update configmaps/istio-ca"; desc != expected {
+		t.Errorf("expect description %q but got %q", expected, desc)
+	}
+}
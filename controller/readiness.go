@@ -0,0 +1,209 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// The annotations on a namespace recording whether all of its service
+// accounts hold valid and fresh certificates, so that operators can tell when
+// the namespace is safe to switch from permissive to strict mutual TLS.
+const (
+	// "true" if all the service accounts of the namespace are ready, "false" otherwise.
+	mtlsReadyAnnotationKey = "istio.io/mtls-ready"
+	// The ready and the total service accounts of the namespace, e.g. "4/5".
+	mtlsReadyServiceAccountsAnnotationKey = "istio.io/mtls-ready-service-accounts"
+)
+
+var (
+	mtlsUnreadyServiceAccounts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "istio_ca",
+		Subsystem: "controller",
+		Name:      "mtls_unready_service_accounts",
+		Help:      "The number of service accounts without a valid and fresh certificate, by namespace.",
+	}, []string{"namespace"})
+
+	mtlsReady = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "istio_ca",
+		Subsystem: "controller",
+		Name:      "mtls_ready",
+		Help: "1 if all the service accounts hold a valid and fresh certificate, so that the mesh is safe to " +
+			"switch to strict mutual TLS, 0 otherwise.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(mtlsUnreadyServiceAccounts)
+	prometheus.MustRegister(mtlsReady)
+}
+
+// NamespaceReadiness describes whether the service accounts of a namespace
+// hold valid and fresh certificates.
+type NamespaceReadiness struct {
+	Namespace string
+
+	// The number of service accounts in the namespace, and the names of
+	// those without a valid and fresh certificate.
+	ServiceAccounts int
+	Unready         []string
+}
+
+// Ready returns true if all the service accounts of the namespace are ready.
+func (r NamespaceReadiness) Ready() bool {
+	return len(r.Unready) == 0
+}
+
+// MTLSReadiness returns the readiness of the namespaces with service
+// accounts, read from the informer caches and ordered by namespace. A service
+// account is ready if its Istio secret holds a certificate that is valid for
+// at least minRemaining and verifies against the current root certificate of
+// the CA of its namespace.
+func (sc *SecretController) MTLSReadiness(minRemaining time.Duration) []NamespaceReadiness {
+	now := time.Now()
+	perNamespace := map[string]*NamespaceReadiness{}
+	roots := map[string][]byte{}
+	for _, obj := range sc.saStore.List() {
+		sa, ok := obj.(*v1.ServiceAccount)
+		if !ok {
+			continue
+		}
+		r, ok := perNamespace[sa.Namespace]
+		if !ok {
+			r = &NamespaceReadiness{Namespace: sa.Namespace}
+			perNamespace[sa.Namespace] = r
+		}
+		r.ServiceAccounts++
+
+		root, ok := roots[sa.Namespace]
+		if !ok {
+			if ca, err := sc.caFor(sa.Namespace); err == nil {
+				root = ca.GetRootCertificate()
+			}
+			roots[sa.Namespace] = root
+		}
+		if !sc.serviceAccountReady(sa.Name, sa.Namespace, root, now.Add(minRemaining)) {
+			r.Unready = append(r.Unready, sa.Name)
+		}
+	}
+
+	readiness := make([]NamespaceReadiness, 0, len(perNamespace))
+	for _, r := range perNamespace {
+		sort.Strings(r.Unready)
+		readiness = append(readiness, *r)
+	}
+	sort.Slice(readiness, func(i, j int) bool { return readiness[i].Namespace < readiness[j].Namespace })
+	return readiness
+}
+
+// serviceAccountReady returns true if the Istio secret of the service account
+// holds a certificate valid until the deadline that verifies against the root.
+func (sc *SecretController) serviceAccountReady(saName, namespace string, root []byte, deadline time.Time) bool {
+	obj, exists, err := sc.scrtStore.GetByKey(namespace + "/" + getSecretName(saName))
+	if err != nil || !exists || root == nil {
+		return false
+	}
+	scrt, ok := obj.(*v1.Secret)
+	if !ok || !sc.isManaged(scrt) {
+		return false
+	}
+	cert, err := parseCertificate(scrt.Data[certChainID])
+	if err != nil || time.Now().Before(cert.NotBefore) || !deadline.Before(cert.NotAfter) {
+		return false
+	}
+	return chainVerifies(scrt.Data[certChainID], root)
+}
+
+// RunMTLSReadiness evaluates the readiness of the namespaces every interval
+// once the caches are synced, until stopCh is closed, reports it in the metrics, and annotates the
+// namespaces with it.
+func (sc *SecretController) RunMTLSReadiness(interval, minRemaining time.Duration, stopCh <-chan struct{}) {
+	// Service accounts missing from unsynced caches would be reported as ready.
+	if !cache.WaitForCacheSync(stopCh, sc.saController.HasSynced, sc.scrtController.HasSynced) {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+		sc.reportMTLSReadiness(sc.MTLSReadiness(minRemaining))
+	}
+}
+
+func (sc *SecretController) reportMTLSReadiness(readiness []NamespaceReadiness) {
+	mtlsUnreadyServiceAccounts.Reset()
+	ready := 1.0
+	for _, r := range readiness {
+		mtlsUnreadyServiceAccounts.WithLabelValues(r.Namespace).Set(float64(len(r.Unready)))
+		if !r.Ready() {
+			ready = 0
+		}
+		if err := sc.annotateNamespaceReadiness(r); err != nil {
+			glog.Errorf("Failed to annotate namespace %s with its mutual TLS readiness (error: %v)", r.Namespace, err)
+		}
+	}
+	mtlsReady.Set(ready)
+}
+
+// annotateNamespaceReadiness annotates the namespace with its readiness,
+// unless it already carries the same annotations.
+func (sc *SecretController) annotateNamespaceReadiness(r NamespaceReadiness) error {
+	ns, err := sc.core.Namespaces().Get(r.Namespace, metav1.GetOptions{})
+	recordAPIRequest("get", err)
+	if err != nil {
+		return err
+	}
+
+	annotations := map[string]string{
+		mtlsReadyAnnotationKey:                fmt.Sprintf("%t", r.Ready()),
+		mtlsReadyServiceAccountsAnnotationKey: fmt.Sprintf("%d/%d", r.ServiceAccounts-len(r.Unready), r.ServiceAccounts),
+	}
+	changed := false
+	for k, v := range annotations {
+		if ns.Annotations[k] != v {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	if ns.Annotations == nil {
+		ns.Annotations = map[string]string{}
+	}
+	for k, v := range annotations {
+		ns.Annotations[k] = v
+	}
+	_, err = sc.core.Namespaces().Update(ns)
+	recordAPIRequest("update", err)
+	if err == nil && !r.Ready() {
+		glog.Infof("Namespace %s is not ready for strict mutual TLS: %d of %d service accounts lack a valid "+
+			"and fresh certificate (%v)", r.Namespace, len(r.Unready), r.ServiceAccounts, r.Unready)
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"crypto/x509/pkix"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"istio.io/auth/certmanager"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// newReadinessTestController returns a controller whose caches hold the
+// service accounts foo, bar and baz in namespace ns-a, and qux in ns-b. Only
+// foo holds a certificate of the CA of the controller, bar holds one of
+// another CA, and baz and qux hold none.
+func newReadinessTestController(t *testing.T) (*SecretController, *fake.Clientset) {
+	newCA := func() *certmanager.IstioCA {
+		ca, err := certmanager.NewSelfSignedIstioCA(&certmanager.SelfSignedIstioCAOptions{
+			CACertTTL:  time.Hour,
+			CertTTL:    30 * time.Minute,
+			Subject:    pkix.Name{Organization: []string{"test.ca.org"}},
+			MaxPathLen: -1,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create a self-signed CA: %v", err)
+		}
+		return ca
+	}
+	ca, other := newCA(), newCA()
+
+	client := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-a"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-b"}})
+	sc := NewSecretController(ca, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+	for _, sa := range []*v1.ServiceAccount{
+		createServiceAccount("foo", "ns-a"),
+		createServiceAccount("bar", "ns-a"),
+		createServiceAccount("baz", "ns-a"),
+		createServiceAccount("qux", "ns-b"),
+	} {
+		if err := sc.saStore.Add(sa); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for saName, ca := range map[string]*certmanager.IstioCA{"foo": ca, "bar": other} {
+		s := createSecret(saName, getSecretName(saName), "ns-a")
+		s.Data[certChainID], _, _ = ca.Generate(saName, "ns-a")
+		s.Data[rootCertID] = ca.GetRootCertificate()
+		if err := sc.scrtStore.Add(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sc, client
+}
+
+func TestMTLSReadiness(t *testing.T) {
+	sc, _ := newReadinessTestController(t)
+	testCases := map[string]struct {
+		minRemaining time.Duration
+		expected     []NamespaceReadiness
+	}{
+		"Fresh": {
+			minRemaining: 10 * time.Minute,
+			expected: []NamespaceReadiness{
+				{Namespace: "ns-a", ServiceAccounts: 3, Unready: []string{"bar", "baz"}},
+				{Namespace: "ns-b", ServiceAccounts: 1, Unready: []string{"qux"}},
+			},
+		},
+		"Expiring": {
+			minRemaining: time.Hour,
+			expected: []NamespaceReadiness{
+				{Namespace: "ns-a", ServiceAccounts: 3, Unready: []string{"bar", "baz", "foo"}},
+				{Namespace: "ns-b", ServiceAccounts: 1, Unready: []string{"qux"}},
+			},
+		},
+	}
+
+	for k, tc := range testCases {
+		if readiness := sc.MTLSReadiness(tc.minRemaining); !reflect.DeepEqual(readiness, tc.expected) {
+			t.Errorf("%s: unexpected readiness %+v (expecting %+v)", k, readiness, tc.expected)
+		}
+	}
+}
+
+func TestReportMTLSReadiness(t *testing.T) {
+	sc, client := newReadinessTestController(t)
+	readiness := []NamespaceReadiness{
+		{Namespace: "ns-a", ServiceAccounts: 3, Unready: []string{"bar"}},
+		{Namespace: "ns-b", ServiceAccounts: 1},
+	}
+	sc.reportMTLSReadiness(readiness)
+
+	expectedAnnotations := map[string]map[string]string{
+		"ns-a": {mtlsReadyAnnotationKey: "false", mtlsReadyServiceAccountsAnnotationKey: "2/3"},
+		"ns-b": {mtlsReadyAnnotationKey: "true", mtlsReadyServiceAccountsAnnotationKey: "1/1"},
+	}
+	for name, expected := range expectedAnnotations {
+		ns, err := client.CoreV1().Namespaces().Get(name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ns.Annotations, expected) {
+			t.Errorf("Unexpected annotations %v of namespace %s (expecting %v)", ns.Annotations, name, expected)
+		}
+	}
+	if v := testutil.ToFloat64(mtlsUnreadyServiceAccounts.WithLabelValues("ns-a")); v != 1 {
+		t.Errorf("Unexpected number of unready service accounts %v in ns-a (expecting 1)", v)
+	}
+	if v := testutil.ToFloat64(mtlsReady); v != 0 {
+		t.Errorf("Unexpected mutual TLS readiness %v (expecting 0)", v)
+	}
+
+	// The annotations are only updated when the readiness changes.
+	client.ClearActions()
+	sc.reportMTLSReadiness(readiness)
+	for _, a := range client.Actions() {
+		if a.GetVerb() == "update" {
+			t.Errorf("Unexpected update of unchanged readiness: %v", a)
+		}
+	}
+
+	readiness[0].Unready = nil
+	sc.reportMTLSReadiness(readiness)
+	if v := testutil.ToFloat64(mtlsReady); v != 1 {
+		t.Errorf("Unexpected mutual TLS readiness %v (expecting 1)", v)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// The maximum number of attempts to write a secret that keeps conflicting.
+	maxUpdateAttempts = 5
+
+	// The base delay before retrying a conflicting write, doubled on each retry.
+	conflictRetryDelay = 10 * time.Millisecond
+)
+
+// secretMutator modifies a secret before it is written. It returns false if
+// the secret must not be written, e.g. because it is no longer managed.
+type secretMutator func(scrt *v1.Secret) bool
+
+// updateSecret applies mutate to a copy of the secret and writes the copy
+// with the resource version of the secret as a precondition. On a conflict,
+// i.e. when the secret has been changed concurrently, the latest version is
+// fetched and mutate is applied to it again, so that no concurrent change is
+// lost. The given secret, usually owned by an informer cache, is never modified.
+func updateSecret(core corev1.CoreV1Interface, scrt *v1.Secret, mutate secretMutator) (*v1.Secret, error) {
+	namespace, name := scrt.GetNamespace(), scrt.GetName()
+	current := scrt
+	delay := conflictRetryDelay
+
+	for attempt := 1; ; attempt++ {
+		obj, err := api.Scheme.Copy(current)
+		if err != nil {
+			return nil, err
+		}
+		updated := obj.(*v1.Secret)
+		if !mutate(updated) {
+			return current, nil
+		}
+
+		result, err := core.Secrets(namespace).Update(updated)
+		recordAPIRequest("update", err)
+		if err == nil {
+			return result, nil
+		}
+		if !errors.IsConflict(err) {
+			return nil, err
+		}
+		if attempt == maxUpdateAttempts {
+			return nil, fmt.Errorf("secret %s/%s is still conflicting after %d attempts (error: %v)",
+				namespace, name, attempt, err)
+		}
+
+		glog.Infof("Secret %s/%s has been modified concurrently, retrying (attempt %d)", namespace, name, attempt)
+		// #nosec: the jitter does not need a cryptographically secure source.
+		time.Sleep(delay + time.Duration(rand.Int63n(int64(delay))))
+		delay *= 2
+
+		current, err = core.Secrets(namespace).Get(name, metav1.GetOptions{})
+		recordAPIRequest("get", err)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestUpdateSecretWithRetry(t *testing.T) {
+	gr := schema.GroupResource{Resource: "secrets"}
+
+	testCases := map[string]struct {
+		conflicts     int
+		skip          bool
+		expectedVerbs []string
+		expectError   bool
+	}{
+		"Update without conflict": {
+			expectedVerbs: []string{"update"},
+		},
+		"Update retries on conflict with the latest version": {
+			conflicts:     2,
+			expectedVerbs: []string{"update", "get", "update", "get", "update"},
+		},
+		"Update gives up on persistent conflicts": {
+			conflicts:     maxUpdateAttempts,
+			expectedVerbs: []string{"update", "get", "update", "get", "update", "get", "update", "get", "update"},
+			expectError:   true,
+		},
+		"Mutator can skip the write": {
+			skip:          true,
+			expectedVerbs: []string{},
+		},
+	}
+
+	for id, tc := range testCases {
+		cached := createSecret("test", "istio.test", "test-ns")
+		latest := createSecret("test", "istio.test", "test-ns")
+		latest.Annotations["edited-by"] = "user"
+		client := fake.NewSimpleClientset(latest)
+
+		conflicts := tc.conflicts
+		client.PrependReactor("update", "secrets", func(ktesting.Action) (bool, runtime.Object, error) {
+			if conflicts == 0 {
+				return false, nil, nil
+			}
+			conflicts--
+			return true, nil, errors.NewConflict(gr, "istio.test", nil)
+		})
+
+		_, err := updateSecret(client.CoreV1(), cached, func(s *v1.Secret) bool {
+			s.Data[certChainID] = []byte("new cert chain")
+			return !tc.skip
+		})
+		if (err != nil) != tc.expectError {
+			t.Errorf("%s: unexpected error %v", id, err)
+		}
+
+		verbs := []string{}
+		for _, a := range client.Actions() {
+			verbs = append(verbs, a.GetVerb())
+		}
+		if !reflect.DeepEqual(verbs, tc.expectedVerbs) {
+			t.Errorf("%s: expect verbs %v but actual verbs are %v", id, tc.expectedVerbs, verbs)
+		}
+		if string(cached.Data[certChainID]) != "fake cert chain" {
+			t.Errorf("%s: the cached secret has been modified", id)
+		}
+
+		if tc.conflicts > 0 && !tc.expectError {
+			stored, err := client.CoreV1().Secrets("test-ns").Get("istio.test", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("%s: failed to get the secret: %v", id, err)
+			}
+			if stored.Annotations["edited-by"] != "user" || string(stored.Data[certChainID]) != "new cert chain" {
+				t.Errorf("%s: the concurrent change is lost or the update is not applied: %v", id, stored)
+			}
+		}
+	}
+}
@@ -0,0 +1,418 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// The phases of a staged root rollout.
+const (
+	RolloutIdle        = "idle"
+	RolloutPropagating = "propagating"
+	RolloutRollingOut  = "rolling-out"
+	RolloutPaused      = "paused"
+	RolloutCompleted   = "completed"
+	RolloutAborted     = "aborted"
+)
+
+// The interval at which the progress of a rollout step is polled.
+const rolloutPollInterval = 5 * time.Second
+
+// RootRotator is a CA supporting staged root rotations, e.g. a self-signed
+// certmanager.IstioCA.
+type RootRotator interface {
+	StartStagedRootRotation() error
+	RolloutRoot(namespace string) error
+	CompleteStagedRootRotation() error
+	AbortStagedRootRotation()
+	GetStagedRootCertificate() []byte
+}
+
+// MetricGate is a health gate of a staged root rollout, which fails if the
+// sum of a metric has grown by more than MaxIncrease since the gate was opened.
+type MetricGate struct {
+	Gatherer    prometheus.Gatherer
+	Metric      string
+	MaxIncrease float64
+}
+
+// sum returns the sum of the values of the metric across its label values.
+func (g *MetricGate) sum() (float64, error) {
+	families, err := g.Gatherer.Gather()
+	if err != nil {
+		return 0, err
+	}
+	total := 0.0
+	for _, f := range families {
+		if f.GetName() != g.Metric {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			total += m.GetCounter().GetValue() + m.GetGauge().GetValue() + m.GetUntyped().GetValue()
+		}
+	}
+	return total, nil
+}
+
+// RootRolloutOptions holds the configurations of a RootRollout.
+type RootRolloutOptions struct {
+	// The namespaces rolled out first, in order. The other namespaces follow
+	// in alphabetical order.
+	Canaries []string
+
+	// The time to observe a rolled out namespace before checking its health
+	// and rolling out the next one.
+	Interval time.Duration
+
+	// The health gate checked after each namespace; nil to only check that
+	// the certificates of the namespace have been re-issued.
+	Gate *MetricGate
+}
+
+// RootRolloutStatus describes the progress of a staged root rollout.
+type RootRolloutStatus struct {
+	Phase string
+
+	// The namespace being rolled out, if any.
+	Current string
+
+	// The namespaces whose certificates have been re-issued by the new root.
+	RolledOut []string
+
+	// The error that paused or stopped the rollout, if any.
+	Error string
+}
+
+// RootRollout rotates the root of a CA in stages rather than re-issuing all
+// the certificates at once: 1) the new root is added to the trust bundle,
+// which is pushed to all the managed Istio secrets without re-issuing their
+// certificates, 2) the certificates are re-issued by the new root namespace by
+// namespace, canaries first, and each namespace is observed for an interval
+// and health-checked before the next one, and 3) the rotation is completed,
+// which removes the old root from the trust bundle. A failed health check
+// pauses the rollout until it is resumed or aborted.
+type RootRollout struct {
+	ca   RootRotator
+	sc   *SecretController
+	opts RootRolloutOptions
+
+	// The interval at which the progress of a step is polled.
+	pollInterval time.Duration
+
+	mutex  sync.Mutex
+	status RootRolloutStatus
+	resume chan struct{}
+	abort  chan struct{}
+}
+
+// NewRootRollout returns a pointer to a RootRollout of the root of ca, whose
+// Istio secrets are managed by sc.
+func NewRootRollout(ca RootRotator, sc *SecretController, opts RootRolloutOptions) *RootRollout {
+	return &RootRollout{
+		ca:           ca,
+		sc:           sc,
+		opts:         opts,
+		pollInterval: rolloutPollInterval,
+		status:       RootRolloutStatus{Phase: RolloutIdle},
+	}
+}
+
+// Status returns the progress of the rollout.
+func (r *RootRollout) Status() RootRolloutStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	st := r.status
+	st.RolledOut = append([]string{}, r.status.RolledOut...)
+	return st
+}
+
+// Start starts a staged root rotation of the CA and rolls it out in the background.
+func (r *RootRollout) Start() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.running() {
+		return fmt.Errorf("the root rollout is already %s", r.status.Phase)
+	}
+	if err := r.ca.StartStagedRootRotation(); err != nil {
+		return err
+	}
+	r.status = RootRolloutStatus{Phase: RolloutPropagating}
+	r.resume = make(chan struct{}, 1)
+	r.abort = make(chan struct{})
+	go r.run(r.resume, r.abort)
+	return nil
+}
+
+// Resume resumes a paused rollout with the next namespace.
+func (r *RootRollout) Resume() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.status.Phase != RolloutPaused {
+		return fmt.Errorf("the root rollout is %s, not %s", r.status.Phase, RolloutPaused)
+	}
+	select {
+	case r.resume <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Abort stops the rollout and discards the new root. The certificates
+// already re-issued by the new root are re-issued by the old root again.
+func (r *RootRollout) Abort() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.running() {
+		return fmt.Errorf("the root rollout is %s", r.status.Phase)
+	}
+	close(r.abort)
+	r.ca.AbortStagedRootRotation()
+	r.status.Phase = RolloutAborted
+	r.status.Current = ""
+	glog.Info("Aborted the root rollout")
+	return nil
+}
+
+// running must be called with the lock held.
+func (r *RootRollout) running() bool {
+	switch r.status.Phase {
+	case RolloutPropagating, RolloutRollingOut, RolloutPaused:
+		return true
+	}
+	return false
+}
+
+func (r *RootRollout) run(resume, abort chan struct{}) {
+	newRoot := r.ca.GetStagedRootCertificate()
+
+	// Wait for the trust bundle holding the new root to reach all the secrets.
+	glog.Info("Root rollout: propagating the trust bundle with the new root")
+	if !r.waitUntil(abort, func() bool {
+		return r.sc.countSecrets("", func(s *v1.Secret) bool { return !pemContains(s.Data[rootCertID], newRoot) }) == 0
+	}) {
+		return
+	}
+
+	for _, ns := range r.namespaces() {
+		if !r.setStatus(abort, func(st *RootRolloutStatus) {
+			st.Phase = RolloutRollingOut
+			st.Current = ns
+			st.Error = ""
+		}) {
+			return
+		}
+		if err := r.rollout(ns, newRoot, abort); err != nil {
+			if err == errRolloutAborted {
+				return
+			}
+			glog.Errorf("Root rollout: pausing after namespace %s (error: %v)", ns, err)
+			if !r.setStatus(abort, func(st *RootRolloutStatus) {
+				st.Phase = RolloutPaused
+				st.Error = err.Error()
+			}) {
+				return
+			}
+			select {
+			case <-resume:
+				glog.Infof("Root rollout: resuming after namespace %s", ns)
+			case <-abort:
+				return
+			}
+		}
+		r.setStatus(abort, func(st *RootRolloutStatus) {
+			st.RolledOut = append(st.RolledOut, ns)
+		})
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.status.Phase == RolloutAborted {
+		return
+	}
+	if err := r.ca.CompleteStagedRootRotation(); err != nil {
+		r.status.Error = err.Error()
+	}
+	r.status.Phase = RolloutCompleted
+	r.status.Current = ""
+	glog.Info("Root rollout: completed")
+}
+
+var errRolloutAborted = errors.New("the root rollout has been aborted")
+
+// rollout re-issues the certificates of the namespace by the new root, then
+// observes the namespace for the interval and checks its health.
+func (r *RootRollout) rollout(namespace string, newRoot []byte, abort chan struct{}) error {
+	glog.Infof("Root rollout: re-issuing the certificates of namespace %s", namespace)
+	if err := r.ca.RolloutRoot(namespace); err != nil {
+		return err
+	}
+	baseline := 0.0
+	if r.opts.Gate != nil {
+		var err error
+		if baseline, err = r.opts.Gate.sum(); err != nil {
+			return fmt.Errorf("failed to read metric %s (error: %v)", r.opts.Gate.Metric, err)
+		}
+	}
+	r.sc.reissue(namespace, func(ManagedIdentity) bool { return true })
+
+	select {
+	case <-time.After(r.opts.Interval):
+	case <-abort:
+		return errRolloutAborted
+	}
+
+	if n := r.sc.countSecrets(namespace, func(s *v1.Secret) bool {
+		return !chainVerifies(s.Data[certChainID], newRoot)
+	}); n > 0 {
+		return fmt.Errorf("%d secrets of namespace %s have not been re-issued by the new root", n, namespace)
+	}
+	if r.opts.Gate != nil {
+		current, err := r.opts.Gate.sum()
+		if err != nil {
+			return fmt.Errorf("failed to read metric %s (error: %v)", r.opts.Gate.Metric, err)
+		}
+		if increase := current - baseline; increase > r.opts.Gate.MaxIncrease {
+			return fmt.Errorf("metric %s has increased by %v (at most %v)", r.opts.Gate.Metric, increase,
+				r.opts.Gate.MaxIncrease)
+		}
+	}
+	return nil
+}
+
+// namespaces returns the namespaces to roll out, canaries first.
+func (r *RootRollout) namespaces() []string {
+	seen := map[string]bool{}
+	namespaces := []string{}
+	for _, ns := range r.opts.Canaries {
+		if !seen[ns] {
+			seen[ns] = true
+			namespaces = append(namespaces, ns)
+		}
+	}
+	others := []string{}
+	for _, ns := range r.sc.managedNamespaces() {
+		if !seen[ns] {
+			seen[ns] = true
+			others = append(others, ns)
+		}
+	}
+	sort.Strings(others)
+	return append(namespaces, others...)
+}
+
+// waitUntil polls done until it returns true, and returns false if the rollout is aborted first.
+func (r *RootRollout) waitUntil(abort chan struct{}, done func() bool) bool {
+	for !done() {
+		select {
+		case <-time.After(r.pollInterval):
+		case <-abort:
+			return false
+		}
+	}
+	return true
+}
+
+// setStatus updates the status unless the rollout is aborted, and returns false if it is.
+func (r *RootRollout) setStatus(abort chan struct{}, update func(*RootRolloutStatus)) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	select {
+	case <-abort:
+		return false
+	default:
+	}
+	update(&r.status)
+	return true
+}
+
+// countSecrets returns the number of managed Istio secrets that match, in the
+// namespace or in all namespaces if it is empty. Only the namespaces whose
+// certificates are issued by the default CA are considered.
+func (sc *SecretController) countSecrets(namespace string, match func(*v1.Secret) bool) int {
+	n := 0
+	for _, obj := range sc.scrtStore.List() {
+		scrt, ok := obj.(*v1.Secret)
+		if !ok || !sc.isManaged(scrt) || (namespace != "" && scrt.GetNamespace() != namespace) ||
+			!sc.usesDefaultCA(scrt.GetNamespace()) {
+			continue
+		}
+		if match(scrt) {
+			n++
+		}
+	}
+	return n
+}
+
+// managedNamespaces returns the namespaces of the managed Istio secrets whose
+// certificates are issued by the default CA.
+func (sc *SecretController) managedNamespaces() []string {
+	seen := map[string]bool{}
+	namespaces := []string{}
+	for _, obj := range sc.scrtStore.List() {
+		scrt, ok := obj.(*v1.Secret)
+		if !ok || !sc.isManaged(scrt) || seen[scrt.GetNamespace()] {
+			continue
+		}
+		seen[scrt.GetNamespace()] = true
+		if sc.usesDefaultCA(scrt.GetNamespace()) {
+			namespaces = append(namespaces, scrt.GetNamespace())
+		}
+	}
+	return namespaces
+}
+
+func (sc *SecretController) usesDefaultCA(namespace string) bool {
+	ca, err := sc.caFor(namespace)
+	return err == nil && ca == sc.ca
+}
+
+// pemContains returns true if all the PEM blocks of b are in a.
+func pemContains(a, b []byte) bool {
+	blocks := map[string]bool{}
+	for rest := a; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		blocks[string(block.Bytes)] = true
+	}
+	found := false
+	for rest := b; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			return found
+		}
+		if !blocks[string(block.Bytes)] {
+			return false
+		}
+		found = true
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"istio.io/auth/certmanager"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// newRolloutTest returns a controller managing a secret in each of the
+// namespaces, signed by a self-signed CA, and a function syncing the cache of
+// the controller with the API and serving the resulting issuances.
+func newRolloutTest(t *testing.T, namespaces ...string) (*certmanager.IstioCA, *SecretController, func()) {
+	ca, err := certmanager.NewSelfSignedIstioCA(&certmanager.SelfSignedIstioCAOptions{
+		CACertTTL:  time.Hour,
+		CertTTL:    30 * time.Minute,
+		Subject:    pkix.Name{Organization: []string{"test.ca.org"}},
+		MaxPathLen: -1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+	client := fake.NewSimpleClientset()
+	sc := NewSecretController(ca, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+	for _, ns := range namespaces {
+		sc.upsertSecret("default", ns)
+	}
+
+	sync := func() {
+		list, err := client.CoreV1().Secrets(metav1.NamespaceAll).List(metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("Failed to list the secrets: %v", err)
+		}
+		for i := range list.Items {
+			scrt := &list.Items[i]
+			slimSecret(scrt)
+			if err := sc.scrtStore.Update(scrt); err != nil {
+				t.Fatalf("Failed to update the cache: %v", err)
+			}
+			sc.scrtUpdated(nil, scrt)
+		}
+		drainQueue(sc)
+	}
+	sync()
+	return ca, sc, sync
+}
+
+// waitForPhase syncs the controller until the rollout reaches the phase.
+func waitForPhase(t *testing.T, r *RootRollout, sync func(), phase string) RootRolloutStatus {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		sync()
+		st := r.Status()
+		if st.Phase == phase {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("The rollout has not reached phase %s: %+v", phase, st)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRootRollout(t *testing.T) {
+	ca, sc, sync := newRolloutTest(t, "ns1", "ns2", "ns3")
+	oldRoot := ca.GetRootCertificate()
+
+	r := NewRootRollout(ca, sc, RootRolloutOptions{Canaries: []string{"ns2"}, Interval: 100 * time.Millisecond})
+	r.pollInterval = time.Millisecond
+	if err := r.Start(); err != nil {
+		t.Fatalf("Failed to start the rollout: %v", err)
+	}
+	if err := r.Start(); err == nil {
+		t.Error("Starting a running rollout should fail")
+	}
+	newRoot := ca.GetStagedRootCertificate()
+
+	st := waitForPhase(t, r, sync, RolloutCompleted)
+	if expected := []string{"ns2", "ns1", "ns3"}; !reflect.DeepEqual(st.RolledOut, expected) || st.Error != "" {
+		t.Errorf("Unexpected status %+v (expecting namespaces %v to be rolled out)", st, expected)
+	}
+	if root := ca.GetRootCertificate(); !bytes.Equal(root, newRoot) || bytes.Equal(root, oldRoot) {
+		t.Error("Expect the new root to be the only root once the rollout completes")
+	}
+
+	// The trust bundles are replaced by the new root.
+	sync()
+	sync()
+	if n := sc.countSecrets("", func(s *v1.Secret) bool {
+		return !chainVerifies(s.Data[certChainID], newRoot) || !samePEM(s.Data[rootCertID], newRoot)
+	}); n != 0 {
+		t.Errorf("Expect all secrets to be issued by and hold the new root only, but %d do not", n)
+	}
+}
+
+func TestRootRolloutHealthGate(t *testing.T) {
+	ca, sc, sync := newRolloutTest(t, "ns1", "ns2")
+
+	registry := prometheus.NewRegistry()
+	errors := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_errors_total", Help: "Test errors."})
+	registry.MustRegister(errors)
+	r := NewRootRollout(ca, sc, RootRolloutOptions{
+		Interval: 50 * time.Millisecond,
+		Gate:     &MetricGate{Gatherer: registry, Metric: "test_errors_total"},
+	})
+	r.pollInterval = time.Millisecond
+	if err := r.Start(); err != nil {
+		t.Fatalf("Failed to start the rollout: %v", err)
+	}
+
+	// The errors increase while the first namespace is observed.
+	waitForPhase(t, r, sync, RolloutRollingOut)
+	errors.Inc()
+	st := waitForPhase(t, r, sync, RolloutPaused)
+	if st.Current != "ns1" || st.Error == "" || len(st.RolledOut) != 0 {
+		t.Errorf("Unexpected status of the paused rollout %+v", st)
+	}
+	if err := r.Resume(); err != nil {
+		t.Fatalf("Failed to resume the rollout: %v", err)
+	}
+
+	st = waitForPhase(t, r, sync, RolloutCompleted)
+	if expected := []string{"ns1", "ns2"}; !reflect.DeepEqual(st.RolledOut, expected) {
+		t.Errorf("Unexpected status %+v (expecting namespaces %v to be rolled out)", st, expected)
+	}
+	if err := r.Resume(); err == nil {
+		t.Error("Resuming a completed rollout should fail")
+	}
+}
+
+func TestAbortRootRollout(t *testing.T) {
+	ca, sc, sync := newRolloutTest(t, "ns1")
+	oldRoot := ca.GetRootCertificate()
+
+	r := NewRootRollout(ca, sc, RootRolloutOptions{Interval: time.Hour})
+	r.pollInterval = time.Millisecond
+	if err := r.Start(); err != nil {
+		t.Fatalf("Failed to start the rollout: %v", err)
+	}
+	waitForPhase(t, r, sync, RolloutRollingOut)
+	if err := r.Abort(); err != nil {
+		t.Fatalf("Failed to abort the rollout: %v", err)
+	}
+	if st := r.Status(); st.Phase != RolloutAborted {
+		t.Errorf("Unexpected status of the aborted rollout %+v", st)
+	}
+	if root := ca.GetRootCertificate(); !bytes.Equal(root, oldRoot) {
+		t.Error("Expect the old root to be the only root once the rollout is aborted")
+	}
+
+	// The secret re-issued by the new root is re-issued by the old root again.
+	sync()
+	sync()
+	if n := sc.countSecrets("", func(s *v1.Secret) bool {
+		return !chainVerifies(s.Data[certChainID], oldRoot) || !samePEM(s.Data[rootCertID], oldRoot)
+	}); n != 0 {
+		t.Errorf("Expect all secrets to be issued by and hold the old root only, but %d do not", n)
+	}
+	if err := r.Abort(); err == nil {
+		t.Error("Aborting an aborted rollout should fail")
+	}
+}
@@ -16,12 +16,21 @@ package controller
 
 import (
 	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"hash/fnv"
+	"math/big"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
 
 	"istio.io/auth/certmanager"
+	"istio.io/auth/kms"
+	"istio.io/auth/parse"
+	"istio.io/auth/pkg/requestid"
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -39,34 +48,161 @@ const (
 	istioSecretType    = "istio.io/key-and-cert"
 	secretResyncPeriod = time.Minute
 
+	// The window before expiry across which the secrets without a renewal hint
+	// are rotated, each at its own point in the window.
+	secretRotationGracePeriod = 10 * time.Minute
+
 	serviceAccountNameAnnotationKey = "istio.io/service-account.name"
 
+	// The RFC 3339 time at which the private key of a secret is generated,
+	// which is only maintained when private keys are reused across rotations.
+	keyCreatedAtAnnotationKey = "istio.io/key-created-at"
+
+	// The label on the Istio secrets managed by the controller. The secrets
+	// orphaned on shutdown are relabeled as managed by their users.
+	managedByLabelKey       = "istio.io/managed-by"
+	managedByLabelValue     = "istio-ca"
+	managedByUserLabelValue = "user"
+
 	certChainID  = "cert-chain.pem"
 	privateKeyID = "key.pem"
 	rootCertID   = "root-cert.pem"
+
+	// The data key of the KMS envelope of the private key, which replaces
+	// privateKeyID when the secrets are encrypted.
+	encryptedPrivateKeyID = "key.pem.enc"
 )
 
+// Maps from the Istio data keys to the data keys of kubernetes.io/tls secrets
+// they are mirrored under when SecretControllerOptions.TLSKeys is set.
+var tlsDataKeys = map[string]string{
+	certChainID:  v1.TLSCertKey,
+	privateKeyID: v1.TLSPrivateKeyKey,
+	rootCertID:   tlsRootCertID,
+}
+
+// SecretControllerOptions holds the configurations of a SecretController.
+type SecretControllerOptions struct {
+	// Whether to take over the Istio secrets that carry neither the ownership
+	// label nor the service account annotation. Otherwise, such secrets are
+	// considered user-managed and are never updated, re-created or deleted.
+	// The unlabeled secrets written by earlier versions of the controller,
+	// which carry the annotation, are always taken over, and only the secrets
+	// labeled with another owner are left alone.
+	AdoptUnlabeled bool
+
+	// Whether to relabel the managed secrets as managed by their users when
+	// the controller stops, leaving the secrets to them.
+	OrphanOnShutdown bool
+
+	// The CAs of additional trust domains, keyed by trust domain. The
+	// workloads of a namespace labeled with `istio.io/trust-domain` get their
+	// certificates from the CA of that trust domain, and the others from the
+	// default CA.
+	TrustDomains map[string]certmanager.CertificateAuthority
+
+	// The trust domain of the default CA, which namespaces may be labeled with
+	// like the additional trust domains.
+	DefaultTrustDomain string
+
+	// The signing profiles of the default trust domain, keyed by name, see
+	// SigningProfile.
+	SigningProfiles map[string]SigningProfile
+
+	// If not nil, the private keys are envelope-encrypted with this KMS key
+	// and only the envelope is written to the secrets, so that the keys cannot
+	// be read from etcd without access to the KMS key.
+	KeyEncryption kms.KMS
+
+	// Whether to re-certify the private key of a secret when its certificate
+	// is rotated, rather than generating a new key on every rotation. A key is
+	// regenerated anyway once it is older than MaxKeyAge, if MaxKeyAge is not
+	// zero, and when its certificate has been revoked.
+	ReuseKeys bool
+	MaxKeyAge time.Duration
+
+	// Whether to prefix the certificates in the secrets with comments naming
+	// their identity, issuer and expiry, for debugging on the hosts.
+	PEMComments bool
+
+	// Whether to also write the certificate chain, the private key and the
+	// root certificate under the data keys of kubernetes.io/tls secrets, for a
+	// transition window in which consumers move to those keys while running
+	// sidecars still read the Istio keys. The private key is not mirrored if
+	// it is encrypted. The mirrored keys are dropped from a secret on its next
+	// update once the option is turned off.
+	TLSKeys bool
+
+	// Whether to drop the duplicated certificates and the root certificates
+	// from the certificate chains written to the secrets, which reduces the
+	// size of the secrets and of the handshakes of the workloads. The roots
+	// remain available under their own data key. Chains already written are
+	// minimized on their next rotation.
+	MinimalChains bool
+
+	// The maximum number of certificates whose remaining lifetime is exported
+	// by the collector of the controller, one series per service account. If
+	// there are more, those expiring first are exported. 0 disables the metric.
+	MaxExpirySeries int
+
+	// Additional names of the data keys, keyed by Istio data key, for
+	// applications expecting the certificates under hard-coded names, e.g.
+	// `key.pem=tls.key`. The data is written under both names, since the
+	// Istio data keys are still read by the sidecars and the controller. An
+	// encrypted private key is not aliased.
+	DataKeyAliases map[string]string
+}
+
 // SecretController manages the service accounts' secrets that contains Istio keys and certificates.
+// Only the secrets carrying the `istio.io/managed-by: istio-ca` label are managed.
 type SecretController struct {
-	ca   certmanager.CertificateAuthority
-	core corev1.CoreV1Interface
+	ca        certmanager.CertificateAuthority
+	core      corev1.CoreV1Interface
+	namespace string
+	opts      SecretControllerOptions
 
 	// Controller and store for service account objects.
 	saController cache.Controller
 	saStore      cache.Store
 
-	// Controller and store for secret objects.
+	// Controller and store for secret objects, and the ListWatch filling the store.
 	scrtController cache.Controller
 	scrtStore      cache.Store
+	scrtLW         *cache.ListWatch
+
+	// Controller and store for namespace objects; nil without additional trust
+	// domains or signing profiles.
+	nsController cache.Controller
+	nsStore      cache.Store
+
+	// Pending certificate issuances, served by a single worker in priority order.
+	queue *issuanceQueue
+
+	// When the last quota event of each namespace and quota was recorded.
+	quotaEventsMutex sync.Mutex
+	quotaEvents      map[string]time.Time
+
+	// Generates the ID of each issuance, see requestIDAnnotationKey.
+	newRequestID func() string
+
+	uncachedNamespaceRetryDelay time.Duration
 }
 
 // NewSecretController returns a pointer to a newly constructed SecretController instance.
 func NewSecretController(ca certmanager.CertificateAuthority, core corev1.CoreV1Interface,
-	namespace string) *SecretController {
+	namespace string, opts SecretControllerOptions) *SecretController {
 
 	c := &SecretController{
-		ca:   ca,
-		core: core,
+		ca:        ca,
+		core:      core,
+		namespace: namespace,
+		opts:      opts,
+		queue:     newIssuanceQueue(),
+
+		quotaEvents:  map[string]time.Time{},
+		newRequestID: requestid.New,
+
+		uncachedNamespaceRetryDelay: uncachedNamespaceRetryDelay,
 	}
 
 	saLW := &cache.ListWatch{
@@ -85,7 +221,7 @@ func NewSecretController(ca certmanager.CertificateAuthority, core corev1.CoreV1
 	c.saStore, c.saController = cache.NewInformer(saLW, &v1.ServiceAccount{}, time.Minute, rehf)
 
 	istioSecretSelector := fields.SelectorFromSet(map[string]string{"type": istioSecretType}).String()
-	scrtLW := &cache.ListWatch{
+	c.scrtLW = newSlimSecretListWatch(&cache.ListWatch{
 		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
 			options.FieldSelector = istioSecretSelector
 			return core.Secrets(namespace).List(options)
@@ -94,27 +230,99 @@ func NewSecretController(ca certmanager.CertificateAuthority, core corev1.CoreV1
 			options.FieldSelector = istioSecretSelector
 			return core.Secrets(namespace).Watch(options)
 		},
-	}
+	})
 	c.scrtStore, c.scrtController =
-		cache.NewInformer(scrtLW, &v1.Secret{}, secretResyncPeriod, cache.ResourceEventHandlerFuncs{
+		cache.NewInformer(c.scrtLW, &v1.Secret{}, secretResyncPeriod, cache.ResourceEventHandlerFuncs{
 			DeleteFunc: c.scrtDeleted,
 			UpdateFunc: c.scrtUpdated,
 		})
 
+	if len(opts.TrustDomains) > 0 || len(opts.SigningProfiles) > 0 {
+		c.nsStore, c.nsController = newNamespaceInformer(core)
+	}
+
 	return c
 }
 
-// Run starts the SecretController until stopCh is closed.
+// Run starts the SecretController until stopCh is closed. The secrets of
+// older schema versions are migrated first, and their certificates are
+// imported into the issuance logs of the CAs before any issuance.
 func (sc *SecretController) Run(stopCh chan struct{}) {
+	if _, err := sc.MigrateSecrets(); err != nil {
+		glog.Errorf("Failed to migrate the Istio secrets (error: %v)", err)
+	}
+
 	go sc.scrtController.Run(stopCh)
 	go sc.saController.Run(stopCh)
+	if sc.nsController != nil {
+		// Certificates are only issued once the trust domains of the namespaces are known.
+		go sc.nsController.Run(stopCh)
+		cache.WaitForCacheSync(stopCh, sc.nsController.HasSynced)
+	}
+	// The namespaces are known by now, so that each certificate is imported into the CA of its namespace.
+	if _, err := sc.ImportIssuances(); err != nil {
+		glog.Errorf("Failed to import the certificates of the Istio secrets (error: %v)", err)
+	}
+	go func() {
+		for sc.processNextIssuance() {
+		}
+	}()
 	<-stopCh
+	sc.queue.ShutDown()
+
+	if sc.opts.OrphanOnShutdown {
+		sc.orphanSecrets()
+	}
+}
+
+// processNextIssuance serves the most urgent pending issuance. It returns false
+// once the queue is shut down.
+func (sc *SecretController) processNextIssuance() bool {
+	r, ok := sc.queue.Get()
+	if !ok {
+		return false
+	}
+	r.run()
+	return true
+}
+
+// enqueueUpsert schedules the initial provisioning of the secret for the service account.
+func (sc *SecretController) enqueueUpsert(saName, saNamespace string) {
+	sc.queue.Add(saNamespace+"/"+getSecretName(saName), time.Time{}, func() {
+		sc.upsertSecret(saName, saNamespace)
+	})
+}
+
+// enqueueRefresh schedules the renewal of the secret, whose certificate
+// expires at notAfter. If rekey is true, the private key is regenerated even
+// when keys are reused.
+func (sc *SecretController) enqueueRefresh(scrt *v1.Secret, notAfter time.Time, rekey bool) {
+	sc.queue.Add(scrt.GetNamespace()+"/"+scrt.GetName(), notAfter, func() {
+		sc.refreshSecret(scrt, rekey)
+	})
+}
+
+// enqueueRootUpdate schedules the update of the root certificate of the
+// secret, whose certificate expires at notAfter. It is queued apart from the
+// renewal of the secret, so that it never replaces a pending renewal.
+func (sc *SecretController) enqueueRootUpdate(scrt *v1.Secret, notAfter time.Time) {
+	sc.queue.Add(scrt.GetNamespace()+"/"+scrt.GetName()+"#root", notAfter, func() {
+		sc.updateRootCert(scrt)
+	})
+}
+
+// enqueueAliasUpdate schedules the update of the data key aliases of the
+// secret, whose certificate expires at notAfter.
+func (sc *SecretController) enqueueAliasUpdate(scrt *v1.Secret, notAfter time.Time) {
+	sc.queue.Add(scrt.GetNamespace()+"/"+scrt.GetName()+"#aliases", notAfter, func() {
+		sc.updateDataKeyAliases(scrt)
+	})
 }
 
 // Handles the event where a service account is added.
 func (sc *SecretController) saAdded(obj interface{}) {
 	acct := obj.(*v1.ServiceAccount)
-	sc.upsertSecret(acct.GetName(), acct.GetNamespace())
+	sc.enqueueUpsert(acct.GetName(), acct.GetNamespace())
 }
 
 // Handles the event where a service account is deleted.
@@ -140,7 +348,7 @@ func (sc *SecretController) saUpdated(oldObj, curObj interface{}) {
 	// We only care the name and namespace of a service account.
 	if curName != oldName || curNamespace != oldNamespace {
 		sc.deleteSecret(oldName, oldNamespace)
-		sc.upsertSecret(curName, curNamespace)
+		sc.enqueueUpsert(curName, curNamespace)
 
 		glog.Infof("Service account \"%s\" in namespace \"%s\" has been updated to \"%s\" in namespace \"%s\"",
 			oldName, oldNamespace, curName, curNamespace)
@@ -148,16 +356,15 @@ func (sc *SecretController) saUpdated(oldObj, curObj interface{}) {
 }
 
 func (sc *SecretController) upsertSecret(saName, saNamespace string) {
-	secret := &v1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Annotations: map[string]string{serviceAccountNameAnnotationKey: saName},
-			Name:        getSecretName(saName),
-			Namespace:   saNamespace,
-		},
-		Type: istioSecretType,
+	if sc.serviceAccountDeleted(saName, saNamespace) {
+		// The service account has been deleted since the provisioning was
+		// queued, or its secret was deleted along with it.
+		glog.Infof("Service account \"%s\" in namespace \"%s\" has been deleted; not provisioning its secret",
+			saName, saNamespace)
+		return
 	}
 
-	_, exists, err := sc.scrtStore.Get(secret)
+	_, exists, err := sc.scrtStore.GetByKey(saNamespace + "/" + getSecretName(saName))
 	if err != nil {
 		glog.Errorf("Failed to get secret from the store (error %v)", err)
 	}
@@ -168,24 +375,83 @@ func (sc *SecretController) upsertSecret(saName, saNamespace string) {
 	}
 
 	// Now we know the secret does not exist yet. So we create a new one.
-	chain, key := sc.ca.Generate(saName, saNamespace)
-	rootCert := sc.ca.GetRootCertificate()
+	if err := sc.createSecret(saName, saNamespace); err != nil {
+		glog.Errorf("Failed to create the Istio secret for service account \"%s\" in namespace \"%s\" (error: %s)",
+			saName, saNamespace, err)
+	}
+}
+
+// serviceAccountDeleted returns true if the service account is missing from
+// the store of the service account informer. It returns false until the store
+// has synced.
+func (sc *SecretController) serviceAccountDeleted(saName, saNamespace string) bool {
+	if !sc.saController.HasSynced() {
+		return false
+	}
+	_, exists, err := sc.saStore.GetByKey(saNamespace + "/" + saName)
+	return err == nil && !exists
+}
+
+// createSecret creates the Istio secret of the service account with a newly
+// issued certificate.
+func (sc *SecretController) createSecret(saName, saNamespace string) error {
+	rid := sc.newRequestID()
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{serviceAccountNameAnnotationKey: saName},
+			Labels:      map[string]string{managedByLabelKey: managedByLabelValue},
+			Name:        getSecretName(saName),
+			Namespace:   saNamespace,
+		},
+		Type: istioSecretType,
+	}
+
+	ca, err := sc.caFor(saNamespace)
+	if err != nil {
+		recordFailure(failureSelectCA)
+		sc.retryUncachedNamespace(err, func() { sc.enqueueUpsert(saName, saNamespace) })
+		return fmt.Errorf("failed to select the CA (request: %s, error: %s)", rid, err)
+	}
+	chain, key, err := ca.Generate(saName, saNamespace)
+	if err != nil {
+		recordFailure(failureIssue)
+		sc.reportQuotaExceeded(saName, saNamespace, err)
+		return fmt.Errorf("failed to generate the certificate (request: %s, error: %s)", rid, err)
+	}
+	chain, rootCert := sc.certChainData(chain, ca.GetRootCertificate()), sc.pemData(ca.GetRootCertificate())
 	secret.Data = map[string][]byte{
-		certChainID:  chain,
-		privateKeyID: key,
-		rootCertID:   rootCert,
+		certChainID: chain,
+		rootCertID:  rootCert,
 	}
+	if err := sc.setPrivateKey(secret, key, time.Now()); err != nil {
+		recordFailure(failureStoreKey)
+		return fmt.Errorf("failed to store the private key (request: %s, error: %s)", rid, err)
+	}
+	sc.setTLSKeys(secret)
+	sc.setDataKeyAliases(secret)
+	setStatusAnnotations(secret, chain, rootCert)
+	setRequestID(secret, rid)
+	setSchemaVersion(secret)
 	_, err = sc.core.Secrets(saNamespace).Create(secret)
+	recordAPIRequest("create", err)
 	if err != nil {
-		glog.Errorf("Failed to create secret (error: %s)", err)
-		return
+		return fmt.Errorf("failed to write the secret (request: %s, error: %s)", rid, err)
 	}
 
-	glog.Infof("Istio secret for service account \"%s\" in namespace \"%s\" has been created", saName, saNamespace)
+	glog.Infof("Istio secret for service account \"%s\" in namespace \"%s\" has been created (request: %s)",
+		saName, saNamespace, rid)
+	return nil
 }
 
 func (sc *SecretController) deleteSecret(saName, saNamespace string) {
+	key := saNamespace + "/" + getSecretName(saName)
+	if obj, exists, err := sc.scrtStore.GetByKey(key); err == nil && exists && !sc.isOwned(obj.(*v1.Secret)) {
+		glog.Infof("Istio secret %s is not managed by Istio CA; leaving it in place", key)
+		return
+	}
+
 	err := sc.core.Secrets(saNamespace).Delete(getSecretName(saName), nil)
+	recordAPIRequest("delete", err)
 	// kube-apiserver returns NotFound error when the secret is successfully deleted.
 	if err == nil || errors.IsNotFound(err) {
 		glog.Infof("Istio secret for service account \"%s\" in namespace \"%s\" has been deleted", saName, saNamespace)
@@ -203,10 +469,14 @@ func (sc *SecretController) scrtDeleted(obj interface{}) {
 		return
 	}
 
+	if !sc.isOwned(scrt) {
+		return
+	}
+
 	glog.Infof("Re-create deleted Istio secret")
 
 	saName := scrt.Annotations[serviceAccountNameAnnotationKey]
-	sc.upsertSecret(saName, scrt.GetNamespace())
+	sc.enqueueUpsert(saName, scrt.GetNamespace())
 }
 
 func (sc *SecretController) scrtUpdated(oldObj, newObj interface{}) {
@@ -215,37 +485,481 @@ func (sc *SecretController) scrtUpdated(oldObj, newObj interface{}) {
 		glog.Warning("Failed to convert to secret object: %v", newObj)
 		return
 	}
+	if !sc.isManaged(scrt) {
+		if scrt, ok = sc.adopt(scrt); !ok {
+			return
+		}
+	}
 
-	certBytes := scrt.Data[certChainID]
-	cert := certmanager.ParsePemEncodedCertificate(certBytes)
+	cert, err := parseCertificate(scrt.Data[certChainID])
+	if err != nil {
+		// The secret may have been edited by a user; replace its content rather than crash.
+		glog.Warningf("Refreshing secret %s/%s, which holds a malformed certificate (error: %v)",
+			scrt.GetNamespace(), scrt.GetName(), err)
+		sc.enqueueRefresh(scrt, time.Now(), false)
+		return
+	}
 	ttl := time.Until(cert.NotAfter)
-	rootCertificate := sc.ca.GetRootCertificate()
+	ca, err := sc.caFor(scrt.GetNamespace())
+	if err != nil {
+		glog.Errorf("Failed to select the CA for namespace %s (error: %s)", scrt.GetNamespace(), err)
+		recordFailure(failureSelectCA)
+		return
+	}
+	rootCertificate := ca.GetRootCertificate()
+
+	// Refresh the secret if 1) the certificate contained in the secret is due
+	// for rotation or about to expire, or 2) the root certificate in the secret
+	// is different than the one held by the certmanager (this may happen when
+	// the CA is restarted and a new self-signed CA cert is generated), and the
+	// certificate does not chain to the new root. A certificate that still
+	// chains to the new root, e.g. during a staged root rotation, is kept and
+	// only the root certificate is updated.
+	rootOutdated := !samePEM(rootCertificate, scrt.Data[rootCertID])
+	if time.Now().After(rotationTime(scrt, cert)) || ttl.Seconds() < secretResyncPeriod.Seconds() ||
+		(rootOutdated && !chainVerifies(scrt.Data[certChainID], rootCertificate)) {
+		glog.Infof("Refreshing secret %s/%s, either the leaf certificate is due for rotation "+
+			"or the root certificate is outdated", scrt.GetNamespace(), scrt.GetName())
+
+		sc.enqueueRefresh(scrt, cert.NotAfter, false)
+	} else if rootOutdated {
+		glog.Infof("Updating the root certificate of secret %s/%s", scrt.GetNamespace(), scrt.GetName())
+		sc.enqueueRootUpdate(scrt, cert.NotAfter)
+	} else if sc.dataKeyAliasesOutdated(scrt) {
+		glog.Infof("Updating the data key aliases of secret %s/%s", scrt.GetNamespace(), scrt.GetName())
+		sc.enqueueAliasUpdate(scrt, cert.NotAfter)
+	}
+}
+
+// HandleRevocation rotates the Istio secrets whose certificate chains hold the
+// certificate with the given serial number, i.e. the secret holding a revoked
+// leaf certificate, or all the secrets issued by a revoked intermediate CA. If
+// deleteSecret is true, the secrets are deleted instead of being updated in
+// place, and `scrtDeleted` re-creates them with new keys and certs.
+func (sc *SecretController) HandleRevocation(serial *big.Int, deleteSecret bool) {
+	found := false
+	for _, obj := range sc.scrtStore.List() {
+		scrt, ok := obj.(*v1.Secret)
+		if !ok || !sc.isManaged(scrt) || !chainHoldsSerial(scrt.Data[certChainID], serial) {
+			continue
+		}
+		found = true
 
-	// Refresh the secret if 1) the certificate contained in the secret is about
-	// to expire, or 2) the root certificate in the secret is different than the
-	// one held by the certmanager (this may happen when the CA is restarted and
-	// a new self-signed CA cert is generated).
-	if ttl.Seconds() < secretResyncPeriod.Seconds() || !bytes.Equal(rootCertificate, scrt.Data[rootCertID]) {
 		namespace := scrt.GetNamespace()
 		name := scrt.GetName()
+		glog.Infof("Certificate with serial number %v in secret %s/%s has been revoked", serial, namespace, name)
+
+		if deleteSecret {
+			err := sc.core.Secrets(namespace).Delete(name, nil)
+			recordAPIRequest("delete", err)
+			if err != nil && !errors.IsNotFound(err) {
+				glog.Errorf("Failed to delete revoked secret %s/%s (error: %s)", namespace, name, err)
+			}
+			continue
+		}
+
+		// A revoked certificate is as urgent to replace as an expired one, and
+		// its key may be compromised.
+		sc.enqueueRefresh(scrt, time.Now(), true)
+	}
+
+	if !found {
+		glog.Warningf("No Istio secret holds the revoked certificate with serial number %v", serial)
+	}
+}
+
+// ReissueExpiring rotates the managed Istio secrets whose certificates expire
+// within the given duration, in the given namespace or in all namespaces if
+// it is empty, and returns the identities of the rotated secrets. Secrets
+// holding malformed certificates are left to the periodic refresh.
+func (sc *SecretController) ReissueExpiring(within time.Duration, namespace string) []ManagedIdentity {
+	deadline := time.Now().Add(within)
+	return sc.reissue(namespace, func(id ManagedIdentity) bool {
+		return id.NotAfter.Before(deadline)
+	})
+}
+
+// reissue rotates the managed Istio secrets of the namespace, or of all
+// namespaces if it is empty, whose identities match, and returns the identities.
+func (sc *SecretController) reissue(namespace string, match func(ManagedIdentity) bool) []ManagedIdentity {
+	reissued := []ManagedIdentity{}
+	for _, obj := range sc.scrtStore.List() {
+		scrt, ok := obj.(*v1.Secret)
+		if !ok || !sc.isManaged(scrt) || (namespace != "" && scrt.GetNamespace() != namespace) {
+			continue
+		}
+		id, err := managedIdentity(scrt)
+		if err != nil || !match(id) {
+			continue
+		}
+
+		glog.Infof("Reissuing the certificate of secret %s/%s, which expires at %v",
+			scrt.GetNamespace(), scrt.GetName(), id.NotAfter)
+		sc.enqueueRefresh(scrt, id.NotAfter, false)
+		reissued = append(reissued, id)
+	}
+	return reissued
+}
+
+// chainVerifies returns true if the leaf of the PEM-encoded chain is valid and
+// chains to one of the PEM-encoded roots through the rest of the chain.
+func chainVerifies(chain, roots []byte) bool {
+	certs, err := parse.CertificateChain(chain)
+	if err != nil {
+		return false
+	}
+	rootPool := x509.NewCertPool()
+	if !rootPool.AppendCertsFromPEM(roots) {
+		return false
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = certs[0].Verify(x509.VerifyOptions{
+		Roots:         rootPool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err == nil
+}
+
+// chainHoldsSerial returns true if a certificate in the PEM-encoded chain has the serial number.
+func chainHoldsSerial(chain []byte, serial *big.Int) bool {
+	certs, err := parse.CertificateChain(chain)
+	if err != nil {
+		return false
+	}
+	for _, cert := range certs {
+		if cert.SerialNumber.Cmp(serial) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshSecret replaces the certificate chain of the secret with a newly
+// issued one, and updates the root certificate. The private key is replaced
+// too, unless keys are reused and rekey is false.
+func (sc *SecretController) refreshSecret(scrt *v1.Secret, rekey bool) {
+	namespace := scrt.GetNamespace()
+	name := scrt.GetName()
+	rid := sc.newRequestID()
+
+	ca, err := sc.caFor(namespace)
+	if err != nil {
+		glog.Errorf("Failed to select the CA for namespace %s (request: %s, error: %s)", namespace, rid, err)
+		recordFailure(failureSelectCA)
+		sc.retryUncachedNamespace(err, func() { sc.enqueueRefresh(scrt, time.Now(), rekey) })
+		return
+	}
 
-		glog.Infof("Refreshing secret %s/%s, either the leaf certificate is about to expire "+
-			"or the root certificate is outdated", namespace, name)
+	if !sc.isManaged(scrt) {
+		return
+	}
+	// The private key is needed to re-certify it.
+	scrt, err = sc.fullSecret(scrt)
+	if err != nil {
+		glog.Errorf("Failed to get secret %s/%s (request: %s, error: %s)", namespace, name, rid, err)
+		return
+	}
 
-		saName := scrt.Annotations[serviceAccountNameAnnotationKey]
-		chain, key := sc.ca.Generate(saName, namespace)
+	// The certificate is issued once, rather than on every retry of the update.
+	saName := scrt.Annotations[serviceAccountNameAnnotationKey]
+	chain, key, keyCreatedAt, err := sc.renew(ca, scrt, saName, rekey)
+	if err != nil {
+		glog.Errorf("Failed to generate the certificate for secret %s/%s (request: %s, error: %s)",
+			namespace, name, rid, err)
+		recordFailure(failureIssue)
+		sc.reportQuotaExceeded(saName, namespace, err)
+		return
+	}
+	chain, rootCert := sc.certChainData(chain, ca.GetRootCertificate()), sc.pemData(ca.GetRootCertificate())
 
-		scrt.Data[certChainID] = chain
+	refreshed := false
+	_, err = sc.updateCachedSecret(scrt, func(s *v1.Secret) bool {
+		if !sc.isManaged(s) || s.Annotations[serviceAccountNameAnnotationKey] != saName {
+			return false
+		}
+		if s.Data == nil {
+			s.Data = map[string][]byte{}
+		}
+		if err := sc.setPrivateKey(s, key, keyCreatedAt); err != nil {
+			glog.Errorf("Failed to store the private key for secret %s/%s (request: %s, error: %s)",
+				namespace, name, rid, err)
+			recordFailure(failureStoreKey)
+			return false
+		}
+		s.Data[certChainID] = chain
+		s.Data[rootCertID] = rootCert
+		sc.setTLSKeys(s)
+		sc.setDataKeyAliases(s)
+		setStatusAnnotations(s, chain, rootCert)
+		setRequestID(s, rid)
+		setSchemaVersion(s)
+		refreshed = true
+		return true
+	})
+	if err != nil {
+		glog.Errorf("Failed to update secret %s/%s (request: %s, error: %s)", namespace, name, rid, err)
+	} else if refreshed {
+		glog.Infof("Istio secret %s/%s has been refreshed (request: %s)", namespace, name, rid)
+	}
+}
+
+// updateRootCert replaces the root certificate of the secret with the current
+// one of its CA, keeping its certificate chain and private key.
+func (sc *SecretController) updateRootCert(scrt *v1.Secret) {
+	namespace := scrt.GetNamespace()
+	name := scrt.GetName()
+
+	ca, err := sc.caFor(namespace)
+	if err != nil {
+		glog.Errorf("Failed to select the CA for namespace %s (error: %s)", namespace, err)
+		recordFailure(failureSelectCA)
+		return
+	}
+	rootCert := sc.pemData(ca.GetRootCertificate())
+
+	_, err = sc.updateCachedSecret(scrt, func(s *v1.Secret) bool {
+		if !sc.isManaged(s) || samePEM(s.Data[rootCertID], rootCert) {
+			return false
+		}
+		s.Data[rootCertID] = rootCert
+		sc.setTLSKeys(s)
+		sc.setDataKeyAliases(s)
+		if root, err := parseCertificate(rootCert); err == nil {
+			if s.Annotations == nil {
+				s.Annotations = map[string]string{}
+			}
+			s.Annotations[rootFingerprintAnnotationKey] = fingerprint(root)
+		}
+		return true
+	})
+	if err != nil {
+		glog.Errorf("Failed to update the root certificate of secret %s/%s (error: %s)", namespace, name, err)
+	}
+}
+
+// renew issues a new certificate chain for the secret. It re-certifies the
+// private key of the secret if keys are reused, rekey is false and the key is
+// not too old; otherwise, or if the key cannot be read, it generates a new key.
+// The time at which the returned key was generated is returned along with it.
+func (sc *SecretController) renew(ca certmanager.CertificateAuthority, scrt *v1.Secret, saName string,
+	rekey bool) (chain, key []byte, keyCreatedAt time.Time, err error) {
+
+	namespace := scrt.GetNamespace()
+	if sc.opts.ReuseKeys && !rekey {
+		createdAt, err := time.Parse(time.RFC3339, scrt.Annotations[keyCreatedAtAnnotationKey])
+		if err == nil && (sc.opts.MaxKeyAge == 0 || time.Since(createdAt) < sc.opts.MaxKeyAge) {
+			key, err = sc.getPrivateKey(scrt)
+			if err == nil {
+				chain, err = ca.Recertify(saName, namespace, key)
+			}
+			if err == nil {
+				return chain, key, createdAt, nil
+			}
+			switch err.(type) {
+			case *certmanager.QuotaExceededError, *certmanager.ApprovalPendingError, *certmanager.ApprovalDeniedError,
+				*certmanager.IdentityNotApprovedError, *certmanager.ClockError:
+				// A new key would be refused too.
+				return nil, nil, time.Time{}, err
+			}
+			glog.Warningf("Failed to re-certify the private key of secret %s/%s; generating a new key (error: %s)",
+				namespace, scrt.GetName(), err)
+		}
+	}
+
+	chain, key, err = ca.Generate(saName, namespace)
+	return chain, key, time.Now(), err
+}
+
+// setPrivateKey stores the private key, generated at createdAt, in the secret,
+// as a KMS envelope if the keys are encrypted.
+func (sc *SecretController) setPrivateKey(scrt *v1.Secret, key []byte, createdAt time.Time) error {
+	if sc.opts.ReuseKeys {
+		if scrt.Annotations == nil {
+			scrt.Annotations = map[string]string{}
+		}
+		scrt.Annotations[keyCreatedAtAnnotationKey] = createdAt.UTC().Format(time.RFC3339)
+	}
+
+	if sc.opts.KeyEncryption == nil {
 		scrt.Data[privateKeyID] = key
-		scrt.Data[rootCertID] = rootCertificate
+		delete(scrt.Data, encryptedPrivateKeyID)
+		return nil
+	}
 
-		_, err := sc.core.Secrets(namespace).Update(scrt)
+	sealed, err := kms.Seal(sc.opts.KeyEncryption, key)
+	if err != nil {
+		return err
+	}
+	scrt.Data[encryptedPrivateKeyID] = sealed
+	delete(scrt.Data, privateKeyID)
+	return nil
+}
+
+// setTLSKeys mirrors the Istio data keys of the secret under the data keys
+// of kubernetes.io/tls secrets if TLSKeys is set, and removes them otherwise.
+func (sc *SecretController) setTLSKeys(scrt *v1.Secret) {
+	for istioKey, tlsKey := range tlsDataKeys {
+		data, ok := scrt.Data[istioKey]
+		if sc.opts.TLSKeys && ok {
+			scrt.Data[tlsKey] = data
+		} else {
+			delete(scrt.Data, tlsKey)
+		}
+	}
+}
+
+// getPrivateKey returns the private key stored in the secret, decrypting it if it is a KMS envelope.
+func (sc *SecretController) getPrivateKey(scrt *v1.Secret) ([]byte, error) {
+	if sealed, ok := scrt.Data[encryptedPrivateKeyID]; ok {
+		if sc.opts.KeyEncryption == nil {
+			return nil, fmt.Errorf("the private key is encrypted but no KMS key is configured")
+		}
+		return kms.Open(sc.opts.KeyEncryption, sealed)
+	}
+	if key, ok := scrt.Data[privateKeyID]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("the secret holds no private key")
+}
+
+// rotationTime returns when the certificate in the secret should be rotated:
+// the renewal hint of the secret if present, otherwise a point within
+// secretRotationGracePeriod before expiry that is fixed per secret. Either
+// way, secrets created at the same time are not all rotated at once.
+func rotationTime(scrt *v1.Secret, cert *x509.Certificate) time.Time {
+	if t, err := time.Parse(time.RFC3339, scrt.Annotations[renewAfterAnnotationKey]); err == nil {
+		return t
+	}
+
+	h := fnv.New32a()
+	// nolint: errcheck
+	h.Write([]byte(scrt.GetNamespace() + "/" + scrt.GetName()))
+	jitter := float64(h.Sum32()) / float64(1<<32)
+	return cert.NotAfter.Add(-time.Duration(jitter * float64(secretRotationGracePeriod)))
+}
+
+// isManaged returns true if the secret carries the ownership label of the controller.
+func (sc *SecretController) isManaged(scrt *v1.Secret) bool {
+	return scrt.Labels[managedByLabelKey] == managedByLabelValue
+}
+
+// isOwned returns true if the secret is managed by the controller, or is to be
+// adopted by it on first sight.
+func (sc *SecretController) isOwned(scrt *v1.Secret) bool {
+	return sc.isManaged(scrt) || sc.adoptable(scrt)
+}
+
+// adoptable returns true if the secret carries no ownership label and is
+// either in the format written by earlier versions of the controller, i.e. an
+// Istio secret annotated with its service account, or adoption is enabled.
+func (sc *SecretController) adoptable(scrt *v1.Secret) bool {
+	if scrt.Labels[managedByLabelKey] != "" {
+		return false
+	}
+	_, annotated := scrt.Annotations[serviceAccountNameAnnotationKey]
+	return sc.opts.AdoptUnlabeled || (scrt.Type == istioSecretType && annotated)
+}
+
+// adopt labels an adoptable secret as managed by the controller. It returns
+// the adopted secret and true if the secret has been adopted.
+func (sc *SecretController) adopt(scrt *v1.Secret) (*v1.Secret, bool) {
+	if !sc.adoptable(scrt) {
+		return scrt, false
+	}
+
+	adopted, err := sc.updateCachedSecret(scrt, func(s *v1.Secret) bool {
+		if s.Labels[managedByLabelKey] != "" {
+			return false
+		}
+		if s.Labels == nil {
+			s.Labels = map[string]string{}
+		}
+		s.Labels[managedByLabelKey] = managedByLabelValue
+		return true
+	})
+	if err != nil {
+		glog.Errorf("Failed to adopt secret %s/%s (error: %s)", scrt.GetNamespace(), scrt.GetName(), err)
+		return scrt, false
+	}
+	if !sc.isManaged(adopted) {
+		return adopted, false
+	}
+
+	glog.Infof("Istio secret %s/%s has been adopted", scrt.GetNamespace(), scrt.GetName())
+	return adopted, true
+}
+
+// orphanSecrets relabels all managed secrets as managed by their users, so that
+// they are not adopted again.
+func (sc *SecretController) orphanSecrets() {
+	for _, obj := range sc.scrtStore.List() {
+		scrt, ok := obj.(*v1.Secret)
+		if !ok || !sc.isManaged(scrt) {
+			continue
+		}
+
+		_, err := sc.updateCachedSecret(scrt, func(s *v1.Secret) bool {
+			if !sc.isManaged(s) {
+				return false
+			}
+			s.Labels[managedByLabelKey] = managedByUserLabelValue
+			return true
+		})
 		if err != nil {
-			glog.Errorf("Failed to update secret %s/%s (error: %s)", namespace, name, err)
+			glog.Errorf("Failed to orphan secret %s/%s (error: %s)", scrt.GetNamespace(), scrt.GetName(), err)
+			continue
 		}
+		glog.Infof("Istio secret %s/%s has been orphaned", scrt.GetNamespace(), scrt.GetName())
 	}
 }
 
 func getSecretName(saName string) string {
 	return secretNamePrefix + saName
 }
+
+// pemData returns the PEM-encoded data to store in a secret, with comments if enabled.
+func (sc *SecretController) pemData(data []byte) []byte {
+	if sc.opts.PEMComments {
+		return certmanager.CommentPEM(data)
+	}
+	return data
+}
+
+// certChainData returns the certificate chain to store in a secret, minimized
+// against the root certificates if enabled.
+func (sc *SecretController) certChainData(chain, rootCerts []byte) []byte {
+	if sc.opts.MinimalChains {
+		chain = certmanager.MinimizeChain(chain, rootCerts)
+	}
+	return sc.pemData(chain)
+}
+
+// samePEM returns true if a and b hold the same PEM blocks, regardless of the
+// text around them, so that adding or removing comments changes nothing.
+func samePEM(a, b []byte) bool {
+	for {
+		var ba, bb *pem.Block
+		ba, a = pem.Decode(a)
+		bb, b = pem.Decode(b)
+		if ba == nil || bb == nil {
+			return ba == nil && bb == nil && bytes.Equal(bytes.TrimSpace(a), bytes.TrimSpace(b))
+		}
+		if ba.Type != bb.Type || !bytes.Equal(ba.Bytes, bb.Bytes) {
+			return false
+		}
+	}
+}
+
+// parseCertificate parses the first certificate of a PEM-encoded chain. Unlike
+// `certmanager.ParsePemEncodedCertificate`, malformed input yields an error.
+func parseCertificate(certBytes []byte) (*x509.Certificate, error) {
+	cb, _ := pem.Decode(certBytes)
+	if cb == nil {
+		return nil, fmt.Errorf("invalid PEM encoding for the certificate")
+	}
+	return x509.ParseCertificate(cb.Bytes)
+}
@@ -15,27 +15,49 @@
 package controller
 
 import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"math/big"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"istio.io/auth/certmanager"
+	"istio.io/auth/kms"
 
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/pkg/api/v1"
 	ktesting "k8s.io/client-go/testing"
 )
 
+// testRequestID is the ID of every issuance of the controllers under test.
+const testRequestID = "0123456789abcdef"
+
 type fakeCa struct{}
 
-func (ca fakeCa) Generate(name, namespace string) (chain, key []byte) {
+func (ca fakeCa) Generate(name, namespace string) (chain, key []byte, err error) {
 	chain = []byte("fake cert chain")
 	key = []byte("fake key")
 	return
 }
 
+func (ca fakeCa) Recertify(name, namespace string, key []byte) (chain []byte, err error) {
+	return []byte("recertified cert chain"), nil
+}
+
+func (ca fakeCa) GenerateServerCert(hosts []string) (chain, key []byte, err error) {
+	chain = []byte("fake server cert chain")
+	key = []byte("fake server key")
+	return
+}
+
 func (ca fakeCa) GetRootCertificate() []byte {
 	return []byte("fake root cert")
 }
@@ -48,9 +70,14 @@ func createSecret(saName, scrtName, namespace string) *v1.Secret {
 			rootCertID:   []byte("fake root cert"),
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Annotations: map[string]string{"istio.io/service-account.name": saName},
-			Name:        scrtName,
-			Namespace:   namespace,
+			Annotations: map[string]string{
+				"istio.io/service-account.name":  saName,
+				"istio.io/secret-schema-version": "2",
+				"istio.io/request-id":            testRequestID,
+			},
+			Labels:    map[string]string{"istio.io/managed-by": "istio-ca"},
+			Name:      scrtName,
+			Namespace: namespace,
 		},
 		Type: istioSecretType,
 	}
@@ -65,6 +92,13 @@ func createServiceAccount(name, namespace string) *v1.ServiceAccount {
 	}
 }
 
+// drainQueue serves all pending issuances of the controller.
+func drainQueue(controller *SecretController) {
+	for controller.queue.Len() > 0 {
+		controller.processNextIssuance()
+	}
+}
+
 type updatedSas struct {
 	curSa *v1.ServiceAccount
 	oldSa *v1.ServiceAccount
@@ -120,7 +154,8 @@ func TestSecretController(t *testing.T) {
 
 	for k, tc := range testCases {
 		client := fake.NewSimpleClientset()
-		controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll)
+		controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+		controller.newRequestID = func() string { return testRequestID }
 
 		if tc.existingSecret != nil {
 			err := controller.scrtStore.Add(tc.existingSecret)
@@ -138,6 +173,7 @@ func TestSecretController(t *testing.T) {
 		if tc.sasToUpdate != nil {
 			controller.saUpdated(tc.sasToUpdate.oldSa, tc.sasToUpdate.curSa)
 		}
+		drainQueue(controller)
 
 		actions := client.Actions()
 		if !reflect.DeepEqual(actions, tc.expectedActions) {
@@ -148,9 +184,11 @@ func TestSecretController(t *testing.T) {
 
 func TestRecoverFromDeletedIstioSecret(t *testing.T) {
 	client := fake.NewSimpleClientset()
-	controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll)
+	controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+	controller.newRequestID = func() string { return testRequestID }
 	scrt := createSecret("test", "istio.test", "test-ns")
 	controller.scrtDeleted(scrt)
+	drainQueue(controller)
 
 	gvr := schema.GroupVersionResource{
 		Resource: "secrets",
@@ -172,6 +210,7 @@ func TestUpdateSecret(t *testing.T) {
 		expectedActions []ktesting.Action
 		notAfter        time.Time
 		rootCert        []byte
+		renewAfter      time.Time
 	}{
 		"Does not update non-expiring secret": {
 			expectedActions: []ktesting.Action{},
@@ -190,16 +229,32 @@ func TestUpdateSecret(t *testing.T) {
 			notAfter: time.Now().Add(time.Hour),
 			rootCert: []byte("Outdated root cert"),
 		},
+		"Update secret past its renewal hint": {
+			expectedActions: []ktesting.Action{
+				ktesting.NewUpdateAction(gvr, "test-ns", createSecret("test", "istio.test", "test-ns")),
+			},
+			notAfter:   time.Now().Add(time.Hour),
+			renewAfter: time.Now().Add(-time.Minute),
+		},
+		"Does not update secret before its renewal hint": {
+			expectedActions: []ktesting.Action{},
+			notAfter:        time.Now().Add(5 * time.Minute),
+			renewAfter:      time.Now().Add(3 * time.Minute),
+		},
 	}
 
 	for k, tc := range testCases {
 		client := fake.NewSimpleClientset()
-		controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll)
+		controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+		controller.newRequestID = func() string { return testRequestID }
 
 		scrt := createSecret("test", "istio.test", "test-ns")
 		if rc := tc.rootCert; rc != nil {
 			scrt.Data[rootCertID] = rc
 		}
+		if !tc.renewAfter.IsZero() {
+			scrt.Annotations[renewAfterAnnotationKey] = tc.renewAfter.Format(time.RFC3339)
+		}
 
 		opts := certmanager.CertOptions{
 			IsSelfSigned: true,
@@ -210,6 +265,146 @@ func TestUpdateSecret(t *testing.T) {
 		scrt.Data[certChainID] = bs
 
 		controller.scrtUpdated(nil, scrt)
+		drainQueue(controller)
+
+		actions := client.Actions()
+		if !reflect.DeepEqual(actions, tc.expectedActions) {
+			t.Errorf("%s: expect actions to be \n\t%v\n but actual actions are \n\t%v", k, tc.expectedActions, actions)
+		}
+	}
+}
+
+func TestRotationTime(t *testing.T) {
+	notAfter := time.Now().Add(time.Hour)
+	cert := &x509.Certificate{NotAfter: notAfter}
+
+	times := map[time.Time]bool{}
+	for i := 0; i < 10; i++ {
+		scrt := createSecret("test", fmt.Sprintf("istio.test-%d", i), "test-ns")
+		rotateAt := rotationTime(scrt, cert)
+		if rotateAt.After(notAfter) || rotateAt.Before(notAfter.Add(-secretRotationGracePeriod)) {
+			t.Errorf("Rotation time %v of secret %s is outside the grace period", rotateAt, scrt.Name)
+		}
+		if again := rotationTime(scrt, cert); !again.Equal(rotateAt) {
+			t.Errorf("Rotation time of secret %s is not stable (%v and %v)", scrt.Name, rotateAt, again)
+		}
+		times[rotateAt] = true
+	}
+	if len(times) < 2 {
+		t.Error("Rotation times of different secrets are not spread")
+	}
+
+	scrt := createSecret("test", "istio.test", "test-ns")
+	renewAfter := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+	scrt.Annotations[renewAfterAnnotationKey] = renewAfter.Format(time.RFC3339)
+	if rotateAt := rotationTime(scrt, cert); !rotateAt.Equal(renewAfter) {
+		t.Errorf("Rotation time should follow the renewal hint (expecting %v, actual %v)", renewAfter, rotateAt)
+	}
+}
+
+// certCa signs real certificates, unlike fakeCa.
+type certCa struct {
+	fakeCa
+	ttl time.Duration
+}
+
+func (ca certCa) Generate(name, namespace string) (chain, key []byte, err error) {
+	now := time.Now()
+	chain, key = certmanager.GenCert(certmanager.CertOptions{
+		IsSelfSigned: true,
+		NotBefore:    now,
+		NotAfter:     now.Add(ca.ttl),
+		RSAKeySize:   512,
+	})
+	return
+}
+
+func TestRenewalHint(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	controller := NewSecretController(certCa{ttl: time.Hour}, client.CoreV1(), metav1.NamespaceAll,
+		SecretControllerOptions{})
+	controller.saAdded(createServiceAccount("test", "test-ns"))
+	drainQueue(controller)
+
+	scrt, err := client.CoreV1().Secrets("test-ns").Get("istio.test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get the created secret: %v", err)
+	}
+	renewAfter, err := time.Parse(time.RFC3339, scrt.Annotations[renewAfterAnnotationKey])
+	if err != nil {
+		t.Fatalf("Failed to parse the renewal hint %q: %v", scrt.Annotations[renewAfterAnnotationKey], err)
+	}
+
+	cert := certmanager.ParsePemEncodedCertificate(scrt.Data[certChainID])
+	early := cert.NotBefore.Add(42 * time.Minute).Add(-time.Second)
+	late := cert.NotBefore.Add(48 * time.Minute)
+	if renewAfter.Before(early) || renewAfter.After(late) {
+		t.Errorf("Renewal hint %v is not within [%v, %v]", renewAfter, early, late)
+	}
+}
+
+func TestHandleRevocation(t *testing.T) {
+	gvr := schema.GroupVersionResource{
+		Resource: "secrets",
+		Version:  "v1",
+	}
+	certBytes, _ := certmanager.GenCert(certmanager.CertOptions{
+		IsSelfSigned: true,
+		NotAfter:     time.Now().Add(time.Hour),
+		RSAKeySize:   512,
+	})
+	serial := certmanager.ParsePemEncodedCertificate(certBytes).SerialNumber
+	intermediateBytes, _ := certmanager.GenCert(certmanager.CertOptions{
+		IsCA:         true,
+		IsSelfSigned: true,
+		NotAfter:     time.Now().Add(time.Hour),
+		RSAKeySize:   512,
+	})
+	intermediateSerial := certmanager.ParsePemEncodedCertificate(intermediateBytes).SerialNumber
+
+	testCases := map[string]struct {
+		deleteSecret    bool
+		expectedActions []ktesting.Action
+		serial          *big.Int
+	}{
+		"Revoking an unknown certificate does nothing": {
+			expectedActions: []ktesting.Action{},
+			serial:          big.NewInt(1),
+		},
+		"Revoking a certificate rotates the secret": {
+			expectedActions: []ktesting.Action{
+				ktesting.NewUpdateAction(gvr, "test-ns", createSecret("test", "istio.test", "test-ns")),
+			},
+			serial: serial,
+		},
+		"Revoking a certificate deletes the secret": {
+			deleteSecret: true,
+			expectedActions: []ktesting.Action{
+				ktesting.NewDeleteAction(gvr, "test-ns", "istio.test"),
+			},
+			serial: serial,
+		},
+		"Revoking an intermediate CA rotates the secrets it issued": {
+			expectedActions: []ktesting.Action{
+				ktesting.NewUpdateAction(gvr, "test-ns", createSecret("test", "istio.test", "test-ns")),
+			},
+			serial: intermediateSerial,
+		},
+	}
+
+	for k, tc := range testCases {
+		client := fake.NewSimpleClientset()
+		controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+		controller.newRequestID = func() string { return testRequestID }
+
+		scrt := createSecret("test", "istio.test", "test-ns")
+		scrt.Data[certChainID] = append(append([]byte{}, certBytes...), intermediateBytes...)
+		if err := controller.scrtStore.Add(scrt); err != nil {
+			t.Errorf("%s: failed to add a secret (error %v)", k, err)
+		}
+
+		controller.HandleRevocation(tc.serial, tc.deleteSecret)
+		drainQueue(controller)
 
 		actions := client.Actions()
 		if !reflect.DeepEqual(actions, tc.expectedActions) {
@@ -217,3 +412,646 @@ func TestUpdateSecret(t *testing.T) {
 		}
 	}
 }
+
+func TestSecretOwnership(t *testing.T) {
+	expiringCert, _ := certmanager.GenCert(certmanager.CertOptions{
+		IsSelfSigned: true,
+		NotAfter:     time.Now().Add(-time.Second),
+		RSAKeySize:   512,
+	})
+	// The secrets written by earlier versions of the controller carry no ownership label.
+	createBaselineSecret := func() *v1.Secret {
+		scrt := createSecret("test", "istio.test", "test-ns")
+		scrt.Labels = nil
+		scrt.Data[certChainID] = expiringCert
+		return scrt
+	}
+	createUserSecret := func() *v1.Secret {
+		scrt := createBaselineSecret()
+		scrt.Labels = map[string]string{managedByLabelKey: managedByUserLabelValue}
+		return scrt
+	}
+	createUnannotatedSecret := func() *v1.Secret {
+		scrt := createBaselineSecret()
+		delete(scrt.Annotations, serviceAccountNameAnnotationKey)
+		return scrt
+	}
+	deleteSecret := func(c *SecretController, s *v1.Secret) {
+		if err := c.scrtStore.Delete(s); err != nil {
+			t.Fatalf("Failed to delete the secret from the store: %v", err)
+		}
+		if err := c.core.Secrets(s.GetNamespace()).Delete(s.GetName(), nil); err != nil {
+			t.Fatalf("Failed to delete the secret: %v", err)
+		}
+		c.scrtDeleted(s)
+	}
+
+	testCases := map[string]struct {
+		secret        func() *v1.Secret
+		opts          SecretControllerOptions
+		run           func(*SecretController, *v1.Secret)
+		expectedVerbs []string
+		// The ownership label of the stored secret, if not deleted.
+		expectedOwner string
+		expectDeleted bool
+	}{
+		"Baseline secret is adopted and rotated": {
+			secret:        createBaselineSecret,
+			run:           func(c *SecretController, s *v1.Secret) { c.scrtUpdated(nil, s) },
+			expectedVerbs: []string{"update", "update"},
+			expectedOwner: managedByLabelValue,
+		},
+		"Deleted baseline secret is re-created": {
+			secret:        createBaselineSecret,
+			run:           deleteSecret,
+			expectedVerbs: []string{"delete", "create"},
+			expectedOwner: managedByLabelValue,
+		},
+		"Baseline secret is deleted along with its service account": {
+			secret: createBaselineSecret,
+			run: func(c *SecretController, s *v1.Secret) {
+				c.saDeleted(createServiceAccount("test", "test-ns"))
+			},
+			expectedVerbs: []string{"delete"},
+			expectDeleted: true,
+		},
+		"User-managed secret is not rotated": {
+			secret:        createUserSecret,
+			opts:          SecretControllerOptions{AdoptUnlabeled: true},
+			run:           func(c *SecretController, s *v1.Secret) { c.scrtUpdated(nil, s) },
+			expectedVerbs: []string{},
+			expectedOwner: managedByUserLabelValue,
+		},
+		"Deleted user-managed secret is not re-created": {
+			secret:        createUserSecret,
+			run:           deleteSecret,
+			expectedVerbs: []string{"delete"},
+			expectDeleted: true,
+		},
+		"User-managed secret survives the deletion of its service account": {
+			secret: createUserSecret,
+			run: func(c *SecretController, s *v1.Secret) {
+				c.saDeleted(createServiceAccount("test", "test-ns"))
+			},
+			expectedVerbs: []string{},
+			expectedOwner: managedByUserLabelValue,
+		},
+		"Unannotated secret is not rotated": {
+			secret:        createUnannotatedSecret,
+			run:           func(c *SecretController, s *v1.Secret) { c.scrtUpdated(nil, s) },
+			expectedVerbs: []string{},
+		},
+		"Unannotated secret is adopted and rotated": {
+			secret:        createUnannotatedSecret,
+			opts:          SecretControllerOptions{AdoptUnlabeled: true},
+			run:           func(c *SecretController, s *v1.Secret) { c.scrtUpdated(nil, s) },
+			expectedVerbs: []string{"update", "update"},
+			expectedOwner: managedByLabelValue,
+		},
+	}
+
+	for k, tc := range testCases {
+		scrt := tc.secret()
+		client := fake.NewSimpleClientset(tc.secret())
+		controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, tc.opts)
+		if err := controller.scrtStore.Add(scrt); err != nil {
+			t.Errorf("%s: failed to add a secret (error %v)", k, err)
+		}
+
+		tc.run(controller, scrt)
+		drainQueue(controller)
+
+		verbs := []string{}
+		for _, a := range client.Actions() {
+			verbs = append(verbs, a.GetVerb())
+		}
+		if !reflect.DeepEqual(verbs, tc.expectedVerbs) {
+			t.Errorf("%s: expect verbs %v but actual verbs are %v", k, tc.expectedVerbs, verbs)
+		}
+		stored, err := client.CoreV1().Secrets("test-ns").Get("istio.test", metav1.GetOptions{})
+		if tc.expectDeleted {
+			if !errors.IsNotFound(err) {
+				t.Errorf("%s: expect the secret to be deleted (error: %v)", k, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: failed to get the secret: %v", k, err)
+		}
+		if owner := stored.Labels[managedByLabelKey]; owner != tc.expectedOwner {
+			t.Errorf("%s: unexpected owner (expecting %q, actual %q)", k, tc.expectedOwner, owner)
+		}
+	}
+}
+
+func TestOrphanSecrets(t *testing.T) {
+	managed := createSecret("test", "istio.test", "test-ns")
+	managed.Labels["app"] = "test"
+	unmanaged := createSecret("other", "istio.other", "test-ns")
+	unmanaged.Labels = nil
+
+	client := fake.NewSimpleClientset(managed)
+	controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll,
+		SecretControllerOptions{OrphanOnShutdown: true})
+	for _, s := range []*v1.Secret{managed, unmanaged} {
+		if err := controller.scrtStore.Add(s); err != nil {
+			t.Errorf("Failed to add a secret (error %v)", err)
+		}
+	}
+
+	controller.orphanSecrets()
+
+	scrt, err := client.CoreV1().Secrets("test-ns").Get("istio.test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get the orphaned secret: %v", err)
+	}
+	expected := map[string]string{"app": "test", managedByLabelKey: managedByUserLabelValue}
+	if !reflect.DeepEqual(scrt.Labels, expected) {
+		t.Errorf("Unexpected labels of the orphaned secret (expecting %v, actual %v)", expected, scrt.Labels)
+	}
+	if len(client.Actions()) != 2 {
+		t.Errorf("Expect only the managed secret to be updated, actual actions are %v", client.Actions())
+	}
+}
+
+func TestRefreshMalformedSecret(t *testing.T) {
+	scrt := createSecret("test", "istio.test", "test-ns")
+	client := fake.NewSimpleClientset(createSecret("test", "istio.test", "test-ns"))
+	controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+
+	// "fake cert chain" is not a PEM-encoded certificate.
+	controller.scrtUpdated(nil, scrt)
+	drainQueue(controller)
+
+	verbs := []string{}
+	for _, a := range client.Actions() {
+		verbs = append(verbs, a.GetVerb())
+	}
+	if expected := []string{"update"}; !reflect.DeepEqual(verbs, expected) {
+		t.Errorf("expect verbs %v but actual verbs are %v", expected, verbs)
+	}
+}
+
+// countingCa is a fakeCa counting the generated certificates.
+type countingCa struct {
+	fakeCa
+	generated int
+}
+
+func (ca *countingCa) Generate(name, namespace string) (chain, key []byte, err error) {
+	ca.generated++
+	return ca.fakeCa.Generate(name, namespace)
+}
+
+func TestRefreshSecretOnConflict(t *testing.T) {
+	scrt := createSecret("test", "istio.test", "test-ns")
+	client := fake.NewSimpleClientset(createSecret("test", "istio.test", "test-ns"))
+	conflicts := 2
+	client.PrependReactor("update", "secrets", func(ktesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			return false, nil, nil
+		}
+		conflicts--
+		return true, nil, errors.NewConflict(schema.GroupResource{Resource: "secrets"}, "istio.test", nil)
+	})
+	ca := &countingCa{}
+	controller := NewSecretController(ca, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+
+	controller.refreshSecret(scrt, false)
+
+	verbs := []string{}
+	for _, a := range client.Actions() {
+		verbs = append(verbs, a.GetVerb())
+	}
+	if expected := []string{"update", "get", "update", "get", "update"}; !reflect.DeepEqual(verbs, expected) {
+		t.Errorf("expect verbs %v but actual verbs are %v", expected, verbs)
+	}
+	if ca.generated != 1 {
+		t.Errorf("Expecting a single certificate to be issued across the retries, actual %d", ca.generated)
+	}
+}
+
+// identityKMS is a fake KMS that does not transform the data keys.
+type identityKMS struct{}
+
+func (identityKMS) KeyName() string {
+	return "fake-kms-key"
+}
+
+func (identityKMS) Encrypt(plaintext []byte) ([]byte, error) {
+	return plaintext, nil
+}
+
+func (identityKMS) Decrypt(ciphertext []byte) ([]byte, error) {
+	return ciphertext, nil
+}
+
+func TestKeyEncryption(t *testing.T) {
+	client := fake.NewSimpleClientset(createSecret("old", "istio.old", "test-ns"))
+	controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll,
+		SecretControllerOptions{KeyEncryption: identityKMS{}})
+
+	controller.upsertSecret("test", "test-ns")
+	controller.refreshSecret(createSecret("old", "istio.old", "test-ns"), false)
+
+	actions := client.Actions()
+	scrts := []*v1.Secret{}
+	for _, a := range actions {
+		switch a := a.(type) {
+		case ktesting.CreateAction:
+			scrts = append(scrts, a.GetObject().(*v1.Secret))
+		case ktesting.UpdateAction:
+			scrts = append(scrts, a.GetObject().(*v1.Secret))
+		}
+	}
+	if len(scrts) != 2 {
+		t.Fatalf("expect a created and an updated secret but actions are %v", actions)
+	}
+	for _, scrt := range scrts {
+		if _, ok := scrt.Data[privateKeyID]; ok {
+			t.Errorf("secret %s holds the plaintext private key", scrt.Name)
+		}
+		key, err := kms.Open(identityKMS{}, scrt.Data[encryptedPrivateKeyID])
+		if err != nil || string(key) != "fake key" {
+			t.Errorf("secret %s: expect the envelope to hold %q but got %q (error: %v)", scrt.Name, "fake key", key, err)
+		}
+	}
+}
+
+func TestKeyReuse(t *testing.T) {
+	now := time.Now()
+	testCases := map[string]struct {
+		opts          SecretControllerOptions
+		keyCreatedAt  time.Time
+		rekey         bool
+		expectedChain string
+		expectedKey   string
+	}{
+		"A new key is generated on every rotation by default": {
+			keyCreatedAt:  now,
+			expectedChain: "fake cert chain",
+			expectedKey:   "fake key",
+		},
+		"The key is re-certified if keys are reused": {
+			opts:          SecretControllerOptions{ReuseKeys: true, MaxKeyAge: 24 * time.Hour},
+			keyCreatedAt:  now.Add(-time.Hour),
+			expectedChain: "recertified cert chain",
+			expectedKey:   "old key",
+		},
+		"A key older than the maximum key age is regenerated": {
+			opts:          SecretControllerOptions{ReuseKeys: true, MaxKeyAge: 24 * time.Hour},
+			keyCreatedAt:  now.Add(-48 * time.Hour),
+			expectedChain: "fake cert chain",
+			expectedKey:   "fake key",
+		},
+		"A key of unknown age is regenerated": {
+			opts:          SecretControllerOptions{ReuseKeys: true},
+			expectedChain: "fake cert chain",
+			expectedKey:   "fake key",
+		},
+		"The key is regenerated on request": {
+			opts:          SecretControllerOptions{ReuseKeys: true},
+			keyCreatedAt:  now,
+			rekey:         true,
+			expectedChain: "fake cert chain",
+			expectedKey:   "fake key",
+		},
+	}
+
+	for k, tc := range testCases {
+		scrt := createSecret("test", "istio.test", "test-ns")
+		scrt.Data[privateKeyID] = []byte("old key")
+		if !tc.keyCreatedAt.IsZero() {
+			scrt.Annotations[keyCreatedAtAnnotationKey] = tc.keyCreatedAt.UTC().Format(time.RFC3339)
+		}
+		client := fake.NewSimpleClientset(scrt)
+		controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, tc.opts)
+
+		controller.refreshSecret(scrt, tc.rekey)
+
+		updated, err := client.CoreV1().Secrets("test-ns").Get("istio.test", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%s: failed to get the secret (error: %v)", k, err)
+		}
+		if chain := string(updated.Data[certChainID]); chain != tc.expectedChain {
+			t.Errorf("%s: expect cert chain %q but got %q", k, tc.expectedChain, chain)
+		}
+		if key := string(updated.Data[privateKeyID]); key != tc.expectedKey {
+			t.Errorf("%s: expect key %q but got %q", k, tc.expectedKey, key)
+		}
+		if tc.opts.ReuseKeys {
+			if _, err := time.Parse(time.RFC3339, updated.Annotations[keyCreatedAtAnnotationKey]); err != nil {
+				t.Errorf("%s: expect the key creation time to be annotated (error: %v)", k, err)
+			}
+		}
+	}
+}
+
+// pemCa is a fake CA that issues PEM-encoded certificates.
+type pemCa struct {
+	fakeCa
+	cert []byte
+}
+
+func (ca pemCa) Generate(name, namespace string) (chain, key []byte, err error) {
+	return ca.cert, []byte("fake key"), nil
+}
+
+func (ca pemCa) GetRootCertificate() []byte {
+	return ca.cert
+}
+
+func TestPEMComments(t *testing.T) {
+	cert, _ := certmanager.GenCert(certmanager.CertOptions{
+		Host:         "spiffe://cluster.local/ns/test-ns/sa/test",
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		IsSelfSigned: true,
+		RSAKeySize:   512,
+	})
+	client := fake.NewSimpleClientset()
+	controller := NewSecretController(pemCa{cert: cert}, client.CoreV1(), metav1.NamespaceAll,
+		SecretControllerOptions{PEMComments: true})
+
+	controller.upsertSecret("test", "test-ns")
+	scrt, err := client.CoreV1().Secrets("test-ns").Get("istio.test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get the created secret (error: %v)", err)
+	}
+	for _, id := range []string{certChainID, rootCertID} {
+		if !bytes.HasPrefix(scrt.Data[id], []byte("# Identity: spiffe://cluster.local/ns/test-ns/sa/test\n")) {
+			t.Errorf("Expect %s to be commented but got\n%s", id, scrt.Data[id])
+		}
+	}
+	if _, ok := scrt.Annotations[expiresAtAnnotationKey]; !ok {
+		t.Errorf("Expect the status annotations to be set on the commented secret: %v", scrt.Annotations)
+	}
+
+	// The commented root certificate is not mistaken for an outdated one.
+	client.ClearActions()
+	controller.scrtUpdated(nil, scrt)
+	drainQueue(controller)
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("Expect the secret not to be refreshed but actions are %v", actions)
+	}
+}
+
+func TestRequestIDAnnotation(t *testing.T) {
+	cert, _ := certmanager.GenCert(certmanager.CertOptions{
+		Host:         "spiffe://cluster.local/ns/test-ns/sa/test",
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		IsSelfSigned: true,
+		RSAKeySize:   512,
+	})
+	client := fake.NewSimpleClientset()
+	controller := NewSecretController(pemCa{cert: cert}, client.CoreV1(), metav1.NamespaceAll,
+		SecretControllerOptions{})
+
+	controller.upsertSecret("test", "test-ns")
+	scrt, err := client.CoreV1().Secrets("test-ns").Get("istio.test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get the created secret (error: %v)", err)
+	}
+	created := scrt.Annotations[requestIDAnnotationKey]
+	if created == "" {
+		t.Fatalf("Expect the created secret to be annotated with a request ID: %v", scrt.Annotations)
+	}
+
+	if err := controller.scrtStore.Add(scrt); err != nil {
+		t.Fatal(err)
+	}
+	controller.refreshSecret(scrt, true)
+	scrt, err = client.CoreV1().Secrets("test-ns").Get("istio.test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get the refreshed secret (error: %v)", err)
+	}
+	if refreshed := scrt.Annotations[requestIDAnnotationKey]; refreshed == "" || refreshed == created {
+		t.Errorf("Expect the refreshed secret to be annotated with a new request ID but got %q", refreshed)
+	}
+}
+
+// chainCa issues a fixed certificate chain, under the certificate of pemCa as root.
+type chainCa struct {
+	pemCa
+	chain []byte
+}
+
+func (ca chainCa) Generate(name, namespace string) (chain, key []byte, err error) {
+	return ca.chain, []byte("fake key"), nil
+}
+
+func TestMinimalChains(t *testing.T) {
+	genCert := func(host string) []byte {
+		cert, _ := certmanager.GenCert(certmanager.CertOptions{
+			Host:         host,
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(24 * time.Hour),
+			IsSelfSigned: true,
+			RSAKeySize:   512,
+		})
+		return cert
+	}
+	leaf, root := genCert("spiffe://cluster.local/ns/test-ns/sa/test"), genCert("root")
+	chain := append(append(append([]byte{}, leaf...), root...), root...)
+
+	testCases := map[string]struct {
+		opts          SecretControllerOptions
+		expectedChain []byte
+	}{
+		"Chain is kept as issued": {
+			expectedChain: chain,
+		},
+		"Root is dropped from the chain": {
+			opts:          SecretControllerOptions{MinimalChains: true},
+			expectedChain: leaf,
+		},
+	}
+
+	for k, tc := range testCases {
+		client := fake.NewSimpleClientset()
+		controller := NewSecretController(chainCa{pemCa: pemCa{cert: root}, chain: chain}, client.CoreV1(),
+			metav1.NamespaceAll, tc.opts)
+
+		controller.upsertSecret("test", "test-ns")
+		scrt, err := client.CoreV1().Secrets("test-ns").Get("istio.test", metav1.GetOptions{})
+		if err != nil {
+			t.Errorf("%s: failed to get the created secret (error: %v)", k, err)
+			continue
+		}
+		if !bytes.Equal(scrt.Data[certChainID], tc.expectedChain) {
+			t.Errorf("%s: unexpected certificate chain\n%s", k, scrt.Data[certChainID])
+		}
+		if !bytes.Equal(scrt.Data[rootCertID], root) {
+			t.Errorf("%s: unexpected root certificate\n%s", k, scrt.Data[rootCertID])
+		}
+	}
+}
+
+func TestSamePEM(t *testing.T) {
+	block := "-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n"
+	testCases := map[string]struct {
+		a, b     string
+		expected bool
+	}{
+		"Comments are ignored": {
+			a:        block,
+			b:        "# comment\n" + block,
+			expected: true,
+		},
+		"Different blocks differ": {
+			a: block,
+			b: strings.Replace(block, "AAAA", "AAAB", 1),
+		},
+		"Missing blocks differ": {
+			a: block + block,
+			b: block,
+		},
+		"Non-PEM data is compared as is": {
+			a:        "fake root cert",
+			b:        "fake root cert",
+			expected: true,
+		},
+	}
+
+	for k, tc := range testCases {
+		if actual := samePEM([]byte(tc.a), []byte(tc.b)); actual != tc.expected {
+			t.Errorf("%s: expect %v but got %v", k, tc.expected, actual)
+		}
+	}
+}
+
+func TestReissueExpiring(t *testing.T) {
+	genCert := func(ttl time.Duration) []byte {
+		cert, _ := certmanager.GenCert(certmanager.CertOptions{
+			NotAfter:     time.Now().Add(ttl),
+			IsSelfSigned: true,
+			RSAKeySize:   512,
+		})
+		return cert
+	}
+	expiring, fresh := genCert(time.Hour), genCert(48*time.Hour)
+
+	testCases := map[string]struct {
+		namespace        string
+		expectedReissued []string
+	}{
+		"The expiring secrets of all namespaces are reissued": {
+			expectedReissued: []string{"other-ns/istio.expiring", "test-ns/istio.expiring"},
+		},
+		"The expiring secrets of the namespace are reissued": {
+			namespace:        "test-ns",
+			expectedReissued: []string{"test-ns/istio.expiring"},
+		},
+	}
+
+	for k, tc := range testCases {
+		client := fake.NewSimpleClientset()
+		controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+		for _, ns := range []string{"test-ns", "other-ns"} {
+			for name, cert := range map[string][]byte{"expiring": expiring, "fresh": fresh} {
+				scrt := createSecret(name, "istio."+name, ns)
+				scrt.Data[certChainID] = cert
+				if err := controller.scrtStore.Add(scrt); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := client.CoreV1().Secrets(ns).Create(scrt); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		client.ClearActions()
+
+		reissued := []string{}
+		for _, id := range controller.ReissueExpiring(24*time.Hour, tc.namespace) {
+			reissued = append(reissued, id.Namespace+"/"+id.SecretName)
+		}
+		sort.Strings(reissued)
+		if !reflect.DeepEqual(reissued, tc.expectedReissued) {
+			t.Errorf("%s: expect %v to be reissued but got %v", k, tc.expectedReissued, reissued)
+		}
+
+		drainQueue(controller)
+		updated := []string{}
+		for _, a := range client.Actions() {
+			if a, ok := a.(ktesting.UpdateAction); ok && a.GetVerb() == "update" {
+				scrt := a.GetObject().(*v1.Secret)
+				updated = append(updated, scrt.Namespace+"/"+scrt.Name)
+			}
+		}
+		sort.Strings(updated)
+		if !reflect.DeepEqual(updated, tc.expectedReissued) {
+			t.Errorf("%s: expect %v to be updated but got %v", k, tc.expectedReissued, updated)
+		}
+	}
+}
+
+func TestTLSKeys(t *testing.T) {
+	testCases := map[string]struct {
+		opts         SecretControllerOptions
+		expectedData map[string]string
+	}{
+		"Istio keys only": {
+			expectedData: map[string]string{
+				certChainID:  "fake cert chain",
+				privateKeyID: "fake key",
+				rootCertID:   "fake root cert",
+			},
+		},
+		"Istio keys mirrored under the TLS keys": {
+			opts: SecretControllerOptions{TLSKeys: true},
+			expectedData: map[string]string{
+				certChainID:         "fake cert chain",
+				privateKeyID:        "fake key",
+				rootCertID:          "fake root cert",
+				v1.TLSCertKey:       "fake cert chain",
+				v1.TLSPrivateKeyKey: "fake key",
+				tlsRootCertID:       "fake root cert",
+			},
+		},
+		"Encrypted private key is not mirrored": {
+			opts: SecretControllerOptions{TLSKeys: true, KeyEncryption: identityKMS{}},
+			expectedData: map[string]string{
+				certChainID:           "fake cert chain",
+				encryptedPrivateKeyID: "*",
+				rootCertID:            "fake root cert",
+				v1.TLSCertKey:         "fake cert chain",
+				tlsRootCertID:         "fake root cert",
+			},
+		},
+	}
+
+	for k, tc := range testCases {
+		client := fake.NewSimpleClientset()
+		controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, tc.opts)
+		controller.saAdded(createServiceAccount("test", "test-ns"))
+		drainQueue(controller)
+
+		scrt, err := client.CoreV1().Secrets("test-ns").Get("istio.test", metav1.GetOptions{})
+		if err != nil {
+			t.Errorf("%s: failed to get the secret (error %v)", k, err)
+			continue
+		}
+		// The KMS envelope is not deterministic, so only its presence is checked.
+		data := map[string]string{}
+		for key, value := range scrt.Data {
+			data[key] = string(value)
+			if tc.expectedData[key] == "*" {
+				data[key] = "*"
+			}
+		}
+		if !reflect.DeepEqual(data, tc.expectedData) {
+			t.Errorf("%s: unexpected data %v (expecting %v)", k, data, tc.expectedData)
+		}
+	}
+
+	// The mirrored keys are dropped once the option is turned off.
+	controller := NewSecretController(fakeCa{}, fake.NewSimpleClientset().CoreV1(), metav1.NamespaceAll,
+		SecretControllerOptions{})
+	scrt := createSecret("test", "istio.test", "test-ns")
+	scrt.Data[v1.TLSCertKey] = []byte("old cert chain")
+	controller.setTLSKeys(scrt)
+	if _, ok := scrt.Data[v1.TLSCertKey]; ok {
+		t.Errorf("The TLS keys should be removed when TLSKeys is not set")
+	}
+}
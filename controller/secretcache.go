@@ -0,0 +1,121 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// The data keys of the Istio secrets that are dropped from the informer cache.
+// The controller only reads the private keys when it re-certifies them, so
+// keeping them cached would grow the memory of the CA with every workload for
+// no benefit. The certificates are kept, as the rotation decisions need them.
+var uncachedSecretDataKeys = []string{privateKeyID, encryptedPrivateKeyID, v1.TLSPrivateKeyKey}
+
+// newSlimSecretListWatch wraps lw so that the uncached data keys are dropped
+// from the listed and watched secrets before they reach the informer cache.
+func newSlimSecretListWatch(lw *cache.ListWatch) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			obj, err := lw.List(options)
+			if err != nil {
+				return nil, err
+			}
+			if list, ok := obj.(*v1.SecretList); ok {
+				for i := range list.Items {
+					slimSecret(&list.Items[i])
+				}
+			}
+			return obj, nil
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			w, err := lw.Watch(options)
+			if err != nil {
+				return nil, err
+			}
+			return watch.Filter(w, func(e watch.Event) (watch.Event, bool) {
+				if scrt, ok := e.Object.(*v1.Secret); ok {
+					slimSecret(scrt)
+				}
+				return e, true
+			}), nil
+		},
+	}
+}
+
+// slimSecret drops the uncached data keys, and the alias of the private key,
+// from a secret decoded for the informer cache.
+func slimSecret(scrt *v1.Secret) {
+	for _, key := range uncachedSecretDataKeys {
+		delete(scrt.Data, key)
+	}
+	if alias, ok := parseDataKeyAliasesAnnotation(scrt)[privateKeyID]; ok && !reservedDataKeys(false).Has(alias) {
+		delete(scrt.Data, alias)
+	}
+}
+
+// isSlim returns true if the secret holds no private key, e.g. because it
+// comes from the informer cache.
+func isSlim(scrt *v1.Secret) bool {
+	for _, key := range uncachedSecretDataKeys {
+		if _, ok := scrt.Data[key]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// updateCachedSecret is like updateSecret, but first fetches the full secret
+// if the given one comes without its private key, so that writing a cached
+// secret back never erases the private key.
+func (sc *SecretController) updateCachedSecret(scrt *v1.Secret, mutate secretMutator) (*v1.Secret, error) {
+	full, err := sc.fullSecret(scrt)
+	if err != nil {
+		return nil, err
+	}
+	return updateSecret(sc.core, full, mutate)
+}
+
+// fullSecret returns the given secret, or fetches the full secret if the given
+// one comes without its private key.
+func (sc *SecretController) fullSecret(scrt *v1.Secret) (*v1.Secret, error) {
+	if !isSlim(scrt) {
+		return scrt, nil
+	}
+	full, err := sc.core.Secrets(scrt.GetNamespace()).Get(scrt.GetName(), metav1.GetOptions{})
+	recordAPIRequest("get", err)
+	return full, err
+}
+
+// FlushSecretCache replaces the informer cache of the Istio secrets with a
+// fresh list from the API server, e.g. after the secrets have been restored
+// from a backup while their watch was interrupted.
+func (sc *SecretController) FlushSecretCache() error {
+	obj, err := sc.scrtLW.List(metav1.ListOptions{})
+	recordAPIRequest("list", err)
+	if err != nil {
+		return err
+	}
+	list := obj.(*v1.SecretList)
+	secrets := make([]interface{}, len(list.Items))
+	for i := range list.Items {
+		secrets[i] = &list.Items[i]
+	}
+	return sc.scrtStore.Replace(secrets, list.ResourceVersion)
+}
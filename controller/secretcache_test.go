@@ -0,0 +1,137 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestSlimSecretListWatch(t *testing.T) {
+	encrypted := createSecret("enc", "istio.enc", "test-ns")
+	delete(encrypted.Data, privateKeyID)
+	encrypted.Data[encryptedPrivateKeyID] = []byte("fake envelope")
+	fakeWatch := watch.NewFake()
+	lw := newSlimSecretListWatch(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return &v1.SecretList{Items: []v1.Secret{*createSecret("test", "istio.test", "test-ns"), *encrypted}}, nil
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return fakeWatch, nil
+		},
+	})
+
+	checkSlim := func(scrt *v1.Secret) {
+		if !isSlim(scrt) {
+			t.Errorf("Secret %s still holds its private key: %v", scrt.Name, scrt.Data)
+		}
+		if string(scrt.Data[certChainID]) != "fake cert chain" || string(scrt.Data[rootCertID]) != "fake root cert" {
+			t.Errorf("Secret %s has lost its certificates: %v", scrt.Name, scrt.Data)
+		}
+	}
+
+	obj, err := lw.List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list the secrets (error: %v)", err)
+	}
+	for i := range obj.(*v1.SecretList).Items {
+		checkSlim(&obj.(*v1.SecretList).Items[i])
+	}
+
+	w, err := lw.Watch(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to watch the secrets (error: %v)", err)
+	}
+	defer w.Stop()
+	go fakeWatch.Modify(createSecret("test", "istio.test", "test-ns"))
+	e := <-w.ResultChan()
+	if e.Type != watch.Modified {
+		t.Errorf("Unexpected event type %v", e.Type)
+	}
+	checkSlim(e.Object.(*v1.Secret))
+}
+
+func TestUpdateCachedSecret(t *testing.T) {
+	testCases := map[string]struct {
+		slim          bool
+		expectedVerbs []string
+	}{
+		"A full secret is written as is": {
+			expectedVerbs: []string{"update"},
+		},
+		"A slim secret is fetched before it is written": {
+			slim:          true,
+			expectedVerbs: []string{"get", "update"},
+		},
+	}
+
+	for k, tc := range testCases {
+		client := fake.NewSimpleClientset(createSecret("test", "istio.test", "test-ns"))
+		controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+		scrt := createSecret("test", "istio.test", "test-ns")
+		if tc.slim {
+			slimSecret(scrt)
+		}
+
+		_, err := controller.updateCachedSecret(scrt, func(s *v1.Secret) bool {
+			s.Labels["app"] = "test"
+			return true
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to update the secret (error: %v)", k, err)
+		}
+
+		verbs := []string{}
+		for _, a := range client.Actions() {
+			verbs = append(verbs, a.GetVerb())
+		}
+		if !reflect.DeepEqual(verbs, tc.expectedVerbs) {
+			t.Errorf("%s: expect verbs %v but actual verbs are %v", k, tc.expectedVerbs, verbs)
+		}
+		updated, err := client.CoreV1().Secrets("test-ns").Get("istio.test", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%s: failed to get the secret (error: %v)", k, err)
+		}
+		if string(updated.Data[privateKeyID]) != "fake key" || updated.Labels["app"] != "test" {
+			t.Errorf("%s: unexpected secret after the update: %v", k, updated)
+		}
+	}
+}
+
+func TestFlushSecretCache(t *testing.T) {
+	client := fake.NewSimpleClientset(createSecret("test", "istio.test", "test-ns"))
+	controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+	if err := controller.scrtStore.Add(createSecret("gone", "istio.gone", "test-ns")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := controller.FlushSecretCache(); err != nil {
+		t.Fatalf("Failed to flush the secret cache (error: %v)", err)
+	}
+	if keys := controller.scrtStore.ListKeys(); !reflect.DeepEqual(keys, []string{"test-ns/istio.test"}) {
+		t.Errorf("Unexpected cached secrets %v", keys)
+	}
+	obj, exists, err := controller.scrtStore.GetByKey("test-ns/istio.test")
+	if err != nil || !exists || !isSlim(obj.(*v1.Secret)) {
+		t.Errorf("Expecting the listed secret to be cached without its private key (error: %v)", err)
+	}
+}
@@ -37,10 +37,13 @@ import (
 const resyncPeriod = 10 * time.Second
 
 // SecureNamingController watches services and pods to create a mapping from
-// service names to service accounts running the services.
+// service names to the identities (service accounts by default) running the services.
 type SecureNamingController struct {
 	client v1core.CoreV1Interface
 
+	// Determines the identity each pod runs as.
+	identity IdentityExtractor
+
 	mapping *SecureNamingMapping
 
 	podController cache.Controller
@@ -53,9 +56,10 @@ type SecureNamingController struct {
 }
 
 // NewSecureNamingController returns a pointer to a new instance of SecureNamingController.
-func NewSecureNamingController(core v1core.CoreV1Interface) *SecureNamingController {
+func NewSecureNamingController(core v1core.CoreV1Interface, identity IdentityExtractor) *SecureNamingController {
 	snc := &SecureNamingController{
 		client:       core,
+		identity:     identity,
 		mapping:      NewSecureNamingMapping(),
 		serviceQueue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
 	}
@@ -137,8 +141,14 @@ func (snc *SecureNamingController) processNextService() bool {
 	}
 
 	accounts := []string{}
-	for _, p := range pods.Items {
-		accounts = append(accounts, p.Spec.ServiceAccountName)
+	for i := range pods.Items {
+		p := &pods.Items[i]
+		id, err := snc.identity.GetIdentity(p)
+		if err != nil {
+			glog.Warningf("Failed to get the identity of pod %s/%s (error: %v)", p.Namespace, p.Name, err)
+			continue
+		}
+		accounts = append(accounts, id)
 	}
 	// Use `key` instead of `svc.GetName()` since `key` contains both service
 	// name and service namespace.
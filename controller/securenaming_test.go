@@ -94,7 +94,7 @@ func TestProcessNextServices(t *testing.T) {
 
 	for d, c := range cases {
 		core := fake.NewSimpleClientset().CoreV1()
-		snc := NewSecureNamingController(core)
+		snc := NewSecureNamingController(core, ServiceAccountIdentityExtractor{})
 
 		snc.mapping.mapping = c.initialMapping
 		snc.enqueueService(c.serviceToProcess)
@@ -187,7 +187,7 @@ func TestGetPodServices(t *testing.T) {
 
 	for ind, testCase := range cases {
 		cs := fake.NewSimpleClientset()
-		snc := NewSecureNamingController(cs.CoreV1())
+		snc := NewSecureNamingController(cs.CoreV1(), ServiceAccountIdentityExtractor{})
 
 		for _, service := range testCase.allServices {
 			err := snc.serviceIndexer.Add(service)
@@ -0,0 +1,294 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/auth/certmanager"
+	"istio.io/auth/pkg/requestid"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	extv1beta1 "k8s.io/client-go/kubernetes/typed/extensions/v1beta1"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/tools/cache"
+)
+
+/* #nosec: disable gas linter */
+const (
+	// The annotation on a Service or an Ingress naming the secret that holds its server certificate.
+	tlsSecretAnnotationKey = "istio.io/tls-secret"
+	// The annotation on a Service listing extra comma-separated DNS names for its server certificate.
+	tlsHostsAnnotationKey = "istio.io/tls-hosts"
+	// The annotation on a server certificate secret naming the object the secret is provisioned for.
+	tlsOwnerAnnotationKey = "istio.io/tls-owner"
+
+	// The data key of the root certificate in a server certificate secret.
+	tlsRootCertID = "ca.crt"
+
+	serverCertResyncPeriod = time.Minute
+)
+
+// ServerCertController provisions server certificates signed by the Istio CA
+// for annotated Services and Ingresses, so that gateways can terminate TLS
+// with certificates trusted by the mesh. The DNS SANs of an Ingress
+// certificate come from its TLS and rule hosts; those of a Service
+// certificate are its cluster DNS name plus any extra hosts in the
+// `istio.io/tls-hosts` annotation.
+type ServerCertController struct {
+	ca   certmanager.CertificateAuthority
+	core corev1.CoreV1Interface
+
+	ingressController cache.Controller
+	serviceController cache.Controller
+
+	// Generates the ID of each issuance, see requestIDAnnotationKey.
+	newRequestID func() string
+}
+
+// NewServerCertController returns a pointer to a newly constructed ServerCertController instance.
+func NewServerCertController(ca certmanager.CertificateAuthority, core corev1.CoreV1Interface,
+	extensions extv1beta1.ExtensionsV1beta1Interface, namespace string) *ServerCertController {
+
+	c := &ServerCertController{
+		ca:           ca,
+		core:         core,
+		newRequestID: requestid.New,
+	}
+
+	ingLW := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return extensions.Ingresses(namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return extensions.Ingresses(namespace).Watch(options)
+		},
+	}
+	_, c.ingressController = cache.NewInformer(ingLW, &v1beta1.Ingress{}, serverCertResyncPeriod,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.ingressUpserted,
+			DeleteFunc: c.ingressDeleted,
+			UpdateFunc: func(oldObj, curObj interface{}) { c.ingressUpserted(curObj) },
+		})
+
+	svcLW := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return core.Services(namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return core.Services(namespace).Watch(options)
+		},
+	}
+	_, c.serviceController = cache.NewInformer(svcLW, &v1.Service{}, serverCertResyncPeriod,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.serviceUpserted,
+			DeleteFunc: c.serviceDeleted,
+			UpdateFunc: func(oldObj, curObj interface{}) { c.serviceUpserted(curObj) },
+		})
+
+	return c
+}
+
+// Run starts the ServerCertController until stopCh is closed.
+func (sc *ServerCertController) Run(stopCh chan struct{}) {
+	go sc.ingressController.Run(stopCh)
+	go sc.serviceController.Run(stopCh)
+	<-stopCh
+}
+
+func (sc *ServerCertController) ingressUpserted(obj interface{}) {
+	ing, ok := obj.(*v1beta1.Ingress)
+	if !ok {
+		return
+	}
+	secretName, ok := ing.Annotations[tlsSecretAnnotationKey]
+	if !ok {
+		return
+	}
+
+	hosts := sets.NewString()
+	for _, tls := range ing.Spec.TLS {
+		hosts.Insert(tls.Hosts...)
+	}
+	for _, rule := range ing.Spec.Rules {
+		if rule.Host != "" {
+			hosts.Insert(rule.Host)
+		}
+	}
+	if hosts.Len() == 0 {
+		glog.Warningf("Ingress %s/%s requests a server certificate but has no hosts", ing.Namespace, ing.Name)
+		return
+	}
+
+	sc.upsertServerCert(getTLSOwner("ingress", ing.Name), ing.Namespace, secretName, hosts.List())
+}
+
+func (sc *ServerCertController) ingressDeleted(obj interface{}) {
+	ing, ok := obj.(*v1beta1.Ingress)
+	if !ok {
+		return
+	}
+	if secretName, ok := ing.Annotations[tlsSecretAnnotationKey]; ok {
+		sc.deleteServerCert(getTLSOwner("ingress", ing.Name), ing.Namespace, secretName)
+	}
+}
+
+func (sc *ServerCertController) serviceUpserted(obj interface{}) {
+	svc, ok := obj.(*v1.Service)
+	if !ok {
+		return
+	}
+	secretName, ok := svc.Annotations[tlsSecretAnnotationKey]
+	if !ok {
+		return
+	}
+
+	hosts := sets.NewString(fmt.Sprintf("%s.%s.svc.cluster.local", svc.Name, svc.Namespace))
+	if extra, ok := svc.Annotations[tlsHostsAnnotationKey]; ok {
+		for _, h := range strings.Split(extra, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				hosts.Insert(h)
+			}
+		}
+	}
+
+	sc.upsertServerCert(getTLSOwner("service", svc.Name), svc.Namespace, secretName, hosts.List())
+}
+
+func (sc *ServerCertController) serviceDeleted(obj interface{}) {
+	svc, ok := obj.(*v1.Service)
+	if !ok {
+		return
+	}
+	if secretName, ok := svc.Annotations[tlsSecretAnnotationKey]; ok {
+		sc.deleteServerCert(getTLSOwner("service", svc.Name), svc.Namespace, secretName)
+	}
+}
+
+// upsertServerCert makes sure the named secret holds a fresh certificate for
+// the hosts. Secrets not provisioned for the owner are never overwritten.
+func (sc *ServerCertController) upsertServerCert(owner, namespace, secretName string, hosts []string) {
+	existing, err := sc.core.Secrets(namespace).Get(secretName, metav1.GetOptions{})
+	recordAPIRequest("get", err)
+	if err != nil && !errors.IsNotFound(err) {
+		glog.Errorf("Failed to get secret %s/%s (error: %s)", namespace, secretName, err)
+		return
+	}
+
+	exists := err == nil
+	if exists {
+		if existing.Annotations[tlsOwnerAnnotationKey] != owner {
+			glog.Warningf("Secret %s/%s is not managed for %s; refusing to overwrite it", namespace, secretName, owner)
+			return
+		}
+		if !sc.serverCertNeedsRefresh(existing, hosts) {
+			return
+		}
+	}
+
+	rid := sc.newRequestID()
+	chain, key, err := sc.ca.GenerateServerCert(hosts)
+	if err != nil {
+		glog.Errorf("Failed to generate the server certificate for %s in namespace %s (request: %s, error: %s)",
+			owner, namespace, rid, err)
+		recordFailure(failureIssue)
+		return
+	}
+	rootCert := sc.ca.GetRootCertificate()
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{tlsOwnerAnnotationKey: owner},
+			Name:        secretName,
+			Namespace:   namespace,
+		},
+		Data: map[string][]byte{
+			v1.TLSCertKey:       chain,
+			v1.TLSPrivateKeyKey: key,
+			tlsRootCertID:       rootCert,
+		},
+		Type: v1.SecretTypeTLS,
+	}
+	setStatusAnnotations(secret, chain, rootCert)
+	setRequestID(secret, rid)
+
+	if exists {
+		_, err = updateSecret(sc.core, existing, func(s *v1.Secret) bool {
+			if s.Annotations[tlsOwnerAnnotationKey] != owner {
+				return false
+			}
+			s.Annotations = secret.Annotations
+			s.Data = secret.Data
+			s.Type = secret.Type
+			return true
+		})
+	} else {
+		_, err = sc.core.Secrets(namespace).Create(secret)
+		recordAPIRequest("create", err)
+	}
+	if err != nil {
+		glog.Errorf("Failed to write server certificate secret %s/%s (request: %s, error: %s)",
+			namespace, secretName, rid, err)
+		return
+	}
+
+	glog.Infof("Server certificate for %s in namespace %s has been written to secret %s (hosts: %v, request: %s)",
+		owner, namespace, secretName, hosts, rid)
+}
+
+func (sc *ServerCertController) deleteServerCert(owner, namespace, secretName string) {
+	existing, err := sc.core.Secrets(namespace).Get(secretName, metav1.GetOptions{})
+	recordAPIRequest("get", err)
+	if err != nil || existing.Annotations[tlsOwnerAnnotationKey] != owner {
+		return
+	}
+
+	err = sc.core.Secrets(namespace).Delete(secretName, nil)
+	recordAPIRequest("delete", err)
+	if err != nil && !errors.IsNotFound(err) {
+		glog.Errorf("Failed to delete server certificate secret %s/%s (error: %s)", namespace, secretName, err)
+	}
+}
+
+// serverCertNeedsRefresh returns true if the certificate in the secret is
+// malformed, about to expire, issued for different hosts, or chained to an
+// outdated root certificate.
+func (sc *ServerCertController) serverCertNeedsRefresh(scrt *v1.Secret, hosts []string) bool {
+	cert, err := parseCertificate(scrt.Data[v1.TLSCertKey])
+	if err != nil {
+		return true
+	}
+	if time.Until(cert.NotAfter) < serverCertResyncPeriod {
+		return true
+	}
+	if !sets.NewString(cert.DNSNames...).Equal(sets.NewString(hosts...)) {
+		return true
+	}
+	return !bytes.Equal(sc.ca.GetRootCertificate(), scrt.Data[tlsRootCertID])
+}
+
+func getTLSOwner(kind, name string) string {
+	return kind + "/" + name
+}
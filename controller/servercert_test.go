@@ -0,0 +1,136 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func createServerCertSecret(owner, name, namespace string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{tlsOwnerAnnotationKey: owner, requestIDAnnotationKey: testRequestID},
+			Name:        name,
+			Namespace:   namespace,
+		},
+		Data: map[string][]byte{
+			v1.TLSCertKey:       []byte("fake server cert chain"),
+			v1.TLSPrivateKeyKey: []byte("fake server key"),
+			tlsRootCertID:       []byte("fake root cert"),
+		},
+		Type: v1.SecretTypeTLS,
+	}
+}
+
+func createIngress(name, namespace string, annotations map[string]string, hosts ...string) *v1beta1.Ingress {
+	ing := &v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: annotations,
+			Name:        name,
+			Namespace:   namespace,
+		},
+	}
+	for _, h := range hosts {
+		ing.Spec.Rules = append(ing.Spec.Rules, v1beta1.IngressRule{Host: h})
+	}
+	return ing
+}
+
+func TestServerCertController(t *testing.T) {
+	gvr := schema.GroupVersionResource{
+		Resource: "secrets",
+		Version:  "v1",
+	}
+	annotations := map[string]string{tlsSecretAnnotationKey: "gateway-certs"}
+
+	testCases := map[string]struct {
+		existingSecret  *v1.Secret
+		ingressToAdd    *v1beta1.Ingress
+		ingressToDelete *v1beta1.Ingress
+		serviceToAdd    *v1.Service
+		expectedActions []ktesting.Action
+	}{
+		"Ingress without the annotation is ignored": {
+			ingressToAdd:    createIngress("ing", "ns", nil, "foo.com"),
+			expectedActions: []ktesting.Action{},
+		},
+		"Annotated ingress gets a server certificate": {
+			ingressToAdd: createIngress("ing", "ns", annotations, "foo.com"),
+			expectedActions: []ktesting.Action{
+				ktesting.NewGetAction(gvr, "ns", "gateway-certs"),
+				ktesting.NewCreateAction(gvr, "ns", createServerCertSecret("ingress/ing", "gateway-certs", "ns")),
+			},
+		},
+		"Secret not managed for the ingress is not overwritten": {
+			existingSecret: createServerCertSecret("ingress/other", "gateway-certs", "ns"),
+			ingressToAdd:   createIngress("ing", "ns", annotations, "foo.com"),
+			expectedActions: []ktesting.Action{
+				ktesting.NewGetAction(gvr, "ns", "gateway-certs"),
+			},
+		},
+		"Deleting an ingress deletes its server certificate": {
+			existingSecret:  createServerCertSecret("ingress/ing", "gateway-certs", "ns"),
+			ingressToDelete: createIngress("ing", "ns", annotations, "foo.com"),
+			expectedActions: []ktesting.Action{
+				ktesting.NewGetAction(gvr, "ns", "gateway-certs"),
+				ktesting.NewDeleteAction(gvr, "ns", "gateway-certs"),
+			},
+		},
+		"Annotated service gets a server certificate": {
+			serviceToAdd: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations, Name: "svc", Namespace: "ns"},
+			},
+			expectedActions: []ktesting.Action{
+				ktesting.NewGetAction(gvr, "ns", "gateway-certs"),
+				ktesting.NewCreateAction(gvr, "ns", createServerCertSecret("service/svc", "gateway-certs", "ns")),
+			},
+		},
+	}
+
+	for k, tc := range testCases {
+		objs := []runtime.Object{}
+		if tc.existingSecret != nil {
+			objs = append(objs, tc.existingSecret)
+		}
+		client := fake.NewSimpleClientset(objs...)
+		controller := NewServerCertController(fakeCa{}, client.CoreV1(), client.ExtensionsV1beta1(),
+			metav1.NamespaceAll)
+		controller.newRequestID = func() string { return testRequestID }
+
+		if tc.ingressToAdd != nil {
+			controller.ingressUpserted(tc.ingressToAdd)
+		}
+		if tc.ingressToDelete != nil {
+			controller.ingressDeleted(tc.ingressToDelete)
+		}
+		if tc.serviceToAdd != nil {
+			controller.serviceUpserted(tc.serviceToAdd)
+		}
+
+		actions := client.Actions()
+		if !reflect.DeepEqual(actions, tc.expectedActions) {
+			t.Errorf("%s: expect actions to be \n\t%v\n but actual actions are \n\t%v", k, tc.expectedActions, actions)
+		}
+	}
+}
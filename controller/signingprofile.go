@@ -0,0 +1,61 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+
+	"istio.io/auth/certmanager"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// The annotation on a namespace selecting the signing profile of its workloads.
+const signingProfileAnnotationKey = "istio.io/signing-profile"
+
+// SigningProfile is an alternate CA for the workloads of the default trust
+// domain, e.g. one signing with a dedicated intermediate CA, which namespaces
+// select with the `istio.io/signing-profile` annotation. It lets the PCI
+// namespaces of a cluster, for instance, be issued by another intermediate
+// than the others.
+type SigningProfile struct {
+	CA certmanager.CertificateAuthority
+
+	// The namespaces allowed to select the profile; a namespace annotated
+	// with a profile it is not allowed to get no certificates.
+	Namespaces sets.String
+}
+
+// signingProfileCA returns the CA of the signing profile that the namespace
+// is annotated with. Selecting an unknown profile or one the namespace is not
+// allowed to is an error, so that the certificates are never issued by
+// another intermediate than the one intended.
+func (sc *SecretController) signingProfileCA(ns *v1.Namespace, profile string) (certmanager.CertificateAuthority,
+	error) {
+
+	if domain, ok := ns.Labels[trustDomainLabelKey]; ok {
+		return nil, fmt.Errorf("namespace %s selects both trust domain %q and signing profile %q, "+
+			"which only apply to the default trust domain", ns.Name, domain, profile)
+	}
+	p, ok := sc.opts.SigningProfiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("namespace %s is annotated with unknown signing profile %q", ns.Name, profile)
+	}
+	if !p.Namespaces.Has(ns.Name) {
+		return nil, fmt.Errorf("namespace %s is not allowed to select signing profile %q", ns.Name, profile)
+	}
+	return p.CA, nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"testing"
+
+	"istio.io/auth/certmanager"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestSigningProfiles(t *testing.T) {
+	annotated := func(name, profile string, labels map[string]string) *v1.Namespace {
+		return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{signingProfileAnnotationKey: profile},
+			Labels:      labels,
+		}}
+	}
+	namespaces := []*v1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "plain"}},
+		annotated("payments", "pci", nil),
+		annotated("frontend", "pci", nil),
+		annotated("billing", "unknown", nil),
+		annotated("tenant-ns", "pci", map[string]string{trustDomainLabelKey: "tenant.com"}),
+	}
+
+	testCases := map[string]struct {
+		namespace    string
+		expectedRoot string
+	}{
+		"Namespace without profile uses the default CA": {
+			namespace:    "plain",
+			expectedRoot: "fake root cert",
+		},
+		"Allowed namespace uses the CA of its profile": {
+			namespace:    "payments",
+			expectedRoot: "pci root cert",
+		},
+		"Namespace not allowed to select the profile gets no secret": {
+			namespace: "frontend",
+		},
+		"Namespace annotated with an unknown profile gets no secret": {
+			namespace: "billing",
+		},
+		"Namespace selecting both a trust domain and a profile gets no secret": {
+			namespace: "tenant-ns",
+		},
+	}
+
+	for k, tc := range testCases {
+		client := fake.NewSimpleClientset()
+		controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{
+			TrustDomains: map[string]certmanager.CertificateAuthority{
+				"tenant.com": domainCa{root: "tenant root cert"},
+			},
+			SigningProfiles: map[string]SigningProfile{
+				"pci": {
+					CA:         domainCa{root: "pci root cert"},
+					Namespaces: sets.NewString("payments", "tenant-ns"),
+				},
+			},
+		})
+		for _, ns := range namespaces {
+			if err := controller.nsStore.Add(ns); err != nil {
+				t.Fatalf("%s: failed to add a namespace (error %v)", k, err)
+			}
+		}
+
+		controller.upsertSecret("test", tc.namespace)
+
+		actions := client.Actions()
+		if tc.expectedRoot == "" {
+			if len(actions) != 0 {
+				t.Errorf("%s: expect no actions but got %v", k, actions)
+			}
+			continue
+		}
+		if len(actions) != 1 {
+			t.Fatalf("%s: expect a single create action but got %v", k, actions)
+		}
+		scrt := actions[0].(ktesting.CreateAction).GetObject().(*v1.Secret)
+		if root := string(scrt.Data[rootCertID]); root != tc.expectedRoot {
+			t.Errorf("%s: expect root certificate %q but got %q", k, tc.expectedRoot, root)
+		}
+	}
+}
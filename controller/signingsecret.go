@@ -0,0 +1,160 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// The data keys of the signing material in a signing secret, as in the
+// 'cacerts' secret that the CA files are usually mounted from.
+const (
+	SigningSecretCertChainID   = "cert-chain.pem"
+	SigningSecretSigningCertID = "ca-cert.pem"
+	SigningSecretSigningKeyID  = "ca-key.pem"
+	SigningSecretRootCertID    = "root-cert.pem"
+
+	signingSecretResyncPeriod = time.Minute
+)
+
+// SigningMaterial holds the PEM-encoded signing material of a CA.
+type SigningMaterial struct {
+	CertChain   []byte
+	SigningCert []byte
+	SigningKey  []byte
+	RootCert    []byte
+}
+
+func (m *SigningMaterial) equal(o *SigningMaterial) bool {
+	return bytes.Equal(m.CertChain, o.CertChain) && bytes.Equal(m.SigningCert, o.SigningCert) &&
+		bytes.Equal(m.SigningKey, o.SigningKey) && bytes.Equal(m.RootCert, o.RootCert)
+}
+
+// SigningMaterialUpdater is a CA whose signing material can be replaced.
+type SigningMaterialUpdater interface {
+	UpdateSigningMaterial(certChain, signingCert, signingKey, rootCert []byte) error
+}
+
+// LoadSigningMaterial reads the signing material from the named secret.
+func LoadSigningMaterial(core corev1.CoreV1Interface, namespace, name string) (*SigningMaterial, error) {
+	scrt, err := core.Secrets(namespace).Get(name, metav1.GetOptions{})
+	recordAPIRequest("get", err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the signing secret %s/%s (error: %v)", namespace, name, err)
+	}
+	return signingMaterialOf(scrt)
+}
+
+func signingMaterialOf(scrt *v1.Secret) (*SigningMaterial, error) {
+	// The certificate chain is empty if the signing cert is issued by the root directly.
+	for _, key := range []string{SigningSecretSigningCertID, SigningSecretSigningKeyID, SigningSecretRootCertID} {
+		if len(scrt.Data[key]) == 0 {
+			return nil, fmt.Errorf("the signing secret %s/%s has no %q", scrt.Namespace, scrt.Name, key)
+		}
+	}
+	return &SigningMaterial{
+		CertChain:   scrt.Data[SigningSecretCertChainID],
+		SigningCert: scrt.Data[SigningSecretSigningCertID],
+		SigningKey:  scrt.Data[SigningSecretSigningKeyID],
+		RootCert:    scrt.Data[SigningSecretRootCertID],
+	}, nil
+}
+
+// SigningSecretController watches the secret holding the signing material of
+// a CA, so that the signing material is rotated by updating the secret.
+type SigningSecretController struct {
+	ca SigningMaterialUpdater
+
+	// The signing material last seen in the secret, which is only accessed by
+	// the informer. Material rejected by the CA is not retried until the
+	// secret changes again.
+	last *SigningMaterial
+
+	controller cache.Controller
+}
+
+// NewSigningSecretController returns a pointer to a newly constructed
+// SigningSecretController instance, for a CA currently using the loaded material.
+func NewSigningSecretController(ca SigningMaterialUpdater, core corev1.CoreV1Interface, namespace, name string,
+	loaded *SigningMaterial) *SigningSecretController {
+
+	c := &SigningSecretController{
+		ca:   ca,
+		last: loaded,
+	}
+
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return core.Secrets(namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return core.Secrets(namespace).Watch(options)
+		},
+	}
+	_, c.controller = cache.NewInformer(lw, &v1.Secret{}, signingSecretResyncPeriod,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.secretUpserted,
+			UpdateFunc: func(oldObj, curObj interface{}) { c.secretUpserted(curObj) },
+			DeleteFunc: func(obj interface{}) {
+				glog.Warningf("The signing secret %s/%s has been deleted; the CA keeps its signing material",
+					namespace, name)
+			},
+		})
+
+	return c
+}
+
+// Run starts the SigningSecretController until stopCh is closed.
+func (c *SigningSecretController) Run(stopCh chan struct{}) {
+	c.controller.Run(stopCh)
+}
+
+func (c *SigningSecretController) secretUpserted(obj interface{}) {
+	scrt, ok := obj.(*v1.Secret)
+	if !ok {
+		return
+	}
+	m, err := signingMaterialOf(scrt)
+	if err != nil {
+		glog.Errorf("Failed to update the signing material (error: %v)", err)
+		return
+	}
+	if c.last != nil && c.last.equal(m) {
+		return
+	}
+
+	c.last = m
+	if err := c.ca.UpdateSigningMaterial(m.CertChain, m.SigningCert, m.SigningKey, m.RootCert); err != nil {
+		glog.Errorf("Failed to update the signing material from secret %s/%s (error: %v)",
+			scrt.Namespace, scrt.Name, err)
+		return
+	}
+	glog.Infof("The signing material has been updated from secret %s/%s", scrt.Namespace, scrt.Name)
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+type fakeUpdater struct {
+	updates [][]byte
+	err     error
+}
+
+func (u *fakeUpdater) UpdateSigningMaterial(certChain, signingCert, signingKey, rootCert []byte) error {
+	u.updates = append(u.updates, signingCert)
+	return u.err
+}
+
+func createSigningSecret(signingCert string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cacerts", Namespace: "istio-system"},
+		Data: map[string][]byte{
+			SigningSecretCertChainID:   []byte("chain"),
+			SigningSecretSigningCertID: []byte(signingCert),
+			SigningSecretSigningKeyID:  []byte("key"),
+			SigningSecretRootCertID:    []byte("root"),
+		},
+	}
+}
+
+func TestLoadSigningMaterial(t *testing.T) {
+	client := fake.NewSimpleClientset(createSigningSecret("cert"))
+	m, err := LoadSigningMaterial(client.CoreV1(), "istio-system", "cacerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &SigningMaterial{
+		CertChain:   []byte("chain"),
+		SigningCert: []byte("cert"),
+		SigningKey:  []byte("key"),
+		RootCert:    []byte("root"),
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("expect signing material %v but got %v", expected, m)
+	}
+
+	if _, err := LoadSigningMaterial(client.CoreV1(), "istio-system", "missing"); err == nil {
+		t.Error("expect an error for a missing secret")
+	}
+
+	incomplete := createSigningSecret("cert")
+	incomplete.Name = "incomplete"
+	delete(incomplete.Data, SigningSecretSigningKeyID)
+	client = fake.NewSimpleClientset(incomplete)
+	_, err = LoadSigningMaterial(client.CoreV1(), "istio-system", "incomplete")
+	if expected := `the signing secret istio-system/incomplete has no "ca-key.pem"`; err == nil || err.Error() != expected {
+		t.Errorf("expect error %q but got %v", expected, err)
+	}
+}
+
+func TestSigningSecretController(t *testing.T) {
+	loaded, _ := signingMaterialOf(createSigningSecret("cert"))
+	testCases := map[string]struct {
+		secrets         []*v1.Secret
+		updateErr       error
+		expectedUpdates [][]byte
+	}{
+		"Loaded signing material is not updated": {
+			secrets: []*v1.Secret{createSigningSecret("cert")},
+		},
+		"Rotated signing material is updated": {
+			secrets:         []*v1.Secret{createSigningSecret("cert"), createSigningSecret("new cert")},
+			expectedUpdates: [][]byte{[]byte("new cert")},
+		},
+		"Incomplete signing material is ignored": {
+			secrets: []*v1.Secret{{
+				ObjectMeta: metav1.ObjectMeta{Name: "cacerts", Namespace: "istio-system"},
+				Data:       map[string][]byte{SigningSecretSigningCertID: []byte("new cert")},
+			}},
+		},
+		"Rejected signing material is not retried until the secret changes": {
+			secrets: []*v1.Secret{createSigningSecret("bad cert"), createSigningSecret("bad cert"),
+				createSigningSecret("other bad cert")},
+			updateErr:       errors.New("invalid signing material"),
+			expectedUpdates: [][]byte{[]byte("bad cert"), []byte("other bad cert")},
+		},
+	}
+
+	for k, tc := range testCases {
+		u := &fakeUpdater{err: tc.updateErr}
+		client := fake.NewSimpleClientset()
+		c := NewSigningSecretController(u, client.CoreV1(), "istio-system", "cacerts", loaded)
+		for _, s := range tc.secrets {
+			c.secretUpserted(s)
+		}
+		if !reflect.DeepEqual(u.updates, tc.expectedUpdates) {
+			t.Errorf("%s: expect updates %q but got %q", k, tc.expectedUpdates, u.updates)
+		}
+	}
+}
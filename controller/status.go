@@ -0,0 +1,204 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/auth/certmanager"
+	"istio.io/auth/cmd/istio_ca/version"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// The status annotations on an Istio secret, which describe its certificate
+// so that its freshness can be audited without decoding the PEM data.
+const (
+	// The RFC 3339 time after which the certificate should be renewed.
+	renewAfterAnnotationKey = "istio.io/renew-after"
+	// The RFC 3339 time at which the certificate is issued.
+	issuedAtAnnotationKey = "istio.io/issued-at"
+	// The RFC 3339 time at which the certificate expires.
+	expiresAtAnnotationKey = "istio.io/expires-at"
+	// The hex-encoded serial number of the certificate.
+	serialNumberAnnotationKey = "istio.io/serial-number"
+	// The hex-encoded SHA-256 fingerprint of the root certificate.
+	rootFingerprintAnnotationKey = "istio.io/root-cert-fingerprint"
+	// The version of Istio CA that wrote the secret.
+	controllerVersionAnnotationKey = "istio.io/controller-version"
+	// The ID of the request that issued the certificate, which correlates the
+	// log lines of the issuance.
+	requestIDAnnotationKey = "istio.io/request-id"
+)
+
+var certStatusAnnotationKeys = []string{
+	renewAfterAnnotationKey,
+	issuedAtAnnotationKey,
+	expiresAtAnnotationKey,
+	serialNumberAnnotationKey,
+}
+
+// setStatusAnnotations annotates the secret with the status of the leaf
+// certificate in the chain and of the root certificate. Annotations that
+// cannot be derived are removed, so that none describes a replaced certificate.
+func setStatusAnnotations(scrt *v1.Secret, chain, rootCert []byte) {
+	if scrt.Annotations == nil {
+		scrt.Annotations = map[string]string{}
+	}
+
+	if cert, err := parseCertificate(chain); err == nil {
+		scrt.Annotations[renewAfterAnnotationKey] =
+			certmanager.RenewalTime(cert.NotBefore, cert.NotAfter).UTC().Format(time.RFC3339)
+		scrt.Annotations[issuedAtAnnotationKey] = cert.NotBefore.UTC().Format(time.RFC3339)
+		scrt.Annotations[expiresAtAnnotationKey] = cert.NotAfter.UTC().Format(time.RFC3339)
+		scrt.Annotations[serialNumberAnnotationKey] = fmt.Sprintf("%x", cert.SerialNumber)
+	} else {
+		glog.Warningf("Failed to parse the certificate of secret %s/%s for its status annotations (error: %v)",
+			scrt.GetNamespace(), scrt.GetName(), err)
+		for _, k := range certStatusAnnotationKeys {
+			delete(scrt.Annotations, k)
+		}
+	}
+
+	if root, err := parseCertificate(rootCert); err == nil {
+		scrt.Annotations[rootFingerprintAnnotationKey] = fingerprint(root)
+	} else {
+		delete(scrt.Annotations, rootFingerprintAnnotationKey)
+	}
+
+	if v := version.Version(); v != "" {
+		scrt.Annotations[controllerVersionAnnotationKey] = v
+	} else {
+		delete(scrt.Annotations, controllerVersionAnnotationKey)
+	}
+}
+
+// setRequestID annotates the secret with the ID of the request that issued its certificate.
+func setRequestID(scrt *v1.Secret, id string) {
+	if scrt.Annotations == nil {
+		scrt.Annotations = map[string]string{}
+	}
+	scrt.Annotations[requestIDAnnotationKey] = id
+}
+
+// fingerprint returns the hex-encoded SHA-256 fingerprint of the certificate.
+func fingerprint(cert *x509.Certificate) string {
+	return fmt.Sprintf("%x", sha256.Sum256(cert.Raw))
+}
+
+// CertificateExpiries returns the expiry times of the certificates in the
+// managed Istio secrets, read from their status annotations or, lacking
+// those, from the certificates. Secrets holding malformed certificates are
+// skipped.
+func (sc *SecretController) CertificateExpiries() []time.Time {
+	expiries := []time.Time{}
+	for _, obj := range sc.scrtStore.List() {
+		scrt, ok := obj.(*v1.Secret)
+		if !ok || !sc.isManaged(scrt) {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, scrt.Annotations[expiresAtAnnotationKey]); err == nil {
+			expiries = append(expiries, t)
+		} else if cert, err := parseCertificate(scrt.Data[certChainID]); err == nil {
+			expiries = append(expiries, cert.NotAfter)
+		}
+	}
+	return expiries
+}
+
+// ManagedIdentity describes the certificate of a managed Istio secret.
+type ManagedIdentity struct {
+	// The identities in the URI SANs of the certificate, comma-separated.
+	ID         string
+	Namespace  string
+	SecretName string
+
+	NotAfter time.Time
+
+	// The type and size of the key of the certificate, e.g. "RSA 2048".
+	KeyType string
+
+	// The hex-encoded SHA-256 fingerprint of the root certificate in the secret.
+	RootFingerprint string
+}
+
+// ManagedIdentities returns the certificates of the managed Istio secrets,
+// read from the informer cache. Secrets holding malformed certificates are skipped.
+func (sc *SecretController) ManagedIdentities() []ManagedIdentity {
+	identities := []ManagedIdentity{}
+	for _, obj := range sc.scrtStore.List() {
+		scrt, ok := obj.(*v1.Secret)
+		if !ok || !sc.isManaged(scrt) {
+			continue
+		}
+		if id, err := managedIdentity(scrt); err == nil {
+			identities = append(identities, id)
+		}
+	}
+	return identities
+}
+
+// managedIdentity describes the certificate of the secret.
+func managedIdentity(scrt *v1.Secret) (ManagedIdentity, error) {
+	cert, err := parseCertificate(scrt.Data[certChainID])
+	if err != nil {
+		return ManagedIdentity{}, err
+	}
+
+	id := ManagedIdentity{
+		ID:              certificateID(cert),
+		Namespace:       scrt.GetNamespace(),
+		SecretName:      scrt.GetName(),
+		NotAfter:        cert.NotAfter,
+		KeyType:         keyType(cert),
+		RootFingerprint: scrt.Annotations[rootFingerprintAnnotationKey],
+	}
+	if id.RootFingerprint == "" {
+		if root, err := parseCertificate(scrt.Data[rootCertID]); err == nil {
+			id.RootFingerprint = fingerprint(root)
+		}
+	}
+	return id, nil
+}
+
+// certificateID returns the comma-separated URI SANs of the certificate, i.e.
+// the Istio identity it is issued for.
+func certificateID(cert *x509.Certificate) string {
+	uris := make([]string, len(cert.URIs))
+	for i, u := range cert.URIs {
+		uris[i] = u.String()
+	}
+	return strings.Join(uris, ",")
+}
+
+// keyType describes the type and size of the public key of the certificate.
+func keyType(cert *x509.Certificate) string {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + key.Curve.Params().Name
+	default:
+		return cert.PublicKeyAlgorithm.String()
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSetStatusAnnotations(t *testing.T) {
+	notBefore := time.Date(2017, time.May, 1, 0, 0, 0, 0, time.UTC)
+	certBytes, _ := certmanager.GenCert(certmanager.CertOptions{
+		IsSelfSigned: true,
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(time.Hour),
+		RSAKeySize:   512,
+	})
+	cert := certmanager.ParsePemEncodedCertificate(certBytes)
+
+	testCases := map[string]struct {
+		chain               []byte
+		rootCert            []byte
+		existingAnnotations map[string]string
+		expectedAnnotations map[string]string
+	}{
+		"Annotations describe the certificates": {
+			chain:    certBytes,
+			rootCert: certBytes,
+			expectedAnnotations: map[string]string{
+				serviceAccountNameAnnotationKey:  "test",
+				secretSchemaVersionAnnotationKey: "2",
+				requestIDAnnotationKey:           testRequestID,
+				issuedAtAnnotationKey:            "2017-05-01T00:00:00Z",
+				expiresAtAnnotationKey:           "2017-05-01T01:00:00Z",
+				serialNumberAnnotationKey:        fmt.Sprintf("%x", cert.SerialNumber),
+				rootFingerprintAnnotationKey:     fmt.Sprintf("%x", sha256.Sum256(cert.Raw)),
+			},
+		},
+		"Stale annotations are removed": {
+			chain:    []byte("fake cert chain"),
+			rootCert: []byte("fake root cert"),
+			existingAnnotations: map[string]string{
+				issuedAtAnnotationKey:        "2017-05-01T00:00:00Z",
+				expiresAtAnnotationKey:       "2017-05-01T01:00:00Z",
+				renewAfterAnnotationKey:      "2017-05-01T00:45:00Z",
+				serialNumberAnnotationKey:    "1f",
+				rootFingerprintAnnotationKey: "abc",
+			},
+			expectedAnnotations: map[string]string{
+				serviceAccountNameAnnotationKey:  "test",
+				secretSchemaVersionAnnotationKey: "2",
+				requestIDAnnotationKey:           testRequestID,
+			},
+		},
+	}
+
+	for id, tc := range testCases {
+		scrt := createSecret("test", "istio.test", "test-ns")
+		for k, v := range tc.existingAnnotations {
+			scrt.Annotations[k] = v
+		}
+
+		setStatusAnnotations(scrt, tc.chain, tc.rootCert)
+
+		if _, ok := tc.expectedAnnotations[serialNumberAnnotationKey]; ok {
+			// The renewal hint is jittered; its bounds are covered by TestRenewalHint.
+			if _, ok := scrt.Annotations[renewAfterAnnotationKey]; !ok {
+				t.Errorf("%s: missing renewal hint", id)
+			}
+			delete(scrt.Annotations, renewAfterAnnotationKey)
+		}
+		if !reflect.DeepEqual(scrt.Annotations, tc.expectedAnnotations) {
+			t.Errorf("%s: unexpected annotations (expecting %v, actual %v)", id, tc.expectedAnnotations, scrt.Annotations)
+		}
+	}
+}
+
+func TestCertificateExpiries(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+
+	expiresAt := time.Date(2017, time.June, 1, 0, 0, 0, 0, time.UTC)
+	annotated := createSecret("annotated", "istio.annotated", "ns")
+	annotated.Annotations[expiresAtAnnotationKey] = expiresAt.Format(time.RFC3339)
+	unmanaged := createSecret("user", "istio.user", "ns")
+	unmanaged.Annotations[expiresAtAnnotationKey] = expiresAt.Format(time.RFC3339)
+	delete(unmanaged.Labels, managedByLabelKey)
+	// "fake cert chain" is neither annotated nor a PEM-encoded certificate.
+	malformed := createSecret("malformed", "istio.malformed", "ns")
+
+	for _, obj := range []interface{}{annotated, unmanaged, malformed} {
+		if err := controller.scrtStore.Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if expiries := controller.CertificateExpiries(); len(expiries) != 1 || !expiries[0].Equal(expiresAt) {
+		t.Errorf("Expecting the expiry of the annotated secret only, but got %v", expiries)
+	}
+}
+
+func TestManagedIdentities(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{})
+
+	notAfter := time.Date(2030, time.June, 1, 0, 0, 0, 0, time.UTC)
+	cert, _ := certmanager.GenCert(certmanager.CertOptions{
+		Host:         "spiffe://cluster.local/ns/ns/sa/test",
+		NotBefore:    notAfter.Add(-time.Hour),
+		NotAfter:     notAfter,
+		IsSelfSigned: true,
+		RSAKeySize:   512,
+	})
+	annotated := createSecret("test", "istio.test", "ns")
+	annotated.Data[certChainID] = cert
+	annotated.Annotations[rootFingerprintAnnotationKey] = "abc"
+	unannotated := createSecret("other", "istio.other", "ns")
+	unannotated.Data[certChainID] = cert
+	unannotated.Data[rootCertID] = cert
+	unmanaged := createSecret("user", "istio.user", "ns")
+	unmanaged.Data[certChainID] = cert
+	delete(unmanaged.Labels, managedByLabelKey)
+	malformed := createSecret("malformed", "istio.malformed", "ns")
+
+	for _, obj := range []interface{}{annotated, unannotated, unmanaged, malformed} {
+		if err := controller.scrtStore.Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]ManagedIdentity{
+		"istio.test": {
+			ID: "spiffe://cluster.local/ns/ns/sa/test", Namespace: "ns", SecretName: "istio.test",
+			NotAfter: notAfter, KeyType: "RSA 512", RootFingerprint: "abc",
+		},
+		"istio.other": {
+			ID: "spiffe://cluster.local/ns/ns/sa/test", Namespace: "ns", SecretName: "istio.other",
+			NotAfter: notAfter, KeyType: "RSA 512",
+			RootFingerprint: fingerprint(certmanager.ParsePemEncodedCertificate(cert)),
+		},
+	}
+	actual := map[string]ManagedIdentity{}
+	for _, id := range controller.ManagedIdentities() {
+		actual[id.SecretName] = id
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected identities (expecting %v, actual %v)", expected, actual)
+	}
+}
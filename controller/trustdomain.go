@@ -0,0 +1,135 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"istio.io/auth/certmanager"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// The label on a namespace selecting the trust domain of its workloads.
+	trustDomainLabelKey = "istio.io/trust-domain"
+
+	// The delay before retrying the issuance for a namespace that is not in
+	// the namespace cache yet, e.g. because it has just been created.
+	uncachedNamespaceRetryDelay = 5 * time.Second
+)
+
+// namespaceNotCachedError is returned when the CA of a namespace is selected
+// before the namespace has reached the namespace cache, so that its trust
+// domain is not known.
+type namespaceNotCachedError struct {
+	namespace string
+}
+
+func (e *namespaceNotCachedError) Error() string {
+	return fmt.Sprintf("namespace %s is not in the namespace cache yet", e.namespace)
+}
+
+func newNamespaceInformer(core corev1.CoreV1Interface) (cache.Store, cache.Controller) {
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return core.Namespaces().List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return core.Namespaces().Watch(options)
+		},
+	}
+	return cache.NewInformer(lw, &v1.Namespace{}, secretResyncPeriod, cache.ResourceEventHandlerFuncs{})
+}
+
+// caFor returns the CA of the trust domain that the namespace is labeled
+// with, or the default CA if the namespace has no trust domain label or is
+// labeled with the default trust domain. A label naming an unknown trust
+// domain is an error, so that certificates of one tenant are never issued by
+// the root of another. For the same reason, a namespace missing from the
+// namespace cache is a namespaceNotCachedError. The CA of a signing profile,
+// see SigningProfile, replaces the default CA.
+func (sc *SecretController) caFor(namespace string) (certmanager.CertificateAuthority, error) {
+	if sc.nsStore == nil {
+		return sc.ca, nil
+	}
+
+	ns, err := sc.cachedNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	if profile, ok := ns.Annotations[signingProfileAnnotationKey]; ok {
+		return sc.signingProfileCA(ns, profile)
+	}
+	domain, ok := ns.Labels[trustDomainLabelKey]
+	if !ok || (domain == sc.opts.DefaultTrustDomain && domain != "") {
+		return sc.ca, nil
+	}
+	ca, ok := sc.opts.TrustDomains[domain]
+	if !ok {
+		return nil, fmt.Errorf("namespace %s is labeled with unknown trust domain %q", namespace, domain)
+	}
+	return ca, nil
+}
+
+// TrustDomain returns the trust domain of the workloads of the namespace, the
+// one that the namespace is labeled with or else the default trust domain.
+// Like caFor, it refuses a label naming an unknown trust domain and a
+// namespace missing from the namespace cache.
+func (sc *SecretController) TrustDomain(namespace string) (string, error) {
+	if sc.nsStore == nil {
+		return sc.opts.DefaultTrustDomain, nil
+	}
+
+	ns, err := sc.cachedNamespace(namespace)
+	if err != nil {
+		return "", err
+	}
+	domain, ok := ns.Labels[trustDomainLabelKey]
+	if !ok || domain == sc.opts.DefaultTrustDomain {
+		return sc.opts.DefaultTrustDomain, nil
+	}
+	if _, ok := sc.opts.TrustDomains[domain]; !ok {
+		return "", fmt.Errorf("namespace %s is labeled with unknown trust domain %q", namespace, domain)
+	}
+	return domain, nil
+}
+
+// cachedNamespace returns the namespace from the namespace cache, or a
+// namespaceNotCachedError.
+func (sc *SecretController) cachedNamespace(namespace string) (*v1.Namespace, error) {
+	obj, exists, err := sc.nsStore.GetByKey(namespace)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, &namespaceNotCachedError{namespace}
+	}
+	return obj.(*v1.Namespace), nil
+}
+
+// retryUncachedNamespace schedules the retry of an issuance that has failed
+// with err, if err is a namespaceNotCachedError.
+func (sc *SecretController) retryUncachedNamespace(err error, retry func()) {
+	if _, ok := err.(*namespaceNotCachedError); ok {
+		time.AfterFunc(sc.uncachedNamespaceRetryDelay, retry)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// domainCa is a fake CA with its own root certificate.
+type domainCa struct {
+	fakeCa
+	root string
+}
+
+func (ca domainCa) GetRootCertificate() []byte {
+	return []byte(ca.root)
+}
+
+func TestTrustDomains(t *testing.T) {
+	namespaces := []*v1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "plain"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "tenant-ns", Labels: map[string]string{trustDomainLabelKey: "tenant.com"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "unknown-ns", Labels: map[string]string{trustDomainLabelKey: "other.com"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "default-ns", Labels: map[string]string{trustDomainLabelKey: "cluster.local"}}},
+	}
+
+	testCases := map[string]struct {
+		namespace    string
+		expectedRoot string
+		expectRetry  bool
+	}{
+		"Unlabeled namespace uses the default CA": {
+			namespace:    "plain",
+			expectedRoot: "fake root cert",
+		},
+		"Namespace labeled with the default trust domain uses the default CA": {
+			namespace:    "default-ns",
+			expectedRoot: "fake root cert",
+		},
+		"Namespace unknown to the store gets no secret until it is cached": {
+			namespace:   "new-ns",
+			expectRetry: true,
+		},
+		"Labeled namespace uses the CA of its trust domain": {
+			namespace:    "tenant-ns",
+			expectedRoot: "tenant root cert",
+		},
+		"Namespace labeled with an unknown trust domain gets no secret": {
+			namespace: "unknown-ns",
+		},
+	}
+
+	for k, tc := range testCases {
+		client := fake.NewSimpleClientset()
+		controller := NewSecretController(fakeCa{}, client.CoreV1(), metav1.NamespaceAll, SecretControllerOptions{
+			TrustDomains: map[string]certmanager.CertificateAuthority{
+				"tenant.com": domainCa{root: "tenant root cert"},
+			},
+			DefaultTrustDomain: "cluster.local",
+		})
+		controller.uncachedNamespaceRetryDelay = 0
+		for _, ns := range namespaces {
+			if err := controller.nsStore.Add(ns); err != nil {
+				t.Fatalf("%s: failed to add a namespace (error %v)", k, err)
+			}
+		}
+
+		controller.upsertSecret("test", tc.namespace)
+
+		actions := client.Actions()
+		if tc.expectedRoot == "" {
+			if len(actions) != 0 {
+				t.Errorf("%s: expect no actions but got %v", k, actions)
+			}
+			// The retry is enqueued once the delay has passed.
+			time.Sleep(10 * time.Millisecond)
+			if retried := controller.queue.Len() > 0; retried != tc.expectRetry {
+				t.Errorf("%s: expect the issuance to be retried: %v, but it is: %v", k, tc.expectRetry, retried)
+			}
+			continue
+		}
+		if len(actions) != 1 {
+			t.Fatalf("%s: expect a single create action but got %v", k, actions)
+		}
+		scrt := actions[0].(ktesting.CreateAction).GetObject().(*v1.Secret)
+		if root := string(scrt.Data[rootCertID]); root != tc.expectedRoot {
+			t.Errorf("%s: expect root certificate %q but got %q", k, tc.expectedRoot, root)
+		}
+	}
+}
+
+func TestTrustDomain(t *testing.T) {
+	controller := NewSecretController(fakeCa{}, fake.NewSimpleClientset().CoreV1(), metav1.NamespaceAll,
+		SecretControllerOptions{
+			TrustDomains:       map[string]certmanager.CertificateAuthority{"tenant.com": domainCa{}},
+			DefaultTrustDomain: "cluster.local",
+		})
+	for _, ns := range []*v1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "plain"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "tenant-ns", Labels: map[string]string{trustDomainLabelKey: "tenant.com"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "unknown-ns", Labels: map[string]string{trustDomainLabelKey: "other.com"}}},
+	} {
+		if err := controller.nsStore.Add(ns); err != nil {
+			t.Fatalf("Failed to add a namespace (error %v)", err)
+		}
+	}
+
+	testCases := map[string]struct {
+		namespace      string
+		expectedDomain string
+	}{
+		"Unlabeled namespace": {
+			namespace:      "plain",
+			expectedDomain: "cluster.local",
+		},
+		"Labeled namespace": {
+			namespace:      "tenant-ns",
+			expectedDomain: "tenant.com",
+		},
+		"Namespace labeled with an unknown trust domain": {
+			namespace: "unknown-ns",
+		},
+		"Namespace unknown to the store": {
+			namespace: "new-ns",
+		},
+	}
+	for k, tc := range testCases {
+		domain, err := controller.TrustDomain(tc.namespace)
+		if tc.expectedDomain == "" {
+			if err == nil {
+				t.Errorf("%s: expect an error but got trust domain %s", k, domain)
+			}
+		} else if err != nil || domain != tc.expectedDomain {
+			t.Errorf("%s: expect trust domain %s but got %s (error: %v)", k, tc.expectedDomain, domain, err)
+		}
+	}
+}
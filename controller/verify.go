@@ -0,0 +1,138 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"istio.io/auth/parse"
+	"istio.io/auth/pkg/spiffe"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// SecretVerification is the outcome of verifying the Istio secret of a
+// service account against the root certificate distributed in the discovery
+// ConfigMap.
+type SecretVerification struct {
+	SecretName string
+	ID         string
+	NotAfter   time.Time
+
+	// The problems found with the secret; empty if it is valid.
+	Problems []string
+
+	// The name of the additional trusted issuer vouching for the certificate
+	// chain, or empty if it verifies against the distributed root certificate.
+	Issuer string
+
+	// The PEM-encoded material of the secret. PrivateKey is nil if the key is
+	// encrypted, and RootCert is the distributed root certificate.
+	CertChain  []byte
+	PrivateKey []byte
+	RootCert   []byte
+}
+
+// VerifyIstioSecret verifies that the Istio secret of the service account holds
+// a certificate chain for its SPIFFE identity in the trust domain, which is
+// not expiring within minRemaining, matches the private key, and verifies
+// against the root certificate published in the discovery ConfigMap
+// rootNamespace/rootConfigMap. An error is only returned if the secret or the
+// ConfigMap can not be read.
+func VerifyIstioSecret(core corev1.CoreV1Interface, namespace, saName, trustDomain, rootNamespace,
+	rootConfigMap string, minRemaining time.Duration) (*SecretVerification, error) {
+	return VerifyIstioSecretWithIssuers(core, namespace, saName, trustDomain, rootNamespace, rootConfigMap,
+		minRemaining, nil)
+}
+
+// VerifyIstioSecretWithIssuers is like VerifyIstioSecret, and also accepts
+// certificate chains that one of the additional trusted issuers vouches for,
+// e.g. secrets provisioned by a legacy PKI during a migration. The root
+// certificate of such a secret is not compared with the distributed one.
+func VerifyIstioSecretWithIssuers(core corev1.CoreV1Interface, namespace, saName, trustDomain, rootNamespace,
+	rootConfigMap string, minRemaining time.Duration, issuers spiffe.TrustedIssuers) (*SecretVerification, error) {
+
+	cm, err := core.ConfigMaps(rootNamespace).Get(rootConfigMap, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the discovery ConfigMap %s/%s (error: %v)", rootNamespace, rootConfigMap, err)
+	}
+	rootCert := []byte(cm.Data[discoveryRootCertKey])
+	if _, err := parseCertificate(rootCert); err != nil {
+		return nil, fmt.Errorf("the discovery ConfigMap %s/%s holds no valid root certificate (error: %v)",
+			rootNamespace, rootConfigMap, err)
+	}
+
+	v := &SecretVerification{
+		SecretName: getSecretName(saName),
+		ID:         fmt.Sprintf("spiffe://%s/ns/%s/sa/%s", trustDomain, namespace, saName),
+		RootCert:   rootCert,
+	}
+	scrt, err := core.Secrets(namespace).Get(v.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the Istio secret %s/%s (error: %v)", namespace, v.SecretName, err)
+	}
+	problem := func(format string, args ...interface{}) {
+		v.Problems = append(v.Problems, fmt.Sprintf(format, args...))
+	}
+
+	if scrt.Type != istioSecretType {
+		problem("the secret is of type %q instead of %q", scrt.Type, istioSecretType)
+	}
+	v.CertChain = scrt.Data[certChainID]
+	certs, err := parse.CertificateChain(v.CertChain)
+	if err != nil || len(certs) == 0 {
+		problem("the secret holds no valid certificate chain (error: %v)", err)
+		return v, nil
+	}
+
+	cert := certs[0]
+	v.NotAfter = cert.NotAfter
+	if len(cert.URIs) != 1 || cert.URIs[0].String() != v.ID {
+		problem("the certificate is issued for %v instead of %s", cert.URIs, v.ID)
+	}
+	now := time.Now()
+	if now.Before(cert.NotBefore) {
+		problem("the certificate is not valid until %v", cert.NotBefore)
+	}
+	if remaining := cert.NotAfter.Sub(now); remaining <= minRemaining {
+		problem("the certificate expires at %v, within %v", cert.NotAfter, minRemaining)
+	}
+
+	if key, ok := scrt.Data[privateKeyID]; ok {
+		v.PrivateKey = key
+		if _, err := tls.X509KeyPair(v.CertChain, key); err != nil {
+			problem("the certificate does not match the private key (error: %v)", err)
+		}
+	} else if _, ok := scrt.Data[encryptedPrivateKeyID]; !ok {
+		problem("the secret holds no private key")
+	}
+
+	if !chainVerifies(v.CertChain, rootCert) {
+		if issuer, err := issuers.Issuer(certs); err == nil {
+			v.Issuer = issuer
+			return v, nil
+		}
+	}
+	if !samePEM(scrt.Data[rootCertID], rootCert) {
+		problem("the root certificate of the secret differs from the one in the discovery ConfigMap")
+	}
+	if !chainVerifies(v.CertChain, rootCert) {
+		problem("the certificate chain does not verify against the root certificate in the discovery ConfigMap")
+	}
+	return v, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager"
+	"istio.io/auth/pkg/spiffe"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestVerifyIstioSecret(t *testing.T) {
+	newCA := func() *certmanager.IstioCA {
+		ca, err := certmanager.NewSelfSignedIstioCA(&certmanager.SelfSignedIstioCAOptions{
+			CACertTTL:  time.Hour,
+			CertTTL:    30 * time.Minute,
+			Subject:    pkix.Name{Organization: []string{"test.ca.org"}},
+			MaxPathLen: -1,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create a self-signed CA: %v", err)
+		}
+		return ca
+	}
+	ca, other := newCA(), newCA()
+	issuers := func(patterns ...string) spiffe.TrustedIssuers {
+		issuer, err := spiffe.NewTrustedIssuer("legacy", other.GetRootCertificate(), patterns...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return spiffe.TrustedIssuers{issuer}
+	}
+	secret := func(ca *certmanager.IstioCA, name string) *v1.Secret {
+		s := createSecret("foo", "istio.foo", "default")
+		s.Data[certChainID], s.Data[privateKeyID], _ = ca.Generate(name, "default")
+		s.Data[rootCertID] = ca.GetRootCertificate()
+		return s
+	}
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "istio-ca", Namespace: "istio-system"},
+		Data:       map[string]string{discoveryRootCertKey: string(ca.GetRootCertificate())},
+	}
+
+	testCases := map[string]struct {
+		secret           *v1.Secret
+		minRemaining     time.Duration
+		issuers          spiffe.TrustedIssuers
+		expectedProblems []string
+		expectedIssuer   string
+	}{
+		"Valid secret": {
+			secret: secret(ca, "foo"),
+		},
+		"Another identity": {
+			secret:           secret(ca, "bar"),
+			expectedProblems: []string{"instead of spiffe://cluster.local/ns/default/sa/foo"},
+		},
+		"Expiring": {
+			secret:           secret(ca, "foo"),
+			minRemaining:     time.Hour,
+			expectedProblems: []string{"within 1h0m0s"},
+		},
+		"Another root": {
+			secret: secret(other, "foo"),
+			expectedProblems: []string{
+				"differs from the one in the discovery ConfigMap",
+				"does not verify against the root certificate",
+			},
+		},
+		"Additional trusted issuer": {
+			secret:         secret(other, "foo"),
+			issuers:        issuers("spiffe://cluster.local/ns/default/*"),
+			expectedIssuer: "legacy",
+		},
+		"Additional trusted issuer for another identity": {
+			secret:  secret(other, "foo"),
+			issuers: issuers("spiffe://cluster.local/ns/legacy/*"),
+			expectedProblems: []string{
+				"differs from the one in the discovery ConfigMap",
+				"does not verify against the root certificate",
+			},
+		},
+		"Mismatched key": {
+			secret: func() *v1.Secret {
+				s := secret(ca, "foo")
+				_, s.Data[privateKeyID], _ = ca.Generate("foo", "default")
+				return s
+			}(),
+			expectedProblems: []string{"does not match the private key"},
+		},
+	}
+
+	for id, tc := range testCases {
+		core := fake.NewSimpleClientset(tc.secret, configMap).CoreV1()
+		v, err := VerifyIstioSecretWithIssuers(core, "default", "foo", "cluster.local", "istio-system", "istio-ca",
+			tc.minRemaining, tc.issuers)
+		if err != nil {
+			t.Fatalf("%s: Unexpected error: %v", id, err)
+		}
+		if v.Issuer != tc.expectedIssuer {
+			t.Errorf("%s: Unexpected issuer %q (expecting %q)", id, v.Issuer, tc.expectedIssuer)
+		}
+		if len(v.Problems) != len(tc.expectedProblems) {
+			t.Errorf("%s: Unexpected problems (expecting %v, actual %v)", id, tc.expectedProblems, v.Problems)
+			continue
+		}
+		for i, p := range v.Problems {
+			if !strings.Contains(p, tc.expectedProblems[i]) {
+				t.Errorf("%s: Unexpected problem %q (expecting %q)", id, p, tc.expectedProblems[i])
+			}
+		}
+	}
+
+	core := fake.NewSimpleClientset(configMap).CoreV1()
+	if _, err := VerifyIstioSecret(core, "default", "foo", "cluster.local", "istio-system", "istio-ca", 0); err == nil {
+		t.Error("Expecting an error for a missing secret")
+	}
+}
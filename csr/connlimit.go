@@ -0,0 +1,96 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"net"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// peerLimitListener is a net.Listener closing the connections of a peer
+// beyond its limit of concurrent connections, so that a misbehaving client
+// cannot exhaust the connections of the server. Peers are told apart by IP
+// address.
+type peerLimitListener struct {
+	net.Listener
+
+	mutex   sync.Mutex
+	perPeer int
+	conns   map[string]int
+}
+
+// limitPeerConnections returns a listener accepting from lis up to perPeer
+// concurrent connections of each peer.
+func limitPeerConnections(lis net.Listener, perPeer int) net.Listener {
+	return &peerLimitListener{Listener: lis, perPeer: perPeer, conns: map[string]int{}}
+}
+
+// Accept implements net.Listener.
+func (l *peerLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		host := peerHost(conn.RemoteAddr())
+		if l.acquire(host) {
+			return &peerLimitConn{Conn: conn, release: func() { l.release(host) }}, nil
+		}
+		glog.Warningf("Closing a connection from %s beyond its limit of %d concurrent connections", host, l.perPeer)
+		conn.Close() // nolint: errcheck
+	}
+}
+
+func (l *peerLimitListener) acquire(host string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.conns[host] >= l.perPeer {
+		return false
+	}
+	l.conns[host]++
+	return true
+}
+
+func (l *peerLimitListener) release(host string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.conns[host]--; l.conns[host] <= 0 {
+		delete(l.conns, host)
+	}
+}
+
+// peerHost returns the IP address of the peer, or the whole address if it has no port.
+func peerHost(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// peerLimitConn is a connection counted against the limit of its peer until closed.
+type peerLimitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+// Close implements net.Conn.
+func (c *peerLimitConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
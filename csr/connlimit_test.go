@@ -0,0 +1,97 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"net"
+	"testing"
+)
+
+func TestLimitPeerConnections(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited := limitPeerConnections(lis, 2)
+	defer limited.Close() // nolint: errcheck
+
+	accepted := make(chan net.Conn)
+	go func() {
+		for {
+			conn, err := limited.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", lis.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	first, second := dial(), dial()
+	defer second.Close() // nolint: errcheck
+	conns := []net.Conn{<-accepted, <-accepted}
+
+	// The third connection is closed by the server.
+	third := dial()
+	defer third.Close() // nolint: errcheck
+	if _, err := third.Read(make([]byte, 1)); err == nil {
+		t.Error("Expecting a connection beyond the limit of the peer to be closed")
+	}
+
+	// Closing an accepted connection frees a slot for the peer.
+	conns[0].Close() // nolint: errcheck
+	first.Close()    // nolint: errcheck
+	fourth := dial()
+	defer fourth.Close() // nolint: errcheck
+	conn := <-accepted
+	defer conn.Close() // nolint: errcheck
+	if host := peerHost(conn.RemoteAddr()); host != "127.0.0.1" {
+		t.Errorf("Unexpected peer %q", host)
+	}
+	conns[1].Close() // nolint: errcheck
+}
+
+func TestPeerHost(t *testing.T) {
+	testCases := map[string]struct {
+		addr     net.Addr
+		expected string
+	}{
+		"IPv4": {
+			addr:     &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443},
+			expected: "10.0.0.1",
+		},
+		"IPv6": {
+			addr:     &net.TCPAddr{IP: net.ParseIP("fd00::1"), Port: 443},
+			expected: "fd00::1",
+		},
+		"No port": {
+			addr:     &net.UnixAddr{Name: "/var/run/ca.sock", Net: "unix"},
+			expected: "/var/run/ca.sock",
+		},
+	}
+
+	for id, tc := range testCases {
+		if host := peerHost(tc.addr); host != tc.expected {
+			t.Errorf("%s: Unexpected host (expecting %q, actual %q)", id, tc.expected, host)
+		}
+	}
+}
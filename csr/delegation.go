@@ -0,0 +1,58 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"istio.io/auth/pkg/spiffe"
+)
+
+// DelegationPolicy maps the identities of trusted callers, e.g. a remote
+// secret controller or an ingress operator, to the patterns of the identities
+// that they may request certificates on behalf of. A pattern is either a
+// SPIFFE URI, or a prefix of SPIFFE URIs followed by "*", e.g.
+// "spiffe://cluster.local/ns/gateways/*".
+type DelegationPolicy map[string][]string
+
+// LoadDelegationPolicy reads a DelegationPolicy from a JSON file holding an
+// object from the identities of the delegates to arrays of patterns.
+func LoadDelegationPolicy(path string) (DelegationPolicy, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := DelegationPolicy{}
+	if err := json.Unmarshal(bs, &policy); err != nil {
+		return nil, fmt.Errorf("invalid delegation policy in %s (error: %v)", path, err)
+	}
+	for delegate, patterns := range policy {
+		if !strings.HasPrefix(delegate, spiffe.Scheme+"://") || strings.Contains(delegate, "*") {
+			return nil, fmt.Errorf("delegate %q is not a SPIFFE URI", delegate)
+		}
+		if _, err := spiffe.NewMatcher(patterns...); err != nil {
+			return nil, fmt.Errorf("delegate %s has invalid patterns (error: %v)", delegate, err)
+		}
+	}
+	return policy, nil
+}
+
+// Allows returns whether the delegate may request certificates on behalf of the identity.
+func (p DelegationPolicy) Allows(delegate, id string) bool {
+	return spiffe.Matcher(p[delegate]).Matches(id)
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDelegationPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "delegation-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	testCases := map[string]struct {
+		content       string
+		expectedError string
+	}{
+		"Valid policy": {
+			content: `{"spiffe://cluster.local/ns/istio-system/sa/controller": ` +
+				`["spiffe://cluster.local/ns/gateways/*", "spiffe://cluster.local/ns/vm/sa/foo"]}`,
+		},
+		"Malformed JSON": {
+			content:       `["spiffe://cluster.local/ns/gateways/*"]`,
+			expectedError: "invalid delegation policy",
+		},
+		"Delegate is not a SPIFFE URI": {
+			content:       `{"controller": ["spiffe://cluster.local/ns/gateways/*"]}`,
+			expectedError: `delegate "controller" is not a SPIFFE URI`,
+		},
+		"Wildcard in the middle of a pattern": {
+			content: `{"spiffe://cluster.local/ns/istio-system/sa/controller": ` +
+				`["spiffe://cluster.local/ns/*/sa/foo"]}`,
+			expectedError: "has invalid patterns",
+		},
+		"Pattern is not a SPIFFE URI": {
+			content:       `{"spiffe://cluster.local/ns/istio-system/sa/controller": ["*"]}`,
+			expectedError: "has invalid patterns",
+		},
+	}
+
+	for name, tc := range testCases {
+		path := filepath.Join(dir, "policy.json")
+		if err := ioutil.WriteFile(path, []byte(tc.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadDelegationPolicy(path)
+		if tc.expectedError == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+			t.Errorf("%s: expecting error %q but got %v", name, tc.expectedError, err)
+		}
+	}
+}
+
+func TestDelegationPolicyAllows(t *testing.T) {
+	const delegate = "spiffe://cluster.local/ns/istio-system/sa/controller"
+	policy := DelegationPolicy{
+		delegate: {"spiffe://cluster.local/ns/gateways/*", "spiffe://cluster.local/ns/vm/sa/foo"},
+	}
+
+	testCases := map[string]struct {
+		policy   DelegationPolicy
+		delegate string
+		id       string
+		expected bool
+	}{
+		"Prefix": {
+			policy: policy, delegate: delegate, id: "spiffe://cluster.local/ns/gateways/sa/ingress", expected: true,
+		},
+		"Exact identity": {
+			policy: policy, delegate: delegate, id: "spiffe://cluster.local/ns/vm/sa/foo", expected: true,
+		},
+		"Other identity": {
+			policy: policy, delegate: delegate, id: "spiffe://cluster.local/ns/vm/sa/foobar",
+		},
+		"Other namespace": {
+			policy: policy, delegate: delegate, id: "spiffe://cluster.local/ns/prod/sa/ingress",
+		},
+		"Not a delegate": {
+			policy: policy, delegate: "spiffe://cluster.local/ns/vm/sa/foo", id: "spiffe://cluster.local/ns/vm/sa/bar",
+		},
+		"Nil policy": {
+			delegate: delegate, id: "spiffe://cluster.local/ns/gateways/sa/ingress",
+		},
+	}
+
+	for name, tc := range testCases {
+		if actual := tc.policy.Allows(tc.delegate, tc.id); actual != tc.expected {
+			t.Errorf("%s: expecting %v but got %v", name, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"istio.io/auth/api/csr/v1alpha1"
+	"istio.io/auth/certmanager"
+)
+
+// apiError returns a gRPC status error with the code and the message, whose
+// details hold a v1alpha1.ErrorDetail with the error code.
+func apiError(c codes.Code, ec v1alpha1.ErrorCode, format string, args ...interface{}) error {
+	st := &spb.Status{Code: int32(c), Message: fmt.Sprintf(format, args...)}
+	if detail, err := ptypes.MarshalAny(&v1alpha1.ErrorDetail{Code: ec}); err == nil {
+		st.Details = []*any.Any{detail}
+	}
+	return status.ErrorProto(st)
+}
+
+// ErrorCode returns the error code in the details of the gRPC status error
+// returned by the CSR API, or ERROR_CODE_UNSPECIFIED if it holds none, e.g.
+// if the server predates the error codes.
+func ErrorCode(err error) v1alpha1.ErrorCode {
+	st, ok := status.FromError(err)
+	if !ok || st == nil {
+		return v1alpha1.ErrorCode_ERROR_CODE_UNSPECIFIED
+	}
+	for _, d := range st.Proto().GetDetails() {
+		detail := &v1alpha1.ErrorDetail{}
+		if ptypes.Is(d, detail) && ptypes.UnmarshalAny(d, detail) == nil {
+			return detail.Code
+		}
+	}
+	return v1alpha1.ErrorCode_ERROR_CODE_UNSPECIFIED
+}
+
+// signError returns the gRPC status error of a failure of the Signer, with
+// the error code of its cause. Only the failures of the backend are
+// BACKEND_UNAVAILABLE, which callers retry; the refusals of the CA are not.
+func signError(err error) error {
+	switch e := err.(type) {
+	case *certmanager.IdentityNotApprovedError, *certmanager.ApprovalDeniedError:
+		return apiError(codes.PermissionDenied, v1alpha1.ErrorCode_UNAUTHORIZED_IDENTITY, "%v", e)
+	case *certmanager.QuotaExceededError:
+		return apiError(codes.ResourceExhausted, v1alpha1.ErrorCode_RATE_LIMITED, "%v", e)
+	case *certmanager.ApprovalPendingError:
+		return apiError(codes.FailedPrecondition, v1alpha1.ErrorCode_APPROVAL_PENDING, "%v", e)
+	case *certmanager.ClockError:
+		return apiError(codes.FailedPrecondition, v1alpha1.ErrorCode_CLOCK_UNTRUSTED,
+			"the CA refuses to sign until its clock is corrected")
+	}
+	return apiError(codes.Unavailable, v1alpha1.ErrorCode_BACKEND_UNAVAILABLE,
+		"failed to sign the certificate signing request")
+}
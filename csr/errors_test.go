@@ -0,0 +1,195 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"crypto/x509/pkix"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"istio.io/auth/api/csr/v1alpha1"
+	"istio.io/auth/authn"
+	"istio.io/auth/certmanager"
+	"istio.io/auth/certmanager/testutil"
+)
+
+func TestErrorCode(t *testing.T) {
+	testCases := map[string]struct {
+		err          error
+		expectedCode v1alpha1.ErrorCode
+	}{
+		"API error": {
+			err:          apiError(codes.InvalidArgument, v1alpha1.ErrorCode_INVALID_CSR, "bad CSR"),
+			expectedCode: v1alpha1.ErrorCode_INVALID_CSR,
+		},
+		"gRPC error without details": {
+			err:          grpc.Errorf(codes.InvalidArgument, "bad CSR"),
+			expectedCode: v1alpha1.ErrorCode_ERROR_CODE_UNSPECIFIED,
+		},
+		"Non-gRPC error": {
+			err:          errors.New("bad CSR"),
+			expectedCode: v1alpha1.ErrorCode_ERROR_CODE_UNSPECIFIED,
+		},
+		"No error": {
+			expectedCode: v1alpha1.ErrorCode_ERROR_CODE_UNSPECIFIED,
+		},
+	}
+
+	for k, tc := range testCases {
+		if code := ErrorCode(tc.err); code != tc.expectedCode {
+			t.Errorf("%s: unexpected error code %v (expecting %v)", k, code, tc.expectedCode)
+		}
+	}
+}
+
+// refusingSigner fails every signing with err.
+type refusingSigner struct {
+	err error
+}
+
+func (s *refusingSigner) Sign(csrPEM []byte) ([]byte, error) {
+	return nil, s.err
+}
+
+func (s *refusingSigner) SignOnBehalf(csrPEM []byte, requester string, dnsNames ...string) ([]byte, error) {
+	return nil, s.err
+}
+
+func (s *refusingSigner) SignForPod(csrPEM []byte, pod certmanager.PodIdentity, dnsNames ...string) ([]byte, error) {
+	return nil, s.err
+}
+
+func (s *refusingSigner) GetRootCertificate() []byte {
+	return nil
+}
+
+func TestSignErrorCodes(t *testing.T) {
+	const id = "spiffe://cluster.local/ns/vm/sa/foo"
+	auth := map[string]authn.Authenticator{CredentialTypeGCP: testutil.FakeAuthenticator{"token": id}}
+
+	failingCA, err := certmanager.NewSelfSignedIstioCA(&certmanager.SelfSignedIstioCAOptions{
+		CACertTTL:         time.Hour,
+		CertTTL:           30 * time.Minute,
+		Subject:           pkix.Name{Organization: []string{"test.ca.org"}},
+		MaxPathLen:        -1,
+		NoKeyEscrow:       true,
+		InjectedErrorRate: 1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+
+	testCases := map[string]struct {
+		ca           Signer
+		req          *Request
+		expectedCode codes.Code
+		expectedErr  v1alpha1.ErrorCode
+	}{
+		"Unauthenticated": {
+			ca:           testutil.NewCA(t, 30*time.Minute),
+			req:          &Request{CSR: createCSR(t, []string{id}, nil), Credential: "unknown"},
+			expectedCode: codes.Unauthenticated,
+			expectedErr:  v1alpha1.ErrorCode_UNAUTHENTICATED,
+		},
+		"Unsupported credential type": {
+			ca: testutil.NewCA(t, 30*time.Minute),
+			req: &Request{CSR: createCSR(t, []string{id}, nil), Credential: "token",
+				CredentialType: CredentialTypeAzure},
+			expectedCode: codes.Unauthenticated,
+			expectedErr:  v1alpha1.ErrorCode_UNAUTHENTICATED,
+		},
+		"Invalid CSR": {
+			ca:           testutil.NewCA(t, 30*time.Minute),
+			req:          &Request{CSR: []byte("not a CSR"), Credential: "token"},
+			expectedCode: codes.InvalidArgument,
+			expectedErr:  v1alpha1.ErrorCode_INVALID_CSR,
+		},
+		"Unauthorized identity": {
+			ca:           testutil.NewCA(t, 30*time.Minute),
+			req:          &Request{CSR: createCSR(t, []string{"spiffe://cluster.local/ns/vm/sa/bar"}, nil), Credential: "token"},
+			expectedCode: codes.PermissionDenied,
+			expectedErr:  v1alpha1.ErrorCode_UNAUTHORIZED_IDENTITY,
+		},
+		"Backend unavailable": {
+			ca:           failingCA,
+			req:          &Request{CSR: createCSR(t, []string{id}, nil), Credential: "token"},
+			expectedCode: codes.Unavailable,
+			expectedErr:  v1alpha1.ErrorCode_BACKEND_UNAVAILABLE,
+		},
+		"Not approved by the registry": {
+			ca:           &refusingSigner{&certmanager.IdentityNotApprovedError{Namespace: "vm", ServiceAccount: "foo"}},
+			req:          &Request{CSR: createCSR(t, []string{id}, nil), Credential: "token"},
+			expectedCode: codes.PermissionDenied,
+			expectedErr:  v1alpha1.ErrorCode_UNAUTHORIZED_IDENTITY,
+		},
+		"Approval denied": {
+			ca:           &refusingSigner{&certmanager.ApprovalDeniedError{ID: "1", Identity: id, By: "admin"}},
+			req:          &Request{CSR: createCSR(t, []string{id}, nil), Credential: "token"},
+			expectedCode: codes.PermissionDenied,
+			expectedErr:  v1alpha1.ErrorCode_UNAUTHORIZED_IDENTITY,
+		},
+		"Approval pending": {
+			ca:           &refusingSigner{&certmanager.ApprovalPendingError{ID: "1", Identity: id}},
+			req:          &Request{CSR: createCSR(t, []string{id}, nil), Credential: "token"},
+			expectedCode: codes.FailedPrecondition,
+			expectedErr:  v1alpha1.ErrorCode_APPROVAL_PENDING,
+		},
+		"Quota exceeded": {
+			ca: &refusingSigner{&certmanager.QuotaExceededError{Namespace: "vm",
+				Quota: certmanager.QuotaIssuancesPerHour, Limit: 1}},
+			req:          &Request{CSR: createCSR(t, []string{id}, nil), Credential: "token"},
+			expectedCode: codes.ResourceExhausted,
+			expectedErr:  v1alpha1.ErrorCode_RATE_LIMITED,
+		},
+		"Clock rolled back": {
+			ca:           &refusingSigner{&certmanager.ClockError{Reason: "clock rollback detected"}},
+			req:          &Request{CSR: createCSR(t, []string{id}, nil), Credential: "token"},
+			expectedCode: codes.FailedPrecondition,
+			expectedErr:  v1alpha1.ErrorCode_CLOCK_UNTRUSTED,
+		},
+	}
+
+	for k, tc := range testCases {
+		_, err := NewServer(tc.ca, auth, []string{"istio-ca"}).Sign(tc.req)
+		if code := grpc.Code(err); code != tc.expectedCode {
+			t.Errorf("%s: unexpected code (expecting %v, actual %v: %v)", k, tc.expectedCode, code, err)
+		}
+		if code := ErrorCode(err); code != tc.expectedErr {
+			t.Errorf("%s: unexpected error code %v (expecting %v)", k, code, tc.expectedErr)
+		}
+	}
+}
+
+func TestV1alpha1ErrorCode(t *testing.T) {
+	const id = "spiffe://cluster.local/ns/vm/sa/foo"
+	ca := testutil.NewCA(t, 30*time.Minute)
+	s := NewServer(ca, map[string]authn.Authenticator{CredentialTypeGCP: testutil.FakeAuthenticator{"token": id}},
+		[]string{"istio-ca"})
+	client, closeClient := dialTestServer(t, s, ca)
+	defer closeClient()
+
+	_, err := client.Sign(context.Background(), &v1alpha1.SignRequest{
+		CsrPem:     []byte("not a CSR"),
+		Credential: "token",
+	})
+	if code := ErrorCode(err); code != v1alpha1.ErrorCode_INVALID_CSR {
+		t.Errorf("Unexpected error code %v of %v (expecting %v)", code, err, v1alpha1.ErrorCode_INVALID_CSR)
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"path"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"istio.io/auth/api/csr/v1alpha1"
+	"istio.io/auth/pkg/requestid"
+)
+
+var (
+	calls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "istio_ca",
+		Subsystem: "csr",
+		Name:      "calls_total",
+		Help:      "The number of unary calls of the CSR API, by method and gRPC status code.",
+	}, []string{"method", "code"})
+
+	callDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "istio_ca",
+		Subsystem: "csr",
+		Name:      "call_duration_seconds",
+		Help:      "The latency of the unary calls of the CSR API, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+func init() {
+	prometheus.MustRegister(calls)
+	prometheus.MustRegister(callDuration)
+}
+
+// Call is a unary call of the CSR API passing through the interceptors.
+type Call struct {
+	// The name of the method without the service, e.g. "Sign".
+	Method string
+
+	// The request message, e.g. a *v1alpha1.SignRequest.
+	Request interface{}
+
+	// The identity of the caller, set by the authentication stage. It is
+	// empty if the request carries no credential, e.g. that of GetNonce.
+	Caller string
+
+	// The ID correlating the log lines and the audit record of the call, which
+	// the response header carries as requestid.MetadataKey.
+	RequestID string
+}
+
+// Handler answers a call of the CSR API.
+type Handler func(ctx context.Context, call *Call) (interface{}, error)
+
+// Interceptor is a stage of the pipeline that the unary calls of the CSR API
+// pass through before their handler. It either fails the call, or passes it
+// on to next.
+type Interceptor interface {
+	Intercept(ctx context.Context, call *Call, next Handler) (interface{}, error)
+}
+
+// Authorizer decides the methods of the CSR API that each caller may call.
+type Authorizer interface {
+	// Authorize returns an error if the caller may not call the method.
+	Authorize(method, caller string) error
+}
+
+// QuotaLimiter bounds the calls of the CSR API.
+type QuotaLimiter interface {
+	// Admit returns an error if the call of the method by the caller would
+	// exceed a quota, and otherwise charges the call.
+	Admit(method, caller string) error
+}
+
+// Auditor records every call of the CSR API, including those that the
+// authentication, authorization and quota stages refuse.
+type Auditor interface {
+	Audit(call *Call, err error, elapsed time.Duration)
+}
+
+// LogAuditor is an Auditor writing the calls to the log.
+type LogAuditor struct{}
+
+// Audit implements Auditor.
+func (LogAuditor) Audit(call *Call, err error, elapsed time.Duration) {
+	glog.Infof("Answered a %s call of %s with %s in %v (request: %s)", call.Method, callerName(call.Caller),
+		grpc.Code(err), elapsed, call.RequestID)
+}
+
+// Interceptors returns the pipeline of the unary calls of the server: audit
+// by the Auditor, wrapping authentication, then authorization by the
+// Authorizer and quota by the QuotaLimiter of the server, where set. The
+// calls refused by the later stages are thus audited too, with an empty
+// caller if they are not authenticated.
+func (s *Server) Interceptors() []Interceptor {
+	var stages []Interceptor
+	if s.Auditor != nil {
+		stages = append(stages, auditStage{s.Auditor})
+	}
+	stages = append(stages, authenticationStage{s})
+	if s.Authorizer != nil {
+		stages = append(stages, authorizationStage{s.Authorizer})
+	}
+	if s.Quota != nil {
+		stages = append(stages, quotaStage{s.Quota})
+	}
+	return stages
+}
+
+// UnaryInterceptor returns a gRPC interceptor passing the unary calls
+// through the stages in order. Every call is assigned a request ID, which is
+// returned in the response header. The handler of a call finds the request ID
+// and the identity of the caller in its context, and the calls are counted
+// by method and status.
+func UnaryInterceptor(stages ...Interceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+
+		call := &Call{Method: path.Base(info.FullMethod), Request: req, RequestID: requestid.New()}
+		ctx = requestid.NewContext(ctx, call.RequestID)
+		if err := grpc.SetHeader(ctx, metadata.Pairs(requestid.MetadataKey, call.RequestID)); err != nil {
+			glog.Warningf("Failed to set the request ID header of a %s call (request: %s, error: %v)",
+				call.Method, call.RequestID, err)
+		}
+		start := time.Now()
+		resp, err := chain(stages, func(ctx context.Context, call *Call) (interface{}, error) {
+			if call.Caller != "" {
+				ctx = context.WithValue(ctx, callerKey{}, call.Caller)
+			}
+			return handler(ctx, call.Request)
+		})(ctx, call)
+
+		calls.WithLabelValues(call.Method, grpc.Code(err).String()).Inc()
+		callDuration.WithLabelValues(call.Method).Observe(time.Since(start).Seconds())
+		return resp, err
+	}
+}
+
+// chain returns a Handler passing the calls through the stages, in order, to the handler.
+func chain(stages []Interceptor, handler Handler) Handler {
+	for i := len(stages) - 1; i >= 0; i-- {
+		stage, next := stages[i], handler
+		handler = func(ctx context.Context, call *Call) (interface{}, error) {
+			return stage.Intercept(ctx, call, next)
+		}
+	}
+	return handler
+}
+
+// callerKey is the context key of the identity of the authenticated caller.
+type callerKey struct{}
+
+// callerFromContext returns the identity of the caller set by the
+// authentication stage, if the call has passed through it.
+func callerFromContext(ctx context.Context) (string, bool) {
+	caller, ok := ctx.Value(callerKey{}).(string)
+	return caller, ok
+}
+
+// credentialed is implemented by the request messages carrying a credential.
+type credentialed interface {
+	GetCredential() string
+	GetCredentialType() string
+}
+
+// authenticationStage authenticates the credential of the request, if it has one, as the caller.
+type authenticationStage struct {
+	s *Server
+}
+
+func (st authenticationStage) Intercept(ctx context.Context, call *Call, next Handler) (interface{}, error) {
+	if req, ok := call.Request.(credentialed); ok {
+		id, err := st.s.authenticate(req.GetCredential(), req.GetCredentialType(), call.RequestID)
+		if err != nil {
+			return nil, err
+		}
+		call.Caller = id
+	}
+	return next(ctx, call)
+}
+
+// authorizationStage fails the calls that the Authorizer refuses.
+type authorizationStage struct {
+	authorizer Authorizer
+}
+
+func (st authorizationStage) Intercept(ctx context.Context, call *Call, next Handler) (interface{}, error) {
+	if err := st.authorizer.Authorize(call.Method, call.Caller); err != nil {
+		glog.Warningf("Refused a %s call of %s (request: %s, error: %v)", call.Method, callerName(call.Caller),
+			call.RequestID, err)
+		return nil, apiError(codes.PermissionDenied, v1alpha1.ErrorCode_UNAUTHORIZED_IDENTITY, "%v", err)
+	}
+	return next(ctx, call)
+}
+
+// quotaStage fails the calls that the QuotaLimiter does not admit.
+type quotaStage struct {
+	quota QuotaLimiter
+}
+
+func (st quotaStage) Intercept(ctx context.Context, call *Call, next Handler) (interface{}, error) {
+	if err := st.quota.Admit(call.Method, call.Caller); err != nil {
+		glog.Warningf("Refused a %s call of %s (request: %s, error: %v)", call.Method, callerName(call.Caller),
+			call.RequestID, err)
+		return nil, apiError(codes.ResourceExhausted, v1alpha1.ErrorCode_RATE_LIMITED, "%v", err)
+	}
+	return next(ctx, call)
+}
+
+// auditStage hands the outcome of every call to the Auditor.
+type auditStage struct {
+	auditor Auditor
+}
+
+func (st auditStage) Intercept(ctx context.Context, call *Call, next Handler) (interface{}, error) {
+	start := time.Now()
+	resp, err := next(ctx, call)
+	st.auditor.Audit(call, err, time.Since(start))
+	return resp, err
+}
+
+// callerName returns the identity of the caller for the log, or "an anonymous caller".
+func callerName(caller string) string {
+	if caller == "" {
+		return "an anonymous caller"
+	}
+	return caller
+}
@@ -0,0 +1,249 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"istio.io/auth/api/csr/v1alpha1"
+	"istio.io/auth/authn"
+	"istio.io/auth/certmanager/testutil"
+	"istio.io/auth/pkg/requestid"
+)
+
+// recordingStage appends its name to the trace and passes the call on.
+type recordingStage struct {
+	name  string
+	trace *[]string
+}
+
+func (st recordingStage) Intercept(ctx context.Context, call *Call, next Handler) (interface{}, error) {
+	*st.trace = append(*st.trace, st.name)
+	return next(ctx, call)
+}
+
+// fakeQuota admits up to a number of calls.
+type fakeQuota struct {
+	remaining int
+}
+
+func (q *fakeQuota) Admit(method, caller string) error {
+	if q.remaining == 0 {
+		return errors.New("out of quota")
+	}
+	q.remaining--
+	return nil
+}
+
+// fakeAuditor records the audited calls.
+type fakeAuditor struct {
+	calls []Call
+	codes []codes.Code
+}
+
+func (a *fakeAuditor) Audit(call *Call, err error, elapsed time.Duration) {
+	a.calls = append(a.calls, *call)
+	a.codes = append(a.codes, grpc.Code(err))
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	trace := []string{}
+	interceptor := UnaryInterceptor(recordingStage{"first", &trace}, recordingStage{"second", &trace},
+		authenticationStage{NewServer(nil, map[string]authn.Authenticator{
+			CredentialTypeGCP: testutil.FakeAuthenticator{"token": "spiffe://cluster.local/ns/vm/sa/foo"},
+		}, nil)})
+	info := &grpc.UnaryServerInfo{FullMethod: "/istio.auth.csr.v1alpha1.CertificateService/Sign"}
+
+	var caller, rid string
+	resp, err := interceptor(context.Background(), &v1alpha1.SignRequest{Credential: "token"}, info,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			trace = append(trace, "handler")
+			caller, _ = callerFromContext(ctx)
+			rid = requestid.FromContext(ctx)
+			return "response", nil
+		})
+	if err != nil || resp != "response" {
+		t.Fatalf("Unexpected response %v (error: %v)", resp, err)
+	}
+	if expected := []string{"first", "second", "handler"}; !reflect.DeepEqual(trace, expected) {
+		t.Errorf("Unexpected order of the stages %v (expected %v)", trace, expected)
+	}
+	if caller != "spiffe://cluster.local/ns/vm/sa/foo" {
+		t.Errorf("Unexpected caller %q in the context of the handler", caller)
+	}
+	if rid == "" {
+		t.Error("Expect a request ID in the context of the handler")
+	}
+}
+
+func TestInterceptorStages(t *testing.T) {
+	const id = "spiffe://cluster.local/ns/vm/sa/foo"
+	s := NewServer(nil, map[string]authn.Authenticator{CredentialTypeGCP: testutil.FakeAuthenticator{"token": id}}, nil)
+	policy := MethodPolicy{"BatchSign": {"spiffe://cluster.local/ns/istio-system/*"}}
+
+	testCases := map[string]struct {
+		stage          Interceptor
+		call           *Call
+		expectedCode   codes.Code
+		expectedCaller string
+	}{
+		"Authenticated": {
+			stage:          authenticationStage{s},
+			call:           &Call{Method: "Sign", Request: &v1alpha1.SignRequest{Credential: "token"}},
+			expectedCode:   codes.OK,
+			expectedCaller: id,
+		},
+		"Invalid credential": {
+			stage:        authenticationStage{s},
+			call:         &Call{Method: "Sign", Request: &v1alpha1.SignRequest{Credential: "forged"}},
+			expectedCode: codes.Unauthenticated,
+		},
+		"No credential": {
+			stage:        authenticationStage{s},
+			call:         &Call{Method: "GetNonce", Request: &v1alpha1.GetNonceRequest{}},
+			expectedCode: codes.OK,
+		},
+		"Authorized": {
+			stage:          authorizationStage{policy},
+			call:           &Call{Method: "Sign", Caller: id},
+			expectedCode:   codes.OK,
+			expectedCaller: id,
+		},
+		"Unauthorized": {
+			stage:          authorizationStage{policy},
+			call:           &Call{Method: "BatchSign", Caller: id},
+			expectedCode:   codes.PermissionDenied,
+			expectedCaller: id,
+		},
+		"Within quota": {
+			stage:          quotaStage{&fakeQuota{remaining: 1}},
+			call:           &Call{Method: "Sign", Caller: id},
+			expectedCode:   codes.OK,
+			expectedCaller: id,
+		},
+		"Out of quota": {
+			stage:          quotaStage{&fakeQuota{}},
+			call:           &Call{Method: "Sign", Caller: id},
+			expectedCode:   codes.ResourceExhausted,
+			expectedCaller: id,
+		},
+	}
+
+	for k, tc := range testCases {
+		handled := false
+		_, err := tc.stage.Intercept(context.Background(), tc.call, func(context.Context, *Call) (interface{}, error) {
+			handled = true
+			return nil, nil
+		})
+		if code := grpc.Code(err); code != tc.expectedCode {
+			t.Errorf("%s: Unexpected code %v (expected %v, error: %v)", k, code, tc.expectedCode, err)
+		}
+		if handled != (tc.expectedCode == codes.OK) {
+			t.Errorf("%s: Unexpected call of the next stage: %v", k, handled)
+		}
+		if tc.call.Caller != tc.expectedCaller {
+			t.Errorf("%s: Unexpected caller %q (expected %q)", k, tc.call.Caller, tc.expectedCaller)
+		}
+	}
+}
+
+func TestAuditStage(t *testing.T) {
+	auditor := &fakeAuditor{}
+	stage := auditStage{auditor}
+	call := &Call{Method: "Sign", Caller: "spiffe://cluster.local/ns/vm/sa/foo"}
+
+	failure := apiError(codes.InvalidArgument, v1alpha1.ErrorCode_INVALID_CSR, "invalid")
+	_, err := stage.Intercept(context.Background(), call, func(context.Context, *Call) (interface{}, error) {
+		return nil, failure
+	})
+	if err != failure {
+		t.Errorf("Unexpected error %v (expected %v)", err, failure)
+	}
+	if len(auditor.calls) != 1 || auditor.calls[0] != *call || auditor.codes[0] != codes.InvalidArgument {
+		t.Errorf("Unexpected audit %v %v", auditor.calls, auditor.codes)
+	}
+}
+
+func TestV1alpha1Interceptors(t *testing.T) {
+	const (
+		id       = "spiffe://cluster.local/ns/vm/sa/foo"
+		operator = "spiffe://cluster.local/ns/istio-system/sa/operator"
+	)
+	ca := testutil.NewCA(t, 30*time.Minute)
+	s := NewServer(ca, map[string]authn.Authenticator{
+		CredentialTypeGCP: testutil.FakeAuthenticator{"token": id, "operator-token": operator},
+	}, []string{"istio-ca"})
+	s.Authorizer = MethodPolicy{"BatchSign": {operator}}
+	s.Quota = &fakeQuota{remaining: 1}
+	auditor := &fakeAuditor{}
+	s.Auditor = auditor
+	client, closeClient := dialTestServer(t, s, ca)
+	defer closeClient()
+
+	csrPEM := createCSR(t, []string{id}, nil)
+	_, err := client.BatchSign(context.Background(), &v1alpha1.BatchSignRequest{
+		CsrPems:    [][]byte{csrPEM},
+		Credential: "token",
+	})
+	if code := grpc.Code(err); code != codes.PermissionDenied {
+		t.Errorf("Unexpected code %v of an unauthorized call (error: %v)", code, err)
+	}
+
+	req := &v1alpha1.SignRequest{CsrPem: csrPEM, Credential: "token"}
+	var header metadata.MD
+	if _, err := client.Sign(context.Background(), req, grpc.Header(&header)); err != nil {
+		t.Errorf("Failed to sign the certificate signing request: %v", err)
+	}
+	_, err = client.Sign(context.Background(), req)
+	if code := grpc.Code(err); code != codes.ResourceExhausted {
+		t.Errorf("Unexpected code %v of a call out of quota (error: %v)", code, err)
+	}
+	_, err = client.Sign(context.Background(), &v1alpha1.SignRequest{CsrPem: csrPEM, Credential: "unknown"})
+	if code := grpc.Code(err); code != codes.Unauthenticated {
+		t.Errorf("Unexpected code %v of an unauthenticated call (error: %v)", code, err)
+	}
+
+	// Every call is audited, including those refused before the handler.
+	expected := []struct {
+		method string
+		caller string
+		code   codes.Code
+	}{
+		{"BatchSign", id, codes.PermissionDenied},
+		{"Sign", id, codes.OK},
+		{"Sign", id, codes.ResourceExhausted},
+		{"Sign", "", codes.Unauthenticated},
+	}
+	if len(auditor.calls) != len(expected) {
+		t.Fatalf("Unexpected audited calls %v", auditor.calls)
+	}
+	for i, e := range expected {
+		if c := auditor.calls[i]; c.Method != e.method || c.Caller != e.caller || auditor.codes[i] != e.code {
+			t.Errorf("Unexpected audited call %v with %v (expecting a %s call of %q with %v)", c, auditor.codes[i],
+				e.method, e.caller, e.code)
+		}
+	}
+	if rid := header[requestid.MetadataKey]; len(rid) != 1 || rid[0] != auditor.calls[1].RequestID {
+		t.Errorf("Unexpected request ID header %v (expected the audited ID %s)", rid, auditor.calls[1].RequestID)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"istio.io/auth/pkg/spiffe"
+)
+
+// The methods of the CSR API that a MethodPolicy may restrict. GetNonce
+// carries no credential, so it is open to every caller.
+var restrictableMethods = []string{"BatchSign", "Sign"}
+
+// MethodPolicy maps the methods of the CSR API, e.g. "BatchSign", to the
+// patterns of the identities that may call them, in the syntax of
+// DelegationPolicy. A method it does not list may be called by every
+// authenticated caller.
+type MethodPolicy map[string][]string
+
+// LoadMethodPolicy reads a MethodPolicy from a JSON file holding an object
+// from the methods to arrays of patterns.
+func LoadMethodPolicy(path string) (MethodPolicy, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := MethodPolicy{}
+	if err := json.Unmarshal(bs, &policy); err != nil {
+		return nil, fmt.Errorf("invalid method policy in %s (error: %v)", path, err)
+	}
+	for method, patterns := range policy {
+		if !contains(restrictableMethods, method) {
+			return nil, fmt.Errorf("unknown method %q (expecting one of %v)", method, restrictableMethods)
+		}
+		if _, err := spiffe.NewMatcher(patterns...); err != nil {
+			return nil, fmt.Errorf("method %s has invalid patterns (error: %v)", method, err)
+		}
+	}
+	return policy, nil
+}
+
+// Authorize implements Authorizer.
+func (p MethodPolicy) Authorize(method, caller string) error {
+	patterns, ok := p[method]
+	if !ok || spiffe.Matcher(patterns).Matches(caller) {
+		return nil
+	}
+	return fmt.Errorf("%s may not call %s", callerName(caller), method)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
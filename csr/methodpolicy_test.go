@@ -0,0 +1,112 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadMethodPolicy(t *testing.T) {
+	testCases := map[string]struct {
+		content        string
+		expectedPolicy MethodPolicy
+		expectedErr    string
+	}{
+		"Valid policy": {
+			content: `{"BatchSign": ["spiffe://cluster.local/ns/istio-system/*"]}`,
+			expectedPolicy: MethodPolicy{
+				"BatchSign": {"spiffe://cluster.local/ns/istio-system/*"},
+			},
+		},
+		"Unknown method": {
+			content:     `{"GetNonce": ["spiffe://cluster.local/ns/istio-system/*"]}`,
+			expectedErr: `unknown method "GetNonce"`,
+		},
+		"Invalid pattern": {
+			content:     `{"Sign": ["spiffe://cluster.local/*/sa/foo"]}`,
+			expectedErr: `method Sign has invalid patterns (error: invalid pattern "spiffe://cluster.local/*/sa/foo"`,
+		},
+		"Malformed file": {
+			content:     `["Sign"]`,
+			expectedErr: "invalid method policy",
+		},
+	}
+
+	for id, tc := range testCases {
+		f, err := ioutil.TempFile("", "method-policy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteString(tc.content); err != nil {
+			t.Fatal(err)
+		}
+		f.Close() // nolint: errcheck
+
+		policy, err := LoadMethodPolicy(f.Name())
+		os.Remove(f.Name()) // nolint: errcheck
+		if tc.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: Unexpected error %v (expected %q)", id, err, tc.expectedErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", id, err)
+		} else if !reflect.DeepEqual(policy, tc.expectedPolicy) {
+			t.Errorf("%s: Unexpected policy %v (expected %v)", id, policy, tc.expectedPolicy)
+		}
+	}
+}
+
+func TestMethodPolicyAuthorize(t *testing.T) {
+	policy := MethodPolicy{
+		"BatchSign": {"spiffe://cluster.local/ns/istio-system/*"},
+		"Sign":      {},
+	}
+
+	testCases := map[string]struct {
+		method     string
+		caller     string
+		authorized bool
+	}{
+		"Matching pattern": {
+			method:     "BatchSign",
+			caller:     "spiffe://cluster.local/ns/istio-system/sa/operator",
+			authorized: true,
+		},
+		"Unmatched pattern": {
+			method: "BatchSign",
+			caller: "spiffe://cluster.local/ns/vm/sa/foo",
+		},
+		"Closed method": {
+			method: "Sign",
+			caller: "spiffe://cluster.local/ns/istio-system/sa/operator",
+		},
+		"Unlisted method": {
+			method:     "GetNonce",
+			authorized: true,
+		},
+	}
+
+	for id, tc := range testCases {
+		if err := policy.Authorize(tc.method, tc.caller); (err == nil) != tc.authorized {
+			t.Errorf("%s: Unexpected authorization (error: %v)", id, err)
+		}
+	}
+}
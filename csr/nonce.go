@@ -0,0 +1,118 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// The validity of a nonce from NewNonce.
+const nonceTTL = 5 * time.Minute
+
+// The lengths of the parts of a nonce: the expiry in Unix seconds, random
+// bytes and a truncated HMAC of both.
+const (
+	nonceExpiryLength = 8
+	nonceRandomLength = 16
+	nonceMACLength    = 16
+	nonceLength       = nonceExpiryLength + nonceRandomLength + nonceMACLength
+)
+
+// NonceExtensionID is the OID of the extension carrying the nonce in a
+// certificate signing request, id-cmc-recipientNonce of RFC 5272. Since the
+// extension is covered by the signature of the request, a valid nonce proves
+// that the request was signed by the key recently.
+var NonceExtensionID = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 7, 7}
+
+// NonceExtension returns the extension to add to a certificate signing
+// request, e.g. via x509.CertificateRequest.ExtraExtensions, to present the nonce.
+func NonceExtension(nonce []byte) (pkix.Extension, error) {
+	value, err := asn1.Marshal(nonce)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: NonceExtensionID, Value: value}, nil
+}
+
+// nonceSource issues and verifies stateless nonces, which are authenticated
+// with a key that only lives in memory. A nonce may be used any number of
+// times until it expires, e.g. by all the requests of a batch.
+type nonceSource struct {
+	key []byte
+}
+
+func newNonceSource() *nonceSource {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		glog.Fatalf("Failed to generate the nonce key (error: %v)", err)
+	}
+	return &nonceSource{key: key}
+}
+
+// issue returns a nonce that expires at nonceTTL from now.
+func (n *nonceSource) issue(now time.Time) ([]byte, error) {
+	nonce := make([]byte, nonceExpiryLength+nonceRandomLength, nonceLength)
+	binary.BigEndian.PutUint64(nonce, uint64(now.Add(nonceTTL).Unix()))
+	if _, err := rand.Read(nonce[nonceExpiryLength:]); err != nil {
+		return nil, err
+	}
+	return append(nonce, n.mac(nonce)...), nil
+}
+
+// verify returns an error unless the nonce was issued by NewNonce and has not expired.
+func (n *nonceSource) verify(nonce []byte, now time.Time) error {
+	if len(nonce) != nonceLength {
+		return errors.New("the nonce is malformed")
+	}
+	data := nonce[:nonceExpiryLength+nonceRandomLength]
+	if !hmac.Equal(nonce[len(data):], n.mac(data)) {
+		return errors.New("the nonce is not issued by this server")
+	}
+	if expiry := int64(binary.BigEndian.Uint64(nonce)); now.Unix() > expiry {
+		return errors.New("the nonce has expired")
+	}
+	return nil
+}
+
+func (n *nonceSource) mac(data []byte) []byte {
+	h := hmac.New(sha256.New, n.key)
+	h.Write(data) // nolint: errcheck
+	return h.Sum(nil)[:nonceMACLength]
+}
+
+// csrNonce returns the nonce in the certificate signing request, or nil if it has none.
+func csrNonce(csr *x509.CertificateRequest) ([]byte, error) {
+	for _, ext := range csr.Extensions {
+		if !ext.Id.Equal(NonceExtensionID) {
+			continue
+		}
+		var nonce []byte
+		if rest, err := asn1.Unmarshal(ext.Value, &nonce); err != nil || len(rest) != 0 {
+			return nil, errors.New("the nonce extension is malformed")
+		}
+		return nonce, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"istio.io/auth/authn"
+	"istio.io/auth/certmanager/testutil"
+)
+
+func TestNonce(t *testing.T) {
+	n := newNonceSource()
+	now := time.Now()
+	nonce, err := n.issue(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte{}, nonce...)
+	tampered[0] ^= 1
+
+	testCases := map[string]struct {
+		source        *nonceSource
+		nonce         []byte
+		now           time.Time
+		expectedError string
+	}{
+		"Valid nonce": {
+			source: n,
+			nonce:  nonce,
+			now:    now.Add(nonceTTL),
+		},
+		"Expired nonce": {
+			source:        n,
+			nonce:         nonce,
+			now:           now.Add(nonceTTL + time.Second),
+			expectedError: "the nonce has expired",
+		},
+		"Tampered nonce": {
+			source:        n,
+			nonce:         tampered,
+			now:           now,
+			expectedError: "the nonce is not issued by this server",
+		},
+		"Nonce of another server": {
+			source:        newNonceSource(),
+			nonce:         nonce,
+			now:           now,
+			expectedError: "the nonce is not issued by this server",
+		},
+		"Malformed nonce": {
+			source:        n,
+			nonce:         nonce[1:],
+			now:           now,
+			expectedError: "the nonce is malformed",
+		},
+	}
+
+	for name, tc := range testCases {
+		err := tc.source.verify(tc.nonce, tc.now)
+		if tc.expectedError == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		} else if tc.expectedError != "" && (err == nil || err.Error() != tc.expectedError) {
+			t.Errorf("%s: unexpected error (expecting %q, actual %v)", name, tc.expectedError, err)
+		}
+	}
+}
+
+func TestCSRNonce(t *testing.T) {
+	ext, err := NonceExtension([]byte("nonce"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		extensions    []pkix.Extension
+		expectedNonce string
+		expectedError bool
+	}{
+		"With nonce": {
+			extensions:    []pkix.Extension{ext},
+			expectedNonce: "nonce",
+		},
+		"Without nonce": {},
+		"Malformed nonce": {
+			extensions:    []pkix.Extension{{Id: NonceExtensionID, Value: []byte("nonce")}},
+			expectedError: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		nonce, err := csrNonce(&x509.CertificateRequest{Extensions: tc.extensions})
+		if (err != nil) != tc.expectedError {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+		if string(nonce) != tc.expectedNonce {
+			t.Errorf("%s: unexpected nonce %q (expecting %q)", name, nonce, tc.expectedNonce)
+		}
+	}
+}
+
+func TestSignNonce(t *testing.T) {
+	const id = "spiffe://cluster.local/ns/vm/sa/foo"
+	authenticators := map[string]authn.Authenticator{CredentialTypeGCP: testutil.FakeAuthenticator{"token": id}}
+	s := NewServer(testutil.NewCA(t, 30*time.Minute), authenticators, []string{"istio-ca"})
+
+	nonce, err := s.NewNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := NonceExtension(nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := newNonceSource().issue(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid, err := NonceExtension(foreign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	malformed := pkix.Extension{Id: NonceExtensionID, Value: []byte{asn1.TagOctetString, 0xff}}
+
+	testCases := map[string]struct {
+		requireNonce  bool
+		extensions    []pkix.Extension
+		expectedCode  codes.Code
+		expectedError string
+	}{
+		"Optional nonce absent": {
+			expectedCode: codes.OK,
+		},
+		"Required nonce absent": {
+			requireNonce:  true,
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "must carry a nonce",
+		},
+		"Valid nonce": {
+			requireNonce: true,
+			extensions:   []pkix.Extension{valid},
+			expectedCode: codes.OK,
+		},
+		"Nonce of another server": {
+			extensions:    []pkix.Extension{invalid},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "the nonce is not issued by this server",
+		},
+		"Malformed nonce": {
+			extensions:    []pkix.Extension{malformed},
+			expectedCode:  codes.InvalidArgument,
+			expectedError: "the nonce extension is malformed",
+		},
+	}
+
+	for name, tc := range testCases {
+		s.RequireNonce = tc.requireNonce
+		req := &Request{CSR: createCSRWithExtensions(t, []string{id}, nil, tc.extensions), Credential: "token"}
+		_, err := s.Sign(req)
+		if code := grpc.Code(err); code != tc.expectedCode {
+			t.Errorf("%s: unexpected code (expecting %v, actual %v: %v)", name, tc.expectedCode, code, err)
+		} else if !strings.Contains(grpc.ErrorDesc(err), tc.expectedError) {
+			t.Errorf("%s: unexpected error (expecting %q, actual %v)", name, tc.expectedError, err)
+		}
+	}
+}
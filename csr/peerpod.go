@@ -0,0 +1,83 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"net"
+
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/auth/certmanager"
+	"istio.io/auth/pkg/spiffe"
+)
+
+// PodProvider decides the pod whose identity the server embeds in the
+// certificate of a caller, see certmanager.PodIdentityExtensionID.
+type PodProvider interface {
+	// ProvidePod returns the identity of the pod of the identity that
+	// requests a certificate from the address, or nil if it is unknown.
+	ProvidePod(id string, addr net.Addr) (*certmanager.PodIdentity, error)
+}
+
+// PeerPods provides the Kubernetes pod running at the IP address of a caller,
+// if it runs as the service account of the identity of the caller. A caller
+// sharing its IP address with other pods of the service account, e.g. on the
+// host network, gets no pod identity since its pod is ambiguous.
+type PeerPods struct {
+	pods corev1.PodsGetter
+}
+
+// NewPeerPods returns a pointer to a PeerPods looking the pods up with the
+// client.
+func NewPeerPods(pods corev1.PodsGetter) *PeerPods {
+	return &PeerPods{pods: pods}
+}
+
+// ProvidePod implements PodProvider.
+func (p *PeerPods) ProvidePod(id string, addr net.Addr) (*certmanager.PodIdentity, error) {
+	namespace, serviceAccount, err := spiffe.ParseIstioID(id)
+	if err != nil {
+		return nil, nil
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return nil, nil
+	}
+
+	pods, err := runningPodsAt(p.pods, namespace, tcpAddr.IP)
+	if err != nil {
+		return nil, err
+	}
+	var found *v1.Pod
+	for _, pod := range pods {
+		if pod.Spec.ServiceAccountName != serviceAccount {
+			continue
+		}
+		if found != nil {
+			return nil, nil
+		}
+		found = pod
+	}
+	if found == nil {
+		return nil, nil
+	}
+	return &certmanager.PodIdentity{
+		Namespace: found.Namespace,
+		Name:      found.Name,
+		UID:       string(found.UID),
+		NodeName:  found.Spec.NodeName,
+	}, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/auth/certmanager"
+)
+
+func createPod(name, serviceAccount, ip string, phase v1.PodPhase) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "vm", UID: types.UID(name + "-uid")},
+		Spec:       v1.PodSpec{ServiceAccountName: serviceAccount, NodeName: "node-1"},
+		Status:     v1.PodStatus{PodIP: ip, Phase: phase},
+	}
+}
+
+func TestPeerPods(t *testing.T) {
+	const id = "spiffe://cluster.local/ns/vm/sa/foo"
+	client := fake.NewSimpleClientset(
+		createPod("foo-1", "foo", "10.0.0.1", v1.PodRunning),
+		createPod("foo-2", "foo", "10.0.0.2", v1.PodSucceeded),
+		createPod("bar-1", "bar", "10.0.0.2", v1.PodRunning),
+		createPod("host-1", "foo", "10.0.0.3", v1.PodRunning),
+		createPod("host-2", "foo", "10.0.0.3", v1.PodRunning),
+	)
+
+	testCases := map[string]struct {
+		id       string
+		addr     net.Addr
+		expected *certmanager.PodIdentity
+	}{
+		"Running pod": {
+			id:       id,
+			addr:     &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4000},
+			expected: &certmanager.PodIdentity{Namespace: "vm", Name: "foo-1", UID: "foo-1-uid", NodeName: "node-1"},
+		},
+		"Terminated pod whose IP address is reused by another service account": {
+			id:   id,
+			addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 4000},
+		},
+		"Pods sharing the IP address": {
+			id:   id,
+			addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.3"), Port: 4000},
+		},
+		"Unknown address": {
+			id:   id,
+			addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.9"), Port: 4000},
+		},
+		"Not a service account identity": {
+			id:   "spiffe://cluster.local/ns/vm",
+			addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4000},
+		},
+	}
+
+	p := NewPeerPods(client.CoreV1())
+	for k, tc := range testCases {
+		pod, err := p.ProvidePod(tc.id, tc.addr)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+		}
+		if !reflect.DeepEqual(pod, tc.expected) {
+			t.Errorf("%s: unexpected pod %v (expecting %v)", k, pod, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const rateLimitWindow = time.Minute
+
+// CallerRateLimit is a QuotaLimiter bounding the calls of each method by each
+// caller within a minute, so that a misbehaving workload cannot monopolize
+// the CA. The calls without a caller, i.e. those of GetNonce, are not limited.
+type CallerRateLimit struct {
+	mutex sync.Mutex
+
+	perMinute int
+
+	// The times of the calls within the last minute, oldest first, by method and caller.
+	calls      map[callKey][]time.Time
+	lastPruned time.Time
+
+	now func() time.Time
+}
+
+type callKey struct {
+	method, caller string
+}
+
+// NewCallerRateLimit returns a pointer to a CallerRateLimit admitting up to
+// perMinute calls of each method by each caller within a minute.
+func NewCallerRateLimit(perMinute int) *CallerRateLimit {
+	return &CallerRateLimit{perMinute: perMinute, calls: map[callKey][]time.Time{}, now: time.Now}
+}
+
+// Admit implements QuotaLimiter.
+func (l *CallerRateLimit) Admit(method, caller string) error {
+	if caller == "" {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.pruneIdle(now)
+
+	k := callKey{method, caller}
+	times := trimCalls(l.calls[k], now)
+	if len(times) >= l.perMinute {
+		l.calls[k] = times
+		return fmt.Errorf("%s exceeds its rate limit of %d %s calls per minute", caller, l.perMinute, method)
+	}
+	l.calls[k] = append(times, now)
+	return nil
+}
+
+// pruneIdle drops the callers without calls within the last minute, at most
+// once per minute. It must be called with the lock held.
+func (l *CallerRateLimit) pruneIdle(now time.Time) {
+	if now.Sub(l.lastPruned) < rateLimitWindow {
+		return
+	}
+	l.lastPruned = now
+
+	for k, times := range l.calls {
+		if len(trimCalls(times, now)) == 0 {
+			delete(l.calls, k)
+		}
+	}
+}
+
+// trimCalls drops the times, oldest first, that are a minute or more before now.
+func trimCalls(times []time.Time, now time.Time) []time.Time {
+	for len(times) > 0 && !times[0].After(now.Add(-rateLimitWindow)) {
+		times = times[1:]
+	}
+	return times
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCallerRateLimit(t *testing.T) {
+	const (
+		foo = "spiffe://cluster.local/ns/vm/sa/foo"
+		bar = "spiffe://cluster.local/ns/vm/sa/bar"
+	)
+	type call struct {
+		method, caller string
+		// The time of the call, relative to the first one.
+		after       time.Duration
+		expectLimit bool
+	}
+	testCases := map[string]struct {
+		calls []call
+	}{
+		"Calls per minute": {
+			calls: []call{
+				{method: "Sign", caller: foo},
+				{method: "Sign", caller: foo, after: 10 * time.Second},
+				{method: "Sign", caller: foo, after: 20 * time.Second, expectLimit: true},
+				// The first call has left the window.
+				{method: "Sign", caller: foo, after: time.Minute},
+				{method: "Sign", caller: foo, after: time.Minute, expectLimit: true},
+			},
+		},
+		"Methods and callers": {
+			calls: []call{
+				{method: "Sign", caller: foo},
+				{method: "Sign", caller: foo},
+				{method: "BatchSign", caller: foo},
+				{method: "Sign", caller: bar},
+				{method: "Sign", caller: foo, expectLimit: true},
+			},
+		},
+		"Anonymous calls": {
+			calls: []call{
+				{method: "GetNonce"},
+				{method: "GetNonce"},
+				{method: "GetNonce"},
+			},
+		},
+	}
+
+	start := time.Now()
+	for k, tc := range testCases {
+		l := NewCallerRateLimit(2)
+		for i, c := range tc.calls {
+			l.now = func() time.Time { return start.Add(c.after) }
+			err := l.Admit(c.method, c.caller)
+			if c.expectLimit != (err != nil) {
+				t.Errorf("%s: call %d: unexpected error: %v", k, i, err)
+			}
+		}
+	}
+}
+
+func TestCallerRateLimitPruning(t *testing.T) {
+	start := time.Now()
+	l := NewCallerRateLimit(1)
+	l.now = func() time.Time { return start }
+	if err := l.Admit("Sign", "spiffe://cluster.local/ns/vm/sa/foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	l.now = func() time.Time { return start.Add(2 * time.Minute) }
+	if err := l.Admit("Sign", "spiffe://cluster.local/ns/vm/sa/bar"); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.calls) != 1 {
+		t.Errorf("Unexpected calls of idle callers retained: %v", l.calls)
+	}
+}
@@ -0,0 +1,481 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package csr serves the CSR API of Istio CA over gRPC. The API signs the
+// certificate signing requests of workloads, e.g. VMs outside Kubernetes,
+// that authenticate with credentials of external identity providers.
+//
+// The API is versioned by protobuf package (see api/csr/v1alpha1). Every
+// served version is adapted to the version-independent Request and Response
+// of the Server, so that all versions share the authentication and signing.
+// The unary calls pass through a pipeline of interceptors before their
+// handler: authentication, authorization, quota and audit.
+
+package csr
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+
+	"istio.io/auth/api/csr/v1alpha1"
+	"istio.io/auth/authn"
+	"istio.io/auth/certmanager"
+	"istio.io/auth/parse"
+	"istio.io/auth/pkg/requestid"
+	"istio.io/auth/pkg/spiffe"
+)
+
+// The types of the credentials accepted by the CSR API.
+const (
+	CredentialTypeGCP        = "gcp"
+	CredentialTypeAzure      = "azure"
+	CredentialTypeKubernetes = "kubernetes"
+	CredentialTypeOIDC       = "oidc"
+)
+
+// MaxBatchSize is the maximum number of certificate signing requests in a batch.
+const MaxBatchSize = 64
+
+// The maximum size of a received message, which carries a credential and up
+// to a batch of certificate signing requests.
+const maxRequestSize = MaxBatchSize*parse.MaxCSRSize + parse.MaxJWTSize + 4*1024
+
+// The default limits of the connections to the server.
+const (
+	DefaultMaxConcurrentStreams  = 100
+	DefaultMaxConnectionsPerPeer = 16
+)
+
+// The keepalive policy of the connections: idle connections are closed, and
+// the others are recycled so that clients rebalance across the replicas of
+// the CA. Clients pinging more often than every minute are disconnected.
+var (
+	keepaliveParams = keepalive.ServerParameters{
+		MaxConnectionIdle:     5 * time.Minute,
+		MaxConnectionAge:      30 * time.Minute,
+		MaxConnectionAgeGrace: time.Minute,
+		Time:                  2 * time.Minute,
+		Timeout:               20 * time.Second,
+	}
+	keepaliveEnforcement = keepalive.EnforcementPolicy{MinTime: time.Minute}
+)
+
+// Signer signs certificate signing requests.
+type Signer interface {
+	Sign(csrPEM []byte) (chain []byte, err error)
+	SignOnBehalf(csrPEM []byte, requester string, dnsNames ...string) (chain []byte, err error)
+	SignForPod(csrPEM []byte, pod certmanager.PodIdentity, dnsNames ...string) (chain []byte, err error)
+	GetRootCertificate() []byte
+}
+
+// Request is a certificate signing request received with any version of the CSR API.
+type Request struct {
+	// The PEM-encoded certificate signing request.
+	CSR []byte
+
+	Credential string
+
+	// The type of the credential, or empty if the caller did not specify it.
+	CredentialType string
+
+	// The address of the caller, if known.
+	Peer net.Addr
+}
+
+// Response is the answer to a Request.
+type Response struct {
+	CertChain []byte
+	RootCert  []byte
+
+	// The suggested time to renew the certificate, or zero if unknown.
+	RenewAfter time.Time
+}
+
+// BatchRequest is a batch of certificate signing requests of the same caller
+// received with any version of the CSR API.
+type BatchRequest struct {
+	// The PEM-encoded certificate signing requests.
+	CSRs [][]byte
+
+	Credential     string
+	CredentialType string
+	Peer           net.Addr
+}
+
+// BatchResponse is the answer to a BatchRequest.
+type BatchResponse struct {
+	// The results of the certificate signing requests, in the order of the request.
+	Results []Result
+
+	RootCert []byte
+}
+
+// Result is the outcome of a certificate signing request in a batch. Err is
+// a gRPC status error if the request is not signed.
+type Result struct {
+	CertChain  []byte
+	RenewAfter time.Time
+	Err        error
+}
+
+// Server authenticates the callers of the CSR API and signs their
+// certificate signing requests.
+type Server struct {
+	ca Signer
+
+	// Maps from the credential types to the authenticators of the credentials.
+	authenticators map[string]authn.Authenticator
+
+	// If not nil, the callers that may request certificates on behalf of other identities.
+	Delegation DelegationPolicy
+
+	// If not nil, decides the DNS names that a certificate signing request
+	// may ask for along with its identity. Otherwise, none are allowed.
+	DNSNames DNSNameAuthorizer
+
+	// If not nil, provides the DNS names added to the certificate of a caller
+	// requesting its own identity, by the address of the caller.
+	PeerDNSNames DNSNameProvider
+
+	// If not nil, provides the pod whose identity the certificate of a caller
+	// requesting its own identity carries, by the address of the caller.
+	PeerPods PodProvider
+
+	// Whether every certificate signing request must carry a nonce from
+	// GetNonce. A nonce that is present is verified regardless.
+	RequireNonce bool
+	nonces       *nonceSource
+
+	// The stages of the pipeline of the unary calls around the
+	// authentication, see Interceptors. Each is skipped if nil; the Auditor
+	// writes to the log by default.
+	Authorizer Authorizer
+	Quota      QuotaLimiter
+	Auditor    Auditor
+
+	// The maximum number of concurrent calls on a connection, and of
+	// concurrent connections from the same IP address.
+	MaxConcurrentStreams  uint32
+	MaxConnectionsPerPeer int
+
+	trustBundlePollInterval time.Duration
+
+	// The TLS certificate of the server, which is reissued once half of its lifetime has passed.
+	cert *certmanager.ServingCertificate
+}
+
+// NewServer returns a pointer to a Server signing with the CA the requests
+// authenticated by the authenticators of their credential types. The TLS
+// certificate of the server is issued by the CA for the hosts.
+func NewServer(ca Signer, authenticators map[string]authn.Authenticator, hosts []string) *Server {
+	sign := func(csrPEM []byte) ([]byte, error) { return ca.Sign(csrPEM) }
+	return &Server{
+		ca:             ca,
+		authenticators: authenticators,
+		cert:           certmanager.NewServingCertificate(sign, hosts),
+		nonces:         newNonceSource(),
+		Auditor:        LogAuditor{},
+
+		MaxConcurrentStreams:  DefaultMaxConcurrentStreams,
+		MaxConnectionsPerPeer: DefaultMaxConnectionsPerPeer,
+
+		trustBundlePollInterval: trustBundlePollInterval,
+	}
+}
+
+// NewNonce returns a nonce to present in certificate signing requests, which
+// is valid for a few minutes.
+func (s *Server) NewNonce() ([]byte, error) {
+	nonce, err := s.nonces.issue(time.Now())
+	if err != nil {
+		return nil, apiError(codes.Internal, v1alpha1.ErrorCode_BACKEND_UNAVAILABLE, "failed to generate a nonce")
+	}
+	return nonce, nil
+}
+
+// Sign authenticates the caller of the request, checks that the certificate
+// signing request only asks for the identity of the caller, or for one that
+// the caller may request on behalf of, and signs it.
+func (s *Server) Sign(req *Request) (*Response, error) {
+	rid := requestid.New()
+	id, err := s.authenticate(req.Credential, req.CredentialType, rid)
+	if err != nil {
+		return nil, err
+	}
+	return s.signAs(id, rid, req.CSR, req.Peer)
+}
+
+// signAs signs the certificate signing request of the authenticated caller
+// like Sign, logging it with the request ID.
+func (s *Server) signAs(id, requestID string, csrPEM []byte, peer net.Addr) (*Response, error) {
+	chain, err := s.signFor(id, requestID, csrPEM, peer)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{CertChain: chain, RootCert: s.ca.GetRootCertificate(), RenewAfter: renewAfter(chain)}, nil
+}
+
+// BatchSign authenticates the caller of the request once, and signs each of
+// its certificate signing requests like Sign. A CSR that cannot be signed
+// fails its own result rather than the whole batch.
+func (s *Server) BatchSign(req *BatchRequest) (*BatchResponse, error) {
+	if err := checkBatchSize(req); err != nil {
+		return nil, err
+	}
+	rid := requestid.New()
+	id, err := s.authenticate(req.Credential, req.CredentialType, rid)
+	if err != nil {
+		return nil, err
+	}
+	return s.batchSignAs(id, rid, req)
+}
+
+// batchSignAs signs the certificate signing requests of the authenticated
+// caller like BatchSign, logging them with the request ID.
+func (s *Server) batchSignAs(id, requestID string, req *BatchRequest) (*BatchResponse, error) {
+	if err := checkBatchSize(req); err != nil {
+		return nil, err
+	}
+
+	resp := &BatchResponse{Results: make([]Result, len(req.CSRs)), RootCert: s.ca.GetRootCertificate()}
+	for i, csrPEM := range req.CSRs {
+		resp.Results[i].CertChain, resp.Results[i].Err = s.signFor(id, requestID, csrPEM, req.Peer)
+		if resp.Results[i].Err == nil {
+			resp.Results[i].RenewAfter = renewAfter(resp.Results[i].CertChain)
+		}
+	}
+	return resp, nil
+}
+
+// renewAfter returns the time suggested by certmanager.RenewalTime to renew
+// the leaf certificate of the chain, or zero if the chain cannot be parsed.
+func renewAfter(chain []byte) time.Time {
+	certs, err := parse.CertificateChain(chain)
+	if err != nil || len(certs) == 0 {
+		return time.Time{}
+	}
+	return certmanager.RenewalTime(certs[0].NotBefore, certs[0].NotAfter)
+}
+
+func checkBatchSize(req *BatchRequest) error {
+	if len(req.CSRs) == 0 || len(req.CSRs) > MaxBatchSize {
+		return apiError(codes.InvalidArgument, v1alpha1.ErrorCode_INVALID_CSR,
+			"a batch must have 1 to %d certificate signing requests (has %d)", MaxBatchSize, len(req.CSRs))
+	}
+	return nil
+}
+
+// authenticate returns the identity that the credential of the type maps to.
+func (s *Server) authenticate(credential, credentialType, requestID string) (string, error) {
+	a, err := s.authenticator(credentialType)
+	if err != nil {
+		return "", err
+	}
+	id, err := a.Authenticate(credential)
+	if err != nil {
+		glog.Warningf("Failed to authenticate a certificate signing request (request: %s, error: %v)", requestID, err)
+		return "", apiError(codes.Unauthenticated, v1alpha1.ErrorCode_UNAUTHENTICATED, "invalid credential: %v", err)
+	}
+	return id, nil
+}
+
+// signFor signs the certificate signing request of the authenticated
+// caller, if it asks for the identity of the caller or, by the delegation
+// policy, for one that the caller may request on behalf of, and for no DNS
+// names but those that the DNS name authorizer allows for the identity. The
+// certificate of the caller's own identity gets the DNS names that the peer
+// DNS name provider returns for the address of the caller.
+func (s *Server) signFor(caller, requestID string, csrPEM []byte, peer net.Addr) ([]byte, error) {
+	// parse.CSR verifies the signature, i.e. that the caller holds the private key.
+	csr, err := parse.CSR(csrPEM)
+	if err != nil {
+		return nil, apiError(codes.InvalidArgument, v1alpha1.ErrorCode_INVALID_CSR,
+			"invalid certificate signing request: %v", err)
+	}
+	if err := s.checkNonce(csr); err != nil {
+		glog.Warningf("Refused to sign a certificate signing request of %s (request: %s, error: %v)",
+			caller, requestID, err)
+		return nil, apiError(codes.InvalidArgument, v1alpha1.ErrorCode_INVALID_CSR, "%v", err)
+	}
+	id, err := requestedIdentity(csr)
+	if err != nil {
+		glog.Warningf("Refused to sign a certificate signing request of %s (request: %s, error: %v)",
+			caller, requestID, err)
+		return nil, apiError(codes.PermissionDenied, v1alpha1.ErrorCode_UNAUTHORIZED_IDENTITY, "%v", err)
+	}
+
+	if id != caller && !s.Delegation.Allows(caller, id) {
+		glog.Warningf("Refused to sign a certificate signing request of %s for %s (request: %s)", caller, id, requestID)
+		return nil, apiError(codes.PermissionDenied, v1alpha1.ErrorCode_UNAUTHORIZED_IDENTITY,
+			"%s may not request a certificate for %s", caller, id)
+	}
+	if len(csr.DNSNames) > 0 {
+		if s.DNSNames == nil {
+			err = fmt.Errorf("the certificate signing request may not ask for DNS names")
+		} else {
+			err = s.DNSNames.AuthorizeDNSNames(id, csr.DNSNames)
+		}
+		if err != nil {
+			glog.Warningf("Refused to sign a certificate signing request of %s (request: %s, error: %v)",
+				caller, requestID, err)
+			return nil, apiError(codes.PermissionDenied, v1alpha1.ErrorCode_UNAUTHORIZED_IDENTITY, "%v", err)
+		}
+	}
+
+	var peerNames []string
+	if id == caller && s.PeerDNSNames != nil && peer != nil {
+		if peerNames, err = s.PeerDNSNames.ProvideDNSNames(id, peer); err != nil {
+			glog.Errorf("Failed to find the DNS names of %s at %s (request: %s, error: %v)",
+				caller, peer, requestID, err)
+			return nil, apiError(codes.Unavailable, v1alpha1.ErrorCode_BACKEND_UNAVAILABLE,
+				"failed to find the DNS names of the caller")
+		}
+	}
+	var pod *certmanager.PodIdentity
+	if id == caller && s.PeerPods != nil && peer != nil {
+		if pod, err = s.PeerPods.ProvidePod(id, peer); err != nil {
+			glog.Errorf("Failed to find the pod of %s at %s (request: %s, error: %v)", caller, peer, requestID, err)
+			return nil, apiError(codes.Unavailable, v1alpha1.ErrorCode_BACKEND_UNAVAILABLE,
+				"failed to find the pod of the caller")
+		}
+	}
+
+	var chain []byte
+	switch {
+	case id != caller:
+		chain, err = s.ca.SignOnBehalf(csrPEM, caller)
+	case pod != nil:
+		chain, err = s.ca.SignForPod(csrPEM, *pod, peerNames...)
+	case len(peerNames) > 0:
+		chain, err = s.ca.SignOnBehalf(csrPEM, "", peerNames...)
+	default:
+		chain, err = s.ca.Sign(csrPEM)
+	}
+	if err != nil {
+		apiErr := signError(err)
+		if grpc.Code(apiErr) == codes.Unavailable {
+			glog.Errorf("Failed to sign the certificate signing request of %s (request: %s, error: %v)",
+				caller, requestID, err)
+		} else {
+			glog.Warningf("The CA refused the certificate signing request of %s (request: %s, error: %v)",
+				caller, requestID, err)
+		}
+		return nil, apiErr
+	}
+
+	if id == caller {
+		glog.Infof("Signed a certificate for %s (request: %s)", id, requestID)
+	} else {
+		glog.Infof("Signed a certificate for %s requested by delegate %s (request: %s)", id, caller, requestID)
+	}
+	return chain, nil
+}
+
+// Register registers every served version of the CSR API with the gRPC
+// server. The gRPC server should be created with the UnaryInterceptor of the
+// Interceptors of the server, or the calls are only authenticated.
+func (s *Server) Register(gs *grpc.Server) {
+	v1alpha1.RegisterCertificateServiceServer(gs, &v1alpha1Service{s})
+}
+
+// Run serves the CSR API over TLS on the address until an error occurs.
+func (s *Server) Run(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	glog.Infof("Serving the CSR API on %s", addr)
+	return s.Serve(lis)
+}
+
+// Serve serves the CSR API over TLS on the listener until an error occurs.
+// The unary calls pass through the Interceptors of the server, and the
+// connections are bounded by MaxConcurrentStreams, MaxConnectionsPerPeer and
+// the keepalive policy.
+func (s *Server) Serve(lis net.Listener) error {
+	creds := credentials.NewTLS(&tls.Config{GetCertificate: s.GetCertificate})
+	gs := grpc.NewServer(grpc.Creds(creds), grpc.MaxRecvMsgSize(maxRequestSize),
+		grpc.MaxConcurrentStreams(s.MaxConcurrentStreams),
+		grpc.KeepaliveParams(keepaliveParams), grpc.KeepaliveEnforcementPolicy(keepaliveEnforcement),
+		grpc.UnaryInterceptor(UnaryInterceptor(s.Interceptors()...)))
+	s.Register(gs)
+	return gs.Serve(limitPeerConnections(lis, s.MaxConnectionsPerPeer))
+}
+
+// authenticator returns the authenticator of the credential type. An empty
+// type selects the only authenticator, if there is one.
+func (s *Server) authenticator(credentialType string) (authn.Authenticator, error) {
+	if credentialType == "" && len(s.authenticators) == 1 {
+		for _, a := range s.authenticators {
+			return a, nil
+		}
+	}
+	if a, ok := s.authenticators[credentialType]; ok {
+		return a, nil
+	}
+
+	types := []string{}
+	for t := range s.authenticators {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return nil, apiError(codes.Unauthenticated, v1alpha1.ErrorCode_UNAUTHENTICATED,
+		"unsupported credential type %q (expecting one of %v)", credentialType, types)
+}
+
+// checkNonce verifies the nonce in the certificate signing request, if it has
+// one or one is required.
+func (s *Server) checkNonce(csr *x509.CertificateRequest) error {
+	nonce, err := csrNonce(csr)
+	if err != nil {
+		return err
+	}
+	if nonce == nil {
+		if s.RequireNonce {
+			return fmt.Errorf("the certificate signing request must carry a nonce from GetNonce")
+		}
+		return nil
+	}
+	return s.nonces.verify(nonce, time.Now())
+}
+
+// requestedIdentity returns the Istio identity that the certificate signing
+// request asks for, which must be its only subject alternative name besides
+// DNS names.
+func requestedIdentity(csr *x509.CertificateRequest) (string, error) {
+	if len(csr.URIs) != 1 || len(csr.EmailAddresses)+len(csr.IPAddresses) != 0 {
+		return "", fmt.Errorf("the certificate signing request must ask for a single identity only")
+	}
+	id := csr.URIs[0].String()
+	if _, _, err := spiffe.ParseIstioID(id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// GetCertificate returns the TLS certificate of the server. It generates the
+// key itself and has the CA sign it, so that it works with key escrow disabled.
+func (s *Server) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.cert.GetCertificate(hello)
+}
@@ -0,0 +1,388 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"istio.io/auth/authn"
+	"istio.io/auth/certmanager"
+	"istio.io/auth/certmanager/testutil"
+)
+
+// fakeDNSNames authorizes the DNS names in the set for any identity.
+type fakeDNSNames map[string]bool
+
+func (a fakeDNSNames) AuthorizeDNSNames(id string, dnsNames []string) error {
+	for _, name := range dnsNames {
+		if !a[name] {
+			return fmt.Errorf("%s may not carry the DNS name %s", id, name)
+		}
+	}
+	return nil
+}
+
+// fakePeerDNSNames provides the DNS names mapped from the addresses, and
+// fails for the other addresses.
+type fakePeerDNSNames map[string][]string
+
+func (p fakePeerDNSNames) ProvideDNSNames(id string, addr net.Addr) ([]string, error) {
+	if names, ok := p[addr.String()]; ok {
+		return names, nil
+	}
+	return nil, fmt.Errorf("unknown address %s", addr)
+}
+
+// fakePeerPods provides the pods mapped from the addresses, and fails for the
+// other addresses.
+type fakePeerPods map[string]certmanager.PodIdentity
+
+func (p fakePeerPods) ProvidePod(id string, addr net.Addr) (*certmanager.PodIdentity, error) {
+	if pod, ok := p[addr.String()]; ok {
+		return &pod, nil
+	}
+	return nil, fmt.Errorf("unknown address %s", addr)
+}
+
+func createCSR(t *testing.T, uris, dnsNames []string) []byte {
+	return createCSRWithExtensions(t, uris, dnsNames, nil)
+}
+
+func createCSRWithExtensions(t *testing.T, uris, dnsNames []string, extensions []pkix.Extension) []byte {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.CertificateRequest{DNSNames: dnsNames, ExtraExtensions: extensions}
+	for _, u := range uris {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.URIs = append(template.URIs, parsed)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+// tamperSignature flips the last bit of the signature of the PEM-encoded CSR.
+func tamperSignature(t *testing.T, csrPEM []byte) []byte {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil {
+		t.Fatal("failed to decode the certificate signing request")
+	}
+	block.Bytes[len(block.Bytes)-1] ^= 1
+	return pem.EncodeToMemory(block)
+}
+
+func TestSign(t *testing.T) {
+	const id = "spiffe://cluster.local/ns/vm/sa/foo"
+	gcp := testutil.FakeAuthenticator{"gcp-token": id}
+	azure := testutil.FakeAuthenticator{"azure-token": id}
+
+	const delegate = "spiffe://cluster.local/ns/istio-system/sa/ingress-operator"
+	operator := testutil.FakeAuthenticator{"operator-token": delegate}
+	delegation := DelegationPolicy{delegate: {"spiffe://cluster.local/ns/vm/*"}}
+
+	testCases := map[string]struct {
+		authenticators      map[string]authn.Authenticator
+		dnsNames            fakeDNSNames
+		req                 *Request
+		expectedCode        codes.Code
+		expectedRequestedBy string
+	}{
+		"Signed": {
+			authenticators: map[string]authn.Authenticator{CredentialTypeGCP: gcp, CredentialTypeAzure: azure},
+			req: &Request{CSR: createCSR(t, []string{id}, nil), Credential: "azure-token",
+				CredentialType: CredentialTypeAzure},
+			expectedCode: codes.OK,
+		},
+		"Only credential type": {
+			authenticators: map[string]authn.Authenticator{CredentialTypeGCP: gcp},
+			req:            &Request{CSR: createCSR(t, []string{id}, nil), Credential: "gcp-token"},
+			expectedCode:   codes.OK,
+		},
+		"Missing credential type": {
+			authenticators: map[string]authn.Authenticator{CredentialTypeGCP: gcp, CredentialTypeAzure: azure},
+			req:            &Request{CSR: createCSR(t, []string{id}, nil), Credential: "gcp-token"},
+			expectedCode:   codes.Unauthenticated,
+		},
+		"Unsupported credential type": {
+			authenticators: map[string]authn.Authenticator{CredentialTypeGCP: gcp},
+			req: &Request{CSR: createCSR(t, []string{id}, nil), Credential: "azure-token",
+				CredentialType: CredentialTypeAzure},
+			expectedCode: codes.Unauthenticated,
+		},
+		"Invalid credential": {
+			authenticators: map[string]authn.Authenticator{CredentialTypeGCP: gcp},
+			req: &Request{CSR: createCSR(t, []string{id}, nil), Credential: "azure-token",
+				CredentialType: CredentialTypeGCP},
+			expectedCode: codes.Unauthenticated,
+		},
+		"Malformed CSR": {
+			authenticators: map[string]authn.Authenticator{CredentialTypeGCP: gcp},
+			req:            &Request{CSR: []byte("not a CSR"), Credential: "gcp-token"},
+			expectedCode:   codes.InvalidArgument,
+		},
+		"Not signed by the key": {
+			authenticators: map[string]authn.Authenticator{CredentialTypeGCP: gcp},
+			req:            &Request{CSR: tamperSignature(t, createCSR(t, []string{id}, nil)), Credential: "gcp-token"},
+			expectedCode:   codes.InvalidArgument,
+		},
+		"Other identity": {
+			authenticators: map[string]authn.Authenticator{CredentialTypeGCP: gcp},
+			req: &Request{CSR: createCSR(t, []string{"spiffe://cluster.local/ns/vm/sa/bar"}, nil),
+				Credential: "gcp-token"},
+			expectedCode: codes.PermissionDenied,
+		},
+		"Delegated": {
+			authenticators:      map[string]authn.Authenticator{CredentialTypeGCP: operator},
+			req:                 &Request{CSR: createCSR(t, []string{id}, nil), Credential: "operator-token"},
+			expectedCode:        codes.OK,
+			expectedRequestedBy: delegate,
+		},
+		"Delegation outside the policy": {
+			authenticators: map[string]authn.Authenticator{CredentialTypeGCP: operator},
+			req: &Request{CSR: createCSR(t, []string{"spiffe://cluster.local/ns/prod/sa/foo"}, nil),
+				Credential: "operator-token"},
+			expectedCode: codes.PermissionDenied,
+		},
+		"Delegation for a non-Istio identity under the prefix": {
+			authenticators: map[string]authn.Authenticator{CredentialTypeGCP: operator},
+			req: &Request{CSR: createCSR(t, []string{"spiffe://cluster.local/ns/vm/anything"}, nil),
+				Credential: "operator-token"},
+			expectedCode: codes.PermissionDenied,
+		},
+		"Extra DNS name": {
+			authenticators: map[string]authn.Authenticator{CredentialTypeGCP: gcp},
+			req:            &Request{CSR: createCSR(t, []string{id}, []string{"foo.com"}), Credential: "gcp-token"},
+			expectedCode:   codes.PermissionDenied,
+		},
+		"Authorized DNS name": {
+			authenticators: map[string]authn.Authenticator{CredentialTypeGCP: gcp},
+			dnsNames:       fakeDNSNames{"foo.com": true},
+			req:            &Request{CSR: createCSR(t, []string{id}, []string{"foo.com"}), Credential: "gcp-token"},
+			expectedCode:   codes.OK,
+		},
+		"Unauthorized DNS name": {
+			authenticators: map[string]authn.Authenticator{CredentialTypeGCP: gcp},
+			dnsNames:       fakeDNSNames{"foo.com": true},
+			req:            &Request{CSR: createCSR(t, []string{id}, []string{"bar.com"}), Credential: "gcp-token"},
+			expectedCode:   codes.PermissionDenied,
+		},
+	}
+
+	ca := testutil.NewCA(t, 30*time.Minute)
+	for name, tc := range testCases {
+		s := NewServer(ca, tc.authenticators, []string{"istio-ca"})
+		s.Delegation = delegation
+		if tc.dnsNames != nil {
+			s.DNSNames = tc.dnsNames
+		}
+		resp, err := s.Sign(tc.req)
+		if code := grpc.Code(err); code != tc.expectedCode {
+			t.Errorf("%s: unexpected code (expecting %v, actual %v: %v)", name, tc.expectedCode, code, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		cert := certmanager.ParsePemEncodedCertificate(resp.CertChain)
+		if len(cert.URIs) != 1 || cert.URIs[0].String() != id {
+			t.Errorf("%s: the certificate is issued for %v (expecting %s)", name, cert.URIs, id)
+		}
+		if string(resp.RootCert) != string(ca.GetRootCertificate()) {
+			t.Errorf("%s: unexpected root certificate in the response", name)
+		}
+		for _, r := range ca.IssuedCertificates() {
+			if r.SerialNumber.Cmp(cert.SerialNumber) == 0 && r.RequestedBy != tc.expectedRequestedBy {
+				t.Errorf("%s: the certificate is recorded as requested by %q (expecting %q)", name, r.RequestedBy,
+					tc.expectedRequestedBy)
+			}
+		}
+	}
+}
+
+func TestSignPeerDNSNames(t *testing.T) {
+	const id = "spiffe://cluster.local/ns/db/sa/etcd"
+	const delegate = "spiffe://cluster.local/ns/istio-system/sa/operator"
+	pod := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4000}
+	other := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 4000}
+	unknownPod := &net.TCPAddr{IP: net.ParseIP("10.0.0.3"), Port: 4000}
+	etcd0 := certmanager.PodIdentity{Namespace: "db", Name: "etcd-0", UID: "etcd-0-uid", NodeName: "node-1"}
+
+	testCases := map[string]struct {
+		credential       string
+		peer             net.Addr
+		expectedCode     codes.Code
+		expectedDNSNames []string
+		expectedPod      *certmanager.PodIdentity
+	}{
+		"StatefulSet pod": {
+			credential:       "pod-token",
+			peer:             pod,
+			expectedDNSNames: []string{"etcd-0.etcd.db.svc.cluster.local"},
+			expectedPod:      &etcd0,
+		},
+		"Failed pod lookup": {
+			credential:   "pod-token",
+			peer:         unknownPod,
+			expectedCode: codes.Unavailable,
+		},
+		"Unknown peer": {
+			credential: "pod-token",
+		},
+		"Delegate": {
+			credential: "operator-token",
+			peer:       pod,
+		},
+		"Failed lookup": {
+			credential:   "pod-token",
+			peer:         other,
+			expectedCode: codes.Unavailable,
+		},
+	}
+
+	ca := testutil.NewCA(t, 30*time.Minute)
+	authenticators := map[string]authn.Authenticator{
+		CredentialTypeKubernetes: testutil.FakeAuthenticator{"pod-token": id, "operator-token": delegate},
+	}
+	for name, tc := range testCases {
+		s := NewServer(ca, authenticators, []string{"istio-ca"})
+		s.Delegation = DelegationPolicy{delegate: {"spiffe://cluster.local/ns/db/*"}}
+		s.PeerDNSNames = fakePeerDNSNames{pod.String(): {"etcd-0.etcd.db.svc.cluster.local"}, unknownPod.String(): nil}
+		s.PeerPods = fakePeerPods{pod.String(): etcd0}
+		resp, err := s.Sign(&Request{CSR: createCSR(t, []string{id}, nil), Credential: tc.credential, Peer: tc.peer})
+		if code := grpc.Code(err); code != tc.expectedCode {
+			t.Errorf("%s: unexpected code (expecting %v, actual %v: %v)", name, tc.expectedCode, code, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		cert := certmanager.ParsePemEncodedCertificate(resp.CertChain)
+		if !reflect.DeepEqual(cert.DNSNames, tc.expectedDNSNames) {
+			t.Errorf("%s: unexpected DNS names %v (expecting %v)", name, cert.DNSNames, tc.expectedDNSNames)
+		}
+		if pod, err := certmanager.ParsePodIdentity(cert); err != nil || !reflect.DeepEqual(pod, tc.expectedPod) {
+			t.Errorf("%s: unexpected pod identity %v (expecting %v, error: %v)", name, pod, tc.expectedPod, err)
+		}
+	}
+}
+
+func TestBatchSign(t *testing.T) {
+	const id = "spiffe://cluster.local/ns/vm/sa/foo"
+	authenticators := map[string]authn.Authenticator{CredentialTypeGCP: testutil.FakeAuthenticator{"token": id}}
+	valid := createCSR(t, []string{id}, nil)
+	tooMany := make([][]byte, MaxBatchSize+1)
+	for i := range tooMany {
+		tooMany[i] = valid
+	}
+
+	testCases := map[string]struct {
+		req           *BatchRequest
+		expectedCode  codes.Code
+		expectedCodes []codes.Code
+	}{
+		"Per-request results": {
+			req: &BatchRequest{
+				CSRs: [][]byte{
+					valid,
+					createCSR(t, []string{"spiffe://cluster.local/ns/vm/sa/bar"}, nil),
+					[]byte("not a CSR"),
+					createCSR(t, []string{id}, nil),
+				},
+				Credential: "token",
+			},
+			expectedCode:  codes.OK,
+			expectedCodes: []codes.Code{codes.OK, codes.PermissionDenied, codes.InvalidArgument, codes.OK},
+		},
+		"Invalid credential": {
+			req:          &BatchRequest{CSRs: [][]byte{valid}, Credential: "other token"},
+			expectedCode: codes.Unauthenticated,
+		},
+		"Empty batch": {
+			req:          &BatchRequest{Credential: "token"},
+			expectedCode: codes.InvalidArgument,
+		},
+		"Too large batch": {
+			req:          &BatchRequest{CSRs: tooMany, Credential: "token"},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	s := NewServer(testutil.NewCA(t, 30*time.Minute), authenticators, []string{"istio-ca"})
+	for name, tc := range testCases {
+		resp, err := s.BatchSign(tc.req)
+		if code := grpc.Code(err); code != tc.expectedCode {
+			t.Errorf("%s: unexpected code (expecting %v, actual %v: %v)", name, tc.expectedCode, code, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if len(resp.Results) != len(tc.expectedCodes) {
+			t.Errorf("%s: unexpected number of results %d (expecting %d)", name, len(resp.Results),
+				len(tc.expectedCodes))
+			continue
+		}
+		for i, r := range resp.Results {
+			if code := grpc.Code(r.Err); code != tc.expectedCodes[i] {
+				t.Errorf("%s: unexpected code of request %d (expecting %v, actual %v: %v)", name, i,
+					tc.expectedCodes[i], code, r.Err)
+			}
+			if (r.Err == nil) != (len(r.CertChain) > 0) {
+				t.Errorf("%s: request %d should have a certificate chain if and only if it is signed", name, i)
+			}
+		}
+	}
+}
+
+func TestGetCertificate(t *testing.T) {
+	s := NewServer(testutil.NewCA(t, 30*time.Minute), nil, []string{"istio-ca", "10.0.0.1"})
+	cert, err := s.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("Failed to get the TLS certificate: %v", err)
+	}
+	if len(cert.Leaf.DNSNames) != 1 || cert.Leaf.DNSNames[0] != "istio-ca" {
+		t.Errorf("Unexpected DNS names %v", cert.Leaf.DNSNames)
+	}
+	if len(cert.Leaf.IPAddresses) != 1 || cert.Leaf.IPAddresses[0].String() != "10.0.0.1" {
+		t.Errorf("Unexpected IP addresses %v", cert.Leaf.IPAddresses)
+	}
+
+	if again, _ := s.GetCertificate(nil); again != cert {
+		t.Error("The TLS certificate should be reused until half of its lifetime has passed")
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	rbac "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+
+	"istio.io/auth/pkg/spiffe"
+)
+
+// DNSNameAuthorizer decides the DNS names that the certificate of an
+// identity may carry in addition to its SPIFFE URI.
+type DNSNameAuthorizer interface {
+	// AuthorizeDNSNames returns an error unless the certificate of the
+	// identity may carry the DNS names.
+	AuthorizeDNSNames(id string, dnsNames []string) error
+}
+
+// DNSNameProvider decides the DNS names that the server adds to the
+// certificate of a caller, which it need not ask for.
+type DNSNameProvider interface {
+	// ProvideDNSNames returns the DNS names to add to the certificate of the
+	// identity, requested from the address.
+	ProvideDNSNames(id string, addr net.Addr) ([]string, error)
+}
+
+// StatefulSetDNSNames authorizes and provides the stable DNS names of
+// StatefulSet pods, `<pod>.<service>.<namespace>.svc.<cluster domain>`,
+// which peers of clustered databases such as etcd or ZooKeeper verify on TLS.
+// A name is authorized for the identity of the service account that the pod
+// runs as, if the pod is controlled by a StatefulSet and its hostname and
+// subdomain form the name. It is provided to such a pod, found by the IP
+// address of the caller.
+type StatefulSetDNSNames struct {
+	pods          corev1.PodsGetter
+	clusterDomain string
+}
+
+// NewStatefulSetDNSNames returns a pointer to a StatefulSetDNSNames looking
+// the pods up with the client, for the names in the cluster domain, e.g.
+// "cluster.local".
+func NewStatefulSetDNSNames(pods corev1.PodsGetter, clusterDomain string) *StatefulSetDNSNames {
+	return &StatefulSetDNSNames{pods: pods, clusterDomain: clusterDomain}
+}
+
+// AuthorizeDNSNames implements DNSNameAuthorizer.
+func (a *StatefulSetDNSNames) AuthorizeDNSNames(id string, dnsNames []string) error {
+	namespace, serviceAccount, err := spiffe.ParseIstioID(id)
+	if err != nil {
+		return fmt.Errorf("%s is not the identity of a service account (error: %v)", id, err)
+	}
+
+	suffix := ".svc." + a.clusterDomain
+	for _, name := range dnsNames {
+		labels := strings.Split(strings.TrimSuffix(name, suffix), ".")
+		if !strings.HasSuffix(name, suffix) || len(labels) != 3 || labels[2] != namespace {
+			return fmt.Errorf("%s is not the stable DNS name of a pod in namespace %s", name, namespace)
+		}
+		if err := a.checkPod(namespace, labels[0], labels[1], serviceAccount); err != nil {
+			return fmt.Errorf("%s may not carry the DNS name %s (error: %v)", id, name, err)
+		}
+	}
+	return nil
+}
+
+// ProvideDNSNames implements DNSNameProvider. It returns the stable DNS name
+// of the running StatefulSet pod at the IP address, if it runs as the service
+// account of the identity, or none if there is no such pod.
+func (a *StatefulSetDNSNames) ProvideDNSNames(id string, addr net.Addr) ([]string, error) {
+	namespace, serviceAccount, err := spiffe.ParseIstioID(id)
+	if err != nil {
+		return nil, nil
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return nil, nil
+	}
+	pods, err := runningPodsAt(a.pods, namespace, tcpAddr.IP)
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods {
+		if pod.Spec.Hostname == "" || pod.Spec.Subdomain == "" ||
+			verifyPod(pod, pod.Spec.Hostname, pod.Spec.Subdomain, serviceAccount) != nil {
+			continue
+		}
+		return []string{fmt.Sprintf("%s.%s.%s.svc.%s", pod.Spec.Hostname, pod.Spec.Subdomain, namespace,
+			a.clusterDomain)}, nil
+	}
+	return nil, nil
+}
+
+// runningPodsAt returns the pods of the namespace at the IP address that are
+// running and not being deleted. Pods that have terminated or are being
+// deleted are skipped, since their IP address may already be reused by
+// another pod.
+func runningPodsAt(pods corev1.PodsGetter, namespace string, addr net.IP) ([]*v1.Pod, error) {
+	ip := addr.String()
+	list, err := pods.Pods(namespace).List(metav1.ListOptions{FieldSelector: "status.podIP=" + ip})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the pod at %s (error: %v)", ip, err)
+	}
+	var running []*v1.Pod
+	for i := range list.Items {
+		pod := &list.Items[i]
+		if pod.Status.PodIP == ip && pod.Status.Phase == v1.PodRunning && pod.DeletionTimestamp == nil {
+			running = append(running, pod)
+		}
+	}
+	return running, nil
+}
+
+func (a *StatefulSetDNSNames) checkPod(namespace, name, subdomain, serviceAccount string) error {
+	pod, err := a.pods.Pods(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	return verifyPod(pod, name, subdomain, serviceAccount)
+}
+
+// verifyPod returns an error unless the pod runs as the service account, is
+// controlled by a StatefulSet, and has the hostname and the subdomain.
+func verifyPod(pod *v1.Pod, name, subdomain, serviceAccount string) error {
+	if pod.Spec.ServiceAccountName != serviceAccount {
+		return fmt.Errorf("pod %s runs as service account %q", name, pod.Spec.ServiceAccountName)
+	}
+	if pod.Spec.Hostname != name || pod.Spec.Subdomain != subdomain {
+		return fmt.Errorf("pod %s has hostname %q and subdomain %q", name, pod.Spec.Hostname, pod.Spec.Subdomain)
+	}
+	for _, ref := range pod.GetOwnerReferences() {
+		if ref.Controller != nil && *ref.Controller && ref.Kind == "StatefulSet" {
+			return nil
+		}
+	}
+	return fmt.Errorf("pod %s is not controlled by a StatefulSet", name)
+}
+
+// StatefulSetDNSNamesRules returns the cluster-wide RBAC rules needed by a
+// StatefulSetDNSNames.
+func StatefulSetDNSNamesRules() []rbac.PolicyRule {
+	return []rbac.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"pods"},
+			Verbs:     []string{"get", "list"},
+		},
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func createStatefulSetPod(name, namespace, serviceAccount, subdomain, owner string) *v1.Pod {
+	controller := true
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       namespace,
+			OwnerReferences: []metav1.OwnerReference{{Kind: owner, Name: subdomain, Controller: &controller}},
+		},
+		Spec: v1.PodSpec{
+			Hostname:           name,
+			ServiceAccountName: serviceAccount,
+			Subdomain:          subdomain,
+		},
+	}
+}
+
+func TestStatefulSetDNSNames(t *testing.T) {
+	const id = "spiffe://cluster.local/ns/db/sa/etcd"
+	client := fake.NewSimpleClientset(
+		createStatefulSetPod("etcd-0", "db", "etcd", "etcd", "StatefulSet"),
+		createStatefulSetPod("etcd-1", "db", "etcd", "etcd", "StatefulSet"),
+		createStatefulSetPod("zk-0", "db", "zookeeper", "zk", "StatefulSet"),
+		createStatefulSetPod("web-0", "db", "etcd", "web", "ReplicaSet"),
+	)
+
+	testCases := map[string]struct {
+		id            string
+		dnsNames      []string
+		expectedError bool
+	}{
+		"Stable DNS names of StatefulSet pods": {
+			id:       id,
+			dnsNames: []string{"etcd-0.etcd.db.svc.cluster.local", "etcd-1.etcd.db.svc.cluster.local"},
+		},
+		"Pod of another service account": {
+			id:            id,
+			dnsNames:      []string{"zk-0.zk.db.svc.cluster.local"},
+			expectedError: true,
+		},
+		"Pod not controlled by a StatefulSet": {
+			id:            id,
+			dnsNames:      []string{"web-0.web.db.svc.cluster.local"},
+			expectedError: true,
+		},
+		"Wrong subdomain": {
+			id:            id,
+			dnsNames:      []string{"etcd-0.other.db.svc.cluster.local"},
+			expectedError: true,
+		},
+		"Unknown pod": {
+			id:            id,
+			dnsNames:      []string{"etcd-2.etcd.db.svc.cluster.local"},
+			expectedError: true,
+		},
+		"Other namespace": {
+			id:            id,
+			dnsNames:      []string{"etcd-0.etcd.prod.svc.cluster.local"},
+			expectedError: true,
+		},
+		"Other cluster domain": {
+			id:            id,
+			dnsNames:      []string{"etcd-0.etcd.db.svc.example.com"},
+			expectedError: true,
+		},
+		"Not a pod DNS name": {
+			id:            id,
+			dnsNames:      []string{"etcd.db.svc.cluster.local"},
+			expectedError: true,
+		},
+		"Not a service account identity": {
+			id:            "spiffe://cluster.local/ns/db",
+			dnsNames:      []string{"etcd-0.etcd.db.svc.cluster.local"},
+			expectedError: true,
+		},
+	}
+
+	a := NewStatefulSetDNSNames(client.CoreV1(), "cluster.local")
+	for name, tc := range testCases {
+		err := a.AuthorizeDNSNames(tc.id, tc.dnsNames)
+		if (err != nil) != tc.expectedError {
+			t.Errorf("%s: unexpected error (expecting an error: %v, actual %v)", name, tc.expectedError, err)
+		}
+	}
+}
+
+func TestStatefulSetDNSNamesClusterDomain(t *testing.T) {
+	const id = "spiffe://cluster.local/ns/db/sa/etcd"
+	client := fake.NewSimpleClientset(createStatefulSetPod("etcd-0", "db", "etcd", "etcd", "StatefulSet"))
+
+	a := NewStatefulSetDNSNames(client.CoreV1(), "example.com")
+	if err := a.AuthorizeDNSNames(id, []string{"etcd-0.etcd.db.svc.example.com"}); err != nil {
+		t.Errorf("The DNS name in the cluster domain should be authorized: %v", err)
+	}
+	if err := a.AuthorizeDNSNames(id, []string{"etcd-0.etcd.db.svc.cluster.local"}); err == nil {
+		t.Error("The DNS name outside the cluster domain should not be authorized")
+	}
+}
+
+func TestProvideDNSNames(t *testing.T) {
+	const id = "spiffe://cluster.local/ns/db/sa/etcd"
+	pods := []*v1.Pod{
+		createStatefulSetPod("etcd-0", "db", "etcd", "etcd", "StatefulSet"),
+		createStatefulSetPod("zk-0", "db", "zookeeper", "zk", "StatefulSet"),
+		createStatefulSetPod("web-0", "db", "etcd", "web", "ReplicaSet"),
+		createStatefulSetPod("etcd-1", "db", "etcd", "etcd", "StatefulSet"),
+		createStatefulSetPod("etcd-2", "db", "etcd", "etcd", "StatefulSet"),
+		createStatefulSetPod("etcd-3", "db", "etcd", "etcd", "StatefulSet"),
+	}
+	for i, pod := range pods {
+		pod.Status.PodIP = fmt.Sprintf("10.0.0.%d", i+1)
+		pod.Status.Phase = v1.PodRunning
+	}
+	pods[3].Status.Phase = v1.PodPending
+	deleted := metav1.Now()
+	pods[4].DeletionTimestamp = &deleted
+	// A terminated pod whose IP address was reused by the pod of another
+	// service account.
+	pods[5].Status.Phase = v1.PodSucceeded
+	pods[5].Status.PodIP = pods[1].Status.PodIP
+	client := fake.NewSimpleClientset(pods[0], pods[1], pods[2], pods[3], pods[4], pods[5])
+
+	testCases := map[string]struct {
+		id       string
+		addr     net.Addr
+		expected []string
+	}{
+		"StatefulSet pod": {
+			id:       id,
+			addr:     &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4000},
+			expected: []string{"etcd-0.etcd.db.svc.example.com"},
+		},
+		"Pod of another service account": {
+			id:   id,
+			addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 4000},
+		},
+		"Pod not controlled by a StatefulSet": {
+			id:   id,
+			addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.3"), Port: 4000},
+		},
+		"Pending pod": {
+			id:   id,
+			addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.4"), Port: 4000},
+		},
+		"Pod being deleted": {
+			id:   id,
+			addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 4000},
+		},
+		"Unknown address": {
+			id:   id,
+			addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.9"), Port: 4000},
+		},
+		"Not a service account identity": {
+			id:   "spiffe://cluster.local/ns/db",
+			addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4000},
+		},
+	}
+
+	a := NewStatefulSetDNSNames(client.CoreV1(), "example.com")
+	for name, tc := range testCases {
+		names, err := a.ProvideDNSNames(tc.id, tc.addr)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+		if !reflect.DeepEqual(names, tc.expected) {
+			t.Errorf("%s: unexpected DNS names %v (expecting %v)", name, names, tc.expected)
+		}
+	}
+}
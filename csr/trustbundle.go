@@ -0,0 +1,54 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+)
+
+// The interval between the checks of the trust bundle of the CA for changes
+// while a watch is open. The checks are in-process, so that watchers are
+// pushed the changes instead of polling the CA themselves.
+const trustBundlePollInterval = 5 * time.Second
+
+// WatchTrustBundle sends the trust bundle of the CA, first the current one
+// and then every change, until ctx is done or sending fails.
+func (s *Server) WatchTrustBundle(ctx context.Context, send func(bundle []byte) error) error {
+	ticker := time.NewTicker(s.trustBundlePollInterval)
+	defer ticker.Stop()
+
+	var sent []byte
+	for {
+		if bundle := s.ca.GetRootCertificate(); !bytes.Equal(bundle, sent) {
+			if err := send(bundle); err != nil {
+				return err
+			}
+			if sent != nil {
+				glog.Infof("Pushed a changed trust bundle to a watcher")
+			}
+			sent = bundle
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"istio.io/auth/certmanager"
+)
+
+// bundleSigner is a Signer whose trust bundle can be changed.
+type bundleSigner struct {
+	mutex  sync.Mutex
+	bundle []byte
+}
+
+func (s *bundleSigner) Sign(csrPEM []byte) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *bundleSigner) SignOnBehalf(csrPEM []byte, requester string, dnsNames ...string) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *bundleSigner) SignForPod(csrPEM []byte, pod certmanager.PodIdentity, dnsNames ...string) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *bundleSigner) GetRootCertificate() []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.bundle
+}
+
+func (s *bundleSigner) setBundle(bundle string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.bundle = []byte(bundle)
+}
+
+func TestWatchTrustBundle(t *testing.T) {
+	ca := &bundleSigner{bundle: []byte("old root")}
+	s := NewServer(ca, nil, nil)
+	s.trustBundlePollInterval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	bundles := make(chan string)
+	done := make(chan error)
+	go func() {
+		done <- s.WatchTrustBundle(ctx, func(bundle []byte) error {
+			bundles <- string(bundle)
+			return nil
+		})
+	}()
+
+	if bundle := <-bundles; bundle != "old root" {
+		t.Errorf("Unexpected initial trust bundle %q", bundle)
+	}
+	ca.setBundle("old root\nnew root")
+	if bundle := <-bundles; bundle != "old root\nnew root" {
+		t.Errorf("Unexpected changed trust bundle %q", bundle)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error after the watch is cancelled: %v", err)
+	}
+}
+
+func TestWatchTrustBundleSendFailure(t *testing.T) {
+	s := NewServer(&bundleSigner{bundle: []byte("root")}, nil, nil)
+	sendErr := errors.New("stream closed")
+	err := s.WatchTrustBundle(context.Background(), func([]byte) error {
+		return sendErr
+	})
+	if err != sendErr {
+		t.Errorf("Unexpected error (expecting %v, actual %v)", sendErr, err)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+
+	"istio.io/auth/api/csr/v1alpha1"
+	"istio.io/auth/pkg/requestid"
+)
+
+// v1alpha1Service serves the v1alpha1 CSR API with the Server.
+type v1alpha1Service struct {
+	s *Server
+}
+
+// Sign implements v1alpha1.CertificateServiceServer.
+func (v *v1alpha1Service) Sign(ctx context.Context, req *v1alpha1.SignRequest) (*v1alpha1.SignResponse, error) {
+	id, rid, err := v.caller(ctx, req.Credential, req.CredentialType)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.s.signAs(id, rid, req.CsrPem, peerAddr(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &v1alpha1.SignResponse{
+		CertChain:  resp.CertChain,
+		RootCert:   resp.RootCert,
+		RenewAfter: unixSeconds(resp.RenewAfter),
+	}, nil
+}
+
+// BatchSign implements v1alpha1.CertificateServiceServer.
+func (v *v1alpha1Service) BatchSign(ctx context.Context,
+	req *v1alpha1.BatchSignRequest) (*v1alpha1.BatchSignResponse, error) {
+
+	id, rid, err := v.caller(ctx, req.Credential, req.CredentialType)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.s.batchSignAs(id, rid, &BatchRequest{
+		CSRs:           req.CsrPems,
+		Credential:     req.Credential,
+		CredentialType: req.CredentialType,
+		Peer:           peerAddr(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*v1alpha1.SignResult, len(resp.Results))
+	for i, r := range resp.Results {
+		results[i] = &v1alpha1.SignResult{CertChain: r.CertChain, RenewAfter: unixSeconds(r.RenewAfter)}
+		if r.Err != nil {
+			results[i].Code = int32(grpc.Code(r.Err))
+			results[i].Message = grpc.ErrorDesc(r.Err)
+			results[i].ErrorCode = ErrorCode(r.Err)
+		}
+	}
+	return &v1alpha1.BatchSignResponse{Results: results, RootCert: resp.RootCert}, nil
+}
+
+// caller returns the identity of the caller authenticated by the
+// interceptors and the ID of the request, or authenticates the credential if
+// the service is registered with a gRPC server without them.
+func (v *v1alpha1Service) caller(ctx context.Context, credential, credentialType string) (
+	id, requestID string, err error) {
+
+	requestID = requestid.FromContext(ctx)
+	if requestID == "" {
+		requestID = requestid.New()
+	}
+	if id, ok := callerFromContext(ctx); ok {
+		return id, requestID, nil
+	}
+	id, err = v.s.authenticate(credential, credentialType, requestID)
+	return id, requestID, err
+}
+
+// peerAddr returns the address of the caller, or nil if it is unknown.
+func peerAddr(ctx context.Context) net.Addr {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr
+	}
+	return nil
+}
+
+// GetNonce implements v1alpha1.CertificateServiceServer.
+func (v *v1alpha1Service) GetNonce(ctx context.Context, req *v1alpha1.GetNonceRequest) (
+	*v1alpha1.GetNonceResponse, error) {
+
+	nonce, err := v.s.NewNonce()
+	if err != nil {
+		return nil, err
+	}
+	return &v1alpha1.GetNonceResponse{Nonce: nonce}, nil
+}
+
+// WatchTrustBundle implements v1alpha1.CertificateServiceServer.
+func (v *v1alpha1Service) WatchTrustBundle(req *v1alpha1.WatchTrustBundleRequest,
+	stream v1alpha1.CertificateService_WatchTrustBundleServer) error {
+
+	return v.s.WatchTrustBundle(stream.Context(), func(bundle []byte) error {
+		return stream.Send(&v1alpha1.TrustBundle{RootCert: bundle})
+	})
+}
+
+// unixSeconds returns the time in Unix seconds, or zero for the zero time.
+func unixSeconds(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
@@ -0,0 +1,193 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csr
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+
+	"istio.io/auth/api/csr/v1alpha1"
+	"istio.io/auth/authn"
+	"istio.io/auth/certmanager/testutil"
+	"istio.io/auth/parse"
+)
+
+// dialTestServer serves the CSR API of the server on a local port, and
+// returns a v1alpha1 client of it.
+func dialTestServer(t *testing.T, s *Server, ca Signer) (v1alpha1.CertificateServiceClient, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(lis) // nolint: errcheck
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.GetRootCertificate())
+	creds := credentials.NewTLS(&tls.Config{RootCAs: roots, ServerName: "istio-ca"})
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(creds))
+	if err != nil {
+		t.Fatalf("Failed to dial the CSR API: %v", err)
+	}
+	return v1alpha1.NewCertificateServiceClient(conn), func() {
+		conn.Close() // nolint: errcheck
+		lis.Close()  // nolint: errcheck
+	}
+}
+
+func TestV1alpha1(t *testing.T) {
+	const id = "spiffe://cluster.local/ns/vm/sa/foo"
+	ca := testutil.NewCA(t, 30*time.Minute)
+	s := NewServer(ca, map[string]authn.Authenticator{CredentialTypeGCP: testutil.FakeAuthenticator{"token": id}},
+		[]string{"istio-ca"})
+	client, closeClient := dialTestServer(t, s, ca)
+	defer closeClient()
+
+	resp, err := client.Sign(context.Background(), &v1alpha1.SignRequest{
+		CsrPem:     createCSR(t, []string{id}, nil),
+		Credential: "token",
+	})
+	if err != nil {
+		t.Fatalf("Failed to sign the certificate signing request: %v", err)
+	}
+	if len(resp.CertChain) == 0 || string(resp.RootCert) != string(ca.GetRootCertificate()) {
+		t.Errorf("Unexpected response %v", resp)
+	}
+	certs, err := parse.CertificateChain(resp.CertChain)
+	if err != nil {
+		t.Fatalf("Failed to parse the certificate chain: %v", err)
+	}
+	if resp.RenewAfter <= certs[0].NotBefore.Unix() || resp.RenewAfter >= certs[0].NotAfter.Unix() {
+		t.Errorf("Renewal time %d is not within the lifetime of the certificate [%v, %v]",
+			resp.RenewAfter, certs[0].NotBefore, certs[0].NotAfter)
+	}
+}
+
+func TestV1alpha1BatchSign(t *testing.T) {
+	const id = "spiffe://cluster.local/ns/vm/sa/foo"
+	ca := testutil.NewCA(t, 30*time.Minute)
+	s := NewServer(ca, map[string]authn.Authenticator{CredentialTypeGCP: testutil.FakeAuthenticator{"token": id}},
+		[]string{"istio-ca"})
+	client, closeClient := dialTestServer(t, s, ca)
+	defer closeClient()
+
+	resp, err := client.BatchSign(context.Background(), &v1alpha1.BatchSignRequest{
+		CsrPems:    [][]byte{createCSR(t, []string{id}, nil), []byte("not a CSR")},
+		Credential: "token",
+	})
+	if err != nil {
+		t.Fatalf("Failed to sign the batch: %v", err)
+	}
+	if len(resp.Results) != 2 {
+		t.Fatalf("Unexpected number of results %d (expecting 2)", len(resp.Results))
+	}
+	if r := resp.Results[0]; codes.Code(r.Code) != codes.OK || len(r.CertChain) == 0 || r.RenewAfter == 0 {
+		t.Errorf("The first request should be signed, but got %v", r)
+	}
+	if r := resp.Results[1]; codes.Code(r.Code) != codes.InvalidArgument || r.Message == "" ||
+		r.ErrorCode != v1alpha1.ErrorCode_INVALID_CSR || r.RenewAfter != 0 {
+		t.Errorf("The second request should be invalid, but got %v", r)
+	}
+	if string(resp.RootCert) != string(ca.GetRootCertificate()) {
+		t.Errorf("Unexpected root certificate %q", resp.RootCert)
+	}
+}
+
+func TestV1alpha1GetNonce(t *testing.T) {
+	const id = "spiffe://cluster.local/ns/vm/sa/foo"
+	ca := testutil.NewCA(t, 30*time.Minute)
+	s := NewServer(ca, map[string]authn.Authenticator{CredentialTypeGCP: testutil.FakeAuthenticator{"token": id}},
+		[]string{"istio-ca"})
+	s.RequireNonce = true
+	client, closeClient := dialTestServer(t, s, ca)
+	defer closeClient()
+
+	resp, err := client.GetNonce(context.Background(), &v1alpha1.GetNonceRequest{})
+	if err != nil {
+		t.Fatalf("Failed to get a nonce: %v", err)
+	}
+	ext, err := NonceExtension(resp.Nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrPEM := createCSRWithExtensions(t, []string{id}, nil, []pkix.Extension{ext})
+	req := &v1alpha1.SignRequest{CsrPem: csrPEM, Credential: "token"}
+	if _, err := client.Sign(context.Background(), req); err != nil {
+		t.Errorf("Failed to sign the certificate signing request with the nonce: %v", err)
+	}
+}
+
+func TestV1alpha1WatchTrustBundle(t *testing.T) {
+	ca := testutil.NewCA(t, 30*time.Minute)
+	s := NewServer(ca, nil, []string{"istio-ca"})
+	s.trustBundlePollInterval = 10 * time.Millisecond
+	client, closeClient := dialTestServer(t, s, ca)
+	defer closeClient()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.WatchTrustBundle(ctx, &v1alpha1.WatchTrustBundleRequest{})
+	if err != nil {
+		t.Fatalf("Failed to watch the trust bundle: %v", err)
+	}
+
+	bundle, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Failed to receive the trust bundle: %v", err)
+	}
+	if string(bundle.RootCert) != string(ca.GetRootCertificate()) {
+		t.Errorf("Unexpected initial trust bundle %q", bundle.RootCert)
+	}
+
+	if err := ca.RotateRoot(); err != nil {
+		t.Fatalf("Failed to rotate the root certificate: %v", err)
+	}
+	if bundle, err = stream.Recv(); err != nil {
+		t.Fatalf("Failed to receive the rotated trust bundle: %v", err)
+	}
+	if string(bundle.RootCert) != string(ca.GetRootCertificate()) {
+		t.Errorf("Unexpected trust bundle after the root rotation %q", bundle.RootCert)
+	}
+}
+
+// TestV1alpha1UnknownFields checks that requests sent with fields added to
+// v1alpha1 after this server was built are still accepted.
+func TestV1alpha1UnknownFields(t *testing.T) {
+	req := &v1alpha1.SignRequest{CsrPem: []byte("csr"), Credential: "token", CredentialType: CredentialTypeGCP}
+	bs, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Field 15, a length-delimited profile name.
+	bs = append(bs, append(proto.EncodeVarint(15<<3|2), append(proto.EncodeVarint(6), "strict"...)...)...)
+
+	decoded := &v1alpha1.SignRequest{}
+	if err := proto.Unmarshal(bs, decoded); err != nil {
+		t.Fatalf("Failed to decode a request with an unknown field: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, req) {
+		t.Errorf("Unexpected request decoded (expecting %v, actual %v)", req, decoded)
+	}
+}
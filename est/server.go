@@ -0,0 +1,284 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package est serves the Enrollment over Secure Transport protocol of RFC
+// 7030 for Istio CA, so that network devices and embedded systems that speak
+// EST can get certificates of the mesh. Only the mandatory operations are
+// served: /cacerts, /simpleenroll and /simplereenroll.
+package est
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"istio.io/auth/csr"
+	"istio.io/auth/parse"
+	"istio.io/auth/pkg/pkcs7"
+)
+
+const (
+	// The path prefix of the EST operations (RFC 7030, section 3.2.2).
+	pathPrefix = "/.well-known/est/"
+
+	pkcs7ContentType  = "application/pkcs7-mime"
+	pkcs10ContentType = "application/pkcs10"
+
+	// The realm of the HTTP basic authentication challenge.
+	realm = "istio-ca"
+)
+
+// CSRServer authenticates and signs certificate signing requests like the CSR API.
+type CSRServer interface {
+	Sign(req *csr.Request) (*csr.Response, error)
+	GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// Server serves EST on top of the CSR API. Initial enrollments authenticate
+// with HTTP basic authentication, where the username is the credential type
+// of the CSR API, which may be empty if the API accepts a single type, and
+// the password is the credential. Re-enrollments authenticate with the TLS
+// client certificate being renewed instead. Either way, the certificate
+// signing request must ask for a single identity URI, like on the CSR API.
+type Server struct {
+	csr CSRServer
+	ca  csr.Signer
+}
+
+// NewServer returns a pointer to a Server enrolling with the CSR API server
+// and re-enrolling the certificates of the CA, whose TLS certificate is
+// also the one of the CSR API server.
+func NewServer(csrServer CSRServer, ca csr.Signer) *Server {
+	return &Server{csr: csrServer, ca: ca}
+}
+
+// Run serves EST over TLS on the address until an error occurs.
+func (s *Server) Run(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	glog.Infof("Serving EST on %s", addr)
+	return s.Serve(lis)
+}
+
+// Serve serves EST over TLS on the listener until an error occurs.
+func (s *Server) Serve(lis net.Listener) error {
+	server := &http.Server{
+		Handler: s.Handler(),
+		TLSConfig: &tls.Config{
+			GetCertificate: s.csr.GetCertificate,
+			// The client certificate is verified by simplereenroll against the current roots.
+			ClientAuth: tls.RequestClientCert,
+		},
+	}
+	return server.Serve(tls.NewListener(lis, server.TLSConfig))
+}
+
+// Handler returns the handler of the EST operations.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(pathPrefix+"cacerts", s.caCerts)
+	mux.HandleFunc(pathPrefix+"simpleenroll", s.simpleEnroll)
+	mux.HandleFunc(pathPrefix+"simplereenroll", s.simpleReenroll)
+	return mux
+}
+
+// caCerts answers the root certificates of the CA.
+func (s *Server) caCerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeCertificates(w, "", s.ca.GetRootCertificate())
+}
+
+// simpleEnroll signs the certificate signing request with the CSR API,
+// authenticated by the credential in the HTTP basic authentication.
+func (s *Server) simpleEnroll(w http.ResponseWriter, r *http.Request) {
+	csrPEM, ok := readCSR(w, r)
+	if !ok {
+		return
+	}
+	credentialType, credential, ok := r.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
+		http.Error(w, "an EST enrollment must authenticate with a credential of the CSR API", http.StatusUnauthorized)
+		return
+	}
+
+	resp, err := s.csr.Sign(&csr.Request{CSR: csrPEM, Credential: credential, CredentialType: credentialType})
+	if err != nil {
+		status := httpStatus(grpc.Code(err))
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
+		}
+		http.Error(w, grpc.ErrorDesc(err), status)
+		return
+	}
+	writeCertificates(w, "certs-only", resp.CertChain)
+}
+
+// simpleReenroll renews the TLS client certificate, if it is issued by the CA
+// and the certificate signing request asks for the same subject alternative
+// names (RFC 7030, section 4.2.2). The subject is not compared, since the CA
+// sets it regardless of the request.
+func (s *Server) simpleReenroll(w http.ResponseWriter, r *http.Request) {
+	csrPEM, ok := readCSR(w, r)
+	if !ok {
+		return
+	}
+	cert, err := s.verifyClientCertificate(r)
+	if err != nil {
+		glog.Warningf("Refused an EST re-enrollment (error: %v)", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	req, err := parse.CSR(csrPEM)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid certificate signing request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !sameSubjectAltNames(req, cert) {
+		glog.Warningf("Refused an EST re-enrollment of %v, which asks for a different identity", cert.URIs)
+		http.Error(w, "the certificate signing request must ask for the subject alternative names of the "+
+			"certificate being renewed", http.StatusForbidden)
+		return
+	}
+
+	chain, err := s.ca.Sign(csrPEM)
+	if err != nil {
+		glog.Errorf("Failed to sign an EST re-enrollment of %v (error: %v)", cert.URIs, err)
+		http.Error(w, "failed to sign the certificate signing request", http.StatusInternalServerError)
+		return
+	}
+	glog.Infof("Re-enrolled a certificate for %v with EST", cert.URIs)
+	writeCertificates(w, "certs-only", chain)
+}
+
+// verifyClientCertificate returns the TLS client certificate if it chains to
+// the current root certificates of the CA.
+func (s *Server) verifyClientCertificate(r *http.Request) (*x509.Certificate, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("an EST re-enrollment must authenticate with the certificate being renewed")
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(s.ca.GetRootCertificate()) {
+		return nil, fmt.Errorf("the CA has no root certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	cert := r.TLS.PeerCertificates[0]
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Intermediates: intermediates,
+		Roots:         roots,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return nil, fmt.Errorf("the client certificate is not issued by the CA (error: %v)", err)
+	}
+	return cert, nil
+}
+
+// readCSR returns the PEM encoding of the base64-encoded DER certificate
+// signing request in the body of the POST request, or answers the error.
+func readCSR(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, pkcs10ContentType) {
+		http.Error(w, fmt.Sprintf("unsupported content type %q (expecting %s)", ct, pkcs10ContentType),
+			http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, parse.MaxCSRSize))
+	if err != nil {
+		http.Error(w, "the certificate signing request is too large", http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	// The base64 encoding may be folded into lines.
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil {
+		http.Error(w, "the certificate signing request is not base64-encoded", http.StatusBadRequest)
+		return nil, false
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), true
+}
+
+// writeCertificates answers the PEM-encoded certificates as a base64-encoded
+// certificates-only PKCS#7 of the S/MIME type, if any.
+func writeCertificates(w http.ResponseWriter, smimeType string, certsPEM []byte) {
+	var certs [][]byte
+	for rest := certsPEM; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		certs = append(certs, block.Bytes)
+	}
+	der, err := pkcs7.CertsOnly(certs)
+	if err != nil {
+		glog.Errorf("Failed to encode the certificates of an EST response (error: %v)", err)
+		http.Error(w, "failed to encode the certificates", http.StatusInternalServerError)
+		return
+	}
+
+	contentType := pkcs7ContentType
+	if smimeType != "" {
+		contentType += "; smime-type=" + smimeType
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	w.Write([]byte(base64.StdEncoding.EncodeToString(der))) // nolint: errcheck
+}
+
+// sameSubjectAltNames returns true if the certificate signing request asks for
+// the subject alternative names of the certificate.
+func sameSubjectAltNames(req *x509.CertificateRequest, cert *x509.Certificate) bool {
+	return reflect.DeepEqual(req.DNSNames, cert.DNSNames) &&
+		reflect.DeepEqual(req.EmailAddresses, cert.EmailAddresses) &&
+		reflect.DeepEqual(req.IPAddresses, cert.IPAddresses) &&
+		reflect.DeepEqual(req.URIs, cert.URIs)
+}
+
+// httpStatus maps the gRPC code of a CSR API error to an HTTP status.
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
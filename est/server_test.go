@@ -0,0 +1,266 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package est
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/auth/authn"
+	"istio.io/auth/certmanager"
+	"istio.io/auth/certmanager/testutil"
+	"istio.io/auth/csr"
+	"istio.io/auth/pkg/pkcs7"
+)
+
+const testID = "spiffe://cluster.local/ns/default/sa/device"
+
+// createCSR returns the DER-encoded certificate signing request for the identity.
+func createCSR(t *testing.T, id string) []byte {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{URIs: []*url.URL{u}}, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// signTestCSR returns the PEM-encoded certificate chain the CA issues for the identity.
+func signTestCSR(t *testing.T, ca *certmanager.IstioCA, id string) []byte {
+	chain, err := ca.Sign(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: createCSR(t, id)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+func parseCertificates(t *testing.T, certsPEM []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for rest := certsPEM; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			return certs
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// parseResponse returns the certificates of a base64-encoded certificates-only PKCS#7.
+func parseResponse(t *testing.T, body string) []*x509.Certificate {
+	der, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		t.Fatalf("The response is not base64-encoded: %v", err)
+	}
+	certs, err := pkcs7.ParseCertsOnly(der)
+	if err != nil {
+		t.Fatalf("The response is not a certificates-only PKCS#7: %v", err)
+	}
+	return certs
+}
+
+func newTestServer(t *testing.T) (*Server, *certmanager.IstioCA) {
+	ca := testutil.NewCA(t, 30*time.Minute)
+	authenticators := map[string]authn.Authenticator{
+		csr.CredentialTypeKubernetes: testutil.FakeAuthenticator{"device-token": testID},
+	}
+	return NewServer(csr.NewServer(ca, authenticators, []string{"istio-ca"}), ca), ca
+}
+
+func TestCACerts(t *testing.T) {
+	s, ca := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/est/cacerts", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/pkcs7-mime" {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+	certs := parseResponse(t, w.Body.String())
+	root := parseCertificates(t, ca.GetRootCertificate())[0]
+	if len(certs) != 1 || !certs[0].Equal(root) {
+		t.Errorf("The response should hold the root certificate only")
+	}
+}
+
+func TestSimpleEnroll(t *testing.T) {
+	s, ca := newTestServer(t)
+	body := base64.StdEncoding.EncodeToString(createCSR(t, testID))
+
+	testCases := map[string]struct {
+		method         string
+		contentType    string
+		username       string
+		password       string
+		body           string
+		expectedStatus int
+	}{
+		"Enrolled": {
+			method:         http.MethodPost,
+			contentType:    "application/pkcs10",
+			username:       "kubernetes",
+			password:       "device-token",
+			body:           body,
+			expectedStatus: http.StatusOK,
+		},
+		"Enrolled with the only credential type": {
+			method:         http.MethodPost,
+			password:       "device-token",
+			body:           body[:64] + "\r\n" + body[64:],
+			expectedStatus: http.StatusOK,
+		},
+		"No credential": {
+			method:         http.MethodPost,
+			body:           body,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"Invalid credential": {
+			method:         http.MethodPost,
+			username:       "kubernetes",
+			password:       "other-token",
+			body:           body,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"Identity of another workload": {
+			method:         http.MethodPost,
+			username:       "kubernetes",
+			password:       "device-token",
+			body:           base64.StdEncoding.EncodeToString(createCSR(t, "spiffe://cluster.local/ns/default/sa/other")),
+			expectedStatus: http.StatusForbidden,
+		},
+		"Not base64-encoded": {
+			method:         http.MethodPost,
+			password:       "device-token",
+			body:           "not a CSR!",
+			expectedStatus: http.StatusBadRequest,
+		},
+		"Wrong content type": {
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			password:       "device-token",
+			body:           body,
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		"Wrong method": {
+			method:         http.MethodGet,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for id, tc := range testCases {
+		r := httptest.NewRequest(tc.method, "/.well-known/est/simpleenroll", strings.NewReader(tc.body))
+		if tc.contentType != "" {
+			r.Header.Set("Content-Type", tc.contentType)
+		}
+		if tc.username != "" || tc.password != "" {
+			r.SetBasicAuth(tc.username, tc.password)
+		}
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		if w.Code != tc.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d: %s", id, tc.expectedStatus, w.Code, w.Body)
+			continue
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: an unauthorized response should carry a challenge", id)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/pkcs7-mime; smime-type=certs-only" {
+			t.Errorf("%s: unexpected content type %q", id, ct)
+		}
+		certs := parseResponse(t, w.Body.String())
+		if len(certs) == 0 || len(certs[0].URIs) != 1 || certs[0].URIs[0].String() != testID {
+			t.Errorf("%s: the response should start with a certificate for %s", id, testID)
+			continue
+		}
+		if err := certs[0].CheckSignatureFrom(parseCertificates(t, ca.GetRootCertificate())[0]); err != nil {
+			t.Errorf("%s: the certificate is not issued by the CA: %v", id, err)
+		}
+	}
+}
+
+func TestSimpleReenroll(t *testing.T) {
+	s, ca := newTestServer(t)
+	cert := parseCertificates(t, signTestCSR(t, ca, testID))
+	otherCert := parseCertificates(t, signTestCSR(t, testutil.NewCA(t, 30*time.Minute), testID))
+	body := base64.StdEncoding.EncodeToString(createCSR(t, testID))
+
+	testCases := map[string]struct {
+		peerCerts      []*x509.Certificate
+		body           string
+		expectedStatus int
+	}{
+		"Re-enrolled": {
+			peerCerts:      cert,
+			body:           body,
+			expectedStatus: http.StatusOK,
+		},
+		"No client certificate": {
+			body:           body,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"Certificate of another CA": {
+			peerCerts:      otherCert,
+			body:           body,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"Different identity": {
+			peerCerts:      cert,
+			body:           base64.StdEncoding.EncodeToString(createCSR(t, "spiffe://cluster.local/ns/default/sa/other")),
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for id, tc := range testCases {
+		r := httptest.NewRequest(http.MethodPost, "/.well-known/est/simplereenroll", strings.NewReader(tc.body))
+		r.TLS = &tls.ConnectionState{PeerCertificates: tc.peerCerts}
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		if w.Code != tc.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d: %s", id, tc.expectedStatus, w.Code, w.Body)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		certs := parseResponse(t, w.Body.String())
+		if len(certs) == 0 || certs[0].Equal(cert[0]) || certs[0].URIs[0].String() != testID {
+			t.Errorf("%s: the response should start with a renewed certificate for %s", id, testID)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package export periodically exports the inventory of the certificates
+// issued by Istio CA, with their revocation status, as CSV reports for
+// compliance reporting.
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/auth/certmanager"
+)
+
+// The layout of the report timestamps in the object names.
+const nameTimeLayout = "20060102T150405Z"
+
+// The header row of the CSV reports.
+var csvHeader = []string{"serial_number", "id", "not_before", "not_after", "revoked", "revoked_at", "requested_by"}
+
+// Source lists the certificates issued by a CA.
+type Source interface {
+	IssuedCertificates() []certmanager.IssuanceRecord
+}
+
+// Exporter writes a CSV report of the unexpired certificates of a CA to a
+// sink every interval.
+type Exporter struct {
+	source      Source
+	revocations *certmanager.RevocationList
+	sink        Sink
+	interval    time.Duration
+
+	// OpenSSLDatabase indicates whether every export also replaces an OpenSSL
+	// CA database (index.txt, index.txt.attr and serial) in the sink, so that
+	// existing PKI tooling can inspect the issuance state.
+	OpenSSLDatabase bool
+}
+
+// NewExporter returns a pointer to an Exporter writing the reports to the sink.
+func NewExporter(source Source, revocations *certmanager.RevocationList, sink Sink, interval time.Duration) *Exporter {
+	return &Exporter{source: source, revocations: revocations, sink: sink, interval: interval}
+}
+
+// Run exports a report every interval until stopCh is closed.
+func (e *Exporter) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case now := <-ticker.C:
+			if err := e.Export(now); err != nil {
+				glog.Errorf("Failed to export the issuance report (error: %v)", err)
+			}
+		}
+	}
+}
+
+// Export writes the report as of now to the sink, named after the time, e.g.
+// istio-ca-issuance-20170601T120000Z.csv.
+func (e *Exporter) Export(now time.Time) error {
+	records := e.source.IssuedCertificates()
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, records, e.revocations); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("istio-ca-issuance-%s.csv", now.UTC().Format(nameTimeLayout))
+	if err := e.sink.Write(name, buf.Bytes()); err != nil {
+		return err
+	}
+	glog.Infof("Exported the issuance report %s", name)
+
+	if e.OpenSSLDatabase {
+		if err := exportOpenSSLDatabase(e.sink, records, e.revocations); err != nil {
+			return err
+		}
+		glog.Infof("Exported the OpenSSL CA database of %d certificates", len(records))
+	}
+	return nil
+}
+
+// WriteCSV writes the issuance records, with their revocation status, as CSV.
+func WriteCSV(w io.Writer, records []certmanager.IssuanceRecord, revocations *certmanager.RevocationList) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range records {
+		revokedAt, revoked := time.Time{}, false
+		if revocations != nil {
+			revokedAt, revoked = revocations.RevokedAt(r.SerialNumber)
+		}
+		row := []string{
+			fmt.Sprintf("%x", r.SerialNumber),
+			r.ID,
+			r.NotBefore.UTC().Format(time.RFC3339),
+			r.NotAfter.UTC().Format(time.RFC3339),
+			strconv.FormatBool(revoked),
+			"",
+			r.RequestedBy,
+		}
+		if revoked {
+			row[5] = revokedAt.UTC().Format(time.RFC3339)
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
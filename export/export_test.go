@@ -0,0 +1,80 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager"
+)
+
+type fakeSource []certmanager.IssuanceRecord
+
+func (s fakeSource) IssuedCertificates() []certmanager.IssuanceRecord {
+	return s
+}
+
+type memSink map[string][]byte
+
+func (s memSink) Write(name string, data []byte) error {
+	s[name] = data
+	return nil
+}
+
+func TestExport(t *testing.T) {
+	notBefore := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	source := fakeSource{
+		{SerialNumber: big.NewInt(255), ID: "spiffe://cluster.local/ns/foo/sa/bar",
+			NotBefore: notBefore, NotAfter: notBefore.Add(time.Hour)},
+		{SerialNumber: big.NewInt(16), ID: "foo.com", RequestedBy: "spiffe://cluster.local/ns/foo/sa/controller",
+			NotBefore: notBefore, NotAfter: notBefore.Add(time.Hour)},
+	}
+	revocations := certmanager.NewRevocationList()
+	revocations.Revoke(big.NewInt(16))
+
+	sink := memSink{}
+	e := NewExporter(source, revocations, sink, time.Hour)
+	if err := e.Export(notBefore); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	data, ok := sink["istio-ca-issuance-20170601T120000Z.csv"]
+	if !ok {
+		t.Fatalf("Expecting the report to be named after the export time, but got %v", sink)
+	}
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read the report: %v", err)
+	}
+	if len(rows) != 3 || !reflect.DeepEqual(rows[0], csvHeader) {
+		t.Fatalf("Unexpected report: %v", rows)
+	}
+	expected := []string{"ff", "spiffe://cluster.local/ns/foo/sa/bar",
+		"2017-06-01T12:00:00Z", "2017-06-01T13:00:00Z", "false", "", ""}
+	if !reflect.DeepEqual(rows[1], expected) {
+		t.Errorf("Expecting row %v but got %v", expected, rows[1])
+	}
+	if rows[2][0] != "10" || rows[2][4] != "true" || rows[2][5] == "" {
+		t.Errorf("Expecting the revoked certificate with its revocation time but got %v", rows[2])
+	}
+	if rows[2][6] != "spiffe://cluster.local/ns/foo/sa/controller" {
+		t.Errorf("Expecting the certificate to be requested by the delegate but got %v", rows[2])
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"istio.io/auth/certmanager"
+)
+
+// The names of the files of an OpenSSL CA database, as in the 'database' and
+// 'serial' settings of openssl-ca(1).
+const (
+	OpenSSLIndexName     = "index.txt"
+	OpenSSLIndexAttrName = "index.txt.attr"
+	OpenSSLSerialName    = "serial"
+)
+
+// The time layout of the OpenSSL CA database, which is always in UTC.
+const opensslTimeLayout = "060102150405Z"
+
+// WriteOpenSSLIndex writes the issuance records as an OpenSSL CA database
+// ('index.txt'), with one tab-separated line per certificate of its status
+// (V for valid, R for revoked), expiry, revocation time, serial number, file
+// name and subject. The certificates are identified by their SANs rather than
+// their subjects, so the subject carries the Istio identity as the common name.
+func WriteOpenSSLIndex(w io.Writer, records []certmanager.IssuanceRecord,
+	revocations *certmanager.RevocationList) error {
+
+	for _, r := range records {
+		status, revokedAt := "V", ""
+		if revocations != nil {
+			if t, revoked := revocations.RevokedAt(r.SerialNumber); revoked {
+				status, revokedAt = "R", t.UTC().Format(opensslTimeLayout)
+			}
+		}
+		_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\tunknown\t/CN=%s\n", status,
+			r.NotAfter.UTC().Format(opensslTimeLayout), revokedAt, opensslSerial(r.SerialNumber), r.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenSSLSerial returns the content of the 'serial' file of an OpenSSL CA
+// database. Istio CA issues random serial numbers, so it holds the one after
+// the largest issued serial number, which no listed certificate has.
+func OpenSSLSerial(records []certmanager.IssuanceRecord) []byte {
+	next := big.NewInt(1)
+	for _, r := range records {
+		if r.SerialNumber.Cmp(next) >= 0 {
+			next = new(big.Int).Add(r.SerialNumber, big.NewInt(1))
+		}
+	}
+	return []byte(opensslSerial(next) + "\n")
+}
+
+// opensslSerial formats a serial number as OpenSSL does, in upper case
+// hexadecimal digits of an even count.
+func opensslSerial(serial *big.Int) string {
+	s := strings.ToUpper(serial.Text(16))
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	return s
+}
+
+// exportOpenSSLDatabase writes the OpenSSL CA database of the records to the sink.
+func exportOpenSSLDatabase(sink Sink, records []certmanager.IssuanceRecord,
+	revocations *certmanager.RevocationList) error {
+
+	var buf bytes.Buffer
+	if err := WriteOpenSSLIndex(&buf, records, revocations); err != nil {
+		return err
+	}
+	// Istio identities are not unique per certificate, as certificates are rotated.
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{OpenSSLIndexName, buf.Bytes()},
+		{OpenSSLIndexAttrName, []byte("unique_subject = no\n")},
+		{OpenSSLSerialName, OpenSSLSerial(records)},
+	}
+	for _, f := range files {
+		if err := sink.Write(f.name, f.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
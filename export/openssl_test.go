@@ -0,0 +1,88 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager"
+)
+
+func TestWriteOpenSSLIndex(t *testing.T) {
+	notBefore := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	records := []certmanager.IssuanceRecord{
+		{SerialNumber: big.NewInt(0xabc), ID: "spiffe://cluster.local/ns/foo/sa/bar",
+			NotBefore: notBefore, NotAfter: notBefore.Add(time.Hour)},
+		{SerialNumber: big.NewInt(16), ID: "foo.com", NotBefore: notBefore, NotAfter: notBefore.Add(time.Hour)},
+	}
+	revocations := certmanager.NewRevocationList()
+	revocations.Revoke(big.NewInt(16))
+
+	var buf bytes.Buffer
+	if err := WriteOpenSSLIndex(&buf, records, revocations); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expecting 2 lines but got %q", buf.String())
+	}
+	if expected := "V\t170601130000Z\t\t0ABC\tunknown\t/CN=spiffe://cluster.local/ns/foo/sa/bar"; lines[0] != expected {
+		t.Errorf("Expecting line %q but got %q", expected, lines[0])
+	}
+	fields := strings.Split(lines[1], "\t")
+	if len(fields) != 6 || fields[0] != "R" || len(fields[2]) != len(opensslTimeLayout) || fields[3] != "10" {
+		t.Errorf("Expecting the revoked certificate with its revocation time but got %q", lines[1])
+	}
+
+	if serial := string(OpenSSLSerial(records)); serial != "0ABD\n" {
+		t.Errorf("Expecting the serial after the largest issued one but got %q", serial)
+	}
+	if serial := string(OpenSSLSerial(nil)); serial != "01\n" {
+		t.Errorf("Expecting the first serial of an empty database but got %q", serial)
+	}
+}
+
+func TestExportOpenSSLDatabase(t *testing.T) {
+	notBefore := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	source := fakeSource{
+		{SerialNumber: big.NewInt(255), ID: "foo.com", NotBefore: notBefore, NotAfter: notBefore.Add(time.Hour)},
+	}
+
+	sink := memSink{}
+	e := NewExporter(source, nil, sink, time.Hour)
+	if err := e.Export(notBefore); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if _, ok := sink[OpenSSLIndexName]; ok {
+		t.Error("Expecting no OpenSSL CA database unless enabled")
+	}
+
+	e.OpenSSLDatabase = true
+	if err := e.Export(notBefore); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	for _, name := range []string{OpenSSLIndexName, OpenSSLIndexAttrName, OpenSSLSerialName} {
+		if _, ok := sink[name]; !ok {
+			t.Errorf("Expecting %s to be exported, but got %v", name, sink)
+		}
+	}
+	if serial := string(sink[OpenSSLSerialName]); serial != "0100\n" {
+		t.Errorf("Unexpected serial %q", serial)
+	}
+}
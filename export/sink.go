@@ -0,0 +1,85 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Sink stores the exported reports.
+type Sink interface {
+	Write(name string, data []byte) error
+}
+
+// FileSink writes the reports to files in a directory, e.g. a mounted GCS or
+// S3 bucket.
+type FileSink struct {
+	Dir string
+}
+
+// Write implements Sink. The file is replaced atomically.
+func (s FileSink) Write(name string, data []byte) error {
+	path := filepath.Join(s.Dir, name)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// HTTPSink uploads the reports with HTTP PUT requests to objects under a base
+// URL, e.g. https://storage.googleapis.com/<bucket>/<prefix> of the GCS XML API.
+type HTTPSink struct {
+	Client  *http.Client
+	BaseURL string
+
+	// The OAuth 2.0 bearer token to authorize the uploads with, if any.
+	Token string
+}
+
+// Write implements Sink.
+func (s HTTPSink) Write(name string, data []byte) error {
+	u := strings.TrimSuffix(s.BaseURL, "/") + "/" + url.PathEscape(name)
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	// The CSV reports are uploaded along with the plain text OpenSSL CA database.
+	contentType := "text/plain"
+	if strings.HasSuffix(name, ".csv") {
+		contentType = "text/csv"
+	}
+	req.Header.Set("Content-Type", contentType)
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to upload %s (status: %s)", u, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	if err := (FileSink{Dir: dir}).Write("report.csv", []byte("a,b\n")); err != nil {
+		t.Fatalf("Failed to write the report: %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "report.csv"))
+	if err != nil || string(data) != "a,b\n" {
+		t.Errorf("Unexpected report content %q (error: %v)", data, err)
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	testCases := map[string]struct {
+		status    int
+		expectErr bool
+	}{
+		"Successful upload": {status: http.StatusOK},
+		"Rejected upload":   {status: http.StatusForbidden, expectErr: true},
+	}
+
+	for k, tc := range testCases {
+		var method, path, auth, body string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method, path, auth = r.Method, r.URL.Path, r.Header.Get("Authorization")
+			bs, _ := ioutil.ReadAll(r.Body)
+			body = string(bs)
+			w.WriteHeader(tc.status)
+		}))
+
+		sink := HTTPSink{Client: server.Client(), BaseURL: server.URL + "/bucket/reports/", Token: "token"}
+		err := sink.Write("report.csv", []byte("a,b\n"))
+		server.Close()
+
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s: expecting an error", k)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+		}
+		if method != http.MethodPut || path != "/bucket/reports/report.csv" || auth != "Bearer token" || body != "a,b\n" {
+			t.Errorf("%s: unexpected upload %s %s (authorization %q, body %q)", k, method, path, auth, body)
+		}
+	}
+}
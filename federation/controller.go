@@ -0,0 +1,334 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package federation keeps the trust bundles of the CAs of a multi-primary
+// mesh consistent, with one CA per cluster. Each CA serves its own root
+// certificates to its peers over mutual TLS, and merges the roots fetched
+// from the peers into the trust bundle it distributes to its workloads.
+package federation
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"istio.io/auth/parse"
+)
+
+const (
+	// The path the own root certificates of a CA are served on.
+	rootsPath = "/roots"
+
+	requestTimeout = 10 * time.Second
+)
+
+var (
+	syncs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "istio_ca",
+		Subsystem: "federation",
+		Name:      "syncs_total",
+		Help:      "The number of fetches of the root certificates of the peer CAs, by peer and result.",
+	}, []string{"peer", "result"})
+
+	peerRoots = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "istio_ca",
+		Subsystem: "federation",
+		Name:      "peer_root_certs",
+		Help:      "The number of root certificates of each peer CA in the trust bundle.",
+	}, []string{"peer"})
+)
+
+func init() {
+	prometheus.MustRegister(syncs)
+	prometheus.MustRegister(peerRoots)
+}
+
+// CertificateAuthority is the CA whose trust bundle is federated.
+type CertificateAuthority interface {
+	Sign(csrPEM []byte) (chain []byte, err error)
+	GetOwnRootCertificates() []byte
+	SetPeerRootCertificates(roots []byte)
+}
+
+// Peer is the CA of another cluster of the mesh.
+type Peer struct {
+	Name string `json:"name"`
+
+	// The host:port the peer serves its root certificates on.
+	Address string `json:"address"`
+
+	// The URI SAN, e.g. spiffe://west.example.com/ns/istio-system/sa/istio-ca,
+	// that the certificate the peer authenticates with must carry.
+	ID string `json:"id"`
+
+	// The path to the root certificates of the peer, which LoadPeers reads into RootCerts.
+	RootCertFile string `json:"rootCert"`
+
+	// The PEM-encoded root certificates of the peer that it is authenticated
+	// with until its roots are first fetched. They are replaced by the
+	// fetched roots, so that the peer can rotate its root.
+	RootCerts []byte `json:"-"`
+}
+
+// LoadPeers loads the peers from a JSON file holding an array of Peer.
+func LoadPeers(path string) ([]Peer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var peers []Peer
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return nil, fmt.Errorf("failed to parse the peers in %s (error: %v)", path, err)
+	}
+	for i, p := range peers {
+		if p.Name == "" || p.Address == "" || p.ID == "" || p.RootCertFile == "" {
+			return nil, fmt.Errorf("peer %d in %s must have a \"name\", an \"address\", an \"id\" and a \"rootCert\"",
+				i, path)
+		}
+		if peers[i].RootCerts, err = ioutil.ReadFile(p.RootCertFile); err != nil {
+			return nil, err
+		}
+	}
+	return peers, nil
+}
+
+type peerState struct {
+	Peer
+
+	// The latest known root certificates of the peer.
+	roots []*x509.Certificate
+
+	// The client fetching the roots, which keeps the connection to the peer
+	// alive across syncs.
+	client *http.Client
+}
+
+// Controller fetches the root certificates of the peers every interval and
+// sets them as the peer roots of the CA. The roots of a peer that can not be
+// fetched are kept as last known.
+type Controller struct {
+	ca       CertificateAuthority
+	id       string
+	interval time.Duration
+
+	mutex sync.RWMutex
+	peers map[string]*peerState
+
+	// The TLS certificate of the CA for id, which is reissued once half of its lifetime has passed.
+	certMutex sync.Mutex
+	cert      *tls.Certificate
+	renewAt   time.Time
+}
+
+// NewController returns a pointer to a Controller federating the trust bundle
+// of the CA, which authenticates to the peers with a certificate for the URI
+// SAN id.
+func NewController(ca CertificateAuthority, id string, peers []Peer, interval time.Duration) (*Controller, error) {
+	c := &Controller{
+		ca:       ca,
+		id:       id,
+		interval: interval,
+		peers:    map[string]*peerState{},
+	}
+	for _, p := range peers {
+		if _, ok := c.peers[p.Name]; ok {
+			return nil, fmt.Errorf("peer %q is configured twice", p.Name)
+		}
+		roots, err := parseRoots(p.RootCerts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root certificates of peer %q (error: %v)", p.Name, err)
+		}
+		c.peers[p.Name] = &peerState{Peer: p, roots: roots, client: c.newPeerClient(p.Name)}
+	}
+	c.updateTrustBundle()
+	return c, nil
+}
+
+// Run fetches the root certificates of the peers every interval until stopCh is closed.
+func (c *Controller) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.sync()
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Controller) sync() {
+	c.mutex.RLock()
+	peers := make([]*peerState, 0, len(c.peers))
+	for _, p := range c.peers {
+		peers = append(peers, p)
+	}
+	c.mutex.RUnlock()
+
+	for _, p := range peers {
+		roots, err := c.fetchRoots(p)
+		if err != nil {
+			glog.Errorf("Failed to fetch the root certificates of peer %q (error: %v)", p.Name, err)
+			syncs.WithLabelValues(p.Name, "failure").Inc()
+			continue
+		}
+		syncs.WithLabelValues(p.Name, "success").Inc()
+
+		c.mutex.Lock()
+		p.roots = roots
+		c.mutex.Unlock()
+	}
+	c.updateTrustBundle()
+}
+
+// newPeerClient returns an HTTP client authenticating to the named peer over
+// mutual TLS.
+func (c *Controller) newPeerClient(name string) *http.Client {
+	return &http.Client{
+		Timeout: requestTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					return c.getCertificate(nil)
+				},
+				// The peer is verified against its roots and its ID rather than a host name.
+				InsecureSkipVerify: true, // nolint: gas
+				VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+					if peer, ok := c.authenticate(rawCerts); !ok || peer != name {
+						return fmt.Errorf("the server is not authenticated as peer %q", name)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// fetchRoots fetches the root certificates of the peer over mutual TLS.
+func (c *Controller) fetchRoots(p *peerState) ([]*x509.Certificate, error) {
+	resp, err := p.client.Get("https://" + p.Address + rootsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	// A larger body is refused by the parser.
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, parse.MaxCertificateSize+1))
+	if err != nil {
+		return nil, err
+	}
+	return parseRoots(body)
+}
+
+// authenticate returns the name of the peer that the certificate chain is
+// issued to, and whether the chain verifies against the roots of the peer.
+func (c *Controller) authenticate(rawCerts [][]byte) (string, bool) {
+	if len(rawCerts) == 0 {
+		return "", false
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return "", false
+		}
+		certs[i] = cert
+	}
+	if len(certs[0].URIs) != 1 {
+		return "", false
+	}
+	id := certs[0].URIs[0].String()
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for _, p := range c.peers {
+		if p.ID != id {
+			continue
+		}
+		roots := x509.NewCertPool()
+		for _, r := range p.roots {
+			roots.AddCert(r)
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(x509.VerifyOptions{
+			Intermediates: intermediates,
+			Roots:         roots,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		return p.Name, err == nil
+	}
+	return "", false
+}
+
+// updateTrustBundle sets the known roots of all peers as the peer roots of
+// the CA, ordered by peer name so that the trust bundle only changes with
+// the roots.
+func (c *Controller) updateTrustBundle() {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	names := make([]string, 0, len(c.peers))
+	for name := range c.peers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var bundle bytes.Buffer
+	seen := map[string]bool{}
+	for _, name := range names {
+		p := c.peers[name]
+		for _, r := range p.roots {
+			if seen[string(r.Raw)] {
+				continue
+			}
+			seen[string(r.Raw)] = true
+			// nolint: errcheck
+			pem.Encode(&bundle, &pem.Block{Type: "CERTIFICATE", Bytes: r.Raw})
+		}
+		peerRoots.WithLabelValues(name).Set(float64(len(p.roots)))
+	}
+	c.ca.SetPeerRootCertificates(bundle.Bytes())
+}
+
+// parseRoots parses PEM-encoded root certificates, of which there must be at least one.
+func parseRoots(data []byte) ([]*x509.Certificate, error) {
+	certs, err := parse.CertificateChain(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, cert := range certs {
+		if !cert.IsCA {
+			return nil, fmt.Errorf("the certificate %q is not a CA certificate", cert.Subject.String())
+		}
+	}
+	return certs, nil
+}
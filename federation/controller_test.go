@@ -0,0 +1,256 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federation
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager"
+)
+
+func newCA(t *testing.T, trustDomain string) *certmanager.IstioCA {
+	ca, err := certmanager.NewSelfSignedIstioCA(&certmanager.SelfSignedIstioCAOptions{
+		CACertTTL:   time.Hour,
+		CertTTL:     30 * time.Minute,
+		MaxPathLen:  -1,
+		TrustDomain: trustDomain,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+	return ca
+}
+
+// serve starts serving the root certificates of the controller on a local
+// address, until the returned listener is closed.
+func serve(t *testing.T, c *Controller) net.Listener {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go c.Serve(lis) // nolint: errcheck
+	return lis
+}
+
+func TestController(t *testing.T) {
+	const eastID, westID = "spiffe://east.local/ns/istio-system/sa/istio-ca",
+		"spiffe://west.local/ns/istio-system/sa/istio-ca"
+	east, west, other := newCA(t, "east.local"), newCA(t, "west.local"), newCA(t, "other.local")
+
+	westController, err := NewController(west, westID, []Peer{
+		{Name: "east", ID: eastID, RootCerts: east.GetOwnRootCertificates()},
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create the controller of west: %v", err)
+	}
+	westListener := serve(t, westController)
+	defer westListener.Close() // nolint: errcheck
+
+	testCases := map[string]struct {
+		peer          Peer
+		expectedRoots []byte
+	}{
+		"Authenticated peer": {
+			peer: Peer{
+				Name: "west", Address: westListener.Addr().String(), ID: westID,
+				RootCerts: west.GetOwnRootCertificates(),
+			},
+			expectedRoots: west.GetOwnRootCertificates(),
+		},
+		"Peer with another ID": {
+			peer: Peer{
+				Name: "west", Address: westListener.Addr().String(), ID: "spiffe://west.local/ns/default/sa/foo",
+				RootCerts: west.GetOwnRootCertificates(),
+			},
+		},
+		"Peer with other roots": {
+			peer: Peer{
+				Name: "west", Address: westListener.Addr().String(), ID: westID,
+				RootCerts: other.GetOwnRootCertificates(),
+			},
+		},
+	}
+
+	for id, tc := range testCases {
+		// The bootstrap roots are trusted before the first sync.
+		east.SetPeerRootCertificates(nil)
+		c, err := NewController(east, eastID, []Peer{tc.peer}, time.Minute)
+		if err != nil {
+			t.Fatalf("%s: Failed to create the controller of east: %v", id, err)
+		}
+		if !bytes.HasSuffix(east.GetRootCertificate(), tc.peer.RootCerts) {
+			t.Errorf("%s: Expecting the bootstrap roots of the peer in the trust bundle", id)
+		}
+
+		c.sync()
+		if tc.expectedRoots == nil {
+			if !bytes.HasSuffix(east.GetRootCertificate(), tc.peer.RootCerts) {
+				t.Errorf("%s: Expecting the bootstrap roots of an unauthenticated peer to be kept", id)
+			}
+			continue
+		}
+		if !bytes.HasSuffix(east.GetRootCertificate(), tc.expectedRoots) {
+			t.Errorf("%s: Expecting the fetched roots of the peer in the trust bundle", id)
+		}
+	}
+
+	// A client that is not a peer of west is refused.
+	stranger, err := NewController(other, "spiffe://other.local/ns/istio-system/sa/istio-ca", []Peer{
+		{Name: "west", Address: westListener.Addr().String(), ID: westID, RootCerts: west.GetOwnRootCertificates()},
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create the controller of a stranger: %v", err)
+	}
+	if _, err := stranger.fetchRoots(stranger.peers["west"]); err == nil {
+		t.Error("Expecting a client that is not a peer to be refused")
+	}
+}
+
+// countingListener counts the accepted connections.
+type countingListener struct {
+	net.Listener
+	accepted int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.accepted, 1)
+	}
+	return conn, err
+}
+
+func TestFetchRootsReusesConnection(t *testing.T) {
+	const eastID, westID = "spiffe://east.local/ns/istio-system/sa/istio-ca",
+		"spiffe://west.local/ns/istio-system/sa/istio-ca"
+	east, west := newCA(t, "east.local"), newCA(t, "west.local")
+
+	westController, err := NewController(west, westID, []Peer{
+		{Name: "east", ID: eastID, RootCerts: east.GetOwnRootCertificates()},
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create the controller of west: %v", err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	westListener := &countingListener{Listener: lis}
+	go westController.Serve(westListener) // nolint: errcheck
+	defer westListener.Close()            // nolint: errcheck
+
+	c, err := NewController(east, eastID, []Peer{
+		{Name: "west", Address: lis.Addr().String(), ID: westID, RootCerts: west.GetOwnRootCertificates()},
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create the controller of east: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.fetchRoots(c.peers["west"]); err != nil {
+			t.Fatalf("Failed to fetch the roots of west: %v", err)
+		}
+	}
+	if accepted := atomic.LoadInt32(&westListener.accepted); accepted != 1 {
+		t.Errorf("Expecting the fetches to share a connection, but west accepted %d", accepted)
+	}
+}
+
+func TestNewController(t *testing.T) {
+	ca := newCA(t, "cluster.local")
+	root := ca.GetOwnRootCertificates()
+	chain, _, _ := ca.Generate("foo", "default")
+
+	testCases := map[string]struct {
+		peers       []Peer
+		expectedErr string
+	}{
+		"Duplicate peer": {
+			peers:       []Peer{{Name: "west", RootCerts: root}, {Name: "west", RootCerts: root}},
+			expectedErr: `peer "west" is configured twice`,
+		},
+		"No root certificates": {
+			peers:       []Peer{{Name: "west"}},
+			expectedErr: "certificate chain is empty",
+		},
+		"Not a CA certificate": {
+			peers:       []Peer{{Name: "west", RootCerts: chain}},
+			expectedErr: "is not a CA certificate",
+		},
+	}
+
+	for id, tc := range testCases {
+		_, err := NewController(ca, "spiffe://cluster.local/ns/istio-system/sa/istio-ca", tc.peers, time.Minute)
+		if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+			t.Errorf("%s: Unexpected error (expecting %q, actual %v)", id, tc.expectedErr, err)
+		}
+	}
+}
+
+func TestLoadPeers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "federation-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	rootFile := filepath.Join(dir, "west-root.pem")
+	if err := ioutil.WriteFile(rootFile, []byte("west root"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		config      string
+		expectedErr string
+	}{
+		"Valid peers": {
+			config: `[{"name": "west", "address": "ca.west.example.com:8070", ` +
+				`"id": "spiffe://west.local/ns/istio-system/sa/istio-ca", "rootCert": "` + rootFile + `"}]`,
+		},
+		"Missing ID": {
+			config:      `[{"name": "west", "address": "ca.west.example.com:8070", "rootCert": "` + rootFile + `"}]`,
+			expectedErr: `peer 0 in`,
+		},
+		"Malformed": {
+			config:      `{"name": "west"}`,
+			expectedErr: "failed to parse the peers",
+		},
+	}
+
+	for id, tc := range testCases {
+		path := filepath.Join(dir, "peers.json")
+		if err := ioutil.WriteFile(path, []byte(tc.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		peers, err := LoadPeers(path)
+		if tc.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: Unexpected error (expecting %q, actual %v)", id, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", id, err)
+		} else if len(peers) != 1 || string(peers[0].RootCerts) != "west root" {
+			t.Errorf("%s: Unexpected peers %v", id, peers)
+		}
+	}
+}
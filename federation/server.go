@@ -0,0 +1,128 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// ListenAndServe serves the own root certificates of the CA to the peers on
+// the address until an error occurs.
+func (c *Controller) ListenAndServe(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	glog.Infof("Serving the root certificates to the federation peers on %s", addr)
+	return c.Serve(lis)
+}
+
+// Serve serves the own root certificates of the CA over TLS on the listener
+// until an error occurs. Only the peers are served, which authenticate with
+// a certificate for their ID issued under their roots.
+func (c *Controller) Serve(lis net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(rootsPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Write(c.ca.GetOwnRootCertificates()) // nolint: errcheck
+	})
+
+	server := &http.Server{
+		Handler: mux,
+		TLSConfig: &tls.Config{
+			GetCertificate: c.getCertificate,
+			ClientAuth:     tls.RequireAnyClientCert,
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				if _, ok := c.authenticate(rawCerts); !ok {
+					return errors.New("the client is not authenticated as a federation peer")
+				}
+				return nil
+			},
+		},
+	}
+	return server.Serve(tls.NewListener(lis, server.TLSConfig))
+}
+
+func (c *Controller) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.certMutex.Lock()
+	defer c.certMutex.Unlock()
+
+	now := time.Now()
+	if c.cert != nil && now.Before(c.renewAt) {
+		return c.cert, nil
+	}
+
+	cert, err := c.issueCertificate()
+	if err != nil {
+		if c.cert != nil && now.Before(c.cert.Leaf.NotAfter) {
+			glog.Errorf("Failed to renew the federation TLS certificate (error: %v)", err)
+			return c.cert, nil
+		}
+		return nil, err
+	}
+	c.cert = cert
+	c.renewAt = cert.Leaf.NotBefore.Add(cert.Leaf.NotAfter.Sub(cert.Leaf.NotBefore) / 2)
+	return c.cert, nil
+}
+
+// issueCertificate issues the certificate for the ID of the CA, which
+// authenticates it both as a client and as a server to the peers.
+func (c *Controller) issueCertificate() (*tls.Certificate, error) {
+	id, err := url.Parse(c.id)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{URIs: []*url.URL{id}}, priv)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := c.ca.Sign(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(chain, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
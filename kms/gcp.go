@@ -0,0 +1,147 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kms
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	gcpKMSEndpoint = "https://cloudkms.googleapis.com/v1/"
+
+	// The GCE metadata server endpoint of the access tokens of the default service account.
+	gcpTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+	// Access tokens are refreshed this long before they expire.
+	tokenExpiryMargin = time.Minute
+)
+
+// GCPKMS encrypts and decrypts with a Google Cloud KMS key, authorized by the
+// access token of the default service account from the GCE metadata server.
+type GCPKMS struct {
+	mutex sync.Mutex
+
+	// The resource name of the key, e.g.
+	// projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>.
+	keyName string
+	client  *http.Client
+
+	endpoint string
+	tokenURL string
+
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewGCPKMS returns a pointer to a GCPKMS using the named key.
+func NewGCPKMS(keyName string) *GCPKMS {
+	return &GCPKMS{
+		keyName:  keyName,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		endpoint: gcpKMSEndpoint,
+		tokenURL: gcpTokenURL,
+	}
+}
+
+// KeyName implements KMS.
+func (k *GCPKMS) KeyName() string {
+	return k.keyName
+}
+
+// Encrypt implements KMS.
+func (k *GCPKMS) Encrypt(plaintext []byte) ([]byte, error) {
+	var resp struct {
+		Ciphertext []byte `json:"ciphertext"`
+	}
+	err := k.call("encrypt", map[string][]byte{"plaintext": plaintext}, &resp)
+	return resp.Ciphertext, err
+}
+
+// Decrypt implements KMS.
+func (k *GCPKMS) Decrypt(ciphertext []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext []byte `json:"plaintext"`
+	}
+	err := k.call("decrypt", map[string][]byte{"ciphertext": ciphertext}, &resp)
+	return resp.Plaintext, err
+}
+
+// call invokes a method of the key. The []byte fields of the request and the
+// response are base64-encoded in JSON, as the API expects.
+func (k *GCPKMS) call(method string, req map[string][]byte, resp interface{}) error {
+	token, err := k.accessToken()
+	if err != nil {
+		return fmt.Errorf("failed to get an access token (error: %v)", err)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	r, err := http.NewRequest(http.MethodPost, k.endpoint+k.keyName+":"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("Content-Type", "application/json")
+	res, err := k.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close() // nolint: errcheck
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("cloud KMS %s failed (status: %s)", method, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(resp)
+}
+
+func (k *GCPKMS) accessToken() (string, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.token != "" && time.Now().Before(k.tokenExpiry.Add(-tokenExpiryMargin)) {
+		return k.token, nil
+	}
+
+	r, err := http.NewRequest(http.MethodGet, k.tokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	r.Header.Set("Metadata-Flavor", "Google")
+	res, err := k.client.Do(r)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close() // nolint: errcheck
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s from the metadata server", res.Status)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	k.token = token.AccessToken
+	k.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return k.token, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kms
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testKeyName = "projects/p/locations/global/keyRings/r/cryptoKeys/k"
+
+func TestGCPKMS(t *testing.T) {
+	tokenRequests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing header", http.StatusForbidden)
+			return
+		}
+		tokenRequests++
+		w.Write([]byte(`{"access_token": "token", "expires_in": 3600}`)) // nolint: errcheck
+	})
+	// The fake KMS reverses the bytes.
+	mux.HandleFunc("/v1/"+testKeyName+":encrypt", func(w http.ResponseWriter, r *http.Request) {
+		handleFakeKMS(t, w, r, "plaintext", "ciphertext")
+	})
+	mux.HandleFunc("/v1/"+testKeyName+":decrypt", func(w http.ResponseWriter, r *http.Request) {
+		handleFakeKMS(t, w, r, "ciphertext", "plaintext")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	k := NewGCPKMS(testKeyName)
+	k.endpoint = server.URL + "/v1/"
+	k.tokenURL = server.URL + "/token"
+
+	plaintext := []byte("data key")
+	ciphertext, err := k.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	if bytes.Equal(ciphertext, plaintext) {
+		t.Error("Expecting the ciphertext to differ from the plaintext")
+	}
+	decrypted, err := k.Decrypt(ciphertext)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Expecting %q but got %q (error: %v)", plaintext, decrypted, err)
+	}
+	if tokenRequests != 1 {
+		t.Errorf("Expecting the access token to be cached, but it was requested %d times", tokenRequests)
+	}
+}
+
+func handleFakeKMS(t *testing.T, w http.ResponseWriter, r *http.Request, in, out string) {
+	if r.Header.Get("Authorization") != "Bearer token" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	req := map[string][]byte{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		t.Errorf("Invalid KMS request: %v", err)
+	}
+	data := req[in]
+	for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+		data[i], data[j] = data[j], data[i]
+	}
+	json.NewEncoder(w).Encode(map[string][]byte{out: data}) // nolint: errcheck
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kms envelope-encrypts data with a key managed by a key management
+// service (KMS): the data is encrypted with a random data key, and only the
+// data key is encrypted by the KMS. Decrypting the envelope requires access to
+// the KMS key, not only to the stored envelope.
+package kms
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// The size of the AES-256 data keys.
+const dataKeySize = 32
+
+// KMS encrypts and decrypts small payloads, i.e. data keys, with a key it manages.
+type KMS interface {
+	// KeyName returns the name of the KMS key.
+	KeyName() string
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// Envelope is the JSON-serialized form of envelope-encrypted data.
+type Envelope struct {
+	// The name of the KMS key that encrypted the data key.
+	KeyName string `json:"kmsKey"`
+	// The data key encrypted by the KMS.
+	EncryptedDataKey []byte `json:"encryptedDataKey"`
+	// The AES-GCM nonce and the encrypted data.
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Seal encrypts the plaintext with a new data key, which is encrypted by the
+// KMS, and returns the serialized envelope.
+func Seal(k KMS, plaintext []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	encryptedDataKey, err := k.Encrypt(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt the data key with KMS key %s (error: %v)", k.KeyName(), err)
+	}
+	return json.Marshal(Envelope{
+		KeyName:          k.KeyName(),
+		EncryptedDataKey: encryptedDataKey,
+		Nonce:            nonce,
+		Ciphertext:       aead.Seal(nil, nonce, plaintext, []byte(k.KeyName())),
+	})
+}
+
+// Open decrypts the serialized envelope, decrypting its data key with the KMS.
+func Open(k KMS, sealed []byte) ([]byte, error) {
+	var env Envelope
+	if err := json.Unmarshal(sealed, &env); err != nil {
+		return nil, fmt.Errorf("invalid envelope (error: %v)", err)
+	}
+	if env.KeyName != k.KeyName() {
+		return nil, fmt.Errorf("the envelope is encrypted with KMS key %s", env.KeyName)
+	}
+
+	dataKey, err := k.Decrypt(env.EncryptedDataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the data key with KMS key %s (error: %v)", k.KeyName(), err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid envelope nonce")
+	}
+	return aead.Open(nil, env.Nonce, env.Ciphertext, []byte(env.KeyName))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("invalid data key size %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
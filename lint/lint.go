@@ -0,0 +1,219 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lint checks certificates against the baseline requirements of
+// RFC 5280 and of the CA/Browser Forum, in the style of zlint, so that
+// malformed PKI is caught before it breaks clients. A lint names the rule it
+// enforces, with the "e_" prefix for errors and "w_" for warnings.
+package lint
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// The kinds of the linted certificates.
+	KindCA     = "ca"
+	KindIssued = "issued"
+
+	minRSAKeySize = 2048
+
+	// The maximum validity of a subscriber certificate per the CA/Browser Forum.
+	maxSubscriberValidity = 398 * 24 * time.Hour
+)
+
+var (
+	oidExtensionBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+	oidExtensionSubjectAltName   = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+	violations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "istio_ca",
+		Subsystem: "lint",
+		Name:      "violations_total",
+		Help:      "The number of lint violations found in certificates, by kind ('ca' or 'issued') and lint.",
+	}, []string{"kind", "lint"})
+)
+
+func init() {
+	prometheus.MustRegister(violations)
+}
+
+// Violation is a rule a certificate breaks.
+type Violation struct {
+	Lint    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return v.Lint + ": " + v.Message
+}
+
+type lint struct {
+	name string
+
+	// Whether the lint applies to CA certificates and to subscriber certificates.
+	ca, subscriber bool
+
+	// check returns the reason the certificate breaks the rule, or the empty string.
+	check func(cert *x509.Certificate) string
+}
+
+var lints = []lint{
+	{name: "e_cert_not_v3", ca: true, subscriber: true, check: func(c *x509.Certificate) string {
+		if c.Version != 3 {
+			return fmt.Sprintf("the certificate is version %d", c.Version)
+		}
+		return ""
+	}},
+	{name: "e_serial_number_not_positive", ca: true, subscriber: true, check: func(c *x509.Certificate) string {
+		if c.SerialNumber.Sign() <= 0 {
+			return "the serial number is not positive"
+		}
+		return ""
+	}},
+	{name: "e_serial_number_longer_than_20_octets", ca: true, subscriber: true, check: func(c *x509.Certificate) string {
+		if n := len(c.SerialNumber.Bytes()); n > 20 {
+			return fmt.Sprintf("the serial number is %d octets long", n)
+		}
+		return ""
+	}},
+	{name: "e_validity_inverted", ca: true, subscriber: true, check: func(c *x509.Certificate) string {
+		if !c.NotBefore.Before(c.NotAfter) {
+			return fmt.Sprintf("notBefore %v is not before notAfter %v", c.NotBefore, c.NotAfter)
+		}
+		return ""
+	}},
+	{name: "e_weak_signature_algorithm", ca: true, subscriber: true, check: func(c *x509.Certificate) string {
+		switch c.SignatureAlgorithm {
+		case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+			return fmt.Sprintf("the certificate is signed with %s", c.SignatureAlgorithm)
+		}
+		return ""
+	}},
+	{name: "e_rsa_key_too_small", ca: true, subscriber: true, check: func(c *x509.Certificate) string {
+		if k, ok := c.PublicKey.(*rsa.PublicKey); ok && k.N.BitLen() < minRSAKeySize {
+			return fmt.Sprintf("the RSA key has %d bits, fewer than %d", k.N.BitLen(), minRSAKeySize)
+		}
+		return ""
+	}},
+	{name: "e_ec_curve_not_allowed", ca: true, subscriber: true, check: func(c *x509.Certificate) string {
+		k, ok := c.PublicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return ""
+		}
+		switch k.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+			return ""
+		}
+		return fmt.Sprintf("the EC key is on the curve %s", k.Curve.Params().Name)
+	}},
+	{name: "e_ext_san_not_critical_without_subject", ca: true, subscriber: true, check: func(c *x509.Certificate) string {
+		if len(c.Subject.Names) > 0 {
+			return ""
+		}
+		for _, ext := range c.Extensions {
+			if ext.Id.Equal(oidExtensionSubjectAltName) && !ext.Critical {
+				return "the subject is empty but the subject alternative names are not critical"
+			}
+		}
+		return ""
+	}},
+
+	{name: "e_ca_basic_constraints_not_critical", ca: true, check: func(c *x509.Certificate) string {
+		for _, ext := range c.Extensions {
+			if ext.Id.Equal(oidExtensionBasicConstraints) && !ext.Critical {
+				return "the basic constraints are not critical"
+			}
+		}
+		return ""
+	}},
+	{name: "e_ca_key_usage_missing_cert_sign", ca: true, check: func(c *x509.Certificate) string {
+		if c.KeyUsage&x509.KeyUsageCertSign == 0 {
+			return "the key usage does not allow signing certificates"
+		}
+		return ""
+	}},
+	{name: "e_ca_subject_key_identifier_missing", ca: true, check: func(c *x509.Certificate) string {
+		if len(c.SubjectKeyId) == 0 {
+			return "the CA certificate has no subject key identifier"
+		}
+		return ""
+	}},
+	{name: "e_ca_subject_empty", ca: true, check: func(c *x509.Certificate) string {
+		if len(c.Subject.Names) == 0 {
+			return "the CA certificate has an empty subject"
+		}
+		return ""
+	}},
+
+	{name: "e_sub_cert_key_usage_cert_sign", subscriber: true, check: func(c *x509.Certificate) string {
+		if c.KeyUsage&x509.KeyUsageCertSign != 0 {
+			return "the key usage of a subscriber certificate allows signing certificates"
+		}
+		return ""
+	}},
+	{name: "e_sub_cert_authority_key_identifier_missing", subscriber: true, check: func(c *x509.Certificate) string {
+		if len(c.AuthorityKeyId) == 0 {
+			return "the subscriber certificate has no authority key identifier"
+		}
+		return ""
+	}},
+	{name: "e_sub_cert_no_san", subscriber: true, check: func(c *x509.Certificate) string {
+		if len(c.DNSNames)+len(c.URIs)+len(c.IPAddresses)+len(c.EmailAddresses) == 0 {
+			return "the subscriber certificate has no subject alternative names"
+		}
+		return ""
+	}},
+	{name: "w_sub_cert_validity_too_long", subscriber: true, check: func(c *x509.Certificate) string {
+		if v := c.NotAfter.Sub(c.NotBefore); v > maxSubscriberValidity {
+			return fmt.Sprintf("the subscriber certificate is valid for %v, longer than %v", v, maxSubscriberValidity)
+		}
+		return ""
+	}},
+}
+
+// Certificate returns the violations of the lints that apply to the
+// certificate, depending on whether it is a CA certificate.
+func Certificate(cert *x509.Certificate) []Violation {
+	found := []Violation{}
+	for _, l := range lints {
+		if cert.IsCA && !l.ca || !cert.IsCA && !l.subscriber {
+			continue
+		}
+		if msg := l.check(cert); msg != "" {
+			found = append(found, Violation{Lint: l.name, Message: msg})
+		}
+	}
+	return found
+}
+
+// Report lints the certificate of the kind, logs the violations and counts
+// them in the metrics, and returns them.
+func Report(kind string, cert *x509.Certificate) []Violation {
+	found := Certificate(cert)
+	for _, v := range found {
+		glog.Warningf("Certificate %q (serial number %x) violates %s", cert.Subject.String(), cert.SerialNumber, v)
+		violations.WithLabelValues(kind, v.Lint).Inc()
+	}
+	return found
+}
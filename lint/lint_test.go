@@ -0,0 +1,169 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lint
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate a key: %v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Failed to generate an RSA key: %v", err)
+	}
+	now := time.Now()
+	id, _ := url.Parse("spiffe://cluster.local/ns/foo/sa/bar")
+
+	ca := func() *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{Organization: []string{"Istio"}},
+			NotBefore:             now,
+			NotAfter:              now.Add(time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+	}
+	leaf := func() *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			NotBefore:    now,
+			NotAfter:     now.Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			URIs:         []*url.URL{id},
+		}
+	}
+
+	testCases := map[string]struct {
+		template  *x509.Certificate
+		selfSign  bool
+		publicKey interface{}
+		expected  []string
+	}{
+		"Valid root": {
+			template: ca(),
+			selfSign: true,
+		},
+		"Valid workload certificate": {
+			template: leaf(),
+		},
+		"Root without cert sign usage": {
+			template: func() *x509.Certificate {
+				c := ca()
+				c.KeyUsage = x509.KeyUsageDigitalSignature
+				return c
+			}(),
+			selfSign: true,
+			expected: []string{"e_ca_key_usage_missing_cert_sign"},
+		},
+		"Root with empty subject": {
+			template: func() *x509.Certificate {
+				c := ca()
+				c.Subject = pkix.Name{}
+				return c
+			}(),
+			selfSign: true,
+			expected: []string{"e_ca_subject_empty"},
+		},
+		"Small RSA key": {
+			template:  leaf(),
+			publicKey: &rsaKey.PublicKey,
+			expected:  []string{"e_rsa_key_too_small"},
+		},
+		"Workload certificate without SANs": {
+			template: func() *x509.Certificate {
+				c := leaf()
+				c.Subject = pkix.Name{CommonName: "foo"}
+				c.URIs = nil
+				return c
+			}(),
+			expected: []string{"e_sub_cert_no_san"},
+		},
+		"Workload certificate allowed to sign certificates": {
+			template: func() *x509.Certificate {
+				c := leaf()
+				c.KeyUsage |= x509.KeyUsageCertSign
+				return c
+			}(),
+			expected: []string{"e_sub_cert_key_usage_cert_sign"},
+		},
+		"Workload certificate valid for too long": {
+			template: func() *x509.Certificate {
+				c := leaf()
+				c.NotAfter = now.Add(400 * 24 * time.Hour)
+				return c
+			}(),
+			expected: []string{"w_sub_cert_validity_too_long"},
+		},
+	}
+
+	root, err := x509.CreateCertificate(rand.Reader, ca(), ca(), &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create the root certificate: %v", err)
+	}
+	parent, err := x509.ParseCertificate(root)
+	if err != nil {
+		t.Fatalf("Failed to parse the root certificate: %v", err)
+	}
+
+	for id, c := range testCases {
+		issuer := parent
+		if c.selfSign {
+			issuer = c.template
+		}
+		var pub interface{} = &key.PublicKey
+		if c.publicKey != nil {
+			pub = c.publicKey
+		}
+		der, err := x509.CreateCertificate(rand.Reader, c.template, issuer, pub, key)
+		if err != nil {
+			t.Fatalf("%s: Failed to create the certificate: %v", id, err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("%s: Failed to parse the certificate: %v", id, err)
+		}
+
+		found := []string{}
+		for _, v := range Report(KindCA, cert) {
+			found = append(found, v.Lint)
+		}
+		if len(found) != len(c.expected) {
+			t.Errorf("%s: Unexpected violations (expecting %v, actual %v)", id, c.expected, found)
+			continue
+		}
+		for i := range found {
+			if found[i] != c.expected[i] {
+				t.Errorf("%s: Unexpected violations (expecting %v, actual %v)", id, c.expected, found)
+				break
+			}
+		}
+	}
+}
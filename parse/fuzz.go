@@ -0,0 +1,57 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build gofuzz
+// +build gofuzz
+
+package parse
+
+// The entry points for go-fuzz (https://github.com/dvyukov/go-fuzz), e.g.
+//
+//   go-fuzz-build -func FuzzCSR istio.io/auth/parse
+//   go-fuzz -bin parse-fuzz.zip -workdir fuzz/csr
+//
+// Each returns 1 if the input parsed successfully and 0 otherwise.
+
+// FuzzCertificate fuzzes Certificate.
+func FuzzCertificate(data []byte) int {
+	if _, err := Certificate(data); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzCertificateChain fuzzes CertificateChain.
+func FuzzCertificateChain(data []byte) int {
+	if _, err := CertificateChain(data); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzCSR fuzzes CSR.
+func FuzzCSR(data []byte) int {
+	if _, err := CSR(data); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzJWT fuzzes UnverifiedJWT.
+func FuzzJWT(data []byte) int {
+	if _, err := UnverifiedJWT(string(data)); err != nil {
+		return 0
+	}
+	return 1
+}
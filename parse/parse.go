@@ -0,0 +1,221 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package parse parses externally supplied PEM certificates, certificate
+// signing requests and JWTs. Every input is size-limited and validated before
+// it is decoded, so that malformed input results in an error rather than a
+// panic or an unbounded allocation.
+
+package parse
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxCertificateSize is the maximum size of a PEM-encoded certificate or certificate chain.
+	MaxCertificateSize = 64 * 1024
+
+	// MaxChainLength is the maximum number of certificates in a certificate chain.
+	MaxChainLength = 8
+
+	// MaxCSRSize is the maximum size of a PEM-encoded certificate signing request.
+	MaxCSRSize = 16 * 1024
+
+	// MaxSANs is the maximum number of subject alternative names in a certificate signing request.
+	MaxSANs = 32
+
+	// MaxJWTSize is the maximum size of a compact-serialized JWT.
+	MaxJWTSize = 16 * 1024
+
+	// The bounds of the RSA key size in a certificate signing request.
+	minRSAKeySize = 1024
+	maxRSAKeySize = 8192
+)
+
+const (
+	certificateBlockType = "CERTIFICATE"
+	csrBlockType         = "CERTIFICATE REQUEST"
+)
+
+// Certificate parses a single PEM-encoded certificate. Trailing data other
+// than whitespace is rejected.
+func Certificate(data []byte) (*x509.Certificate, error) {
+	if len(data) > MaxCertificateSize {
+		return nil, fmt.Errorf("certificate exceeds %d bytes", MaxCertificateSize)
+	}
+	block, rest := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM encoding for the certificate")
+	}
+	if block.Type != certificateBlockType {
+		return nil, fmt.Errorf("unexpected PEM block type %q (expecting %q)", block.Type, certificateBlockType)
+	}
+	if len(strings.TrimSpace(string(rest))) != 0 {
+		return nil, fmt.Errorf("unexpected data after the certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// CertificateChain parses a sequence of PEM-encoded certificates, leaf first.
+func CertificateChain(data []byte) ([]*x509.Certificate, error) {
+	if len(data) > MaxCertificateSize {
+		return nil, fmt.Errorf("certificate chain exceeds %d bytes", MaxCertificateSize)
+	}
+
+	certs := []*x509.Certificate{}
+	rest := data
+	for len(strings.TrimSpace(string(rest))) != 0 {
+		if len(certs) == MaxChainLength {
+			return nil, fmt.Errorf("certificate chain has more than %d certificates", MaxChainLength)
+		}
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, fmt.Errorf("invalid PEM encoding for certificate %d of the chain", len(certs))
+		}
+		if block.Type != certificateBlockType {
+			return nil, fmt.Errorf("unexpected PEM block type %q in the certificate chain", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate %d of the chain (error: %v)", len(certs), err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("certificate chain is empty")
+	}
+	return certs, nil
+}
+
+// CSR parses a PEM-encoded certificate signing request and validates its
+// signature, public key and number of subject alternative names.
+func CSR(data []byte) (*x509.CertificateRequest, error) {
+	if len(data) > MaxCSRSize {
+		return nil, fmt.Errorf("certificate signing request exceeds %d bytes", MaxCSRSize)
+	}
+	block, rest := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM encoding for the certificate signing request")
+	}
+	if block.Type != csrBlockType {
+		return nil, fmt.Errorf("unexpected PEM block type %q (expecting %q)", block.Type, csrBlockType)
+	}
+	if len(strings.TrimSpace(string(rest))) != 0 {
+		return nil, fmt.Errorf("unexpected data after the certificate signing request")
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePublicKey(csr.PublicKey); err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid signature of the certificate signing request (error: %v)", err)
+	}
+	if n := len(csr.DNSNames) + len(csr.EmailAddresses) + len(csr.IPAddresses) + len(csr.URIs); n > MaxSANs {
+		return nil, fmt.Errorf("certificate signing request has %d subject alternative names (at most %d)", n, MaxSANs)
+	}
+	return csr, nil
+}
+
+// JWT is a JSON Web Token parsed by UnverifiedJWT.
+type JWT struct {
+	Header    map[string]interface{}
+	Claims    map[string]interface{}
+	Signature []byte
+
+	// The signed part of the token, i.e. the encoded header and claims joined by ".".
+	SigningInput string
+}
+
+// UnverifiedJWT parses a compact-serialized JWT without verifying its
+// signature, which is left to the caller. Tokens that are unsigned
+// ("alg": "none") are rejected.
+func UnverifiedJWT(token string) (*JWT, error) {
+	if len(token) > MaxJWTSize {
+		return nil, fmt.Errorf("JWT exceeds %d bytes", MaxJWTSize)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("JWT has %d parts (expecting 3)", len(parts))
+	}
+
+	header, err := decodeJWTPart(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT header (error: %v)", err)
+	}
+	claims, err := decodeJWTPart(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT claims (error: %v)", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT signature encoding (error: %v)", err)
+	}
+	jwt := &JWT{
+		Header:       header,
+		Claims:       claims,
+		Signature:    sig,
+		SigningInput: parts[0] + "." + parts[1],
+	}
+
+	alg, ok := jwt.Header["alg"].(string)
+	if !ok || alg == "" || strings.EqualFold(alg, "none") {
+		return nil, fmt.Errorf("JWT is not signed")
+	}
+	return jwt, nil
+}
+
+func decodeJWTPart(part string) (map[string]interface{}, error) {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, fmt.Errorf("not a JSON object")
+	}
+	return m, nil
+}
+
+func validatePublicKey(pub interface{}) error {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if size := key.N.BitLen(); size < minRSAKeySize || size > maxRSAKeySize {
+			return fmt.Errorf("unsupported RSA key size %d (expecting %d to %d bits)", size, minRSAKeySize, maxRSAKeySize)
+		}
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() && key.Curve != elliptic.P384() {
+			return fmt.Errorf("unsupported elliptic curve %s", key.Curve.Params().Name)
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return nil
+}
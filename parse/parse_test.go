@@ -0,0 +1,275 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parse
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	mathrand "math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func genCertificate(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{"test"}},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: certificateBlockType, Bytes: der})
+}
+
+func genCSR(t *testing.T, key interface{}, dnsNames ...string) []byte {
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: dnsNames}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: csrBlockType, Bytes: der})
+}
+
+func encodeJWT(header, claims string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(header)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(claims)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte("sig"))
+}
+
+func TestCertificate(t *testing.T) {
+	cert := genCertificate(t)
+
+	testCases := map[string]struct {
+		data        []byte
+		expectError bool
+	}{
+		"Valid certificate": {
+			data: cert,
+		},
+		"Not PEM": {
+			data:        []byte("not a certificate"),
+			expectError: true,
+		},
+		"Wrong block type": {
+			data:        pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: []byte("key")}),
+			expectError: true,
+		},
+		"Trailing data": {
+			data:        append(append([]byte{}, cert...), cert...),
+			expectError: true,
+		},
+		"Oversized": {
+			data:        append(append([]byte{}, cert...), bytes.Repeat([]byte(" "), MaxCertificateSize)...),
+			expectError: true,
+		},
+	}
+
+	for id, tc := range testCases {
+		if _, err := Certificate(tc.data); (err != nil) != tc.expectError {
+			t.Errorf("%s: unexpected error %v", id, err)
+		}
+	}
+}
+
+func TestCertificateChain(t *testing.T) {
+	cert := genCertificate(t)
+
+	testCases := map[string]struct {
+		data           []byte
+		expectedLength int
+		expectError    bool
+	}{
+		"Single certificate": {
+			data:           cert,
+			expectedLength: 1,
+		},
+		"Two certificates": {
+			data:           bytes.Join([][]byte{cert, cert}, []byte("\n")),
+			expectedLength: 2,
+		},
+		"Empty": {
+			data:        []byte("\n"),
+			expectError: true,
+		},
+		"Too long": {
+			data:        bytes.Repeat(cert, MaxChainLength+1),
+			expectError: true,
+		},
+		"Garbage after a certificate": {
+			data:        append(append([]byte{}, cert...), []byte("garbage")...),
+			expectError: true,
+		},
+	}
+
+	for id, tc := range testCases {
+		certs, err := CertificateChain(tc.data)
+		if (err != nil) != tc.expectError {
+			t.Errorf("%s: unexpected error %v", id, err)
+		}
+		if err == nil && len(certs) != tc.expectedLength {
+			t.Errorf("%s: unexpected chain length (expecting %d, actual %d)", id, tc.expectedLength, len(certs))
+		}
+	}
+}
+
+func TestCSR(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p224Key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallRSAKey, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tooManySANs := []string{}
+	for i := 0; i <= MaxSANs; i++ {
+		tooManySANs = append(tooManySANs, fmt.Sprintf("host%d.com", i))
+	}
+
+	valid := genCSR(t, ecKey, "foo.com")
+	tampered := append([]byte{}, valid...)
+	block, _ := pem.Decode(tampered)
+	block.Bytes[len(block.Bytes)-1] ^= 0xff
+	tampered = pem.EncodeToMemory(block)
+
+	testCases := map[string]struct {
+		data        []byte
+		expectError bool
+	}{
+		"Valid CSR": {
+			data: valid,
+		},
+		"Tampered signature": {
+			data:        tampered,
+			expectError: true,
+		},
+		"Unsupported curve": {
+			data:        genCSR(t, p224Key),
+			expectError: true,
+		},
+		"RSA key too small": {
+			data:        genCSR(t, smallRSAKey),
+			expectError: true,
+		},
+		"Too many SANs": {
+			data:        genCSR(t, ecKey, tooManySANs...),
+			expectError: true,
+		},
+		"Certificate instead of CSR": {
+			data:        genCertificate(t),
+			expectError: true,
+		},
+		"Oversized": {
+			data:        bytes.Repeat([]byte("A"), MaxCSRSize+1),
+			expectError: true,
+		},
+	}
+
+	for id, tc := range testCases {
+		if _, err := CSR(tc.data); (err != nil) != tc.expectError {
+			t.Errorf("%s: unexpected error %v", id, err)
+		}
+	}
+}
+
+func TestUnverifiedJWT(t *testing.T) {
+	testCases := map[string]struct {
+		token       string
+		expectError bool
+	}{
+		"Valid token": {
+			token: encodeJWT(`{"alg":"RS256"}`, `{"sub":"foo"}`),
+		},
+		"Unsigned token": {
+			token:       encodeJWT(`{"alg":"none"}`, `{"sub":"foo"}`),
+			expectError: true,
+		},
+		"Missing algorithm": {
+			token:       encodeJWT(`{}`, `{"sub":"foo"}`),
+			expectError: true,
+		},
+		"Null claims": {
+			token:       encodeJWT(`{"alg":"RS256"}`, `null`),
+			expectError: true,
+		},
+		"Claims not an object": {
+			token:       encodeJWT(`{"alg":"RS256"}`, `[1]`),
+			expectError: true,
+		},
+		"Wrong number of parts": {
+			token:       "a.b",
+			expectError: true,
+		},
+		"Oversized": {
+			token:       encodeJWT(`{"alg":"RS256"}`, `{"sub":"`+strings.Repeat("a", MaxJWTSize)+`"}`),
+			expectError: true,
+		},
+	}
+
+	for id, tc := range testCases {
+		jwt, err := UnverifiedJWT(tc.token)
+		if (err != nil) != tc.expectError {
+			t.Errorf("%s: unexpected error %v", id, err)
+		}
+		if err == nil && jwt.Claims["sub"] != "foo" {
+			t.Errorf("%s: unexpected claims %v", id, jwt.Claims)
+		}
+	}
+}
+
+// TestMutatedInputs feeds randomly corrupted inputs to the parsers, which
+// must return errors rather than panic.
+func TestMutatedInputs(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeds := [][]byte{genCertificate(t), genCSR(t, key, "foo.com"), []byte(encodeJWT(`{"alg":"RS256"}`, `{}`))}
+
+	r := mathrand.New(mathrand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		data := append([]byte{}, seeds[i%len(seeds)]...)
+		for j := 0; j < 1+r.Intn(8); j++ {
+			data[r.Intn(len(data))] = byte(r.Intn(256))
+		}
+		data = data[:r.Intn(len(data)+1)]
+
+		_, _ = Certificate(data)
+		_, _ = CertificateChain(data)
+		_, _ = CSR(data)
+		_, _ = UnverifiedJWT(string(data))
+	}
+}
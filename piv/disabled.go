@@ -0,0 +1,29 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !piv
+// +build !piv
+
+package piv
+
+import (
+	"crypto"
+	"errors"
+)
+
+// Open always fails: PIV tokens are reached through PC/SC, which needs cgo
+// and is only built in with the "piv" build tag.
+func Open(opts Options, public crypto.PublicKey) (Signer, error) {
+	return nil, errors.New("Istio CA is built without PIV support: rebuild it with the \"piv\" build tag")
+}
@@ -0,0 +1,56 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package piv loads the signing key of Istio CA from the PIV applet of a
+// hardware token, e.g. a YubiKey, so that small deployments get hardware
+// protection of the key without an enterprise HSM. The key never leaves the
+// token; Istio CA only asks the token to sign.
+package piv
+
+import (
+	"crypto"
+	"io"
+)
+
+// Slots lists the names of the PIV slots that can hold the signing key.
+var Slots = []string{"9a", "9c", "9d", "9e"}
+
+// Options selects the token and the key to sign with.
+type Options struct {
+	// A case-insensitive substring of the name of the smart card reader of
+	// the token; the first YubiKey if empty.
+	Card string
+
+	// The slot holding the key, one of Slots.
+	Slot string
+
+	// The PIN of the token, if the PIN policy of the key requires it.
+	PIN string
+}
+
+// Signer signs with the key on a token, and releases the token on Close.
+type Signer interface {
+	crypto.Signer
+	io.Closer
+}
+
+// ValidSlot returns true if the key can be held in the named slot.
+func ValidSlot(slot string) bool {
+	for _, s := range Slots {
+		if s == slot {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package piv
+
+import "testing"
+
+func TestValidSlot(t *testing.T) {
+	testCases := map[string]struct {
+		slot     string
+		expected bool
+	}{
+		"Signature slot":      {slot: "9c", expected: true},
+		"Card authentication": {slot: "9e", expected: true},
+		"Retired key slot":    {slot: "82"},
+		"Upper-case slot":     {slot: "9C"},
+		"Empty slot":          {slot: ""},
+	}
+	for id, tc := range testCases {
+		if actual := ValidSlot(tc.slot); actual != tc.expected {
+			t.Errorf("%s: expected %t, got %t", id, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build piv
+// +build piv
+
+package piv
+
+import (
+	"crypto"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/go-piv/piv-go/piv"
+)
+
+var slots = map[string]piv.Slot{
+	"9a": piv.SlotAuthentication,
+	"9c": piv.SlotSignature,
+	"9d": piv.SlotKeyManagement,
+	"9e": piv.SlotCardAuthentication,
+}
+
+// yubiKeySigner serializes the signatures, as a token handles one
+// transaction at a time.
+type yubiKeySigner struct {
+	mutex sync.Mutex
+	yk    *piv.YubiKey
+	key   crypto.Signer
+}
+
+// Open returns a Signer for the key in the slot of the token, which must be
+// the key of the public key, e.g. that of the signing certificate.
+func Open(opts Options, public crypto.PublicKey) (Signer, error) {
+	slot, ok := slots[opts.Slot]
+	if !ok {
+		return nil, fmt.Errorf("invalid PIV slot %q: expect one of %v", opts.Slot, Slots)
+	}
+	card, err := findCard(opts.Card)
+	if err != nil {
+		return nil, err
+	}
+	yk, err := piv.Open(card)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the PIV token %q (error: %v)", card, err)
+	}
+
+	priv, err := yk.PrivateKey(slot, public, piv.KeyAuth{PIN: opts.PIN})
+	if err != nil {
+		yk.Close() // nolint: errcheck
+		return nil, fmt.Errorf("failed to access the key in PIV slot %s (error: %v)", opts.Slot, err)
+	}
+	key, ok := priv.(crypto.Signer)
+	if !ok {
+		yk.Close() // nolint: errcheck
+		return nil, fmt.Errorf("the key in PIV slot %s cannot sign", opts.Slot)
+	}
+	return &yubiKeySigner{yk: yk, key: key}, nil
+}
+
+// findCard returns the name of the first smart card reader containing name,
+// or a YubiKey if name is empty.
+func findCard(name string) (string, error) {
+	if name == "" {
+		name = "yubikey"
+	}
+	cards, err := piv.Cards()
+	if err != nil {
+		return "", fmt.Errorf("failed to list the smart cards (error: %v)", err)
+	}
+	for _, card := range cards {
+		if strings.Contains(strings.ToLower(card), strings.ToLower(name)) {
+			return card, nil
+		}
+	}
+	return "", fmt.Errorf("no smart card matches %q among %v", name, cards)
+}
+
+// Public implements crypto.Signer.
+func (s *yubiKeySigner) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+// Sign implements crypto.Signer.
+func (s *yubiKeySigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.key.Sign(rand, digest, opts)
+}
+
+// Close implements io.Closer.
+func (s *yubiKeySigner) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.yk.Close()
+}
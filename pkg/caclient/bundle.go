@@ -0,0 +1,207 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/auth/pkg/spiffe"
+)
+
+// RotationListener is invoked with every certificate a KeyCertBundleRotator
+// rotates to.
+type RotationListener func(cert *Certificate)
+
+// KeyCertBundleRotator keeps the certificate of a Client rotated in process,
+// and serves the current bundle of the certificate, its key and the root
+// certificates to crypto/tls. When a rotation fails, it keeps serving the
+// previous bundle until the certificate expires.
+type KeyCertBundleRotator struct {
+	// The additional trusted issuers whose certificates peers may present,
+	// e.g. during a migration from a legacy PKI (see spiffe.TrustedIssuers).
+	TrustedIssuers spiffe.TrustedIssuers
+
+	client *Client
+
+	mutex     sync.RWMutex
+	cert      *Certificate
+	tlsCert   *tls.Certificate
+	roots     *x509.CertPool
+	listeners []RotationListener
+
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+// NewKeyCertBundleRotator returns a pointer to a KeyCertBundleRotator that
+// rotates the certificate through the client once Run is called.
+func NewKeyCertBundleRotator(client *Client) *KeyCertBundleRotator {
+	return &KeyCertBundleRotator{client: client, ready: make(chan struct{})}
+}
+
+// Run rotates the certificate until stopCh is closed.
+func (r *KeyCertBundleRotator) Run(stopCh <-chan struct{}) {
+	r.client.Rotate(stopCh, r.update, nil)
+}
+
+// Ready returns a channel that is closed once the first certificate is issued.
+func (r *KeyCertBundleRotator) Ready() <-chan struct{} {
+	return r.ready
+}
+
+// AddRotationListener registers a listener invoked after every rotation.
+// Listeners are invoked synchronously and must not block.
+func (r *KeyCertBundleRotator) AddRotationListener(l RotationListener) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.listeners = append(r.listeners, l)
+}
+
+// Current returns the current certificate, or nil if none is issued yet or
+// the last one has expired.
+func (r *KeyCertBundleRotator) Current() *Certificate {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if r.cert == nil || !time.Now().Before(r.cert.NotAfter) {
+		return nil
+	}
+	return r.cert
+}
+
+// GetTLSConfig returns a tls.Config presenting the current certificate, as a
+// server or as a client, and requiring the peer to present a certificate
+// chained to the current root certificates. It is TLSConfig(nil).
+func (r *KeyCertBundleRotator) GetTLSConfig() *tls.Config {
+	return r.TLSConfig(nil)
+}
+
+// TLSConfig returns a copy of the base config, which may be nil, whose hooks
+// present the current certificate, as a server or as a client, and require
+// the peer to present a certificate chained to the current root
+// certificates. Connections made with the config pick up rotated
+// certificates and root certificates, so servers and clients keep working
+// with the same config across rotations. The static certificates and root
+// certificates of the base config are ignored, and its VerifyPeerCertificate,
+// if any, runs after the chain is verified, e.g. a spiffe.NewVerifier
+// authorizing specific identities. Peers are verified as SPIFFE identities with URI SANs, so the
+// host name of a server is not checked.
+func (r *KeyCertBundleRotator) TLSConfig(base *tls.Config) *tls.Config {
+	config := &tls.Config{}
+	if base != nil {
+		config = base.Clone()
+	}
+	config.Certificates = nil
+	config.NameToCertificate = nil
+	config.RootCAs = nil
+	config.ClientCAs = nil
+	config.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return r.getTLSCertificate()
+	}
+	config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return r.getTLSCertificate()
+	}
+	config.ClientAuth = tls.RequireAnyClientCert
+	// The peer certificate is verified by verifyPeerCertificate rather than against a host name.
+	config.InsecureSkipVerify = true // nolint: gas
+
+	verify := config.VerifyPeerCertificate
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		verified, err := r.verifyPeerCertificate(rawCerts)
+		if err != nil || verify == nil {
+			return err
+		}
+		return verify(rawCerts, verified)
+	}
+	return config
+}
+
+func (r *KeyCertBundleRotator) getTLSCertificate() (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if r.cert == nil || !time.Now().Before(r.cert.NotAfter) {
+		return nil, errors.New("no valid certificate is issued")
+	}
+	return r.tlsCert, nil
+}
+
+// verifyPeerCertificate verifies the chain presented by the peer against the
+// current root certificates, and returns the verified chains.
+func (r *KeyCertBundleRotator) verifyPeerCertificate(rawCerts [][]byte) ([][]*x509.Certificate, error) {
+	r.mutex.RLock()
+	roots := r.roots
+	r.mutex.RUnlock()
+	if roots == nil {
+		return nil, errors.New("no root certificates are issued")
+	}
+	if len(rawCerts) == 0 {
+		return nil, errors.New("the peer presented no certificate")
+	}
+
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the peer certificate (error: %v)", err)
+		}
+		certs[i] = cert
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		if _, issuerErr := r.TrustedIssuers.Issuer(certs); issuerErr == nil {
+			return [][]*x509.Certificate{certs}, nil
+		}
+	}
+	return chains, err
+}
+
+func (r *KeyCertBundleRotator) update(cert *Certificate) {
+	tlsCert, err := cert.TLSCertificate()
+	if err != nil {
+		glog.Errorf("Failed to load the rotated certificate for %s (error: %v)", r.client.opts.ID, err)
+		return
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(cert.RootCert) {
+		glog.Errorf("Failed to parse the root certificates rotated with the certificate for %s", r.client.opts.ID)
+		return
+	}
+
+	r.mutex.Lock()
+	r.cert = cert
+	r.tlsCert = &tlsCert
+	r.roots = roots
+	listeners := r.listeners
+	r.mutex.Unlock()
+
+	r.readyOnce.Do(func() { close(r.ready) })
+	for _, l := range listeners {
+		l(cert)
+	}
+}
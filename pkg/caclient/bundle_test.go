@@ -0,0 +1,255 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager/testutil"
+	"istio.io/auth/csr"
+	"istio.io/auth/pkg/spiffe"
+)
+
+// startRotator runs a KeyCertBundleRotator against a test server backed by a
+// CA issuing certificates valid for certTTL, and waits for it to be ready.
+func startRotator(t *testing.T, certTTL time.Duration, stopCh chan struct{}) *KeyCertBundleRotator {
+	ca := testutil.NewCA(t, certTTL)
+	addr, stop := serveTestServer(t, ca, false, false)
+	c, err := Dial(addr, "istio-ca", ca.GetRootCertificate(), Options{
+		ID:             testID,
+		Credential:     func() (string, error) { return "token", nil },
+		CredentialType: csr.CredentialTypeKubernetes,
+	})
+	if err != nil {
+		t.Fatalf("Failed to dial the CA: %v", err)
+	}
+	go func() {
+		<-stopCh
+		c.Close() // nolint: errcheck
+		stop()
+	}()
+
+	r := NewKeyCertBundleRotator(c)
+	if r.Current() != nil {
+		t.Error("A certificate is available before the rotator runs")
+	}
+	go r.Run(stopCh)
+	select {
+	case <-r.Ready():
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for the first certificate")
+	}
+	return r
+}
+
+// handshake runs a TLS handshake between the configs over an in-memory
+// connection, and returns the errors of the server and the client.
+func handshake(server, client *tls.Config) (error, error) {
+	_, serverErr, clientErr := handshakeState(server, client)
+	return serverErr, clientErr
+}
+
+// handshakeState is like handshake, and also returns the connection state
+// of the client. The connection is a loopback TCP connection rather than a
+// net.Pipe, whose unbuffered writes would block the server sending an alert
+// after a TLS 1.3 client has completed the handshake.
+func handshakeState(server, client *tls.Config) (tls.ConnectionState, error, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return tls.ConnectionState{}, err, err
+	}
+	defer lis.Close() // nolint: errcheck
+
+	errCh := make(chan error, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer conn.Close() // nolint: errcheck
+		errCh <- tls.Server(conn, server).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", lis.Addr().String(), client)
+	if err != nil {
+		return tls.ConnectionState{}, <-errCh, err
+	}
+	defer conn.Close() // nolint: errcheck
+	return conn.ConnectionState(), <-errCh, nil
+}
+
+func TestKeyCertBundleRotator(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	r := startRotator(t, 2*time.Second, stopCh)
+
+	rotated := make(chan *Certificate, 10)
+	r.AddRotationListener(func(cert *Certificate) { rotated <- cert })
+	first := r.Current()
+	if first == nil {
+		t.Fatal("No certificate is available once the rotator is ready")
+	}
+
+	select {
+	case cert := <-rotated:
+		if string(cert.CertChain) == string(first.CertChain) {
+			t.Error("The listener is invoked with the same certificate")
+		}
+		if r.Current() != cert {
+			t.Error("The current certificate is not the rotated one")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for a rotation")
+	}
+}
+
+func TestKeyCertBundleRotatorTLSConfig(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	r := startRotator(t, time.Hour, stopCh)
+	other := startRotator(t, time.Hour, stopCh)
+	// trusting and mutual accept the certificates of each other as additional trusted issuers.
+	trusting, mutual := startRotator(t, time.Hour, stopCh), startRotator(t, time.Hour, stopCh)
+	for _, p := range [][2]*KeyCertBundleRotator{{trusting, mutual}, {mutual, trusting}} {
+		issuer, err := spiffe.NewTrustedIssuer("other", p[1].Current().RootCert, "spiffe://cluster.local/ns/foo/*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p[0].TrustedIssuers = spiffe.TrustedIssuers{issuer}
+	}
+
+	testCases := map[string]struct {
+		server, client *KeyCertBundleRotator
+		expectSuccess  bool
+	}{
+		"Same CA": {
+			server:        r,
+			client:        r,
+			expectSuccess: true,
+		},
+		"Different CAs": {
+			server: r,
+			client: other,
+		},
+		"Additional trusted issuers": {
+			server:        trusting,
+			client:        mutual,
+			expectSuccess: true,
+		},
+		"Additional trusted issuer of the server only": {
+			server: trusting,
+			client: other,
+		},
+	}
+
+	for k, tc := range testCases {
+		serverErr, clientErr := handshake(tc.server.GetTLSConfig(), tc.client.GetTLSConfig())
+		if tc.expectSuccess && (serverErr != nil || clientErr != nil) {
+			t.Errorf("%s: the handshake failed (server: %v, client: %v)", k, serverErr, clientErr)
+		}
+		if !tc.expectSuccess && serverErr == nil && clientErr == nil {
+			t.Errorf("%s: the handshake succeeded unexpectedly", k)
+		}
+	}
+}
+
+func TestKeyCertBundleRotatorNotReady(t *testing.T) {
+	r := NewKeyCertBundleRotator(nil)
+	serverErr, clientErr := handshake(r.GetTLSConfig(), &tls.Config{InsecureSkipVerify: true})
+	if serverErr == nil || clientErr == nil {
+		t.Errorf("The handshake succeeded without a certificate (server: %v, client: %v)", serverErr, clientErr)
+	}
+}
+
+func TestKeyCertBundleRotatorTLSConfigRotation(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	r := startRotator(t, 2*time.Second, stopCh)
+	rotated := make(chan *Certificate, 10)
+	r.AddRotationListener(func(cert *Certificate) { rotated <- cert })
+
+	server, client := r.TLSConfig(nil), r.TLSConfig(nil)
+	state, serverErr, clientErr := handshakeState(server, client)
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("The handshake failed (server: %v, client: %v)", serverErr, clientErr)
+	}
+	first := state.PeerCertificates[0].SerialNumber
+
+	select {
+	case <-rotated:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for a rotation")
+	}
+	state, serverErr, clientErr = handshakeState(server, client)
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("The handshake failed after the rotation (server: %v, client: %v)", serverErr, clientErr)
+	}
+	if state.PeerCertificates[0].SerialNumber.Cmp(first) == 0 {
+		t.Error("The config keeps serving the certificate from before the rotation")
+	}
+}
+
+func TestKeyCertBundleRotatorTLSConfigBase(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	r := startRotator(t, time.Hour, stopCh)
+
+	testCases := map[string]struct {
+		verify        func([][]byte, [][]*x509.Certificate) error
+		expectSuccess bool
+	}{
+		"Authorized peer": {
+			verify: func(_ [][]byte, chains [][]*x509.Certificate) error {
+				if len(chains) == 0 || len(chains[0][0].URIs) != 1 || chains[0][0].URIs[0].String() != testID {
+					return errors.New("unauthorized peer")
+				}
+				return nil
+			},
+			expectSuccess: true,
+		},
+		"Unauthorized peer": {
+			verify:        func([][]byte, [][]*x509.Certificate) error { return errors.New("unauthorized peer") },
+			expectSuccess: false,
+		},
+	}
+
+	for k, tc := range testCases {
+		base := &tls.Config{
+			NextProtos:            []string{"h2"},
+			Certificates:          []tls.Certificate{{}},
+			VerifyPeerCertificate: tc.verify,
+		}
+		server := r.TLSConfig(base)
+		if len(base.Certificates) != 1 || base.GetCertificate != nil {
+			t.Errorf("%s: the base config is modified", k)
+		}
+		state, serverErr, clientErr := handshakeState(server, r.TLSConfig(&tls.Config{NextProtos: []string{"h2"}}))
+		if tc.expectSuccess {
+			if serverErr != nil || clientErr != nil {
+				t.Errorf("%s: the handshake failed (server: %v, client: %v)", k, serverErr, clientErr)
+			} else if state.NegotiatedProtocol != "h2" {
+				t.Errorf("%s: unexpected negotiated protocol %q (expecting h2)", k, state.NegotiatedProtocol)
+			}
+		} else if serverErr == nil {
+			t.Errorf("%s: the server accepted the peer", k)
+		}
+	}
+}
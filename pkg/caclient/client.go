@@ -0,0 +1,265 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package caclient requests workload certificates from the CSR API of the
+// Istio CA, so that Go programs can obtain mesh identities without running
+// a node agent. A Client generates the keys and certificate signing requests
+// itself, retries transient failures, and can keep a certificate rotated.
+package caclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+
+	"istio.io/auth/api/csr/v1alpha1"
+	"istio.io/auth/certmanager"
+	"istio.io/auth/csr"
+	"istio.io/auth/pkg/spiffe"
+)
+
+const (
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = time.Minute
+)
+
+// Options configures a Client.
+type Options struct {
+	// The SPIFFE URI of the identity to request certificates for, e.g.
+	// spiffe://cluster.local/ns/foo/sa/bar.
+	ID string
+
+	// The DNS names to request in addition to the identity. The CA must
+	// authorize them for the identity.
+	DNSNames []string
+
+	// Credential returns the credential authenticating the caller, e.g. the
+	// token of a Kubernetes service account. It is called before every
+	// request, so that expiring tokens can be refreshed.
+	Credential func() (string, error)
+
+	// The type of the credential, e.g. csr.CredentialTypeKubernetes. It may
+	// be empty if the CA accepts a single type of credential.
+	CredentialType string
+
+	// Whether to present a nonce from GetNonce in the certificate signing
+	// requests, which CAs requiring nonces reject requests without.
+	UseNonce bool
+
+	// The maximum number of attempts of a request, and the initial and the
+	// maximum backoff between them, which doubles after every failure. The
+	// defaults are 5 attempts, 1s and 1m.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Certificate is a certificate issued by the CA along with its key.
+type Certificate struct {
+	// The PEM-encoded certificate chain, leaf first.
+	CertChain []byte
+	// The PEM-encoded private key of the leaf certificate.
+	PrivateKey []byte
+	// The PEM-encoded root certificates to verify the chain and the peers with.
+	RootCert []byte
+
+	NotBefore, NotAfter time.Time
+	// The time to renew the certificate at, as suggested by the server or, if
+	// the server predates the suggestion, by certmanager.RenewalTime.
+	RenewAfter time.Time
+}
+
+// TLSCertificate returns the certificate and the key for tls.Config.
+func (c *Certificate) TLSCertificate() (tls.Certificate, error) {
+	return tls.X509KeyPair(c.CertChain, c.PrivateKey)
+}
+
+// Client requests certificates from the CSR API. It is safe for concurrent use.
+type Client struct {
+	client v1alpha1.CertificateServiceClient
+	conn   *grpc.ClientConn
+	id     *url.URL
+	opts   Options
+}
+
+// New returns a pointer to a Client requesting certificates through the
+// CSR API client.
+func New(client v1alpha1.CertificateServiceClient, opts Options) (*Client, error) {
+	id, err := spiffe.ParseID(opts.ID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity %q", opts.ID)
+	}
+	if opts.Credential == nil {
+		return nil, errors.New("no credential is configured")
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = defaultInitialBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaultMaxBackoff
+	}
+	return &Client{client: client, id: id, opts: opts}, nil
+}
+
+// Dial connects to the CSR API at the address, verifying the server against
+// the PEM-encoded root certificates, and returns a pointer to a Client
+// requesting certificates through the connection. The server name to verify
+// defaults to the host of the address.
+func Dial(address, serverName string, rootCert []byte, opts Options) (*Client, error) {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(rootCert) {
+		return nil, errors.New("failed to parse the root certificates")
+	}
+	creds := credentials.NewTLS(&tls.Config{RootCAs: roots, ServerName: serverName})
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s (error: %v)", address, err)
+	}
+	c, err := New(v1alpha1.NewCertificateServiceClient(conn), opts)
+	if err != nil {
+		conn.Close() // nolint: errcheck
+		return nil, err
+	}
+	c.conn = conn
+	return c, nil
+}
+
+// Close closes the connection opened by Dial, if any.
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// Request generates a key and has the CA issue a certificate for it,
+// retrying failures that may be transient. Errors from the server carry the
+// error code returned by csr.ErrorCode.
+func (c *Client) Request(ctx context.Context) (*Certificate, error) {
+	backoff := c.opts.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		cert, err := c.request(ctx)
+		if err == nil || attempt >= c.opts.MaxAttempts || !retryable(err) {
+			return cert, err
+		}
+		glog.Warningf("Certificate request for %s failed, retrying in %v (error: %v)", c.opts.ID, backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > c.opts.MaxBackoff {
+			backoff = c.opts.MaxBackoff
+		}
+	}
+}
+
+func (c *Client) request(ctx context.Context) (*Certificate, error) {
+	credential, err := c.opts.Credential()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the credential (error: %v)", err)
+	}
+
+	template := &x509.CertificateRequest{URIs: []*url.URL{c.id}, DNSNames: c.opts.DNSNames}
+	if c.opts.UseNonce {
+		resp, err := c.client.GetNonce(ctx, &v1alpha1.GetNonceRequest{})
+		if err != nil {
+			return nil, err
+		}
+		ext, err := csr.NonceExtension(resp.Nonce)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode the nonce (error: %v)", err)
+		}
+		template.ExtraExtensions = []pkix.Extension{ext}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the key (error: %v)", err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the certificate signing request (error: %v)", err)
+	}
+
+	resp, err := c.client.Sign(ctx, &v1alpha1.SignRequest{
+		CsrPem:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}),
+		Credential:     credential,
+		CredentialType: c.opts.CredentialType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(resp.CertChain)
+	if block == nil {
+		return nil, errors.New("invalid PEM encoding in the certificate chain")
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the certificate (error: %v)", err)
+	}
+	if pub, ok := leaf.PublicKey.(*ecdsa.PublicKey); !ok || pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+		return nil, errors.New("the certificate does not certify the requested key")
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the key (error: %v)", err)
+	}
+
+	renewAfter := certmanager.RenewalTime(leaf.NotBefore, leaf.NotAfter)
+	if resp.RenewAfter != 0 {
+		renewAfter = time.Unix(resp.RenewAfter, 0)
+	}
+	return &Certificate{
+		CertChain:  resp.CertChain,
+		PrivateKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		RootCert:   resp.RootCert,
+		NotBefore:  leaf.NotBefore,
+		NotAfter:   leaf.NotAfter,
+		RenewAfter: renewAfter,
+	}, nil
+}
+
+// retryable returns true if the error may go away by retrying the request.
+func retryable(err error) bool {
+	switch csr.ErrorCode(err) {
+	case v1alpha1.ErrorCode_BACKEND_UNAVAILABLE, v1alpha1.ErrorCode_RATE_LIMITED:
+		return true
+	case v1alpha1.ErrorCode_ERROR_CODE_UNSPECIFIED:
+		switch grpc.Code(err) {
+		case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded:
+			return true
+		}
+	}
+	return false
+}
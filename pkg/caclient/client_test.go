@@ -0,0 +1,210 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caclient
+
+import (
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"istio.io/auth/api/csr/v1alpha1"
+	"istio.io/auth/authn"
+	"istio.io/auth/certmanager"
+	"istio.io/auth/certmanager/testutil"
+	"istio.io/auth/csr"
+)
+
+const testID = "spiffe://cluster.local/ns/foo/sa/bar"
+
+// failingSigner fails to sign once fail is set.
+type failingSigner struct {
+	*certmanager.IstioCA
+	fail bool
+}
+
+func (s *failingSigner) Sign(csrPEM []byte) ([]byte, error) {
+	if s.fail {
+		return nil, certmanager.ErrInjectedFault
+	}
+	return s.IstioCA.Sign(csrPEM)
+}
+
+// serveTestServer serves the CSR API of a server backed by the CA on a
+// local port, and returns its address and a function stopping it. If
+// failSigning is true, the server fails to sign any certificate signing
+// request but keeps serving with its own TLS certificate.
+func serveTestServer(t *testing.T, ca *certmanager.IstioCA, requireNonce, failSigning bool) (string, func()) {
+	signer := &failingSigner{IstioCA: ca}
+	auth := map[string]authn.Authenticator{csr.CredentialTypeKubernetes: testutil.FakeAuthenticator{"token": testID}}
+	s := csr.NewServer(signer, auth, []string{"istio-ca"})
+	s.RequireNonce = requireNonce
+	if _, err := s.GetCertificate(nil); err != nil {
+		t.Fatalf("Failed to issue the TLS certificate of the server: %v", err)
+	}
+	signer.fail = failSigning
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(lis) // nolint: errcheck
+	return lis.Addr().String(), func() {
+		lis.Close() // nolint: errcheck
+	}
+}
+
+func TestRequest(t *testing.T) {
+	testCases := map[string]struct {
+		credential       string
+		useNonce         bool
+		requireNonce     bool
+		failSigning      bool
+		expectedCode     codes.Code
+		expectedErr      v1alpha1.ErrorCode
+		expectedAttempts int
+	}{
+		"Issued": {
+			credential:       "token",
+			expectedAttempts: 1,
+		},
+		"Issued with a nonce": {
+			credential:       "token",
+			useNonce:         true,
+			requireNonce:     true,
+			expectedAttempts: 1,
+		},
+		"Missing nonce": {
+			credential:       "token",
+			requireNonce:     true,
+			expectedCode:     codes.InvalidArgument,
+			expectedErr:      v1alpha1.ErrorCode_INVALID_CSR,
+			expectedAttempts: 1,
+		},
+		"Unauthenticated": {
+			credential:       "unknown",
+			expectedCode:     codes.Unauthenticated,
+			expectedErr:      v1alpha1.ErrorCode_UNAUTHENTICATED,
+			expectedAttempts: 1,
+		},
+		"Backend unavailable": {
+			credential:       "token",
+			failSigning:      true,
+			expectedCode:     codes.Unavailable,
+			expectedErr:      v1alpha1.ErrorCode_BACKEND_UNAVAILABLE,
+			expectedAttempts: 3,
+		},
+	}
+
+	for k, tc := range testCases {
+		ca := testutil.NewCA(t, time.Hour)
+		addr, stop := serveTestServer(t, ca, tc.requireNonce, tc.failSigning)
+
+		attempts := 0
+		c, err := Dial(addr, "istio-ca", ca.GetRootCertificate(), Options{
+			ID:             testID,
+			Credential:     func() (string, error) { attempts++; return tc.credential, nil },
+			CredentialType: csr.CredentialTypeKubernetes,
+			UseNonce:       tc.useNonce,
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to dial the CA: %v", k, err)
+		}
+
+		cert, err := c.Request(context.Background())
+		c.Close() // nolint: errcheck
+		stop()
+
+		if attempts != tc.expectedAttempts {
+			t.Errorf("%s: unexpected number of attempts %d (expecting %d)", k, attempts, tc.expectedAttempts)
+		}
+		if code := grpc.Code(err); code != tc.expectedCode {
+			t.Errorf("%s: unexpected code (expecting %v, actual %v: %v)", k, tc.expectedCode, code, err)
+			continue
+		}
+		if code := csr.ErrorCode(err); code != tc.expectedErr {
+			t.Errorf("%s: unexpected error code %v (expecting %v)", k, code, tc.expectedErr)
+		}
+		if err != nil {
+			continue
+		}
+
+		tlsCert, err := cert.TLSCertificate()
+		if err != nil {
+			t.Errorf("%s: the certificate does not match the key: %v", k, err)
+			continue
+		}
+		leaf, err := x509.ParseCertificate(tlsCert.Certificate[0])
+		if err != nil {
+			t.Fatalf("%s: failed to parse the certificate: %v", k, err)
+		}
+		if len(leaf.URIs) != 1 || leaf.URIs[0].String() != testID {
+			t.Errorf("%s: the certificate is issued for %v (expecting %s)", k, leaf.URIs, testID)
+		}
+		if string(cert.RootCert) != string(ca.GetRootCertificate()) {
+			t.Errorf("%s: unexpected root certificate %q", k, cert.RootCert)
+		}
+		if !cert.NotAfter.Equal(leaf.NotAfter) {
+			t.Errorf("%s: unexpected expiry %v (expecting %v)", k, cert.NotAfter, leaf.NotAfter)
+		}
+		if !cert.RenewAfter.After(leaf.NotBefore) || !cert.RenewAfter.Before(leaf.NotAfter) {
+			t.Errorf("%s: renewal time %v is not within the lifetime of the certificate [%v, %v]",
+				k, cert.RenewAfter, leaf.NotBefore, leaf.NotAfter)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	credential := func() (string, error) { return "token", nil }
+	testCases := map[string]struct {
+		opts        Options
+		expectedErr string
+	}{
+		"Valid": {
+			opts: Options{ID: testID, Credential: credential},
+		},
+		"Invalid identity": {
+			opts:        Options{ID: "bar", Credential: credential},
+			expectedErr: `invalid identity "bar"`,
+		},
+		"No credential": {
+			opts:        Options{ID: testID},
+			expectedErr: "no credential is configured",
+		},
+	}
+
+	for k, tc := range testCases {
+		c, err := New(nil, tc.opts)
+		if tc.expectedErr != "" {
+			if err == nil || err.Error() != tc.expectedErr {
+				t.Errorf("%s: unexpected error %v (expecting %q)", k, err, tc.expectedErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		if c.opts.MaxAttempts != defaultMaxAttempts || c.opts.InitialBackoff != defaultInitialBackoff ||
+			c.opts.MaxBackoff != defaultMaxBackoff {
+			t.Errorf("%s: the defaults are not applied to %+v", k, c.opts)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caclient
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+)
+
+// Rotate keeps a certificate rotated until stopCh is closed. It requests a
+// certificate, passes it to onRotate, and requests the next at its
+// RenewAfter time, as suggested by the server. Requests that fail despite the
+// retries are passed to onError, which may be nil, and tried again after the
+// maximum backoff.
+func (c *Client) Rotate(stopCh <-chan struct{}, onRotate func(*Certificate), onError func(error)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	for {
+		wait := c.opts.MaxBackoff
+		cert, err := c.Request(ctx)
+		if err != nil {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+			glog.Errorf("Failed to rotate the certificate for %s (error: %v)", c.opts.ID, err)
+			if onError != nil {
+				onError(err)
+			}
+		} else {
+			onRotate(cert)
+			wait = time.Until(cert.RenewAfter)
+			glog.Infof("Rotated the certificate for %s, next rotation in %v", c.opts.ID, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-stopCh:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caclient
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager/testutil"
+	"istio.io/auth/csr"
+)
+
+func TestRotate(t *testing.T) {
+	ca := testutil.NewCA(t, 2*time.Second)
+	addr, stop := serveTestServer(t, ca, false, false)
+	defer stop()
+
+	c, err := Dial(addr, "istio-ca", ca.GetRootCertificate(), Options{
+		ID:             testID,
+		Credential:     func() (string, error) { return "token", nil },
+		CredentialType: csr.CredentialTypeKubernetes,
+	})
+	if err != nil {
+		t.Fatalf("Failed to dial the CA: %v", err)
+	}
+	defer c.Close() // nolint: errcheck
+
+	rotated := make(chan *Certificate, 10)
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.Rotate(stopCh, func(cert *Certificate) { rotated <- cert }, nil)
+		close(done)
+	}()
+
+	var last *Certificate
+	for i := 0; i < 2; i++ {
+		select {
+		case cert := <-rotated:
+			if last != nil && string(cert.CertChain) == string(last.CertChain) {
+				t.Errorf("Rotation %d returned the same certificate", i)
+			}
+			last = cert
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for rotation %d", i)
+		}
+	}
+
+	close(stopCh)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Rotate did not return after stopCh was closed")
+	}
+}
+
+func TestRotateError(t *testing.T) {
+	c, err := New(nil, Options{
+		ID:         testID,
+		Credential: func() (string, error) { return "", errors.New("no token") },
+		MaxBackoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 10)
+	stopCh := make(chan struct{})
+	onError := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+	go c.Rotate(stopCh, func(*Certificate) { t.Error("Unexpected rotation") }, onError)
+	defer close(stopCh)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err.Error() != "failed to get the credential (error: no token)" {
+				t.Errorf("Unexpected error %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for failure %d", i)
+		}
+	}
+}
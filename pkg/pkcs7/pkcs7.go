@@ -0,0 +1,95 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pkcs7 encodes and parses the degenerate, certificates-only PKCS#7
+// SignedData of RFC 2315, which EST and SCEP distribute certificates in.
+package pkcs7
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+var (
+	oidData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+// contentInfo is the PKCS#7 ContentInfo of RFC 2315.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// signedData is the PKCS#7 SignedData of RFC 2315, without the optional CRLs.
+type signedData struct {
+	Version          int
+	DigestAlgorithms []asn1.RawValue `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue   `asn1:"optional,tag:0"`
+	SignerInfos      []asn1.RawValue `asn1:"set"`
+}
+
+// CertsOnly returns the DER encoding of the degenerate, certificates-only
+// PKCS#7 SignedData holding the DER-encoded certificates, which EST answers
+// /cacerts and the enrollments with (RFC 7030, section 4.1.3), and SCEP
+// GetCACert and CertRep.
+func CertsOnly(certs [][]byte) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates to encode")
+	}
+	var raw []byte
+	for _, cert := range certs {
+		raw = append(raw, cert...)
+	}
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []asn1.RawValue{},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      []asn1.RawValue{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// A RawValue is marshaled as is, so the explicit tag of the content is added here.
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
+
+// ParseCertsOnly returns the certificates of the DER-encoded, certificates-only
+// PKCS#7 SignedData.
+func ParseCertsOnly(der []byte) ([]*x509.Certificate, error) {
+	var ci contentInfo
+	if rest, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, fmt.Errorf("invalid content info (error: %v)", err)
+	} else if len(rest) > 0 {
+		return nil, errors.New("trailing data after the content info")
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("unexpected content type %v", ci.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("invalid signed data (error: %v)", err)
+	}
+	if len(sd.SignerInfos) != 0 {
+		return nil, errors.New("the signed data of a certificates-only PKCS#7 has signers")
+	}
+	return x509.ParseCertificates(sd.Certificates.Bytes)
+}
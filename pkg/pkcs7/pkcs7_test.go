@@ -0,0 +1,128 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkcs7
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newTestCert(t *testing.T, name string) *x509.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCertsOnly(t *testing.T) {
+	root := newTestCert(t, "root")
+	leaf := newTestCert(t, "leaf")
+
+	testCases := map[string]struct {
+		certs       []*x509.Certificate
+		expectedErr string
+	}{
+		"Single certificate": {
+			certs: []*x509.Certificate{root},
+		},
+		"Chain": {
+			certs: []*x509.Certificate{leaf, root},
+		},
+		"No certificate": {
+			expectedErr: "no certificates to encode",
+		},
+	}
+	for id, tc := range testCases {
+		var raw [][]byte
+		for _, c := range tc.certs {
+			raw = append(raw, c.Raw)
+		}
+		der, err := CertsOnly(raw)
+		if tc.expectedErr != "" {
+			if err == nil || err.Error() != tc.expectedErr {
+				t.Errorf("%s: expected error %q, got %v", id, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+		certs, err := ParseCertsOnly(der)
+		if err != nil {
+			t.Errorf("%s: failed to parse the certificates-only PKCS#7: %v", id, err)
+			continue
+		}
+		if len(certs) != len(tc.certs) {
+			t.Errorf("%s: expected %d certificates, got %d", id, len(tc.certs), len(certs))
+			continue
+		}
+		for i, c := range certs {
+			if !bytes.Equal(c.Raw, tc.certs[i].Raw) {
+				t.Errorf("%s: unexpected certificate %d", id, i)
+			}
+		}
+	}
+}
+
+func TestParseCertsOnly(t *testing.T) {
+	der, err := CertsOnly([][]byte{newTestCert(t, "root").Raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		der         []byte
+		expectedErr bool
+	}{
+		"Certificates-only PKCS#7": {
+			der: der,
+		},
+		"Trailing data": {
+			der:         append(append([]byte{}, der...), 0),
+			expectedErr: true,
+		},
+		"Not DER": {
+			der:         []byte("certificates"),
+			expectedErr: true,
+		},
+	}
+	for id, tc := range testCases {
+		if _, err := ParseCertsOnly(tc.der); (err != nil) != tc.expectedErr {
+			t.Errorf("%s: unexpected error (expecting an error: %v, actual %v)", id, tc.expectedErr, err)
+		}
+	}
+}
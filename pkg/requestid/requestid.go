@@ -0,0 +1,53 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package requestid generates the IDs that correlate the log lines, audit
+// records and artifacts of a single certificate issuance, from the
+// controller event or the API call that triggers it to the secret or the
+// response that delivers the certificate.
+package requestid
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+)
+
+// MetadataKey is the key of the gRPC response header carrying the request ID.
+const MetadataKey = "x-request-id"
+
+type contextKey struct{}
+
+// New returns a random request ID of 16 hex digits.
+func New() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		glog.Errorf("Failed to generate a random request ID (error: %v)", err)
+	}
+	return fmt.Sprintf("%x", b)
+}
+
+// NewContext returns a copy of the context carrying the request ID.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID that the context carries, or an empty
+// string if it carries none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
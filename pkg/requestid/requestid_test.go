@@ -0,0 +1,41 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requestid
+
+import (
+	"regexp"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestNew(t *testing.T) {
+	id := New()
+	if !regexp.MustCompile("^[0-9a-f]{16}$").MatchString(id) {
+		t.Errorf("Unexpected request ID %q", id)
+	}
+	if other := New(); other == id {
+		t.Errorf("Expect distinct request IDs, got %q twice", id)
+	}
+}
+
+func TestContext(t *testing.T) {
+	if id := FromContext(context.Background()); id != "" {
+		t.Errorf("Unexpected request ID %q in an empty context", id)
+	}
+	if id := FromContext(NewContext(context.Background(), "0123456789abcdef")); id != "0123456789abcdef" {
+		t.Errorf("Unexpected request ID %q (expected 0123456789abcdef)", id)
+	}
+}
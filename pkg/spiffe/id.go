@@ -0,0 +1,107 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package spiffe verifies the SPIFFE identities of TLS peers, so that Go
+// services can enforce mesh identity without a sidecar proxy.
+package spiffe
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Scheme is the URI scheme of SPIFFE IDs.
+const Scheme = "spiffe"
+
+// ParseID parses a SPIFFE ID, e.g. spiffe://cluster.local/ns/foo/sa/bar,
+// which must have a trust domain and no user info, port, query or fragment.
+func ParseID(id string) (*url.URL, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SPIFFE ID %q (error: %v)", id, err)
+	}
+	if u.Scheme != Scheme || u.Host == "" || u.Port() != "" || u.User != nil || u.RawQuery != "" ||
+		u.Fragment != "" || u.Opaque != "" {
+		return nil, fmt.Errorf("invalid SPIFFE ID %q", id)
+	}
+	return u, nil
+}
+
+// ParseIstioID parses the SPIFFE ID of an Istio workload, which must be of the
+// exact form spiffe://<trust domain>/ns/<namespace>/sa/<service account>, and
+// returns its namespace and service account.
+func ParseIstioID(id string) (namespace, serviceAccount string, err error) {
+	u, err := ParseID(id)
+	if err != nil {
+		return "", "", err
+	}
+	parts := strings.Split(u.Path, "/")
+	if u.RawPath != "" || len(parts) != 5 || parts[0] != "" || parts[1] != "ns" || parts[3] != "sa" ||
+		!validName(parts[2]) || !validName(parts[4]) {
+		return "", "", fmt.Errorf("%q is not an Istio identity of the form %s://<trust domain>/ns/<namespace>/sa/<name>",
+			id, Scheme)
+	}
+	return parts[2], parts[4], nil
+}
+
+// validName returns true if the path segment may be a namespace or service
+// account name, which excludes empty, relative and wildcard segments.
+func validName(segment string) bool {
+	return segment != "" && segment != "." && segment != ".." && !strings.Contains(segment, "*")
+}
+
+// CertificateID returns the SPIFFE ID of the certificate, which must be its
+// only URI SAN.
+func CertificateID(cert *x509.Certificate) (string, error) {
+	if len(cert.URIs) != 1 {
+		return "", errors.New("the certificate must have a single URI SAN")
+	}
+	id := cert.URIs[0].String()
+	if _, err := ParseID(id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Matcher matches SPIFFE IDs against patterns, each of which is either a
+// SPIFFE ID, or a prefix of SPIFFE IDs followed by "*", e.g.
+// "spiffe://cluster.local/ns/foo/*".
+type Matcher []string
+
+// NewMatcher returns a Matcher of the patterns, which must be valid.
+func NewMatcher(patterns ...string) (Matcher, error) {
+	for _, p := range patterns {
+		if !strings.HasPrefix(p, Scheme+"://") || strings.Contains(strings.TrimSuffix(p, "*"), "*") {
+			return nil, fmt.Errorf("invalid pattern %q: expect a SPIFFE ID, optionally followed by '*'", p)
+		}
+	}
+	return Matcher(patterns), nil
+}
+
+// Matches returns whether the ID matches any of the patterns.
+func (m Matcher) Matches(id string) bool {
+	for _, pattern := range m {
+		if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern {
+			if strings.HasPrefix(id, prefix) {
+				return true
+			}
+		} else if pattern == id {
+			return true
+		}
+	}
+	return false
+}
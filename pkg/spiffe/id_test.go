@@ -0,0 +1,153 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spiffe
+
+import (
+	"crypto/x509"
+	"net/url"
+	"testing"
+)
+
+func TestParseID(t *testing.T) {
+	testCases := map[string]struct {
+		id          string
+		expectValid bool
+	}{
+		"Service account": {id: "spiffe://cluster.local/ns/foo/sa/bar", expectValid: true},
+		"Trust domain":    {id: "spiffe://cluster.local", expectValid: true},
+		"Wrong scheme":    {id: "https://cluster.local/ns/foo/sa/bar"},
+		"No trust domain": {id: "spiffe:///ns/foo/sa/bar"},
+		"Port":            {id: "spiffe://cluster.local:8080/ns/foo"},
+		"User info":       {id: "spiffe://user@cluster.local/ns/foo"},
+		"Query":           {id: "spiffe://cluster.local/ns/foo?sa=bar"},
+		"Fragment":        {id: "spiffe://cluster.local/ns/foo#bar"},
+		"Opaque":          {id: "spiffe:cluster.local"},
+	}
+
+	for k, tc := range testCases {
+		_, err := ParseID(tc.id)
+		if tc.expectValid && err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+		}
+		if !tc.expectValid && err == nil {
+			t.Errorf("%s: %s is accepted", k, tc.id)
+		}
+	}
+}
+
+func TestParseIstioID(t *testing.T) {
+	testCases := map[string]struct {
+		id                     string
+		expectedNamespace      string
+		expectedServiceAccount string
+	}{
+		"Service account": {
+			id:                     "spiffe://cluster.local/ns/foo/sa/bar",
+			expectedNamespace:      "foo",
+			expectedServiceAccount: "bar",
+		},
+		"Trust domain":     {id: "spiffe://cluster.local"},
+		"Namespace":        {id: "spiffe://cluster.local/ns/foo"},
+		"Extra segment":    {id: "spiffe://cluster.local/ns/foo/sa/bar/baz"},
+		"Trailing slash":   {id: "spiffe://cluster.local/ns/foo/sa/bar/"},
+		"Empty namespace":  {id: "spiffe://cluster.local/ns//sa/bar"},
+		"Relative segment": {id: "spiffe://cluster.local/ns/../sa/bar"},
+		"Wildcard":         {id: "spiffe://cluster.local/ns/foo/sa/*"},
+		"Escaped slash":    {id: "spiffe://cluster.local/ns/foo%2Fsa/sa/bar"},
+		"Wrong scheme":     {id: "https://cluster.local/ns/foo/sa/bar"},
+		"Other path":       {id: "spiffe://cluster.local/workload/foo/sa/bar"},
+	}
+
+	for k, tc := range testCases {
+		namespace, serviceAccount, err := ParseIstioID(tc.id)
+		if tc.expectedNamespace == "" {
+			if err == nil {
+				t.Errorf("%s: %s is accepted", k, tc.id)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+		} else if namespace != tc.expectedNamespace || serviceAccount != tc.expectedServiceAccount {
+			t.Errorf("%s: expect %s/%s but got %s/%s", k, tc.expectedNamespace, tc.expectedServiceAccount,
+				namespace, serviceAccount)
+		}
+	}
+}
+
+func TestCertificateID(t *testing.T) {
+	parse := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	testCases := map[string]struct {
+		uris       []*url.URL
+		expectedID string
+	}{
+		"SPIFFE ID": {
+			uris:       []*url.URL{parse("spiffe://cluster.local/ns/foo/sa/bar")},
+			expectedID: "spiffe://cluster.local/ns/foo/sa/bar",
+		},
+		"No URI SAN": {},
+		"Multiple URI SANs": {
+			uris: []*url.URL{parse("spiffe://cluster.local/ns/foo/sa/bar"), parse("spiffe://cluster.local/ns/foo/sa/baz")},
+		},
+		"Not a SPIFFE ID": {
+			uris: []*url.URL{parse("https://example.com")},
+		},
+	}
+
+	for k, tc := range testCases {
+		id, err := CertificateID(&x509.Certificate{URIs: tc.uris})
+		if id != tc.expectedID {
+			t.Errorf("%s: unexpected ID %q (expecting %q)", k, id, tc.expectedID)
+		}
+		if (err == nil) != (tc.expectedID != "") {
+			t.Errorf("%s: unexpected error %v", k, err)
+		}
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	m, err := NewMatcher("spiffe://cluster.local/ns/foo/sa/bar", "spiffe://cluster.local/ns/baz/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := map[string]struct {
+		id            string
+		expectMatches bool
+	}{
+		"Exact":          {id: "spiffe://cluster.local/ns/foo/sa/bar", expectMatches: true},
+		"Prefix":         {id: "spiffe://cluster.local/ns/baz/sa/qux", expectMatches: true},
+		"Other account":  {id: "spiffe://cluster.local/ns/foo/sa/qux"},
+		"Exact is exact": {id: "spiffe://cluster.local/ns/foo/sa/bar/baz"},
+		"Other domain":   {id: "spiffe://example.com/ns/baz/sa/qux"},
+	}
+
+	for k, tc := range testCases {
+		if matches := m.Matches(tc.id); matches != tc.expectMatches {
+			t.Errorf("%s: Matches(%s) = %v (expecting %v)", k, tc.id, matches, tc.expectMatches)
+		}
+	}
+
+	for _, p := range []string{"cluster.local/ns/foo", "spiffe://cluster.local/*/sa/bar", "https://example.com/*"} {
+		if _, err := NewMatcher(p); err == nil {
+			t.Errorf("Invalid pattern %q is accepted", p)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spiffe
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// TrustedIssuer is an external CA, e.g. a legacy PKI being migrated from,
+// whose certificates are accepted as mesh identities in addition to those
+// chained to the trust bundle.
+type TrustedIssuer struct {
+	// The name of the issuer, for logs and reports.
+	Name string `json:"name"`
+
+	// The PEM-encoded root certificates of the issuer.
+	RootCert string `json:"rootCert"`
+
+	// The patterns of the SPIFFE IDs that the issuer is trusted for (see
+	// Matcher). Restricting them keeps a compromised legacy issuer from
+	// vouching for identities outside of the migrating workloads.
+	IDs []string `json:"ids"`
+
+	roots   *x509.CertPool
+	matcher Matcher
+}
+
+// TrustedIssuers is a list of additional trusted issuers.
+type TrustedIssuers []*TrustedIssuer
+
+// LoadTrustedIssuers reads TrustedIssuers from a JSON file holding an array
+// of issuers, e.g. [{"name": "legacy", "rootCert": "<PEM>", "ids":
+// ["spiffe://cluster.local/ns/legacy/*"]}].
+func LoadTrustedIssuers(path string) (TrustedIssuers, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	issuers := TrustedIssuers{}
+	if err := json.Unmarshal(bs, &issuers); err != nil {
+		return nil, fmt.Errorf("invalid trusted issuers in %s (error: %v)", path, err)
+	}
+	for _, i := range issuers {
+		if err := i.init(); err != nil {
+			return nil, fmt.Errorf("invalid trusted issuer in %s (error: %v)", path, err)
+		}
+	}
+	return issuers, nil
+}
+
+// NewTrustedIssuer returns a pointer to a TrustedIssuer with the PEM-encoded
+// root certificates, trusted for the SPIFFE IDs matching any of the patterns.
+func NewTrustedIssuer(name string, rootCert []byte, patterns ...string) (*TrustedIssuer, error) {
+	i := &TrustedIssuer{Name: name, RootCert: string(rootCert), IDs: patterns}
+	if err := i.init(); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+func (i *TrustedIssuer) init() error {
+	if i.Name == "" {
+		return errors.New("the issuer has no name")
+	}
+	if len(i.IDs) == 0 {
+		return fmt.Errorf("issuer %s is not trusted for any SPIFFE ID", i.Name)
+	}
+	matcher, err := NewMatcher(i.IDs...)
+	if err != nil {
+		return fmt.Errorf("issuer %s has an %v", i.Name, err)
+	}
+	i.roots = x509.NewCertPool()
+	if !i.roots.AppendCertsFromPEM([]byte(i.RootCert)) {
+		return fmt.Errorf("issuer %s has no valid root certificate", i.Name)
+	}
+	i.matcher = matcher
+	return nil
+}
+
+// Issuer returns the name of the issuer that vouches for the leaf of the
+// chain, i.e. the leaf chains to its roots through the rest of the chain and
+// carries a SPIFFE ID that the issuer is trusted for, or an error if none does.
+func (ti TrustedIssuers) Issuer(certs []*x509.Certificate) (string, error) {
+	if len(certs) == 0 {
+		return "", errors.New("the certificate chain is empty")
+	}
+	id, err := CertificateID(certs[0])
+	if err != nil {
+		return "", err
+	}
+	for _, i := range ti {
+		if i.matcher.Matches(id) && verifyChain(certs, i.roots) == nil {
+			return i.Name, nil
+		}
+	}
+	return "", fmt.Errorf("no trusted issuer vouches for %s", id)
+}
+
+// verifyChain verifies that the leaf of the chain chains to one of the roots
+// through the rest of the chain.
+func verifyChain(certs []*x509.Certificate, roots *x509.CertPool) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
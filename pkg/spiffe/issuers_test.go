@@ -0,0 +1,134 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spiffe
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadTrustedIssuers(t *testing.T) {
+	ca := newTestCA(t)
+	testCases := map[string]struct {
+		content     string
+		expectedErr string
+	}{
+		"Valid": {
+			content: `[{"name": "legacy", "rootCert": ` + quote(t, string(ca.pem)) +
+				`, "ids": ["spiffe://cluster.local/ns/legacy/*"]}]`,
+		},
+		"Malformed": {
+			content:     `{"name": "legacy"}`,
+			expectedErr: "invalid trusted issuers",
+		},
+		"No name": {
+			content:     `[{"rootCert": ` + quote(t, string(ca.pem)) + `, "ids": ["spiffe://cluster.local/*"]}]`,
+			expectedErr: "the issuer has no name",
+		},
+		"No IDs": {
+			content:     `[{"name": "legacy", "rootCert": ` + quote(t, string(ca.pem)) + `}]`,
+			expectedErr: "issuer legacy is not trusted for any SPIFFE ID",
+		},
+		"Invalid pattern": {
+			content:     `[{"name": "legacy", "rootCert": ` + quote(t, string(ca.pem)) + `, "ids": ["legacy/*"]}]`,
+			expectedErr: `invalid pattern "legacy/*"`,
+		},
+		"No root certificate": {
+			content:     `[{"name": "legacy", "rootCert": "foo", "ids": ["spiffe://cluster.local/*"]}]`,
+			expectedErr: "issuer legacy has no valid root certificate",
+		},
+	}
+
+	for k, tc := range testCases {
+		f, err := ioutil.TempFile("", "issuers")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteString(tc.content); err != nil {
+			t.Fatal(err)
+		}
+		f.Close() // nolint: errcheck
+
+		issuers, err := LoadTrustedIssuers(f.Name())
+		os.Remove(f.Name()) // nolint: errcheck
+		if tc.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: unexpected error %v (expecting %q)", k, err, tc.expectedErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		if len(issuers) != 1 || issuers[0].Name != "legacy" {
+			t.Errorf("%s: unexpected issuers %v", k, issuers)
+		}
+	}
+}
+
+func TestTrustedIssuersIssuer(t *testing.T) {
+	legacy, other := newTestCA(t), newTestCA(t)
+	issuer, err := NewTrustedIssuer("legacy", legacy.pem, "spiffe://cluster.local/ns/legacy/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuers := TrustedIssuers{issuer}
+
+	testCases := map[string]struct {
+		der            []byte
+		expectedIssuer string
+	}{
+		"Trusted": {
+			der:            legacy.issue(t, "spiffe://cluster.local/ns/legacy/sa/foo"),
+			expectedIssuer: "legacy",
+		},
+		"Not trusted for the ID": {
+			der: legacy.issue(t, "spiffe://cluster.local/ns/istio-system/sa/foo"),
+		},
+		"Other root": {
+			der: other.issue(t, "spiffe://cluster.local/ns/legacy/sa/foo"),
+		},
+		"No SPIFFE ID": {
+			der: legacy.issue(t),
+		},
+	}
+
+	for k, tc := range testCases {
+		cert, err := x509.ParseCertificate(tc.der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, err := issuers.Issuer([]*x509.Certificate{cert})
+		if name != tc.expectedIssuer {
+			t.Errorf("%s: unexpected issuer %q (expecting %q)", k, name, tc.expectedIssuer)
+		}
+		if (err == nil) != (tc.expectedIssuer != "") {
+			t.Errorf("%s: unexpected error %v", k, err)
+		}
+	}
+}
+
+func quote(t *testing.T, s string) string {
+	bs, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bs)
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spiffe
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// VerifyPeerCertificate is the signature of tls.Config.VerifyPeerCertificate.
+type VerifyPeerCertificate func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+
+// NewVerifier returns a VerifyPeerCertificate callback accepting peers whose
+// certificate chains to the trust bundle and carries a SPIFFE ID matching
+// any of the patterns (see Matcher). The trust bundle returns the
+// PEM-encoded root certificates, e.g. the root certificates from the CSR API
+// kept up to date by WatchTrustBundle, and is called for every handshake so
+// that rotated roots are picked up.
+//
+// The callback verifies the chain itself, so the tls.Config should set
+// InsecureSkipVerify to skip the verification of the host name, which SPIFFE
+// certificates do not carry, and servers should set ClientAuth to
+// tls.RequireAnyClientCert.
+func NewVerifier(trustBundle func() []byte, patterns ...string) (VerifyPeerCertificate, error) {
+	return NewVerifierWithIssuers(trustBundle, nil, patterns...)
+}
+
+// NewVerifierWithIssuers is like NewVerifier, and also accepts peers whose
+// certificate one of the additional trusted issuers vouches for.
+func NewVerifierWithIssuers(trustBundle func() []byte, issuers TrustedIssuers,
+	patterns ...string) (VerifyPeerCertificate, error) {
+
+	if len(patterns) == 0 {
+		return nil, errors.New("no SPIFFE ID pattern is given")
+	}
+	matcher, err := NewMatcher(patterns...)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("the peer presented no certificate")
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("failed to parse the peer certificate (error: %v)", err)
+			}
+			certs[i] = cert
+		}
+
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(trustBundle()) && len(issuers) == 0 {
+			return errors.New("the trust bundle holds no valid root certificate")
+		}
+		if err := verifyChain(certs, roots); err != nil {
+			if _, issuerErr := issuers.Issuer(certs); issuerErr != nil {
+				return fmt.Errorf("failed to verify the peer certificate (error: %v)", err)
+			}
+		}
+
+		id, err := CertificateID(certs[0])
+		if err != nil {
+			return err
+		}
+		if !matcher.Matches(id) {
+			return fmt.Errorf("peer %s is not authorized", id)
+		}
+		return nil
+	}, nil
+}
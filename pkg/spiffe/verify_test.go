@@ -0,0 +1,194 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spiffe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"test.ca.org"}},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the DER encoding of a certificate for the URI SANs.
+func (ca *testCA) issue(t *testing.T, uris ...string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, u := range uris {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.URIs = append(template.URIs, parsed)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestNewVerifier(t *testing.T) {
+	ca, other := newTestCA(t), newTestCA(t)
+	verify, err := NewVerifier(func() []byte { return ca.pem }, "spiffe://cluster.local/ns/foo/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		rawCerts     [][]byte
+		expectAccept bool
+	}{
+		"Authorized peer": {
+			rawCerts:     [][]byte{ca.issue(t, "spiffe://cluster.local/ns/foo/sa/bar")},
+			expectAccept: true,
+		},
+		"Unauthorized peer": {
+			rawCerts: [][]byte{ca.issue(t, "spiffe://cluster.local/ns/baz/sa/bar")},
+		},
+		"Untrusted root": {
+			rawCerts: [][]byte{other.issue(t, "spiffe://cluster.local/ns/foo/sa/bar")},
+		},
+		"No SPIFFE ID": {
+			rawCerts: [][]byte{ca.issue(t)},
+		},
+		"No certificate": {},
+		"Malformed certificate": {
+			rawCerts: [][]byte{[]byte("not a certificate")},
+		},
+	}
+
+	for k, tc := range testCases {
+		err := verify(tc.rawCerts, nil)
+		if tc.expectAccept && err != nil {
+			t.Errorf("%s: the peer is rejected: %v", k, err)
+		}
+		if !tc.expectAccept && err == nil {
+			t.Errorf("%s: the peer is accepted", k)
+		}
+	}
+}
+
+func TestNewVerifierRotatedBundle(t *testing.T) {
+	oldCA, newCA := newTestCA(t), newTestCA(t)
+	bundle := oldCA.pem
+	verify, err := NewVerifier(func() []byte { return bundle }, "spiffe://cluster.local/ns/foo/sa/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := newCA.issue(t, "spiffe://cluster.local/ns/foo/sa/bar")
+
+	if err := verify([][]byte{peer}, nil); err == nil {
+		t.Error("A peer of the new root is accepted before the rotation")
+	}
+	bundle = append(append([]byte{}, oldCA.pem...), newCA.pem...)
+	if err := verify([][]byte{peer}, nil); err != nil {
+		t.Errorf("A peer of the new root is rejected after the rotation: %v", err)
+	}
+}
+
+func TestNewVerifierInvalidPatterns(t *testing.T) {
+	bundle := func() []byte { return nil }
+	if _, err := NewVerifier(bundle); err == nil {
+		t.Error("A verifier without patterns is created")
+	}
+	if _, err := NewVerifier(bundle, "cluster.local/ns/foo"); err == nil {
+		t.Error("A verifier with an invalid pattern is created")
+	}
+}
+
+func TestNewVerifierWithIssuers(t *testing.T) {
+	ca, legacy := newTestCA(t), newTestCA(t)
+	issuer, err := NewTrustedIssuer("legacy", legacy.pem, "spiffe://cluster.local/ns/legacy/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify, err := NewVerifierWithIssuers(func() []byte { return ca.pem }, TrustedIssuers{issuer},
+		"spiffe://cluster.local/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		der          []byte
+		expectAccept bool
+	}{
+		"Trust bundle": {
+			der:          ca.issue(t, "spiffe://cluster.local/ns/foo/sa/bar"),
+			expectAccept: true,
+		},
+		"Trusted issuer": {
+			der:          legacy.issue(t, "spiffe://cluster.local/ns/legacy/sa/bar"),
+			expectAccept: true,
+		},
+		"Trusted issuer for another ID": {
+			der: legacy.issue(t, "spiffe://cluster.local/ns/foo/sa/bar"),
+		},
+	}
+
+	for k, tc := range testCases {
+		err := verify([][]byte{tc.der}, nil)
+		if tc.expectAccept && err != nil {
+			t.Errorf("%s: the peer is rejected: %v", k, err)
+		}
+		if !tc.expectAccept && err == nil {
+			t.Errorf("%s: the peer is accepted", k)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package registry looks up the workloads approved in external identity
+// registries, e.g. the CMDB of an organization.
+package registry
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"istio.io/auth/certmanager"
+)
+
+// The maximum size of a webhook response, which only holds a verdict and a
+// short reason.
+const maxWebhookResponseSize = 64 << 10
+
+// WebhookRequest is the body POSTed to the webhook for every identity to approve.
+type WebhookRequest struct {
+	TrustDomain    string `json:"trustDomain"`
+	Namespace      string `json:"namespace"`
+	ServiceAccount string `json:"serviceAccount"`
+}
+
+// WebhookResponse is the body the webhook responds with.
+type WebhookResponse struct {
+	// Whether the service account is a known, approved workload.
+	Approved bool `json:"approved"`
+
+	// Why the service account is not approved, shown in the errors. Optional.
+	Reason string `json:"reason"`
+}
+
+// Webhook is a certmanager.IdentityRegistry that has an HTTPS endpoint, e.g.
+// a CMDB service, approve the identities before the CA issues their
+// certificates. The approvals are cached, so that the renewals of approved
+// workloads do not depend on the endpoint, while the refusals are not, so
+// that newly approved workloads get their certificates on the next retry.
+type Webhook struct {
+	endpoint string
+	token    string
+	client   *http.Client
+	cacheTTL time.Duration
+
+	mutex sync.Mutex
+	// The expiry of the cached approvals.
+	approved map[WebhookRequest]time.Time
+}
+
+// NewWebhook returns a pointer to a Webhook querying the HTTPS endpoint, and
+// caching its approvals for cacheTTL. The server certificate of the endpoint
+// is verified against the PEM-encoded caCert, or the system roots if it is
+// empty. The token, if not empty, is sent as a bearer token.
+func NewWebhook(endpoint string, caCert []byte, token string, cacheTTL time.Duration) (*Webhook, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook endpoint %q: expect an https:// URL", endpoint)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(caCert) > 0 {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("the CA certificate of the webhook holds no PEM-encoded certificate")
+		}
+	}
+	return &Webhook{
+		endpoint: endpoint,
+		token:    token,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		cacheTTL: cacheTTL,
+		approved: map[WebhookRequest]time.Time{},
+	}, nil
+}
+
+// Approve implements certmanager.IdentityRegistry. It fails if the webhook
+// cannot be reached, so that no identity is issued without an approval.
+func (w *Webhook) Approve(trustDomain, namespace, serviceAccount string) error {
+	req := WebhookRequest{TrustDomain: trustDomain, Namespace: namespace, ServiceAccount: serviceAccount}
+	now := time.Now()
+	w.mutex.Lock()
+	expiry, ok := w.approved[req]
+	w.mutex.Unlock()
+	if ok && now.Before(expiry) {
+		return nil
+	}
+
+	resp, err := w.query(req)
+	if err != nil {
+		return fmt.Errorf("failed to look up service account %s/%s in the identity registry (error: %v)",
+			namespace, serviceAccount, err)
+	}
+	if !resp.Approved {
+		return &certmanager.IdentityNotApprovedError{Namespace: namespace, ServiceAccount: serviceAccount,
+			Reason: resp.Reason}
+	}
+
+	if w.cacheTTL > 0 {
+		w.mutex.Lock()
+		w.pruneExpired(now)
+		w.approved[req] = now.Add(w.cacheTTL)
+		w.mutex.Unlock()
+	}
+	return nil
+}
+
+func (w *Webhook) query(req WebhookRequest) (*WebhookResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequest(http.MethodPost, w.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if w.token != "" {
+		r.Header.Set("Authorization", "Bearer "+w.token)
+	}
+	res, err := w.client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close() // nolint: errcheck
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the identity registry webhook failed (status: %s)", res.Status)
+	}
+
+	var resp WebhookResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxWebhookResponseSize)).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode the identity registry webhook response (error: %v)", err)
+	}
+	return &resp, nil
+}
+
+// Flush drops the cached approvals, so that the identities are approved by
+// the endpoint again, e.g. after workloads have been decommissioned.
+func (w *Webhook) Flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.approved = map[WebhookRequest]time.Time{}
+}
+
+// pruneExpired drops the expired approvals. It must be called with the lock held.
+func (w *Webhook) pruneExpired(now time.Time) {
+	for req, expiry := range w.approved {
+		if !now.Before(expiry) {
+			delete(w.approved, req)
+		}
+	}
+}
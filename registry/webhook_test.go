@@ -0,0 +1,165 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	var queries int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&queries, 1)
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req WebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TrustDomain != "cluster.local" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resp := WebhookResponse{Approved: req.Namespace == "approved"}
+		switch req.ServiceAccount {
+		case "retired":
+			resp = WebhookResponse{Reason: "retired workload"}
+		case "verbose":
+			resp = WebhookResponse{Reason: strings.Repeat("x", maxWebhookResponseSize)}
+		}
+		json.NewEncoder(w).Encode(resp) // nolint: errcheck
+	}))
+	defer server.Close()
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	testCases := map[string]struct {
+		endpoint        string
+		caCert          []byte
+		token           string
+		cacheTTL        time.Duration
+		flush           bool
+		namespace       string
+		serviceAccount  string
+		expectedErr     string
+		expectedQueries int32
+	}{
+		"Approved": {
+			endpoint:        server.URL,
+			caCert:          caCert,
+			token:           "secret",
+			namespace:       "approved",
+			serviceAccount:  "foo",
+			expectedQueries: 2,
+		},
+		"Cached approval": {
+			endpoint:        server.URL,
+			caCert:          caCert,
+			token:           "secret",
+			cacheTTL:        time.Hour,
+			namespace:       "approved",
+			serviceAccount:  "foo",
+			expectedQueries: 1,
+		},
+		"Flushed approval": {
+			endpoint:        server.URL,
+			caCert:          caCert,
+			token:           "secret",
+			cacheTTL:        time.Hour,
+			flush:           true,
+			namespace:       "approved",
+			serviceAccount:  "foo",
+			expectedQueries: 2,
+		},
+		"Not approved": {
+			endpoint:        server.URL,
+			caCert:          caCert,
+			token:           "secret",
+			cacheTTL:        time.Hour,
+			namespace:       "unknown",
+			serviceAccount:  "foo",
+			expectedErr:     "service account unknown/foo is not approved by the identity registry",
+			expectedQueries: 2,
+		},
+		"Not approved with a reason": {
+			endpoint:        server.URL,
+			caCert:          caCert,
+			token:           "secret",
+			namespace:       "approved",
+			serviceAccount:  "retired",
+			expectedErr:     "is not approved by the identity registry: retired workload",
+			expectedQueries: 2,
+		},
+		"Oversized response": {
+			endpoint:        server.URL,
+			caCert:          caCert,
+			token:           "secret",
+			namespace:       "approved",
+			serviceAccount:  "verbose",
+			expectedErr:     "failed to decode the identity registry webhook response",
+			expectedQueries: 2,
+		},
+		"Unauthorized": {
+			endpoint:        server.URL,
+			caCert:          caCert,
+			token:           "wrong",
+			namespace:       "approved",
+			serviceAccount:  "foo",
+			expectedErr:     "the identity registry webhook failed (status: 401 Unauthorized)",
+			expectedQueries: 2,
+		},
+		"Untrusted server": {
+			endpoint:       server.URL,
+			token:          "secret",
+			namespace:      "approved",
+			serviceAccount: "foo",
+			expectedErr:    "certificate",
+		},
+		"Plain HTTP": {
+			endpoint:    strings.Replace(server.URL, "https://", "http://", 1),
+			expectedErr: "expect an https:// URL",
+		},
+	}
+	for id, tc := range testCases {
+		atomic.StoreInt32(&queries, 0)
+		w, err := NewWebhook(tc.endpoint, tc.caCert, tc.token, tc.cacheTTL)
+		if err == nil {
+			// Approve twice, to check that only the approvals are cached.
+			for i := 0; i < 2; i++ {
+				err = w.Approve("cluster.local", tc.namespace, tc.serviceAccount)
+				if tc.flush {
+					w.Flush()
+				}
+			}
+		}
+		if tc.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: expected error %q, got %v", id, tc.expectedErr, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+		}
+		if tc.expectedQueries > 0 {
+			if n := atomic.LoadInt32(&queries); n != tc.expectedQueries {
+				t.Errorf("%s: expected %d queries, got %d", id, tc.expectedQueries, n)
+			}
+		}
+	}
+}
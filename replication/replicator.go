@@ -0,0 +1,175 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package replication mirrors the public certificates issued by Istio CA, and
+// its trust bundle, into an external key-value store such as Consul or etcd,
+// for consumers outside of Kubernetes that cannot read the Istio secrets.
+package replication
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"istio.io/auth/certmanager"
+)
+
+// The number of issued certificates that may be waiting to be replicated.
+// Certificates issued while the queue is full are not replicated.
+const queueSize = 1024
+
+var (
+	writes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "istio_ca",
+		Subsystem: "replication",
+		Name:      "writes_total",
+		Help:      "The number of writes to the external key-value store, by operation and result.",
+	}, []string{"operation", "result"})
+
+	dropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "istio_ca",
+		Subsystem: "replication",
+		Name:      "dropped_certs_total",
+		Help:      "The number of issued certificates not replicated because the queue was full.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(writes)
+	prometheus.MustRegister(dropped)
+}
+
+// Source is the CA whose certificates are replicated.
+type Source interface {
+	GetRootCertificate() []byte
+	AddIssuanceListener(l certmanager.IssuanceListener)
+}
+
+// Replicator writes every certificate issued by a CA to
+// <prefix>/certs/<hex serial number> and its trust bundle to
+// <prefix>/trust-bundle, both PEM-encoded. The trust bundle is checked for
+// changes every interval, when the keys of expired certificates are also
+// deleted.
+type Replicator struct {
+	source   Source
+	store    Store
+	prefix   string
+	interval time.Duration
+
+	queue chan *x509.Certificate
+
+	// Maps from the keys of the replicated certificates to their expiration.
+	expirations map[string]time.Time
+
+	// The last trust bundle successfully written to the store.
+	trustBundle []byte
+}
+
+// NewReplicator returns a pointer to a Replicator of the certificates issued
+// by the source afterwards.
+func NewReplicator(source Source, store Store, prefix string, interval time.Duration) *Replicator {
+	r := &Replicator{
+		source:      source,
+		store:       store,
+		prefix:      prefix,
+		interval:    interval,
+		queue:       make(chan *x509.Certificate, queueSize),
+		expirations: map[string]time.Time{},
+	}
+	source.AddIssuanceListener(r.enqueue)
+	return r
+}
+
+// enqueue is called synchronously by the CA, so it must never block.
+func (r *Replicator) enqueue(cert *x509.Certificate) {
+	select {
+	case r.queue <- cert:
+	default:
+		dropped.Inc()
+	}
+}
+
+// Run replicates the certificates until stopCh is closed.
+func (r *Replicator) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	r.syncTrustBundle()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case cert := <-r.queue:
+			r.putCertificate(cert)
+		case now := <-ticker.C:
+			r.syncTrustBundle()
+			r.deleteExpired(now)
+		}
+	}
+}
+
+func (r *Replicator) putCertificate(cert *x509.Certificate) {
+	key := path.Join(r.prefix, "certs", fmt.Sprintf("%x", cert.SerialNumber))
+	value := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if err := r.write("put", func() error { return r.store.Put(key, value) }); err != nil {
+		glog.Errorf("Failed to replicate the certificate %s (error: %v)", key, err)
+		return
+	}
+	r.expirations[key] = cert.NotAfter
+}
+
+func (r *Replicator) syncTrustBundle() {
+	bundle := r.source.GetRootCertificate()
+	if bytes.Equal(bundle, r.trustBundle) {
+		return
+	}
+	key := path.Join(r.prefix, "trust-bundle")
+	if err := r.write("put", func() error { return r.store.Put(key, bundle) }); err != nil {
+		glog.Errorf("Failed to replicate the trust bundle (error: %v)", err)
+		return
+	}
+	r.trustBundle = bundle
+	glog.Infof("Replicated the trust bundle to %s", key)
+}
+
+// deleteExpired deletes the keys of the certificates expired as of now. The
+// deletions that fail are retried on the next call.
+func (r *Replicator) deleteExpired(now time.Time) {
+	for key, notAfter := range r.expirations {
+		if notAfter.After(now) {
+			continue
+		}
+		if err := r.write("delete", func() error { return r.store.Delete(key) }); err != nil {
+			glog.Errorf("Failed to delete the expired certificate %s (error: %v)", key, err)
+			continue
+		}
+		delete(r.expirations, key)
+	}
+}
+
+func (r *Replicator) write(operation string, f func() error) error {
+	err := f()
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	writes.WithLabelValues(operation, result).Inc()
+	return err
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager"
+)
+
+type fakeSource struct {
+	roots    []byte
+	listener certmanager.IssuanceListener
+}
+
+func (s *fakeSource) GetRootCertificate() []byte {
+	return s.roots
+}
+
+func (s *fakeSource) AddIssuanceListener(l certmanager.IssuanceListener) {
+	s.listener = l
+}
+
+type fakeStore struct {
+	kv  map[string][]byte
+	err error
+
+	puts int
+}
+
+func (s *fakeStore) Put(key string, value []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.puts++
+	s.kv[key] = value
+	return nil
+}
+
+func (s *fakeStore) Delete(key string) error {
+	if s.err != nil {
+		return s.err
+	}
+	delete(s.kv, key)
+	return nil
+}
+
+func TestReplicateCertificates(t *testing.T) {
+	now := time.Now()
+	testCases := map[string]struct {
+		notAfter  time.Time
+		storeErr  error
+		expectKey bool
+	}{
+		"Unexpired certificate": {
+			notAfter:  now.Add(time.Hour),
+			expectKey: true,
+		},
+		"Expired certificate": {
+			notAfter: now.Add(-time.Minute),
+		},
+		"Failed write": {
+			notAfter: now.Add(time.Hour),
+			storeErr: fmt.Errorf("unavailable"),
+		},
+	}
+
+	for k, tc := range testCases {
+		source := &fakeSource{}
+		store := &fakeStore{kv: map[string][]byte{}, err: tc.storeErr}
+		r := NewReplicator(source, store, "istio-ca", time.Minute)
+
+		cert := &x509.Certificate{Raw: []byte("der"), SerialNumber: big.NewInt(0xab), NotAfter: tc.notAfter}
+		source.listener(cert)
+		r.putCertificate(<-r.queue)
+		r.deleteExpired(now)
+
+		value, ok := store.kv["istio-ca/certs/ab"]
+		if ok != tc.expectKey {
+			t.Errorf("%s: expecting the certificate to be replicated to be %t, but it is %t", k, tc.expectKey, ok)
+		}
+		if ok && string(value) != string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})) {
+			t.Errorf("%s: unexpected replicated certificate %q", k, value)
+		}
+	}
+}
+
+func TestSyncTrustBundle(t *testing.T) {
+	source := &fakeSource{roots: []byte("root 1")}
+	store := &fakeStore{kv: map[string][]byte{}}
+	r := NewReplicator(source, store, "/istio-ca/", time.Minute)
+
+	r.syncTrustBundle()
+	r.syncTrustBundle()
+	if store.puts != 1 || string(store.kv["/istio-ca/trust-bundle"]) != "root 1" {
+		t.Errorf("Unexpected trust bundle %q after %d writes", store.kv["/istio-ca/trust-bundle"], store.puts)
+	}
+
+	source.roots = []byte("root 2")
+	r.syncTrustBundle()
+	if store.puts != 2 || string(store.kv["/istio-ca/trust-bundle"]) != "root 2" {
+		t.Errorf("Unexpected trust bundle %q after %d writes", store.kv["/istio-ca/trust-bundle"], store.puts)
+	}
+}
+
+func TestDroppedCertificates(t *testing.T) {
+	source := &fakeSource{}
+	r := NewReplicator(source, &fakeStore{kv: map[string][]byte{}}, "istio-ca", time.Minute)
+
+	for i := 0; i < queueSize+1; i++ {
+		source.listener(&x509.Certificate{SerialNumber: big.NewInt(int64(i))})
+	}
+	if len(r.queue) != queueSize {
+		t.Errorf("Expecting %d queued certificates, but got %d", queueSize, len(r.queue))
+	}
+}
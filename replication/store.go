@@ -0,0 +1,106 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Store is an external key-value store that the certificates are replicated into.
+type Store interface {
+	Put(key string, value []byte) error
+	Delete(key string) error
+}
+
+// ConsulStore writes the keys with the KV HTTP API of HashiCorp Consul.
+type ConsulStore struct {
+	Client *http.Client
+	// The address of the Consul agent, e.g. https://consul.example.com:8501.
+	Address string
+
+	// The ACL token to authorize the writes with, if any.
+	Token string
+}
+
+// Put implements Store.
+func (s ConsulStore) Put(key string, value []byte) error {
+	return s.do(http.MethodPut, key, value)
+}
+
+// Delete implements Store.
+func (s ConsulStore) Delete(key string) error {
+	return s.do(http.MethodDelete, key, nil)
+}
+
+func (s ConsulStore) do(method, key string, value []byte) error {
+	u := strings.TrimSuffix(s.Address, "/") + "/v1/kv/" + (&url.URL{Path: key}).EscapedPath()
+	req, err := http.NewRequest(method, u, bytes.NewReader(value))
+	if err != nil {
+		return err
+	}
+	if s.Token != "" {
+		req.Header.Set("X-Consul-Token", s.Token)
+	}
+	return send(s.Client, req)
+}
+
+// EtcdStore writes the keys with the JSON gRPC gateway of the v3 API of etcd.
+type EtcdStore struct {
+	Client *http.Client
+	// The client URL of an etcd member, e.g. https://etcd.example.com:2379.
+	Address string
+}
+
+// Put implements Store.
+func (s EtcdStore) Put(key string, value []byte) error {
+	return s.do("/v3/kv/put", map[string][]byte{"key": []byte(key), "value": value})
+}
+
+// Delete implements Store.
+func (s EtcdStore) Delete(key string) error {
+	return s.do("/v3/kv/deleterange", map[string][]byte{"key": []byte(key)})
+}
+
+// do posts the request, whose bytes are base64-encoded by encoding/json as
+// the gateway expects.
+func (s EtcdStore) do(path string, body map[string][]byte) error {
+	bs, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(s.Address, "/")+path, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return send(s.Client, req)
+}
+
+func send(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to write %s (status: %s)", req.URL, resp.Status)
+	}
+	return nil
+}
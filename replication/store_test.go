@@ -0,0 +1,148 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type request struct {
+	method, path, token, body string
+}
+
+func TestConsulStore(t *testing.T) {
+	testCases := map[string]struct {
+		status    int
+		delete    bool
+		expected  request
+		expectErr bool
+	}{
+		"Put": {
+			status:   http.StatusOK,
+			expected: request{http.MethodPut, "/v1/kv/istio-ca/certs/ab", "token", "cert"},
+		},
+		"Delete": {
+			status:   http.StatusOK,
+			delete:   true,
+			expected: request{http.MethodDelete, "/v1/kv/istio-ca/certs/ab", "token", ""},
+		},
+		"Rejected put": {
+			status:    http.StatusForbidden,
+			expectErr: true,
+		},
+	}
+
+	for k, tc := range testCases {
+		var got request
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bs, _ := ioutil.ReadAll(r.Body)
+			got = request{r.Method, r.URL.Path, r.Header.Get("X-Consul-Token"), string(bs)}
+			w.WriteHeader(tc.status)
+		}))
+
+		store := ConsulStore{Client: server.Client(), Address: server.URL + "/", Token: "token"}
+		var err error
+		if tc.delete {
+			err = store.Delete("istio-ca/certs/ab")
+		} else {
+			err = store.Put("istio-ca/certs/ab", []byte("cert"))
+		}
+		server.Close()
+
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s: expecting an error", k)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+		}
+		if got != tc.expected {
+			t.Errorf("%s: expecting request %+v, but got %+v", k, tc.expected, got)
+		}
+	}
+}
+
+func TestEtcdStore(t *testing.T) {
+	testCases := map[string]struct {
+		status       int
+		delete       bool
+		expectedPath string
+		expectedBody map[string][]byte
+		expectErr    bool
+	}{
+		"Put": {
+			status:       http.StatusOK,
+			expectedPath: "/v3/kv/put",
+			expectedBody: map[string][]byte{"key": []byte("istio-ca/trust-bundle"), "value": []byte("roots")},
+		},
+		"Delete": {
+			status:       http.StatusOK,
+			delete:       true,
+			expectedPath: "/v3/kv/deleterange",
+			expectedBody: map[string][]byte{"key": []byte("istio-ca/trust-bundle")},
+		},
+		"Failed put": {
+			status:    http.StatusServiceUnavailable,
+			expectErr: true,
+		},
+	}
+
+	for k, tc := range testCases {
+		var path string
+		var body map[string][]byte
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(tc.status)
+		}))
+
+		store := EtcdStore{Client: server.Client(), Address: server.URL}
+		var err error
+		if tc.delete {
+			err = store.Delete("istio-ca/trust-bundle")
+		} else {
+			err = store.Put("istio-ca/trust-bundle", []byte("roots"))
+		}
+		server.Close()
+
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s: expecting an error", k)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+		}
+		if path != tc.expectedPath || len(body) != len(tc.expectedBody) {
+			t.Errorf("%s: unexpected request to %s with body %v", k, path, body)
+			continue
+		}
+		for key, value := range tc.expectedBody {
+			if string(body[key]) != string(value) {
+				t.Errorf("%s: expecting %s to be %q, but got %q", k, key, value, body[key])
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scep
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"istio.io/auth/pkg/spiffe"
+)
+
+var oidChallengePassword = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+
+// ChallengePolicy maps the challenge passwords handed out to the operators
+// of device fleets to the patterns of the identities that the devices
+// presenting them may enroll for. A pattern is either a SPIFFE URI, or a
+// prefix of SPIFFE URIs followed by "*", e.g.
+// "spiffe://cluster.local/ns/devices/*".
+type ChallengePolicy map[string][]string
+
+// LoadChallengePolicy reads a ChallengePolicy from a JSON file holding an
+// object from the challenge passwords to arrays of patterns.
+func LoadChallengePolicy(path string) (ChallengePolicy, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := ChallengePolicy{}
+	if err := json.Unmarshal(bs, &policy); err != nil {
+		return nil, fmt.Errorf("invalid challenge policy in %s (error: %v)", path, err)
+	}
+	if len(policy) == 0 {
+		return nil, fmt.Errorf("the challenge policy in %s has no challenge passwords", path)
+	}
+	for challenge, patterns := range policy {
+		if challenge == "" {
+			return nil, errors.New("the challenge policy has an empty challenge password")
+		}
+		if _, err := spiffe.NewMatcher(patterns...); err != nil {
+			// The challenge passwords are secrets, which are kept out of the error.
+			return nil, fmt.Errorf("the challenge policy has invalid patterns (error: %v)", err)
+		}
+	}
+	return policy, nil
+}
+
+// Allows returns whether a device presenting the challenge password may
+// enroll for the identity. The challenge password is compared in constant
+// time with every one of the policy.
+func (p ChallengePolicy) Allows(challenge, id string) bool {
+	var patterns []string
+	for c, ps := range p {
+		if subtle.ConstantTimeCompare([]byte(c), []byte(challenge)) == 1 {
+			patterns = ps
+		}
+	}
+	return spiffe.Matcher(patterns).Matches(id)
+}
+
+// tbsCertificateRequest is the CertificationRequestInfo of PKCS#10 (RFC 2986).
+type tbsCertificateRequest struct {
+	Version    int
+	Subject    asn1.RawValue
+	PublicKey  asn1.RawValue
+	Attributes []attribute `asn1:"tag:0"`
+}
+
+// challengePassword returns the challenge password attribute of the
+// certificate signing request, which x509 does not parse.
+func challengePassword(csr *x509.CertificateRequest) (string, error) {
+	var tbs tbsCertificateRequest
+	if _, err := asn1.Unmarshal(csr.RawTBSCertificateRequest, &tbs); err != nil {
+		return "", fmt.Errorf("invalid certificate signing request (error: %v)", err)
+	}
+	for _, a := range tbs.Attributes {
+		if !a.Type.Equal(oidChallengePassword) {
+			continue
+		}
+		var challenge string
+		if _, err := asn1.Unmarshal(a.Value.Bytes, &challenge); err != nil {
+			return "", fmt.Errorf("invalid challenge password (error: %v)", err)
+		}
+		return challenge, nil
+	}
+	return "", errors.New("the certificate signing request has no challenge password")
+}
@@ -0,0 +1,198 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scep
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var (
+	oidExtensionRequest        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 14}
+	oidExtensionSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidSHA256WithRSA           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+)
+
+// createCSR returns the DER-encoded certificate signing request for the
+// identity with the challenge password, if not empty, which x509 cannot
+// create.
+func createCSR(t *testing.T, key *rsa.PrivateKey, id, challenge string) []byte {
+	subject, err := asn1.Marshal(pkix.Name{CommonName: "appliance"}.ToRDNSequence())
+	if err != nil {
+		t.Fatal(err)
+	}
+	spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	san, err := asn1.Marshal([]asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 6, Bytes: []byte(id)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	extensions, err := newAttribute(oidExtensionRequest, []pkix.Extension{{Id: oidExtensionSubjectAltName, Value: san}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbs := tbsCertificateRequest{
+		Subject:    asn1.RawValue{FullBytes: subject},
+		PublicKey:  asn1.RawValue{FullBytes: spki},
+		Attributes: []attribute{extensions},
+	}
+	if challenge != "" {
+		a, err := newAttribute(oidChallengePassword, asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte(challenge)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tbs.Attributes = append(tbs.Attributes, a)
+	}
+	tbsDER, err := asn1.Marshal(tbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(tbsDER)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(struct {
+		TBS       asn1.RawValue
+		Algorithm pkix.AlgorithmIdentifier
+		Signature asn1.BitString
+	}{
+		TBS:       asn1.RawValue{FullBytes: tbsDER},
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256WithRSA, Parameters: asn1.NullRawValue},
+		Signature: asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestLoadChallengePolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "challenge-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	testCases := map[string]struct {
+		content     string
+		expectedErr string
+	}{
+		"Valid": {
+			content: `{"s3cret": ["spiffe://cluster.local/ns/devices/*", "spiffe://cluster.local/ns/lab/sa/printer"]}`,
+		},
+		"Invalid JSON": {
+			content:     `["s3cret"]`,
+			expectedErr: "invalid challenge policy",
+		},
+		"No challenge passwords": {
+			content:     `{}`,
+			expectedErr: "has no challenge passwords",
+		},
+		"Empty challenge password": {
+			content:     `{"": ["spiffe://cluster.local/ns/devices/*"]}`,
+			expectedErr: "the challenge policy has an empty challenge password",
+		},
+		"Invalid pattern": {
+			content:     `{"s3cret": ["spiffe://cluster.local/*/sa/printer"]}`,
+			expectedErr: `has invalid patterns (error: invalid pattern "spiffe://cluster.local/*/sa/printer"`,
+		},
+	}
+	for id, tc := range testCases {
+		path := filepath.Join(dir, "challenges.json")
+		if err := ioutil.WriteFile(path, []byte(tc.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadChallengePolicy(path)
+		if tc.expectedErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", id, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+			t.Errorf("%s: expected error %q, got %v", id, tc.expectedErr, err)
+		}
+	}
+}
+
+func TestChallengePolicyAllows(t *testing.T) {
+	policy := ChallengePolicy{
+		"fleet":   {"spiffe://cluster.local/ns/devices/*"},
+		"printer": {"spiffe://cluster.local/ns/lab/sa/printer"},
+	}
+	testCases := map[string]struct {
+		challenge string
+		id        string
+		allowed   bool
+	}{
+		"Prefix":           {"fleet", "spiffe://cluster.local/ns/devices/sa/router", true},
+		"Exact":            {"printer", "spiffe://cluster.local/ns/lab/sa/printer", true},
+		"Other namespace":  {"fleet", "spiffe://cluster.local/ns/lab/sa/printer", false},
+		"Other identity":   {"printer", "spiffe://cluster.local/ns/lab/sa/scanner", false},
+		"Unknown password": {"guess", "spiffe://cluster.local/ns/devices/sa/router", false},
+		"Empty password":   {"", "spiffe://cluster.local/ns/devices/sa/router", false},
+	}
+	for id, tc := range testCases {
+		if allowed := policy.Allows(tc.challenge, tc.id); allowed != tc.allowed {
+			t.Errorf("%s: expected %v, got %v", id, tc.allowed, allowed)
+		}
+	}
+}
+
+func TestChallengePassword(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := map[string]struct {
+		challenge   string
+		expectedErr string
+	}{
+		"Challenge password": {
+			challenge: "s3cret",
+		},
+		"No challenge password": {
+			expectedErr: "the certificate signing request has no challenge password",
+		},
+	}
+	for id, tc := range testCases {
+		csr, err := x509.ParseCertificateRequest(createCSR(t, key, "spiffe://cluster.local/ns/devices/sa/router",
+			tc.challenge))
+		if err != nil {
+			t.Fatalf("%s: failed to parse the certificate signing request: %v", id, err)
+		}
+		challenge, err := challengePassword(csr)
+		if tc.expectedErr != "" {
+			if err == nil || err.Error() != tc.expectedErr {
+				t.Errorf("%s: expected error %q, got %v", id, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil || challenge != tc.challenge {
+			t.Errorf("%s: expected challenge password %q, got %q (error: %v)", id, tc.challenge, challenge, err)
+		}
+	}
+}
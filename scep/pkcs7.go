@@ -0,0 +1,472 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scep
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	// Register the digests of the signed messages.
+	_ "crypto/sha1" // #nosec
+	_ "crypto/sha256"
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// The digests of the signed messages.
+var digestAlgorithms = []struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+}{
+	{oidSHA1, crypto.SHA1},
+	{oidSHA256, crypto.SHA256},
+}
+
+// The key sizes of the content encryption algorithms of the enveloped messages.
+var contentKeySizes = map[string]int{
+	oidDESEDE3CBC.String(): 24,
+	oidAES128CBC.String():  16,
+	oidAES256CBC.String():  32,
+}
+
+// The ASN.1 structures of PKCS#7 (RFC 2315) used by SCEP. A RawValue is
+// marshaled as is, so the tags of the RawValue fields are set by the code
+// building them.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type issuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type attribute struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []recipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type recipientInfo struct {
+	Version                int
+	IssuerAndSerialNumber  issuerAndSerial
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// signedMessage is a verified PKCS#7 SignedData.
+type signedMessage struct {
+	// The signed content, which is empty in a message without content.
+	content []byte
+	signer  *x509.Certificate
+	digest  crypto.Hash
+
+	// Maps from the string forms of the types of the authenticated
+	// attributes to the DER encodings of their values.
+	attributes map[string][]byte
+}
+
+// newAttribute returns the attribute with the single value.
+func newAttribute(t asn1.ObjectIdentifier, value interface{}) (attribute, error) {
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		return attribute{}, err
+	}
+	return attribute{Type: t, Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: der}}, nil
+}
+
+// parseSignedData parses the DER-encoded ContentInfo of a SignedData with a
+// single signer, and verifies the signature with the certificate of the
+// signer in the message.
+func parseSignedData(der []byte) (*signedMessage, error) {
+	var ci contentInfo
+	if rest, err := asn1.Unmarshal(der, &ci); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("invalid PKCS#7 content info (error: %v)", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("unexpected PKCS#7 content type %v (expecting signed data)", ci.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("invalid PKCS#7 signed data (error: %v)", err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("the signed data must have a single signer (has %d)", len(sd.SignerInfos))
+	}
+	si := sd.SignerInfos[0]
+
+	var content []byte
+	if len(sd.ContentInfo.Content.Bytes) > 0 {
+		if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &content); err != nil {
+			return nil, fmt.Errorf("invalid signed content (error: %v)", err)
+		}
+	}
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificates in the signed data (error: %v)", err)
+	}
+	var signer *x509.Certificate
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, si.IssuerAndSerialNumber.Issuer.FullBytes) &&
+			c.SerialNumber.Cmp(si.IssuerAndSerialNumber.SerialNumber) == 0 {
+			signer = c
+		}
+	}
+	if signer == nil {
+		return nil, errors.New("the signed data does not hold the certificate of its signer")
+	}
+	pub, ok := signer.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("the signer of the signed data does not have an RSA key")
+	}
+	digest, ok := digestOf(si.DigestAlgorithm.Algorithm)
+	if !ok {
+		return nil, fmt.Errorf("unsupported digest algorithm %v", si.DigestAlgorithm.Algorithm)
+	}
+
+	m := &signedMessage{content: content, signer: signer, digest: digest, attributes: map[string][]byte{}}
+	for rest := si.AuthenticatedAttributes.Bytes; len(rest) > 0; {
+		var attr attribute
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			return nil, fmt.Errorf("invalid authenticated attribute (error: %v)", err)
+		}
+		m.attributes[attr.Type.String()] = attr.Value.Bytes
+	}
+	var messageDigest []byte
+	if _, err := asn1.Unmarshal(m.attributes[oidMessageDigest.String()], &messageDigest); err != nil {
+		return nil, errors.New("the signed data has no message digest")
+	}
+	h := digest.New()
+	h.Write(content) // nolint: errcheck
+	if !bytes.Equal(h.Sum(nil), messageDigest) {
+		return nil, errors.New("the message digest does not match the signed content")
+	}
+
+	// The signature is over the DER encoding of the attributes as a SET OF.
+	signed := append([]byte{}, si.AuthenticatedAttributes.FullBytes...)
+	signed[0] = 0x31
+	h = digest.New()
+	h.Write(signed) // nolint: errcheck
+	if err := rsa.VerifyPKCS1v15(pub, digest, h.Sum(nil), si.EncryptedDigest); err != nil {
+		return nil, fmt.Errorf("invalid signature of the signed data (error: %v)", err)
+	}
+	return m, nil
+}
+
+// newSignedData returns the DER-encoded ContentInfo of a SignedData of the
+// content, which may be empty, signed by the key of the certificate with
+// the digest and the authenticated attributes.
+func newSignedData(content []byte, digest crypto.Hash, cert *x509.Certificate, key *rsa.PrivateKey,
+	attrs []attribute) ([]byte, error) {
+
+	digestOID := digestAlgorithm(digest)
+	if digestOID == nil {
+		return nil, fmt.Errorf("unsupported digest %v", digest)
+	}
+
+	h := digest.New()
+	h.Write(content) // nolint: errcheck
+	contentType, err := newAttribute(oidContentType, oidData)
+	if err != nil {
+		return nil, err
+	}
+	messageDigest, err := newAttribute(oidMessageDigest, h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	// The attributes are sorted by their encodings, as DER requires of a SET OF.
+	var encoded [][]byte
+	for _, a := range append([]attribute{contentType, messageDigest}, attrs...) {
+		der, err := asn1.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, der)
+	}
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+	signedAttrs := bytes.Join(encoded, nil)
+
+	signed, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: signedAttrs})
+	if err != nil {
+		return nil, err
+	}
+	h = digest.New()
+	h.Write(signed) // nolint: errcheck
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, digest, h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: digestOID, Parameters: asn1.NullRawValue}},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert.Raw},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: issuerAndSerial{
+				Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm: pkix.AlgorithmIdentifier{Algorithm: digestOID, Parameters: asn1.NullRawValue},
+			AuthenticatedAttributes: asn1.RawValue{
+				Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedAttrs,
+			},
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+			EncryptedDigest:           sig,
+		}},
+	}
+	if len(content) > 0 {
+		octets, err := asn1.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		sd.ContentInfo.Content = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: octets}
+	}
+	return wrapContent(oidSignedData, sd)
+}
+
+// decryptEnvelope returns the content of the DER-encoded ContentInfo of an
+// EnvelopedData, which is encrypted to one of the certificates and keys.
+func decryptEnvelope(der []byte, certs []*x509.Certificate, keys []*rsa.PrivateKey) ([]byte, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, fmt.Errorf("invalid PKCS#7 content info (error: %v)", err)
+	}
+	if !ci.ContentType.Equal(oidEnvelopedData) {
+		return nil, fmt.Errorf("unexpected PKCS#7 content type %v (expecting enveloped data)", ci.ContentType)
+	}
+	var ed envelopedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+		return nil, fmt.Errorf("invalid PKCS#7 enveloped data (error: %v)", err)
+	}
+
+	var contentKey []byte
+	for _, ri := range ed.RecipientInfos {
+		for i, cert := range certs {
+			if !bytes.Equal(cert.RawIssuer, ri.IssuerAndSerialNumber.Issuer.FullBytes) ||
+				cert.SerialNumber.Cmp(ri.IssuerAndSerialNumber.SerialNumber) != 0 {
+				continue
+			}
+			var err error
+			if contentKey, err = rsa.DecryptPKCS1v15(rand.Reader, keys[i], ri.EncryptedKey); err != nil {
+				return nil, fmt.Errorf("failed to decrypt the content encryption key (error: %v)", err)
+			}
+		}
+	}
+	if contentKey == nil {
+		return nil, errors.New("the enveloped data is not encrypted to the certificate")
+	}
+
+	eci := ed.EncryptedContentInfo
+	block, err := newBlockCipher(eci.ContentEncryptionAlgorithm.Algorithm, contentKey)
+	if err != nil {
+		return nil, err
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil ||
+		len(iv) != block.BlockSize() {
+		return nil, errors.New("invalid initialization vector of the enveloped data")
+	}
+	ciphertext := eci.EncryptedContent.Bytes
+	if len(ciphertext) == 0 || len(ciphertext)%block.BlockSize() != 0 {
+		return nil, errors.New("invalid length of the encrypted content")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	return unpad(plaintext, block.BlockSize())
+}
+
+// newEnvelope returns the DER-encoded ContentInfo of an EnvelopedData of the
+// content, encrypted with the algorithm to the certificate, which must have
+// an RSA key.
+func newEnvelope(content []byte, algorithm asn1.ObjectIdentifier, recipient *x509.Certificate) ([]byte, error) {
+	pub, ok := recipient.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("the recipient of the enveloped data does not have an RSA key")
+	}
+	size, ok := contentKeySizes[algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported content encryption algorithm %v", algorithm)
+	}
+	contentKey := make([]byte, size)
+	if _, err := rand.Read(contentKey); err != nil {
+		return nil, err
+	}
+	block, err := newBlockCipher(algorithm, contentKey)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, block.BlockSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	ciphertext := pad(content, block.BlockSize())
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, pub, contentKey)
+	if err != nil {
+		return nil, err
+	}
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	return wrapContent(oidEnvelopedData, envelopedData{
+		RecipientInfos: []recipientInfo{{
+			IssuerAndSerialNumber: issuerAndSerial{
+				Issuer:       asn1.RawValue{FullBytes: recipient.RawIssuer},
+				SerialNumber: recipient.SerialNumber,
+			},
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+			EncryptedKey:           encryptedKey,
+		}},
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType: oidData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  algorithm,
+				Parameters: asn1.RawValue{FullBytes: ivDER},
+			},
+			EncryptedContent: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: ciphertext},
+		},
+	})
+}
+
+// envelopeAlgorithm returns the content encryption algorithm of the
+// DER-encoded ContentInfo of an EnvelopedData.
+func envelopeAlgorithm(der []byte) (asn1.ObjectIdentifier, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, err
+	}
+	var ed envelopedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+		return nil, err
+	}
+	return ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Algorithm, nil
+}
+
+// wrapContent returns the DER-encoded ContentInfo of the content of the type.
+func wrapContent(contentType asn1.ObjectIdentifier, content interface{}) ([]byte, error) {
+	der, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: contentType,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der},
+	})
+}
+
+func newBlockCipher(algorithm asn1.ObjectIdentifier, key []byte) (cipher.Block, error) {
+	if size, ok := contentKeySizes[algorithm.String()]; !ok || len(key) != size {
+		return nil, fmt.Errorf("unsupported content encryption algorithm %v", algorithm)
+	}
+	if algorithm.Equal(oidDESEDE3CBC) {
+		return des.NewTripleDESCipher(key)
+	}
+	return aes.NewCipher(key)
+}
+
+// pad returns a copy of the data with PKCS#7 padding to the block size.
+func pad(data []byte, blockSize int) []byte {
+	n := blockSize - len(data)%blockSize
+	return append(append([]byte{}, data...), bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
+func unpad(data []byte, blockSize int) ([]byte, error) {
+	n := int(data[len(data)-1])
+	if n == 0 || n > blockSize || n > len(data) || !bytes.Equal(data[len(data)-n:], bytes.Repeat([]byte{byte(n)}, n)) {
+		return nil, errors.New("invalid padding of the encrypted content")
+	}
+	return data[:len(data)-n], nil
+}
+
+func digestOf(oid asn1.ObjectIdentifier) (crypto.Hash, bool) {
+	for _, a := range digestAlgorithms {
+		if a.oid.Equal(oid) {
+			return a.hash, true
+		}
+	}
+	return 0, false
+}
+
+func digestAlgorithm(hash crypto.Hash) asn1.ObjectIdentifier {
+	for _, a := range digestAlgorithms {
+		if a.hash == hash {
+			return a.oid
+		}
+	}
+	return nil
+}
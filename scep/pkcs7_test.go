@@ -0,0 +1,201 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scep
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/auth/pkg/pkcs7"
+)
+
+// newSelfSignedCert returns a self-signed certificate of a new RSA key, like
+// the ones devices sign their SCEP requests with.
+func newSelfSignedCert(t *testing.T, name string) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// parseCertsOnly returns the certificates of a certificates-only SignedData.
+func parseCertsOnly(t *testing.T, der []byte) []*x509.Certificate {
+	certs, err := pkcs7.ParseCertsOnly(der)
+	if err != nil {
+		t.Fatalf("Invalid certificates-only SignedData (error: %v)", err)
+	}
+	return certs
+}
+
+func TestSignedData(t *testing.T) {
+	cert, key := newSelfSignedCert(t, "signer")
+	transactionID, err := newAttribute(oidTransactionID, printableString("1234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		content     []byte
+		digest      crypto.Hash
+		tamper      func(der []byte) []byte
+		expectedErr string
+	}{
+		"SHA-256": {
+			content: []byte("content"),
+			digest:  crypto.SHA256,
+		},
+		"SHA-1": {
+			content: []byte("content"),
+			digest:  crypto.SHA1,
+		},
+		"No content": {
+			digest: crypto.SHA256,
+		},
+		"Tampered content": {
+			content: []byte("content"),
+			digest:  crypto.SHA256,
+			tamper: func(der []byte) []byte {
+				return bytes.Replace(der, []byte("content"), []byte("CONTENT"), 1)
+			},
+			expectedErr: "the message digest does not match the signed content",
+		},
+		"Tampered attribute": {
+			content: []byte("content"),
+			digest:  crypto.SHA256,
+			tamper: func(der []byte) []byte {
+				return bytes.Replace(der, []byte("1234"), []byte("4321"), 1)
+			},
+			expectedErr: "invalid signature of the signed data",
+		},
+		"Not signed data": {
+			content: []byte("content"),
+			digest:  crypto.SHA256,
+			tamper: func(der []byte) []byte {
+				bad, _ := wrapContent(oidData, []byte("content"))
+				return bad
+			},
+			expectedErr: "expecting signed data",
+		},
+	}
+	for id, tc := range testCases {
+		der, err := newSignedData(tc.content, tc.digest, cert, key, []attribute{transactionID})
+		if err != nil {
+			t.Fatalf("%s: failed to create the signed data: %v", id, err)
+		}
+		if tc.tamper != nil {
+			der = tc.tamper(der)
+		}
+		m, err := parseSignedData(der)
+		if tc.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: expected error %q, got %v", id, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+		if !bytes.Equal(m.content, tc.content) || !m.signer.Equal(cert) || m.digest != tc.digest {
+			t.Errorf("%s: unexpected message %+v", id, m)
+		}
+		var value string
+		if _, err := asn1.Unmarshal(m.attributes[oidTransactionID.String()], &value); err != nil || value != "1234" {
+			t.Errorf("%s: unexpected transaction ID %q (error: %v)", id, value, err)
+		}
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	cert, key := newSelfSignedCert(t, "recipient")
+	otherCert, otherKey := newSelfSignedCert(t, "other")
+	content := []byte("a certificate signing request")
+
+	testCases := map[string]struct {
+		algorithm   asn1.ObjectIdentifier
+		certs       []*x509.Certificate
+		keys        []*rsa.PrivateKey
+		expectedErr string
+	}{
+		"AES-128": {
+			algorithm: oidAES128CBC,
+			certs:     []*x509.Certificate{cert},
+			keys:      []*rsa.PrivateKey{key},
+		},
+		"AES-256": {
+			algorithm: oidAES256CBC,
+			certs:     []*x509.Certificate{otherCert, cert},
+			keys:      []*rsa.PrivateKey{otherKey, key},
+		},
+		"3DES": {
+			algorithm: oidDESEDE3CBC,
+			certs:     []*x509.Certificate{cert},
+			keys:      []*rsa.PrivateKey{key},
+		},
+		"Other recipient": {
+			algorithm:   oidAES128CBC,
+			certs:       []*x509.Certificate{otherCert},
+			keys:        []*rsa.PrivateKey{otherKey},
+			expectedErr: "the enveloped data is not encrypted to the certificate",
+		},
+	}
+	for id, tc := range testCases {
+		der, err := newEnvelope(content, tc.algorithm, cert)
+		if err != nil {
+			t.Fatalf("%s: failed to create the envelope: %v", id, err)
+		}
+		if algorithm, err := envelopeAlgorithm(der); err != nil || !algorithm.Equal(tc.algorithm) {
+			t.Errorf("%s: unexpected algorithm %v (error: %v)", id, algorithm, err)
+		}
+		decrypted, err := decryptEnvelope(der, tc.certs, tc.keys)
+		if tc.expectedErr != "" {
+			if err == nil || err.Error() != tc.expectedErr {
+				t.Errorf("%s: expected error %q, got %v", id, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(decrypted, content) {
+			t.Errorf("%s: unexpected content %q (error: %v)", id, decrypted, err)
+		}
+	}
+
+	if _, err := newEnvelope(content, oidSHA256, cert); err == nil {
+		t.Error("An envelope should not be created with an unsupported algorithm")
+	}
+}
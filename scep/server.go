@@ -0,0 +1,406 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scep serves the Simple Certificate Enrollment Protocol (RFC 8894)
+// for Istio CA, so that legacy appliances that only speak SCEP can get
+// certificates of the mesh. Devices authenticate with the challenge password
+// in their certificate signing request, which a ChallengePolicy maps to the
+// identities they may enroll for.
+//
+// The messages are encrypted to and signed by a registration authority (RA)
+// certificate with an RSA key, which the CA issues to the server, since the
+// signing key of the CA need not be RSA nor be held by Istio CA.
+package scep
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/auth/parse"
+	"istio.io/auth/pkg/pkcs7"
+)
+
+const (
+	raKeySize = 2048
+
+	// The maximum size of a PKIOperation message, which carries a certificate
+	// signing request and the self-signed certificate of the device.
+	maxMessageSize = 4*parse.MaxCSRSize + 16*1024
+
+	// The capabilities answered to GetCACaps.
+	caCaps = "POSTPKIOperation\nSHA-1\nSHA-256\nAES\nDES3\nSCEPStandard\n"
+)
+
+// The SCEP message types, statuses and failure reasons.
+const (
+	messageTypeCertRep = "3"
+	messageTypePKCSReq = "19"
+
+	statusSuccess = "0"
+	statusFailure = "2"
+
+	failBadMessageCheck = "1"
+	failBadRequest      = "2"
+)
+
+// The authenticated attributes of SCEP messages.
+var (
+	oidMessageType    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 2}
+	oidPKIStatus      = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 3}
+	oidFailInfo       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 4}
+	oidSenderNonce    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 5}
+	oidRecipientNonce = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 6}
+	oidTransactionID  = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}
+)
+
+// Signer signs certificate signing requests.
+type Signer interface {
+	Sign(csrPEM []byte) (chain []byte, err error)
+	GetRootCertificate() []byte
+}
+
+// raCertificate is an RA certificate and its key.
+type raCertificate struct {
+	cert *x509.Certificate
+	// The DER-encoded certificate chain, starting with cert.
+	chain [][]byte
+	key   *rsa.PrivateKey
+}
+
+// Server answers the SCEP operations GetCACaps, GetCACert and PKIOperation
+// with PKCSReq messages.
+type Server struct {
+	ca         Signer
+	challenges ChallengePolicy
+
+	// The identity, e.g. the SPIFFE URI of Istio CA, that the RA certificate is issued for.
+	id string
+
+	mutex sync.Mutex
+	// The RA certificate, which is reissued once half of its lifetime has
+	// passed. The previous one still decrypts the messages of devices that
+	// fetched it before.
+	ra, previousRA *raCertificate
+	renewAt        time.Time
+}
+
+// NewServer returns a pointer to a Server enrolling devices with the CA by
+// the challenge policy, whose RA certificate is issued for the identity.
+func NewServer(ca Signer, challenges ChallengePolicy, id string) *Server {
+	return &Server{ca: ca, challenges: challenges, id: id}
+}
+
+// Run serves SCEP over HTTP on the address until an error occurs. SCEP
+// protects its messages itself, and is traditionally served without TLS.
+func (s *Server) Run(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	glog.Infof("Serving SCEP on %s", addr)
+	return s.Serve(lis)
+}
+
+// Serve serves SCEP over HTTP on the listener until an error occurs.
+func (s *Server) Serve(lis net.Listener) error {
+	return (&http.Server{Handler: s}).Serve(lis)
+}
+
+// ServeHTTP answers the SCEP operation in the query, on any path, e.g.
+// /cgi-bin/pkiclient.exe, which devices default to.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch op := r.URL.Query().Get("operation"); op {
+	case "GetCACaps":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(caCaps)) // nolint: errcheck
+	case "GetCACert":
+		s.getCACert(w)
+	case "PKIOperation":
+		s.pkiOperation(w, r)
+	default:
+		http.Error(w, fmt.Sprintf("unsupported SCEP operation %q", op), http.StatusBadRequest)
+	}
+}
+
+// getCACert answers the RA certificate, its chain and the root certificates.
+func (s *Server) getCACert(w http.ResponseWriter) {
+	ra, err := s.raCertificate()
+	if err != nil {
+		glog.Errorf("Failed to issue the SCEP RA certificate (error: %v)", err)
+		http.Error(w, "failed to issue the RA certificate", http.StatusInternalServerError)
+		return
+	}
+	certs := ra.chain
+	for rest := s.ca.GetRootCertificate(); ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		certs = append(certs, block.Bytes)
+	}
+	der, err := pkcs7.CertsOnly(certs)
+	if err != nil {
+		http.Error(w, "failed to encode the certificates", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-x509-ca-ra-cert")
+	w.Write(der) // nolint: errcheck
+}
+
+// pkiOperation answers a PKCSReq message, which is base64-encoded in the
+// query of a GET, or is the body of a POST.
+func (s *Server) pkiOperation(w http.ResponseWriter, r *http.Request) {
+	var msg []byte
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		msg, err = base64.StdEncoding.DecodeString(r.URL.Query().Get("message"))
+	case http.MethodPost:
+		msg, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageSize))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "unreadable SCEP message", http.StatusBadRequest)
+		return
+	}
+
+	req, err := parseSignedData(msg)
+	if err != nil {
+		glog.Warningf("Refused a SCEP message (error: %v)", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var transactionID string
+	if _, err := asn1.Unmarshal(req.attributes[oidTransactionID.String()], &transactionID); err != nil {
+		http.Error(w, "the SCEP message has no transaction ID", http.StatusBadRequest)
+		return
+	}
+	resp, err := s.respond(req, transactionID)
+	if err != nil {
+		glog.Errorf("Failed to answer a SCEP message (error: %v)", err)
+		http.Error(w, "failed to answer the SCEP message", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pki-message")
+	w.Write(resp) // nolint: errcheck
+}
+
+// respond returns the CertRep message answering the verified request of the transaction.
+func (s *Server) respond(req *signedMessage, transactionID string) ([]byte, error) {
+	var messageType string
+	var senderNonce []byte
+	asn1.Unmarshal(req.attributes[oidMessageType.String()], &messageType) // nolint: errcheck
+	asn1.Unmarshal(req.attributes[oidSenderNonce.String()], &senderNonce) // nolint: errcheck
+
+	ra, err := s.raCertificate()
+	if err != nil {
+		return nil, err
+	}
+	rep := &certRep{ra: ra, req: req, transactionID: transactionID, recipientNonce: senderNonce}
+	if messageType != messageTypePKCSReq {
+		glog.Warningf("Refused SCEP transaction %s with unsupported message type %q", transactionID, messageType)
+		return rep.failure(failBadRequest)
+	}
+
+	s.mutex.Lock()
+	certs, keys := []*x509.Certificate{ra.cert}, []*rsa.PrivateKey{ra.key}
+	if s.previousRA != nil {
+		certs, keys = append(certs, s.previousRA.cert), append(keys, s.previousRA.key)
+	}
+	s.mutex.Unlock()
+	csrDER, err := decryptEnvelope(req.content, certs, keys)
+	if err != nil {
+		glog.Warningf("Refused SCEP transaction %s (error: %v)", transactionID, err)
+		return rep.failure(failBadMessageCheck)
+	}
+	algorithm, _ := envelopeAlgorithm(req.content)
+
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+	if err := s.authorize(csrPEM); err != nil {
+		glog.Warningf("Refused SCEP transaction %s (error: %v)", transactionID, err)
+		return rep.failure(failBadRequest)
+	}
+	chain, err := s.ca.Sign(csrPEM)
+	if err != nil {
+		glog.Errorf("Failed to sign the certificate signing request of SCEP transaction %s (error: %v)",
+			transactionID, err)
+		return rep.failure(failBadRequest)
+	}
+
+	var issued [][]byte
+	for rest := chain; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		issued = append(issued, block.Bytes)
+	}
+	degenerate, err := pkcs7.CertsOnly(issued)
+	if err != nil {
+		return nil, err
+	}
+	// Devices that sent the request encrypted with an algorithm decrypt the response with it too.
+	envelope, err := newEnvelope(degenerate, algorithm, req.signer)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Signed a certificate with SCEP (transaction: %s)", transactionID)
+	return rep.success(envelope)
+}
+
+// authorize checks that the certificate signing request asks for a single
+// identity, which its challenge password allows.
+func (s *Server) authorize(csrPEM []byte) error {
+	csr, err := parse.CSR(csrPEM)
+	if err != nil {
+		return err
+	}
+	if len(csr.URIs) != 1 || len(csr.DNSNames)+len(csr.EmailAddresses)+len(csr.IPAddresses) != 0 {
+		return fmt.Errorf("the certificate signing request must ask for a single identity only")
+	}
+	challenge, err := challengePassword(csr)
+	if err != nil {
+		return err
+	}
+	if id := csr.URIs[0].String(); !s.challenges.Allows(challenge, id) {
+		return fmt.Errorf("the challenge password does not allow enrolling for %s", id)
+	}
+	return nil
+}
+
+// certRep builds the CertRep messages answering a request.
+type certRep struct {
+	ra             *raCertificate
+	req            *signedMessage
+	transactionID  string
+	recipientNonce []byte
+}
+
+func (c *certRep) success(envelope []byte) ([]byte, error) {
+	return c.build(statusSuccess, "", envelope)
+}
+
+func (c *certRep) failure(failInfo string) ([]byte, error) {
+	return c.build(statusFailure, failInfo, nil)
+}
+
+func (c *certRep) build(status, failInfo string, content []byte) ([]byte, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	var attrs []attribute
+	var err error
+	add := func(t asn1.ObjectIdentifier, value interface{}) {
+		if err == nil {
+			var a attribute
+			a, err = newAttribute(t, value)
+			attrs = append(attrs, a)
+		}
+	}
+	add(oidMessageType, printableString(messageTypeCertRep))
+	add(oidPKIStatus, printableString(status))
+	add(oidTransactionID, printableString(c.transactionID))
+	add(oidSenderNonce, nonce)
+	if failInfo != "" {
+		add(oidFailInfo, printableString(failInfo))
+	}
+	if len(c.recipientNonce) > 0 {
+		add(oidRecipientNonce, c.recipientNonce)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newSignedData(content, c.req.digest, c.ra.cert, c.ra.key, attrs)
+}
+
+// printableString returns the value to marshal as an ASN.1 PrintableString,
+// which SCEP uses for its string attributes.
+func printableString(s string) asn1.RawValue {
+	return asn1.RawValue{Tag: asn1.TagPrintableString, Bytes: []byte(s)}
+}
+
+// raCertificate returns the RA certificate, which it has the CA reissue once
+// half of its lifetime has passed.
+func (s *Server) raCertificate() (*raCertificate, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if s.ra != nil && now.Before(s.renewAt) {
+		return s.ra, nil
+	}
+	ra, err := s.issueRACertificate()
+	if err != nil {
+		if s.ra != nil && now.Before(s.ra.cert.NotAfter) {
+			glog.Errorf("Failed to renew the SCEP RA certificate (error: %v)", err)
+			return s.ra, nil
+		}
+		return nil, err
+	}
+	s.previousRA, s.ra = s.ra, ra
+	s.renewAt = ra.cert.NotBefore.Add(ra.cert.NotAfter.Sub(ra.cert.NotBefore) / 2)
+	return s.ra, nil
+}
+
+func (s *Server) issueRACertificate() (*raCertificate, error) {
+	id, err := url.Parse(s.id)
+	if err != nil {
+		return nil, err
+	}
+	key, err := rsa.GenerateKey(rand.Reader, raKeySize)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{URIs: []*url.URL{id}}, key)
+	if err != nil {
+		return nil, err
+	}
+	chainPEM, err := s.ca.Sign(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	if err != nil {
+		return nil, err
+	}
+
+	ra := &raCertificate{key: key}
+	for rest := chainPEM; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		ra.chain = append(ra.chain, block.Bytes)
+	}
+	if len(ra.chain) == 0 {
+		return nil, fmt.Errorf("the CA issued no RA certificate")
+	}
+	if ra.cert, err = x509.ParseCertificate(ra.chain[0]); err != nil {
+		return nil, err
+	}
+	return ra, nil
+}
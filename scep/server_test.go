@@ -0,0 +1,278 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scep
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager"
+	"istio.io/auth/certmanager/testutil"
+)
+
+const (
+	testRAID     = "spiffe://cluster.local/ns/istio-system/sa/istio-ca-service-account"
+	testDeviceID = "spiffe://cluster.local/ns/devices/sa/router"
+)
+
+func newTestServer(t *testing.T) (*Server, *certmanager.IstioCA) {
+	ca := testutil.NewCA(t, 30*time.Minute)
+	return NewServer(ca, ChallengePolicy{"s3cret": {"spiffe://cluster.local/ns/devices/*"}}, testRAID), ca
+}
+
+func getCACert(t *testing.T, s *Server) []*x509.Certificate {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cgi-bin/pkiclient.exe?operation=GetCACert", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status of GetCACert %d: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-x509-ca-ra-cert" {
+		t.Errorf("Unexpected content type of GetCACert %q", ct)
+	}
+	return parseCertsOnly(t, w.Body.Bytes())
+}
+
+func TestGetCACert(t *testing.T) {
+	s, ca := newTestServer(t)
+	certs := getCACert(t, s)
+	if len(certs) != 2 {
+		t.Fatalf("Expected the RA and the root certificates, got %d certificates", len(certs))
+	}
+	if len(certs[0].URIs) != 1 || certs[0].URIs[0].String() != testRAID {
+		t.Errorf("Unexpected identities of the RA certificate %v", certs[0].URIs)
+	}
+	if root, _ := pem.Decode(ca.GetRootCertificate()); !bytes.Equal(certs[1].Raw, root.Bytes) {
+		t.Error("The root certificate should follow the RA certificate")
+	}
+	if again := getCACert(t, s); !again[0].Equal(certs[0]) {
+		t.Error("The RA certificate should be reused until half of its lifetime has passed")
+	}
+}
+
+func TestGetCACaps(t *testing.T) {
+	s, _ := newTestServer(t)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/scep?operation=GetCACaps", nil))
+	if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte("POSTPKIOperation\n")) {
+		t.Errorf("Unexpected response to GetCACaps %d: %q", w.Code, w.Body)
+	}
+}
+
+func TestPKIOperation(t *testing.T) {
+	s, ca := newTestServer(t)
+	ra := getCACert(t, s)[0]
+	deviceCert, deviceKey := newSelfSignedCert(t, "router")
+	otherCert, _ := newSelfSignedCert(t, "other")
+
+	testCases := map[string]struct {
+		method           string
+		id               string
+		challenge        string
+		messageType      string
+		algorithm        asn1.ObjectIdentifier
+		recipient        *x509.Certificate
+		expectedStatus   string
+		expectedFailInfo string
+	}{
+		"Enrolled with POST": {
+			method:         http.MethodPost,
+			id:             testDeviceID,
+			challenge:      "s3cret",
+			messageType:    messageTypePKCSReq,
+			algorithm:      oidAES128CBC,
+			recipient:      ra,
+			expectedStatus: statusSuccess,
+		},
+		"Enrolled with GET and 3DES": {
+			method:         http.MethodGet,
+			id:             testDeviceID,
+			challenge:      "s3cret",
+			messageType:    messageTypePKCSReq,
+			algorithm:      oidDESEDE3CBC,
+			recipient:      ra,
+			expectedStatus: statusSuccess,
+		},
+		"Wrong challenge password": {
+			method:           http.MethodPost,
+			id:               testDeviceID,
+			challenge:        "guess",
+			messageType:      messageTypePKCSReq,
+			algorithm:        oidAES128CBC,
+			recipient:        ra,
+			expectedStatus:   statusFailure,
+			expectedFailInfo: failBadRequest,
+		},
+		"Identity not allowed": {
+			method:           http.MethodPost,
+			id:               "spiffe://cluster.local/ns/default/sa/admin",
+			challenge:        "s3cret",
+			messageType:      messageTypePKCSReq,
+			algorithm:        oidAES128CBC,
+			recipient:        ra,
+			expectedStatus:   statusFailure,
+			expectedFailInfo: failBadRequest,
+		},
+		"Not encrypted to the RA": {
+			method:           http.MethodPost,
+			id:               testDeviceID,
+			challenge:        "s3cret",
+			messageType:      messageTypePKCSReq,
+			algorithm:        oidAES128CBC,
+			recipient:        otherCert,
+			expectedStatus:   statusFailure,
+			expectedFailInfo: failBadMessageCheck,
+		},
+		"Unsupported message type": {
+			method:           http.MethodPost,
+			id:               testDeviceID,
+			challenge:        "s3cret",
+			messageType:      "20",
+			algorithm:        oidAES128CBC,
+			recipient:        ra,
+			expectedStatus:   statusFailure,
+			expectedFailInfo: failBadRequest,
+		},
+	}
+	for name, tc := range testCases {
+		envelope, err := newEnvelope(createCSR(t, deviceKey, tc.id, tc.challenge), tc.algorithm, tc.recipient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var attrs []attribute
+		for _, a := range []struct {
+			oid   asn1.ObjectIdentifier
+			value interface{}
+		}{
+			{oidMessageType, printableString(tc.messageType)},
+			{oidTransactionID, printableString("transaction")},
+			{oidSenderNonce, []byte("nonce")},
+		} {
+			attr, err := newAttribute(a.oid, a.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			attrs = append(attrs, attr)
+		}
+		msg, err := newSignedData(envelope, crypto.SHA256, deviceCert, deviceKey, attrs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var r *http.Request
+		if tc.method == http.MethodGet {
+			r = httptest.NewRequest(http.MethodGet, "/scep?operation=PKIOperation&message="+
+				url.QueryEscape(base64.StdEncoding.EncodeToString(msg)), nil)
+		} else {
+			r = httptest.NewRequest(http.MethodPost, "/scep?operation=PKIOperation", bytes.NewReader(msg))
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: unexpected status %d: %s", name, w.Code, w.Body)
+			continue
+		}
+
+		rep, err := parseSignedData(w.Body.Bytes())
+		if err != nil {
+			t.Errorf("%s: invalid CertRep: %v", name, err)
+			continue
+		}
+		if !rep.signer.Equal(ra) {
+			t.Errorf("%s: the CertRep should be signed by the RA", name)
+		}
+		var messageType, status, failInfo, transactionID string
+		var recipientNonce []byte
+		asn1.Unmarshal(rep.attributes[oidMessageType.String()], &messageType)       // nolint: errcheck
+		asn1.Unmarshal(rep.attributes[oidPKIStatus.String()], &status)              // nolint: errcheck
+		asn1.Unmarshal(rep.attributes[oidFailInfo.String()], &failInfo)             // nolint: errcheck
+		asn1.Unmarshal(rep.attributes[oidTransactionID.String()], &transactionID)   // nolint: errcheck
+		asn1.Unmarshal(rep.attributes[oidRecipientNonce.String()], &recipientNonce) // nolint: errcheck
+		if messageType != messageTypeCertRep || transactionID != "transaction" || string(recipientNonce) != "nonce" {
+			t.Errorf("%s: unexpected CertRep attributes %q, %q, %q", name, messageType, transactionID, recipientNonce)
+		}
+		if status != tc.expectedStatus || failInfo != tc.expectedFailInfo {
+			t.Errorf("%s: expected status %q and failure %q, got %q and %q", name, tc.expectedStatus,
+				tc.expectedFailInfo, status, failInfo)
+			continue
+		}
+		if status != statusSuccess {
+			continue
+		}
+
+		degenerate, err := decryptEnvelope(rep.content, []*x509.Certificate{deviceCert}, []*rsa.PrivateKey{deviceKey})
+		if err != nil {
+			t.Errorf("%s: failed to decrypt the CertRep: %v", name, err)
+			continue
+		}
+		if algorithm, _ := envelopeAlgorithm(rep.content); !algorithm.Equal(tc.algorithm) {
+			t.Errorf("%s: the CertRep should be encrypted with the algorithm of the request", name)
+		}
+		certs := parseCertsOnly(t, degenerate)
+		if len(certs) == 0 || len(certs[0].URIs) != 1 || certs[0].URIs[0].String() != tc.id ||
+			!certs[0].PublicKey.(*rsa.PublicKey).Equal(&deviceKey.PublicKey) {
+			t.Errorf("%s: the CertRep should hold a certificate of the device key for %s", name, tc.id)
+			continue
+		}
+		if root, _ := pem.Decode(ca.GetRootCertificate()); certs[0].CheckSignatureFrom(mustParse(t, root.Bytes)) != nil {
+			t.Errorf("%s: the certificate is not issued by the CA", name)
+		}
+	}
+}
+
+func TestPKIOperationMalformed(t *testing.T) {
+	s, _ := newTestServer(t)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/scep?operation=PKIOperation",
+		bytes.NewReader([]byte("not a message"))))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// A message without the SCEP attributes cannot be answered with a CertRep.
+	cert, key := newSelfSignedCert(t, "router")
+	msg, err := newSignedData([]byte("content"), crypto.SHA256, cert, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/scep?operation=PKIOperation", bytes.NewReader(msg)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a message without a transaction ID, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/scep?operation=GetCRL", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unsupported operation, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func mustParse(t *testing.T, der []byte) *x509.Certificate {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
@@ -0,0 +1,171 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selftest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	canaryIssuances = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "istio_ca",
+		Subsystem: "canary",
+		Name:      "issuances_total",
+		Help:      "The number of canary issuances, by canary and result ('success' or 'failure').",
+	}, []string{"canary", "result"})
+
+	canaryLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "istio_ca",
+		Subsystem: "canary",
+		Name:      "issuance_duration_seconds",
+		Help:      "The time to sign the certificate signing requests of the canaries, by canary.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+	}, []string{"canary"})
+
+	canaryLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "istio_ca",
+		Subsystem: "canary",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "The Unix time of the last successful canary issuance, by canary.",
+	}, []string{"canary"})
+)
+
+func init() {
+	prometheus.MustRegister(canaryIssuances)
+	prometheus.MustRegister(canaryLatency)
+	prometheus.MustRegister(canaryLastSuccess)
+}
+
+// Canary issues certificates for a synthetic identity through the same path
+// as the workload certificate signing requests, and verifies them end to end,
+// so that a broken signer or signing backend is detected before workloads
+// fail to rotate their certificates.
+type Canary struct {
+	name string
+	id   *url.URL
+
+	sign func(csrPEM []byte) (chain []byte, err error)
+	root func() []byte
+}
+
+// NewCanary returns a pointer to a Canary, labeled with the name in the
+// metrics, that requests certificates for the URI ID through sign, and
+// verifies them against the PEM-encoded root certificate returned by root.
+func NewCanary(name, id string, sign func(csrPEM []byte) ([]byte, error), root func() []byte) (*Canary, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid canary identity %q (error: %v)", id, err)
+	}
+	return &Canary{name: name, id: u, sign: sign, root: root}, nil
+}
+
+// Run issues and verifies a canary certificate, and records the outcome in the metrics.
+func (c *Canary) Run() error {
+	err := c.run()
+	if err != nil {
+		glog.Errorf("Canary issuance %q failed (error: %v)", c.name, err)
+		canaryIssuances.WithLabelValues(c.name, "failure").Inc()
+		return err
+	}
+	canaryIssuances.WithLabelValues(c.name, "success").Inc()
+	canaryLastSuccess.WithLabelValues(c.name).Set(float64(time.Now().Unix()))
+	return nil
+}
+
+// RunEvery runs the canary every interval until stopCh is closed, starting immediately.
+func (c *Canary) RunEvery(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.Run() // nolint: errcheck
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Canary) run() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate the canary key (error: %v)", err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{URIs: []*url.URL{c.id}}, key)
+	if err != nil {
+		return fmt.Errorf("failed to create the canary certificate signing request (error: %v)", err)
+	}
+
+	start := time.Now()
+	chain, err := c.sign(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	canaryLatency.WithLabelValues(c.name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to sign the canary certificate signing request (error: %v)", err)
+	}
+
+	certs := []*x509.Certificate{}
+	for rest := chain; len(bytes.TrimSpace(rest)) != 0; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			return errors.New("invalid PEM encoding in the canary certificate chain")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse the canary certificate chain (error: %v)", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return errors.New("the canary certificate chain is empty")
+	}
+
+	leaf := certs[0]
+	if pub, ok := leaf.PublicKey.(*ecdsa.PublicKey); !ok || pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+		return errors.New("the canary certificate does not certify the requested key")
+	}
+	if len(leaf.URIs) != 1 || leaf.URIs[0].String() != c.id.String() {
+		return fmt.Errorf("the canary certificate is issued for %v instead of %s", leaf.URIs, c.id)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(c.root()) {
+		return errors.New("failed to parse the root certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Intermediates: intermediates,
+		Roots:         roots,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to verify the canary certificate (error: %v)", err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selftest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func genRoot(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"Istio"}},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestCanary(t *testing.T) {
+	const id = "spiffe://cluster.local/ns/istio-ca-canary/sa/canary"
+	root, rootKey, rootPEM := genRoot(t)
+	_, _, otherRootPEM := genRoot(t)
+
+	// signer returns a sign function issuing certificates for the URIs, or
+	// those of the certificate signing request if nil.
+	signer := func(uris []*url.URL) func([]byte) ([]byte, error) {
+		return func(csrPEM []byte) ([]byte, error) {
+			block, _ := pem.Decode(csrPEM)
+			csr, err := x509.ParseCertificateRequest(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			if uris == nil {
+				uris = csr.URIs
+			}
+			der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+				SerialNumber: big.NewInt(2),
+				NotBefore:    time.Now().Add(-time.Minute),
+				NotAfter:     time.Now().Add(time.Hour),
+				URIs:         uris,
+			}, root, csr.PublicKey, rootKey)
+			if err != nil {
+				return nil, err
+			}
+			return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+		}
+	}
+	other, _ := url.Parse("spiffe://cluster.local/ns/foo/sa/bar")
+
+	testCases := map[string]struct {
+		sign        func([]byte) ([]byte, error)
+		root        []byte
+		expectError bool
+	}{
+		"Valid canary certificate": {
+			sign: signer(nil),
+			root: rootPEM,
+		},
+		"Signing failure": {
+			sign:        func([]byte) ([]byte, error) { return nil, errors.New("signer unavailable") },
+			root:        rootPEM,
+			expectError: true,
+		},
+		"Wrong identity": {
+			sign:        signer([]*url.URL{other}),
+			root:        rootPEM,
+			expectError: true,
+		},
+		"Untrusted chain": {
+			sign:        signer(nil),
+			root:        otherRootPEM,
+			expectError: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		root := tc.root
+		c, err := NewCanary("test", id, tc.sign, func() []byte { return root })
+		if err != nil {
+			t.Fatalf("%s: failed to create the canary: %v", name, err)
+		}
+		if err := c.Run(); (err != nil) != tc.expectError {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package selftest runs the checks Istio CA must pass on startup before it
+// reports itself as ready.
+
+package selftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The earliest time the CA clock may report. A clock behind this is certainly wrong.
+var minimumTime = time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var checkPassed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "istio_ca",
+	Name:      "self_test_passed",
+	Help:      "Whether the named startup self-test check passed (1) or failed (0).",
+}, []string{"check"})
+
+func init() {
+	prometheus.MustRegister(checkPassed)
+}
+
+// Check is a named startup check.
+type Check struct {
+	Name string
+	Run  func() error
+}
+
+// Result is the outcome of a Check.
+type Result struct {
+	Name     string        `json:"name"`
+	Passed   bool          `json:"passed"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Suite runs a set of checks and serves the results as a readiness endpoint.
+// The suite is ready only after a run in which all checks passed.
+type Suite struct {
+	checks []Check
+
+	mutex   sync.RWMutex
+	results []Result
+	ready   bool
+}
+
+// NewSuite returns a pointer to a Suite of the given checks.
+func NewSuite(checks ...Check) *Suite {
+	return &Suite{checks: checks}
+}
+
+// Run runs all checks, records their results, and returns true if all passed.
+func (s *Suite) Run() bool {
+	results := []Result{}
+	ready := true
+	for _, c := range s.checks {
+		start := time.Now()
+		err := c.Run()
+		r := Result{Name: c.Name, Passed: err == nil, Duration: time.Since(start)}
+		if err != nil {
+			ready = false
+			r.Error = err.Error()
+			glog.Errorf("Self-test check %q failed (error: %v)", c.Name, err)
+			checkPassed.WithLabelValues(c.Name).Set(0)
+		} else {
+			glog.Infof("Self-test check %q passed", c.Name)
+			checkPassed.WithLabelValues(c.Name).Set(1)
+		}
+		results = append(results, r)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.results = results
+	s.ready = ready
+	return ready
+}
+
+// RunEvery runs all checks every interval until stopCh is closed, starting
+// immediately, so that the results follow conditions that change over time.
+func (s *Suite) RunEvery(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.Run()
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Ready returns whether the last run passed all checks.
+func (s *Suite) Ready() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.ready
+}
+
+// ServeHTTP responds with the results of the last run, with status 200 if the
+// suite is ready and 503 otherwise.
+func (s *Suite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	Handler(s).ServeHTTP(w, r)
+}
+
+// Handler returns an HTTP handler that responds with the results of the last
+// runs of all the suites, with status 200 if all are ready and 503 otherwise.
+func Handler(suites ...*Suite) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		results := []Result{}
+		ready := true
+		for _, s := range suites {
+			s.mutex.RLock()
+			results = append(results, s.results...)
+			ready = ready && s.ready
+			s.mutex.RUnlock()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(results); err != nil {
+			glog.Errorf("Failed to write the self-test results (error: %v)", err)
+		}
+	})
+}
+
+// ClockCheck returns a Check that the local clock is plausible and within
+// the validity period of the given certificate, e.g. the root certificate.
+func ClockCheck(notBefore, notAfter time.Time) Check {
+	return Check{
+		Name: "clock",
+		Run: func() error {
+			now := time.Now()
+			if now.Before(minimumTime) {
+				return fmt.Errorf("the clock (%v) is behind %v", now, minimumTime)
+			}
+			if now.Before(notBefore) || now.After(notAfter) {
+				return fmt.Errorf("the clock (%v) is outside the validity period of the root certificate (%v to %v)",
+					now, notBefore, notAfter)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selftest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSuite(t *testing.T) {
+	pass := Check{Name: "pass", Run: func() error { return nil }}
+	fail := Check{Name: "fail", Run: func() error { return errors.New("broken") }}
+
+	testCases := map[string]struct {
+		checks         []Check
+		expectedReady  bool
+		expectedStatus int
+		expectedErrors map[string]string
+	}{
+		"All checks pass": {
+			checks:         []Check{pass},
+			expectedReady:  true,
+			expectedStatus: http.StatusOK,
+			expectedErrors: map[string]string{"pass": ""},
+		},
+		"One check fails": {
+			checks:         []Check{pass, fail},
+			expectedReady:  false,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedErrors: map[string]string{"pass": "", "fail": "broken"},
+		},
+	}
+
+	for id, tc := range testCases {
+		s := NewSuite(tc.checks...)
+		if s.Ready() {
+			t.Errorf("%s: suite should not be ready before running", id)
+		}
+		if ready := s.Run(); ready != tc.expectedReady || s.Ready() != tc.expectedReady {
+			t.Errorf("%s: unexpected readiness (expecting %v, actual %v)", id, tc.expectedReady, ready)
+		}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		if w.Code != tc.expectedStatus {
+			t.Errorf("%s: unexpected status code (expecting %d, actual %d)", id, tc.expectedStatus, w.Code)
+		}
+		results := []Result{}
+		if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+			t.Fatalf("%s: failed to decode the results: %v", id, err)
+		}
+		if len(results) != len(tc.expectedErrors) {
+			t.Errorf("%s: unexpected results %v", id, results)
+		}
+		for _, r := range results {
+			if expected, ok := tc.expectedErrors[r.Name]; !ok || r.Error != expected || r.Passed != (expected == "") {
+				t.Errorf("%s: unexpected result %+v", id, r)
+			}
+		}
+	}
+}
+
+func TestClockCheck(t *testing.T) {
+	now := time.Now()
+	testCases := map[string]struct {
+		notBefore   time.Time
+		notAfter    time.Time
+		expectError bool
+	}{
+		"Within validity": {
+			notBefore: now.Add(-time.Hour),
+			notAfter:  now.Add(time.Hour),
+		},
+		"Before validity": {
+			notBefore:   now.Add(time.Hour),
+			notAfter:    now.Add(2 * time.Hour),
+			expectError: true,
+		},
+		"After validity": {
+			notBefore:   now.Add(-2 * time.Hour),
+			notAfter:    now.Add(-time.Hour),
+			expectError: true,
+		},
+	}
+
+	for id, tc := range testCases {
+		err := ClockCheck(tc.notBefore, tc.notAfter).Run()
+		if (err != nil) != tc.expectError {
+			t.Errorf("%s: unexpected error %v", id, err)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	pass := NewSuite(Check{Name: "pass", Run: func() error { return nil }})
+	fail := NewSuite(Check{Name: "fail", Run: func() error { return errors.New("broken") }})
+	pass.Run()
+	fail.Run()
+
+	testCases := map[string]struct {
+		suites          []*Suite
+		expectedStatus  int
+		expectedResults int
+	}{
+		"All suites are ready": {
+			suites:          []*Suite{pass},
+			expectedStatus:  http.StatusOK,
+			expectedResults: 1,
+		},
+		"A suite is not ready": {
+			suites:          []*Suite{pass, fail},
+			expectedStatus:  http.StatusServiceUnavailable,
+			expectedResults: 2,
+		},
+	}
+
+	for id, tc := range testCases {
+		w := httptest.NewRecorder()
+		Handler(tc.suites...).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		if w.Code != tc.expectedStatus {
+			t.Errorf("%s: unexpected status code (expecting %d, actual %d)", id, tc.expectedStatus, w.Code)
+		}
+		results := []Result{}
+		if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil || len(results) != tc.expectedResults {
+			t.Errorf("%s: unexpected results %s (error: %v)", id, w.Body.String(), err)
+		}
+	}
+}
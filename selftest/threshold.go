@@ -0,0 +1,62 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selftest
+
+import (
+	"fmt"
+	"time"
+)
+
+// ExpiringCertificatesCheck returns a Check that fails if more than
+// maxFraction of the certificates, whose expiry times are returned by
+// expiries, expire within the window, e.g. because their rotation is stuck.
+func ExpiringCertificatesCheck(expiries func() []time.Time, window time.Duration, maxFraction float64) Check {
+	return Check{
+		Name: "expiring-certificates",
+		Run: func() error {
+			notAfters := expiries()
+			if len(notAfters) == 0 {
+				return nil
+			}
+			deadline := time.Now().Add(window)
+			expiring := 0
+			for _, t := range notAfters {
+				if t.Before(deadline) {
+					expiring++
+				}
+			}
+			if fraction := float64(expiring) / float64(len(notAfters)); fraction > maxFraction {
+				return fmt.Errorf("%d of %d certificates (%.1f%%) expire within %v, beyond the threshold of %.1f%%",
+					expiring, len(notAfters), 100*fraction, window, 100*maxFraction)
+			}
+			return nil
+		},
+	}
+}
+
+// RootExpiryCheck returns a Check that fails if the root certificate, whose
+// expiry time is returned by notAfter, expires within minRemaining.
+func RootExpiryCheck(notAfter func() time.Time, minRemaining time.Duration) Check {
+	return Check{
+		Name: "root-expiry",
+		Run: func() error {
+			expiry := notAfter()
+			if remaining := time.Until(expiry); remaining < minRemaining {
+				return fmt.Errorf("the root certificate expires at %v, within %v", expiry, minRemaining)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selftest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpiringCertificatesCheck(t *testing.T) {
+	now := time.Now()
+	soon, later := now.Add(time.Minute), now.Add(time.Hour)
+
+	testCases := map[string]struct {
+		expiries    []time.Time
+		expectError bool
+	}{
+		"No certificates": {},
+		"Few certificates expire soon": {
+			expiries: []time.Time{soon, later, later, later, later},
+		},
+		"Too many certificates expire soon": {
+			expiries:    []time.Time{soon, soon, later},
+			expectError: true,
+		},
+	}
+
+	for id, tc := range testCases {
+		check := ExpiringCertificatesCheck(func() []time.Time { return tc.expiries }, 10*time.Minute, 0.25)
+		if err := check.Run(); (err != nil) != tc.expectError {
+			t.Errorf("%s: unexpected error %v", id, err)
+		}
+	}
+}
+
+func TestRootExpiryCheck(t *testing.T) {
+	testCases := map[string]struct {
+		notAfter    time.Time
+		expectError bool
+	}{
+		"Root expires later": {
+			notAfter: time.Now().Add(72 * time.Hour),
+		},
+		"Root expires soon": {
+			notAfter:    time.Now().Add(time.Hour),
+			expectError: true,
+		},
+	}
+
+	for id, tc := range testCases {
+		check := RootExpiryCheck(func() time.Time { return tc.notAfter }, 24*time.Hour)
+		if err := check.Run(); (err != nil) != tc.expectError {
+			t.Errorf("%s: unexpected error %v", id, err)
+		}
+	}
+}
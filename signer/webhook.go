@@ -0,0 +1,128 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package signer implements signing keys held by external signing services.
+package signer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// WebhookRequest is the body POSTed to the webhook, with the []byte fields
+// base64-encoded in JSON.
+type WebhookRequest struct {
+	// The DER-encoded TBSCertificate to sign.
+	TBSCertificate []byte `json:"tbsCertificate"`
+
+	// The signature algorithm named in the TBSCertificate, e.g. "SHA256-RSA" or "ECDSA-SHA256".
+	SignatureAlgorithm string `json:"signatureAlgorithm"`
+}
+
+// WebhookResponse is the body the webhook responds with on success.
+type WebhookResponse struct {
+	// The signature of the TBSCertificate, in the encoding of a certificate signature.
+	Signature []byte `json:"signature"`
+}
+
+// Webhook is a certmanager.TBSSigner that has an HTTPS endpoint, e.g. a
+// corporate signing service, sign the certificates. The CA checks every
+// returned signature against the signing certificate.
+type Webhook struct {
+	endpoint string
+	public   crypto.PublicKey
+	token    string
+	client   *http.Client
+}
+
+// NewWebhook returns a pointer to a Webhook signing with the key of the
+// public key at the HTTPS endpoint. The server certificate of the endpoint is
+// verified against the PEM-encoded caCert, or the system roots if it is
+// empty. The token, if not empty, is sent as a bearer token.
+func NewWebhook(endpoint string, public crypto.PublicKey, caCert []byte, token string) (*Webhook, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook endpoint %q: expect an https:// URL", endpoint)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(caCert) > 0 {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("the CA certificate of the webhook holds no PEM-encoded certificate")
+		}
+	}
+	return &Webhook{
+		endpoint: endpoint,
+		public:   public,
+		token:    token,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// Public implements crypto.Signer.
+func (w *Webhook) Public() crypto.PublicKey {
+	return w.public
+}
+
+// Sign implements crypto.Signer. It always fails, as the webhook only signs
+// whole TBSCertificates.
+func (w *Webhook) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return nil, errors.New("the webhook only signs whole certificates")
+}
+
+// SignTBS implements certmanager.TBSSigner.
+func (w *Webhook) SignTBS(tbs []byte, algo x509.SignatureAlgorithm) ([]byte, error) {
+	body, err := json.Marshal(WebhookRequest{TBSCertificate: tbs, SignatureAlgorithm: algo.String()})
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequest(http.MethodPost, w.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if w.token != "" {
+		r.Header.Set("Authorization", "Bearer "+w.token)
+	}
+	res, err := w.client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close() // nolint: errcheck
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the signing webhook failed (status: %s)", res.Status)
+	}
+
+	var resp WebhookResponse
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Signature) == 0 {
+		return nil, errors.New("the signing webhook returned no signature")
+	}
+	return resp.Signature, nil
+}
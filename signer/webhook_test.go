@@ -0,0 +1,106 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req WebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SignatureAlgorithm != "ECDSA-SHA256" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		digest := sha256.Sum256(req.TBSCertificate)
+		sig, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(WebhookResponse{Signature: sig}) // nolint: errcheck
+	}))
+	defer server.Close()
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	testCases := map[string]struct {
+		endpoint    string
+		caCert      []byte
+		token       string
+		expectedErr string
+	}{
+		"Signed": {
+			endpoint: server.URL,
+			caCert:   caCert,
+			token:    "secret",
+		},
+		"Unauthorized": {
+			endpoint:    server.URL,
+			caCert:      caCert,
+			token:       "wrong",
+			expectedErr: "the signing webhook failed (status: 401 Unauthorized)",
+		},
+		"Untrusted server": {
+			endpoint:    server.URL,
+			token:       "secret",
+			expectedErr: "certificate",
+		},
+		"Plain HTTP": {
+			endpoint:    strings.Replace(server.URL, "https://", "http://", 1),
+			expectedErr: "expect an https:// URL",
+		},
+	}
+	for id, tc := range testCases {
+		w, err := NewWebhook(tc.endpoint, key.Public(), tc.caCert, tc.token)
+		var sig []byte
+		if err == nil {
+			sig, err = w.SignTBS([]byte("tbs"), x509.ECDSAWithSHA256)
+		}
+		if tc.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: expected error %q, got %v", id, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+		digest := sha256.Sum256([]byte("tbs"))
+		if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+			t.Errorf("%s: invalid signature", id)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package state implements the stores persisting the issuance state of Istio
+// CA, see certmanager.StateStore.
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/auth/certmanager"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	rbac "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+)
+
+/* #nosec: disable gas linter */
+const (
+	// The data keys of the ConfigMap.
+	issuancesKey    = "issuances"
+	revocationsKey  = "revocations"
+	lastIssuanceKey = "last-issuance"
+
+	maxUpdateAttempts  = 5
+	conflictRetryDelay = 100 * time.Millisecond
+)
+
+// configMapState is the content of the ConfigMap, keyed by the string form of the serial numbers.
+type configMapState struct {
+	issuances    map[string]issuance
+	revocations  map[string]time.Time
+	lastIssuance time.Time
+}
+
+type issuance struct {
+	ID          string    `json:"id"`
+	RequestedBy string    `json:"requestedBy,omitempty"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+}
+
+// ConfigMapStore is a certmanager.StateStore backed by a ConfigMap, which
+// needs no storage outside of the cluster. The replicas of Istio CA update
+// the ConfigMap with optimistic concurrency, retrying on conflicts. The
+// records of expired certificates are dropped on each issuance, but a
+// ConfigMap is limited to 1 MiB, i.e. several thousand unexpired
+// certificates; larger meshes should use a SQLStore.
+type ConfigMapStore struct {
+	// Serializes the updates of this replica, which would otherwise conflict.
+	mutex sync.Mutex
+
+	configMaps corev1.ConfigMapsGetter
+	namespace  string
+	name       string
+}
+
+// NewConfigMapStore returns a pointer to a ConfigMapStore persisting the state
+// in the named ConfigMap, which is created on the first update.
+func NewConfigMapStore(configMaps corev1.ConfigMapsGetter, namespace, name string) *ConfigMapStore {
+	return &ConfigMapStore{configMaps: configMaps, namespace: namespace, name: name}
+}
+
+// ConfigMapStoreRules returns the RBAC rules needed by a ConfigMapStore
+// persisting the state in the named ConfigMap. A create can not be
+// restricted to a resource name, so only the reads and updates are.
+func ConfigMapStoreRules(name string) []rbac.PolicyRule {
+	return []rbac.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"configmaps"},
+			Verbs:     []string{"create"},
+		},
+		{
+			APIGroups:     []string{""},
+			Resources:     []string{"configmaps"},
+			ResourceNames: []string{name},
+			Verbs:         []string{"get", "update"},
+		},
+	}
+}
+
+// Load implements certmanager.IssuanceTimeStore.
+func (s *ConfigMapStore) Load() (time.Time, error) {
+	st, _, err := s.get()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return st.lastIssuance, nil
+}
+
+// Store implements certmanager.IssuanceTimeStore.
+func (s *ConfigMapStore) Store(t time.Time) error {
+	return s.update(func(st *configMapState) (bool, error) {
+		if !t.After(st.lastIssuance) {
+			return false, nil
+		}
+		st.lastIssuance = t
+		return true, nil
+	})
+}
+
+// AddIssuance implements certmanager.StateStore.
+func (s *ConfigMapStore) AddIssuance(r certmanager.IssuanceRecord) error {
+	key := r.SerialNumber.String()
+	now := time.Now()
+	return s.update(func(st *configMapState) (bool, error) {
+		if _, ok := st.issuances[key]; ok {
+			return false, certmanager.ErrDuplicateSerial
+		}
+		for k, i := range st.issuances {
+			if !i.NotAfter.After(now) {
+				delete(st.issuances, k)
+			}
+		}
+		st.issuances[key] = issuance{ID: r.ID, RequestedBy: r.RequestedBy, NotBefore: r.NotBefore, NotAfter: r.NotAfter}
+		return true, nil
+	})
+}
+
+// Issuances implements certmanager.StateStore.
+func (s *ConfigMapStore) Issuances(now time.Time) ([]certmanager.IssuanceRecord, error) {
+	st, _, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	records := []certmanager.IssuanceRecord{}
+	for k, i := range st.issuances {
+		if !i.NotAfter.After(now) {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(k, 10)
+		if !ok {
+			glog.Warningf("Ignoring the issuance of the malformed serial number %q in ConfigMap %s/%s",
+				k, s.namespace, s.name)
+			continue
+		}
+		records = append(records, certmanager.IssuanceRecord{
+			SerialNumber: serial,
+			ID:           i.ID,
+			RequestedBy:  i.RequestedBy,
+			NotBefore:    i.NotBefore,
+			NotAfter:     i.NotAfter,
+		})
+	}
+	return records, nil
+}
+
+// AddRevocation implements certmanager.StateStore.
+func (s *ConfigMapStore) AddRevocation(serial *big.Int, t time.Time) error {
+	key := serial.String()
+	return s.update(func(st *configMapState) (bool, error) {
+		if _, ok := st.revocations[key]; ok {
+			return false, nil
+		}
+		st.revocations[key] = t
+		return true, nil
+	})
+}
+
+// Revocations implements certmanager.StateStore.
+func (s *ConfigMapStore) Revocations() (map[string]time.Time, error) {
+	st, _, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	return st.revocations, nil
+}
+
+// get returns the state in the ConfigMap, and the ConfigMap, or nil if it does not exist.
+func (s *ConfigMapStore) get() (*configMapState, *v1.ConfigMap, error) {
+	cm, err := s.configMaps.ConfigMaps(s.namespace).Get(s.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		st, _ := decodeState(nil)
+		return st, nil, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("failed to get ConfigMap %s/%s (error: %v)", s.namespace, s.name, err)
+	}
+	st, err := decodeState(cm.Data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("malformed ConfigMap %s/%s (error: %v)", s.namespace, s.name, err)
+	}
+	return st, cm, nil
+}
+
+// update applies mutate to the state in the ConfigMap and writes it back if
+// mutate returns true, retrying with the latest state on conflicts.
+func (s *ConfigMapStore) update(mutate func(st *configMapState) (bool, error)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cms := s.configMaps.ConfigMaps(s.namespace)
+	delay := conflictRetryDelay
+	for attempt := 1; ; attempt++ {
+		st, cm, err := s.get()
+		if err != nil {
+			return err
+		}
+		if changed, err := mutate(st); err != nil || !changed {
+			return err
+		}
+		data, err := encodeState(st)
+		if err != nil {
+			return err
+		}
+
+		if cm == nil {
+			_, err = cms.Create(&v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: s.name, Namespace: s.namespace},
+				Data:       data,
+			})
+		} else {
+			updated := *cm
+			updated.Data = data
+			_, err = cms.Update(&updated)
+		}
+		if err == nil {
+			return nil
+		}
+		if !errors.IsConflict(err) && !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to write ConfigMap %s/%s (error: %v)", s.namespace, s.name, err)
+		}
+		if attempt == maxUpdateAttempts {
+			return fmt.Errorf("ConfigMap %s/%s is still conflicting after %d attempts (error: %v)",
+				s.namespace, s.name, attempt, err)
+		}
+
+		glog.Infof("ConfigMap %s/%s has been modified concurrently, retrying (attempt %d)", s.namespace, s.name, attempt)
+		// #nosec: the jitter does not need a cryptographically secure source.
+		time.Sleep(delay + time.Duration(rand.Int63n(int64(delay))))
+		delay *= 2
+	}
+}
+
+func decodeState(data map[string]string) (*configMapState, error) {
+	st := &configMapState{issuances: map[string]issuance{}, revocations: map[string]time.Time{}}
+	if v, ok := data[issuancesKey]; ok {
+		if err := json.Unmarshal([]byte(v), &st.issuances); err != nil {
+			return nil, fmt.Errorf("invalid %q (error: %v)", issuancesKey, err)
+		}
+	}
+	if v, ok := data[revocationsKey]; ok {
+		if err := json.Unmarshal([]byte(v), &st.revocations); err != nil {
+			return nil, fmt.Errorf("invalid %q (error: %v)", revocationsKey, err)
+		}
+	}
+	if v, ok := data[lastIssuanceKey]; ok {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %q (error: %v)", lastIssuanceKey, err)
+		}
+		st.lastIssuance = t
+	}
+	return st, nil
+}
+
+func encodeState(st *configMapState) (map[string]string, error) {
+	issuances, err := json.Marshal(st.issuances)
+	if err != nil {
+		return nil, err
+	}
+	revocations, err := json.Marshal(st.revocations)
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]string{issuancesKey: string(issuances), revocationsKey: string(revocations)}
+	if !st.lastIssuance.IsZero() {
+		data[lastIssuanceKey] = st.lastIssuance.UTC().Format(time.RFC3339Nano)
+	}
+	return data, nil
+}
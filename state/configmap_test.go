@@ -0,0 +1,206 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestConfigMapStore(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	s := NewConfigMapStore(client.CoreV1(), "istio-system", "istio-ca-state")
+	testStateStore(t, s)
+
+	cm, err := client.CoreV1().ConfigMaps("istio-system").Get("istio-ca-state", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get the ConfigMap: %v", err)
+	}
+	for _, key := range []string{issuancesKey, revocationsKey, lastIssuanceKey} {
+		if _, ok := cm.Data[key]; !ok {
+			t.Errorf("Missing data key %q in the ConfigMap", key)
+		}
+	}
+
+	// Another replica sees the same state.
+	other := NewConfigMapStore(client.CoreV1(), "istio-system", "istio-ca-state")
+	if records, err := other.Issuances(time.Now()); err != nil || len(records) != 2 {
+		t.Errorf("Unexpected issuances %v seen by another replica (error: %v)", records, err)
+	}
+}
+
+func TestConfigMapStoreConflict(t *testing.T) {
+	gr := schema.GroupResource{Resource: "configmaps"}
+
+	testCases := map[string]struct {
+		conflicts   int
+		expectError bool
+	}{
+		"Update retries on conflict": {
+			conflicts: 2,
+		},
+		"Update gives up on persistent conflicts": {
+			conflicts:   maxUpdateAttempts,
+			expectError: true,
+		},
+	}
+
+	for id, tc := range testCases {
+		client := fake.NewSimpleClientset()
+		s := NewConfigMapStore(client.CoreV1(), "istio-system", "istio-ca-state")
+		now := time.Now()
+		if err := s.Store(now); err != nil {
+			t.Fatalf("%s: failed to store the issuance time: %v", id, err)
+		}
+
+		conflicts := tc.conflicts
+		client.PrependReactor("update", "configmaps", func(ktesting.Action) (bool, runtime.Object, error) {
+			if conflicts == 0 {
+				return false, nil, nil
+			}
+			conflicts--
+			return true, nil, errors.NewConflict(gr, "istio-ca-state", nil)
+		})
+
+		err := s.AddRevocation(big.NewInt(1), now)
+		if (err != nil) != tc.expectError {
+			t.Errorf("%s: unexpected error %v", id, err)
+		}
+		revocations, err := s.Revocations()
+		if err != nil {
+			t.Errorf("%s: failed to get the revocations: %v", id, err)
+		}
+		if _, ok := revocations["1"]; ok == tc.expectError {
+			t.Errorf("%s: unexpected revocations %v", id, revocations)
+		}
+	}
+}
+
+func TestConfigMapStoreMalformed(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	s := NewConfigMapStore(client.CoreV1(), "istio-system", "istio-ca-state")
+	if err := s.Store(time.Now()); err != nil {
+		t.Fatalf("Failed to store the issuance time: %v", err)
+	}
+	cm, err := client.CoreV1().ConfigMaps("istio-system").Get("istio-ca-state", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get the ConfigMap: %v", err)
+	}
+	cm.Data[issuancesKey] = "not json"
+	if _, err := client.CoreV1().ConfigMaps("istio-system").Update(cm); err != nil {
+		t.Fatalf("Failed to update the ConfigMap: %v", err)
+	}
+
+	if _, err := s.Issuances(time.Now()); err == nil {
+		t.Errorf("Expecting an error reading a malformed ConfigMap")
+	}
+	if err := s.AddRevocation(big.NewInt(1), time.Now()); err == nil {
+		t.Errorf("Expecting an error updating a malformed ConfigMap")
+	}
+}
+
+// testStateStore exercises an empty certmanager.StateStore.
+func testStateStore(t *testing.T, s certmanager.StateStore) {
+	now := time.Now().Round(0)
+
+	if last, err := s.Load(); err != nil || !last.IsZero() {
+		t.Errorf("Unexpected issuance time %v of an empty store (error: %v)", last, err)
+	}
+	if err := s.Store(now); err != nil {
+		t.Errorf("Failed to store the issuance time: %v", err)
+	}
+	// An earlier time, e.g. of another replica, does not override a later one.
+	if err := s.Store(now.Add(-time.Minute)); err != nil {
+		t.Errorf("Failed to store the issuance time: %v", err)
+	}
+	if last, err := s.Load(); err != nil || !last.Equal(now) {
+		t.Errorf("Expecting the issuance time %v but got %v (error: %v)", now, last, err)
+	}
+
+	records := []certmanager.IssuanceRecord{
+		{
+			SerialNumber: big.NewInt(1),
+			ID:           "spiffe://cluster.local/ns/foo/sa/bar",
+			NotBefore:    now.Add(-time.Minute),
+			NotAfter:     now.Add(time.Hour),
+		},
+		{
+			SerialNumber: big.NewInt(2),
+			ID:           "spiffe://cluster.local/ns/foo/sa/baz",
+			RequestedBy:  "spiffe://cluster.local/ns/foo/sa/delegate",
+			NotBefore:    now,
+			NotAfter:     now.Add(time.Hour),
+		},
+		{
+			SerialNumber: big.NewInt(3),
+			ID:           "expired",
+			NotBefore:    now.Add(-2 * time.Hour),
+			NotAfter:     now.Add(-time.Hour),
+		},
+	}
+	for _, r := range records {
+		if err := s.AddIssuance(r); err != nil {
+			t.Errorf("Failed to add the issuance of %v: %v", r.SerialNumber, err)
+		}
+	}
+	if err := s.AddIssuance(records[0]); err != certmanager.ErrDuplicateSerial {
+		t.Errorf("Expecting ErrDuplicateSerial but got %v", err)
+	}
+
+	issued, err := s.Issuances(now)
+	if err != nil {
+		t.Errorf("Failed to get the issuances: %v", err)
+	}
+	bySerial := map[string]certmanager.IssuanceRecord{}
+	for _, r := range issued {
+		bySerial[r.SerialNumber.String()] = r
+	}
+	expected := map[string]certmanager.IssuanceRecord{"1": records[0], "2": records[1]}
+	if len(bySerial) != len(expected) {
+		t.Errorf("Expecting the issuances %v but got %v", expected, bySerial)
+	}
+	for k, e := range expected {
+		r, ok := bySerial[k]
+		if !ok || r.ID != e.ID || r.RequestedBy != e.RequestedBy || !r.NotBefore.Equal(e.NotBefore) ||
+			!r.NotAfter.Equal(e.NotAfter) {
+			t.Errorf("Expecting the issuance %v but got %v", e, r)
+		}
+	}
+
+	if err := s.AddRevocation(big.NewInt(1), now); err != nil {
+		t.Errorf("Failed to add the revocation: %v", err)
+	}
+	// Revoking a revoked certificate keeps the first revocation.
+	if err := s.AddRevocation(big.NewInt(1), now.Add(time.Minute)); err != nil {
+		t.Errorf("Failed to add the revocation: %v", err)
+	}
+	revocations, err := s.Revocations()
+	if err != nil {
+		t.Errorf("Failed to get the revocations: %v", err)
+	}
+	if len(revocations) != 1 || !revocations["1"].Equal(now) {
+		t.Errorf("Unexpected revocations %v", revocations)
+	}
+}
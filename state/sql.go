@@ -0,0 +1,248 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/auth/certmanager"
+)
+
+// The records of expired certificates are deleted at most once per pruneInterval.
+const pruneInterval = time.Minute
+
+// SQLDialects lists the SQL drivers whose dialect SQLStore supports.
+var SQLDialects = []string{"mysql", "postgres", "sqlite3"}
+
+// The times are stored as nanoseconds since the Unix epoch, which all the dialects compare as integers.
+var sqlSchema = []string{
+	"CREATE TABLE IF NOT EXISTS istio_ca_issuances (serial VARCHAR(64) PRIMARY KEY, identity TEXT NOT NULL, " +
+		"requested_by TEXT NOT NULL, not_before BIGINT NOT NULL, not_after BIGINT NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS istio_ca_revocations (serial VARCHAR(64) PRIMARY KEY, revoked_at BIGINT NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS istio_ca_issuance_time (id INTEGER PRIMARY KEY, last_issuance BIGINT NOT NULL)",
+}
+
+const (
+	insertIssuanceQuery = "INSERT INTO istio_ca_issuances (serial, identity, requested_by, not_before, not_after) " +
+		"VALUES (?, ?, ?, ?, ?)"
+	countIssuanceQuery   = "SELECT COUNT(*) FROM istio_ca_issuances WHERE serial = ?"
+	selectIssuancesQuery = "SELECT serial, identity, requested_by, not_before, not_after FROM istio_ca_issuances " +
+		"WHERE not_after > ?"
+	pruneIssuancesQuery = "DELETE FROM istio_ca_issuances WHERE not_after <= ?"
+
+	insertRevocationQuery  = "INSERT INTO istio_ca_revocations (serial, revoked_at) VALUES (?, ?)"
+	countRevocationQuery   = "SELECT COUNT(*) FROM istio_ca_revocations WHERE serial = ?"
+	selectRevocationsQuery = "SELECT serial, revoked_at FROM istio_ca_revocations"
+
+	selectIssuanceTimeQuery = "SELECT last_issuance FROM istio_ca_issuance_time WHERE id = 1"
+	updateIssuanceTimeQuery = "UPDATE istio_ca_issuance_time SET last_issuance = ? WHERE id = 1 AND last_issuance < ?"
+	insertIssuanceTimeQuery = "INSERT INTO istio_ca_issuance_time (id, last_issuance) VALUES (1, ?)"
+)
+
+// SQLStore is a certmanager.StateStore backed by an external SQL database,
+// which the replicas of Istio CA share. The primary keys of the tables
+// guarantee that a serial number is recorded only once across the replicas.
+type SQLStore struct {
+	db *sql.DB
+
+	// Whether the placeholders are numbered, i.e. $1, $2, instead of ?.
+	numbered bool
+
+	mutex      sync.Mutex
+	lastPruned time.Time
+}
+
+// NewSQLStore returns a pointer to a SQLStore persisting the state in the
+// database, whose SQL driver is one of SQLDialects. The tables are created if
+// they do not exist.
+func NewSQLStore(db *sql.DB, dialect string) (*SQLStore, error) {
+	s := &SQLStore{db: db}
+	switch dialect {
+	case "postgres":
+		s.numbered = true
+	case "mysql", "sqlite3":
+	default:
+		return nil, fmt.Errorf("unsupported SQL dialect %q (supported: %s)", dialect, strings.Join(SQLDialects, ", "))
+	}
+
+	for _, stmt := range sqlSchema {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, fmt.Errorf("failed to create the tables (error: %v)", err)
+		}
+	}
+	return s, nil
+}
+
+// Load implements certmanager.IssuanceTimeStore.
+func (s *SQLStore) Load() (time.Time, error) {
+	var last int64
+	err := s.db.QueryRow(s.rebind(selectIssuanceTimeQuery)).Scan(&last)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, last), nil
+}
+
+// Store implements certmanager.IssuanceTimeStore. The conditional update
+// keeps the latest time if the replicas store concurrently.
+func (s *SQLStore) Store(t time.Time) error {
+	res, err := s.db.Exec(s.rebind(updateIssuanceTimeQuery), t.UnixNano(), t.UnixNano())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	// Either the row does not exist yet, or it holds a later time.
+	if _, err := s.db.Exec(s.rebind(insertIssuanceTimeQuery), t.UnixNano()); err != nil {
+		if last, loadErr := s.Load(); loadErr == nil && !last.IsZero() {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// AddIssuance implements certmanager.StateStore.
+func (s *SQLStore) AddIssuance(r certmanager.IssuanceRecord) error {
+	s.pruneExpired(time.Now())
+
+	serial := r.SerialNumber.String()
+	_, err := s.db.Exec(s.rebind(insertIssuanceQuery), serial, r.ID, r.RequestedBy,
+		r.NotBefore.UnixNano(), r.NotAfter.UnixNano())
+	if err == nil {
+		return nil
+	}
+	// The error of a primary key violation differs across the drivers.
+	if exists, existsErr := s.exists(countIssuanceQuery, serial); existsErr == nil && exists {
+		return certmanager.ErrDuplicateSerial
+	}
+	return err
+}
+
+// Issuances implements certmanager.StateStore.
+func (s *SQLStore) Issuances(now time.Time) ([]certmanager.IssuanceRecord, error) {
+	rows, err := s.db.Query(s.rebind(selectIssuancesQuery), now.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	records := []certmanager.IssuanceRecord{}
+	for rows.Next() {
+		var key, id, requestedBy string
+		var notBefore, notAfter int64
+		if err := rows.Scan(&key, &id, &requestedBy, &notBefore, &notAfter); err != nil {
+			return nil, err
+		}
+		serial, ok := new(big.Int).SetString(key, 10)
+		if !ok {
+			glog.Warningf("Ignoring the issuance of the malformed serial number %q in the state database", key)
+			continue
+		}
+		records = append(records, certmanager.IssuanceRecord{
+			SerialNumber: serial,
+			ID:           id,
+			RequestedBy:  requestedBy,
+			NotBefore:    time.Unix(0, notBefore),
+			NotAfter:     time.Unix(0, notAfter),
+		})
+	}
+	return records, rows.Err()
+}
+
+// AddRevocation implements certmanager.StateStore.
+func (s *SQLStore) AddRevocation(serial *big.Int, t time.Time) error {
+	key := serial.String()
+	if _, err := s.db.Exec(s.rebind(insertRevocationQuery), key, t.UnixNano()); err != nil {
+		if exists, existsErr := s.exists(countRevocationQuery, key); existsErr == nil && exists {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Revocations implements certmanager.StateStore.
+func (s *SQLStore) Revocations() (map[string]time.Time, error) {
+	rows, err := s.db.Query(s.rebind(selectRevocationsQuery))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	revocations := map[string]time.Time{}
+	for rows.Next() {
+		var key string
+		var revokedAt int64
+		if err := rows.Scan(&key, &revokedAt); err != nil {
+			return nil, err
+		}
+		revocations[key] = time.Unix(0, revokedAt)
+	}
+	return revocations, rows.Err()
+}
+
+func (s *SQLStore) exists(query, serial string) (bool, error) {
+	var n int64
+	if err := s.db.QueryRow(s.rebind(query), serial).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (s *SQLStore) pruneExpired(now time.Time) {
+	s.mutex.Lock()
+	if now.Sub(s.lastPruned) < pruneInterval {
+		s.mutex.Unlock()
+		return
+	}
+	s.lastPruned = now
+	s.mutex.Unlock()
+
+	if _, err := s.db.Exec(s.rebind(pruneIssuancesQuery), now.UnixNano()); err != nil {
+		glog.Warningf("Failed to delete the records of the expired certificates (error: %v)", err)
+	}
+}
+
+// rebind numbers the ? placeholders of the query if the dialect requires it.
+func (s *SQLStore) rebind(query string) string {
+	if !s.numbered {
+		return query
+	}
+	var b bytes.Buffer
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
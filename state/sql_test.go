@@ -0,0 +1,283 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager"
+)
+
+// fakeDriver serves the statements of SQLStore from memory. Each DSN names a
+// separate database.
+type fakeDriver struct{}
+
+var (
+	fakeDatabasesMutex sync.Mutex
+	fakeDatabases      = map[string]*fakeDatabase{}
+	// Numbers the DSNs of the tests, so that repeated runs get new databases.
+	fakeDatabaseCount int
+
+	numberedPlaceholder = regexp.MustCompile(`\$[0-9]+`)
+)
+
+func init() {
+	sql.Register("fakesql", fakeDriver{})
+}
+
+type fakeDatabase struct {
+	mutex sync.Mutex
+
+	issuances    map[string][]driver.Value
+	revocations  map[string]int64
+	lastIssuance []int64
+}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	fakeDatabasesMutex.Lock()
+	defer fakeDatabasesMutex.Unlock()
+	db, ok := fakeDatabases[dsn]
+	if !ok {
+		db = &fakeDatabase{issuances: map[string][]driver.Value{}, revocations: map[string]int64{}}
+		fakeDatabases[dsn] = db
+	}
+	return &fakeConn{db: db}, nil
+}
+
+type fakeConn struct {
+	db *fakeDatabase
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: numberedPlaceholder.ReplaceAllString(query, "?")}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type fakeStmt struct {
+	db    *fakeDatabase
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db := s.db
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	switch s.query {
+	case sqlSchema[0], sqlSchema[1], sqlSchema[2]:
+		return driver.RowsAffected(0), nil
+	case insertIssuanceQuery:
+		serial := args[0].(string)
+		if _, ok := db.issuances[serial]; ok {
+			return nil, errors.New("duplicate key")
+		}
+		db.issuances[serial] = args
+		return driver.RowsAffected(1), nil
+	case pruneIssuancesQuery:
+		n := 0
+		for serial, row := range db.issuances {
+			if row[4].(int64) <= args[0].(int64) {
+				delete(db.issuances, serial)
+				n++
+			}
+		}
+		return driver.RowsAffected(n), nil
+	case insertRevocationQuery:
+		serial := args[0].(string)
+		if _, ok := db.revocations[serial]; ok {
+			return nil, errors.New("duplicate key")
+		}
+		db.revocations[serial] = args[1].(int64)
+		return driver.RowsAffected(1), nil
+	case updateIssuanceTimeQuery:
+		if len(db.lastIssuance) == 0 || db.lastIssuance[0] >= args[1].(int64) {
+			return driver.RowsAffected(0), nil
+		}
+		db.lastIssuance[0] = args[0].(int64)
+		return driver.RowsAffected(1), nil
+	case insertIssuanceTimeQuery:
+		if len(db.lastIssuance) > 0 {
+			return nil, errors.New("duplicate key")
+		}
+		db.lastIssuance = []int64{args[0].(int64)}
+		return driver.RowsAffected(1), nil
+	}
+	return nil, fmt.Errorf("unexpected statement %q", s.query)
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	db := s.db
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	rows := &fakeRows{}
+	switch s.query {
+	case countIssuanceQuery:
+		_, ok := db.issuances[args[0].(string)]
+		rows.values = [][]driver.Value{{boolToCount(ok)}}
+	case selectIssuancesQuery:
+		for _, row := range db.issuances {
+			if row[4].(int64) > args[0].(int64) {
+				rows.values = append(rows.values, row)
+			}
+		}
+	case countRevocationQuery:
+		_, ok := db.revocations[args[0].(string)]
+		rows.values = [][]driver.Value{{boolToCount(ok)}}
+	case selectRevocationsQuery:
+		for serial, t := range db.revocations {
+			rows.values = append(rows.values, []driver.Value{serial, t})
+		}
+	case selectIssuanceTimeQuery:
+		if len(db.lastIssuance) > 0 {
+			rows.values = [][]driver.Value{{db.lastIssuance[0]}}
+		}
+	default:
+		return nil, fmt.Errorf("unexpected query %q", s.query)
+	}
+	if len(rows.values) > 0 {
+		for i := range rows.values[0] {
+			rows.columns = append(rows.columns, fmt.Sprintf("c%d", i))
+		}
+	}
+	return rows, nil
+}
+
+func boolToCount(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestSQLStore(t *testing.T) {
+	testCases := map[string]struct {
+		dialect     string
+		expectError bool
+	}{
+		"MySQL": {
+			dialect: "mysql",
+		},
+		"PostgreSQL": {
+			dialect: "postgres",
+		},
+		"SQLite": {
+			dialect: "sqlite3",
+		},
+		"Unsupported dialect": {
+			dialect:     "oracle",
+			expectError: true,
+		},
+	}
+
+	for id, tc := range testCases {
+		fakeDatabasesMutex.Lock()
+		fakeDatabaseCount++
+		dsn := fmt.Sprintf("%s/%s/%d", t.Name(), id, fakeDatabaseCount)
+		fakeDatabasesMutex.Unlock()
+		db, err := sql.Open("fakesql", dsn)
+		if err != nil {
+			t.Fatalf("%s: failed to open the database: %v", id, err)
+		}
+		s, err := NewSQLStore(db, tc.dialect)
+		if tc.expectError {
+			if err == nil {
+				t.Errorf("%s: expecting an error but got none", id)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%s: failed to create the store: %v", id, err)
+			continue
+		}
+
+		testStateStore(t, s)
+
+		// The expired record is deleted on the next issuance after pruneInterval.
+		s.lastPruned = s.lastPruned.Add(-pruneInterval)
+		if err := s.AddIssuance(certmanager.IssuanceRecord{
+			SerialNumber: big.NewInt(4),
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+		}); err != nil {
+			t.Errorf("%s: failed to add the issuance: %v", id, err)
+		}
+		fakeDatabasesMutex.Lock()
+		fdb := fakeDatabases[dsn]
+		fakeDatabasesMutex.Unlock()
+		fdb.mutex.Lock()
+		if _, ok := fdb.issuances["3"]; ok {
+			t.Errorf("%s: the record of the expired certificate has not been deleted", id)
+		}
+		fdb.mutex.Unlock()
+	}
+}
+
+func TestRebind(t *testing.T) {
+	s := &SQLStore{numbered: true}
+	if q := s.rebind(updateIssuanceTimeQuery); q !=
+		"UPDATE istio_ca_issuance_time SET last_issuance = $1 WHERE id = 1 AND last_issuance < $2" {
+		t.Errorf("Unexpected rebound query %q", q)
+	}
+	s.numbered = false
+	if q := s.rebind(updateIssuanceTimeQuery); q != updateIssuanceTimeQuery {
+		t.Errorf("Unexpected rebound query %q", q)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sts serves a token exchange endpoint for Istio CA, modeled on the
+// OAuth 2.0 Token Exchange of RFC 8693, so that CI systems and other external
+// jobs can call mesh services. A job exchanges an ID token of its OpenID
+// Connect provider, along with a certificate signing request, for a
+// short-lived certificate of the Istio identity the token maps to.
+package sts
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/auth/authn"
+	"istio.io/auth/parse"
+)
+
+const (
+	// Path is the path of the token exchange endpoint.
+	Path = "/v1/token"
+
+	// The grant type and the subject token types of RFC 8693, section 3.
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+	TokenTypeIDToken       = "urn:ietf:params:oauth:token-type:id_token"
+
+	// TokenTypeCertChain is the type of the issued token, a PEM-encoded
+	// certificate chain starting with the issued certificate.
+	TokenTypeCertChain = "urn:istio:params:oauth:token-type:x509-cert-chain"
+
+	// The form parameters of the request. CSRParam, holding the PEM-encoded
+	// certificate signing request, is an extension of RFC 8693.
+	GrantTypeParam        = "grant_type"
+	SubjectTokenParam     = "subject_token"
+	SubjectTokenTypeParam = "subject_token_type"
+	CSRParam              = "csr"
+
+	// The maximum size of a request, which carries a token and a certificate
+	// signing request, both of which may be percent-encoded to thrice their size.
+	maxRequestSize = 3*(parse.MaxCSRSize+parse.MaxJWTSize) + 4*1024
+)
+
+// Signer signs short-lived certificates.
+type Signer interface {
+	SignShortLived(csrPEM []byte, ttl time.Duration) (chain []byte, err error)
+	GetRootCertificate() []byte
+}
+
+// Response is the successful response of the token exchange endpoint (RFC
+// 8693, section 2.2.1).
+type Response struct {
+	// The PEM-encoded certificate chain, starting with the issued certificate.
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	// Always "N_A", as the certificate is not an access token.
+	TokenType string `json:"token_type"`
+	// The number of seconds until the certificate expires.
+	ExpiresIn int64 `json:"expires_in"`
+
+	// Extensions of RFC 8693: the Istio identity the certificate is issued
+	// for, and the PEM-encoded root certificates of the mesh.
+	Identity string `json:"identity"`
+	RootCert string `json:"root_cert"`
+}
+
+// ErrorResponse is the error response of the token exchange endpoint (RFC
+// 6749, section 5.2).
+type ErrorResponse struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// Server exchanges the ID tokens verified by an authenticator for
+// short-lived certificates of the identities they map to.
+type Server struct {
+	ca            Signer
+	authenticator authn.Authenticator
+	ttl           time.Duration
+
+	// Returns the TLS certificate of the server.
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// NewServer returns a pointer to a Server exchanging the tokens verified by
+// the authenticator for certificates signed by the CA, which expire after
+// the TTL. The server is served over TLS with the certificates returned by
+// getCertificate, e.g. those of the CSR API.
+func NewServer(ca Signer, authenticator authn.Authenticator, ttl time.Duration,
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *Server {
+	return &Server{
+		ca:             ca,
+		authenticator:  authenticator,
+		ttl:            ttl,
+		getCertificate: getCertificate,
+	}
+}
+
+// Run serves the token exchange endpoint over TLS on the address until an error occurs.
+func (s *Server) Run(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	glog.Infof("Serving the token exchange endpoint on %s", addr)
+	return s.Serve(lis)
+}
+
+// Serve serves the token exchange endpoint over TLS on the listener until an error occurs.
+func (s *Server) Serve(lis net.Listener) error {
+	server := &http.Server{
+		Handler:   s.Handler(),
+		TLSConfig: &tls.Config{GetCertificate: s.getCertificate},
+	}
+	return server.Serve(tls.NewListener(lis, server.TLSConfig))
+}
+
+// Handler returns the handler of the token exchange endpoint.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(Path, s.exchange)
+	return mux
+}
+
+// exchange answers a token exchange request (RFC 8693, section 2.1).
+func (s *Server) exchange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "invalid form: %v", err)
+		return
+	}
+	if gt := r.PostForm.Get(GrantTypeParam); gt != GrantTypeTokenExchange {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", "unsupported grant type %q", gt)
+		return
+	}
+	if tt := r.PostForm.Get(SubjectTokenTypeParam); tt != TokenTypeJWT && tt != TokenTypeIDToken {
+		writeError(w, http.StatusBadRequest, "invalid_request", "unsupported subject token type %q", tt)
+		return
+	}
+
+	id, err := s.authenticator.Authenticate(r.PostForm.Get(SubjectTokenParam))
+	if err != nil {
+		glog.Warningf("Refused a token exchange (error: %v)", err)
+		writeError(w, http.StatusBadRequest, "invalid_grant", "invalid subject token: %v", err)
+		return
+	}
+
+	csrPEM := []byte(r.PostForm.Get(CSRParam))
+	// parse.CSR verifies the signature, i.e. that the caller holds the private key.
+	csr, err := parse.CSR(csrPEM)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "invalid certificate signing request: %v", err)
+		return
+	}
+	if len(csr.URIs) != 1 || csr.URIs[0].String() != id ||
+		len(csr.DNSNames)+len(csr.EmailAddresses)+len(csr.IPAddresses) != 0 {
+		glog.Warningf("Refused a token exchange for %s, whose certificate signing request asks for other names", id)
+		writeError(w, http.StatusBadRequest, "invalid_target",
+			"the certificate signing request must ask for the identity %s only", id)
+		return
+	}
+
+	chain, err := s.ca.SignShortLived(csrPEM, s.ttl)
+	if err != nil {
+		glog.Errorf("Failed to sign the certificate signing request of a token exchange for %s (error: %v)", id, err)
+		writeError(w, http.StatusInternalServerError, "server_error", "failed to sign the certificate signing request")
+		return
+	}
+	certs, err := parse.CertificateChain(chain)
+	if err != nil {
+		glog.Errorf("Failed to parse the certificate issued for %s (error: %v)", id, err)
+		writeError(w, http.StatusInternalServerError, "server_error", "failed to sign the certificate signing request")
+		return
+	}
+
+	notAfter := certs[0].NotAfter
+	glog.Infof("Exchanged a token for a certificate of %s expiring at %v", id, notAfter)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, Response{
+		AccessToken:     string(chain),
+		IssuedTokenType: TokenTypeCertChain,
+		TokenType:       "N_A",
+		ExpiresIn:       int64(time.Until(notAfter).Seconds()),
+		Identity:        id,
+		RootCert:        string(s.ca.GetRootCertificate()),
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, code, format string, args ...interface{}) {
+	writeJSON(w, status, ErrorResponse{Error: code, Description: fmt.Sprintf(format, args...)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		glog.Errorf("Failed to write the token exchange response (error: %v)", err)
+	}
+}
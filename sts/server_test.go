@@ -0,0 +1,176 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sts
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/auth/certmanager/testutil"
+	"istio.io/auth/parse"
+)
+
+const testID = "spiffe://cluster.local/ns/ci/sa/deploy"
+
+// createCSR returns the PEM-encoded certificate signing request for the identity.
+func createCSR(t *testing.T, id string) string {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{URIs: []*url.URL{u}}, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+func TestExchange(t *testing.T) {
+	ca := testutil.NewCA(t, 30*time.Minute)
+	s := NewServer(ca, testutil.FakeAuthenticator{"token": testID}, 10*time.Minute, nil)
+
+	testCases := map[string]struct {
+		method         string
+		form           url.Values
+		expectedStatus int
+		expectedError  string
+	}{
+		"Exchanged": {
+			form: url.Values{
+				GrantTypeParam:        {GrantTypeTokenExchange},
+				SubjectTokenParam:     {"token"},
+				SubjectTokenTypeParam: {TokenTypeIDToken},
+				CSRParam:              {createCSR(t, testID)},
+			},
+			expectedStatus: http.StatusOK,
+		},
+		"Wrong method": {
+			method:         http.MethodGet,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		"Unsupported grant type": {
+			form: url.Values{
+				GrantTypeParam:        {"client_credentials"},
+				SubjectTokenParam:     {"token"},
+				SubjectTokenTypeParam: {TokenTypeJWT},
+				CSRParam:              {createCSR(t, testID)},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "unsupported_grant_type",
+		},
+		"Unsupported token type": {
+			form: url.Values{
+				GrantTypeParam:        {GrantTypeTokenExchange},
+				SubjectTokenParam:     {"token"},
+				SubjectTokenTypeParam: {"urn:ietf:params:oauth:token-type:saml2"},
+				CSRParam:              {createCSR(t, testID)},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "invalid_request",
+		},
+		"Invalid token": {
+			form: url.Values{
+				GrantTypeParam:        {GrantTypeTokenExchange},
+				SubjectTokenParam:     {"other"},
+				SubjectTokenTypeParam: {TokenTypeJWT},
+				CSRParam:              {createCSR(t, testID)},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "invalid_grant",
+		},
+		"Invalid CSR": {
+			form: url.Values{
+				GrantTypeParam:        {GrantTypeTokenExchange},
+				SubjectTokenParam:     {"token"},
+				SubjectTokenTypeParam: {TokenTypeJWT},
+				CSRParam:              {"invalid"},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "invalid_request",
+		},
+		"Other identity": {
+			form: url.Values{
+				GrantTypeParam:        {GrantTypeTokenExchange},
+				SubjectTokenParam:     {"token"},
+				SubjectTokenTypeParam: {TokenTypeJWT},
+				CSRParam:              {createCSR(t, "spiffe://cluster.local/ns/istio-system/sa/admin")},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "invalid_target",
+		},
+	}
+
+	for k, tc := range testCases {
+		method := tc.method
+		if method == "" {
+			method = http.MethodPost
+		}
+		req := httptest.NewRequest(method, Path, strings.NewReader(tc.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+
+		if w.Code != tc.expectedStatus {
+			t.Errorf("%s: unexpected status code (expecting %d, actual %d): %s", k, tc.expectedStatus, w.Code,
+				w.Body.String())
+			continue
+		}
+		if tc.expectedError != "" {
+			er := ErrorResponse{}
+			if err := json.NewDecoder(w.Body).Decode(&er); err != nil || er.Error != tc.expectedError {
+				t.Errorf("%s: expecting error %q, but got %+v (error: %v)", k, tc.expectedError, er, err)
+			}
+			continue
+		}
+		if tc.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		resp := Response{}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Errorf("%s: failed to decode the response body: %v", k, err)
+			continue
+		}
+		if resp.Identity != testID || resp.IssuedTokenType != TokenTypeCertChain || resp.TokenType != "N_A" {
+			t.Errorf("%s: unexpected response %+v", k, resp)
+		}
+		if resp.ExpiresIn <= 0 || resp.ExpiresIn > 600 {
+			t.Errorf("%s: expecting the certificate to expire within 10m, but it expires in %ds", k, resp.ExpiresIn)
+		}
+		certs, err := parse.CertificateChain([]byte(resp.AccessToken))
+		if err != nil {
+			t.Errorf("%s: failed to parse the certificate chain: %v", k, err)
+		} else if len(certs[0].URIs) != 1 || certs[0].URIs[0].String() != testID {
+			t.Errorf("%s: unexpected URI SANs %v", k, certs[0].URIs)
+		}
+		if resp.RootCert != string(ca.GetRootCertificate()) {
+			t.Errorf("%s: unexpected root certificate", k)
+		}
+	}
+}
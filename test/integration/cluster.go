@@ -0,0 +1,199 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// The number of events a watch buffers before the API calls block on its consumer.
+const watchBufferSize = 1024
+
+// Cluster is an in-memory Kubernetes API server. Unlike the fake clientset it
+// is built on, whose watches never deliver any event, it sends the creations,
+// updates and deletions of the objects to the watches of their resource and
+// namespace, so that informers observe the changes as they would against a
+// real API server. Label and field selectors are ignored.
+type Cluster struct {
+	Client *fake.Clientset
+
+	objectReaction ktesting.ReactionFunc
+
+	mutex   sync.Mutex
+	watches []*clusterWatch
+}
+
+// NewCluster returns a pointer to a Cluster holding the objects.
+func NewCluster(objects ...runtime.Object) *Cluster {
+	tracker := ktesting.NewObjectTracker(api.Registry, api.Scheme, api.Codecs.UniversalDecoder())
+	for _, obj := range objects {
+		if err := tracker.Add(obj); err != nil {
+			panic(err)
+		}
+	}
+
+	c := &Cluster{
+		Client:         &fake.Clientset{},
+		objectReaction: ktesting.ObjectReaction(tracker, api.Registry.RESTMapper()),
+	}
+	c.Client.AddReactor("*", "*", c.react)
+	c.Client.AddWatchReactor("*", c.watch)
+	return c
+}
+
+// react serves the action from the stored objects and notifies the watches of
+// the change, if any. The fake clientset holds its lock while reacting, so the
+// events are sent in the order of the changes.
+func (c *Cluster) react(action ktesting.Action) (bool, runtime.Object, error) {
+	var deleted runtime.Object
+	if action.GetVerb() == "delete" {
+		get := ktesting.NewGetAction(action.GetResource(), action.GetNamespace(),
+			action.(ktesting.DeleteAction).GetName())
+		if _, obj, err := c.objectReaction(get); err == nil {
+			deleted = obj
+		}
+	}
+
+	handled, obj, err := c.objectReaction(action)
+	if err != nil {
+		return handled, obj, err
+	}
+	switch action.GetVerb() {
+	case "create":
+		c.notify(action, watch.Added, obj)
+	case "update":
+		c.notify(action, watch.Modified, obj)
+	case "delete":
+		if deleted != nil {
+			c.notify(action, watch.Deleted, deleted)
+		}
+	}
+	return handled, obj, err
+}
+
+func (c *Cluster) notify(action ktesting.Action, eventType watch.EventType, obj runtime.Object) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+
+	c.mutex.Lock()
+	watches := append([]*clusterWatch{}, c.watches...)
+	c.mutex.Unlock()
+
+	for _, w := range watches {
+		if w.resource == action.GetResource().Resource &&
+			(w.namespace == "" || w.namespace == accessor.GetNamespace()) {
+			w.send(watch.Event{Type: eventType, Object: obj})
+		}
+	}
+}
+
+func (c *Cluster) watch(action ktesting.Action) (bool, watch.Interface, error) {
+	w := &clusterWatch{
+		resource:  action.GetResource().Resource,
+		namespace: action.GetNamespace(),
+		result:    make(chan watch.Event, watchBufferSize),
+		done:      make(chan struct{}),
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	active := []*clusterWatch{}
+	for _, existing := range c.watches {
+		if !existing.stopped() {
+			active = append(active, existing)
+		}
+	}
+	c.watches = append(active, w)
+	return true, w, nil
+}
+
+// WaitForWatches waits until each of the resources, e.g. "secrets", is
+// watched. The changes made before an informer watches its resource may only
+// be observed on its next resync.
+func (c *Cluster) WaitForWatches(timeout time.Duration, resources ...string) error {
+	err := wait.PollImmediate(10*time.Millisecond, timeout, func() (bool, error) {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		watched := map[string]bool{}
+		for _, w := range c.watches {
+			if !w.stopped() {
+				watched[w.resource] = true
+			}
+		}
+		for _, r := range resources {
+			if !watched[r] {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("resources %v are not watched within %v", resources, timeout)
+	}
+	return nil
+}
+
+// clusterWatch is a watch of the objects of a resource in a namespace, or in
+// all the namespaces if namespace is empty.
+type clusterWatch struct {
+	resource  string
+	namespace string
+
+	result   chan watch.Event
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// Stop implements watch.Interface. The result channel is left open, since
+// events may still be sent concurrently; its consumer stops reading anyway.
+func (w *clusterWatch) Stop() {
+	w.stopOnce.Do(func() { close(w.done) })
+}
+
+// ResultChan implements watch.Interface.
+func (w *clusterWatch) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+// send blocks until the event is buffered or the watch is stopped.
+func (w *clusterWatch) send(e watch.Event) {
+	select {
+	case w.result <- e:
+	case <-w.done:
+	}
+}
+
+func (w *clusterWatch) stopped() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package integration runs the Istio CA controllers against an in-memory
+// Kubernetes API server, so that cross-cutting changes can be validated
+// end to end on scenarios such as service account churn, secret deletions and
+// root rotations. The scenarios only build with the "integration" tag:
+//
+//	go test -tags integration istio.io/auth/test/integration
+//
+// Some take minutes, as they wait for the resync of the informers.
+package integration
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"istio.io/auth/certmanager"
+	"istio.io/auth/controller"
+	"istio.io/auth/parse"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// The layout of the Istio secrets, which the controller package keeps unexported.
+const (
+	secretNamePrefix = "istio."
+	managedByLabel   = "istio.io/managed-by"
+	certChainKey     = "cert-chain.pem"
+	privateKeyKey    = "key.pem"
+	rootCertKey      = "root-cert.pem"
+)
+
+// The time the informers of the controller are given to start watching.
+const startTimeout = 10 * time.Second
+
+// Harness runs a SecretController of a self-signed Istio CA against a Cluster.
+type Harness struct {
+	CA         *certmanager.IstioCA
+	Cluster    *Cluster
+	Controller *controller.SecretController
+
+	stopCh chan struct{}
+}
+
+// Start returns a pointer to a Harness running a SecretController with the
+// options on a cluster holding the objects. It returns once the controller
+// watches the service accounts and the secrets; Stop must be called then.
+func Start(opts controller.SecretControllerOptions, objects ...runtime.Object) (*Harness, error) {
+	ca, err := certmanager.NewSelfSignedIstioCA(&certmanager.SelfSignedIstioCAOptions{
+		CACertTTL:  24 * time.Hour,
+		CertTTL:    time.Hour,
+		Subject:    pkix.Name{Organization: []string{"integration.istio.io"}},
+		MaxPathLen: -1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the self-signed CA (error: %v)", err)
+	}
+	cluster := NewCluster(objects...)
+
+	h := &Harness{
+		CA:         ca,
+		Cluster:    cluster,
+		Controller: controller.NewSecretController(ca, cluster.Client.CoreV1(), metav1.NamespaceAll, opts),
+		stopCh:     make(chan struct{}),
+	}
+	go h.Controller.Run(h.stopCh)
+	if err := cluster.WaitForWatches(startTimeout, "serviceaccounts", "secrets"); err != nil {
+		h.Stop()
+		return nil, err
+	}
+	return h, nil
+}
+
+// Stop stops the controller.
+func (h *Harness) Stop() {
+	close(h.stopCh)
+}
+
+// WaitForConvergence polls Converged until it returns nil, and returns its
+// last error if the timeout elapses first.
+func (h *Harness) WaitForConvergence(timeout time.Duration) error {
+	var lastErr error
+	err := wait.PollImmediate(100*time.Millisecond, timeout, func() (bool, error) {
+		lastErr = h.Converged()
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("not converged within %v: %v", timeout, lastErr)
+	}
+	return nil
+}
+
+// Converged returns nil if every service account of the cluster has an Istio
+// secret holding a certificate for its identity, with the matching private
+// key, which chains to the current root of the CA, along with that root, and
+// if no Istio secret outlives its service account. Otherwise, it returns an
+// error listing the problems.
+func (h *Harness) Converged() error {
+	core := h.Cluster.Client.CoreV1()
+	sas, err := core.ServiceAccounts(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	secrets, err := core.Secrets(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	managed := map[string]*v1.Secret{}
+	for i, s := range secrets.Items {
+		if s.Labels[managedByLabel] == "istio-ca" {
+			managed[s.Namespace+"/"+s.Name] = &secrets.Items[i]
+		}
+	}
+
+	roots := h.CA.GetRootCertificate()
+	problems := []string{}
+	for _, sa := range sas.Items {
+		key := sa.Namespace + "/" + secretNamePrefix + sa.Name
+		s, ok := managed[key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("service account %s/%s has no Istio secret", sa.Namespace, sa.Name))
+			continue
+		}
+		delete(managed, key)
+
+		id := fmt.Sprintf("spiffe://cluster.local/ns/%s/sa/%s", sa.Namespace, sa.Name)
+		if err := verifySecret(s, id, roots); err != nil {
+			problems = append(problems, fmt.Sprintf("secret %s: %v", key, err))
+		}
+	}
+	for key := range managed {
+		problems = append(problems, fmt.Sprintf("secret %s has no service account", key))
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return errors.New(strings.Join(problems, "; "))
+}
+
+func verifySecret(s *v1.Secret, id string, roots []byte) error {
+	if !bytes.Equal(s.Data[rootCertKey], roots) {
+		return errors.New("the root certificate is outdated")
+	}
+	certs, err := parse.CertificateChain(s.Data[certChainKey])
+	if err != nil || len(certs) == 0 {
+		return fmt.Errorf("the certificate chain is invalid (error: %v)", err)
+	}
+	if len(certs[0].URIs) != 1 || certs[0].URIs[0].String() != id {
+		return fmt.Errorf("the certificate is issued for %v instead of %s", certs[0].URIs, id)
+	}
+	if _, err := tls.X509KeyPair(s.Data[certChainKey], s.Data[privateKeyKey]); err != nil {
+		return fmt.Errorf("the certificate does not match the private key (error: %v)", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(roots)
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err = certs[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("the certificate chain does not verify against the root certificate (error: %v)", err)
+	}
+	return nil
+}
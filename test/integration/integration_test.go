@@ -0,0 +1,128 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"istio.io/auth/controller"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// The time the controller is given to react to watch events.
+	convergenceTimeout = 30 * time.Second
+
+	// The time the controller is given to converge on changes it only
+	// observes on the resync of its secret informer, every minute.
+	resyncConvergenceTimeout = 3 * time.Minute
+)
+
+func serviceAccount(namespace, name string) *v1.ServiceAccount {
+	return &v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+}
+
+func serviceAccounts(namespaces []string, n int) []runtime.Object {
+	objects := []runtime.Object{}
+	for _, ns := range namespaces {
+		for i := 0; i < n; i++ {
+			objects = append(objects, serviceAccount(ns, fmt.Sprintf("sa-%d", i)))
+		}
+	}
+	return objects
+}
+
+func TestConvergence(t *testing.T) {
+	testCases := map[string]struct {
+		opts    controller.SecretControllerOptions
+		objects []runtime.Object
+		// The disruption the controller must converge from.
+		disrupt func(h *Harness) error
+		timeout time.Duration
+	}{
+		"Service account churn": {
+			objects: serviceAccounts([]string{"default", "istio-system"}, 5),
+			disrupt: func(h *Harness) error {
+				core := h.Cluster.Client.CoreV1()
+				for i := 5; i < 25; i++ {
+					if _, err := core.ServiceAccounts("churn").Create(serviceAccount("churn", fmt.Sprintf("sa-%d", i))); err != nil {
+						return err
+					}
+					if i%2 == 0 {
+						if err := core.ServiceAccounts("churn").Delete(fmt.Sprintf("sa-%d", i), nil); err != nil {
+							return err
+						}
+					}
+				}
+				return core.ServiceAccounts("default").Delete("sa-0", nil)
+			},
+			timeout: convergenceTimeout,
+		},
+		"Secret deletion": {
+			objects: serviceAccounts([]string{"default", "foo"}, 5),
+			disrupt: func(h *Harness) error {
+				core := h.Cluster.Client.CoreV1()
+				for _, ns := range []string{"default", "foo"} {
+					for i := 0; i < 5; i++ {
+						if err := core.Secrets(ns).Delete(fmt.Sprintf("istio.sa-%d", i), nil); err != nil {
+							return err
+						}
+					}
+				}
+				return nil
+			},
+			timeout: convergenceTimeout,
+		},
+		"Root rotation": {
+			objects: serviceAccounts([]string{"default", "foo"}, 3),
+			disrupt: func(h *Harness) error {
+				return h.CA.RotateRoot()
+			},
+			timeout: resyncConvergenceTimeout,
+		},
+		"Root rotation with reused keys": {
+			opts:    controller.SecretControllerOptions{ReuseKeys: true},
+			objects: serviceAccounts([]string{"default"}, 3),
+			disrupt: func(h *Harness) error {
+				return h.CA.RotateRoot()
+			},
+			timeout: resyncConvergenceTimeout,
+		},
+	}
+
+	for k, tc := range testCases {
+		h, err := Start(tc.opts, tc.objects...)
+		if err != nil {
+			t.Fatalf("%s: failed to start the harness: %v", k, err)
+		}
+
+		if err := h.WaitForConvergence(convergenceTimeout); err != nil {
+			t.Errorf("%s: initial provisioning: %v", k, err)
+		} else if err := tc.disrupt(h); err != nil {
+			t.Errorf("%s: failed to disrupt the cluster: %v", k, err)
+		} else if err := h.WaitForConvergence(tc.timeout); err != nil {
+			t.Errorf("%s: after the disruption: %v", k, err)
+		}
+		h.Stop()
+	}
+}